@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Builds a forced rule for every key in `alerts.canaries.keys`
+// Events matching a canary are only sent to alert sinks, they are withheld from the output
+func createCanaryRules(config *viper.Viper) ([]*AlertRule, error) {
+	ks := config.Get("alerts.canaries.keys")
+	if ks == nil {
+		return nil, nil
+	}
+
+	kt, ok := ks.([]interface{})
+	if !ok {
+		return nil, errors.New("Could not parse alerts.canaries.keys object")
+	}
+
+	severity := config.GetString("alerts.canaries.severity")
+	if severity == "" {
+		severity = "critical"
+	}
+
+	level, ok := severityLevel(severity)
+	if !ok {
+		return nil, fmt.Errorf("alerts.canaries.severity must be one of %s; Value: `%s`", strings.Join(severities, ", "), severity)
+	}
+
+	rules := []*AlertRule{}
+	for i, k := range kt {
+		key, ok := k.(string)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("Canary key %d could not be parsed; Value: `%+v`", i+1, k)
+		}
+
+		rules = append(rules, &AlertRule{
+			name:     "canary:" + key,
+			severity: severity,
+			level:    level,
+			key:      key,
+			force:    true,
+			canary:   true,
+		})
+	}
+
+	l.Printf("Alerting with %s severity on %d canary keys\n", severity, len(rules))
+	return rules, nil
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createCanaryRules(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	r, err := createCanaryRules(c)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+
+	c.Set("alerts.canaries.keys", "nope")
+	_, err = createCanaryRules(c)
+	assert.EqualError(t, err, "Could not parse alerts.canaries.keys object")
+
+	c.Set("alerts.canaries.keys", []interface{}{""})
+	_, err = createCanaryRules(c)
+	assert.EqualError(t, err, "Canary key 1 could not be parsed; Value: ``")
+
+	c.Set("alerts.canaries.keys", []interface{}{"honeytoken"})
+	c.Set("alerts.canaries.severity", "nope")
+	_, err = createCanaryRules(c)
+	assert.EqualError(t, err, "alerts.canaries.severity must be one of info, low, medium, high, critical; Value: `nope`")
+
+	// All good
+	lb.Reset()
+	c.Set("alerts.canaries.severity", "")
+	c.Set("alerts.canaries.keys", []interface{}{"honeytoken", "tripwire"})
+	r, err = createCanaryRules(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []*AlertRule{
+		{name: "canary:honeytoken", severity: "critical", level: 4, key: "honeytoken", force: true, canary: true},
+		{name: "canary:tripwire", severity: "critical", level: 4, key: "tripwire", force: true, canary: true},
+	}, r)
+	assert.Equal(t, "Alerting with critical severity on 2 canary keys\n", lb.String())
+}
+
+func TestAlerter_canary(t *testing.T) {
+	a := &Alerter{rules: []*AlertRule{
+		{name: "execve", severity: "critical", level: 4, syscall: "59"},
+		{name: "canary:honeytoken", severity: "low", level: 1, key: "honeytoken", force: true, canary: true},
+	}}
+
+	msg := &AuditMessageGroup{Syscall: "59", Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 key="honeytoken"`}}}
+	a.Process(msg)
+	assert.Equal(t, &Alert{Rule: "canary:honeytoken", Severity: "low", level: 1, canary: true}, msg.Alert, "Canaries should win over higher severity rules")
+
+	// But not over tampering with the audit subsystem, which has to stay in the output
+	msg = &AuditMessageGroup{Syscall: "59", Tamper: &AuditTamper{Change: "rules_removed"}, Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 key="honeytoken"`}}}
+	a.Process(msg)
+	assert.Equal(t, &Alert{Rule: "audit_tamper", Severity: "critical", level: 4}, msg.Alert)
+}
+
+func TestAuditMarshaller_canary(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{
+		{messageType: 1300, regex: regexp.MustCompile("."), syscall: "2"},
+	}, nil)
+	sink := &recordingSink{}
+	m.alerter = &Alerter{
+		rules: []*AlertRule{{name: "canary:honeytoken", severity: "critical", level: 4, key: "honeytoken", force: true, canary: true}},
+		sinks: []namedAlertSink{{name: "test", sink: sink}},
+		queue: make(chan *AuditMessageGroup, 10),
+	}
+	m.alerter.wg.Add(1)
+	go m.alerter.dispatch()
+
+	subscribed := 0
+	m.subscribers = []func(*AuditMessageGroup){func(*AuditMessageGroup) { subscribed++ }}
+
+	// filtered but forced through to the sinks, never written or handed to subscribers like /events
+	m.Consume(context.Background(), newNlMsg(1300, `audit(10000001:1): syscall=2 key="honeytoken"`))
+	m.Consume(context.Background(), new1320("1"))
+	assert.Nil(t, m.alerter.Close())
+	assert.Empty(t, w.String())
+	assert.Len(t, sink.alerts, 1)
+	assert.Equal(t, 0, subscribed)
+}
@@ -0,0 +1,319 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultEmailSubject  = "[go-audit] {{.Alert.Severity}} alert {{.Alert.Rule}} on {{.Hostname}}"
+	defaultEmailTemplate = `Rule:      {{.Alert.Rule}}
+Severity:  {{.Alert.Severity}}
+Host:      {{.Hostname}}
+Sequence:  {{.Seq}}
+Time:      {{.Time}}
+Exe:       {{.Fields.exe}}
+Uid:       {{.Fields.uid}}
+{{range .Msgs}}
+type={{.Type}} {{.Data}}{{end}}
+`
+)
+
+// Default number of alerts a digest holds, later ones are only counted
+const defaultEmailDigestMax = 100
+
+func init() {
+	RegisterAlertSink("email", createEmailAlertSink)
+}
+
+// emailAlertSink sends alerts over smtp, either one email per alert or as a periodic digest
+type emailAlertSink struct {
+	addr      string
+	host      string
+	tlsMode   string
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+	from      string
+	to        []string
+	timeout   time.Duration
+	subject   *template.Template
+	body      *template.Template
+
+	// Digest mode, rendered alerts wait here until the next interval. Past max they are dropped and only counted
+	interval time.Duration
+	max      int
+	mu       sync.Mutex
+	pending  []renderedEmail
+	dropped  int
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type renderedEmail struct {
+	subject string
+	body    string
+}
+
+func createEmailAlertSink(config *viper.Viper) (AlertSink, error) {
+	e := &emailAlertSink{
+		host:     config.GetString("alerts.sinks.email.host"),
+		tlsMode:  config.GetString("alerts.sinks.email.tls"),
+		from:     config.GetString("alerts.sinks.email.from"),
+		to:       config.GetStringSlice("alerts.sinks.email.to"),
+		timeout:  config.GetDuration("alerts.sinks.email.timeout"),
+		interval: config.GetDuration("alerts.sinks.email.digest_interval"),
+		max:      config.GetInt("alerts.sinks.email.digest_max_alerts"),
+	}
+
+	if e.host == "" {
+		return nil, errors.New("alerts.sinks.email.host must be set")
+	}
+
+	if e.from == "" || len(e.to) == 0 {
+		return nil, errors.New("alerts.sinks.email.from and alerts.sinks.email.to must be set")
+	}
+
+	if e.tlsMode == "" {
+		e.tlsMode = "starttls"
+	}
+
+	port := config.GetInt("alerts.sinks.email.port")
+	switch e.tlsMode {
+	case "starttls", "none":
+		if port == 0 {
+			port = 25
+		}
+	case "tls":
+		if port == 0 {
+			port = 465
+		}
+	default:
+		return nil, fmt.Errorf("alerts.sinks.email.tls must be one of starttls, tls, none; Value: `%s`", e.tlsMode)
+	}
+	e.addr = net.JoinHostPort(e.host, strconv.Itoa(port))
+
+	e.tlsConfig = &tls.Config{ServerName: e.host}
+	if caFile := config.GetString("alerts.sinks.email.ca_file"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read alerts.sinks.email.ca_file. Error: %s", err)
+		}
+
+		e.tlsConfig.RootCAs = x509.NewCertPool()
+		if !e.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in alerts.sinks.email.ca_file %s", caFile)
+		}
+	}
+
+	if username := config.GetString("alerts.sinks.email.username"); username != "" {
+		e.auth = smtp.PlainAuth("", username, config.GetString("alerts.sinks.email.password"), e.host)
+	}
+
+	if e.timeout <= 0 {
+		e.timeout = time.Second * 30
+	}
+
+	if e.max <= 0 {
+		e.max = defaultEmailDigestMax
+	}
+
+	var err error
+	if e.subject, err = parseEmailTemplate(config, "subject", defaultEmailSubject); err != nil {
+		return nil, err
+	}
+
+	if e.body, err = parseEmailTemplate(config, "template", defaultEmailTemplate); err != nil {
+		return nil, err
+	}
+
+	if e.interval > 0 {
+		e.done = make(chan struct{})
+		e.wg.Add(1)
+		go e.digest()
+	}
+
+	return e, nil
+}
+
+func parseEmailTemplate(config *viper.Viper, name string, def string) (*template.Template, error) {
+	text := config.GetString("alerts.sinks.email." + name)
+	if text == "" {
+		text = def
+	}
+
+	tmpl, err := template.New("email").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse alerts.sinks.email.%s. Error: %s", name, err)
+	}
+
+	return tmpl, nil
+}
+
+func (e *emailAlertSink) SendAlert(ctx context.Context, msg *AuditMessageGroup) error {
+	data := newAlertTemplateData(msg)
+
+	body := &bytes.Buffer{}
+	if err := e.body.Execute(body, data); err != nil {
+		return fmt.Errorf("Failed to execute template. Error: %s", err)
+	}
+
+	subject := &bytes.Buffer{}
+	if err := e.subject.Execute(subject, data); err != nil {
+		return fmt.Errorf("Failed to execute subject template. Error: %s", err)
+	}
+
+	if e.interval > 0 {
+		e.mu.Lock()
+		if len(e.pending) < e.max {
+			e.pending = append(e.pending, renderedEmail{subject: subject.String(), body: body.String()})
+		} else {
+			e.dropped++
+		}
+		e.mu.Unlock()
+		return nil
+	}
+
+	return e.send(ctx, subject.String(), body.String())
+}
+
+// Sends everything that is pending as a single email every interval
+func (e *emailAlertSink) digest() {
+	defer e.wg.Done()
+
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-e.done:
+			e.flush()
+			return
+		}
+
+		e.flush()
+	}
+}
+
+// The subject of the first alert is used for the whole digest, with a count of the others
+func (e *emailAlertSink) flush() {
+	e.mu.Lock()
+	pending, dropped := e.pending, e.dropped
+	e.pending, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	bodies := make([]string, len(pending))
+	for i, p := range pending {
+		bodies[i] = strings.TrimRight(p.body, "\n")
+	}
+
+	if dropped > 0 {
+		bodies = append(bodies, fmt.Sprintf("%d more alerts were dropped, a digest holds at most %d", dropped, e.max))
+	}
+
+	subject := pending[0].subject
+	if others := len(pending) - 1 + dropped; others > 0 {
+		subject = fmt.Sprintf("%s (+%d more)", subject, others)
+	}
+
+	if err := e.send(context.Background(), subject, strings.Join(bodies, "\n----\n\n")+"\n"); err != nil {
+		el.Printf("Failed to send alert digest of %d alerts to email. Error: %s\n", len(pending)+dropped, err)
+	}
+}
+
+// Keeps templated values from ending the header or adding new ones
+var headerReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+func (e *emailAlertSink) send(ctx context.Context, subject string, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	d := &net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if e.tlsMode == "tls" {
+		conn = tls.Client(conn, e.tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.tlsMode == "starttls" {
+		if err := c.StartTLS(e.tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if e.auth != nil {
+		if err := c.Auth(e.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "From: %s\r\n", e.from)
+	fmt.Fprintf(w, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(w, "Subject: %s\r\n", headerReplacer.Replace(subject))
+	fmt.Fprintf(w, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(w, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprint(w, strings.Replace(body, "\n", "\r\n", -1))
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Close sends any pending digest
+func (e *emailAlertSink) Close() error {
+	if e.done != nil {
+		close(e.done)
+		e.wg.Wait()
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// Accepts smtp sessions and sends what was received for every DATA command on the returned channel
+func fakeSMTPServer(t *testing.T) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			r := bufio.NewReader(conn)
+			conn.Write([]byte("220 localhost\r\n"))
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+
+				switch strings.ToUpper(strings.SplitN(strings.TrimSpace(line), " ", 2)[0]) {
+				case "DATA":
+					conn.Write([]byte("354 go ahead\r\n"))
+					data := ""
+					for {
+						l, _ := r.ReadString('\n')
+						if l == ".\r\n" || l == "" {
+							break
+						}
+						data += l
+					}
+					mails <- data
+					conn.Write([]byte("250 ok\r\n"))
+				case "QUIT":
+					conn.Write([]byte("221 bye\r\n"))
+				default:
+					conn.Write([]byte("250 ok\r\n"))
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	return ln, mails
+}
+
+func Test_createEmailAlertSink(t *testing.T) {
+	c := viper.New()
+	s, err := createEmailAlertSink(c)
+	assert.EqualError(t, err, "alerts.sinks.email.host must be set")
+	assert.Nil(t, s)
+
+	c.Set("alerts.sinks.email.host", "mail.example.com")
+	s, err = createEmailAlertSink(c)
+	assert.EqualError(t, err, "alerts.sinks.email.from and alerts.sinks.email.to must be set")
+	assert.Nil(t, s)
+
+	c.Set("alerts.sinks.email.from", "go-audit@example.com")
+	c.Set("alerts.sinks.email.to", []string{"security@example.com"})
+	c.Set("alerts.sinks.email.tls", "maybe")
+	s, err = createEmailAlertSink(c)
+	assert.EqualError(t, err, "alerts.sinks.email.tls must be one of starttls, tls, none; Value: `maybe`")
+	assert.Nil(t, s)
+
+	c.Set("alerts.sinks.email.tls", "")
+	c.Set("alerts.sinks.email.subject", "{{")
+	s, err = createEmailAlertSink(c)
+	assert.EqualError(t, err, "Could not parse alerts.sinks.email.subject. Error: template: email:1: unclosed action")
+	assert.Nil(t, s)
+
+	c.Set("alerts.sinks.email.subject", "")
+	c.Set("alerts.sinks.email.ca_file", "/tmp/go-audit-does-not-exist")
+	s, err = createEmailAlertSink(c)
+	assert.EqualError(t, err, "Could not read alerts.sinks.email.ca_file. Error: open /tmp/go-audit-does-not-exist: no such file or directory")
+	assert.Nil(t, s)
+
+	// defaults
+	c.Set("alerts.sinks.email.ca_file", "")
+	s, err = createEmailAlertSink(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "mail.example.com:25", s.(*emailAlertSink).addr)
+	assert.Equal(t, "starttls", s.(*emailAlertSink).tlsMode)
+	assert.Nil(t, s.(*emailAlertSink).auth)
+
+	c.Set("alerts.sinks.email.tls", "tls")
+	c.Set("alerts.sinks.email.username", "user")
+	s, err = createEmailAlertSink(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "mail.example.com:465", s.(*emailAlertSink).addr)
+	assert.NotNil(t, s.(*emailAlertSink).auth)
+}
+
+func TestEmailAlertSink_SendAlert(t *testing.T) {
+	ln, mails := fakeSMTPServer(t)
+	defer ln.Close()
+
+	defer func(h string) { hostname = h }(hostname)
+	hostname = "test-host"
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	c := viper.New()
+	c.Set("alerts.sinks.email.host", host)
+	c.Set("alerts.sinks.email.port", port)
+	c.Set("alerts.sinks.email.tls", "none")
+	c.Set("alerts.sinks.email.from", "go-audit@example.com")
+	c.Set("alerts.sinks.email.to", []string{"a@example.com", "b@example.com"})
+	c.Set("alerts.sinks.email.template", "{{.Alert.Rule}} ran {{.Fields.exe}}\n")
+	s, err := createEmailAlertSink(c)
+	assert.Nil(t, err)
+
+	msg := &AuditMessageGroup{
+		Seq:   10,
+		Alert: &Alert{Rule: "curl", Severity: "high"},
+		Msgs:  []*AuditMessage{{Type: 1300, Data: `syscall=59 uid=33 exe="/usr/bin/curl"`}},
+	}
+
+	assert.Nil(t, s.SendAlert(context.Background(), msg))
+	mail := <-mails
+	assert.Contains(t, mail, "From: go-audit@example.com\r\n")
+	assert.Contains(t, mail, "To: a@example.com, b@example.com\r\n")
+	assert.Contains(t, mail, "Subject: [go-audit] high alert curl on test-host\r\n")
+	assert.True(t, strings.HasSuffix(mail, "\r\n\r\ncurl ran /usr/bin/curl\r\n"), mail)
+	assert.Nil(t, s.Close())
+}
+
+func TestEmailAlertSink_digest(t *testing.T) {
+	ln, mails := fakeSMTPServer(t)
+	defer ln.Close()
+
+	defer func(h string) { hostname = h }(hostname)
+	hostname = "test-host"
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	c := viper.New()
+	c.Set("alerts.sinks.email.host", host)
+	c.Set("alerts.sinks.email.port", port)
+	c.Set("alerts.sinks.email.tls", "none")
+	c.Set("alerts.sinks.email.from", "go-audit@example.com")
+	c.Set("alerts.sinks.email.to", []string{"a@example.com"})
+	c.Set("alerts.sinks.email.template", "{{.Alert.Rule}} {{.Seq}}\n")
+	c.Set("alerts.sinks.email.digest_interval", time.Hour)
+	s, err := createEmailAlertSink(c)
+	assert.Nil(t, err)
+
+	for i := 1; i <= 2; i++ {
+		assert.Nil(t, s.SendAlert(context.Background(), &AuditMessageGroup{
+			Seq:   i,
+			Alert: &Alert{Rule: "curl", Severity: "high"},
+		}))
+	}
+
+	select {
+	case <-mails:
+		t.Fatal("Nothing should be sent before the digest interval")
+	default:
+	}
+
+	// Close sends whatever is pending
+	assert.Nil(t, s.Close())
+	mail := <-mails
+	assert.Contains(t, mail, "Subject: [go-audit] high alert curl on test-host (+1 more)\r\n")
+	assert.True(t, strings.HasSuffix(mail, "\r\n\r\ncurl 1\r\n----\r\n\r\ncurl 2\r\n"), mail)
+}
+
+func TestEmailAlertSink_digestLimit(t *testing.T) {
+	ln, mails := fakeSMTPServer(t)
+	defer ln.Close()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	c := viper.New()
+	c.Set("alerts.sinks.email.host", host)
+	c.Set("alerts.sinks.email.port", port)
+	c.Set("alerts.sinks.email.tls", "none")
+	c.Set("alerts.sinks.email.from", "go-audit@example.com")
+	c.Set("alerts.sinks.email.to", []string{"a@example.com"})
+	c.Set("alerts.sinks.email.subject", "{{.Alert.Rule}}\r\nBcc: evil@example.com")
+	c.Set("alerts.sinks.email.template", "{{.Alert.Rule}} {{.Seq}}\n")
+	c.Set("alerts.sinks.email.digest_interval", time.Hour)
+	c.Set("alerts.sinks.email.digest_max_alerts", 2)
+	s, err := createEmailAlertSink(c)
+	assert.Nil(t, err)
+
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, s.SendAlert(context.Background(), &AuditMessageGroup{
+			Seq:   i,
+			Alert: &Alert{Rule: "curl", Severity: "high"},
+		}))
+	}
+	assert.Len(t, s.(*emailAlertSink).pending, 2)
+
+	// Dropped alerts are counted, line breaks can't sneak headers in through the subject
+	assert.Nil(t, s.Close())
+	mail := <-mails
+	assert.Contains(t, mail, "Subject: curl Bcc: evil@example.com (+4 more)\r\n")
+	assert.NotContains(t, mail, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(mail, "\r\n\r\ncurl 1\r\n----\r\n\r\ncurl 2\r\n----\r\n\r\n3 more alerts were dropped, a digest holds at most 2\r\n"), mail)
+}
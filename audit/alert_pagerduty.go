@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+func init() {
+	RegisterAlertSink("pagerduty", createPagerDutyAlertSink)
+}
+
+// Maps our severities to the ones the PagerDuty events api accepts
+var pagerDutySeverities = map[string]string{
+	"info":     "info",
+	"low":      "warning",
+	"medium":   "warning",
+	"high":     "error",
+	"critical": "critical",
+}
+
+// pagerDutyAlertSink triggers PagerDuty incidents through the v2 events api
+type pagerDutyAlertSink struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component"`
+	CustomDetails map[string]string `json:"custom_details"`
+}
+
+func createPagerDutyAlertSink(config *viper.Viper) (AlertSink, error) {
+	routingKey := config.GetString("alerts.sinks.pagerduty.routing_key")
+	if routingKey == "" {
+		return nil, errors.New("alerts.sinks.pagerduty.routing_key must be set")
+	}
+
+	url := config.GetString("alerts.sinks.pagerduty.url")
+	if url == "" {
+		url = pagerDutyEventsURL
+	}
+
+	timeout := config.GetDuration("alerts.sinks.pagerduty.timeout")
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+
+	return &pagerDutyAlertSink{
+		url:        url,
+		routingKey: routingKey,
+		client:     &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (p *pagerDutyAlertSink) SendAlert(ctx context.Context, msg *AuditMessageGroup) error {
+	data := newAlertTemplateData(msg)
+
+	details := map[string]string{
+		"sequence":  fmt.Sprint(msg.Seq),
+		"timestamp": msg.AuditTime,
+		"rule":      msg.Alert.Rule,
+	}
+
+	for _, k := range []string{"syscall", "exe", "comm", "uid", "auid", "key"} {
+		if v, ok := data.Fields[k]; ok {
+			details[k] = v
+		}
+	}
+
+	for k, v := range msg.Alert.Tags {
+		details["tag."+k] = v
+	}
+
+	event := &pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: "trigger",
+		// Repeated alerts for the same rule on the same host are grouped into one incident
+		DedupKey: "go-audit:" + data.Hostname + ":" + msg.Alert.Rule,
+		Payload: pagerDutyPayload{
+			Summary:       fmt.Sprintf("go-audit alert %s on %s", msg.Alert.Rule, data.Hostname),
+			Source:        data.Hostname,
+			Timestamp:     data.Time,
+			Severity:      pagerDutySeverities[msg.Alert.Severity],
+			Component:     "go-audit",
+			CustomDetails: details,
+		},
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, p.client, p.url, nil, body)
+}
+
+func (p *pagerDutyAlertSink) Close() error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createPagerDutyAlertSink(t *testing.T) {
+	// missing routing key
+	c := viper.New()
+	s, err := createPagerDutyAlertSink(c)
+	assert.EqualError(t, err, "alerts.sinks.pagerduty.routing_key must be set")
+	assert.Nil(t, s)
+
+	// defaults
+	c.Set("alerts.sinks.pagerduty.routing_key", "abc")
+	s, err = createPagerDutyAlertSink(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://events.pagerduty.com/v2/enqueue", s.(*pagerDutyAlertSink).url)
+	assert.Equal(t, "abc", s.(*pagerDutyAlertSink).routingKey)
+}
+
+func TestPagerDutyAlertSink_SendAlert(t *testing.T) {
+	body := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	defer func(h string) { hostname = h }(hostname)
+	hostname = "test-host"
+
+	c := viper.New()
+	c.Set("alerts.sinks.pagerduty.routing_key", "abc")
+	c.Set("alerts.sinks.pagerduty.url", ts.URL)
+	s, err := createPagerDutyAlertSink(c)
+	assert.Nil(t, err)
+
+	err = s.SendAlert(context.Background(), &AuditMessageGroup{
+		Seq:       10,
+		AuditTime: "10000001.123",
+		Alert:     &Alert{Rule: "audit_rules_removed", Severity: "critical", Tags: map[string]string{"team": "secops"}},
+		Msgs:      []*AuditMessage{{Type: 1300, Data: `syscall=44 uid=0 auid=1000 exe="/sbin/auditctl" key=(null)`}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		`{"routing_key":"abc","event_action":"trigger","dedup_key":"go-audit:test-host:audit_rules_removed",`+
+			`"payload":{"summary":"go-audit alert audit_rules_removed on test-host","source":"test-host","timestamp":"1970-04-26T17:46:41.123Z","severity":"critical","component":"go-audit",`+
+			`"custom_details":{"auid":"1000","exe":"/sbin/auditctl","key":"(null)","rule":"audit_rules_removed","sequence":"10","syscall":"44","tag.team":"secops","timestamp":"10000001.123","uid":"0"}}}`,
+		body,
+	)
+	assert.Nil(t, s.Close())
+}
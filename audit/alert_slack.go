@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultSlackTemplate = "[{{.Alert.Severity}}] {{.Alert.Rule}} on {{.Hostname}}: {{.Fields.exe}} (uid {{.Fields.uid}}, sequence {{.Seq}})"
+
+func init() {
+	RegisterAlertSink("slack", createSlackAlertSink)
+}
+
+// rateLimiter is a simple token bucket
+type rateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	max      float64
+	perSec   float64
+	last     time.Time
+	dropped  int
+	disabled bool
+}
+
+// Allows up to perMinute events per minute with bursts of the same size, 0 or less disables limiting
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		tokens:   float64(perMinute),
+		max:      float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		last:     time.Now(),
+		disabled: perMinute <= 0,
+	}
+}
+
+// Takes a token if there is one, returns how many events were dropped since the last allowed one
+func (r *rateLimiter) allow() (bool, int) {
+	if r.disabled {
+		return true, 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.perSec
+	if r.tokens > r.max {
+		r.tokens = r.max
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		r.dropped++
+		return false, 0
+	}
+
+	r.tokens--
+	dropped := r.dropped
+	r.dropped = 0
+	return true, dropped
+}
+
+// slackAlertSink posts alerts to a slack incoming webhook
+type slackAlertSink struct {
+	url      string
+	template *template.Template
+	limiter  *rateLimiter
+	client   *http.Client
+}
+
+func createSlackAlertSink(config *viper.Viper) (AlertSink, error) {
+	url := config.GetString("alerts.sinks.slack.webhook_url")
+	if url == "" {
+		return nil, errors.New("alerts.sinks.slack.webhook_url must be set")
+	}
+
+	text := config.GetString("alerts.sinks.slack.template")
+	if text == "" {
+		text = defaultSlackTemplate
+	}
+
+	tmpl, err := template.New("slack").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse alerts.sinks.slack.template. Error: %s", err)
+	}
+
+	timeout := config.GetDuration("alerts.sinks.slack.timeout")
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+
+	rateLimit := 30
+	if config.IsSet("alerts.sinks.slack.rate_limit") {
+		rateLimit = config.GetInt("alerts.sinks.slack.rate_limit")
+	}
+
+	return &slackAlertSink{
+		url:      url,
+		template: tmpl,
+		limiter:  newRateLimiter(rateLimit),
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *slackAlertSink) SendAlert(ctx context.Context, msg *AuditMessageGroup) error {
+	ok, dropped := s.limiter.allow()
+	if !ok {
+		return nil
+	}
+
+	text := &bytes.Buffer{}
+	if err := s.template.Execute(text, newAlertTemplateData(msg)); err != nil {
+		return fmt.Errorf("Failed to execute template. Error: %s", err)
+	}
+
+	if dropped > 0 {
+		fmt.Fprintf(text, " (%d alerts suppressed by rate limiting)", dropped)
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text.String()})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, s.client, s.url, nil, body)
+}
+
+func (s *slackAlertSink) Close() error {
+	return nil
+}
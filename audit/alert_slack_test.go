@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createSlackAlertSink(t *testing.T) {
+	// missing url
+	c := viper.New()
+	s, err := createSlackAlertSink(c)
+	assert.EqualError(t, err, "alerts.sinks.slack.webhook_url must be set")
+	assert.Nil(t, s)
+
+	// bad template
+	c.Set("alerts.sinks.slack.webhook_url", "http://localhost")
+	c.Set("alerts.sinks.slack.template", "{{")
+	s, err = createSlackAlertSink(c)
+	assert.EqualError(t, err, "Could not parse alerts.sinks.slack.template. Error: template: slack:1: unclosed action")
+	assert.Nil(t, s)
+
+	// defaults
+	c.Set("alerts.sinks.slack.template", "")
+	s, err = createSlackAlertSink(c)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(30), s.(*slackAlertSink).limiter.max)
+	assert.False(t, s.(*slackAlertSink).limiter.disabled)
+}
+
+func TestSlackAlertSink_SendAlert(t *testing.T) {
+	bodies := []string{}
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(status)
+		w.Write([]byte("no_text\n"))
+	}))
+	defer ts.Close()
+
+	c := viper.New()
+	c.Set("alerts.sinks.slack.webhook_url", ts.URL)
+	c.Set("alerts.sinks.slack.rate_limit", 1)
+	s, err := createSlackAlertSink(c)
+	assert.Nil(t, err)
+
+	defer func(h string) { hostname = h }(hostname)
+	hostname = "test-host"
+	msg := &AuditMessageGroup{
+		Seq:   10,
+		Alert: &Alert{Rule: "curl", Severity: "high"},
+		Msgs:  []*AuditMessage{{Type: 1300, Data: `syscall=59 uid=33 exe="/usr/bin/curl"`}},
+	}
+
+	assert.Nil(t, s.SendAlert(context.Background(), msg))
+	assert.Equal(t, []string{`{"text":"[high] curl on test-host: /usr/bin/curl (uid 33, sequence 10)"}`}, bodies)
+
+	// rate limited alerts are counted in the next message
+	assert.Nil(t, s.SendAlert(context.Background(), msg))
+	assert.Len(t, bodies, 1)
+	s.(*slackAlertSink).limiter.tokens = 1
+	s.(*slackAlertSink).template, _ = s.(*slackAlertSink).template.Parse("{{.Alert.Rule}}")
+	assert.Nil(t, s.SendAlert(context.Background(), msg))
+	assert.Equal(t, `{"text":"curl (1 alerts suppressed by rate limiting)"}`, bodies[1])
+
+	// non 2xx responses
+	status = http.StatusBadRequest
+	s.(*slackAlertSink).limiter = newRateLimiter(0)
+	assert.EqualError(t, s.SendAlert(context.Background(), msg), "Unexpected response 400 Bad Request: no_text")
+	assert.Nil(t, s.Close())
+}
+
+func Test_rateLimiter(t *testing.T) {
+	r := newRateLimiter(2)
+	ok, _ := r.allow()
+	assert.True(t, ok)
+	ok, _ = r.allow()
+	assert.True(t, ok)
+	ok, _ = r.allow()
+	assert.False(t, ok, "Burst should be limited to the per minute rate")
+	assert.Equal(t, 1, r.dropped)
+
+	// disabled
+	r = newRateLimiter(0)
+	for i := 0; i < 100; i++ {
+		ok, _ = r.allow()
+		assert.True(t, ok)
+	}
+}
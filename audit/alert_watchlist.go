@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Syscalls that modify a path or its attributes, by name so they match on every arch
+var pathWriteSyscalls = map[string]bool{
+	"truncate": true, "rename": true, "mkdir": true, "rmdir": true, "creat": true, "link": true, "unlink": true, "symlink": true,
+	"chmod": true, "chown": true, "lchown": true, "utime": true, "mknod": true, "setxattr": true, "lsetxattr": true,
+	"removexattr": true, "lremovexattr": true, "utimes": true, "mkdirat": true, "mknodat": true, "fchownat": true, "futimesat": true,
+	"unlinkat": true, "renameat": true, "linkat": true, "symlinkat": true, "fchmodat": true, "utimensat": true, "renameat2": true,
+}
+
+// O_WRONLY | O_RDWR | O_CREAT | O_TRUNC
+const openWriteFlags = 0x1 | 0x2 | 0x40 | 0x200
+
+// Returns the paths from PATH records that the syscall wrote to or changed the attributes of
+// Relative paths are resolved against the CWD record, parent directory records are skipped
+func (amg *AuditMessageGroup) writtenPaths() []string {
+	data, ok := amg.firstMessage(1300)
+	if !ok {
+		return nil
+	}
+
+	fields := parseFields(data)
+	name := syscallName(fields["arch"], fields["syscall"])
+	write := pathWriteSyscalls[name]
+
+	// open and openat only write if the flags say so
+	flags := ""
+	switch name {
+	case "open":
+		flags = fields["a1"]
+	case "openat":
+		flags = fields["a2"]
+	}
+
+	if flags != "" {
+		f, err := strconv.ParseUint(flags, 16, 64)
+		write = err == nil && f&openWriteFlags != 0
+	}
+
+	cwd, _ := amg.findField(1307, "cwd")
+	paths := []string{}
+
+	for _, msg := range amg.Msgs {
+		if msg.Type != 1302 {
+			continue
+		}
+
+		pf := parseFields(msg.Data)
+		nametype := pf["nametype"]
+		if nametype == "PARENT" || (!write && nametype != "CREATE" && nametype != "DELETE") {
+			continue
+		}
+
+		name := pf["name"]
+		if name == "" || name == "(null)" {
+			continue
+		}
+
+		if !path.IsAbs(name) && cwd != "" {
+			name = path.Join(cwd, name)
+		}
+
+		paths = append(paths, name)
+	}
+
+	return paths
+}
+
+// Checks written paths against the rule's path globs
+func (r *AlertRule) matchesPaths(msg *AuditMessageGroup) bool {
+	for _, p := range msg.writtenPaths() {
+		for _, glob := range r.paths {
+			if ok, _ := path.Match(glob, p); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Builds the `file_watchlist` rule from `alerts.watchlist`, nil is returned if no paths are configured
+// The rule is forced, events matching it are never dropped by filters
+func createWatchlistRule(config *viper.Viper) (*AlertRule, error) {
+	ps := config.Get("alerts.watchlist.paths")
+	if ps == nil {
+		return nil, nil
+	}
+
+	pt, ok := ps.([]interface{})
+	if !ok {
+		return nil, errors.New("Could not parse alerts.watchlist.paths object")
+	}
+
+	r := &AlertRule{name: "file_watchlist", severity: "high", force: true}
+	if s := config.GetString("alerts.watchlist.severity"); s != "" {
+		r.severity = s
+	}
+
+	if r.level, ok = severityLevel(r.severity); !ok {
+		return nil, fmt.Errorf("alerts.watchlist.severity must be one of %s; Value: `%s`", strings.Join(severities, ", "), r.severity)
+	}
+
+	for i, p := range pt {
+		glob, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("Watchlist path %d could not be parsed; Value: `%+v`", i+1, p)
+		}
+
+		// ~ means any home directory
+		globs := []string{glob}
+		if strings.HasPrefix(glob, "~/") {
+			globs = []string{"/root" + glob[1:], "/home/*" + glob[1:]}
+		}
+
+		for _, g := range globs {
+			if _, err := path.Match(g, ""); err != nil {
+				return nil, fmt.Errorf("Watchlist path %d could not be parsed; Value: `%s`; Error: %s", i+1, glob, err)
+			}
+			r.paths = append(r.paths, g)
+		}
+	}
+
+	l.Printf("Alerting with %s severity on writes to %d watchlist paths\n", r.severity, len(pt))
+	return r, nil
+}
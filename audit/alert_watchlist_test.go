@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMessageGroup_writtenPaths(t *testing.T) {
+	group := func(syscall string, msgs ...string) *AuditMessageGroup {
+		amg := &AuditMessageGroup{Syscall: syscall, Msgs: []*AuditMessage{{Type: 1300, Data: syscall}}}
+		for _, m := range msgs {
+			amg.Msgs = append(amg.Msgs, &AuditMessage{Type: 1302, Data: m})
+		}
+		amg.Msgs = append(amg.Msgs, &AuditMessage{Type: 1307, Data: `cwd="/home/bob"`})
+		return amg
+	}
+
+	// openat for reading
+	amg := group("arch=c000003e syscall=257 a1=7ffc a2=0", `item=0 name="/etc/shadow" nametype=NORMAL`)
+	assert.Empty(t, amg.writtenPaths())
+
+	// openat with O_WRONLY|O_TRUNC
+	amg = group("arch=c000003e syscall=257 a1=7ffc a2=201", `item=0 name="/etc/shadow" nametype=NORMAL`)
+	assert.Equal(t, []string{"/etc/shadow"}, amg.writtenPaths())
+
+	// open with O_RDWR
+	amg = group("arch=c000003e syscall=2 a1=2", `item=0 name=".ssh/authorized_keys" nametype=NORMAL`)
+	assert.Equal(t, []string{"/home/bob/.ssh/authorized_keys"}, amg.writtenPaths(), "Relative paths should be resolved against the cwd")
+
+	// rename skips the parents
+	amg = group(
+		"arch=c000003e syscall=82",
+		`item=0 name="/etc/" nametype=PARENT`,
+		`item=1 name="/etc/" nametype=PARENT`,
+		`item=2 name="/etc/passwd.new" nametype=DELETE`,
+		`item=3 name="/etc/passwd" nametype=CREATE`,
+	)
+	assert.Equal(t, []string{"/etc/passwd.new", "/etc/passwd"}, amg.writtenPaths())
+
+	// chmod
+	amg = group("arch=c000003e syscall=90", `item=0 name="/etc/crontab" nametype=NORMAL`)
+	assert.Equal(t, []string{"/etc/crontab"}, amg.writtenPaths())
+
+	// Syscalls are looked up for the arch, openat and fchmodat on aarch64
+	amg = group("arch=c00000b7 syscall=56 a1=7ffc a2=201", `item=0 name="/etc/shadow" nametype=NORMAL`)
+	assert.Equal(t, []string{"/etc/shadow"}, amg.writtenPaths())
+	amg = group("arch=c00000b7 syscall=53", `item=0 name="/etc/crontab" nametype=NORMAL`)
+	assert.Equal(t, []string{"/etc/crontab"}, amg.writtenPaths())
+	amg = group("arch=c00000b7 syscall=90", `item=0 name="/etc/crontab" nametype=NORMAL`)
+	assert.Empty(t, amg.writtenPaths(), "90 is capget on aarch64")
+
+	// no SYSCALL
+	assert.Nil(t, (&AuditMessageGroup{}).writtenPaths())
+}
+
+func Test_createWatchlistRule(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	r, err := createWatchlistRule(c)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+
+	c.Set("alerts.watchlist.paths", "nope")
+	_, err = createWatchlistRule(c)
+	assert.EqualError(t, err, "Could not parse alerts.watchlist.paths object")
+
+	c.Set("alerts.watchlist.paths", []interface{}{1})
+	_, err = createWatchlistRule(c)
+	assert.EqualError(t, err, "Watchlist path 1 could not be parsed; Value: `1`")
+
+	c.Set("alerts.watchlist.paths", []interface{}{"/etc/["})
+	_, err = createWatchlistRule(c)
+	assert.EqualError(t, err, "Watchlist path 1 could not be parsed; Value: `/etc/[`; Error: syntax error in pattern")
+
+	c.Set("alerts.watchlist.paths", []interface{}{"/etc/shadow"})
+	c.Set("alerts.watchlist.severity", "nope")
+	_, err = createWatchlistRule(c)
+	assert.EqualError(t, err, "alerts.watchlist.severity must be one of info, low, medium, high, critical; Value: `nope`")
+
+	// All good
+	lb.Reset()
+	c.Set("alerts.watchlist.severity", "")
+	c.Set("alerts.watchlist.paths", []interface{}{"/etc/shadow", "~/.ssh/authorized_keys", "/etc/cron*"})
+	r, err = createWatchlistRule(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/etc/shadow", "/root/.ssh/authorized_keys", "/home/*/.ssh/authorized_keys", "/etc/cron*"}, r.paths)
+	assert.Equal(t, "file_watchlist", r.name)
+	assert.Equal(t, "high", r.severity)
+	assert.Equal(t, 3, r.level)
+	assert.True(t, r.force)
+	assert.Equal(t, "Alerting with high severity on writes to 3 watchlist paths\n", lb.String())
+
+	assert.True(t, r.matches(&AuditMessageGroup{Msgs: []*AuditMessage{
+		{Type: 1300, Data: "arch=c000003e syscall=82"},
+		{Type: 1302, Data: `item=1 name="/home/bob/.ssh/authorized_keys" nametype=CREATE`},
+	}}))
+	assert.False(t, r.matches(&AuditMessageGroup{Msgs: []*AuditMessage{
+		{Type: 1300, Data: "arch=c000003e syscall=82"},
+		{Type: 1302, Data: `item=1 name="/home/bob/.ssh/known_hosts" nametype=CREATE`},
+	}}))
+}
+
+func TestAuditMarshaller_forcedAlerts(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{
+		{messageType: 1300, regex: regexp.MustCompile("."), syscall: "90"},
+	}, nil)
+	m.alerter = &Alerter{rules: []*AlertRule{{name: "file_watchlist", severity: "high", level: 3, force: true, paths: []string{"/etc/shadow"}}}}
+
+	// filtered
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): arch=c000003e syscall=90"))
+	m.Consume(context.Background(), newNlMsg(1302, `audit(10000001:1): item=0 name="/etc/passwd"`))
+	m.Consume(context.Background(), new1320("1"))
+	assert.Empty(t, w.String())
+
+	// forced through
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:2): arch=c000003e syscall=90"))
+	m.Consume(context.Background(), newNlMsg(1302, `audit(10000001:2): item=0 name="/etc/shadow"`))
+	m.Consume(context.Background(), new1320("2"))
+	assert.Contains(t, w.String(), `"sequence":2,`)
+	assert.Contains(t, w.String(), `"alert":{"rule":"file_watchlist","severity":"high"}`)
+}
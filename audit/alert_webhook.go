@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultWebhookTemplate = `{"rule":{{json .Alert.Rule}},"severity":{{json .Alert.Severity}},"host":{{json .Hostname}},"event":{{.Event}}}`
+
+// Functions available to webhook templates, json encodes a value so it can be placed in the body safely
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func init() {
+	RegisterAlertSink("webhook", createWebhookAlertSink)
+}
+
+// webhookAlertSink posts alerts to any http endpoint, the url, headers and body are all templates
+type webhookAlertSink struct {
+	url     *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+	limiter *rateLimiter
+	client  *http.Client
+}
+
+func createWebhookAlertSink(config *viper.Viper) (AlertSink, error) {
+	url := config.GetString("alerts.sinks.webhook.url")
+	if url == "" {
+		return nil, errors.New("alerts.sinks.webhook.url must be set")
+	}
+
+	w := &webhookAlertSink{headers: map[string]*template.Template{}}
+
+	var err error
+	if w.url, err = parseWebhookTemplate("url", url); err != nil {
+		return nil, err
+	}
+
+	body := config.GetString("alerts.sinks.webhook.template")
+	if body == "" {
+		body = defaultWebhookTemplate
+	}
+
+	if w.body, err = parseWebhookTemplate("template", body); err != nil {
+		return nil, err
+	}
+
+	for k, v := range config.GetStringMapString("alerts.sinks.webhook.headers") {
+		if w.headers[k], err = parseWebhookTemplate("headers."+k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := config.GetDuration("alerts.sinks.webhook.timeout")
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+	w.client = &http.Client{Timeout: timeout}
+
+	// Unlimited unless asked for, unlike chat there is usually a machine on the other end
+	w.limiter = newRateLimiter(config.GetInt("alerts.sinks.webhook.rate_limit"))
+
+	return w, nil
+}
+
+func parseWebhookTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse alerts.sinks.webhook.%s. Error: %s", name, err)
+	}
+
+	return tmpl, nil
+}
+
+func (w *webhookAlertSink) SendAlert(ctx context.Context, msg *AuditMessageGroup) error {
+	if ok, _ := w.limiter.allow(); !ok {
+		return nil
+	}
+
+	data := newAlertTemplateData(msg)
+
+	url := &bytes.Buffer{}
+	if err := w.url.Execute(url, data); err != nil {
+		return fmt.Errorf("Failed to execute url template. Error: %s", err)
+	}
+
+	body := &bytes.Buffer{}
+	if err := w.body.Execute(body, data); err != nil {
+		return fmt.Errorf("Failed to execute template. Error: %s", err)
+	}
+
+	if !json.Valid(body.Bytes()) {
+		return fmt.Errorf("Template did not produce valid json: %s", body)
+	}
+
+	headers := make(map[string]string, len(w.headers))
+	for k, tmpl := range w.headers {
+		v := &bytes.Buffer{}
+		if err := tmpl.Execute(v, data); err != nil {
+			return fmt.Errorf("Failed to execute template for header %s. Error: %s", k, err)
+		}
+		headers[k] = v.String()
+	}
+
+	return postJSON(ctx, w.client, url.String(), headers, body.Bytes())
+}
+
+func (w *webhookAlertSink) Close() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createWebhookAlertSink(t *testing.T) {
+	c := viper.New()
+	s, err := createWebhookAlertSink(c)
+	assert.EqualError(t, err, "alerts.sinks.webhook.url must be set")
+	assert.Nil(t, s)
+
+	c.Set("alerts.sinks.webhook.url", "http://localhost/{{")
+	s, err = createWebhookAlertSink(c)
+	assert.EqualError(t, err, "Could not parse alerts.sinks.webhook.url. Error: template: webhook:1: unclosed action")
+	assert.Nil(t, s)
+
+	c.Set("alerts.sinks.webhook.url", "http://localhost")
+	c.Set("alerts.sinks.webhook.headers", map[string]string{"authorization": "{{"})
+	s, err = createWebhookAlertSink(c)
+	assert.EqualError(t, err, "Could not parse alerts.sinks.webhook.headers.authorization. Error: template: webhook:1: unclosed action")
+	assert.Nil(t, s)
+
+	// defaults
+	c.Set("alerts.sinks.webhook.headers", map[string]string{})
+	s, err = createWebhookAlertSink(c)
+	assert.Nil(t, err)
+	assert.True(t, s.(*webhookAlertSink).limiter.disabled)
+}
+
+func TestWebhookAlertSink_SendAlert(t *testing.T) {
+	var req *http.Request
+	body := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer ts.Close()
+
+	defer func(h string) { hostname = h }(hostname)
+	hostname = "test-host"
+
+	msg := &AuditMessageGroup{
+		Seq:       10,
+		AuditTime: "10000001.123",
+		Alert:     &Alert{Rule: "curl", Severity: "high"},
+		Msgs:      []*AuditMessage{{Type: 1300, Data: `syscall=59 uid=33 exe="/usr/bin/"curl"`}},
+	}
+
+	// default body
+	c := viper.New()
+	c.Set("alerts.sinks.webhook.url", ts.URL+"/{{.Alert.Severity}}")
+	c.Set("alerts.sinks.webhook.headers", map[string]string{"x-host": "{{.Hostname}}"})
+	s, err := createWebhookAlertSink(c)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.SendAlert(context.Background(), msg))
+	assert.Equal(t, "/high", req.URL.Path)
+	assert.Equal(t, "test-host", req.Header.Get("X-Host"))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(
+		t,
+		`{"rule":"curl","severity":"high","host":"test-host","event":{"schema_version":2,"sequence":10,"timestamp":"10000001.123","messages":[{"type":1300,"data":"syscall=59 uid=33 exe=\"/usr/bin/\"curl\""}],"uid_map":null,"alert":{"rule":"curl","severity":"high"}}}`,
+		body,
+	)
+
+	// custom body, json quotes and escapes values
+	c.Set("alerts.sinks.webhook.template", `{"summary":{{json .Fields.exe}}}`)
+	s, err = createWebhookAlertSink(c)
+	assert.Nil(t, err)
+	assert.Nil(t, s.SendAlert(context.Background(), msg))
+	assert.Equal(t, `{"summary":"/usr/bin/"}`, body)
+
+	// invalid json
+	c.Set("alerts.sinks.webhook.template", `{"summary":{{.Fields.exe}}}`)
+	s, err = createWebhookAlertSink(c)
+	assert.Nil(t, err)
+	assert.EqualError(t, s.SendAlert(context.Background(), msg), "Template did not produce valid json: {\"summary\":/usr/bin/}")
+	assert.Nil(t, s.Close())
+}
@@ -0,0 +1,713 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Alert severities, in ascending order
+var severities = []string{"info", "low", "medium", "high", "critical"}
+
+// Converts a severity name into something comparable
+func severityLevel(severity string) (int, bool) {
+	for i, s := range severities {
+		if s == severity {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Alert is attached to message groups that matched an alert rule
+type Alert struct {
+	Rule     string            `json:"rule"`
+	Severity string            `json:"severity"`
+	Tags     map[string]string `json:"tags,omitempty"` // From the rule, for routing alerts to whoever owns it
+	level    int
+	canary   bool
+}
+
+// Default number of group_by values a stateful rule keeps track of
+const defaultMaxTracked = 10000
+
+// AlertRule matches message groups on their parsed fields, every condition that is set must match
+type AlertRule struct {
+	name     string
+	severity string
+	level    int
+	syscall  string
+	sysName  string // syscall given by name, like execve, matched against syscall_name so it works on every arch
+	msgType  uint16 // Field conditions are checked against this message type, SYSCALL if unset
+	exe      string // A glob, see path.Match
+	key      string
+	uid      string
+	fields   map[string]string
+	dstNet   *net.IPNet
+	paths    []string // Globs matched against written paths, see writtenPaths
+	tags     map[string]string
+
+	// Forced rules let matching message groups through filters, canary rules also keep them out of the output
+	force  bool
+	canary bool
+
+	// Stateful rules, either fire after threshold matches or when followedBy matches after this rule did
+	// Both are scoped to the value of the groupBy field and only look back as far as window
+	threshold  int
+	followedBy *AlertRule
+	window     time.Duration
+	groupBy    string
+	maxTracked int
+	state      map[string][]time.Time
+}
+
+func (r *AlertRule) matches(msg *AuditMessageGroup) bool {
+	return r.matchesParsed(msg, groupFields{})
+}
+
+// Like matches, records already in parsed are not parsed again so many rules can share the work on one group
+func (r *AlertRule) matchesParsed(msg *AuditMessageGroup, parsed groupFields) bool {
+	if r.syscall != "" && r.syscall != msg.Syscall {
+		return false
+	}
+
+	if r.sysName != "" && r.sysName != msg.SyscallName {
+		return false
+	}
+
+	if r.msgType != 0 {
+		if _, ok := msg.firstMessage(r.msgType); !ok {
+			return false
+		}
+	}
+
+	if r.exe != "" || r.key != "" || r.uid != "" || len(r.fields) > 0 {
+		fields := r.conditionFields(msg, parsed)
+
+		for k, v := range r.fields {
+			if fields[k] != v {
+				return false
+			}
+		}
+
+		if r.exe != "" {
+			if ok, _ := path.Match(r.exe, fields["exe"]); !ok {
+				return false
+			}
+		}
+
+		if r.key != "" && !r.matchesKey(msg) {
+			return false
+		}
+
+		if r.uid != "" && r.uid != fields["uid"] {
+			return false
+		}
+	}
+
+	if r.dstNet != nil {
+		saddr, _ := msg.findField(1306, "saddr")
+		ip, _ := parseSockaddr(saddr)
+		if ip == nil || !r.dstNet.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(r.paths) > 0 && !r.matchesPaths(msg) {
+		return false
+	}
+
+	return true
+}
+
+// Fields of the first record of each message type, as parsed by conditionFields
+type groupFields map[uint16]map[string]string
+
+// Parses the message field conditions are checked against
+func (r *AlertRule) conditionFields(msg *AuditMessageGroup, parsed groupFields) map[string]string {
+	msgType := r.msgType
+	if msgType == 0 {
+		msgType = 1300
+	}
+
+	if fields, ok := parsed[msgType]; ok {
+		return fields
+	}
+
+	fields := map[string]string{}
+	if data, ok := msg.firstMessage(msgType); ok {
+		fields = parseFields(data)
+	}
+
+	parsed[msgType] = fields
+	return fields
+}
+
+// Checks the key of the rule against every key of the record conditions are matched on, rules can have more than one
+func (r *AlertRule) matchesKey(msg *AuditMessageGroup) bool {
+	msgType := r.msgType
+	if msgType == 0 {
+		msgType = 1300
+	}
+
+	data, _ := msg.firstMessage(msgType)
+	return hasKey(parseKeys(data), r.key)
+}
+
+// Finds the value stateful rules are scoped by, false if the field is missing
+func (r *AlertRule) groupKey(msg *AuditMessageGroup, groupBy string) (string, bool) {
+	if groupBy == "" {
+		return "", true
+	}
+
+	v := r.conditionFields(msg, groupFields{})[groupBy]
+	return v, v != ""
+}
+
+func (r *AlertRule) stateful() bool {
+	return r.threshold > 0 || r.followedBy != nil
+}
+
+// Checks the rule against a message group, updating the state of stateful rules
+func (r *AlertRule) evaluate(msg *AuditMessageGroup) bool {
+	if !r.stateful() {
+		return r.matches(msg)
+	}
+
+	now := eventTime(msg)
+
+	if r.followedBy != nil {
+		fired := false
+		if r.followedBy.matches(msg) {
+			if key, ok := r.followedBy.groupKey(msg, r.groupBy); ok {
+				if seen, ok := r.state[key]; ok && now.Sub(seen[0]) <= r.window {
+					delete(r.state, key)
+					fired = true
+				}
+			}
+		}
+
+		if r.matches(msg) {
+			if key, ok := r.groupKey(msg, r.groupBy); ok {
+				r.track(key, []time.Time{now}, now)
+			}
+		}
+
+		return fired
+	}
+
+	if !r.matches(msg) {
+		return false
+	}
+
+	key, ok := r.groupKey(msg, r.groupBy)
+	if !ok {
+		return false
+	}
+
+	hits := []time.Time{}
+	for _, t := range r.state[key] {
+		if now.Sub(t) <= r.window {
+			hits = append(hits, t)
+		}
+	}
+	hits = append(hits, now)
+
+	if len(hits) >= r.threshold {
+		delete(r.state, key)
+		return true
+	}
+
+	r.track(key, hits, now)
+	return false
+}
+
+// Stores the state for a key, making room by forgetting expired keys first and an arbitrary key if that wasn't enough
+func (r *AlertRule) track(key string, hits []time.Time, now time.Time) {
+	if r.state == nil {
+		r.state = map[string][]time.Time{}
+	}
+
+	maxTracked := r.maxTracked
+	if maxTracked < 1 {
+		maxTracked = defaultMaxTracked
+	}
+
+	if _, ok := r.state[key]; !ok && len(r.state) >= maxTracked {
+		for k, h := range r.state {
+			if now.Sub(h[len(h)-1]) > r.window {
+				delete(r.state, k)
+			}
+		}
+
+		for k := range r.state {
+			if len(r.state) < maxTracked {
+				break
+			}
+			delete(r.state, k)
+		}
+	}
+
+	r.state[key] = hits
+}
+
+// Uses the audit timestamp so windows are not thrown off by processing delays, falls back to the current time
+func eventTime(msg *AuditMessageGroup) time.Time {
+	if t, ok := parseAuditTime(msg.AuditTime); ok {
+		return t
+	}
+
+	return time.Now()
+}
+
+// AlertSink receives message groups that matched an alert rule
+// Sinks are called from a single goroutine and must not modify the message group
+type AlertSink interface {
+	SendAlert(ctx context.Context, msg *AuditMessageGroup) error
+	Close() error
+}
+
+// AlertSinkFactory creates an AlertSink, any sink specific settings should be read from `alerts.sinks.<name>`
+type AlertSinkFactory func(config *viper.Viper) (AlertSink, error)
+
+var alertSinks = map[string]AlertSinkFactory{}
+
+// RegisterAlertSink makes an AlertSink available to be enabled with `alerts.sinks.<name>.enabled`
+// This is meant to be called from an init() func, registering the same name twice will panic
+func RegisterAlertSink(name string, factory AlertSinkFactory) {
+	if _, ok := alertSinks[name]; ok {
+		panic(fmt.Sprintf("Alert sink `%s` is already registered", name))
+	}
+
+	alertSinks[name] = factory
+}
+
+type namedAlertSink struct {
+	name     string
+	minLevel int
+	sink     AlertSink
+}
+
+// Alerter evaluates alert rules against complete message groups and hands matches to the alert sinks
+type Alerter struct {
+	rules []*AlertRule
+	sinks []namedAlertSink
+	queue chan *AuditMessageGroup
+	wg    sync.WaitGroup
+}
+
+// CreateAlerter creates the alerter from the `alerts` config section, nil is returned if there are no rules or sinks
+// Sinks without rules still receive the built in `audit_tamper` alerts
+func CreateAlerter(config *viper.Viper) (*Alerter, error) {
+	rules, err := createAlertRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	wr, err := createWatchlistRule(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if wr != nil {
+		rules = append(rules, wr)
+	}
+
+	cr, err := createCanaryRules(config)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, cr...)
+
+	names := []string{}
+	for name := range alertSinks {
+		if config.GetBool("alerts.sinks." + name + ".enabled") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(rules) == 0 && len(names) == 0 {
+		return nil, nil
+	}
+
+	a := &Alerter{rules: rules}
+
+	for _, name := range names {
+		minLevel := 0
+		if ms := config.GetString("alerts.sinks." + name + ".min_severity"); ms != "" {
+			var ok bool
+			if minLevel, ok = severityLevel(ms); !ok {
+				return nil, fmt.Errorf("Unknown min_severity `%s` for alert sink %s", ms, name)
+			}
+		}
+
+		sink, err := alertSinks[name](config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create alert sink %s. Error: %s", name, err)
+		}
+
+		a.sinks = append(a.sinks, namedAlertSink{name: name, minLevel: minLevel, sink: sink})
+		l.Printf("Sending alerts of %s severity and above to %s\n", severities[minLevel], name)
+	}
+
+	queueSize := config.GetInt("alerts.queue_size")
+	if queueSize < 1 {
+		queueSize = 1000
+	}
+
+	a.queue = make(chan *AuditMessageGroup, queueSize)
+	a.wg.Add(1)
+	go a.dispatch()
+
+	return a, nil
+}
+
+// Parses the rules in `alerts.rules`
+func createAlertRules(config *viper.Viper) ([]*AlertRule, error) {
+	rs := config.Get("alerts.rules")
+	rules := []*AlertRule{}
+
+	if rs == nil {
+		return rules, nil
+	}
+
+	rt, ok := rs.([]interface{})
+	if !ok {
+		return rules, errors.New("Could not parse alerts.rules object")
+	}
+
+	maxTracked := config.GetInt("alerts.max_tracked")
+	if maxTracked < 1 {
+		maxTracked = defaultMaxTracked
+	}
+
+	for i, r := range rt {
+		r2, ok := r.(map[interface{}]interface{})
+		if !ok {
+			return rules, fmt.Errorf("Could not parse alert rule %d; '%+v'", i+1, r)
+		}
+
+		where := fmt.Sprintf("alert rule %d", i+1)
+		ar := &AlertRule{maxTracked: maxTracked}
+		for k, v := range r2 {
+			var err error
+			switch k {
+			case "name":
+				ar.name, err = alertRuleString(k, v, where)
+			case "severity":
+				if ar.severity, err = alertRuleString(k, v, where); err != nil {
+					break
+				}
+
+				if ar.level, ok = severityLevel(ar.severity); !ok {
+					err = fmt.Errorf("`severity` in alert rule %d must be one of %s; Value: `%s`", i+1, strings.Join(severities, ", "), ar.severity)
+				}
+			case "threshold":
+				if ar.threshold, ok = v.(int); !ok || ar.threshold < 1 {
+					err = fmt.Errorf("`threshold` in alert rule %d must be a number greater than 0; Value: `%+v`", i+1, v)
+				}
+			case "window":
+				var sv string
+				if sv, err = alertRuleString(k, v, where); err != nil {
+					break
+				}
+
+				if ar.window, err = time.ParseDuration(sv); err != nil {
+					err = fmt.Errorf("`window` in alert rule %d could not be parsed; Value: `%s`; Error: %s", i+1, sv, err)
+				}
+			case "group_by":
+				ar.groupBy, err = alertRuleString(k, v, where)
+			case "tags":
+				ar.tags, err = parseTags(v, where)
+			case "followed_by":
+				fb, ok := v.(map[interface{}]interface{})
+				if !ok {
+					err = fmt.Errorf("`followed_by` in alert rule %d could not be parsed; Value: `%+v`", i+1, v)
+					break
+				}
+
+				ar.followedBy = &AlertRule{}
+				for fk, fv := range fb {
+					if ok, err = ar.followedBy.parseCondition(fk, fv, where); err == nil && !ok {
+						err = fmt.Errorf("Unknown condition `%v` in followed_by of alert rule %d", fk, i+1)
+					}
+
+					if err != nil {
+						break
+					}
+				}
+			default:
+				if ok, err = ar.parseCondition(k, v, where); err == nil && !ok {
+					err = fmt.Errorf("Unknown condition `%v` in alert rule %d", k, i+1)
+				}
+			}
+
+			if err != nil {
+				return rules, err
+			}
+		}
+
+		if ar.name == "" {
+			return rules, fmt.Errorf("Alert rule %d is missing the `name` entry", i+1)
+		}
+
+		if ar.severity == "" {
+			return rules, fmt.Errorf("Alert rule %d is missing the `severity` entry", i+1)
+		}
+
+		if ar.threshold > 0 && ar.followedBy != nil {
+			return rules, fmt.Errorf("`threshold` and `followed_by` can not be combined in alert rule %d", i+1)
+		}
+
+		if ar.stateful() && ar.window <= 0 {
+			return rules, fmt.Errorf("Alert rule %d needs a `window` when using `threshold` or `followed_by`", i+1)
+		}
+
+		if !ar.stateful() && (ar.window > 0 || ar.groupBy != "") {
+			return rules, fmt.Errorf("`window` and `group_by` in alert rule %d are only used with `threshold` or `followed_by`", i+1)
+		}
+
+		rules = append(rules, ar)
+		l.Printf("Alerting with %s severity on rule `%s`\n", ar.severity, ar.name)
+	}
+
+	return rules, nil
+}
+
+// Everything is matched as a string, numbers are fine for syscall and uid
+func alertRuleString(k interface{}, v interface{}, where string) (string, error) {
+	switch ev := v.(type) {
+	case string:
+		return ev, nil
+	case int:
+		return fmt.Sprint(ev), nil
+	}
+
+	return "", fmt.Errorf("`%v` in %s could not be parsed; Value: `%+v`", k, where, v)
+}
+
+// Parses a single match condition into the rule, false is returned if the key is not a condition
+func (r *AlertRule) parseCondition(k interface{}, v interface{}, where string) (bool, error) {
+	if k == "fields" {
+		fm, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return true, fmt.Errorf("`fields` in %s could not be parsed; Value: `%+v`", where, v)
+		}
+
+		r.fields = map[string]string{}
+		for fk, fv := range fm {
+			sv, err := alertRuleString(fmt.Sprintf("fields.%v", fk), fv, where)
+			if err != nil {
+				return true, err
+			}
+			r.fields[fmt.Sprint(fk)] = sv
+		}
+
+		return true, nil
+	}
+
+	switch k {
+	case "syscall", "message_type", "exe", "key", "uid", "dst_ip":
+	default:
+		return false, nil
+	}
+
+	sv, err := alertRuleString(k, v, where)
+	if err != nil {
+		return true, err
+	}
+
+	switch k {
+	case "syscall":
+		// Names are matched against syscall_name
+		if _, err := strconv.Atoi(sv); err != nil {
+			r.sysName = sv
+		} else {
+			r.syscall = sv
+		}
+	case "message_type":
+		t, err := strconv.ParseUint(sv, 10, 16)
+		if err != nil {
+			return true, fmt.Errorf("`message_type` in %s could not be parsed; Value: `%s`; Error: %s", where, sv, err)
+		}
+		r.msgType = uint16(t)
+	case "exe":
+		if _, err := path.Match(sv, ""); err != nil {
+			return true, fmt.Errorf("`exe` in %s could not be parsed; Value: `%s`; Error: %s", where, sv, err)
+		}
+		r.exe = sv
+	case "key":
+		r.key = sv
+	case "uid":
+		r.uid = sv
+	case "dst_ip":
+		if !strings.Contains(sv, "/") {
+			sv += "/32"
+		}
+
+		_, n, err := net.ParseCIDR(sv)
+		if err != nil {
+			return true, fmt.Errorf("`dst_ip` in %s could not be parsed; Value: `%s`; Error: %s", where, sv, err)
+		}
+		r.dstNet = n
+	}
+
+	return true, nil
+}
+
+// Process attaches an alert to the message group if any rule matches and queues it for the sinks
+// The highest severity match wins, ties go to the rule defined first. Canaries win over everything but audit_tamper,
+// which also keeps the event in the output
+// Process is not safe for concurrent use, stateful rules are updated without locking
+func (a *Alerter) Process(msg *AuditMessageGroup) {
+	if msg.Tamper != nil {
+		msg.Alert = &Alert{Rule: "audit_tamper", Severity: "critical", level: len(severities) - 1}
+	}
+
+	for _, r := range a.rules {
+		better := msg.Alert == nil || (r.level > msg.Alert.level && !msg.Alert.canary) || (r.canary && !msg.Alert.canary && msg.Tamper == nil)
+
+		// Stateful rules have to see every message group to keep their windows up to date
+		if !better && !r.stateful() {
+			continue
+		}
+
+		if r.evaluate(msg) && better {
+			msg.Alert = &Alert{Rule: r.name, Severity: r.severity, Tags: r.tags, level: r.level, canary: r.canary}
+		}
+	}
+
+	if msg.Alert == nil || len(a.sinks) == 0 {
+		return
+	}
+
+	// Sinks run on their own goroutine while the receive loop goes on filling in the group, like latency_ms
+	queued := *msg
+	select {
+	case a.queue <- &queued:
+	default:
+		el.Printf("Alert queue is full, dropping alert `%s` for sequence %d\n", msg.Alert.Rule, msg.Seq)
+	}
+}
+
+// Forced reports if the message group matches a forced rule and must not be dropped by filters
+func (a *Alerter) Forced(msg *AuditMessageGroup) bool {
+	for _, r := range a.rules {
+		if r.force && r.matches(msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hands queued alerts to every sink that cares about the severity
+func (a *Alerter) dispatch() {
+	defer a.wg.Done()
+
+	for msg := range a.queue {
+		for _, s := range a.sinks {
+			if msg.Alert.level < s.minLevel {
+				continue
+			}
+
+			if err := s.sink.SendAlert(context.Background(), msg); err != nil {
+				el.Printf("Failed to send alert `%s` to %s. Error: %s\n", msg.Alert.Rule, s.name, err)
+			}
+		}
+	}
+}
+
+// Close sends any queued alerts and closes the sinks
+func (a *Alerter) Close() error {
+	close(a.queue)
+	a.wg.Wait()
+
+	var err error
+	for _, s := range a.sinks {
+		if cerr := s.sink.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// alertTemplateData is what alert templates are executed against
+type alertTemplateData struct {
+	*AuditMessageGroup
+	Hostname string
+
+	// The event time in output.timezone, empty if the timestamp could not be parsed
+	Time string
+
+	// Fields from the SYSCALL message, if there was one
+	Fields map[string]string
+}
+
+// Event is the event as the json output writes it, schema_version and base64 encoded invalid utf-8 included
+func (d *alertTemplateData) Event() (string, error) {
+	b, err := (&JSONMarshaler{}).Marshal(d.AuditMessageGroup)
+	return string(bytes.TrimSuffix(b, []byte{'\n'})), err
+}
+
+var hostname, _ = os.Hostname()
+
+func newAlertTemplateData(msg *AuditMessageGroup) *alertTemplateData {
+	fields := map[string]string{}
+	if data, ok := msg.firstMessage(1300); ok {
+		fields = parseFields(data)
+	}
+
+	data := &alertTemplateData{AuditMessageGroup: msg, Hostname: hostname, Fields: fields}
+	if t, ok := parseAuditTime(msg.AuditTime); ok {
+		data.Time = t.In(outputLocation).Format(humanTimeFormat)
+	}
+
+	return data
+}
+
+// Posts a json body and treats anything other than a 2xx response as an error
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Unexpected response %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+
+	// Drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
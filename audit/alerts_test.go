@@ -0,0 +1,394 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink keeps every alert it was sent
+type recordingSink struct {
+	mu     sync.Mutex
+	alerts []*AuditMessageGroup
+	err    error
+	closed bool
+}
+
+func (r *recordingSink) SendAlert(ctx context.Context, msg *AuditMessageGroup) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, msg)
+	return r.err
+}
+
+func (r *recordingSink) Close() error {
+	r.closed = true
+	return nil
+}
+
+func alertRule(kv ...interface{}) map[interface{}]interface{} {
+	m := map[interface{}]interface{}{}
+	for i := 0; i < len(kv); i += 2 {
+		m[kv[i]] = kv[i+1]
+	}
+	return m
+}
+
+func Test_createAlertRules(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	tests := []struct {
+		rule interface{}
+		err  string
+	}{
+		{"bad", "Could not parse alert rule 1; 'bad'"},
+		{alertRule("name", []string{}), "`name` in alert rule 1 could not be parsed; Value: `[]`"},
+		{alertRule("name", "a", "severity", "bad"), "`severity` in alert rule 1 must be one of info, low, medium, high, critical; Value: `bad`"},
+		{alertRule("name", "a", "exe", "["), "`exe` in alert rule 1 could not be parsed; Value: `[`; Error: syntax error in pattern"},
+		{alertRule("name", "a", "dst_ip", "nope"), "`dst_ip` in alert rule 1 could not be parsed; Value: `nope/32`; Error: invalid CIDR address: nope/32"},
+		{alertRule("name", "a", "nope", "a"), "Unknown condition `nope` in alert rule 1"},
+		{alertRule("severity", "low"), "Alert rule 1 is missing the `name` entry"},
+		{alertRule("name", "a"), "Alert rule 1 is missing the `severity` entry"},
+		{alertRule("name", "a", "message_type", "x"), "`message_type` in alert rule 1 could not be parsed; Value: `x`; Error: strconv.ParseUint: parsing \"x\": invalid syntax"},
+		{alertRule("name", "a", "fields", "x"), "`fields` in alert rule 1 could not be parsed; Value: `x`"},
+		{alertRule("name", "a", "fields", alertRule("res", []string{})), "`fields.res` in alert rule 1 could not be parsed; Value: `[]`"},
+		{alertRule("name", "a", "threshold", 0), "`threshold` in alert rule 1 must be a number greater than 0; Value: `0`"},
+		{alertRule("name", "a", "window", "x"), "`window` in alert rule 1 could not be parsed; Value: `x`; Error: time: invalid duration \"x\""},
+		{alertRule("name", "a", "followed_by", "x"), "`followed_by` in alert rule 1 could not be parsed; Value: `x`"},
+		{alertRule("name", "a", "followed_by", alertRule("name", "b")), "Unknown condition `name` in followed_by of alert rule 1"},
+		{alertRule("name", "a", "severity", "low", "threshold", 2, "followed_by", alertRule()), "`threshold` and `followed_by` can not be combined in alert rule 1"},
+		{alertRule("name", "a", "severity", "low", "threshold", 2), "Alert rule 1 needs a `window` when using `threshold` or `followed_by`"},
+		{alertRule("name", "a", "severity", "low", "group_by", "uid"), "`window` and `group_by` in alert rule 1 are only used with `threshold` or `followed_by`"},
+		{alertRule("name", "a", "tags", "x"), "`tags` in alert rule 1 could not be parsed; Value: `x`"},
+		{alertRule("name", "a", "tags", alertRule("team", []string{})), "Tag `team` in alert rule 1 could not be parsed; Value: `[]`"},
+	}
+
+	for _, test := range tests {
+		c := viper.New()
+		c.Set("alerts.rules", []interface{}{test.rule})
+		_, err := createAlertRules(c)
+		assert.EqualError(t, err, test.err)
+	}
+
+	c := viper.New()
+	c.Set("alerts.rules", 1)
+	_, err := createAlertRules(c)
+	assert.EqualError(t, err, "Could not parse alerts.rules object")
+
+	// All good
+	lb.Reset()
+	c = viper.New()
+	c.Set("alerts.rules", []interface{}{
+		alertRule("name", "web shell", "severity", "high", "syscall", 59, "exe", "/bin/*sh", "uid", 33, "key", "user_commands", "dst_ip", "10.0.0.0/8", "tags", alertRule("team", "secops", "ticket", 123)),
+	})
+	rules, err := createAlertRules(c)
+	assert.Nil(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "59", rules[0].syscall)
+	assert.Equal(t, "33", rules[0].uid)
+	assert.Equal(t, "10.0.0.0/8", rules[0].dstNet.String())
+	assert.Equal(t, 3, rules[0].level)
+	assert.Equal(t, map[string]string{"team": "secops", "ticket": "123"}, rules[0].tags)
+	assert.Equal(t, "Alerting with high severity on rule `web shell`\n", lb.String())
+
+	// Stateful rules
+	c = viper.New()
+	c.Set("alerts.max_tracked", 5)
+	c.Set("alerts.rules", []interface{}{
+		alertRule("name", "brute force", "severity", "high", "message_type", 1100, "fields", alertRule("res", "failed"), "threshold", 5, "window", "5m", "group_by", "acct"),
+		alertRule("name", "download and run", "severity", "critical", "exe", "/usr/bin/curl", "followed_by", alertRule("syscall", 90), "window", "10s", "group_by", "ses"),
+	})
+	rules, err = createAlertRules(c)
+	assert.Nil(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, uint16(1100), rules[0].msgType)
+	assert.Equal(t, map[string]string{"res": "failed"}, rules[0].fields)
+	assert.Equal(t, 5, rules[0].threshold)
+	assert.Equal(t, 5*time.Minute, rules[0].window)
+	assert.Equal(t, "acct", rules[0].groupBy)
+	assert.Equal(t, 5, rules[0].maxTracked)
+	assert.Equal(t, &AlertRule{syscall: "90"}, rules[1].followedBy)
+	assert.Equal(t, 10*time.Second, rules[1].window)
+}
+
+func TestAlertRule_matches(t *testing.T) {
+	amg := &AuditMessageGroup{
+		Syscall:     "42",
+		SyscallName: "connect",
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `syscall=42 success=yes uid=33 exe="/usr/bin/curl" key="egress"`},
+			{Type: 1306, Data: `saddr=020001BB0A0102030000000000000000`},
+		},
+	}
+
+	assert.True(t, (&AlertRule{}).matches(amg), "No conditions should always match")
+	assert.True(t, (&AlertRule{syscall: "42", uid: "33", key: "egress", exe: "/usr/bin/*"}).matches(amg))
+	assert.False(t, (&AlertRule{syscall: "59"}).matches(amg))
+	assert.True(t, (&AlertRule{sysName: "connect"}).matches(amg))
+	assert.False(t, (&AlertRule{sysName: "execve"}).matches(amg))
+	assert.False(t, (&AlertRule{uid: "0"}).matches(amg))
+	assert.False(t, (&AlertRule{key: "nope"}).matches(amg))
+
+	// Any key of a rule with more than one matches
+	multi := &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: "syscall=42 key=65677265737301636F6E6E656374"}}}
+	assert.True(t, (&AlertRule{key: "egress"}).matches(multi))
+	assert.True(t, (&AlertRule{key: "connect"}).matches(multi))
+	assert.False(t, (&AlertRule{exe: "/bin/*"}).matches(amg))
+
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	assert.True(t, (&AlertRule{dstNet: n}).matches(amg))
+	_, n, _ = net.ParseCIDR("192.168.0.0/16")
+	assert.False(t, (&AlertRule{dstNet: n}).matches(amg))
+
+	// ipv6 destinations
+	amg.Msgs[1].Data = `saddr=0A001F900000000020010DB800000000000000000000000100000000`
+	_, n, _ = net.ParseCIDR("2001:db8::/32")
+	assert.True(t, (&AlertRule{dstNet: n}).matches(amg))
+	_, n, _ = net.ParseCIDR("0.0.0.0/0")
+	assert.False(t, (&AlertRule{dstNet: n}).matches(amg))
+
+	// no sockaddr
+	amg.Msgs = amg.Msgs[:1]
+	assert.False(t, (&AlertRule{dstNet: n}).matches(amg))
+
+	// fields of other message types
+	amg = &AuditMessageGroup{
+		Msgs: []*AuditMessage{{Type: 1100, Data: `pid=1 uid=0 msg='op=PAM:authentication acct="bob" exe="/usr/sbin/sshd" res=failed'`}},
+	}
+	assert.True(t, (&AlertRule{msgType: 1100, fields: map[string]string{"res": "failed"}}).matches(amg))
+	assert.False(t, (&AlertRule{msgType: 1100, fields: map[string]string{"res": "success"}}).matches(amg))
+	assert.False(t, (&AlertRule{msgType: 1112}).matches(amg))
+	assert.False(t, (&AlertRule{fields: map[string]string{"res": "failed"}}).matches(amg), "Fields should come from SYSCALL by default")
+
+	// Records are parsed once for every rule sharing parsed
+	parsed := groupFields{}
+	assert.True(t, (&AlertRule{msgType: 1100, fields: map[string]string{"res": "failed"}}).matchesParsed(amg, parsed))
+	assert.Equal(t, "failed", parsed[1100]["res"])
+	parsed[1100]["res"] = "success"
+	assert.True(t, (&AlertRule{msgType: 1100, fields: map[string]string{"res": "success"}}).matchesParsed(amg, parsed))
+}
+
+func TestAlertRule_threshold(t *testing.T) {
+	r := &AlertRule{msgType: 1100, fields: map[string]string{"res": "failed"}, threshold: 3, window: time.Minute, groupBy: "acct"}
+	auth := func(ts string, acct string, res string) *AuditMessageGroup {
+		return &AuditMessageGroup{
+			AuditTime: ts,
+			Msgs:      []*AuditMessage{{Type: 1100, Data: `msg='op=PAM:authentication acct="` + acct + `" res=` + res + `'`}},
+		}
+	}
+
+	assert.False(t, r.evaluate(auth("100.000", "bob", "failed")))
+	assert.False(t, r.evaluate(auth("101.000", "bob", "failed")))
+	assert.False(t, r.evaluate(auth("101.500", "alice", "failed")), "Other users should be counted separately")
+	assert.False(t, r.evaluate(auth("102.000", "bob", "success")), "Non matching events should not count")
+	assert.True(t, r.evaluate(auth("103.000", "bob", "failed")))
+	assert.False(t, r.evaluate(auth("104.000", "bob", "failed")), "The count should start over after firing")
+
+	// old hits fall out of the window
+	assert.False(t, r.evaluate(auth("200.000", "alice", "failed")))
+	assert.False(t, r.evaluate(auth("201.000", "alice", "failed")))
+	assert.Len(t, r.state["alice"], 2)
+
+	// no group_by value
+	assert.False(t, r.evaluate(&AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1100, Data: "res=failed"}}}))
+}
+
+func TestAlertRule_followedBy(t *testing.T) {
+	r := &AlertRule{exe: "/usr/bin/curl", followedBy: &AlertRule{syscall: "90"}, window: 10 * time.Second, groupBy: "ses"}
+	sys := func(ts string, syscall string, exe string, ses string) *AuditMessageGroup {
+		return &AuditMessageGroup{
+			AuditTime: ts,
+			Syscall:   syscall,
+			Msgs:      []*AuditMessage{{Type: 1300, Data: "syscall=" + syscall + " ses=" + ses + " exe=\"" + exe + "\""}},
+		}
+	}
+
+	assert.False(t, r.evaluate(sys("100.000", "90", "/bin/chmod", "1")), "Nothing should fire without the first event")
+	assert.False(t, r.evaluate(sys("101.000", "59", "/usr/bin/curl", "1")))
+	assert.False(t, r.evaluate(sys("102.000", "90", "/bin/chmod", "2")), "Other sessions should not fire")
+	assert.True(t, r.evaluate(sys("105.000", "90", "/bin/chmod", "1")))
+	assert.False(t, r.evaluate(sys("106.000", "90", "/bin/chmod", "1")), "The sequence should start over after firing")
+
+	// too late
+	assert.False(t, r.evaluate(sys("200.000", "59", "/usr/bin/curl", "1")))
+	assert.False(t, r.evaluate(sys("211.000", "90", "/bin/chmod", "1")))
+}
+
+func TestAlertRule_track(t *testing.T) {
+	r := &AlertRule{window: time.Minute, maxTracked: 2}
+	now := time.Unix(1000, 0)
+
+	r.track("a", []time.Time{now.Add(-2 * time.Minute)}, now)
+	r.track("b", []time.Time{now}, now)
+	r.track("c", []time.Time{now}, now)
+	assert.Len(t, r.state, 2)
+	assert.NotContains(t, r.state, "a", "Expired keys should be forgotten first")
+
+	r.track("d", []time.Time{now}, now)
+	assert.Len(t, r.state, 2, "Some key has to go when nothing has expired")
+	assert.Contains(t, r.state, "d")
+
+	// updating a tracked key doesn't evict anything
+	r.track("d", []time.Time{now, now}, now)
+	assert.Len(t, r.state, 2)
+}
+
+func Test_eventTime(t *testing.T) {
+	assert.Equal(t, time.Unix(10000001, 123000000), eventTime(&AuditMessageGroup{AuditTime: "10000001.123"}))
+
+	defer func(loc *time.Location) { outputLocation = loc }(outputLocation)
+	outputLocation = time.FixedZone("test", -5*60*60)
+	data := newAlertTemplateData(&AuditMessageGroup{AuditTime: "10000001.123"})
+	assert.Equal(t, "1970-04-26T12:46:41.123-05:00", data.Time)
+	assert.Equal(t, "", newAlertTemplateData(&AuditMessageGroup{AuditTime: "nope"}).Time)
+	assert.WithinDuration(t, time.Now(), eventTime(&AuditMessageGroup{}), time.Second)
+}
+
+func TestAlertTemplateData_Event(t *testing.T) {
+	// Like the json output, invalid utf8 is base64 encoded instead of being mangled
+	data := newAlertTemplateData(&AuditMessageGroup{Seq: 1, AuditTime: "1", Msgs: []*AuditMessage{{Type: 1300, Data: "exe=\xff"}}})
+	event, err := data.Event()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"schema_version":2,"sequence":1,"timestamp":"1","messages":[{"type":1300,"data":"ZXhlPf8=","encoding":"base64"}],"uid_map":null}`, event)
+}
+
+func TestAlerter(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+	defer delete(alertSinks, "test")
+	defer delete(alertSinks, "broken")
+
+	sink := &recordingSink{}
+	RegisterAlertSink("test", func(config *viper.Viper) (AlertSink, error) {
+		return sink, nil
+	})
+	assert.Panics(t, func() { RegisterAlertSink("test", nil) })
+
+	RegisterAlertSink("broken", func(config *viper.Viper) (AlertSink, error) {
+		return nil, errors.New("derp")
+	})
+
+	// no rules means no alerter
+	c := viper.New()
+	a, err := CreateAlerter(c)
+	assert.Nil(t, err)
+	assert.Nil(t, a)
+
+	// sinks without rules still get audit_tamper alerts
+	c.Set("alerts.sinks.test.enabled", true)
+	a, err = CreateAlerter(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, a)
+	tamper := &AuditMessageGroup{Seq: 3, Tamper: &AuditTamper{Change: "audit_disabled"}}
+	a.Process(tamper)
+	assert.Equal(t, &Alert{Rule: "audit_tamper", Severity: "critical", level: 4}, tamper.Alert)
+	assert.Nil(t, a.Close())
+	assert.Equal(t, []*AuditMessageGroup{tamper}, sink.alerts)
+	sink.alerts = nil
+	c.Set("alerts.sinks.test.enabled", false)
+
+	// sink errors
+	c.Set("alerts.rules", []interface{}{
+		alertRule("name", "any", "severity", "low"),
+		alertRule("name", "execve", "severity", "critical", "syscall", "59", "tags", alertRule("team", "secops")),
+		alertRule("name", "also execve", "severity", "critical", "syscall", "59"),
+	})
+	c.Set("alerts.sinks.broken.enabled", true)
+	_, err = CreateAlerter(c)
+	assert.EqualError(t, err, "Failed to create alert sink broken. Error: derp")
+
+	c.Set("alerts.sinks.broken.enabled", false)
+	c.Set("alerts.sinks.test.enabled", true)
+	c.Set("alerts.sinks.test.min_severity", "bad")
+	_, err = CreateAlerter(c)
+	assert.EqualError(t, err, "Unknown min_severity `bad` for alert sink test")
+
+	// All good
+	lb.Reset()
+	c.Set("alerts.sinks.test.min_severity", "high")
+	a, err = CreateAlerter(c)
+	assert.Nil(t, err)
+	assert.Contains(t, lb.String(), "Sending alerts of high severity and above to test\n")
+
+	low := &AuditMessageGroup{Seq: 1, Syscall: "42"}
+	a.Process(low)
+	assert.Equal(t, &Alert{Rule: "any", Severity: "low", level: 1}, low.Alert)
+
+	crit := &AuditMessageGroup{Seq: 2, Syscall: "59"}
+	a.Process(crit)
+	assert.Equal(t, &Alert{Rule: "execve", Severity: "critical", Tags: map[string]string{"team": "secops"}, level: 4}, crit.Alert, "Highest severity, first defined should win")
+
+	assert.Nil(t, a.Close())
+	assert.Equal(t, []*AuditMessageGroup{crit}, sink.alerts, "Only alerts at or above min_severity should be sent")
+	assert.True(t, sink.closed)
+	assert.Empty(t, elb.String())
+}
+
+func TestAlerter_errors(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	sink := &recordingSink{err: errors.New("derp")}
+	a := &Alerter{
+		rules: []*AlertRule{{name: "any", severity: "info"}},
+		sinks: []namedAlertSink{{name: "test", sink: sink}},
+		queue: make(chan *AuditMessageGroup, 1),
+	}
+
+	// The queue is full until dispatch starts
+	a.Process(&AuditMessageGroup{Seq: 1})
+	a.Process(&AuditMessageGroup{Seq: 2})
+	assert.Equal(t, "Alert queue is full, dropping alert `any` for sequence 2\n", elb.String())
+
+	elb.Reset()
+	a.wg.Add(1)
+	go a.dispatch()
+	assert.Nil(t, a.Close())
+	assert.Equal(t, "Failed to send alert `any` to test. Error: derp\n", elb.String())
+}
+
+func TestAuditMarshaller_alerts(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+	m.alerter = &Alerter{rules: []*AlertRule{{name: "any", severity: "info"}}}
+
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): hi there"))
+	m.Consume(context.Background(), new1320("1"))
+
+	assert.Equal(
+		t,
+		"{\"schema_version\":2,\"sequence\":1,\"timestamp\":\"10000001\",\"timestamp_ms\":10000001000,\"messages\":[{\"type\":1300,\"data\":\"hi there\"}],\"uid_map\":{},\"alert\":{\"rule\":\"any\",\"severity\":\"info\"}}\n",
+		w.String(),
+	)
+}
+
+func TestAuditMarshaller_alertsLatency(t *testing.T) {
+	sink := &recordingSink{}
+	m := NewAuditMarshaller(NewAuditWriter(&bytes.Buffer{}, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+	m.latency = true
+	m.alerter = &Alerter{
+		rules: []*AlertRule{{name: "any", severity: "info"}},
+		sinks: []namedAlertSink{{name: "test", sink: sink}},
+		queue: make(chan *AuditMessageGroup, 1),
+	}
+	m.alerter.wg.Add(1)
+	go m.alerter.dispatch()
+
+	// Sinks get the group as it was when the alert was raised, the marshaller adds latency_ms after that
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): hi there"))
+	m.Consume(context.Background(), new1320("1"))
+	assert.Nil(t, m.alerter.Close())
+
+	if assert.Len(t, sink.alerts, 1) {
+		assert.Equal(t, 1, sink.alerts[0].Seq)
+		assert.Nil(t, sink.alerts[0].Latency)
+	}
+}
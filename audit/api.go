@@ -0,0 +1,414 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// How often Run checks if it should stop while no events are arriving
+const receiveTimeout = time.Second
+
+// ClientOptions configures a Client, the zero value listens to the default event range and writes nowhere
+type ClientOptions struct {
+	// Netlink receive buffer size, 0 leaves the system default in place
+	// RecvForce allows going past net.core.rmem_max, see `socket_buffer` in the example config
+	RecvSize  int
+	RecvForce bool
+
+	// Join the read only audit multicast group instead of registering as the audit daemon, see `listen_mode` in the example config
+	Multicast bool
+
+	// Range of audit message types to process, defaults to [1300, 1399]
+	EventMin uint16
+	EventMax uint16
+
+	// How long to wait for more records of an event that didn't end with an EOE, defaults to COMPLETE_AFTER
+	CompleteAfter time.Duration
+
+	// Sequence tracking, see `message_tracking` in the example config
+	TrackMessages bool
+	LogOutOfOrder bool
+	MaxOutOfOrder int
+
+	Filters   []AuditFilter
+	Enrichers []NamedEnricher
+
+	// Keep only the groups matching a filter instead of dropping them, see `filter_mode` in the example config
+	KeepOnly bool
+
+	// How often the events dropped by sampling and rate limiting filters are reported, 0 disables it
+	SuppressedInterval time.Duration
+
+	// Holds syscall events for DedupWindow and folds identical ones into them, 0 disables it. See `dedup` in the example config
+	DedupWindow time.Duration
+
+	// Optional writer that every complete message group is written to after subscribers are called
+	Writer AuditWriter
+
+	// Optional alert rules, evaluated before subscribers are called. Events matching a canary rule only go to the alert
+	// sinks, subscribers and the writer never see them
+	Alerter *Alerter
+
+	// Adds latency_ms to every group and logs writes slower than SlowOutput, see `latency` in the example config
+	Latency    bool
+	SlowOutput time.Duration
+
+	// Optional file to save the last processed sequence to every CheckpointInterval, see `message_tracking.checkpoint` in the example config
+	CheckpointPath     string
+	CheckpointInterval time.Duration
+
+	// How often to check go-audit is still the registered audit daemon, 0 disables it
+	// Reclaim registers go-audit again after a takeover, otherwise it is only reported. See `audit_daemon` in the example config
+	RegistrationInterval time.Duration
+	Reclaim              bool
+
+	// How often to check the kernel lost counter, 0 disables it. See `message_tracking.kernel_lost_interval` in the example config
+	KernelLostInterval time.Duration
+
+	// Optional unix socket to change the running client through, only the listed uids may connect. See `control` in the example config
+	ControlSocket string
+	ControlUids   []int
+
+	// Optional address to serve prometheus metrics and the /healthz and /readyz checks on, see `metrics` in the example config
+	// /readyz fails once no record arrived for MaxEventAge, 0 disables that. See `health` in the example config
+	MetricsAddress string
+	MaxEventAge    time.Duration
+
+	// Optional statsd to push the same metrics to, see `statsd` in the example config
+	Statsd *StatsdClient
+
+	// How long Run may spend flushing pending groups once the context is done, 0 means no limit. See `shutdown` in
+	// the example config
+	ShutdownTimeout time.Duration
+}
+
+// ReloadOptions is what Reload swaps on a running client
+type ReloadOptions struct {
+	Filters  []AuditFilter
+	KeepOnly bool
+
+	// The writer events go to from now on, the previous one is closed first
+	Writer AuditWriter
+
+	// Optional, called once the previous writer is closed and before anything is written to the new one
+	Start func() error
+}
+
+type reloadRequest struct {
+	opts ReloadOptions
+	err  error
+	done chan struct{}
+}
+
+// receiver is the part of NetlinkClient the Client relies on
+type receiver interface {
+	Receive() (*syscall.NetlinkMessage, error)
+}
+
+// Client wraps the netlink socket and marshaller so go-audit can be embedded in other programs
+// Complete message groups are handed to every subscriber, in the order they subscribed
+type Client struct {
+	opts        ClientOptions
+	nl          receiver
+	subscribers []func(*AuditMessageGroup)
+	internal    chan *AuditMessageGroup
+	reloads     chan *reloadRequest
+	metricsUp   func(net.Addr) // Told where the metrics endpoint ended up, tests listen on port 0
+}
+
+// How many events made up by go-audit itself can wait for the receive loop
+const internalBacklog = 64
+
+// NewClient creates a client, nothing is opened until Run is called
+func NewClient(opts ClientOptions) *Client {
+	if opts.EventMin == 0 {
+		opts.EventMin = 1300
+	}
+
+	if opts.EventMax == 0 {
+		opts.EventMax = 1399
+	}
+
+	if opts.CompleteAfter == 0 {
+		opts.CompleteAfter = COMPLETE_AFTER
+	}
+
+	return &Client{
+		opts:     opts,
+		internal: make(chan *AuditMessageGroup, internalBacklog),
+		reloads:  make(chan *reloadRequest),
+	}
+}
+
+// Reload swaps the filters and writer of the running client between two events, groups still being assembled and
+// events held for dedup are written to the new writer. It returns once the swap is done or the context is done first
+// The caller still owns the new writer if an error is returned before the swap, see Writer
+func (c *Client) Reload(ctx context.Context, opts ReloadOptions) error {
+	r := &reloadRequest{opts: opts, done: make(chan struct{})}
+	select {
+	case c.reloads <- r:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// The receive loop has it, it always finishes
+	<-r.done
+	return r.err
+}
+
+// Writer is the writer events currently go to, it changes with Reload. It should only be called while Run is not running
+func (c *Client) Writer() AuditWriter {
+	return c.opts.Writer
+}
+
+// Emit hands an event go-audit made up itself, like a rule change it made, to subscribers and the writer
+// It is safe to call from any goroutine, events are dropped if the receive loop is too far behind
+func (c *Client) Emit(msg *AuditMessageGroup) {
+	select {
+	case c.internal <- msg:
+	default:
+		el.Printf("Dropped internal event, the receive loop is behind: %s\n", msg.Msgs[0].Data)
+	}
+}
+
+// Subscribe registers a callback for every complete message group
+// Callbacks are run on the receive loop, anything slow should be handed off to another goroutine
+func (c *Client) Subscribe(fn func(*AuditMessageGroup)) *Client {
+	c.subscribers = append(c.subscribers, fn)
+	return c
+}
+
+// How long shutdown waits for more records already queued on the daemon socket
+const shutdownReceiveTimeout = 10 * time.Millisecond
+
+// Stops go-audit from receiving new records and writes out every group still being assembled, instead of dropping
+// them. The output is only closed after Run returns. daemon is nil when go-audit is not the audit daemon
+// The context given to Run is done by now, the writes get a fresh one limited to ShutdownTimeout
+func (c *Client) shutdown(marshaller *AuditMarshaller, daemon *NetlinkClient) {
+	ctx, cancel := context.Background(), func() {}
+	if c.opts.ShutdownTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.opts.ShutdownTimeout)
+	}
+	defer cancel()
+
+	// The kernel logs the records that follow or, booted with audit=1, holds them for the next audit daemon.
+	// Whatever it already queued for go-audit is still read
+	if daemon != nil {
+		if marshaller.registered {
+			if err := deregister(); err != nil {
+				el.Printf("Failed to deregister as the audit daemon. Error: %s\n", err)
+			}
+		}
+
+		if err := daemon.SetReceiveTimeout(shutdownReceiveTimeout); err == nil {
+			for ctx.Err() == nil {
+				msg, err := daemon.Receive()
+				if err != nil {
+					break
+				}
+
+				marshaller.Consume(ctx, msg)
+			}
+		}
+	}
+
+	n := marshaller.flushAll(ctx)
+	l.Printf("Flushed %d pending events\n", n)
+}
+
+// Run opens the netlink socket and processes events until the context is done
+// The context is handed down to the parser, enrichers and writer. Once it is done pending groups are still written,
+// see shutdown
+func (c *Client) Run(ctx context.Context) error {
+	var daemon *NetlinkClient
+	if c.nl == nil {
+		nl, err := NewNetlinkClient(c.opts.RecvSize, c.opts.RecvForce, c.opts.Multicast)
+		if err != nil {
+			return err
+		}
+		defer nl.Close()
+
+		// Wake up periodically to notice the context is done, a blocked recvfrom can't be interrupted otherwise
+		// Groups are flushed on wake up too, so a short CompleteAfter needs a shorter timeout
+		timeout := receiveTimeout
+		if c.opts.CompleteAfter < timeout {
+			timeout = c.opts.CompleteAfter
+		}
+
+		if err := nl.SetReceiveTimeout(timeout); err != nil {
+			return fmt.Errorf("Failed to set the netlink receive timeout. Error: %s", err)
+		}
+
+		c.nl = nl
+		if !c.opts.Multicast {
+			daemon = nl
+		}
+	}
+
+	marshaller := NewAuditMarshaller(
+		c.opts.Writer,
+		c.opts.EventMin,
+		c.opts.EventMax,
+		c.opts.TrackMessages,
+		c.opts.LogOutOfOrder,
+		c.opts.MaxOutOfOrder,
+		c.opts.Filters,
+		c.opts.Enrichers,
+	)
+	marshaller.subscribers = c.subscribers
+	marshaller.keepOnly = c.opts.KeepOnly
+	marshaller.alerter = c.opts.Alerter
+	marshaller.latency = c.opts.Latency
+	marshaller.slowOutput = c.opts.SlowOutput
+	marshaller.completeAfter = c.opts.CompleteAfter
+	marshaller.registered = true
+
+	// Whatever is still held goes out with shutdown
+	if c.opts.DedupWindow > 0 {
+		marshaller.dedup = newDeduper(c.opts.DedupWindow)
+	}
+
+	if c.opts.CheckpointPath != "" {
+		cp, err := loadCheckpoint(c.opts.CheckpointPath, c.opts.CheckpointInterval)
+		if err != nil {
+			return err
+		}
+
+		marshaller.checkpoint = cp
+		defer func() {
+			if err := cp.save(time.Now()); err != nil {
+				el.Println(err)
+			}
+		}()
+	}
+
+	var control chan *controlRequest
+	if c.opts.ControlSocket != "" {
+		s, err := listenControl(c.opts.ControlSocket, c.opts.ControlUids)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+
+		control = s.requests
+	}
+
+	var metrics chan func(*AuditMarshaller)
+	if c.opts.MetricsAddress != "" {
+		s, err := listenMetrics(c.opts.MetricsAddress, c.opts.MaxEventAge)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+
+		if c.metricsUp != nil {
+			c.metricsUp(s.ln.Addr())
+		}
+		metrics = s.requests
+	}
+
+	// A last push on the way out has whatever happened since the previous one
+	var push <-chan time.Time
+	if c.opts.Statsd != nil {
+		if err := c.opts.Statsd.open(); err != nil {
+			return err
+		}
+		defer c.opts.Statsd.Close()
+		defer func() { c.opts.Statsd.push(marshaller.metrics()) }()
+
+		t := time.NewTicker(c.opts.Statsd.interval)
+		defer t.Stop()
+		push = t.C
+	}
+
+	// The counter is polled on its own socket so replies don't end up in the event stream
+	var lost chan uint32
+	if c.opts.KernelLostInterval > 0 {
+		lost = make(chan uint32)
+		go watchKernelLost(ctx, c.opts.KernelLostInterval, lost)
+	}
+
+	var takeover chan uint32
+	if daemon != nil && c.opts.RegistrationInterval > 0 {
+		takeover = make(chan uint32)
+		go watchRegistration(ctx, c.opts.RegistrationInterval, uint32(syscall.Getpid()), takeover)
+	}
+
+	// Limiting filters can show up with a reload or be enabled through the control socket later on, reporting is a
+	// no-op without them
+	var suppressed <-chan time.Time
+	if c.opts.SuppressedInterval > 0 {
+		t := time.NewTicker(c.opts.SuppressedInterval)
+		defer t.Stop()
+		suppressed = t.C
+	}
+
+	l.Printf("Started processing events in the range [%d, %d]\n", c.opts.EventMin, c.opts.EventMax)
+
+	//Main loop. Get data from netlink and send it to the json lib for processing
+	for {
+		if err := ctx.Err(); err != nil {
+			c.shutdown(marshaller, daemon)
+			return err
+		}
+
+		// Commands run here so they never race with processing, a nil channel is never ready
+		select {
+		case req := <-control:
+			req.run(marshaller)
+		case fn := <-metrics:
+			fn(marshaller)
+		case <-push:
+			c.opts.Statsd.push(marshaller.metrics())
+		case r := <-c.reloads:
+			r.err = marshaller.reload(r.opts)
+			c.opts.Writer = marshaller.writer
+			close(r.done)
+		case msg := <-c.internal:
+			marshaller.emit(ctx, msg)
+		case n := <-lost:
+			marshaller.kernelLost(ctx, n)
+		case <-suppressed:
+			marshaller.reportSuppressed(ctx)
+		case pid := <-takeover:
+			// The registration came back on its own
+			if pid == uint32(syscall.Getpid()) {
+				marshaller.registered = true
+				break
+			}
+
+			marshaller.registered = c.opts.Reclaim
+			if c.opts.Reclaim {
+				daemon.KeepConnection()
+			}
+			marshaller.registrationLost(ctx, pid, c.opts.Reclaim)
+		default:
+		}
+
+		msg, err := c.nl.Receive()
+		if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+			marshaller.flushOld(ctx)
+			continue
+		}
+
+		// The kernel dropped records that didn't fit in the receive buffer, the socket is still usable
+		if err == syscall.ENOBUFS {
+			marshaller.overrun()
+			continue
+		}
+
+		if err != nil {
+			el.Printf("Error during message receive: %+v\n", err)
+			continue
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		marshaller.Consume(ctx, msg)
+	}
+}
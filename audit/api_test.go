@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReceiver hands out queued netlink messages, like a netlink socket with SO_RCVTIMEO set it returns EAGAIN when
+// there is nothing to read so the receive loop gets to its commands
+type fakeReceiver struct {
+	msgs chan *syscall.NetlinkMessage
+}
+
+func (f *fakeReceiver) Receive() (*syscall.NetlinkMessage, error) {
+	select {
+	case msg := <-f.msgs:
+		if msg == nil {
+			return nil, errors.New("derp")
+		}
+
+		return msg, nil
+	case <-time.After(time.Millisecond):
+		return nil, syscall.EAGAIN
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c := NewClient(ClientOptions{})
+	assert.Equal(t, uint16(1300), c.opts.EventMin)
+	assert.Equal(t, uint16(1399), c.opts.EventMax)
+
+	assert.Equal(t, COMPLETE_AFTER, c.opts.CompleteAfter)
+
+	c = NewClient(ClientOptions{EventMin: 1100, EventMax: 1200, CompleteAfter: time.Millisecond * 20})
+	assert.Equal(t, uint16(1100), c.opts.EventMin)
+	assert.Equal(t, uint16(1200), c.opts.EventMax)
+	assert.Equal(t, time.Millisecond*20, c.opts.CompleteAfter)
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	got := make(chan *AuditMessageGroup, 2)
+	order := []string{}
+
+	f := &fakeReceiver{msgs: make(chan *syscall.NetlinkMessage, 4)}
+	c := NewClient(ClientOptions{})
+	c.nl = f
+	c.Subscribe(func(msg *AuditMessageGroup) {
+		order = append(order, "first")
+	}).Subscribe(func(msg *AuditMessageGroup) {
+		order = append(order, "second")
+		got <- msg
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	f.msgs <- nil
+	f.msgs <- newNlMsg(1300, "audit(10000001:1): hi there")
+	f.msgs <- new1320("1")
+
+	select {
+	case msg := <-got:
+		assert.Equal(t, 1, msg.Seq)
+		assert.Equal(t, "hi there", msg.Msgs[0].Data)
+	case <-time.After(time.Second):
+		t.Fatal("Subscriber was never called")
+	}
+
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, "Started processing events in the range [1300, 1399]\n", lb.String())
+	assert.Equal(t, "Error during message receive: derp\n", elb.String())
+
+	// Cancelling stops the receive loop
+	cancel()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop after the context was cancelled")
+	}
+}
+
+func TestClient_Emit(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	got := make(chan *AuditMessageGroup, 1)
+	c := NewClient(ClientOptions{})
+	c.nl = &timeoutReceiver{}
+	c.Subscribe(func(msg *AuditMessageGroup) { got <- msg })
+
+	// Run has to be done logging before the next test hooks the logger
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	c.Emit(newInternalEvent(1305, "op=test"))
+	select {
+	case msg := <-got:
+		assert.Equal(t, "go-audit", msg.Source)
+		assert.Equal(t, "op=test", msg.Msgs[0].Data)
+	case <-time.After(time.Second):
+		t.Fatal("Subscriber was never called")
+	}
+
+	// Events are dropped when the receive loop can't keep up
+	c = NewClient(ClientOptions{})
+	for i := 0; i <= internalBacklog; i++ {
+		c.Emit(newInternalEvent(1305, "op=test"))
+	}
+	assert.Equal(t, "Dropped internal event, the receive loop is behind: op=test\n", elb.String())
+}
+
+func TestClient_Run_timeout(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	// Receive timeouts are not errors worth logging
+	c := NewClient(ClientOptions{})
+	c.nl = &timeoutReceiver{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, c.Run(ctx))
+	assert.Empty(t, elb.String())
+}
+
+// timeoutReceiver acts like a netlink socket with SO_RCVTIMEO set and nothing to read
+type timeoutReceiver struct{}
+
+func (r *timeoutReceiver) Receive() (*syscall.NetlinkMessage, error) {
+	time.Sleep(time.Millisecond)
+	return nil, syscall.EAGAIN
+}
+
+// overrunReceiver acts like a netlink socket whose receive buffer overflowed a few times
+type overrunReceiver struct {
+	overruns int
+}
+
+func (r *overrunReceiver) Receive() (*syscall.NetlinkMessage, error) {
+	if r.overruns > 0 {
+		r.overruns--
+		return nil, syscall.ENOBUFS
+	}
+
+	time.Sleep(time.Millisecond)
+	return nil, syscall.EAGAIN
+}
+
+func TestClient_Run_overrun(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	c := NewClient(ClientOptions{})
+	c.nl = &overrunReceiver{overruns: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, c.Run(ctx))
+	assert.Equal(t, "The netlink receive buffer overflowed and audit records were dropped, total overruns: 1. Consider raising socket_buffer.receive\n"+
+		"The netlink receive buffer overflowed and audit records were dropped, total overruns: 2. Consider raising socket_buffer.receive\n", elb.String())
+}
+
+// queueReceiver hands out its messages, then acts like a netlink socket with nothing to read
+type queueReceiver struct {
+	msgs []*syscall.NetlinkMessage
+}
+
+func (r *queueReceiver) Receive() (*syscall.NetlinkMessage, error) {
+	if len(r.msgs) > 0 {
+		msg := r.msgs[0]
+		r.msgs = r.msgs[1:]
+		return msg, nil
+	}
+
+	time.Sleep(time.Millisecond)
+	return nil, syscall.EAGAIN
+}
+
+func TestClient_Run_completeAfter(t *testing.T) {
+	hookLogger()
+	defer resetLogger()
+
+	got := make(chan *AuditMessageGroup, 1)
+	c := NewClient(ClientOptions{EventMin: 1100, CompleteAfter: time.Millisecond * 20})
+	c.nl = &queueReceiver{msgs: []*syscall.NetlinkMessage{newNlMsg(1112, "audit(10000001:1): op=login")}}
+	c.Subscribe(func(msg *AuditMessageGroup) { got <- msg })
+
+	// Run has to be done logging before the next test hooks the logger
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Nothing else arrives, the group is written once it is old enough
+	select {
+	case msg := <-got:
+		assert.Equal(t, 1, msg.Seq)
+		assert.True(t, time.Since(msg.received) >= time.Millisecond*20, "Group was completed too early")
+	case <-time.After(time.Second):
+		t.Fatal("Group was never completed")
+	}
+}
+
+func TestClient_Run_shutdown(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	r := &recordingWriter{}
+	f := &fakeReceiver{msgs: make(chan *syscall.NetlinkMessage)}
+	c := NewClient(ClientOptions{Writer: r, CompleteAfter: time.Hour, ShutdownTimeout: time.Second})
+	c.nl = f
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	// Without an EOE the group would wait an hour, shutting down writes it anyway
+	f.msgs <- newNlMsg(1300, "audit(10000001:1): hi")
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	if assert.Len(t, r.msgs, 1) {
+		assert.Equal(t, 1, r.msgs[0].Seq)
+	}
+	assert.False(t, r.closed, "The output is closed by whoever called Run")
+	assert.Contains(t, lb.String(), "Flushed 1 pending events\n")
+}
@@ -0,0 +1,1121 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var l = log.New(os.Stdout, "", 0)
+var el = log.New(os.Stderr, "", 0)
+
+type executor func(string, ...string) error
+
+func lExec(s string, a ...string) error {
+	return exec.Command(s, a...).Run()
+}
+
+func loadConfig(configFile string) (*viper.Viper, error) {
+	config := viper.New()
+	config.SetConfigFile(configFile)
+
+	config.SetDefault("socket_buffer.force", false)
+	config.SetDefault("events.min", 1300)
+	config.SetDefault("events.max", 1399)
+	config.SetDefault("events.complete_after", "2s")
+	config.SetDefault("listen_mode", "daemon")
+	config.SetDefault("audit_daemon.interval", "5s")
+	config.SetDefault("audit_daemon.on_takeover", "reclaim")
+	config.SetDefault("message_tracking.enabled", true)
+	config.SetDefault("message_tracking.log_out_of_order", false)
+	config.SetDefault("message_tracking.max_out_of_order", 500)
+	config.SetDefault("message_tracking.checkpoint.interval", "5s")
+	config.SetDefault("message_tracking.kernel_lost_interval", "10s")
+	config.SetDefault("output.format", "json")
+	config.SetDefault("formats.json.timestamp", "raw")
+	config.SetDefault("formats.flattened.separator", ".")
+	config.SetDefault("formats.template.template", "{{.Timestamp}} {{.SyscallName}} {{.Exe}} by {{.Username}}")
+	config.SetDefault("formats.ocsf.vendor", "slackhq")
+	config.SetDefault("formats.ocsf.raw_data", false)
+	config.SetDefault("formats.cef.vendor", "slackhq")
+	config.SetDefault("formats.cef.product", "go-audit")
+	config.SetDefault("formats.cef.version", "1")
+	config.SetDefault("formats.cef.severity", 3)
+	config.SetDefault("formats.cef.extensions", []string{
+		"rt=timestamp_ms", "externalId=sequence", "act=syscall_name", "outcome=syscall.success",
+		"suid=syscall.uid", "suser=username", "spid=syscall.pid", "sproc=syscall.comm",
+		"filePath=path.name", "cs1=keys", "cs2=syscall.exe", "cs3=cwd.cwd",
+	})
+	config.SetDefault("output.timezone", "utc")
+	config.SetDefault("output.syslog.enabled", false)
+	config.SetDefault("output.syslog.priority", int(syslog.LOG_LOCAL0|syslog.LOG_WARNING))
+	config.SetDefault("output.syslog.tag", "go-audit")
+	config.SetDefault("output.syslog.attempts", "3")
+	config.SetDefault("output.syslog.rfc5424.enabled", false)
+	config.SetDefault("output.syslog.rfc5424.msgid", "audit")
+	config.SetDefault("output.syslog.rfc5424.sd_id", "go-audit@32473")
+	config.SetDefault("output.file.retention.interval", "1m")
+	config.SetDefault("output.failover.attempts", 3)
+	config.SetDefault("output.failover.after", "30s")
+	config.SetDefault("output.clickhouse.attempts", 3)
+	config.SetDefault("output.clickhouse.url", "http://127.0.0.1:8123")
+	config.SetDefault("output.clickhouse.table", "go_audit.events")
+	config.SetDefault("output.clickhouse.batch_size", 1000)
+	config.SetDefault("output.clickhouse.flush_interval", "5s")
+	config.SetDefault("output.clickhouse.timeout", "10s")
+	config.SetDefault("output.http.attempts", 3)
+	config.SetDefault("output.http.batch_size", 500)
+	config.SetDefault("output.http.flush_interval", "5s")
+	config.SetDefault("output.http.timeout", "30s")
+	config.SetDefault("output.http.gzip", false)
+	config.SetDefault("output.http.retry.attempts", 3)
+	config.SetDefault("output.http.retry.backoff", "1s")
+	config.SetDefault("output.http.retry.max_backoff", "30s")
+	config.SetDefault("output.http.spool.max_size", 104857600)
+	config.SetDefault("output.tcp.attempts", 3)
+	config.SetDefault("output.tcp.queue_size", 10000)
+	config.SetDefault("output.tcp.dial_timeout", "10s")
+	config.SetDefault("output.tcp.write_timeout", "10s")
+	config.SetDefault("output.tcp.reconnect.backoff", "1s")
+	config.SetDefault("output.tcp.reconnect.max_backoff", "30s")
+	config.SetDefault("output.elasticsearch.attempts", 3)
+	config.SetDefault("output.elasticsearch.url", "http://127.0.0.1:9200")
+	config.SetDefault("output.elasticsearch.index", "go-audit-%{+yyyy.MM.dd}")
+	config.SetDefault("output.elasticsearch.batch_size", 500)
+	config.SetDefault("output.elasticsearch.flush_interval", "5s")
+	config.SetDefault("output.elasticsearch.timeout", "30s")
+	config.SetDefault("output.elasticsearch.retry.attempts", 5)
+	config.SetDefault("output.elasticsearch.retry.backoff", "1s")
+	config.SetDefault("output.elasticsearch.retry.max_backoff", "30s")
+	config.SetDefault("output.journald.attempts", 3)
+	config.SetDefault("output.journald.socket", "/run/systemd/journal/socket")
+	config.SetDefault("output.journald.identifier", "go-audit")
+	config.SetDefault("output.journald.priority", 6)
+	config.SetDefault("output.nats.attempts", 3)
+	config.SetDefault("output.nats.url", "nats://127.0.0.1:4222")
+	config.SetDefault("output.nats.subject", "go-audit.events")
+	config.SetDefault("output.nats.timeout", "10s")
+	config.SetDefault("output.nats.jetstream.enabled", false)
+	config.SetDefault("output.nats.jetstream.ack_timeout", "5s")
+	config.SetDefault("output.redis.attempts", 3)
+	config.SetDefault("output.redis.address", "127.0.0.1:6379")
+	config.SetDefault("output.redis.mode", "stream")
+	config.SetDefault("output.redis.key", "go-audit")
+	config.SetDefault("output.redis.stream.field", "event")
+	config.SetDefault("output.redis.stream.max_len", 0)
+	config.SetDefault("output.redis.pool_size", 4)
+	config.SetDefault("output.redis.timeout", "5s")
+	config.SetDefault("output.grpc.attempts", 3)
+	config.SetDefault("output.grpc.format", "protobuf")
+	config.SetDefault("output.grpc.address", "127.0.0.1:50051")
+	config.SetDefault("output.grpc.timeout", "10s")
+	config.SetDefault("output.grpc.keepalive.time", "30s")
+	config.SetDefault("output.grpc.keepalive.timeout", "10s")
+	config.SetDefault("output.grpc.stream.max_events", 10000)
+	config.SetDefault("output.grpc.stream.max_age", "1m")
+	config.SetDefault("output.fluentd.attempts", 3)
+	config.SetDefault("output.fluentd.address", "127.0.0.1:24224")
+	config.SetDefault("output.fluentd.tag", "go-audit")
+	config.SetDefault("output.fluentd.batch_size", 500)
+	config.SetDefault("output.fluentd.flush_interval", "1s")
+	config.SetDefault("output.fluentd.timeout", "10s")
+	config.SetDefault("output.fluentd.require_ack", false)
+	config.SetDefault("output.exec.attempts", 3)
+	config.SetDefault("output.exec.queue_size", 10000)
+	config.SetDefault("output.exec.write_timeout", "10s")
+	config.SetDefault("output.exec.restart.backoff", "1s")
+	config.SetDefault("output.exec.restart.max_backoff", "30s")
+	config.SetDefault("output.sql.attempts", 3)
+	config.SetDefault("output.sql.batch_size", 500)
+	config.SetDefault("output.sql.flush_interval", "5s")
+	config.SetDefault("output.parquet.attempts", 3)
+	config.SetDefault("output.parquet.partition", "hour")
+	config.SetDefault("output.parquet.compression", "gzip")
+	config.SetDefault("output.parquet.max_size", 64*1024*1024)
+	config.SetDefault("output.parquet.roll_interval", "5m")
+	config.SetDefault("output.dead_letter.max_size", 100*1024*1024)
+	config.SetDefault("output.spool.max_size", 1024*1024*1024)
+	config.SetDefault("output.spool.interval", "1s")
+	config.SetDefault("alerts.sinks.pagerduty.min_severity", "critical")
+	config.SetDefault("enrichers.first_seen.enabled", false)
+	config.SetDefault("uid_lookup.timeout", "2s")
+	config.SetDefault("uid_lookup.negative_ttl", "5m")
+	config.SetDefault("uid_lookup.unset", "unset")
+	config.SetDefault("filter_mode", "drop")
+	config.SetDefault("filter_summary_interval", "1m")
+	config.SetDefault("latency.enabled", false)
+	config.SetDefault("latency.slow_output", "1s")
+	config.SetDefault("dedup.enabled", false)
+	config.SetDefault("metrics.enabled", false)
+	config.SetDefault("metrics.address", "127.0.0.1:9851")
+	config.SetDefault("health.max_event_age", 0)
+	config.SetDefault("statsd.enabled", false)
+	config.SetDefault("statsd.address", "127.0.0.1:8125")
+	config.SetDefault("statsd.dogstatsd", false)
+	config.SetDefault("statsd.prefix", "go_audit")
+	config.SetDefault("statsd.interval", "10s")
+	config.SetDefault("shutdown.timeout", "10s")
+	config.SetDefault("dedup.window", "5s")
+	config.SetDefault("control.enabled", false)
+	config.SetDefault("control.socket", "/var/run/go-audit.sock")
+	config.SetDefault("control.allowed_uids", []int{0})
+	config.SetDefault("query_api.enabled", false)
+	config.SetDefault("query_api.socket", "/var/run/go-audit.query.sock")
+	config.SetDefault("query_api.allowed_uids", []int{0})
+	config.SetDefault("query_api.max_events", 10000)
+	config.SetDefault("osquery.enabled", false)
+	config.SetDefault("osquery.socket", "/var/osquery/osquery.em")
+	config.SetDefault("osquery.table", "go_audit_events")
+	config.SetDefault("osquery.max_events", 10000)
+	config.SetDefault("osquery.interval", "5s")
+	config.SetDefault("rules_enforce.enabled", false)
+	config.SetDefault("rules_enforce.interval", "1m")
+	config.SetDefault("remote_config.interval", "5m")
+	config.SetDefault("remote_config.timeout", "30s")
+	config.SetDefault("log.flags", 0)
+
+	if err := config.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	l.SetFlags(config.GetInt("log.flags"))
+	el.SetFlags(config.GetInt("log.flags"))
+
+	return config, nil
+}
+
+// Held while rules are being replaced
+var setRulesMu sync.Mutex
+
+func setRules(config *viper.Viper, e executor) error {
+	// Remote config refreshes and reloads can both get here, one set of rules must be done before the next starts
+	setRulesMu.Lock()
+	defer setRulesMu.Unlock()
+
+	// Clear existing rules
+	if err := e("auditctl", "-D"); err != nil {
+		return fmt.Errorf("Failed to flush existing audit rules. Error: %s", err)
+	}
+
+	l.Println("Flushed existing audit rules")
+
+	rules, err := createAuditRules(config)
+	if err != nil {
+		return err
+	}
+
+	// Add ours in
+	if len(rules) != 0 {
+		for i, r := range rules {
+			// Skip rules with no content
+			if r.rule == "" {
+				continue
+			}
+
+			if err := e("auditctl", strings.Fields(r.rule)...); err != nil {
+				return fmt.Errorf("Failed to add rule %s. Error: %s", r.name(i), err)
+			}
+
+			l.Printf("Added audit rule %s\n", r.name(i))
+		}
+	} else {
+		return errors.New("No audit rules found")
+	}
+
+	return nil
+}
+
+// auditRule is an auditctl rule from the config and the tags attached to the events it produces
+type auditRule struct {
+	rule   string
+	tags   map[string]string
+	source string // file:line for rules from rules_files
+}
+
+// Names the rule in logs, config rules by their number and file rules by where they came from
+func (r auditRule) name(i int) string {
+	if r.source != "" {
+		return r.source
+	}
+
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Reads `rules`, entries are either a plain auditctl rule or a map with `rule` and `tags`
+// The rules of `rules_files` follow them
+func createAuditRules(config *viper.Viper) ([]auditRule, error) {
+	rules := []auditRule{}
+
+	switch rs := config.Get("rules").(type) {
+	case nil:
+	case []string:
+		for _, r := range rs {
+			rules = append(rules, auditRule{rule: r})
+		}
+	case []interface{}:
+		for i, r := range rs {
+			switch v := r.(type) {
+			case nil:
+				rules = append(rules, auditRule{})
+			case string:
+				rules = append(rules, auditRule{rule: v})
+			case map[interface{}]interface{}:
+				ar := auditRule{}
+				for k, rv := range v {
+					var err error
+					switch k {
+					case "rule":
+						ar.rule, err = alertRuleString(k, rv, fmt.Sprintf("audit rule %d", i+1))
+					case "tags":
+						ar.tags, err = parseTags(rv, fmt.Sprintf("audit rule %d", i+1))
+					default:
+						err = fmt.Errorf("Unknown entry `%v` in audit rule %d", k, i+1)
+					}
+
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				if ar.rule == "" {
+					return nil, fmt.Errorf("Audit rule %d is missing the `rule` entry", i+1)
+				}
+
+				rules = append(rules, ar)
+			default:
+				return nil, fmt.Errorf("Could not parse audit rule %d; '%+v'", i+1, r)
+			}
+		}
+	default:
+		return nil, errors.New("Could not parse rules object")
+	}
+
+	for _, pattern := range config.GetStringSlice("rules_files") {
+		fileRules, err := loadRulesFiles(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, fileRules...)
+	}
+
+	return rules, nil
+}
+
+// Reads auditctl rules files like the ones in /etc/audit/rules.d, files matching the glob are read in name order
+// Blank lines, comments and -D are skipped, existing rules are always flushed first
+func loadRulesFiles(pattern string) ([]auditRule, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("rules_files could not be parsed; Value: `%s`", pattern)
+	}
+
+	sort.Strings(files)
+
+	rules := []auditRule{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read rules file `%s`. Error: %s", file, err)
+		}
+
+		for i, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' || line == "-D" {
+				continue
+			}
+
+			rules = append(rules, auditRule{rule: line, source: fmt.Sprintf("%s:%d", file, i+1)})
+		}
+	}
+
+	return rules, nil
+}
+
+// Finds the key (-k or -F key=) of an auditctl rule
+func (r auditRule) key() string {
+	args := strings.Fields(r.rule)
+	for i := 0; i < len(args)-1; i++ {
+		switch {
+		case args[i] == "-k":
+			return args[i+1]
+		case args[i] == "-F" && strings.HasPrefix(args[i+1], "key="):
+			return strings.TrimPrefix(args[i+1], "key=")
+		}
+	}
+
+	return ""
+}
+
+// Parses a map of tags, values can be anything yaml considers a scalar
+func parseTags(v interface{}, where string) (map[string]string, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("`tags` in %s could not be parsed; Value: `%+v`", where, v)
+	}
+
+	tags := make(map[string]string, len(m))
+	for k, tv := range m {
+		switch tv.(type) {
+		case string, int, bool, float64:
+			tags[fmt.Sprint(k)] = fmt.Sprint(tv)
+		default:
+			return nil, fmt.Errorf("Tag `%v` in %s could not be parsed; Value: `%+v`", k, where, tv)
+		}
+	}
+
+	return tags, nil
+}
+
+// Returns true if go-audit should join the audit multicast group instead of being the audit daemon
+func createListenMode(config *viper.Viper) (bool, error) {
+	switch mode := config.GetString("listen_mode"); mode {
+	case "daemon":
+		return false, nil
+	case "multicast":
+		if config.GetBool("rules_enforce.enabled") {
+			return false, errors.New("rules_enforce can't be used with the multicast listen_mode, the audit daemon owns the rules")
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("`listen_mode` could not be parsed; Value: `%s`", mode)
+	}
+}
+
+// How long a group without an EOE waits for more records
+func createCompleteAfter(config *viper.Viper) (time.Duration, error) {
+	completeAfter := config.GetDuration("events.complete_after")
+	if completeAfter <= 0 {
+		return 0, fmt.Errorf("events.complete_after must be greater than 0, %v provided", completeAfter)
+	}
+
+	return completeAfter, nil
+}
+
+// How long shutdown may spend writing pending groups, and then again on sending what the spools hold
+func createShutdownTimeout(config *viper.Viper) (time.Duration, error) {
+	timeout := config.GetDuration("shutdown.timeout")
+	if timeout <= 0 {
+		return 0, fmt.Errorf("shutdown.timeout must be greater than 0, %v provided", timeout)
+	}
+
+	return timeout, nil
+}
+
+// The dedup window, 0 if dedup is disabled
+func createDedupWindow(config *viper.Viper) (time.Duration, error) {
+	if !config.GetBool("dedup.enabled") {
+		return 0, nil
+	}
+
+	window := config.GetDuration("dedup.window")
+	if window <= 0 {
+		return 0, fmt.Errorf("dedup.window must be greater than 0, %v provided", window)
+	}
+
+	return window, nil
+}
+
+// Used instead of netlinkExec in the multicast listen_mode, rules from a remote config are not applied
+func readOnlyExec(name string, args ...string) error {
+	return errors.New("Audit rules are left to the audit daemon in the multicast listen_mode")
+}
+
+func createSyslogOutput(config *viper.Viper) (Output, error) {
+	if config.GetBool("output.syslog.rfc5424.enabled") {
+		w, err := createRFC5424Writer(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewWriterOutput(w), nil
+	}
+
+	syslogWriter, err := syslog.Dial(
+		config.GetString("output.syslog.network"),
+		config.GetString("output.syslog.address"),
+		syslog.Priority(config.GetInt("output.syslog.priority")),
+		config.GetString("output.syslog.tag"),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open syslog writer. Error: %v", err)
+	}
+
+	return NewWriterOutput(syslogWriter), nil
+}
+
+func createFileOutput(config *viper.Viper) (Output, error) {
+	mode := os.FileMode(config.GetInt("output.file.mode"))
+	if mode < 1 {
+		return nil, errors.New("Output file mode should be greater than 0000")
+	}
+
+	uname := config.GetString("output.file.user")
+	u, err := user.Lookup(uname)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find uid for user %s. Error: %s", uname, err)
+	}
+
+	gname := config.GetString("output.file.group")
+	g, err := user.LookupGroup(gname)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find gid for group %s. Error: %s", gname, err)
+	}
+
+	uid, err := strconv.ParseInt(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Found uid could not be parsed. Error: %s", err)
+	}
+
+	gid, err := strconv.ParseInt(g.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Found gid could not be parsed. Error: %s", err)
+	}
+
+	path := config.GetString("output.file.path")
+	retention, err := createFileRetention(config, path)
+	if err != nil {
+		return nil, err
+	}
+
+	rotator, err := createFileRotation(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileOutput{
+		path:      path,
+		mode:      mode,
+		uid:       int(uid),
+		gid:       int(gid),
+		retention: retention,
+		rotator:   rotator,
+	}, nil
+}
+
+// fileOutput appends to a local file and re-opens it on SIGUSR1
+type fileOutput struct {
+	path string
+	mode os.FileMode
+	uid  int
+	gid  int
+
+	// Optional, pruning of rotated copies of the file
+	retention *fileRetention
+
+	// Optional, rotating the file without logrotate
+	rotator *fileRotation
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64     // Size of f, for rotation
+	opened   time.Time // When f was opened, for rotation
+	err      error
+	rotation sync.Once
+	stop     chan struct{} // Closed by Close, ends the SIGUSR1 and retention goroutines
+}
+
+// Open (re)opens the output file, the previous file is closed once the new one is ready
+func (o *fileOutput) Open() error {
+	f, size, err := o.openFile()
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	oldFile := o.f
+	o.f = f
+	o.size, o.opened = size, time.Now()
+	o.err = nil
+	o.mu.Unlock()
+
+	if oldFile != nil {
+		if err := oldFile.Close(); err != nil {
+			el.Printf("Error closing old log file: %+v\n", err)
+		}
+	}
+
+	o.rotation.Do(func() {
+		o.mu.Lock()
+		o.stop = make(chan struct{})
+		stop := o.stop
+		o.mu.Unlock()
+
+		go handleLogRotation(o, stop)
+
+		if o.retention != nil {
+			go o.retention.run(stop)
+		}
+	})
+
+	return nil
+}
+
+// Opens the output file for appending with the configured mode and owner, returns its current size
+func (o *fileOutput) openFile() (*os.File, int64, error) {
+	f, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, o.mode)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to open output file. Error: %s", err)
+	}
+
+	if err := f.Chmod(o.mode); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("Failed to set file permissions. Error: %s", err)
+	}
+
+	if err := f.Chown(o.uid, o.gid); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("Could not chown output file. Error: %s", err)
+	}
+
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	return f, size, nil
+}
+
+func (o *fileOutput) Write(p []byte) (n int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.rotator != nil && o.f != nil {
+		if now := time.Now(); o.rotator.due(o.size, len(p), o.opened, now) {
+			if err := o.rotate(now); err != nil {
+				el.Printf("Failed to rotate output file. Error: %s\n", err)
+			}
+		}
+	}
+
+	n, err = o.f.Write(p)
+	o.size += int64(n)
+	o.err = err
+	return n, err
+}
+
+// Moves the output file aside and starts a new one, must be called with mu held
+// If the new file can't be opened writing carries on in the rotated copy until the next rotation
+func (o *fileOutput) rotate(now time.Time) error {
+	o.size, o.opened = 0, now
+
+	name := o.rotator.name(o.path, now)
+	if err := os.Rename(o.path, name); err != nil {
+		return err
+	}
+
+	f, size, err := o.openFile()
+	if err != nil {
+		return err
+	}
+
+	if err := o.f.Close(); err != nil {
+		el.Printf("Error closing old log file: %+v\n", err)
+	}
+
+	o.f, o.size = f, size
+	l.Printf("Rotated output file to %s\n", name)
+
+	if o.rotator.compress {
+		go func() {
+			if err := compressFile(name, o.mode, o.uid, o.gid); err != nil {
+				el.Printf("Failed to compress rotated output file %s. Error: %s\n", name, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (o *fileOutput) Flush() error {
+	return nil
+}
+
+func (o *fileOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// A reload replaces the output, nothing may reopen the file after this
+	if o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+
+	if o.f == nil {
+		return nil
+	}
+
+	err := o.f.Close()
+	o.f = nil
+	return err
+}
+
+func (o *fileOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.f != nil && o.err == nil
+}
+
+func handleLogRotation(o *fileOutput, stop <-chan struct{}) {
+	// Re-open our log file. This is triggered by a USR1 signal and is meant to be used upon log rotation
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR1)
+	defer signal.Stop(sigc)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sigc:
+		}
+
+		if err := o.Open(); err != nil {
+			el.Fatalln("Error re-opening log file. Exiting.")
+		}
+	}
+}
+
+// stdoutWriter keeps the WriterOutput from closing stdout
+type stdoutWriter struct{}
+
+func (s stdoutWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func createStdOutOutput(config *viper.Viper) (Output, error) {
+	// l logger is no longer stdout
+	l.SetOutput(os.Stderr)
+
+	return NewWriterOutput(stdoutWriter{}), nil
+}
+
+// CreateFilters reads `filters` from the config, see `filter_mode` for what a match means
+func CreateFilters(config *viper.Viper) ([]AuditFilter, error) {
+	filters, err := parseFilters(config, "filters")
+	if err != nil {
+		return filters, err
+	}
+
+	for _, af := range filters {
+		if af.limit != nil {
+			l.Printf("Limiting events matching %s\n", af)
+		} else if config.GetString("filter_mode") == "keep" {
+			l.Printf("Keeping only events matching %s\n", af)
+		} else if af.hasFieldConditions() {
+			l.Printf("Ignoring events matching %s\n", af)
+		} else {
+			l.Printf("Ignoring syscall `%v` containing message type `%v` matching string `%s`\n", af.syscall, af.messageType, af.regex.String())
+		}
+	}
+
+	return filters, nil
+}
+
+// Parses the list of filters under key, like `filters` or `output.<name>.filters`
+func parseFilters(config *viper.Viper, key string) ([]AuditFilter, error) {
+	var err error
+	var ok bool
+
+	fs := config.Get(key)
+	filters := []AuditFilter{}
+
+	if fs == nil {
+		return filters, nil
+	}
+
+	ft, ok := fs.([]interface{})
+	if !ok {
+		return filters, fmt.Errorf("Could not parse %s object", key)
+	}
+
+	for i, f := range ft {
+		f2, ok := f.(map[interface{}]interface{})
+		if !ok {
+			return filters, fmt.Errorf("Could not parse filter %d; '%+v'", i+1, f)
+		}
+
+		af := AuditFilter{}
+		for k, v := range f2 {
+			switch k {
+			case "message_type":
+				if ev, ok := v.(string); ok {
+					fv, err := strconv.ParseUint(ev, 10, 64)
+					if err != nil {
+						return filters, fmt.Errorf("`message_type` in filter %d could not be parsed; Value: `%+v`; Error: %s", i+1, v, err)
+					}
+					af.messageType = uint16(fv)
+
+				} else if ev, ok := v.(int); ok {
+					af.messageType = uint16(ev)
+
+				} else {
+					return filters, fmt.Errorf("`message_type` in filter %d could not be parsed; Value: `%+v`", i+1, v)
+				}
+
+			case "regex":
+				re, ok := v.(string)
+				if !ok {
+					return filters, fmt.Errorf("`regex` in filter %d could not be parsed; Value: `%+v`", i+1, v)
+				}
+
+				if af.regex, err = regexp.Compile(re); err != nil {
+					return filters, fmt.Errorf("`regex` in filter %d could not be parsed; Value: `%+v`; Error: %s", i+1, v, err)
+				}
+
+			case "syscall":
+				if af.syscall, ok = v.(string); ok {
+					// All is good
+				} else if ev, ok := v.(int); ok {
+					af.syscall = strconv.Itoa(ev)
+				} else {
+					return filters, fmt.Errorf("`syscall` in filter %d could not be parsed; Value: `%+v`", i+1, v)
+				}
+
+				// Names are matched against syscall_name
+				if _, err := strconv.Atoi(af.syscall); err != nil {
+					af.syscallName, af.syscall = af.syscall, ""
+				}
+
+			default:
+				if ok, err := af.parseLimit(k, v, i+1); err != nil {
+					return filters, err
+				} else if ok {
+					break
+				}
+
+				if _, err := af.parseFieldCondition(k, v, i+1); err != nil {
+					return filters, err
+				}
+			}
+		}
+
+		if err := af.checkLimit(i + 1); err != nil {
+			return filters, err
+		}
+
+		if af.regex == nil && (af.messageType != 0 || !af.hasFieldConditions()) {
+			return filters, fmt.Errorf("Filter %d is missing the `regex` entry", i+1)
+		}
+
+		if af.regex != nil && af.messageType == 0 {
+			return filters, fmt.Errorf("Filter %d is missing the `message_type` entry", i+1)
+		}
+
+		filters = append(filters, af)
+	}
+
+	return filters, nil
+}
+
+// Sends the dead letter file to the output, audit rules are left alone so this can run next to the daemon
+func replayDeadLetter(aw AuditWriter) {
+	writer, ok := aw.(*OutputWriter)
+	if !ok || writer.deadLetter == nil {
+		el.Fatal("output.dead_letter.path must be set to replay dead letters")
+	}
+
+	sent, err := writer.deadLetter.replay(context.Background(), writer)
+	if cerr := writer.Close(); cerr != nil {
+		el.Printf("Error closing output: %+v\n", cerr)
+	}
+
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	l.Printf("Replayed %d dead letter events\n", sent)
+}
+
+// Main runs go-audit as configured by the command line flags, it is all the go-audit binary does
+func Main() {
+	configFile := flag.String("config", "", "Config file location")
+	replay := flag.Bool("replay-dead-letter", false, "Send the events in output.dead_letter.path to the configured output and exit")
+	check := flag.Bool("check-config", false, "Validate the config file and exit, the exit code is 1 if anything is wrong")
+	resolve := flag.Bool("resolve", false, "With -check-config, also resolve the hostnames of the output and alert sink endpoints")
+
+	flag.Parse()
+
+	if *configFile == "" {
+		el.Println("A config file must be provided")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *check {
+		os.Exit(runCheckConfig(*configFile, *resolve))
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	remote, err := createRemoteConfig(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	// A remote config that can't be fetched or verified is not fatal, the local config is still usable
+	if remote != nil {
+		if err := remote.apply(context.Background(), config); err != nil {
+			el.Printf("Using the local config only. Error: %s\n", err)
+		}
+	}
+
+	// output needs to be created before anything that write to stdout
+	writer, err := createOutput(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	if *replay {
+		replayDeadLetter(writer)
+		return
+	}
+
+	multicast, err := createListenMode(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	ruleExec := executor(netlinkExec)
+	if multicast {
+		ruleExec = readOnlyExec
+		l.Println("Leaving the audit rules, features and status to the audit daemon")
+	} else {
+		if err := setRules(config, ruleExec); err != nil {
+			el.Fatal(err)
+		}
+
+		features, err := createAuditFeatures(config)
+		if err != nil {
+			el.Fatal(err)
+		}
+
+		if err := applyAuditFeatures(features); err != nil {
+			el.Fatal(err)
+		}
+
+		status, err := createAuditStatus(config)
+		if err != nil {
+			el.Fatal(err)
+		}
+
+		if err := applyAuditStatus(status); err != nil {
+			el.Fatal(err)
+		}
+	}
+
+	filters, err := CreateFilters(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	keepOnly, err := parseFilterMode(config, "filter_mode", filters)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	alerter, err := CreateAlerter(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	enrichers, err := CreateEnrichers(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	controlSocket, controlUids, err := createControl(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	recent, queryAPI, err := createQueryAPI(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	metricsAddress, err := createMetrics(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	maxEventAge, err := createHealth(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	statsd, err := CreateStatsd(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	shutdownTimeout, err := createShutdownTimeout(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	osquery, err := createOsqueryExtension(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	enforcer, err := createRulesEnforcer(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	registrationInterval, reclaim, err := createRegistration(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	completeAfter, err := createCompleteAfter(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	dedupWindow, err := createDedupWindow(config)
+	if err != nil {
+		el.Fatal(err)
+	}
+
+	if dedupWindow > 0 {
+		l.Printf("Folding identical events seen within %s into one\n", dedupWindow)
+	}
+
+	if outputLocation, err = loadTimezone(config.GetString("output.timezone")); err != nil {
+		el.Fatal(err)
+	}
+
+	uidLookupTimeout = config.GetDuration("uid_lookup.timeout")
+	uidNegativeTTL = config.GetDuration("uid_lookup.negative_ttl")
+	unsetIdName = config.GetString("uid_lookup.unset")
+
+	client := NewClient(ClientOptions{
+		RecvSize:             config.GetInt("socket_buffer.receive"),
+		RecvForce:            config.GetBool("socket_buffer.force"),
+		Multicast:            multicast,
+		RegistrationInterval: registrationInterval,
+		Reclaim:              reclaim,
+		EventMin:             uint16(config.GetInt("events.min")),
+		EventMax:             uint16(config.GetInt("events.max")),
+		CompleteAfter:        completeAfter,
+		TrackMessages:        config.GetBool("message_tracking.enabled"),
+		LogOutOfOrder:        config.GetBool("message_tracking.log_out_of_order"),
+		MaxOutOfOrder:        config.GetInt("message_tracking.max_out_of_order"),
+		CheckpointPath:       config.GetString("message_tracking.checkpoint.path"),
+		CheckpointInterval:   config.GetDuration("message_tracking.checkpoint.interval"),
+		KernelLostInterval:   config.GetDuration("message_tracking.kernel_lost_interval"),
+		ControlSocket:        controlSocket,
+		ControlUids:          controlUids,
+		MetricsAddress:       metricsAddress,
+		MaxEventAge:          maxEventAge,
+		Statsd:               statsd,
+		ShutdownTimeout:      shutdownTimeout,
+		Filters:              filters,
+		KeepOnly:             keepOnly,
+		SuppressedInterval:   config.GetDuration("filter_summary_interval"),
+		Enrichers:            enrichers,
+		Writer:               writer,
+		Alerter:              alerter,
+		Latency:              config.GetBool("latency.enabled"),
+		SlowOutput:           config.GetDuration("latency.slow_output"),
+		DedupWindow:          dedupWindow,
+	})
+
+	if recent != nil {
+		client.Subscribe(recent.add)
+		go func() {
+			l.Printf("Serving recent events on %s\n", queryAPI.path)
+			if err := queryAPI.ListenAndServe(); err != nil {
+				el.Printf("Query api stopped. Error: %s\n", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigc
+		l.Printf("Got %s, shutting down\n", sig)
+		cancel()
+
+		// Pending events and spools are given up on
+		sig = <-sigc
+		el.Fatalf("Got %s again, exiting right away\n", sig)
+	}()
+
+	// File outputs stop listening for SIGUSR1 when closed, a reload that drops them must not leave logrotate killing us
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGUSR1)
+
+	// SIGHUP swaps in the filters, outputs and rules of the config file without touching the netlink socket
+	go func() {
+		hupc := make(chan os.Signal, 1)
+		signal.Notify(hupc, syscall.SIGHUP)
+
+		reloadExec := ruleExec
+		if multicast {
+			reloadExec = nil
+		}
+
+		for range hupc {
+			l.Printf("Got SIGHUP, reloading %s\n", *configFile)
+			if err := reloadConfig(ctx, *configFile, client, reloadExec); err != nil {
+				el.Printf("Failed to reload the config, the running filters and outputs are left in place. Error: %s\n", err)
+			}
+		}
+	}()
+
+	if remote != nil {
+		go remote.watch(ctx, ruleExec)
+	}
+
+	if osquery != nil {
+		client.Subscribe(osquery.events.add)
+		go osquery.run(ctx)
+	}
+
+	if enforcer != nil {
+		go enforcer.run(ctx, client.Emit)
+	}
+
+	if err := client.Run(ctx); err != nil && err != context.Canceled {
+		el.Fatal(err)
+	}
+
+	if alerter != nil {
+		if err := alerter.Close(); err != nil {
+			el.Printf("Error closing alert sinks: %+v\n", err)
+		}
+	}
+
+	// A reload may have replaced the writer the client started with
+	if err := shutdownWriter(client.Writer(), time.Now().Add(shutdownTimeout)); err != nil {
+		el.Printf("Error closing output: %+v\n", err)
+	}
+
+	l.Println("Shut down cleanly")
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// The AUDIT_SET settings go-audit can change, by their name under `audit_status` in the config
+var auditStatusSettings = []struct {
+	name  string
+	mask  uint32
+	value func(s *AuditStatusPayload) *uint32
+}{
+	{"failure", AUDIT_STATUS_FAILURE, func(s *AuditStatusPayload) *uint32 { return &s.Failure }},
+	{"rate_limit", AUDIT_STATUS_RATE_LIMIT, func(s *AuditStatusPayload) *uint32 { return &s.RateLimit }},
+	{"backlog_limit", AUDIT_STATUS_BACKLOG_LIMIT, func(s *AuditStatusPayload) *uint32 { return &s.BacklogLimit }},
+	{"backlog_wait_time", AUDIT_STATUS_BACKLOG_WAIT_TIME, func(s *AuditStatusPayload) *uint32 { return &s.BacklogWaitTime }},
+}
+
+// What the kernel does when it can't record an event, see AUDIT_FAIL_* in linux/audit.h
+var auditFailureModes = []string{"silent", "printk", "panic"}
+
+// Reads `audit_status` from the config, settings that are not set are left alone
+func createAuditStatus(config *viper.Viper) (*AuditStatusPayload, error) {
+	s := &AuditStatusPayload{}
+	for _, setting := range auditStatusSettings {
+		key := "audit_status." + setting.name
+		if !config.IsSet(key) {
+			continue
+		}
+
+		v, err := parseAuditStatusValue(setting.name, config.Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("`%s` could not be parsed; Value: `%+v`", key, config.Get(key))
+		}
+
+		s.Mask |= setting.mask
+		*setting.value(s) = v
+	}
+
+	return s, nil
+}
+
+func parseAuditStatusValue(name string, v interface{}) (uint32, error) {
+	if name == "failure" {
+		for i, mode := range auditFailureModes {
+			if v == mode {
+				return uint32(i), nil
+			}
+		}
+	}
+
+	n, err := cast.ToUint32E(v)
+	if err != nil {
+		return 0, err
+	}
+
+	if name == "failure" && int(n) >= len(auditFailureModes) {
+		return 0, fmt.Errorf("unknown failure mode %d", n)
+	}
+
+	return n, nil
+}
+
+// Opens a one off netlink socket to apply the settings, see setAuditStatus
+func applyAuditStatus(s *AuditStatusPayload) error {
+	n, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	return setAuditStatus(n, s)
+}
+
+// Applies the configured settings, then logs the kernel audit status
+func setAuditStatus(n *NetlinkClient, s *AuditStatusPayload) error {
+	if err := n.SetReceiveTimeout(rulesTimeout); err != nil {
+		return fmt.Errorf("Failed to set the netlink receive timeout. Error: %s", err)
+	}
+
+	if s.Mask != 0 {
+		packet := &NetlinkPacket{
+			Type:  AUDIT_SET,
+			Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+			Pid:   uint32(syscall.Getpid()),
+		}
+
+		if _, err := n.Request(packet, s, syscall.NLMSG_ERROR); err != nil {
+			if err == syscall.EPERM {
+				err = fmt.Errorf("%s, the audit configuration may be locked (-e 2) until the next reboot", err)
+			}
+			return fmt.Errorf("Failed to set the audit status. Error: %s", err)
+		}
+
+		l.Printf("Set audit status: %s\n", formatAuditStatus(s, s.Mask))
+	}
+
+	current, err := getAuditStatus(n)
+	if err != nil {
+		el.Printf("Failed to get the audit status. Error: %s\n", err)
+		return nil
+	}
+
+	l.Printf("Audit status: enabled=%d pid=%d %s lost=%d backlog=%d\n",
+		current.Enabled, current.Pid, formatAuditStatus(current, ^uint32(0)), current.Lost, current.Backlog)
+	return nil
+}
+
+// Asks the kernel for its audit status with AUDIT_GET
+func getAuditStatus(n *NetlinkClient) (*AuditStatusPayload, error) {
+	packet := &NetlinkPacket{
+		Type:  AUDIT_GET,
+		Flags: syscall.NLM_F_REQUEST,
+		Pid:   uint32(syscall.Getpid()),
+	}
+
+	msg, err := n.Request(packet, &AuditStatusPayload{}, AUDIT_GET)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAuditStatus(msg.Data)
+}
+
+// Older kernels send a shorter struct audit_status, the missing fields are left at 0
+func parseAuditStatus(data []byte) (*AuditStatusPayload, error) {
+	s := &AuditStatusPayload{}
+	b := make([]byte, binary.Size(s))
+	if len(data) < 4*8 {
+		return nil, fmt.Errorf("Audit status is too short, %d bytes", len(data))
+	}
+
+	copy(b, data)
+	if err := binary.Read(bytes.NewReader(b), Endianness, s); err != nil {
+		return nil, fmt.Errorf("Could not parse the audit status. Error: %s", err)
+	}
+
+	return s, nil
+}
+
+// Formats the settings in mask like `failure=printk backlog_limit=8192`
+func formatAuditStatus(s *AuditStatusPayload, mask uint32) string {
+	parts := []string{}
+	for _, setting := range auditStatusSettings {
+		if mask&setting.mask == 0 {
+			continue
+		}
+
+		v := *setting.value(s)
+		if setting.name == "failure" && int(v) < len(auditFailureModes) {
+			parts = append(parts, "failure="+auditFailureModes[v])
+		} else {
+			parts = append(parts, fmt.Sprintf("%s=%d", setting.name, v))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createAuditStatus(t *testing.T) {
+	c := viper.New()
+	s, err := createAuditStatus(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditStatusPayload{}, s)
+
+	c.Set("audit_status.failure", "panic")
+	c.Set("audit_status.backlog_limit", 8192)
+	c.Set("audit_status.backlog_wait_time", "60000")
+	s, err = createAuditStatus(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditStatusPayload{
+		Mask:            AUDIT_STATUS_FAILURE | AUDIT_STATUS_BACKLOG_LIMIT | AUDIT_STATUS_BACKLOG_WAIT_TIME,
+		Failure:         2,
+		BacklogLimit:    8192,
+		BacklogWaitTime: 60000,
+	}, s)
+	assert.Equal(t, "failure=panic backlog_limit=8192 backlog_wait_time=60000", formatAuditStatus(s, s.Mask))
+
+	c = viper.New()
+	c.Set("audit_status.failure", 1)
+	c.Set("audit_status.rate_limit", 0)
+	s, err = createAuditStatus(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditStatusPayload{Mask: AUDIT_STATUS_FAILURE | AUDIT_STATUS_RATE_LIMIT, Failure: 1}, s)
+
+	tests := map[string]interface{}{"failure": "loud", "rate_limit": -1, "backlog_limit": "lots"}
+	for k, v := range tests {
+		c := viper.New()
+		c.Set("audit_status."+k, v)
+		_, err := createAuditStatus(c)
+		assert.Error(t, err, k)
+	}
+
+	c = viper.New()
+	c.Set("audit_status.failure", 3)
+	_, err = createAuditStatus(c)
+	assert.EqualError(t, err, "`audit_status.failure` could not be parsed; Value: `3`")
+}
+
+func Test_parseAuditStatus(t *testing.T) {
+	want := &AuditStatusPayload{Enabled: 1, Failure: 1, Pid: 42, BacklogLimit: 8192, Lost: 3, Backlog: 1, Version: 2, BacklogWaitTime: 60000}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, Endianness, want)
+
+	s, err := parseAuditStatus(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, want, s)
+
+	// Older kernels have no version or backlog_wait_time
+	s, err = parseAuditStatus(buf.Bytes()[:32])
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), s.Lost)
+	assert.Equal(t, uint32(0), s.BacklogWaitTime)
+
+	_, err = parseAuditStatus(buf.Bytes()[:8])
+	assert.EqualError(t, err, "Audit status is too short, 8 bytes")
+}
@@ -0,0 +1,865 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadConfig(t *testing.T) {
+	file := createTempFile(t, "defaultValues.test.yaml", "")
+	defer os.Remove(file)
+
+	// defaults
+	config, err := loadConfig(file)
+	assert.Equal(t, 1300, config.GetInt("events.min"), "events.min should default to 1300")
+	assert.Equal(t, 1399, config.GetInt("events.max"), "events.max should default to 1399")
+	assert.Equal(t, time.Second*2, config.GetDuration("events.complete_after"), "events.complete_after should default to 2s")
+	assert.Equal(t, true, config.GetBool("message_tracking.enabled"), "message_tracking.enabled should default to true")
+	assert.Equal(t, false, config.GetBool("message_tracking.log_out_of_order"), "message_tracking.log_out_of_order should default to false")
+	assert.Equal(t, 500, config.GetInt("message_tracking.max_out_of_order"), "message_tracking.max_out_of_order should default to 500")
+	assert.Equal(t, time.Second*5, config.GetDuration("message_tracking.checkpoint.interval"), "message_tracking.checkpoint.interval should default to 5s")
+	assert.Equal(t, false, config.GetBool("socket_buffer.force"), "socket_buffer.force should default to false")
+	assert.Equal(t, "daemon", config.GetString("listen_mode"), "listen_mode should default to daemon")
+	assert.Equal(t, time.Second*5, config.GetDuration("audit_daemon.interval"), "audit_daemon.interval should default to 5s")
+	assert.Equal(t, "reclaim", config.GetString("audit_daemon.on_takeover"), "audit_daemon.on_takeover should default to reclaim")
+	assert.Equal(t, time.Second*10, config.GetDuration("message_tracking.kernel_lost_interval"), "message_tracking.kernel_lost_interval should default to 10s")
+	assert.Equal(t, false, config.GetBool("output.syslog.enabled"), "output.syslog.enabled should default to false")
+	assert.Equal(t, 132, config.GetInt("output.syslog.priority"), "output.syslog.priority should default to 132")
+	assert.Equal(t, "go-audit", config.GetString("output.syslog.tag"), "output.syslog.tag should default to go-audit")
+	assert.Equal(t, 3, config.GetInt("output.syslog.attempts"), "output.syslog.attempts should default to 3")
+	assert.Equal(t, false, config.GetBool("output.syslog.rfc5424.enabled"), "output.syslog.rfc5424.enabled should default to false")
+	assert.Equal(t, "audit", config.GetString("output.syslog.rfc5424.msgid"), "output.syslog.rfc5424.msgid should default to audit")
+	assert.Equal(t, "go-audit@32473", config.GetString("output.syslog.rfc5424.sd_id"), "output.syslog.rfc5424.sd_id should default to go-audit@32473")
+	assert.Equal(t, time.Minute, config.GetDuration("output.file.retention.interval"), "output.file.retention.interval should default to 1m")
+	assert.Equal(t, 3, config.GetInt("output.failover.attempts"), "output.failover.attempts should default to 3")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.failover.after"), "output.failover.after should default to 30s")
+	assert.Equal(t, 3, config.GetInt("output.clickhouse.attempts"), "output.clickhouse.attempts should default to 3")
+	assert.Equal(t, "http://127.0.0.1:8123", config.GetString("output.clickhouse.url"), "output.clickhouse.url should default to http://127.0.0.1:8123")
+	assert.Equal(t, "go_audit.events", config.GetString("output.clickhouse.table"), "output.clickhouse.table should default to go_audit.events")
+	assert.Equal(t, 1000, config.GetInt("output.clickhouse.batch_size"), "output.clickhouse.batch_size should default to 1000")
+	assert.Equal(t, time.Second*5, config.GetDuration("output.clickhouse.flush_interval"), "output.clickhouse.flush_interval should default to 5s")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.clickhouse.timeout"), "output.clickhouse.timeout should default to 10s")
+	assert.Equal(t, 3, config.GetInt("output.http.attempts"), "output.http.attempts should default to 3")
+	assert.Equal(t, 500, config.GetInt("output.http.batch_size"), "output.http.batch_size should default to 500")
+	assert.Equal(t, time.Second*5, config.GetDuration("output.http.flush_interval"), "output.http.flush_interval should default to 5s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.http.timeout"), "output.http.timeout should default to 30s")
+	assert.Equal(t, false, config.GetBool("output.http.gzip"), "output.http.gzip should default to false")
+	assert.Equal(t, 3, config.GetInt("output.http.retry.attempts"), "output.http.retry.attempts should default to 3")
+	assert.Equal(t, time.Second, config.GetDuration("output.http.retry.backoff"), "output.http.retry.backoff should default to 1s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.http.retry.max_backoff"), "output.http.retry.max_backoff should default to 30s")
+	assert.Equal(t, 104857600, config.GetInt("output.http.spool.max_size"), "output.http.spool.max_size should default to 100MB")
+	assert.Equal(t, 3, config.GetInt("output.tcp.attempts"), "output.tcp.attempts should default to 3")
+	assert.Equal(t, 10000, config.GetInt("output.tcp.queue_size"), "output.tcp.queue_size should default to 10000")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.tcp.dial_timeout"), "output.tcp.dial_timeout should default to 10s")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.tcp.write_timeout"), "output.tcp.write_timeout should default to 10s")
+	assert.Equal(t, time.Second, config.GetDuration("output.tcp.reconnect.backoff"), "output.tcp.reconnect.backoff should default to 1s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.tcp.reconnect.max_backoff"), "output.tcp.reconnect.max_backoff should default to 30s")
+	assert.Equal(t, 3, config.GetInt("output.elasticsearch.attempts"), "output.elasticsearch.attempts should default to 3")
+	assert.Equal(t, "http://127.0.0.1:9200", config.GetString("output.elasticsearch.url"), "output.elasticsearch.url should default to http://127.0.0.1:9200")
+	assert.Equal(t, "go-audit-%{+yyyy.MM.dd}", config.GetString("output.elasticsearch.index"), "output.elasticsearch.index should default to go-audit-%{+yyyy.MM.dd}")
+	assert.Equal(t, 500, config.GetInt("output.elasticsearch.batch_size"), "output.elasticsearch.batch_size should default to 500")
+	assert.Equal(t, time.Second*5, config.GetDuration("output.elasticsearch.flush_interval"), "output.elasticsearch.flush_interval should default to 5s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.elasticsearch.timeout"), "output.elasticsearch.timeout should default to 30s")
+	assert.Equal(t, 5, config.GetInt("output.elasticsearch.retry.attempts"), "output.elasticsearch.retry.attempts should default to 5")
+	assert.Equal(t, time.Second, config.GetDuration("output.elasticsearch.retry.backoff"), "output.elasticsearch.retry.backoff should default to 1s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.elasticsearch.retry.max_backoff"), "output.elasticsearch.retry.max_backoff should default to 30s")
+	assert.Equal(t, 3, config.GetInt("output.journald.attempts"), "output.journald.attempts should default to 3")
+	assert.Equal(t, "/run/systemd/journal/socket", config.GetString("output.journald.socket"), "output.journald.socket should default to /run/systemd/journal/socket")
+	assert.Equal(t, "go-audit", config.GetString("output.journald.identifier"), "output.journald.identifier should default to go-audit")
+	assert.Equal(t, 6, config.GetInt("output.journald.priority"), "output.journald.priority should default to 6")
+	assert.Equal(t, 3, config.GetInt("output.nats.attempts"), "output.nats.attempts should default to 3")
+	assert.Equal(t, "nats://127.0.0.1:4222", config.GetString("output.nats.url"), "output.nats.url should default to nats://127.0.0.1:4222")
+	assert.Equal(t, "go-audit.events", config.GetString("output.nats.subject"), "output.nats.subject should default to go-audit.events")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.nats.timeout"), "output.nats.timeout should default to 10s")
+	assert.Equal(t, false, config.GetBool("output.nats.jetstream.enabled"), "output.nats.jetstream.enabled should default to false")
+	assert.Equal(t, time.Second*5, config.GetDuration("output.nats.jetstream.ack_timeout"), "output.nats.jetstream.ack_timeout should default to 5s")
+	assert.Equal(t, 3, config.GetInt("output.redis.attempts"), "output.redis.attempts should default to 3")
+	assert.Equal(t, "127.0.0.1:6379", config.GetString("output.redis.address"), "output.redis.address should default to 127.0.0.1:6379")
+	assert.Equal(t, "stream", config.GetString("output.redis.mode"), "output.redis.mode should default to stream")
+	assert.Equal(t, "go-audit", config.GetString("output.redis.key"), "output.redis.key should default to go-audit")
+	assert.Equal(t, "event", config.GetString("output.redis.stream.field"), "output.redis.stream.field should default to event")
+	assert.Equal(t, 0, config.GetInt("output.redis.stream.max_len"), "output.redis.stream.max_len should default to 0")
+	assert.Equal(t, 4, config.GetInt("output.redis.pool_size"), "output.redis.pool_size should default to 4")
+	assert.Equal(t, time.Second*5, config.GetDuration("output.redis.timeout"), "output.redis.timeout should default to 5s")
+	assert.Equal(t, 3, config.GetInt("output.grpc.attempts"), "output.grpc.attempts should default to 3")
+	assert.Equal(t, "protobuf", config.GetString("output.grpc.format"), "output.grpc.format should default to protobuf")
+	assert.Equal(t, "127.0.0.1:50051", config.GetString("output.grpc.address"), "output.grpc.address should default to 127.0.0.1:50051")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.grpc.timeout"), "output.grpc.timeout should default to 10s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.grpc.keepalive.time"), "output.grpc.keepalive.time should default to 30s")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.grpc.keepalive.timeout"), "output.grpc.keepalive.timeout should default to 10s")
+	assert.Equal(t, 10000, config.GetInt("output.grpc.stream.max_events"), "output.grpc.stream.max_events should default to 10000")
+	assert.Equal(t, time.Minute, config.GetDuration("output.grpc.stream.max_age"), "output.grpc.stream.max_age should default to 1m")
+	assert.Equal(t, 3, config.GetInt("output.fluentd.attempts"), "output.fluentd.attempts should default to 3")
+	assert.Equal(t, "127.0.0.1:24224", config.GetString("output.fluentd.address"), "output.fluentd.address should default to 127.0.0.1:24224")
+	assert.Equal(t, "go-audit", config.GetString("output.fluentd.tag"), "output.fluentd.tag should default to go-audit")
+	assert.Equal(t, 500, config.GetInt("output.fluentd.batch_size"), "output.fluentd.batch_size should default to 500")
+	assert.Equal(t, time.Second, config.GetDuration("output.fluentd.flush_interval"), "output.fluentd.flush_interval should default to 1s")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.fluentd.timeout"), "output.fluentd.timeout should default to 10s")
+	assert.Equal(t, false, config.GetBool("output.fluentd.require_ack"), "output.fluentd.require_ack should default to false")
+	assert.Equal(t, 3, config.GetInt("output.exec.attempts"), "output.exec.attempts should default to 3")
+	assert.Equal(t, 10000, config.GetInt("output.exec.queue_size"), "output.exec.queue_size should default to 10000")
+	assert.Equal(t, time.Second*10, config.GetDuration("output.exec.write_timeout"), "output.exec.write_timeout should default to 10s")
+	assert.Equal(t, time.Second, config.GetDuration("output.exec.restart.backoff"), "output.exec.restart.backoff should default to 1s")
+	assert.Equal(t, time.Second*30, config.GetDuration("output.exec.restart.max_backoff"), "output.exec.restart.max_backoff should default to 30s")
+	assert.Equal(t, 3, config.GetInt("output.sql.attempts"), "output.sql.attempts should default to 3")
+	assert.Equal(t, 500, config.GetInt("output.sql.batch_size"), "output.sql.batch_size should default to 500")
+	assert.Equal(t, time.Second*5, config.GetDuration("output.sql.flush_interval"), "output.sql.flush_interval should default to 5s")
+	assert.Equal(t, 3, config.GetInt("output.parquet.attempts"), "output.parquet.attempts should default to 3")
+	assert.Equal(t, "hour", config.GetString("output.parquet.partition"), "output.parquet.partition should default to hour")
+	assert.Equal(t, "gzip", config.GetString("output.parquet.compression"), "output.parquet.compression should default to gzip")
+	assert.Equal(t, 64*1024*1024, config.GetInt("output.parquet.max_size"), "output.parquet.max_size should default to 64MB")
+	assert.Equal(t, time.Minute*5, config.GetDuration("output.parquet.roll_interval"), "output.parquet.roll_interval should default to 5m")
+	assert.Equal(t, 100*1024*1024, config.GetInt("output.dead_letter.max_size"), "output.dead_letter.max_size should default to 100MB")
+	assert.Equal(t, 1024*1024*1024, config.GetInt("output.spool.max_size"), "output.spool.max_size should default to 1GB")
+	assert.Equal(t, time.Second, config.GetDuration("output.spool.interval"), "output.spool.interval should default to 1s")
+	assert.Equal(t, "json", config.GetString("output.format"), "output.format should default to json")
+	assert.Equal(t, "raw", config.GetString("formats.json.timestamp"), "formats.json.timestamp should default to raw")
+	assert.Equal(t, ".", config.GetString("formats.flattened.separator"), "formats.flattened.separator should default to .")
+	assert.Equal(t, "{{.Timestamp}} {{.SyscallName}} {{.Exe}} by {{.Username}}", config.GetString("formats.template.template"), "formats.template.template should default to the example layout")
+	assert.Equal(t, "slackhq", config.GetString("formats.ocsf.vendor"), "formats.ocsf.vendor should default to slackhq")
+	assert.Equal(t, false, config.GetBool("formats.ocsf.raw_data"), "formats.ocsf.raw_data should default to false")
+	assert.Equal(t, "slackhq", config.GetString("formats.cef.vendor"), "formats.cef.vendor should default to slackhq")
+	assert.Equal(t, "go-audit", config.GetString("formats.cef.product"), "formats.cef.product should default to go-audit")
+	assert.Equal(t, "1", config.GetString("formats.cef.version"), "formats.cef.version should default to 1")
+	assert.Equal(t, 3, config.GetInt("formats.cef.severity"), "formats.cef.severity should default to 3")
+	assert.Len(t, config.GetStringSlice("formats.cef.extensions"), 12, "formats.cef.extensions should default to 12 extensions")
+	assert.Equal(t, "critical", config.GetString("alerts.sinks.pagerduty.min_severity"), "alerts.sinks.pagerduty.min_severity should default to critical")
+	assert.Equal(t, false, config.GetBool("enrichers.first_seen.enabled"), "enrichers.first_seen.enabled should default to false")
+	assert.Equal(t, "utc", config.GetString("output.timezone"), "output.timezone should default to utc")
+	assert.Equal(t, "drop", config.GetString("filter_mode"), "filter_mode should default to drop")
+	assert.Equal(t, time.Minute, config.GetDuration("filter_summary_interval"), "filter_summary_interval should default to 1m")
+	assert.Equal(t, false, config.GetBool("latency.enabled"), "latency.enabled should default to false")
+	assert.Equal(t, time.Second, config.GetDuration("latency.slow_output"), "latency.slow_output should default to 1s")
+	assert.Equal(t, false, config.GetBool("dedup.enabled"), "dedup.enabled should default to false")
+	assert.Equal(t, 5*time.Second, config.GetDuration("dedup.window"), "dedup.window should default to 5s")
+	assert.Equal(t, false, config.GetBool("metrics.enabled"), "metrics.enabled should default to false")
+	assert.Equal(t, "127.0.0.1:9851", config.GetString("metrics.address"), "metrics.address should default to 127.0.0.1:9851")
+	assert.Equal(t, time.Duration(0), config.GetDuration("health.max_event_age"), "health.max_event_age should default to 0")
+	assert.Equal(t, false, config.GetBool("statsd.enabled"), "statsd.enabled should default to false")
+	assert.Equal(t, "127.0.0.1:8125", config.GetString("statsd.address"), "statsd.address should default to 127.0.0.1:8125")
+	assert.Equal(t, false, config.GetBool("statsd.dogstatsd"), "statsd.dogstatsd should default to false")
+	assert.Equal(t, "go_audit", config.GetString("statsd.prefix"), "statsd.prefix should default to go_audit")
+	assert.Equal(t, 10*time.Second, config.GetDuration("statsd.interval"), "statsd.interval should default to 10s")
+	assert.Equal(t, 10*time.Second, config.GetDuration("shutdown.timeout"), "shutdown.timeout should default to 10s")
+	assert.Equal(t, time.Second*2, config.GetDuration("uid_lookup.timeout"), "uid_lookup.timeout should default to 2s")
+	assert.Equal(t, time.Minute*5, config.GetDuration("uid_lookup.negative_ttl"), "uid_lookup.negative_ttl should default to 5m")
+	assert.Equal(t, "unset", config.GetString("uid_lookup.unset"), "uid_lookup.unset should default to unset")
+	assert.Equal(t, false, config.GetBool("control.enabled"), "control.enabled should default to false")
+	assert.Equal(t, "/var/run/go-audit.sock", config.GetString("control.socket"), "control.socket should default to /var/run/go-audit.sock")
+	assert.Equal(t, []int{0}, config.Get("control.allowed_uids"), "control.allowed_uids should default to root")
+	assert.Equal(t, false, config.GetBool("query_api.enabled"), "query_api.enabled should default to false")
+	assert.Equal(t, "/var/run/go-audit.query.sock", config.GetString("query_api.socket"), "query_api.socket should default to /var/run/go-audit.query.sock")
+	assert.Equal(t, []int{0}, config.Get("query_api.allowed_uids"), "query_api.allowed_uids should default to root")
+	assert.Equal(t, 10000, config.GetInt("query_api.max_events"), "query_api.max_events should default to 10000")
+	assert.Equal(t, false, config.GetBool("osquery.enabled"), "osquery.enabled should default to false")
+	assert.Equal(t, "/var/osquery/osquery.em", config.GetString("osquery.socket"), "osquery.socket should default to /var/osquery/osquery.em")
+	assert.Equal(t, "go_audit_events", config.GetString("osquery.table"), "osquery.table should default to go_audit_events")
+	assert.Equal(t, 10000, config.GetInt("osquery.max_events"), "osquery.max_events should default to 10000")
+	assert.Equal(t, time.Second*5, config.GetDuration("osquery.interval"), "osquery.interval should default to 5s")
+	assert.Equal(t, false, config.GetBool("rules_enforce.enabled"), "rules_enforce.enabled should default to false")
+	assert.Equal(t, time.Minute, config.GetDuration("rules_enforce.interval"), "rules_enforce.interval should default to 1m")
+	assert.Equal(t, time.Minute*5, config.GetDuration("remote_config.interval"), "remote_config.interval should default to 5m")
+	assert.Equal(t, time.Second*30, config.GetDuration("remote_config.timeout"), "remote_config.timeout should default to 30s")
+	assert.Equal(t, 0, config.GetInt("log.flags"), "log.flags should default to 0")
+	assert.Equal(t, 0, l.Flags(), "stdout log flags was wrong")
+	assert.Equal(t, 0, el.Flags(), "stderr log flags was wrong")
+	assert.Nil(t, err)
+
+	// parse error
+	file = createTempFile(t, "defaultValues.test.yaml", "this is bad")
+	config, err = loadConfig(file)
+	assert.EqualError(t, err, "While parsing config: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str `this is...` into map[string]interface {}")
+	assert.Nil(t, config)
+}
+
+func Test_createAuditRules(t *testing.T) {
+	tests := []struct {
+		rules interface{}
+		err   string
+	}{
+		{1, "Could not parse rules object"},
+		{[]interface{}{1}, "Could not parse audit rule 1; '1'"},
+		{[]interface{}{"-e 1", map[interface{}]interface{}{"tags": map[interface{}]interface{}{}}}, "Audit rule 2 is missing the `rule` entry"},
+		{[]interface{}{map[interface{}]interface{}{"rule": "-e 1", "nope": 1}}, "Unknown entry `nope` in audit rule 1"},
+		{[]interface{}{map[interface{}]interface{}{"rule": "-e 1", "tags": "x"}}, "`tags` in audit rule 1 could not be parsed; Value: `x`"},
+	}
+
+	for _, test := range tests {
+		c := viper.New()
+		c.Set("rules", test.rules)
+		_, err := createAuditRules(c)
+		assert.EqualError(t, err, test.err)
+	}
+
+	c := viper.New()
+	rules, err := createAuditRules(c)
+	assert.Nil(t, err)
+	assert.Empty(t, rules)
+
+	c.Set("rules", []interface{}{
+		"-a exit,always -S execve",
+		nil,
+		map[interface{}]interface{}{
+			"rule": "-w /etc/shadow -p wa -k shadow",
+			"tags": map[interface{}]interface{}{"team": "secops", "ticket": 42, "paging": true},
+		},
+	})
+	rules, err = createAuditRules(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []auditRule{
+		{rule: "-a exit,always -S execve"},
+		{},
+		{rule: "-w /etc/shadow -p wa -k shadow", tags: map[string]string{"team": "secops", "ticket": "42", "paging": "true"}},
+	}, rules)
+}
+
+func Test_loadRulesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "99-finalize.rules"), []byte("-e 2\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "10-base.rules"), []byte("## Remove any existing rules\n-D\n\n-b 8192\n  -w /etc/shadow -p wa -k shadow  \n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "notes.txt"), []byte("-e 0\n"), 0644)
+
+	c := viper.New()
+	c.Set("rules", []string{"-a exit,always -S execve"})
+	c.Set("rules_files", []string{path.Join(dir, "*.rules")})
+	rules, err := createAuditRules(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []auditRule{
+		{rule: "-a exit,always -S execve"},
+		{rule: "-b 8192", source: path.Join(dir, "10-base.rules") + ":4"},
+		{rule: "-w /etc/shadow -p wa -k shadow", source: path.Join(dir, "10-base.rules") + ":5"},
+		{rule: "-e 2", source: path.Join(dir, "99-finalize.rules") + ":1"},
+	}, rules)
+
+	assert.Equal(t, "#1", rules[0].name(0))
+	assert.Equal(t, path.Join(dir, "99-finalize.rules")+":1", rules[3].name(3))
+
+	// Nothing matching is not an error
+	c.Set("rules_files", []string{path.Join(dir, "*.conf")})
+	rules, err = createAuditRules(c)
+	assert.Nil(t, err)
+	assert.Len(t, rules, 1)
+
+	c.Set("rules_files", []string{"[nope"})
+	_, err = createAuditRules(c)
+	assert.EqualError(t, err, "rules_files could not be parsed; Value: `[nope`")
+}
+
+func Test_auditRule_key(t *testing.T) {
+	assert.Equal(t, "shadow", auditRule{rule: "-w /etc/shadow -p wa -k shadow"}.key())
+	assert.Equal(t, "exec", auditRule{rule: "-a exit,always -S execve -F key=exec"}.key())
+	assert.Equal(t, "", auditRule{rule: "-a exit,always -S execve"}.key())
+	assert.Equal(t, "", auditRule{rule: "-a exit,always -k"}.key())
+	assert.Equal(t, "", auditRule{}.key())
+}
+
+func Test_createListenMode(t *testing.T) {
+	c := viper.New()
+	c.Set("listen_mode", "daemon")
+	multicast, err := createListenMode(c)
+	assert.Nil(t, err)
+	assert.False(t, multicast)
+
+	c.Set("listen_mode", "multicast")
+	multicast, err = createListenMode(c)
+	assert.Nil(t, err)
+	assert.True(t, multicast)
+
+	c.Set("rules_enforce.enabled", true)
+	_, err = createListenMode(c)
+	assert.EqualError(t, err, "rules_enforce can't be used with the multicast listen_mode, the audit daemon owns the rules")
+
+	c.Set("listen_mode", "auditd")
+	_, err = createListenMode(c)
+	assert.EqualError(t, err, "`listen_mode` could not be parsed; Value: `auditd`")
+}
+
+func Test_setRules(t *testing.T) {
+	defer resetLogger()
+
+	// fail to flush rules
+	config := viper.New()
+
+	err := setRules(config, func(s string, a ...string) error {
+		if s == "auditctl" && a[0] == "-D" {
+			return errors.New("testing")
+		}
+
+		return nil
+	})
+
+	assert.EqualError(t, err, "Failed to flush existing audit rules. Error: testing")
+
+	// fail on 0 rules
+	err = setRules(config, func(s string, a ...string) error { return nil })
+	assert.EqualError(t, err, "No audit rules found")
+
+	// failure to set rule
+	r := 0
+	config.Set("rules", []string{"-a -1 -2", "", "-a -3 -4"})
+	err = setRules(config, func(s string, a ...string) error {
+		if a[0] != "-D" {
+			return errors.New("testing rule")
+		}
+
+		r++
+
+		return nil
+	})
+
+	assert.Equal(t, 1, r, "Wrong number of rule set attempts")
+	assert.EqualError(t, err, "Failed to add rule #1. Error: testing rule")
+
+	// properly set rules
+	r = 0
+	err = setRules(config, func(s string, a ...string) error {
+		// Skip the flush rules
+		if a[0] != "-a" {
+			return nil
+		}
+
+		if (a[1] == "-1" && a[2] == "-2") || (a[1] == "-3" && a[2] == "-4") {
+			r++
+		}
+
+		return nil
+	})
+
+	assert.Equal(t, 2, r, "Wrong number of correct rule set attempts")
+	assert.Nil(t, err)
+
+	// one caller at a time, a second caller doesn't flush until the first is done adding
+	calls := make(chan string, 10)
+	release := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- setRules(config, func(s string, a ...string) error {
+			calls <- "first " + a[0]
+			if a[0] == "-a" {
+				<-release
+			}
+			return nil
+		})
+	}()
+	assert.Equal(t, "first -D", <-calls)
+	assert.Equal(t, "first -a", <-calls)
+
+	go func() {
+		done <- setRules(config, func(s string, a ...string) error {
+			calls <- "second " + a[0]
+			return nil
+		})
+	}()
+
+	select {
+	case c := <-calls:
+		t.Fatalf("Rules were set concurrently, got %s", c)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Nil(t, <-done)
+	assert.Equal(t, "first -a", <-calls)
+	assert.Nil(t, <-done)
+	assert.Equal(t, "second -D", <-calls)
+}
+
+func Test_createFileOutput(t *testing.T) {
+	uid := os.Getuid()
+	gid := os.Getgid()
+	u, _ := user.LookupId(strconv.Itoa(uid))
+	g, _ := user.LookupGroupId(strconv.Itoa(gid))
+
+	// travis-ci is silly
+	if u.Username == "" {
+		u.Username = g.Name
+	}
+
+	// mode error
+	c := viper.New()
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	o, err := createFileOutput(c)
+	assert.EqualError(t, err, "Output file mode should be greater than 0000")
+	assert.Nil(t, o)
+
+	// uid error
+	c = viper.New()
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	c.Set("output.file.mode", 0644)
+	o, err = createFileOutput(c)
+	assert.EqualError(t, err, "Could not find uid for user . Error: user: unknown user ")
+	assert.Nil(t, o)
+
+	// gid error
+	c = viper.New()
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", u.Username)
+	o, err = createFileOutput(c)
+	assert.EqualError(t, err, "Could not find gid for group . Error: group: unknown group ")
+	assert.Nil(t, o)
+
+	// failure to create/open file
+	c = viper.New()
+	c.Set("output.file.path", "/do/not/exist/please")
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", u.Username)
+	c.Set("output.file.group", g.Name)
+	o, err = createFileOutput(c)
+	assert.Nil(t, err)
+	assert.EqualError(t, o.Open(), "Failed to open output file. Error: open /do/not/exist/please: no such file or directory")
+	assert.False(t, o.Healthy())
+
+	// chown error
+	c = viper.New()
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", "root")
+	c.Set("output.file.group", "root")
+	o, err = createFileOutput(c)
+	assert.Nil(t, err)
+	assert.EqualError(t, o.Open(), "Could not chown output file. Error: chown /tmp/go-audit.test.log: operation not permitted")
+
+	// All good
+	c = viper.New()
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", u.Username)
+	c.Set("output.file.group", g.Name)
+	o, err = createFileOutput(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &fileOutput{}, o)
+	assert.Nil(t, o.Open())
+	assert.True(t, o.Healthy())
+	assert.IsType(t, &os.File{}, o.(*fileOutput).f)
+
+	// Re-opening swaps the file
+	oldFile := o.(*fileOutput).f
+	assert.Nil(t, o.Open())
+	assert.NotEqual(t, oldFile, o.(*fileOutput).f)
+
+	assert.Nil(t, o.Close())
+	assert.False(t, o.Healthy())
+}
+
+func Test_createSyslogOutput(t *testing.T) {
+	// dial error
+	c := viper.New()
+	c.Set("output.syslog.priority", -1)
+	o, err := createSyslogOutput(c)
+	assert.EqualError(t, err, "Failed to open syslog writer. Error: log/syslog: invalid priority")
+	assert.Nil(t, o)
+
+	// All good
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c = viper.New()
+	c.Set("output.syslog.network", "tcp")
+	c.Set("output.syslog.address", l.Addr().String())
+	o, err = createSyslogOutput(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, o)
+	assert.IsType(t, &syslog.Writer{}, o.(*WriterOutput).w)
+}
+
+func Test_createStdOutOutput(t *testing.T) {
+	defer resetLogger()
+
+	c := viper.New()
+	o, err := createStdOutOutput(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, o)
+	assert.IsType(t, stdoutWriter{}, o.(*WriterOutput).w)
+	assert.Nil(t, o.Close(), "Closing should leave stdout alone")
+}
+
+func Test_createOutput(t *testing.T) {
+	// no outputs
+	c := viper.New()
+	w, err := createOutput(c)
+	assert.EqualError(t, err, "No outputs were configured")
+	assert.Nil(t, w)
+
+	// multiple outputs
+	uid := os.Getuid()
+	gid := os.Getgid()
+	u, _ := user.LookupId(strconv.Itoa(uid))
+	g, _ := user.LookupGroupId(strconv.Itoa(gid))
+
+	// travis-ci is silly
+	if u.Username == "" {
+		u.Username = g.Name
+	}
+
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c = viper.New()
+	c.Set("output.syslog.enabled", true)
+	c.Set("output.syslog.attempts", 1)
+	c.Set("output.syslog.network", "tcp")
+	c.Set("output.syslog.address", l.Addr().String())
+
+	c.Set("output.file.enabled", true)
+	c.Set("output.file.attempts", 1)
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", u.Username)
+	c.Set("output.file.group", g.Name)
+
+	c.Set("output.file.format", "nope")
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "Unknown output format `nope`")
+	assert.Nil(t, w)
+
+	// every output gets its own writer and format
+	c.Set("output.file.format", "json")
+	w, err = createOutput(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &multiWriter{}, w)
+	assert.Equal(t, []string{"file", "syslog"}, w.(*multiWriter).names)
+	assert.IsType(t, &fileOutput{}, w.(*multiWriter).writers[0].w)
+	assert.IsType(t, &syslog.Writer{}, w.(*multiWriter).writers[1].w.(*WriterOutput).w)
+	assert.Nil(t, w.Close())
+
+	c.Set("output.dead_letter.path", path.Join(os.TempDir(), "go-audit.test.dead"))
+	c.Set("output.dead_letter.max_size", 1024)
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "output.dead_letter can only be used with a single output, use output.spool with several")
+	assert.Nil(t, w)
+
+	// syslog error
+	c = viper.New()
+	c.Set("output.syslog.enabled", true)
+	c.Set("output.syslog.attempts", 0)
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "Output attempts for syslog must be at least 1, 0 provided")
+	assert.Nil(t, w)
+
+	// file error
+	c = viper.New()
+	c.Set("output.file.enabled", true)
+	c.Set("output.file.attempts", 0)
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "Output attempts for file must be at least 1, 0 provided")
+	assert.Nil(t, w)
+
+	// file open error
+	c = viper.New()
+	c.Set("output.file.enabled", true)
+	c.Set("output.file.attempts", 1)
+	c.Set("output.file.path", "/do/not/exist/please")
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", u.Username)
+	c.Set("output.file.group", g.Name)
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "Failed to open output file. Error: open /do/not/exist/please: no such file or directory")
+	assert.Nil(t, w)
+
+	// stdout error
+	c = viper.New()
+	c.Set("output.stdout.enabled", true)
+	c.Set("output.stdout.attempts", 0)
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "Output attempts for stdout must be at least 1, 0 provided")
+	assert.Nil(t, w)
+
+	// format error
+	c = viper.New()
+	c.Set("output.stdout.enabled", true)
+	c.Set("output.stdout.attempts", 1)
+	c.Set("output.format", "nope")
+	w, err = createOutput(c)
+	assert.EqualError(t, err, "Unknown output format `nope`")
+	assert.Nil(t, w)
+	resetLogger()
+
+	// All good syslog
+	c = viper.New()
+	c.Set("output.syslog.enabled", true)
+	c.Set("output.syslog.attempts", 1)
+	c.Set("output.syslog.network", "tcp")
+	c.Set("output.syslog.address", l.Addr().String())
+	w, err = createOutput(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, w)
+	assert.Equal(t, 1, w.(*OutputWriter).attempts)
+	assert.IsType(t, &syslog.Writer{}, w.(*OutputWriter).w.(*WriterOutput).w)
+
+	// All good file
+	c = viper.New()
+	c.Set("output.file.enabled", true)
+	c.Set("output.file.attempts", 1)
+	c.Set("output.file.path", path.Join(os.TempDir(), "go-audit.test.log"))
+	c.Set("output.file.mode", 0644)
+	c.Set("output.file.user", u.Username)
+	c.Set("output.file.group", g.Name)
+	w, err = createOutput(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, w)
+	assert.IsType(t, &OutputWriter{}, w)
+	assert.IsType(t, &fileOutput{}, w.(*OutputWriter).w)
+	assert.IsType(t, &JSONMarshaler{}, w.(*OutputWriter).m)
+
+	// File rotation
+	os.Rename(path.Join(os.TempDir(), "go-audit.test.log"), path.Join(os.TempDir(), "go-audit.test.log.rotated"))
+	_, err = os.Stat(path.Join(os.TempDir(), "go-audit.test.log"))
+	assert.True(t, os.IsNotExist(err))
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	time.Sleep(100 * time.Millisecond)
+	_, err = os.Stat(path.Join(os.TempDir(), "go-audit.test.log"))
+	assert.Nil(t, err)
+}
+
+func TestCreateFilters(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	// no filters
+	c := viper.New()
+	f, err := CreateFilters(c)
+	assert.Nil(t, err)
+	assert.Empty(t, f)
+
+	// Bad outer filter value
+	c = viper.New()
+	c.Set("filters", 1)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "Could not parse filters object")
+	assert.Empty(t, f)
+
+	// Bad inner filter value
+	c = viper.New()
+	rf := make([]interface{}, 0)
+	rf = append(rf, "bad filter definition")
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "Could not parse filter 1; 'bad filter definition'")
+	assert.Empty(t, f)
+
+	// Bad message type - string
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"message_type": "bad message type"})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "`message_type` in filter 1 could not be parsed; Value: `bad message type`; Error: strconv.ParseUint: parsing \"bad message type\": invalid syntax")
+	assert.Empty(t, f)
+
+	// Bad message type - unknown
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"message_type": false})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "`message_type` in filter 1 could not be parsed; Value: `false`")
+	assert.Empty(t, f)
+
+	// Bad regex - not string
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"regex": false})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "`regex` in filter 1 could not be parsed; Value: `false`")
+	assert.Empty(t, f)
+
+	// Bad regex - un-parse-able
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"regex": "["})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "`regex` in filter 1 could not be parsed; Value: `[`; Error: error parsing regexp: missing closing ]: `[`")
+	assert.Empty(t, f)
+
+	// Bad syscall - not string or int
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"syscall": []string{}})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "`syscall` in filter 1 could not be parsed; Value: `[]`")
+	assert.Empty(t, f)
+
+	// Missing regex
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"syscall": "1", "message_type": "1"})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "Filter 1 is missing the `regex` entry")
+	assert.Empty(t, f)
+
+	// Missing message_type
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"syscall": "1", "regex": "1"})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.EqualError(t, err, "Filter 1 is missing the `message_type` entry")
+	assert.Empty(t, f)
+
+	// Good with strings
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"message_type": "1", "regex": "1", "syscall": "1"})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, f)
+	assert.Equal(t, "1", f[0].syscall)
+	assert.Equal(t, uint16(1), f[0].messageType)
+	assert.Equal(t, "1", f[0].regex.String())
+	assert.Empty(t, elb.String())
+	assert.Equal(t, "Ignoring syscall `1` containing message type `1` matching string `1`\n", lb.String())
+
+	// Good with ints
+	lb.Reset()
+	elb.Reset()
+	c = viper.New()
+	rf = make([]interface{}, 0)
+	rf = append(rf, map[interface{}]interface{}{"message_type": 1, "regex": "1", "syscall": 1})
+	c.Set("filters", rf)
+	f, err = CreateFilters(c)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, f)
+	assert.Equal(t, "1", f[0].syscall)
+	assert.Equal(t, uint16(1), f[0].messageType)
+	assert.Equal(t, "1", f[0].regex.String())
+	assert.Empty(t, elb.String())
+	assert.Equal(t, "Ignoring syscall `1` containing message type `1` matching string `1`\n", lb.String())
+}
+
+func TestCreateFilters_fields(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	c.Set("filters", []interface{}{
+		map[interface{}]interface{}{"syscall": "connect", "dst_ip": "10.0.0.0/8", "success": true},
+		map[interface{}]interface{}{"uid": 0, "exe_prefix": "/usr/lib/", "key": "exec"},
+		map[interface{}]interface{}{"syscall": 42},
+		map[interface{}]interface{}{"username": "nobody", "dst_ip": "::1", "message_type": 1306, "regex": "saddr=0A"},
+	})
+
+	f, err := CreateFilters(c)
+	assert.Nil(t, err)
+	assert.Len(t, f, 4)
+
+	assert.Equal(t, "", f[0].syscall)
+	assert.Equal(t, "connect", f[0].syscallName)
+	assert.Equal(t, "10.0.0.0/8", f[0].dstNet.String())
+	assert.Equal(t, true, *f[0].success)
+
+	assert.Equal(t, "0", f[1].uid)
+	assert.Equal(t, "/usr/lib/", f[1].exePrefix)
+	assert.Equal(t, "exec", f[1].key)
+
+	assert.Equal(t, "42", f[2].syscall)
+	assert.True(t, f[2].hasFieldConditions())
+
+	assert.Equal(t, "::1/128", f[3].dstNet.String())
+	assert.Equal(t, uint16(1306), f[3].messageType)
+
+	assert.Equal(t, "Ignoring events matching syscall=connect success=true dst_ip=10.0.0.0/8\n"+
+		"Ignoring events matching uid=0 exe_prefix=/usr/lib/ key=exec\n"+
+		"Ignoring events matching syscall=42\n"+
+		"Ignoring events matching username=nobody dst_ip=::1/128 message_type=1306 regex=saddr=0A\n", lb.String())
+
+	// Bad values
+	for _, tc := range []struct {
+		filter map[interface{}]interface{}
+		err    string
+	}{
+		{map[interface{}]interface{}{"success": "yes"}, "`success` in filter 1 could not be parsed; Value: `yes`"},
+		{map[interface{}]interface{}{"uid": []string{}}, "`uid` in filter 1 could not be parsed; Value: `[]`"},
+		{map[interface{}]interface{}{"dst_ip": "10.0.0.0/33"}, "`dst_ip` in filter 1 could not be parsed; Value: `10.0.0.0/33`; Error: invalid CIDR address: 10.0.0.0/33"},
+		{map[interface{}]interface{}{"key": "exec", "message_type": 1300}, "Filter 1 is missing the `regex` entry"},
+		{map[interface{}]interface{}{"key": "exec", "regex": "a"}, "Filter 1 is missing the `message_type` entry"},
+	} {
+		c.Set("filters", []interface{}{tc.filter})
+		_, err := CreateFilters(c)
+		assert.EqualError(t, err, tc.err)
+	}
+}
+
+func Benchmark_MultiPacketMessage(b *testing.B) {
+	marshaller := NewAuditMarshaller(NewAuditWriter(&noopWriter{}, 1), uint16(1300), uint16(1399), false, false, 1, []AuditFilter{}, nil)
+
+	data := make([][]byte, 6)
+
+	//&{1300,,arch=c000003e,syscall=59,success=yes,exit=0,a0=cc4e68,a1=d10bc8,a2=c69808,a3=7fff2a700900,items=2,ppid=11552,pid=11623,auid=1000,uid=1000,gid=1000,euid=1000,suid=1000,fsuid=1000,egid=1000,sgid=1000,fsgid=1000,tty=pts0,ses=35,comm="ls",exe="/bin/ls",key=(null),1222763,1459376866.885}
+	data[0] = []byte{34, 1, 0, 0, 20, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 97, 117, 100, 105, 116, 40, 49, 52, 53, 57, 51, 55, 54, 56, 54, 54, 46, 56, 56, 53, 58, 49, 50, 50, 50, 55, 54, 51, 41, 58, 32, 97, 114, 99, 104, 61, 99, 48, 48, 48, 48, 48, 51, 101, 32, 115, 121, 115, 99, 97, 108, 108, 61, 53, 57, 32, 115, 117, 99, 99, 101, 115, 115, 61, 121, 101, 115, 32, 101, 120, 105, 116, 61, 48, 32, 97, 48, 61, 99, 99, 52, 101, 54, 56, 32, 97, 49, 61, 100, 49, 48, 98, 99, 56, 32, 97, 50, 61, 99, 54, 57, 56, 48, 56, 32, 97, 51, 61, 55, 102, 102, 102, 50, 97, 55, 48, 48, 57, 48, 48, 32, 105, 116, 101, 109, 115, 61, 50, 32, 112, 112, 105, 100, 61, 49, 49, 53, 53, 50, 32, 112, 105, 100, 61, 49, 49, 54, 50, 51, 32, 97, 117, 105, 100, 61, 49, 48, 48, 48, 32, 117, 105, 100, 61, 49, 48, 48, 48, 32, 103, 105, 100, 61, 49, 48, 48, 48, 32, 101, 117, 105, 100, 61, 49, 48, 48, 48, 32, 115, 117, 105, 100, 61, 49, 48, 48, 48, 32, 102, 115, 117, 105, 100, 61, 49, 48, 48, 48, 32, 101, 103, 105, 100, 61, 49, 48, 48, 48, 32, 115, 103, 105, 100, 61, 49, 48, 48, 48, 32, 102, 115, 103, 105, 100, 61, 49, 48, 48, 48, 32, 116, 116, 121, 61, 112, 116, 115, 48, 32, 115, 101, 115, 61, 51, 53, 32, 99, 111, 109, 109, 61, 34, 108, 115, 34, 32, 101, 120, 101, 61, 34, 47, 98, 105, 110, 47, 108, 115, 34, 32, 107, 101, 121, 61, 40, 110, 117, 108, 108, 41}
+
+	//&{1309,,argc=3,a0="ls",a1="--color=auto",a2="-alF",1222763,1459376866.885}
+	data[1] = []byte{73, 0, 0, 0, 29, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 97, 117, 100, 105, 116, 40, 49, 52, 53, 57, 51, 55, 54, 56, 54, 54, 46, 56, 56, 53, 58, 49, 50, 50, 50, 55, 54, 51, 41, 58, 32, 97, 114, 103, 99, 61, 51, 32, 97, 48, 61, 34, 108, 115, 34, 32, 97, 49, 61, 34, 45, 45, 99, 111, 108, 111, 114, 61, 97, 117, 116, 111, 34, 32, 97, 50, 61, 34, 45, 97, 108, 70, 34}
+
+	//&{1307,,,cwd="/home/ubuntu/src/slack-github.com/rhuber/go-audit-new",1222763,1459376866.885}
+	data[2] = []byte{91, 0, 0, 0, 27, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 97, 117, 100, 105, 116, 40, 49, 52, 53, 57, 51, 55, 54, 56, 54, 54, 46, 56, 56, 53, 58, 49, 50, 50, 50, 55, 54, 51, 41, 58, 32, 32, 99, 119, 100, 61, 34, 47, 104, 111, 109, 101, 47, 117, 98, 117, 110, 116, 117, 47, 115, 114, 99, 47, 115, 108, 97, 99, 107, 45, 103, 105, 116, 104, 117, 98, 46, 99, 111, 109, 47, 114, 104, 117, 98, 101, 114, 47, 103, 111, 45, 97, 117, 100, 105, 116, 45, 110, 101, 119, 34}
+
+	//&{1302,,item=0,name="/bin/ls",inode=262316,dev=ca:01,mode=0100755,ouid=0,ogid=0,rdev=00:00,nametype=NORMAL,1222763,1459376866.885}
+	data[3] = []byte{129, 0, 0, 0, 22, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 97, 117, 100, 105, 116, 40, 49, 52, 53, 57, 51, 55, 54, 56, 54, 54, 46, 56, 56, 53, 58, 49, 50, 50, 50, 55, 54, 51, 41, 58, 32, 105, 116, 101, 109, 61, 48, 32, 110, 97, 109, 101, 61, 34, 47, 98, 105, 110, 47, 108, 115, 34, 32, 105, 110, 111, 100, 101, 61, 50, 54, 50, 51, 49, 54, 32, 100, 101, 118, 61, 99, 97, 58, 48, 49, 32, 109, 111, 100, 101, 61, 48, 49, 48, 48, 55, 53, 53, 32, 111, 117, 105, 100, 61, 48, 32, 111, 103, 105, 100, 61, 48, 32, 114, 100, 101, 118, 61, 48, 48, 58, 48, 48, 32, 110, 97, 109, 101, 116, 121, 112, 101, 61, 78, 79, 82, 77, 65, 76}
+
+	//&{1302,,item=1,name="/lib64/ld-linux-x86-64.so.2",inode=396037,dev=ca:01,mode=0100755,ouid=0,ogid=0,rdev=00:00,nametype=NORMAL,1222763,1459376866.885}
+	data[4] = []byte{149, 0, 0, 0, 22, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 97, 117, 100, 105, 116, 40, 49, 52, 53, 57, 51, 55, 54, 56, 54, 54, 46, 56, 56, 53, 58, 49, 50, 50, 50, 55, 54, 51, 41, 58, 32, 105, 116, 101, 109, 61, 49, 32, 110, 97, 109, 101, 61, 34, 47, 108, 105, 98, 54, 52, 47, 108, 100, 45, 108, 105, 110, 117, 120, 45, 120, 56, 54, 45, 54, 52, 46, 115, 111, 46, 50, 34, 32, 105, 110, 111, 100, 101, 61, 51, 57, 54, 48, 51, 55, 32, 100, 101, 118, 61, 99, 97, 58, 48, 49, 32, 109, 111, 100, 101, 61, 48, 49, 48, 48, 55, 53, 53, 32, 111, 117, 105, 100, 61, 48, 32, 111, 103, 105, 100, 61, 48, 32, 114, 100, 101, 118, 61, 48, 48, 58, 48, 48, 32, 110, 97, 109, 101, 116, 121, 112, 101, 61, 78, 79, 82, 77, 65, 76}
+
+	//&{1320,,,1222763,1459376866.885}
+	data[5] = []byte{31, 0, 0, 0, 40, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 97, 117, 100, 105, 116, 40, 49, 52, 53, 57, 51, 55, 54, 56, 54, 54, 46, 56, 56, 53, 58, 49, 50, 50, 50, 55, 54, 51, 41, 58, 32}
+
+	for i := 0; i < b.N; i++ {
+		for n := 0; n < len(data); n++ {
+			nlen := len(data[n])
+			msg := &syscall.NetlinkMessage{
+				Header: syscall.NlMsghdr{
+					Len:   Endianness.Uint32(data[n][0:4]),
+					Type:  Endianness.Uint16(data[n][4:6]),
+					Flags: Endianness.Uint16(data[n][6:8]),
+					Seq:   Endianness.Uint32(data[n][8:12]),
+					Pid:   Endianness.Uint32(data[n][12:16]),
+				},
+				Data: data[n][syscall.SizeofNlMsghdr:nlen],
+			}
+			marshaller.Consume(context.Background(), msg)
+		}
+	}
+}
+
+type noopWriter struct{ t *testing.T }
+
+func (t *noopWriter) Write(a []byte) (int, error) {
+	return 0, nil
+}
+
+func createTempFile(t *testing.T, name string, contents string) string {
+	file := os.TempDir() + string(os.PathSeparator) + "go-audit." + name
+	if err := ioutil.WriteFile(file, []byte(contents), os.FileMode(0644)); err != nil {
+		t.Fatal("Failed to create temp file", err)
+	}
+	return file
+}
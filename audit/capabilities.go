@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"strconv"
+)
+
+// Capability names by bit, see linux/capability.h
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID",
+	"CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST", "CAP_NET_ADMIN",
+	"CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE", "CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE", "CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP", "CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM", "CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF", "CAP_CHECKPOINT_RESTORE",
+}
+
+// The capability set fields of CAPSET (cap_p*) and BPRM_FCAPS (p*, old_p* and f*) records
+// fe and cap_fe are a single effective bit, not a set, and are left alone
+var capabilityFields = []string{
+	"cap_pi", "cap_pp", "cap_pe", "cap_pa",
+	"fp", "fi", "old_pp", "old_pi", "old_pe", "old_pa", "pp", "pi", "pe", "pa",
+}
+
+// Expands a hex capability set into capability names, in bit order
+// Bits newer than the names we know are returned as their number, an empty set is an empty list
+func parseCapabilities(mask string) ([]string, bool) {
+	bits, err := strconv.ParseUint(mask, 16, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	names := []string{}
+	for i := uint(0); i < 64; i++ {
+		if bits&(1<<i) == 0 {
+			continue
+		}
+
+		if int(i) < len(capabilityNames) {
+			names = append(names, capabilityNames[i])
+		} else {
+			names = append(names, strconv.Itoa(int(i)))
+		}
+	}
+
+	return names, true
+}
+
+// Adds the capability sets of a CAPSET or BPRM_FCAPS record to Capabilities, a set seen before keeps its first value
+func (amg *AuditMessageGroup) addCapabilities(am *AuditMessage) {
+	fields := parseFields(am.Data)
+	for _, field := range capabilityFields {
+		if _, ok := amg.Capabilities[field]; ok {
+			continue
+		}
+
+		names, ok := parseCapabilities(fields[field])
+		if !ok {
+			continue
+		}
+
+		if amg.Capabilities == nil {
+			amg.Capabilities = make(map[string][]string, 4)
+		}
+
+		amg.Capabilities[field] = names
+	}
+}
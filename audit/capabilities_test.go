@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseCapabilities(t *testing.T) {
+	caps, ok := parseCapabilities("3000")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"CAP_NET_ADMIN", "CAP_NET_RAW"}, caps)
+
+	caps, ok = parseCapabilities("000001ffffffffff")
+	assert.True(t, ok)
+	assert.Len(t, caps, 41)
+	assert.Equal(t, "CAP_CHECKPOINT_RESTORE", caps[40])
+
+	// Bits we have no name for
+	caps, _ = parseCapabilities("40000200000")
+	assert.Equal(t, []string{"CAP_SYS_ADMIN", "42"}, caps)
+
+	caps, ok = parseCapabilities("0")
+	assert.True(t, ok)
+	assert.Equal(t, []string{}, caps)
+
+	_, ok = parseCapabilities("")
+	assert.False(t, ok)
+	_, ok = parseCapabilities("nope")
+	assert.False(t, ok)
+}
+
+func TestAuditMessageGroup_addCapabilities(t *testing.T) {
+	amg := &AuditMessageGroup{UidMap: make(map[string]string)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1322, Data: "pid=1234 cap_pi=0 cap_pp=3000 cap_pe=2000 cap_pa=0"})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1321, Data: "fver=2 fp=2000 fi=0 fe=1 old_pp=0 old_pi=0 old_pe=0 old_pa=0 pp=2000 pi=0 pe=2000 pa=0"})
+
+	assert.Equal(t, map[string][]string{
+		"cap_pi": {}, "cap_pp": {"CAP_NET_ADMIN", "CAP_NET_RAW"}, "cap_pe": {"CAP_NET_RAW"}, "cap_pa": {},
+		"fp": {"CAP_NET_RAW"}, "fi": {},
+		"old_pp": {}, "old_pi": {}, "old_pe": {}, "old_pa": {},
+		"pp": {"CAP_NET_RAW"}, "pi": {}, "pe": {"CAP_NET_RAW"}, "pa": {},
+	}, amg.Capabilities)
+	assert.Empty(t, amg.UidMap)
+
+	// Records without capability sets add nothing
+	amg = &AuditMessageGroup{UidMap: make(map[string]string)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1322, Data: "pid=1234"})
+	assert.Nil(t, amg.Capabilities)
+}
@@ -0,0 +1,237 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// How long -resolve waits on every hostname
+const resolveTimeout = 5 * time.Second
+
+// Runs -check-config, returns the exit code
+func runCheckConfig(file string, resolve bool) int {
+	config, err := loadConfig(file)
+	if err != nil {
+		el.Printf("%s: %s\n", file, err)
+		return 1
+	}
+
+	errs := checkConfig(config, resolve)
+	for _, err := range errs {
+		el.Printf("%s: %s\n", file, err)
+	}
+
+	if len(errs) > 0 {
+		return 1
+	}
+
+	l.Printf("%s is valid\n", file)
+	return 0
+}
+
+// Validates a config the way go-audit would use it, without touching the audit subsystem or opening any output
+// Every problem is returned instead of only the first, each prefixed with where in the config it is
+func checkConfig(config *viper.Viper, resolve bool) []error {
+	var errs []error
+	check := func(where string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", where, err))
+		}
+	}
+
+	_, err := createRemoteConfig(config)
+	check("remote_config", err)
+
+	multicast, err := createListenMode(config)
+	check("listen_mode", err)
+
+	if !multicast {
+		check("rules", checkAuditRules(config))
+
+		_, err = createAuditFeatures(config)
+		check("features", err)
+
+		_, err = createAuditStatus(config)
+		check("audit_status", err)
+	}
+
+	filters, err := CreateFilters(config)
+	check("filters", err)
+	if err == nil {
+		_, err = parseFilterMode(config, "filter_mode", filters)
+		check("filter_mode", err)
+	}
+
+	errs = append(errs, checkOutputs(config)...)
+
+	_, err = CreateAlerter(config)
+	check("alerts", err)
+
+	_, err = CreateEnrichers(config)
+	check("enrichers", err)
+
+	_, _, err = createControl(config)
+	check("control", err)
+
+	_, _, err = createQueryAPI(config)
+	check("query_api", err)
+
+	_, err = createMetrics(config)
+	check("metrics", err)
+
+	_, err = createHealth(config)
+	check("health", err)
+
+	_, err = CreateStatsd(config)
+	check("statsd", err)
+
+	_, err = createOsqueryExtension(config)
+	check("osquery", err)
+
+	_, err = createRulesEnforcer(config)
+	check("rules_enforce", err)
+
+	_, _, err = createRegistration(config)
+	check("audit_daemon", err)
+
+	_, err = createCompleteAfter(config)
+	check("events", err)
+
+	_, err = createDedupWindow(config)
+	check("dedup", err)
+
+	_, err = createShutdownTimeout(config)
+	check("shutdown", err)
+
+	_, err = loadTimezone(config.GetString("output.timezone"))
+	check("output.timezone", err)
+
+	if resolve {
+		errs = append(errs, resolveEndpoints(config)...)
+	}
+
+	return errs
+}
+
+// Checks the settings, format and filters of every enabled output. Outputs are created but never opened, those
+// that connect as they are created, like syslog, do connect
+func checkOutputs(config *viper.Viper) []error {
+	enabled, _, err := enabledOutputs(config)
+	if err != nil {
+		return []error{fmt.Errorf("output: %s", err)}
+	}
+
+	var errs []error
+	for _, name := range enabled {
+		if err := checkOutput(config, name); err != nil {
+			errs = append(errs, fmt.Errorf("output.%s: %s", name, err))
+		}
+	}
+
+	return errs
+}
+
+func checkOutput(config *viper.Viper, name string) error {
+	if _, err := createNamedMarshaler(config, outputFormat(config, name)); err != nil {
+		return err
+	}
+
+	if _, _, err := parseOutputFilters(config, name); err != nil {
+		return err
+	}
+
+	o, _, err := newNamedOutput(config, name)
+	if err != nil {
+		return err
+	}
+
+	return o.Close()
+}
+
+// Settings that hold a hostname, as an address, a url or a host
+var endpointKeys = map[string]bool{"address": true, "addresses": true, "url": true, "webhook_url": true, "host": true}
+
+// Resolves the hostname of every endpoint of the enabled outputs and alert sinks, of statsd and of the remote config
+func resolveEndpoints(config *viper.Viper) []error {
+	sections := []string{"remote_config."}
+	if config.GetBool("statsd.enabled") {
+		sections = append(sections, "statsd.")
+	}
+
+	for name := range outputs {
+		if config.GetBool("output." + name + ".enabled") {
+			sections = append(sections, "output."+name+".")
+		}
+	}
+
+	for _, name := range config.GetStringSlice("output.failover.outputs") {
+		sections = append(sections, "output."+name+".")
+	}
+
+	for name := range alertSinks {
+		if config.GetBool("alerts.sinks." + name + ".enabled") {
+			sections = append(sections, "alerts.sinks."+name+".")
+		}
+	}
+
+	var keys []string
+	for _, key := range config.AllKeys() {
+		for _, s := range sections {
+			if strings.HasPrefix(key, s) && endpointKeys[strings.TrimPrefix(key, s)] {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		for _, v := range config.GetStringSlice(key) {
+			host := endpointHost(v)
+			if host == "" {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+			_, err := net.DefaultResolver.LookupHost(ctx, host)
+			cancel()
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: could not resolve `%s`. Error: %s", key, host, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// The hostname in an address or url, empty for paths, ip addresses and templated values
+func endpointHost(v string) string {
+	if v == "" || strings.HasPrefix(v, "/") || strings.Contains(v, "{{") {
+		return ""
+	}
+
+	host := v
+	if strings.Contains(v, "://") {
+		u, err := url.Parse(v)
+		if err != nil {
+			return ""
+		}
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(v); err == nil {
+		host = h
+	}
+
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+
+	return host
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// A config every check passes, with the file output
+func validCheckConfig(t *testing.T) string {
+	u, err := user.Current()
+	assert.Nil(t, err)
+	g, err := user.LookupGroupId(u.Gid)
+	assert.Nil(t, err)
+
+	return fmt.Sprintf(`
+rules:
+  - -a exit,always -S execve -k exec
+filters:
+  - key: exec
+    exe_prefix: /usr/lib/
+output:
+  file:
+    enabled: true
+    attempts: 1
+    path: %s
+    mode: 0600
+    user: %s
+    group: %s
+`, path.Join(t.TempDir(), "go-audit.log"), u.Username, g.Name)
+}
+
+func Test_runCheckConfig(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	file := createTempFile(t, "check.test.yaml", validCheckConfig(t))
+	defer os.Remove(file)
+
+	assert.Equal(t, 0, runCheckConfig(file, false))
+	assert.Contains(t, lb.String(), file+" is valid\n")
+	assert.Empty(t, elb.String())
+
+	// Nothing is opened
+	c, err := loadConfig(file)
+	assert.Nil(t, err)
+	_, err = os.Stat(c.GetString("output.file.path"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Every problem is reported
+	lb.Reset()
+	assert.Nil(t, os.WriteFile(file, []byte(validCheckConfig(t)+`
+  format: nope
+dedup:
+  enabled: true
+  window: 0s
+events:
+  complete_after: 0s
+shutdown:
+  timeout: 0s
+`), 0600))
+	c, err = loadConfig(file)
+	assert.Nil(t, err)
+	c.Set("rules", []string{"-a exit,always -S execve", "-a exit,always -S nope"})
+	c.Set("filters", []interface{}{map[interface{}]interface{}{"expr": `syscall ==`}})
+
+	errs := []string{}
+	for _, err := range checkConfig(c, false) {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"rules: Failed to parse rule #2. Error: Unknown syscall `nope` for arch c000003e",
+		"filters: `expr` in filter 1 could not be parsed; Value: `syscall ==`; Error: unexpected end of expression",
+		"output.file: Unknown output format `nope`",
+		"events: events.complete_after must be greater than 0, 0s provided",
+		"dedup: dedup.window must be greater than 0, 0s provided",
+		"shutdown: shutdown.timeout must be greater than 0, 0s provided",
+	}, errs)
+
+	assert.Equal(t, 1, runCheckConfig(file, false))
+	assert.Contains(t, elb.String(), file+": output.file: Unknown output format `nope`\n")
+	assert.NotContains(t, lb.String(), "is valid")
+
+	// Syntax errors have the line
+	elb.Reset()
+	assert.Nil(t, os.WriteFile(file, []byte("rules: [\nfilters: 1\n"), 0600))
+	assert.Equal(t, 1, runCheckConfig(file, false))
+	assert.Contains(t, elb.String(), "line 2")
+}
+
+func Test_endpointHost(t *testing.T) {
+	for v, host := range map[string]string{
+		"":                                 "",
+		"/dev/log":                         "",
+		"127.0.0.1:514":                    "",
+		"[::1]:514":                        "",
+		"logstash.example.com:5170":        "logstash.example.com",
+		"mail.example.com":                 "mail.example.com",
+		"https://hooks.example.com/x":      "hooks.example.com",
+		"nats://127.0.0.1:4222":            "",
+		"https://{{.Alert.Severity}}.com/": "",
+	} {
+		assert.Equal(t, host, endpointHost(v), v)
+	}
+}
+
+func Test_resolveEndpoints(t *testing.T) {
+	c := viper.New()
+	c.Set("output.tcp.enabled", true)
+	c.Set("output.tcp.address", "localhost:5170")
+	c.Set("output.http.enabled", false)
+	c.Set("output.http.url", "https://nope.invalid/")
+	assert.Empty(t, resolveEndpoints(c))
+
+	c.Set("output.http.enabled", true)
+	errs := resolveEndpoints(c)
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0].Error(), "output.http.url: could not resolve `nope.invalid`. Error: ")
+	}
+}
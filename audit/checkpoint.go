@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint remembers the last processed sequence across restarts, see `message_tracking.checkpoint` in the example config
+// It is saved at most once per interval and when the client stops
+type checkpoint struct {
+	path     string
+	interval time.Duration
+
+	state   checkpointState
+	seen    bool // If a sequence was observed since starting
+	dirty   bool
+	nextRun time.Time
+}
+
+type checkpointState struct {
+	Sequence int    `json:"sequence"`
+	Time     string `json:"time"`
+}
+
+// Loads the checkpoint file, a missing file is a fresh start
+func loadCheckpoint(path string, interval time.Duration) (*checkpoint, error) {
+	c := &checkpoint{path: path, interval: interval}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read checkpoint file. Error: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &c.state); err != nil {
+		return nil, fmt.Errorf("Failed to parse checkpoint file %s. Error: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// Records a processed sequence, the first one after a restart is compared against the saved one to report what was missed
+func (c *checkpoint) observe(seq int, now time.Time) {
+	if !c.seen {
+		c.seen = true
+		c.report(seq)
+		c.state.Sequence = seq
+		c.dirty = true
+	} else if seq > c.state.Sequence {
+		c.state.Sequence = seq
+		c.dirty = true
+	}
+
+	if c.dirty && !now.Before(c.nextRun) {
+		if err := c.save(now); err != nil {
+			el.Println(err)
+		}
+		c.nextRun = now.Add(c.interval)
+	}
+}
+
+func (c *checkpoint) report(seq int) {
+	last := c.state.Sequence
+	switch {
+	case last == 0:
+		// Nothing saved yet
+	case seq <= last:
+		// The kernel starts counting from scratch on boot
+		el.Printf("Sequence %d is not after the checkpointed sequence %d from %s, the system likely restarted\n", seq, last, c.state.Time)
+	case seq > last+1:
+		el.Printf("Missed %d sequences while not running, checkpointed sequence %d from %s, now at %d\n", seq-last-1, last, c.state.Time, seq)
+	}
+}
+
+// Writes the checkpoint to a temporary file and moves it into place so a crash never leaves a partial file
+func (c *checkpoint) save(now time.Time) error {
+	if !c.dirty {
+		return nil
+	}
+
+	c.state.Time = now.UTC().Format(humanTimeFormat)
+	b, err := json.Marshal(&c.state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path))
+	if err != nil {
+		return fmt.Errorf("Failed to save checkpoint. Error: %s", err)
+	}
+
+	_, err = tmp.Write(append(b, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Failed to save checkpoint. Error: %s", err)
+	}
+
+	c.dirty = false
+	return nil
+}
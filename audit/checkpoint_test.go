@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint.json")
+	c, err := loadCheckpoint(path, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, c.state.Sequence, "A missing file is a fresh start")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("nope"), 0600))
+	_, err = loadCheckpoint(path, time.Second)
+	assert.EqualError(t, err, "Failed to parse checkpoint file "+path+". Error: invalid character 'o' in literal null (expecting 'u')")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"sequence":10,"time":"then"}`), 0600))
+	c, err = loadCheckpoint(path, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, checkpointState{Sequence: 10, Time: "then"}, c.state)
+}
+
+func TestCheckpoint_observe(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"sequence":10,"time":"then"}`), 0600))
+	c, err := loadCheckpoint(path, time.Second*5)
+	assert.Nil(t, err)
+
+	// The first sequence reports what was missed and is saved right away
+	now := time.Unix(10000001, 0)
+	c.observe(15, now)
+	assert.Equal(t, "Missed 4 sequences while not running, checkpointed sequence 10 from then, now at 15\n", elb.String())
+
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"sequence\":15,\"time\":\"1970-04-26T17:46:41.000Z\"}\n", string(b))
+
+	// Later ones wait for the interval, older ones are ignored
+	c.observe(17, now.Add(time.Second))
+	c.observe(16, now.Add(time.Second*2))
+	b, _ = ioutil.ReadFile(path)
+	assert.Contains(t, string(b), `"sequence":15`)
+
+	c.observe(18, now.Add(time.Second*5))
+	b, _ = ioutil.ReadFile(path)
+	assert.Contains(t, string(b), `"sequence":18`)
+
+	// Saving happens on shutdown as well
+	c.observe(19, now.Add(time.Second*6))
+	assert.Nil(t, c.save(now.Add(time.Second*7)))
+	c, err = loadCheckpoint(path, time.Second*5)
+	assert.Nil(t, err)
+	assert.Equal(t, 19, c.state.Sequence)
+
+	// The sequence going backwards means the kernel started over
+	elb.Reset()
+	c.observe(3, now)
+	assert.Equal(t, "Sequence 3 is not after the checkpointed sequence 19 from 1970-04-26T17:46:48.000Z, the system likely restarted\n", elb.String())
+	assert.Equal(t, 3, c.state.Sequence)
+
+	// Consecutive sequences are not worth mentioning
+	c, _ = loadCheckpoint(path, time.Second*5)
+	elb.Reset()
+	c.observe(4, now)
+	assert.Empty(t, elb.String())
+
+	// A fresh start has nothing to compare against
+	c = &checkpoint{path: filepath.Join(dir, "new.json")}
+	c.observe(100, now)
+	assert.Empty(t, elb.String())
+
+	// Save errors are logged
+	c = &checkpoint{path: filepath.Join(dir, "nope", "checkpoint.json")}
+	c.observe(1, now)
+	assert.Contains(t, elb.String(), "Failed to save checkpoint. Error: ")
+}
@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"fmt"
+)
+
+// Endianness is an alias for what we assume is the current machine endianness
+var Endianness = binary.LittleEndian
+
+const (
+	// MAX_AUDIT_MESSAGE_LENGTH see http://lxr.free-electrons.com/source/include/uapi/linux/audit.h#L398
+	MAX_AUDIT_MESSAGE_LENGTH = 8970
+
+	// AUDIT_NLGRP_READLOG is the multicast group that gets a read only copy of every audit record, see `listen_mode` in the example config
+	AUDIT_NLGRP_READLOG = 1
+)
+
+//TODO: this should live in a marshaller
+type AuditStatusPayload struct {
+	Mask            uint32
+	Enabled         uint32
+	Failure         uint32
+	Pid             uint32
+	RateLimit       uint32
+	BacklogLimit    uint32
+	Lost            uint32
+	Backlog         uint32
+	Version         uint32
+	BacklogWaitTime uint32
+}
+
+// NetlinkPacket is an alias to give the header a similar name here
+type NetlinkPacket syscall.NlMsghdr
+
+type NetlinkClient struct {
+	fd      int
+	address syscall.Sockaddr
+	seq     uint32
+	buf     []byte
+}
+
+// NewNetlinkClient creates a new NetLinkClient and optionally tries to modify the netlink recv buffer
+// With recvForce the size may go past net.core.rmem_max, which needs CAP_NET_ADMIN
+// With multicast the client joins the read only audit group instead of registering as the audit daemon
+func NewNetlinkClient(recvSize int, recvForce, multicast bool) (*NetlinkClient, error) {
+	var groups uint32
+	if multicast {
+		groups = 1 << (AUDIT_NLGRP_READLOG - 1)
+	}
+
+	n, err := dialNetlinkGroups(groups)
+	if err != nil {
+		if multicast {
+			err = fmt.Errorf("%s, joining the audit multicast group needs CAP_AUDIT_READ and linux 3.16 or later", err)
+		}
+		return nil, err
+	}
+
+	// Set the buffer size if we were asked
+	if recvSize > 0 {
+		if recvForce {
+			if err = syscall.SetsockoptInt(n.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, recvSize); err != nil {
+				el.Printf("Failed to force the receive buffer size, falling back to the net.core.rmem_max limit. Error: %s\n", err)
+			}
+		}
+
+		if !recvForce || err != nil {
+			if err = syscall.SetsockoptInt(n.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, recvSize); err != nil {
+				el.Println("Failed to set receive buffer size")
+			}
+		}
+	}
+
+	// Print the current receive buffer size, the kernel doubles what was asked for
+	if v, err := syscall.GetsockoptInt(n.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF); err == nil {
+		l.Println("Socket receive buffer size:", v)
+		if v < recvSize {
+			el.Printf("Socket receive buffer is smaller than the %d asked for, raise net.core.rmem_max or set socket_buffer.force\n", recvSize)
+		}
+	}
+
+	// The audit daemon already gets the records, listeners don't need to register
+	// Someone else can take the registration away, see watchRegistration
+	if multicast {
+		l.Println("Listening to the audit multicast group")
+	} else {
+		n.KeepConnection()
+	}
+
+	return n, nil
+}
+
+// dialNetlink opens a netlink audit socket without registering as the audit daemon, good for one off requests
+func dialNetlink() (*NetlinkClient, error) {
+	return dialNetlinkGroups(0)
+}
+
+// dialNetlinkGroups opens a netlink audit socket that is a member of the multicast groups in the bitmask
+func dialNetlinkGroups(groups uint32) (*NetlinkClient, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_AUDIT)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create a socket: %s", err)
+	}
+
+	n := &NetlinkClient{
+		fd:      fd,
+		address: &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups, Pid: 0},
+		buf:     make([]byte, MAX_AUDIT_MESSAGE_LENGTH+syscall.SizeofNlMsghdr), // Room for the largest record and its header
+	}
+
+	if err = syscall.Bind(fd, n.address); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Could not bind to netlink socket: %s", err)
+	}
+
+	return n, nil
+}
+
+// Send will send a packet and payload to the netlink socket without waiting for a response
+// The payload must be a fixed size struct, like AuditStatusPayload
+func (n *NetlinkClient) Send(np *NetlinkPacket, a interface{}) error {
+	//We need to get the length first. This is a bit wasteful, but requests are rare so yolo..
+	buf := new(bytes.Buffer)
+	var length int
+
+	np.Seq = atomic.AddUint32(&n.seq, 1)
+
+	for {
+		buf.Reset()
+		binary.Write(buf, Endianness, np)
+		binary.Write(buf, Endianness, a)
+		if np.Len == 0 {
+			length = len(buf.Bytes())
+			np.Len = uint32(length)
+		} else {
+			break
+		}
+	}
+
+	if err := syscall.Sendto(n.fd, buf.Bytes(), 0, n.address); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Request sends a packet and waits for the reply of replyType to it
+// Errors reported by the kernel are returned, with syscall.NLMSG_ERROR as replyType the ack itself is the reply
+func (n *NetlinkClient) Request(np *NetlinkPacket, a interface{}, replyType uint16) (*syscall.NetlinkMessage, error) {
+	if err := n.Send(np, a); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := n.Receive()
+		if err != nil {
+			return nil, err
+		}
+
+		// Anything else on the socket is not ours to handle
+		if msg.Header.Seq != np.Seq {
+			continue
+		}
+
+		if msg.Header.Type == syscall.NLMSG_ERROR {
+			if len(msg.Data) < 4 {
+				return nil, errors.New("Got a short netlink error message")
+			}
+
+			if code := int32(Endianness.Uint32(msg.Data[0:4])); code != 0 {
+				return nil, syscall.Errno(-code)
+			}
+		}
+
+		if msg.Header.Type == replyType {
+			return msg, nil
+		}
+	}
+}
+
+// Receive will receive a packet from a netlink socket
+func (n *NetlinkClient) Receive() (*syscall.NetlinkMessage, error) {
+	nlen, _, err := syscall.Recvfrom(n.fd, n.buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if nlen < 1 {
+		return nil, errors.New("Got a 0 length packet")
+	}
+
+	msg := &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   Endianness.Uint32(n.buf[0:4]),
+			Type:  Endianness.Uint16(n.buf[4:6]),
+			Flags: Endianness.Uint16(n.buf[6:8]),
+			Seq:   Endianness.Uint32(n.buf[8:12]),
+			Pid:   Endianness.Uint32(n.buf[12:16]),
+		},
+		Data: n.buf[syscall.SizeofNlMsghdr:nlen],
+	}
+
+	return msg, nil
+}
+
+// SetReceiveTimeout makes Receive return EAGAIN if nothing arrives within the timeout, 0 blocks forever
+func (n *NetlinkClient) SetReceiveTimeout(timeout time.Duration) error {
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	return syscall.SetsockoptTimeval(n.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+}
+
+// Close closes the socket
+func (n *NetlinkClient) Close() error {
+	return syscall.Close(n.fd)
+}
+
+// KeepConnection re-establishes our connection to the netlink socket
+func (n *NetlinkClient) KeepConnection() {
+	payload := &AuditStatusPayload{
+		Mask:    4,
+		Enabled: 1,
+		Pid:     uint32(syscall.Getpid()),
+		//TODO: Failure: http://lxr.free-electrons.com/source/include/uapi/linux/audit.h#L338
+	}
+
+	packet := &NetlinkPacket{
+		Type:  uint16(1001),
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Pid:   uint32(syscall.Getpid()),
+	}
+
+	err := n.Send(packet, payload)
+	if err != nil {
+		el.Println("Error occurred while trying to keep the connection:", err)
+	}
+}
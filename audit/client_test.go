@@ -1,4 +1,4 @@
-package main
+package audit
 
 import (
 	"bytes"
@@ -7,6 +7,7 @@ import (
 	"os"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func TestNetlinkClient_KeepConnection(t *testing.T) {
@@ -91,7 +92,7 @@ func TestNewNetlinkClient(t *testing.T) {
 	lb, elb := hookLogger()
 	defer resetLogger()
 
-	n, err := NewNetlinkClient(1024)
+	n, err := NewNetlinkClient(1024, false, false)
 
 	assert.Nil(t, err)
 	if n == nil {
@@ -99,14 +100,26 @@ func TestNewNetlinkClient(t *testing.T) {
 	} else {
 		assert.True(t, (n.fd > 0), "No file descriptor")
 		assert.True(t, (n.address != nil), "Address was nil")
-		assert.Equal(t, uint32(0), n.seq, "Seq should start at 0")
-		assert.True(t, MAX_AUDIT_MESSAGE_LENGTH >= len(n.buf), "Client buffer is too small")
+		assert.Equal(t, uint32(1), n.seq, "Registering as the audit daemon should be the first request")
+		assert.True(t, len(n.buf) >= MAX_AUDIT_MESSAGE_LENGTH+syscall.SizeofNlMsghdr, "Client buffer is too small")
 
 		assert.Equal(t, "Socket receive buffer size: ", lb.String()[:28], "Expected some nice log lines")
 		assert.Equal(t, "", elb.String(), "Did not expect any error messages")
 	}
 }
 
+func TestNetlinkClient_SetReceiveTimeout(t *testing.T) {
+	n := makeNelinkClient(t)
+
+	assert.Nil(t, n.SetReceiveTimeout(time.Millisecond*10))
+	_, err := n.Receive()
+	assert.Equal(t, syscall.EAGAIN, err, "Expected the receive to time out")
+
+	assert.Nil(t, n.Close())
+	_, err = n.Receive()
+	assert.Equal(t, syscall.EBADF, err, "Expected the socket to be closed")
+}
+
 // Helper to make a client listening on a unix socket
 func makeNelinkClient(t *testing.T) *NetlinkClient {
 	os.Remove("go-audit.test.sock")
@@ -0,0 +1,313 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// How long a connection waits for the receive loop to run a command
+const controlTimeout = time.Second * 5
+
+// controlCommand runs on the receive loop, so it can safely change the marshaller
+type controlCommand struct {
+	usage string
+	run   func(m *AuditMarshaller, args []string) (string, error)
+}
+
+var controlCommands = map[string]controlCommand{
+	"stats": {"stats", func(m *AuditMarshaller, args []string) (string, error) {
+		b, err := json.Marshal(struct {
+			marshallerStats
+			Pending      int                   `json:"pending"`
+			LastSequence int                   `json:"last_sequence"`
+			WorstLag     int                   `json:"worst_lag"`
+			Missing      int                   `json:"missing"`
+			OutputHealth bool                  `json:"output_healthy"`
+			Spools       map[string]spoolStats `json:"spools,omitempty"`
+		}{m.stats, len(m.msgs), m.lastSeq, m.worstLag, len(m.missed), m.writer == nil || m.writer.Healthy(), writerSpoolStats(m.writer)})
+		return string(b), err
+	}},
+
+	"filters": {"filters", func(m *AuditMarshaller, args []string) (string, error) {
+		lines := []string{}
+		for i, f := range m.filterList {
+			state := "enabled"
+			if f.disabled {
+				state = "disabled"
+			}
+			lines = append(lines, fmt.Sprintf("%d %s %s", i+1, state, f))
+		}
+
+		if len(lines) == 0 {
+			return "No filters", nil
+		}
+
+		return strings.Join(lines, "\n"), nil
+	}},
+
+	"filter": {"filter <enable|disable> <number>", func(m *AuditMarshaller, args []string) (string, error) {
+		if len(args) != 2 || (args[0] != "enable" && args[0] != "disable") {
+			return "", errors.New("Usage: filter <enable|disable> <number>")
+		}
+
+		i, err := strconv.Atoi(args[1])
+		if err != nil || i < 1 || i > len(m.filterList) {
+			return "", fmt.Errorf("Filter %s does not exist", args[1])
+		}
+
+		m.filterList[i-1].disabled = args[0] == "disable"
+		m.buildFilters()
+		l.Printf("Filter %d was %sd through the control socket\n", i, args[0])
+		return fmt.Sprintf("Filter %d %sd", i, args[0]), nil
+	}},
+
+	"log": {"log <out_of_order|flags> <value>", func(m *AuditMarshaller, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", errors.New("Usage: log <out_of_order|flags> <value>")
+		}
+
+		switch args[0] {
+		case "out_of_order":
+			v, err := strconv.ParseBool(args[1])
+			if err != nil {
+				return "", fmt.Errorf("out_of_order must be true or false; Value: `%s`", args[1])
+			}
+			m.logOutOfOrder = v
+		case "flags":
+			v, err := strconv.Atoi(args[1])
+			if err != nil {
+				return "", fmt.Errorf("flags must be a number; Value: `%s`", args[1])
+			}
+			l.SetFlags(v)
+			el.SetFlags(v)
+		default:
+			return "", fmt.Errorf("Unknown log setting `%s`", args[0])
+		}
+
+		return "ok", nil
+	}},
+
+	"flush": {"flush", func(m *AuditMarshaller, args []string) (string, error) {
+		if m.writer == nil {
+			return "No output", nil
+		}
+
+		if err := m.writer.Flush(); err != nil {
+			return "", fmt.Errorf("Failed to flush output. Error: %s", err)
+		}
+
+		return "ok", nil
+	}},
+}
+
+func init() {
+	// help lists the commands, it can't be part of the map literal it reads
+	controlCommands["help"] = controlCommand{"help", func(m *AuditMarshaller, args []string) (string, error) {
+		usage := []string{}
+		for _, cmd := range controlCommands {
+			usage = append(usage, cmd.usage)
+		}
+		sort.Strings(usage)
+		return strings.Join(usage, "\n"), nil
+	}}
+}
+
+// Reads the control socket settings, an empty path means it is disabled
+func createControl(config *viper.Viper) (string, []int, error) {
+	if !config.GetBool("control.enabled") {
+		return "", nil, nil
+	}
+
+	path := config.GetString("control.socket")
+	if path == "" {
+		return "", nil, errors.New("control.socket must be set")
+	}
+
+	uids, err := parseAllowedUids(config, "control.allowed_uids")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, uids, nil
+}
+
+// Reads a list of uids allowed to connect to a unix socket
+func parseAllowedUids(config *viper.Viper, key string) ([]int, error) {
+	// Defaults are []int, which cast.ToSlice would turn into nothing
+	if uids, ok := config.Get(key).([]int); ok {
+		return uids, nil
+	}
+
+	uids := []int{}
+	for _, v := range cast.ToSlice(config.Get(key)) {
+		uid, err := cast.ToIntE(v)
+		if err != nil || uid < 0 {
+			return nil, fmt.Errorf("%s could not be parsed; Value: `%+v`", key, v)
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, nil
+}
+
+// A command waiting to be run on the receive loop
+type controlRequest struct {
+	args  []string
+	reply chan string
+}
+
+func (r *controlRequest) run(m *AuditMarshaller) {
+	cmd, ok := controlCommands[r.args[0]]
+	if !ok {
+		r.reply <- fmt.Sprintf("error: Unknown command `%s`, try help", r.args[0])
+		return
+	}
+
+	out, err := cmd.run(m, r.args[1:])
+	if err != nil {
+		r.reply <- "error: " + err.Error()
+		return
+	}
+
+	r.reply <- out
+}
+
+// controlServer accepts commands on a unix socket, see `control` in the example config
+// Each line sent is a command, the reply is one or more lines followed by an empty line
+type controlServer struct {
+	path     string
+	uids     map[uint32]bool
+	ln       *net.UnixListener
+	requests chan *controlRequest
+}
+
+// Opens the control socket, only the owner can connect and peers are checked against uids as well
+func listenControl(path string, uids []int) (*controlServer, error) {
+	ln, err := listenOwnerUnix(path, "control")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &controlServer{
+		path:     path,
+		uids:     uidSet(uids),
+		ln:       ln,
+		requests: make(chan *controlRequest),
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn *net.UnixConn) {
+	defer conn.Close()
+
+	uid, err := peerUid(conn)
+	if err != nil || !s.uids[uid] {
+		el.Printf("Refused control connection from uid %d. Error: %v\n", uid, err)
+		fmt.Fprint(conn, "error: Not allowed\n\n")
+		return
+	}
+
+	lines := bufio.NewScanner(conn)
+	for lines.Scan() {
+		args := strings.Fields(lines.Text())
+		if len(args) == 0 {
+			continue
+		}
+
+		req := &controlRequest{args: args, reply: make(chan string, 1)}
+		reply := "error: go-audit is busy, try again"
+		select {
+		case s.requests <- req:
+			reply = <-req.reply
+		case <-time.After(controlTimeout):
+		}
+
+		if _, err := fmt.Fprintf(conn, "%s\n\n", reply); err != nil {
+			return
+		}
+	}
+}
+
+// Opens a unix socket only its owner can connect to, name is used in errors
+func listenOwnerUnix(path string, name string) (*net.UnixListener, error) {
+	// A socket left behind by a previous run would make listen fail
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s socket. Error: %s", name, err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("Failed to set %s socket permissions. Error: %s", name, err)
+	}
+
+	return ln, nil
+}
+
+func uidSet(uids []int) map[uint32]bool {
+	set := map[uint32]bool{}
+	for _, uid := range uids {
+		set[uint32(uid)] = true
+	}
+
+	return set
+}
+
+// Returns the uid of the process on the other end of a unix socket
+func peerUid(conn *net.UnixConn) (uint32, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if credErr != nil {
+		return 0, credErr
+	}
+
+	return cred.Uid, nil
+}
+
+func (s *controlServer) Close() error {
+	err := s.ln.Close()
+	os.Remove(s.path)
+	return err
+}
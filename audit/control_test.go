@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createControl(t *testing.T) {
+	c := viper.New()
+	path, uids, err := createControl(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "", path)
+	assert.Nil(t, uids)
+
+	c.Set("control.enabled", true)
+	_, _, err = createControl(c)
+	assert.EqualError(t, err, "control.socket must be set")
+
+	c.Set("control.socket", "/tmp/go-audit.sock")
+	c.Set("control.allowed_uids", []interface{}{0, "nope"})
+	_, _, err = createControl(c)
+	assert.EqualError(t, err, "control.allowed_uids could not be parsed; Value: `nope`")
+
+	c.Set("control.allowed_uids", []interface{}{0, 1000})
+	path, uids, err = createControl(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/go-audit.sock", path)
+	assert.Equal(t, []int{0, 1000}, uids)
+
+	// Like the default
+	c.Set("control.allowed_uids", []int{0})
+	_, uids, err = createControl(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0}, uids)
+}
+
+// Sends a command and reads the reply up to the empty line
+func controlCall(t *testing.T, conn net.Conn, r *bufio.Reader, cmd string) string {
+	_, err := fmt.Fprintln(conn, cmd)
+	assert.Nil(t, err)
+
+	lines := []string{}
+	for {
+		line, err := r.ReadString('\n')
+		assert.Nil(t, err)
+		if line == "\n" {
+			return strings.Join(lines, "")
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestControlServer(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Stale sockets are replaced
+	path := filepath.Join(dir, "control.sock")
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s, err := listenControl(path, []int{os.Getuid()})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	m := NewAuditMarshaller(NewAuditWriter(&bytes.Buffer{}, 1), 1300, 1399, true, false, 0, []AuditFilter{
+		{messageType: 1300, regex: regexp.MustCompile("a"), syscall: "59"},
+		{messageType: 1302, regex: regexp.MustCompile("b"), syscall: "2"},
+	}, nil)
+	m.stats.Received = 3
+
+	// Stands in for the receive loop
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case req := <-s.requests:
+				req.run(m)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	assert.Equal(t, "filter <enable|disable> <number>\nfilters\nflush\nhelp\nlog <out_of_order|flags> <value>\nstats\n", controlCall(t, conn, r, "help"))
+	assert.Equal(t, "{\"received\":3,\"completed\":0,\"filtered\":0,\"parse_errors\":0,\"missed\":0,\"kernel_lost\":0,\"takeovers\":0,\"overruns\":0,\"deduplicated\":0,\"flushed_eoe\":0,\"flushed_timeout\":0,\"pending\":0,\"last_sequence\":0,\"worst_lag\":0,\"missing\":0,\"output_healthy\":true}\n", controlCall(t, conn, r, "stats"))
+	assert.Equal(t, "1 enabled syscall=59 message_type=1300 regex=a\n2 enabled syscall=2 message_type=1302 regex=b\n", controlCall(t, conn, r, "filters"))
+
+	assert.Equal(t, "Filter 2 disabled\n", controlCall(t, conn, r, "filter disable 2"))
+	assert.Equal(t, "2 disabled syscall=2 message_type=1302 regex=b\n", strings.SplitAfter(controlCall(t, conn, r, "filters"), "\n")[1])
+	assert.NotContains(t, m.filters, "2")
+	assert.Contains(t, lb.String(), "Filter 2 was disabled through the control socket\n")
+
+	assert.Equal(t, "Filter 2 enabled\n", controlCall(t, conn, r, "  filter   enable 2 "))
+	assert.Contains(t, m.filters, "2")
+
+	assert.Equal(t, "ok\n", controlCall(t, conn, r, "log out_of_order true"))
+	assert.True(t, m.logOutOfOrder)
+	assert.Equal(t, "ok\n", controlCall(t, conn, r, "flush"))
+
+	errors := map[string]string{
+		"nope":               "error: Unknown command `nope`, try help\n",
+		"filter disable 3":   "error: Filter 3 does not exist\n",
+		"filter toggle 1":    "error: Usage: filter <enable|disable> <number>\n",
+		"log out_of_order x": "error: out_of_order must be true or false; Value: `x`\n",
+		"log flags x":        "error: flags must be a number; Value: `x`\n",
+		"log level debug":    "error: Unknown log setting `level`\n",
+		"log":                "error: Usage: log <out_of_order|flags> <value>\n",
+	}
+	for cmd, reply := range errors {
+		assert.Equal(t, reply, controlCall(t, conn, r, cmd), cmd)
+	}
+
+	// Peers that aren't allowed are refused, by a server of their own so the uids are set before it serves
+	refusing, err := listenControl(filepath.Join(dir, "refusing.sock"), []int{})
+	assert.Nil(t, err)
+	defer refusing.Close()
+
+	conn2, err := net.Dial("unix", refusing.path)
+	assert.Nil(t, err)
+	defer conn2.Close()
+	b, _ := ioutil.ReadAll(conn2)
+	assert.Equal(t, "error: Not allowed\n\n", string(b))
+	assert.Contains(t, elb.String(), fmt.Sprintf("Refused control connection from uid %d", os.Getuid()))
+
+	// Closing removes the socket
+	assert.Nil(t, s.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
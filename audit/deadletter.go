@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// deadLetter appends events that could not be written to any output to a file, see `output.dead_letter` in the example config
+// The file is opened for every event, it should rarely be used and this lets a replay move it out of the way at any time
+type deadLetter struct {
+	path    string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+// A line in the dead letter file
+type deadLetterEntry struct {
+	Time   string `json:"time"`
+	Reason string `json:"reason"`
+	Event  []byte `json:"event"` // The marshaled event as it was handed to the output, base64 encoded
+}
+
+// Creates the dead letter file handler, returns nil if `output.dead_letter.path` is not set
+func createDeadLetter(config *viper.Viper) (*deadLetter, error) {
+	path := config.GetString("output.dead_letter.path")
+	if path == "" {
+		return nil, nil
+	}
+
+	maxSize := int64(config.GetInt("output.dead_letter.max_size"))
+	if maxSize < 1 {
+		return nil, fmt.Errorf("output.dead_letter.max_size must be greater than 0, %v provided", maxSize)
+	}
+
+	return &deadLetter{path: path, maxSize: maxSize}, nil
+}
+
+// Appends an event and the reason it failed, refuses to grow the file past maxSize
+func (d *deadLetter) add(event []byte, reason error) error {
+	b, err := json.Marshal(&deadLetterEntry{
+		Time:   time.Now().UTC().Format(humanTimeFormat),
+		Reason: reason.Error(),
+		Event:  event,
+	})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open dead letter file. Error: %s", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Failed to stat dead letter file. Error: %s", err)
+	}
+
+	if info.Size()+int64(len(b)) > d.maxSize {
+		return errors.New("Dead letter file is full")
+	}
+
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("Failed to write dead letter file. Error: %s", err)
+	}
+
+	return nil
+}
+
+// Re-sends every event in the dead letter file to the writer's output, in order
+// The file is moved aside first so a running go-audit can keep adding to a fresh one
+// Replay stops at the first failure, events that were not sent are appended back to the dead letter file
+// The moved file is only removed once the output flushed, otherwise it is replayed whole the next time
+func (d *deadLetter) replay(ctx context.Context, w *OutputWriter) (sent int, err error) {
+	replayPath := d.path + ".replay"
+
+	// A previous replay that was interrupted is picked up again
+	if _, err := os.Stat(replayPath); os.IsNotExist(err) {
+		if err := os.Rename(d.path, replayPath); err != nil {
+			if os.IsNotExist(err) {
+				return 0, nil
+			}
+
+			return 0, fmt.Errorf("Failed to move the dead letter file aside. Error: %s", err)
+		}
+	}
+
+	f, err := os.Open(replayPath)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to open dead letter file. Error: %s", err)
+	}
+	defer f.Close()
+
+	var failed error
+	var offset int64 // Where the entries that were not sent start
+	lines := bufio.NewScanner(f)
+	lines.Buffer(make([]byte, 64*1024), int(d.maxSize))
+
+	for failed == nil && lines.Scan() {
+		entry := &deadLetterEntry{}
+		if err := json.Unmarshal(lines.Bytes(), entry); err != nil {
+			el.Printf("Skipping dead letter entry %d, it could not be parsed. Error: %s\n", sent+1, err)
+		} else if failed = w.writeRaw(ctx, entry.Event); failed == nil {
+			sent++
+		} else {
+			break
+		}
+
+		offset += int64(len(lines.Bytes())) + 1
+	}
+
+	if err := lines.Err(); err != nil {
+		return sent, fmt.Errorf("Failed to read dead letter file. Error: %s", err)
+	}
+
+	// Events written so far may still be buffered, keep the whole file if they can't be delivered
+	if err := w.Flush(); err != nil {
+		return sent, fmt.Errorf("Failed to flush the replayed dead letter events, %s will be replayed again. Error: %s", replayPath, err)
+	}
+
+	if failed != nil {
+		// Keep everything from the first failure on, in order
+		if err := d.restore(f, offset); err != nil {
+			return sent, err
+		}
+	}
+
+	os.Remove(replayPath)
+
+	if failed != nil {
+		return sent, fmt.Errorf("Stopped replaying the dead letter file after %d events. Error: %s", sent, failed)
+	}
+
+	return sent, nil
+}
+
+// Puts the entries from offset on back, the size limit does not apply so nothing is lost
+func (d *deadLetter) restore(from *os.File, offset int64) error {
+	if _, err := from.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("Failed to read dead letter file. Error: %s", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open dead letter file. Error: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, from); err != nil {
+		return fmt.Errorf("Failed to write dead letter file. Error: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createDeadLetter(t *testing.T) {
+	c := viper.New()
+	d, err := createDeadLetter(c)
+	assert.Nil(t, err)
+	assert.Nil(t, d, "No path should disable the dead letter file")
+
+	c.Set("output.dead_letter.path", "/tmp/dl")
+	_, err = createDeadLetter(c)
+	assert.EqualError(t, err, "output.dead_letter.max_size must be greater than 0, 0 provided")
+
+	c.Set("output.dead_letter.max_size", 10)
+	d, err = createDeadLetter(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &deadLetter{path: "/tmp/dl", maxSize: 10}, d)
+}
+
+func TestAuditWriter_deadLetter(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	o := NewWriterOutput(&FailWriter{})
+	w := NewAuditWriter(o, 1)
+	w.deadLetter = &deadLetter{path: filepath.Join(dir, "dl"), maxSize: 1024}
+
+	// Failed writes go to the dead letter file instead of failing
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 1, AuditTime: "1"}))
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 2, AuditTime: "2"}))
+	assert.Contains(t, elb.String(), "Wrote sequence 1 to the dead letter file. Error: derp\n")
+
+	b, err := ioutil.ReadFile(w.deadLetter.path)
+	assert.Nil(t, err)
+	assert.Regexp(t, `^\{"time":"[^"]+","reason":"derp","event":"[A-Za-z0-9+/=]+"\}\n\{.+\}\n$`, string(b))
+
+	// The file is bounded
+	w.deadLetter.maxSize = int64(len(b)) + 10
+	err = w.Write(context.Background(), &AuditMessageGroup{Seq: 3, AuditTime: "3"})
+	assert.EqualError(t, err, "derp, the dead letter file could not be written either. Error: Dead letter file is full")
+
+	// Replay stops at the first failure and keeps the rest
+	fw := &flakyWriter{ok: 1}
+	o.w = fw
+	sent, err := w.deadLetter.replay(context.Background(), w)
+	assert.Equal(t, 1, sent)
+	assert.EqualError(t, err, "Stopped replaying the dead letter file after 1 events. Error: derp")
+	assert.Equal(t, "{\"schema_version\":2,\"sequence\":1,\"timestamp\":\"1\",\"messages\":null,\"uid_map\":null}\n", fw.b.String())
+
+	b2, err := ioutil.ReadFile(w.deadLetter.path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(bytes.SplitAfter(b, []byte("\n"))[1]), string(b2))
+
+	// Once the output is back everything is sent and the file is gone
+	fw = &flakyWriter{ok: 10}
+	o.w = fw
+	sent, err = w.deadLetter.replay(context.Background(), w)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, "{\"schema_version\":2,\"sequence\":2,\"timestamp\":\"2\",\"messages\":null,\"uid_map\":null}\n", fw.b.String())
+
+	_, err = os.Stat(w.deadLetter.path)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(w.deadLetter.path + ".replay")
+	assert.True(t, os.IsNotExist(err))
+
+	// Nothing to replay
+	sent, err = w.deadLetter.replay(context.Background(), w)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sent)
+}
+
+// unflushedOutput takes writes but fails to flush them
+type unflushedOutput struct {
+	*WriterOutput
+}
+
+func (u *unflushedOutput) Flush() error {
+	return errors.New("flush derp")
+}
+
+func TestDeadLetter_replayFlushFails(t *testing.T) {
+	hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &deadLetter{path: filepath.Join(dir, "dl"), maxSize: 1024}
+	assert.Nil(t, d.add([]byte("one\n"), errors.New("derp")))
+	assert.Nil(t, d.add([]byte("two\n"), errors.New("derp")))
+	b, err := ioutil.ReadFile(d.path)
+	assert.Nil(t, err)
+
+	// The writes may never have left the buffer, everything is kept for the next replay
+	fw := &flakyWriter{ok: 10}
+	w := NewAuditWriter(&unflushedOutput{NewWriterOutput(fw)}, 1)
+	sent, err := d.replay(context.Background(), w)
+	assert.Equal(t, 2, sent)
+	assert.EqualError(t, err, "Failed to flush the replayed dead letter events, "+d.path+".replay will be replayed again. Error: flush derp")
+
+	b2, err := ioutil.ReadFile(d.path + ".replay")
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), string(b2))
+	_, err = os.Stat(d.path)
+	assert.True(t, os.IsNotExist(err), "Nothing should have been restored")
+
+	// Same when a write failed too, nothing is restored twice
+	fw.ok = 1
+	sent, err = d.replay(context.Background(), w)
+	assert.Equal(t, 1, sent)
+	assert.NotNil(t, err)
+	b2, err = ioutil.ReadFile(d.path + ".replay")
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), string(b2))
+	_, err = os.Stat(d.path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Once flushing works the file is replayed whole
+	fw = &flakyWriter{ok: 10}
+	sent, err = d.replay(context.Background(), NewAuditWriter(NewWriterOutput(fw), 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, "one\ntwo\n", fw.b.String())
+	_, err = os.Stat(d.path + ".replay")
+	assert.True(t, os.IsNotExist(err))
+}
+
+// flakyWriter accepts ok writes and fails after that
+type flakyWriter struct {
+	ok int
+	b  bytes.Buffer
+}
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	if f.ok == 0 {
+		return (&FailWriter{}).Write(p)
+	}
+
+	f.ok--
+	return f.b.Write(p)
+}
@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DedupSummary is set on an event that stands in for identical ones seen within `dedup.window` of it
+type DedupSummary struct {
+	Count int    `json:"count"` // Identical events seen, this one included
+	Last  string `json:"last"`  // The audit timestamp of the last one
+}
+
+// deduper holds the first event of every exe, syscall, uid, saddr, argv, path names, result and keys for window,
+// identical events arriving in the meantime are only counted. Held events are written once the window is over, so every event is delayed by it
+// It is only used from the receive loop and is not safe for concurrent use
+type deduper struct {
+	window time.Duration
+	held   map[string]*heldEvent
+}
+
+type heldEvent struct {
+	msg     *AuditMessageGroup
+	expires time.Time
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{window: window, held: map[string]*heldEvent{}}
+}
+
+// Holds the event or folds it into the held one it is identical to, false if it should be written right away
+func (d *deduper) hold(msg *AuditMessageGroup, now time.Time) bool {
+	key, ok := dedupKey(msg)
+	if !ok {
+		return false
+	}
+
+	if h, ok := d.held[key]; ok {
+		if h.msg.Dedup == nil {
+			h.msg.Dedup = &DedupSummary{Count: 1}
+		}
+		h.msg.Dedup.Count++
+		h.msg.Dedup.Last = msg.AuditTime
+		return true
+	}
+
+	// Too many distinct events to keep track of, let the new ones through as they are
+	if len(d.held) >= defaultMaxTracked {
+		return false
+	}
+
+	d.held[key] = &heldEvent{msg: msg, expires: now.Add(d.window)}
+	return true
+}
+
+// Takes out the held events whose window is over, or all of them, in sequence order
+func (d *deduper) expired(now time.Time, all bool) []*AuditMessageGroup {
+	var out []*AuditMessageGroup
+	for key, h := range d.held {
+		if all || !h.expires.After(now) {
+			out = append(out, h.msg)
+			delete(d.held, key)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Seq < out[j].Seq })
+	return out
+}
+
+// What makes two events identical, only syscall events are deduplicated
+func dedupKey(msg *AuditMessageGroup) (string, bool) {
+	if msg.Syscall == "" {
+		return "", false
+	}
+
+	exe, _ := msg.findField(1300, "exe")
+	saddr, _ := msg.findField(1306, "saddr")
+
+	uid := ""
+	if u := msg.Uids["uid"]; u != nil {
+		uid = u.ID
+	}
+
+	// A failed attempt is not the same as the one that worked, nor is the same binary run on other files
+	success, exit := "", ""
+	if msg.Success != nil {
+		success = strconv.FormatBool(*msg.Success)
+	}
+
+	if msg.Exit != nil {
+		exit = strconv.FormatInt(*msg.Exit, 10)
+	}
+
+	paths := []string{}
+	for _, p := range msg.Paths {
+		if p != nil {
+			paths = append(paths, p.Name)
+		}
+	}
+
+	return strings.Join([]string{
+		exe, msg.Syscall, uid, saddr, success, exit,
+		strings.Join(msg.Argv, "\x01"),
+		strings.Join(paths, "\x01"),
+		strings.Join(msg.Keys, "\x01"),
+	}, "\x00"), true
+}
+
+// Writes the held events whose window is over, or all of them when shutting down
+func (a *AuditMarshaller) flushDedup(ctx context.Context, all bool) {
+	if a.dedup == nil {
+		return
+	}
+
+	for _, msg := range a.dedup.expired(time.Now(), all) {
+		if msg.Dedup != nil {
+			a.stats.Deduplicated += uint64(msg.Dedup.Count - 1)
+		}
+		a.write(ctx, msg)
+	}
+}
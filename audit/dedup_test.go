@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_deduper(t *testing.T) {
+	d := newDeduper(time.Second)
+	now := time.Now()
+	event := func(seq int, exe string, saddr string) *AuditMessageGroup {
+		msgs := []*AuditMessage{{Type: 1300, Data: fmt.Sprintf(`syscall=42 exe="%s"`, exe)}}
+		if saddr != "" {
+			msgs = append(msgs, &AuditMessage{Type: 1306, Data: "saddr=" + saddr})
+		}
+
+		return &AuditMessageGroup{
+			Seq:       seq,
+			AuditTime: fmt.Sprintf("10000001.%03d", seq),
+			Syscall:   "42",
+			Msgs:      msgs,
+			Uids:      map[string]*UidName{"uid": {ID: "0", Name: "root"}},
+		}
+	}
+
+	assert.True(t, d.hold(event(1, "/bin/curl", "020000507F000001"), now))
+	assert.True(t, d.hold(event(2, "/bin/curl", "020000507F000001"), now))
+	assert.True(t, d.hold(event(3, "/bin/curl", "020000507F000001"), now.Add(500*time.Millisecond)))
+
+	// A different destination or exe is a different event
+	assert.True(t, d.hold(event(4, "/bin/curl", "020001BB7F000001"), now.Add(100*time.Millisecond)))
+	assert.True(t, d.hold(event(5, "/bin/wget", "020000507F000001"), now))
+
+	// So are other arguments, paths, results or keys
+	differ := []func(*AuditMessageGroup){
+		func(m *AuditMessageGroup) { m.Argv = []string{"curl", "https://example.com"} },
+		func(m *AuditMessageGroup) { m.Paths = []*AuditPath{{Name: "/etc/shadow"}, nil} },
+		func(m *AuditMessageGroup) { m.Success = new(bool) },
+		func(m *AuditMessageGroup) { m.Exit = new(int64) },
+		func(m *AuditMessageGroup) { m.Keys = []string{"egress"} },
+	}
+	for i, f := range differ {
+		msg := event(10+i, "/bin/curl", "020000507F000001")
+		f(msg)
+		assert.True(t, d.hold(msg, now))
+		assert.Nil(t, d.held[mustDedupKey(msg)].msg.Dedup, "Event %d should be held on its own", 10+i)
+		delete(d.held, mustDedupKey(msg))
+	}
+
+	// Argv elements can't run together into the same key
+	a := event(20, "/bin/curl", "")
+	a.Argv = []string{"a b", "c"}
+	b := event(21, "/bin/curl", "")
+	b.Argv = []string{"a", "b c"}
+	assert.NotEqual(t, mustDedupKey(a), mustDedupKey(b))
+
+	// Events without a syscall go through as they are
+	assert.False(t, d.hold(&AuditMessageGroup{Seq: 6, Msgs: []*AuditMessage{{Type: 1112, Data: "op=login"}}}, now))
+
+	assert.Empty(t, d.expired(now.Add(999*time.Millisecond), false))
+
+	out := d.expired(now.Add(time.Second), false)
+	if assert.Len(t, out, 2) {
+		assert.Equal(t, 1, out[0].Seq)
+		assert.Equal(t, &DedupSummary{Count: 3, Last: "10000001.003"}, out[0].Dedup)
+		assert.Equal(t, 5, out[1].Seq)
+		assert.Nil(t, out[1].Dedup, "Events nothing was folded into are left alone")
+	}
+
+	out = d.expired(now, true)
+	if assert.Len(t, out, 1) {
+		assert.Equal(t, 4, out[0].Seq)
+	}
+	assert.Empty(t, d.held)
+}
+
+func mustDedupKey(msg *AuditMessageGroup) string {
+	key, _ := dedupKey(msg)
+	return key
+}
+
+func TestAuditMarshaller_dedup(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+	m.dedup = newDeduper(time.Hour)
+
+	var subscribed int
+	m.subscribers = []func(*AuditMessageGroup){func(*AuditMessageGroup) { subscribed++ }}
+
+	for seq := 1; seq <= 3; seq++ {
+		m.Consume(context.Background(), newNlMsg(1300, fmt.Sprintf(`audit(10000001.%03d:%d): syscall=42 exe="/bin/curl"`, seq, seq)))
+		m.Consume(context.Background(), new1320(fmt.Sprint(seq)))
+	}
+
+	// Subscribers still see every event, the output waits for the window
+	assert.Equal(t, 3, subscribed)
+	assert.Empty(t, w.String())
+
+	m.flushDedup(context.Background(), true)
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if assert.Len(t, lines, 1) {
+		var got map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(lines[0]), &got))
+		assert.Equal(t, float64(1), got["sequence"])
+		assert.Equal(t, map[string]interface{}{"count": float64(3), "last": "10000001.003"}, got["dedup"])
+	}
+	assert.Equal(t, uint64(2), m.stats.Deduplicated)
+}
+
+func TestAuditMarshaller_flushAll(t *testing.T) {
+	r := &recordingWriter{}
+	m := NewAuditMarshaller(r, 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+	m.dedup = newDeduper(time.Hour)
+	m.completeAfter = time.Hour
+
+	ctx := context.Background()
+	m.Consume(ctx, newNlMsg(1300, `audit(10000001.001:1): arch=c000003e syscall=42 exe="/bin/curl"`))
+	m.Consume(ctx, new1320("1"))
+	m.Consume(ctx, newNlMsg(1300, "audit(10000001.002:3): hi"))
+	m.Consume(ctx, newNlMsg(1300, "audit(10000001.002:2): hi"))
+	assert.Empty(t, r.msgs)
+
+	// Pending groups go out in sequence order, then whatever dedup held
+	assert.Equal(t, 2, m.flushAll(ctx))
+	if assert.Len(t, r.msgs, 3) {
+		assert.Equal(t, 2, r.msgs[0].Seq)
+		assert.Equal(t, 3, r.msgs[1].Seq)
+		assert.Equal(t, 1, r.msgs[2].Seq)
+	}
+	assert.Empty(t, m.msgs)
+	assert.Equal(t, 0, m.flushAll(ctx))
+}
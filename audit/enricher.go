@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// Enricher adds information to a complete message group before it is written
+// Returning an error is logged but does not stop the message group from being written
+// Any lookups done by an enricher should respect the context
+type Enricher func(ctx context.Context, msg *AuditMessageGroup) error
+
+// EnricherFactory creates an Enricher that needs configuration, settings should be read from `enrichers.<name>`
+type EnricherFactory func(config *viper.Viper) (Enricher, error)
+
+// NamedEnricher is an enricher and its place in line, enrichers run in ascending order and by name within one order
+type NamedEnricher struct {
+	Name    string
+	Order   int
+	Fn      Enricher
+	factory EnricherFactory
+}
+
+var enrichers = map[string]NamedEnricher{}
+
+// RegisterEnricher makes an Enricher available to the marshaller, enrichers run in ascending order
+// Enrichers are enabled by default, `enrichers.<name>.enabled` and `enrichers.<name>.order` can override that
+// This is meant to be called from an init() func, registering the same name twice will panic
+func RegisterEnricher(name string, order int, e Enricher) {
+	if _, ok := enrichers[name]; ok {
+		panic(fmt.Sprintf("Enricher `%s` is already registered", name))
+	}
+
+	enrichers[name] = NamedEnricher{Name: name, Order: order, Fn: e}
+}
+
+// RegisterEnricherFactory is RegisterEnricher for enrichers that need configuration
+// The factory is only called if the enricher is enabled
+func RegisterEnricherFactory(name string, order int, factory EnricherFactory) {
+	if _, ok := enrichers[name]; ok {
+		panic(fmt.Sprintf("Enricher `%s` is already registered", name))
+	}
+
+	enrichers[name] = NamedEnricher{Name: name, Order: order, factory: factory}
+}
+
+// CreateEnrichers builds the ordered list of registered enrichers `enrichers` in the config leaves enabled
+func CreateEnrichers(config *viper.Viper) ([]NamedEnricher, error) {
+	enabled := []NamedEnricher{}
+
+	for name, e := range enrichers {
+		key := "enrichers." + name
+		if config.IsSet(key+".enabled") && !config.GetBool(key+".enabled") {
+			l.Printf("Enricher `%s` is disabled\n", name)
+			continue
+		}
+
+		if config.IsSet(key + ".order") {
+			e.Order = config.GetInt(key + ".order")
+		}
+
+		if e.factory != nil {
+			fn, err := e.factory(config)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create enricher %s. Error: %s", name, err)
+			}
+			e.Fn = fn
+		}
+
+		enabled = append(enabled, e)
+	}
+
+	sort.Slice(enabled, func(i, j int) bool {
+		if enabled[i].Order == enabled[j].Order {
+			return enabled[i].Name < enabled[j].Name
+		}
+
+		return enabled[i].Order < enabled[j].Order
+	})
+
+	for _, e := range enabled {
+		l.Printf("Enricher `%s` enabled with order %d\n", e.Name, e.Order)
+	}
+
+	return enabled, nil
+}
+
+// SetExtra stores a value under `extra` in the output, creating the map if needed
+func (amg *AuditMessageGroup) SetExtra(key string, value interface{}) {
+	if amg.Extra == nil {
+		amg.Extra = make(map[string]interface{}, 1)
+	}
+
+	amg.Extra[key] = value
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterEnricherFactory("attack", 50, createAttackEnricher)
+}
+
+// attackMapping tags message groups matching the conditions with a MITRE ATT&CK technique id
+type attackMapping struct {
+	technique string
+	rule      *AlertRule
+}
+
+// Built in mappings, syscalls are given by name so they match on every arch
+var defaultAttackMappings = concatAttackMappings(
+	execAttackMappings("T1059.004", "sh", "[bd]ash", "[kz]sh"),
+	execAttackMappings("T1059.006", "python*"),
+	execAttackMappings("T1105", "curl", "wget"),
+	execAttackMappings("T1140", "base64"),
+	execAttackMappings("T1033", "whoami"),
+	execAttackMappings("T1082", "uname"),
+	execAttackMappings("T1053.003", "crontab"),
+	execAttackMappings("T1136.001", "useradd", "adduser"),
+	execAttackMappings("T1222.002", "chmod"),
+	execAttackMappings("T1547.006", "insmod", "modprobe"),
+	execAttackMappings("T1562.004", "iptables*", "nft"),
+	execAttackMappings("T1562.012", "auditctl"),
+	[]map[interface{}]interface{}{
+		{"technique": "T1222.002", "syscall": "chmod"},
+		{"technique": "T1222.002", "syscall": "fchmod"},
+		{"technique": "T1222.002", "syscall": "fchmodat"},
+		{"technique": "T1055.008", "syscall": "ptrace"},
+		{"technique": "T1547.006", "syscall": "init_module"},
+		{"technique": "T1547.006", "syscall": "finit_module"},
+	},
+)
+
+// Maps execve of any of the binaries to the technique, binaries are globs looked for in the usual bin directories
+func execAttackMappings(technique string, names ...string) []map[interface{}]interface{} {
+	mappings := []map[interface{}]interface{}{}
+	for _, name := range names {
+		for _, dir := range []string{"/*bin/", "/usr/*bin/", "/usr/local/*bin/"} {
+			mappings = append(mappings, map[interface{}]interface{}{"technique": technique, "syscall": "execve", "exe": dir + name})
+		}
+	}
+	return mappings
+}
+
+func concatAttackMappings(lists ...[]map[interface{}]interface{}) []map[interface{}]interface{} {
+	mappings := []map[interface{}]interface{}{}
+	for _, l := range lists {
+		mappings = append(mappings, l...)
+	}
+	return mappings
+}
+
+func createAttackEnricher(config *viper.Viper) (Enricher, error) {
+	raw := []interface{}{}
+	if !config.IsSet("enrichers.attack.builtin") || config.GetBool("enrichers.attack.builtin") {
+		for _, m := range defaultAttackMappings {
+			raw = append(raw, m)
+		}
+	}
+
+	if ms := config.Get("enrichers.attack.mappings"); ms != nil {
+		mt, ok := ms.([]interface{})
+		if !ok {
+			return nil, errors.New("Could not parse enrichers.attack.mappings object")
+		}
+		raw = append(raw, mt...)
+	}
+
+	mappings, err := parseAttackMappings(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, msg *AuditMessageGroup) error {
+		var techniques []string
+		seen := map[string]bool{}
+		parsed := groupFields{}
+
+		for _, m := range mappings {
+			if !seen[m.technique] && m.rule.matchesParsed(msg, parsed) {
+				seen[m.technique] = true
+				techniques = append(techniques, m.technique)
+			}
+		}
+
+		if len(techniques) > 0 {
+			msg.SetExtra("attack", techniques)
+		}
+
+		return nil
+	}, nil
+}
+
+// Mappings take the same conditions as alert rules plus the technique id
+func parseAttackMappings(raw []interface{}) ([]attackMapping, error) {
+	mappings := []attackMapping{}
+
+	for i, r := range raw {
+		r2, ok := r.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Could not parse attack mapping %d; '%+v'", i+1, r)
+		}
+
+		where := fmt.Sprintf("attack mapping %d", i+1)
+		m := attackMapping{rule: &AlertRule{}}
+		for k, v := range r2 {
+			if k == "technique" {
+				var err error
+				if m.technique, err = alertRuleString(k, v, where); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			known, err := m.rule.parseCondition(k, v, where)
+			if err != nil {
+				return nil, err
+			}
+
+			if !known {
+				return nil, fmt.Errorf("Unknown condition `%v` in %s", k, where)
+			}
+		}
+
+		if m.technique == "" {
+			return nil, fmt.Errorf("Attack mapping %d is missing the `technique` entry", i+1)
+		}
+
+		mappings = append(mappings, m)
+	}
+
+	return mappings, nil
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseAttackMappings(t *testing.T) {
+	tests := []struct {
+		mapping interface{}
+		err     string
+	}{
+		{"bad", "Could not parse attack mapping 1; 'bad'"},
+		{alertRule("technique", []string{}), "`technique` in attack mapping 1 could not be parsed; Value: `[]`"},
+		{alertRule("technique", "T1", "exe", "["), "`exe` in attack mapping 1 could not be parsed; Value: `[`; Error: syntax error in pattern"},
+		{alertRule("technique", "T1", "nope", 1), "Unknown condition `nope` in attack mapping 1"},
+		{alertRule("key", "a"), "Attack mapping 1 is missing the `technique` entry"},
+	}
+
+	for _, test := range tests {
+		_, err := parseAttackMappings([]interface{}{test.mapping})
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func toInterfaces(ms []map[interface{}]interface{}) []interface{} {
+	r := []interface{}{}
+	for _, m := range ms {
+		r = append(r, m)
+	}
+	return r
+}
+
+func Test_createAttackEnricher(t *testing.T) {
+	c := viper.New()
+	c.Set("enrichers.attack.mappings", "bad")
+	_, err := createAttackEnricher(c)
+	assert.EqualError(t, err, "Could not parse enrichers.attack.mappings object")
+
+	c.Set("enrichers.attack.mappings", []interface{}{
+		alertRule("technique", "T1098.004", "key", "ssh_keys"),
+		alertRule("technique", "T1059.004", "key", "ssh_keys"),
+	})
+	e, err := createAttackEnricher(c)
+	assert.Nil(t, err)
+
+	// built in
+	msg := &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 exe="/usr/bin/bash" key=(null)`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"attack": []string{"T1059.004"}}, msg.Extra)
+
+	// configured, the same technique is only listed once
+	msg = &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 exe="/bin/sh" key="ssh_keys"`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"attack": []string{"T1059.004", "T1098.004"}}, msg.Extra)
+
+	// nothing matches
+	msg = &AuditMessageGroup{Syscall: "2", SyscallName: "open", Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=2 exe="/bin/sh"`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Nil(t, msg.Extra)
+
+	// without the built in mappings
+	c.Set("enrichers.attack.builtin", false)
+	e, err = createAttackEnricher(c)
+	assert.Nil(t, err)
+	msg = &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 exe="/usr/bin/bash" key="ssh_keys"`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"attack": []string{"T1098.004", "T1059.004"}}, msg.Extra)
+}
+
+func Test_defaultAttackMappings(t *testing.T) {
+	mappings, err := parseAttackMappings(toInterfaces(defaultAttackMappings))
+	assert.Nil(t, err)
+
+	tagSyscall := func(syscall, name, exe string) []string {
+		tags := []string{}
+		msg := &AuditMessageGroup{Syscall: syscall, SyscallName: name, Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=` + syscall + ` exe="` + exe + `"`}}}
+		for _, m := range mappings {
+			if m.rule.matches(msg) {
+				tags = append(tags, m.technique)
+			}
+		}
+		return tags
+	}
+	tag := func(exe string) []string { return tagSyscall("59", "execve", exe) }
+
+	assert.Equal(t, []string{"T1059.004"}, tag("/bin/bash"))
+	assert.Equal(t, []string{"T1059.004"}, tag("/usr/bin/zsh"))
+	assert.Equal(t, []string{}, tag("/usr/bin/ssh"))
+	assert.Equal(t, []string{"T1562.012"}, tag("/usr/sbin/auditctl"))
+	assert.Equal(t, []string{"T1059.006"}, tag("/usr/local/bin/python3.11"))
+
+	// Syscalls are matched by name, execve is 221 on aarch64
+	assert.Equal(t, []string{"T1059.004"}, tagSyscall("221", "execve", "/bin/bash"))
+	assert.Equal(t, []string{}, tagSyscall("59", "", "/bin/bash"), "Unknown syscalls should not match")
+	assert.Equal(t, []string{"T1222.002"}, tagSyscall("53", "fchmodat", "/usr/bin/python3"))
+	assert.Equal(t, []string{"T1547.006"}, tagSyscall("273", "finit_module", "/usr/bin/kmod"))
+}
+
+func Benchmark_attackEnricher(b *testing.B) {
+	e, err := createAttackEnricher(viper.New())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	msg := &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{
+		Type: 1300,
+		Data: `arch=c000003e syscall=59 success=yes exit=0 a0=cc4e68 a1=d10bc8 a2=c69808 a3=7fff2a700900 items=2 ppid=11552 pid=11623 auid=1000 uid=1000 gid=1000 euid=1000 suid=1000 fsuid=1000 egid=1000 sgid=1000 fsgid=1000 tty=pts0 ses=35 comm="ls" exe="/bin/ls" key=(null)`,
+	}}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.Extra = nil
+		e(context.Background(), msg)
+	}
+}
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterEnricherFactory("first_seen", 60, createFirstSeenEnricher)
+}
+
+// seenSet is an lru of keys, optionally persisted to an append only file that is compacted as it grows
+type seenSet struct {
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+
+	path    string
+	file    *os.File
+	written int
+}
+
+func newSeenSet(max int, path string) (*seenSet, error) {
+	s := &seenSet{max: max, order: list.New(), entries: map[string]*list.Element{}, path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			s.touch(sc.Text())
+			s.written++
+		}
+		f.Close()
+
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Marks the key as recently seen, true is returned if it was not in the set
+func (s *seenSet) touch(key string) bool {
+	if e, ok := s.entries[key]; ok {
+		s.order.MoveToFront(e)
+		return false
+	}
+
+	s.entries[key] = s.order.PushFront(key)
+	if s.order.Len() > s.max {
+		delete(s.entries, s.order.Remove(s.order.Back()).(string))
+	}
+
+	return true
+}
+
+// Records the key, true is returned if it had not been seen before
+// The file is rewritten from the lru once it holds twice as many lines as the lru does
+func (s *seenSet) add(key string) (bool, error) {
+	if !s.touch(key) {
+		return false, nil
+	}
+
+	if s.file == nil {
+		return true, nil
+	}
+
+	if _, err := fmt.Fprintln(s.file, key); err != nil {
+		return true, err
+	}
+
+	s.written++
+	if s.written > s.max*2 {
+		return true, s.compact()
+	}
+
+	return true, nil
+}
+
+// Rewrites the file with only what is in the lru, least recently seen first so reloading keeps the order
+func (s *seenSet) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		fmt.Fprintln(w, e.Value.(string))
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	s.written = s.order.Len()
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+// Flags execve of executables that have not been seen before with `extra.first_seen`
+func createFirstSeenEnricher(config *viper.Viper) (Enricher, error) {
+	max := config.GetInt("enrichers.first_seen.max_entries")
+	if max < 1 {
+		max = 100000
+	}
+
+	path := config.GetString("enrichers.first_seen.state_file")
+	seen, err := newSeenSet(max, path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load enrichers.first_seen.state_file %s. Error: %s", path, err)
+	}
+
+	perUser := config.GetBool("enrichers.first_seen.per_user")
+
+	return func(ctx context.Context, msg *AuditMessageGroup) error {
+		// SyscallName is resolved for the arch of the event, so this works beyond x86_64
+		if msg.SyscallName != "execve" && msg.SyscallName != "execveat" {
+			return nil
+		}
+
+		data, ok := msg.firstMessage(1300)
+		if !ok {
+			return nil
+		}
+
+		fields := parseFields(data)
+		if fields["success"] == "no" || fields["exe"] == "" {
+			return nil
+		}
+
+		// Keys are stored one per line
+		key := strings.Replace(fields["exe"], "\n", "\\n", -1)
+		if perUser {
+			key = fields["uid"] + ":" + key
+		}
+
+		isNew, err := seen.add(key)
+		if isNew {
+			msg.SetExtra("first_seen", true)
+		}
+
+		return err
+	}, nil
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeenSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen")
+
+	s, err := newSeenSet(2, path)
+	assert.Nil(t, err)
+
+	isNew, err := s.add("a")
+	assert.True(t, isNew)
+	assert.Nil(t, err)
+
+	isNew, err = s.add("a")
+	assert.False(t, isNew)
+	assert.Nil(t, err)
+
+	s.add("b")
+	s.add("a")
+	s.add("c")
+	assert.NotContains(t, s.entries, "b", "The least recently seen entry should be dropped")
+
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "a\nb\nc\n", string(b))
+
+	// compacted once the file is more than twice the size of the lru
+	s.add("d")
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "a\nb\nc\nd\n", string(b))
+	s.add("e")
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "d\ne\n", string(b))
+
+	// reloading keeps the most recent entries
+	s, err = newSeenSet(2, path)
+	assert.Nil(t, err)
+	assert.Len(t, s.entries, 2)
+	assert.Contains(t, s.entries, "d")
+	assert.Contains(t, s.entries, "e")
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "d\ne\n", string(b))
+
+	// bad state file location
+	_, err = newSeenSet(2, filepath.Join(dir, "nope", "seen"))
+	assert.NotNil(t, err)
+}
+
+func Test_createFirstSeenEnricher(t *testing.T) {
+	c := viper.New()
+	c.Set("enrichers.first_seen.state_file", "/tmp/go-audit-does-not-exist/seen")
+	_, err := createFirstSeenEnricher(c)
+	assert.True(t, strings.HasPrefix(err.Error(), "Could not load enrichers.first_seen.state_file /tmp/go-audit-does-not-exist/seen. Error: "), err.Error())
+
+	exec := func(uid string, exe string) *AuditMessageGroup {
+		return &AuditMessageGroup{
+			Syscall:     "59",
+			SyscallName: "execve",
+			Msgs:        []*AuditMessage{{Type: 1300, Data: `syscall=59 success=yes uid=` + uid + ` exe="` + exe + `"`}},
+		}
+	}
+
+	// in memory
+	c = viper.New()
+	e, err := createFirstSeenEnricher(c)
+	assert.Nil(t, err)
+
+	msg := exec("0", "/usr/bin/curl")
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"first_seen": true}, msg.Extra)
+
+	msg = exec("1000", "/usr/bin/curl")
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Nil(t, msg.Extra)
+
+	// other syscalls are ignored
+	msg = exec("0", "/usr/bin/wget")
+	msg.Syscall, msg.SyscallName = "2", "open"
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Nil(t, msg.Extra)
+
+	// execve on aarch64
+	msg = exec("0", "/usr/bin/wget")
+	msg.Syscall = "221"
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"first_seen": true}, msg.Extra)
+
+	// per user
+	c.Set("enrichers.first_seen.per_user", true)
+	e, err = createFirstSeenEnricher(c)
+	assert.Nil(t, err)
+
+	msg = exec("0", "/usr/bin/curl")
+	assert.Nil(t, e(context.Background(), msg))
+	assert.NotNil(t, msg.Extra)
+
+	msg = exec("1000", "/usr/bin/curl")
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"first_seen": true}, msg.Extra)
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterEnricherFactory("rule_tags", 40, createRuleTagsEnricher)
+}
+
+// Adds the tags of the audit rule that produced an event under extra.tags, rules are matched by their key
+// Rules sharing a key have their tags merged, the first rule wins if they disagree
+func createRuleTagsEnricher(config *viper.Viper) (Enricher, error) {
+	rules, err := createAuditRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := map[string]map[string]string{}
+	for i, r := range rules {
+		if len(r.tags) == 0 {
+			continue
+		}
+
+		key := r.key()
+		if key == "" {
+			return nil, fmt.Errorf("Audit rule %d has tags but no key (-k) to match events with", i+1)
+		}
+
+		if byKey[key] == nil {
+			byKey[key] = map[string]string{}
+		}
+
+		for k, v := range r.tags {
+			if _, ok := byKey[key][k]; !ok {
+				byKey[key][k] = v
+			}
+		}
+	}
+
+	return func(ctx context.Context, msg *AuditMessageGroup) error {
+		if len(byKey) == 0 {
+			return nil
+		}
+
+		// Events of rules with more than one key get the tags of all of them, the first key wins if they disagree
+		data, _ := msg.firstMessage(1300)
+		var tags map[string]string
+		for _, key := range parseKeys(data) {
+			if tags == nil {
+				tags = byKey[key]
+				continue
+			}
+
+			if more, ok := byKey[key]; ok {
+				tags = mergeTags(tags, more)
+			}
+		}
+
+		if tags != nil {
+			msg.SetExtra("tags", tags)
+		}
+
+		return nil
+	}, nil
+}
+
+// Returns a copy of a with the tags of b it doesn't have
+func mergeTags(a, b map[string]string) map[string]string {
+	tags := make(map[string]string, len(a)+len(b))
+	for k, v := range b {
+		tags[k] = v
+	}
+
+	for k, v := range a {
+		tags[k] = v
+	}
+
+	return tags
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func tagRule(rule string, tags ...string) map[interface{}]interface{} {
+	t := map[interface{}]interface{}{}
+	for i := 0; i < len(tags); i += 2 {
+		t[tags[i]] = tags[i+1]
+	}
+
+	return map[interface{}]interface{}{"rule": rule, "tags": t}
+}
+
+func Test_createRuleTagsEnricher(t *testing.T) {
+	c := viper.New()
+	c.Set("rules", []interface{}{tagRule("-a exit,always -S execve", "team", "secops")})
+	_, err := createRuleTagsEnricher(c)
+	assert.EqualError(t, err, "Audit rule 1 has tags but no key (-k) to match events with")
+
+	c.Set("rules", 1)
+	_, err = createRuleTagsEnricher(c)
+	assert.EqualError(t, err, "Could not parse rules object")
+
+	c.Set("rules", []interface{}{
+		"-a exit,always -S execve -k exec",
+		tagRule("-w /etc/shadow -p wa -k secrets", "team", "secops"),
+		tagRule("-w /etc/sudoers -p wa -k secrets", "team", "infra", "ticket", "SEC-1"),
+		tagRule("-w /etc/passwd -p wa -k identity", "team", "iam", "owner", "iam"),
+	})
+	e, err := createRuleTagsEnricher(c)
+	assert.Nil(t, err)
+
+	// Tags of rules sharing a key are merged
+	msg := &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=2 key="secrets"`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"tags": map[string]string{"team": "secops", "ticket": "SEC-1"}}, msg.Extra)
+
+	// Events of rules with more than one key get the tags of each
+	msg = &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=2 key="exec" key="secrets" key="identity"`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Equal(t, map[string]interface{}{"tags": map[string]string{"team": "secops", "ticket": "SEC-1", "owner": "iam"}}, msg.Extra)
+
+	// Rules without tags or events without a key are left alone
+	msg = &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 key="exec"`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Nil(t, msg.Extra)
+
+	msg = &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1100, Data: `pid=1`}}}
+	assert.Nil(t, e(context.Background(), msg))
+	assert.Nil(t, msg.Extra)
+}
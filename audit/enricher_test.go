@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterEnricher(t *testing.T) {
+	defer delete(enrichers, "test")
+
+	RegisterEnricher("test", 0, func(ctx context.Context, msg *AuditMessageGroup) error { return nil })
+	assert.Contains(t, enrichers, "test")
+
+	assert.Panics(t, func() {
+		RegisterEnricher("test", 0, nil)
+	}, "Registering the same name twice should panic")
+
+	assert.Panics(t, func() {
+		RegisterEnricherFactory("test", 0, nil)
+	}, "Registering the same name twice should panic")
+}
+
+func TestCreateEnrichers(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	old := enrichers
+	defer func() { enrichers = old }()
+	enrichers = map[string]NamedEnricher{}
+
+	RegisterEnricher("c", 10, nil)
+	RegisterEnricher("b", 5, nil)
+	RegisterEnricher("a", 5, nil)
+	RegisterEnricher("off", 1, nil)
+
+	c := viper.New()
+	c.Set("enrichers.off.enabled", false)
+	c.Set("enrichers.c.order", 1)
+
+	e, err := CreateEnrichers(c)
+	assert.Nil(t, err)
+	assert.Len(t, e, 3)
+	assert.Equal(t, "c", e[0].Name)
+	assert.Equal(t, 1, e[0].Order)
+	assert.Equal(t, "a", e[1].Name, "Same order should fall back to the name")
+	assert.Equal(t, "b", e[2].Name)
+	assert.Equal(
+		t,
+		"Enricher `off` is disabled\nEnricher `c` enabled with order 1\nEnricher `a` enabled with order 5\nEnricher `b` enabled with order 5\n",
+		lb.String(),
+	)
+}
+
+func TestCreateEnrichers_factory(t *testing.T) {
+	_, _ = hookLogger()
+	defer resetLogger()
+
+	old := enrichers
+	defer func() { enrichers = old }()
+	enrichers = map[string]NamedEnricher{}
+
+	called := 0
+	RegisterEnricherFactory("configured", 0, func(config *viper.Viper) (Enricher, error) {
+		called++
+		if config.GetBool("enrichers.configured.broken") {
+			return nil, errors.New("derp")
+		}
+
+		return func(ctx context.Context, msg *AuditMessageGroup) error { return nil }, nil
+	})
+
+	c := viper.New()
+	e, err := CreateEnrichers(c)
+	assert.Nil(t, err)
+	assert.Len(t, e, 1)
+	assert.NotNil(t, e[0].Fn)
+
+	c.Set("enrichers.configured.broken", true)
+	e, err = CreateEnrichers(c)
+	assert.EqualError(t, err, "Failed to create enricher configured. Error: derp")
+	assert.Nil(t, e)
+
+	// disabled enrichers are not created
+	c.Set("enrichers.configured.enabled", false)
+	_, err = CreateEnrichers(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, called)
+}
+
+func TestAuditMarshaller_enrich(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, []NamedEnricher{
+		{Name: "first", Fn: func(ctx context.Context, msg *AuditMessageGroup) error {
+			msg.SetExtra("team", "security")
+			return nil
+		}},
+		{Name: "broken", Fn: func(ctx context.Context, msg *AuditMessageGroup) error {
+			return errors.New("derp")
+		}},
+	})
+
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): hi there"))
+	m.Consume(context.Background(), new1320("1"))
+
+	assert.Equal(
+		t,
+		"{\"schema_version\":2,\"sequence\":1,\"timestamp\":\"10000001\",\"timestamp_ms\":10000001000,\"messages\":[{\"type\":1300,\"data\":\"hi there\"}],\"uid_map\":{},\"extra\":{\"team\":\"security\"}}\n",
+		w.String(),
+	)
+	assert.Equal(t, "Enricher `broken` failed on sequence 1. Error: derp\n", elb.String())
+}
@@ -0,0 +1,42 @@
+package audit_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/slackhq/go-audit/audit"
+	"github.com/spf13/viper"
+)
+
+// Embeds go-audit in another program, filters and the rest are read from a config like the go-audit binary's
+func ExampleClient() {
+	config := viper.New()
+	config.Set("filters", []interface{}{
+		map[interface{}]interface{}{"syscall": "connect"},
+	})
+
+	filters, err := audit.CreateFilters(config)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	client := audit.NewClient(audit.ClientOptions{
+		Multicast: true,
+		Filters:   filters,
+		Enrichers: []audit.NamedEnricher{{Name: "team", Fn: func(ctx context.Context, msg *audit.AuditMessageGroup) error {
+			msg.SetExtra("team", "security")
+			return nil
+		}}},
+		Writer: audit.NewAuditWriter(os.Stdout, 1),
+	})
+
+	client.Subscribe(func(msg *audit.AuditMessageGroup) {
+		fmt.Println(msg.Seq, msg.SyscallName, msg.Argv)
+	})
+
+	if err := client.Run(context.Background()); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Reassembles the command line from the EXECVE records of the group, nil if there are none
+// Arguments are logged as a0="plain" or a0=HEX when they need encoding, long ones are split into
+// a1_len=N a1[0]=HEX a1[1]=HEX... fragments and the whole list may span several EXECVE records
+// Arguments that never showed up are left empty, invalid utf8 is kept as hex like in decodeHexField
+func (amg *AuditMessageGroup) reassembleExecve() []string {
+	args := map[int]string{}
+	fragments := map[int]map[int]string{}
+	last := -1
+
+	for _, msg := range amg.Msgs {
+		if msg.Type != 1309 {
+			continue
+		}
+
+		// Values are hex encoded if they hold spaces or quotes so splitting on spaces is safe
+		for _, token := range strings.Fields(msg.Data) {
+			eq := strings.IndexByte(token, '=')
+			if eq < 2 || token[0] != 'a' {
+				continue
+			}
+
+			name, value := token[1:eq], token[eq+1:]
+			fragment := -1
+			if open := strings.IndexByte(name, '['); open > 0 && strings.HasSuffix(name, "]") {
+				i, err := strconv.Atoi(name[open+1 : len(name)-1])
+				if err != nil || i < 0 {
+					continue
+				}
+				name, fragment = name[:open], i
+			}
+
+			// Skips aN_len and anything else that isn't an argument
+			n, err := strconv.Atoi(name)
+			if err != nil || n < 0 {
+				continue
+			}
+
+			if fragment >= 0 {
+				if fragments[n] == nil {
+					fragments[n] = map[int]string{}
+				}
+				fragments[n][fragment] = value
+			} else if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				args[n] = value[1 : len(value)-1]
+			} else {
+				args[n] = decodeHexField(value)
+			}
+
+			if n > last {
+				last = n
+			}
+		}
+	}
+
+	if last < 0 {
+		return nil
+	}
+
+	for n, parts := range fragments {
+		hex := make([]string, 0, len(parts))
+		for i := 0; i < len(parts); i++ {
+			part, ok := parts[i]
+			if !ok {
+				// A record went missing, keep what was contiguous
+				break
+			}
+			hex = append(hex, part)
+		}
+		args[n] = decodeHexField(strings.Join(hex, ""))
+	}
+
+	argv := make([]string, last+1)
+	for n, arg := range args {
+		argv[n] = arg
+	}
+
+	return argv
+}
@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMessageGroup_reassembleExecve(t *testing.T) {
+	execve := func(data ...string) *AuditMessageGroup {
+		amg := &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59 a0=7ffd a1=2`}}}
+		for _, d := range data {
+			amg.Msgs = append(amg.Msgs, &AuditMessage{Type: 1309, Data: d})
+		}
+		return amg
+	}
+
+	assert.Nil(t, execve().reassembleExecve())
+	assert.Equal(t, []string{"ls", "-la", "/tmp/a b"}, execve(`argc=3 a0="ls" a1="-la" a2=2F746D702F612062`).reassembleExecve())
+
+	// Fragments across records, out of order
+	assert.Equal(t,
+		[]string{"echo", "hello world", "done"},
+		execve(`argc=3 a0="echo" a1_len=22 a1[0]=68656C6C6F`, `a1[2]=726C64 a2="done"`, `a1[1]=20776F`).reassembleExecve(),
+	)
+
+	// A missing fragment keeps the start, missing arguments are empty
+	assert.Equal(t, []string{"sh", "", "ab"}, execve(`argc=3 a0="sh" a2_len=6 a2[0]=6162 a2[2]=63`).reassembleExecve())
+
+	// Invalid utf8 stays hex
+	assert.Equal(t, []string{"cat", "FF00"}, execve(`argc=2 a0="cat" a1=FF00`).reassembleExecve())
+
+	// Garbage is skipped
+	assert.Equal(t, []string{"true"}, execve(`argc=1 a0="true" ab=1 a-1="x" a1[x]=41 a=1 a0_len=4`).reassembleExecve())
+}
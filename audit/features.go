@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// See http://lxr.free-electrons.com/source/include/uapi/linux/audit.h
+const (
+	AUDIT_SET_FEATURE     = 1018
+	AUDIT_GET_FEATURE     = 1019
+	AUDIT_FEATURE_VERSION = 1
+)
+
+// auditFeatureNames are the config and log names of the kernel audit features, the index is the feature bit
+var auditFeatureNames = []string{"only_unset_loginuid", "loginuid_immutable"}
+
+// AuditFeaturesPayload is struct audit_features, only features in Mask are changed by AUDIT_SET_FEATURE
+// A locked feature can't be changed again until the next reboot
+type AuditFeaturesPayload struct {
+	Vers     uint32
+	Mask     uint32
+	Features uint32
+	Lock     uint32
+}
+
+// How long to wait for the kernel to answer a feature request
+const featureTimeout = time.Second * 2
+
+// Reads `features` from the config, features that are not set are left alone
+func createAuditFeatures(config *viper.Viper) (*AuditFeaturesPayload, error) {
+	f := &AuditFeaturesPayload{Vers: AUDIT_FEATURE_VERSION}
+	for bit, name := range auditFeatureNames {
+		key := "features." + name
+		if !config.IsSet(key) {
+			continue
+		}
+
+		// A typo should not silently turn hardening off
+		on, err := cast.ToBoolE(config.Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("`%s` could not be parsed; Value: `%+v`", key, config.Get(key))
+		}
+
+		f.Mask |= 1 << uint(bit)
+		if on {
+			f.Features |= 1 << uint(bit)
+		}
+	}
+
+	if config.GetBool("features.lock") {
+		if f.Mask == 0 {
+			return nil, fmt.Errorf("features.lock needs at least one feature to lock")
+		}
+		f.Lock = f.Mask
+	}
+
+	return f, nil
+}
+
+// Opens a one off netlink socket to apply the features, see setAuditFeatures
+func applyAuditFeatures(f *AuditFeaturesPayload) error {
+	n, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	return setAuditFeatures(n, f)
+}
+
+// Applies the configured features, then logs the state of every feature
+func setAuditFeatures(n *NetlinkClient, f *AuditFeaturesPayload) error {
+	if err := n.SetReceiveTimeout(featureTimeout); err != nil {
+		return fmt.Errorf("Failed to set the netlink receive timeout. Error: %s", err)
+	}
+
+	if f.Mask != 0 {
+		packet := &NetlinkPacket{
+			Type:  AUDIT_SET_FEATURE,
+			Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+			Pid:   uint32(syscall.Getpid()),
+		}
+
+		if _, err := n.Request(packet, f, syscall.NLMSG_ERROR); err != nil {
+			if err == syscall.EPERM {
+				err = fmt.Errorf("%s, the features may be locked until the next reboot", err)
+			}
+			return fmt.Errorf("Failed to set audit features. Error: %s", err)
+		}
+
+		l.Printf("Set audit features: %s\n", formatAuditFeatures(f, f.Mask))
+	}
+
+	current, err := getAuditFeatures(n)
+	if err != nil {
+		// Older kernels don't know about features, there is nothing to report then
+		el.Printf("Failed to get audit features. Error: %s\n", err)
+		return nil
+	}
+
+	l.Printf("Audit features: %s\n", formatAuditFeatures(current, 1<<uint(len(auditFeatureNames))-1))
+	return nil
+}
+
+func getAuditFeatures(n *NetlinkClient) (*AuditFeaturesPayload, error) {
+	packet := &NetlinkPacket{
+		Type:  AUDIT_GET_FEATURE,
+		Flags: syscall.NLM_F_REQUEST,
+		Pid:   uint32(syscall.Getpid()),
+	}
+
+	msg, err := n.Request(packet, &AuditFeaturesPayload{}, AUDIT_GET_FEATURE)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &AuditFeaturesPayload{}
+	if err := binary.Read(bytes.NewReader(msg.Data), Endianness, f); err != nil {
+		return nil, fmt.Errorf("Could not parse the reply. Error: %s", err)
+	}
+
+	return f, nil
+}
+
+// Formats the features in mask like `loginuid_immutable=on (locked)`
+func formatAuditFeatures(f *AuditFeaturesPayload, mask uint32) string {
+	parts := []string{}
+	for bit, name := range auditFeatureNames {
+		b := uint32(1) << uint(bit)
+		if mask&b == 0 {
+			continue
+		}
+
+		state := "off"
+		if f.Features&b != 0 {
+			state = "on"
+		}
+
+		if f.Lock&b != 0 {
+			state += " (locked)"
+		}
+
+		parts = append(parts, name+"="+state)
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,191 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createAuditFeatures(t *testing.T) {
+	c := viper.New()
+	f, err := createAuditFeatures(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditFeaturesPayload{Vers: 1}, f)
+
+	c.Set("features.lock", true)
+	_, err = createAuditFeatures(c)
+	assert.EqualError(t, err, "features.lock needs at least one feature to lock")
+
+	c.Set("features.loginuid_immutable", "yes")
+	_, err = createAuditFeatures(c)
+	assert.EqualError(t, err, "`features.loginuid_immutable` could not be parsed; Value: `yes`")
+
+	c.Set("features.loginuid_immutable", true)
+	c.Set("features.only_unset_loginuid", false)
+	f, err = createAuditFeatures(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditFeaturesPayload{Vers: 1, Mask: 3, Features: 2, Lock: 3}, f)
+
+	c.Set("features.lock", false)
+	f, err = createAuditFeatures(c)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), f.Lock)
+}
+
+func Test_formatAuditFeatures(t *testing.T) {
+	f := &AuditFeaturesPayload{Features: 2, Lock: 2}
+	assert.Equal(t, "only_unset_loginuid=off, loginuid_immutable=on (locked)", formatAuditFeatures(f, 3))
+	assert.Equal(t, "loginuid_immutable=on (locked)", formatAuditFeatures(f, 2))
+	assert.Equal(t, "", formatAuditFeatures(f, 0))
+}
+
+// Stands in for the kernel, answers each request read from fd with reply
+type fakeKernel struct {
+	fd       int
+	mu       sync.Mutex
+	requests []*syscall.NetlinkMessage
+}
+
+// Every request received so far, serve records them on its own goroutine
+func (k *fakeKernel) received() []*syscall.NetlinkMessage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]*syscall.NetlinkMessage{}, k.requests...)
+}
+
+func (k *fakeKernel) serve(t *testing.T, client string, reply func(req *syscall.NetlinkMessage) [][]byte) {
+	buf := make([]byte, MAX_AUDIT_MESSAGE_LENGTH)
+	for {
+		n, _, err := syscall.Recvfrom(k.fd, buf, 0)
+		// The runtime's preemption signals interrupt a blocking recvfrom
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil || n == 0 {
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(append([]byte{}, buf[:n]...))
+		assert.Nil(t, err)
+		k.mu.Lock()
+		k.requests = append(k.requests, &msgs[0])
+		k.mu.Unlock()
+
+		for _, r := range reply(&msgs[0]) {
+			syscall.Sendto(k.fd, r, 0, &syscall.SockaddrUnix{Name: client})
+		}
+	}
+}
+
+// Builds a netlink message
+func netlinkReply(typ uint16, seq uint32, payload interface{}) []byte {
+	body := &bytes.Buffer{}
+	binary.Write(body, Endianness, payload)
+
+	b := &bytes.Buffer{}
+	binary.Write(b, Endianness, syscall.NlMsghdr{Len: uint32(syscall.SizeofNlMsghdr + body.Len()), Type: typ, Seq: seq})
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+func makeFeatureClient(t *testing.T, reply func(req *syscall.NetlinkMessage) [][]byte) (*NetlinkClient, *fakeKernel, func()) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+
+	kfd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, syscall.Bind(kfd, &syscall.SockaddrUnix{Name: filepath.Join(dir, "kernel")}))
+
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, syscall.Bind(fd, &syscall.SockaddrUnix{Name: filepath.Join(dir, "client")}))
+
+	n := &NetlinkClient{
+		fd:      fd,
+		address: &syscall.SockaddrUnix{Name: filepath.Join(dir, "kernel")},
+		buf:     make([]byte, MAX_AUDIT_MESSAGE_LENGTH),
+	}
+
+	k := &fakeKernel{fd: kfd}
+	served := make(chan bool)
+	go func() {
+		k.serve(t, filepath.Join(dir, "client"), reply)
+		close(served)
+	}()
+
+	return n, k, func() {
+		n.Close()
+
+		// serve must be done with the fd before it is closed, the next test may get the same number
+		syscall.Shutdown(kfd, syscall.SHUT_RDWR)
+		<-served
+		syscall.Close(kfd)
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_setAuditFeatures(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	current := AuditFeaturesPayload{Vers: 1, Features: 2, Lock: 2}
+	n, k, done := makeFeatureClient(t, func(req *syscall.NetlinkMessage) [][]byte {
+		switch req.Header.Type {
+		case AUDIT_SET_FEATURE:
+			// Unrelated messages are skipped
+			return [][]byte{
+				netlinkReply(1300, 0, []byte("audit(1.0:1): hi")),
+				netlinkReply(syscall.NLMSG_ERROR, req.Header.Seq, int32(0)),
+			}
+		case AUDIT_GET_FEATURE:
+			return [][]byte{netlinkReply(AUDIT_GET_FEATURE, req.Header.Seq, current)}
+		}
+		return nil
+	})
+	defer done()
+
+	assert.Nil(t, setAuditFeatures(n, &AuditFeaturesPayload{Vers: 1, Mask: 2, Features: 2, Lock: 2}))
+	assert.Equal(t, "Set audit features: loginuid_immutable=on (locked)\nAudit features: only_unset_loginuid=off, loginuid_immutable=on (locked)\n", lb.String())
+	assert.Equal(t, "", elb.String())
+
+	requests := k.received()
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, uint16(AUDIT_SET_FEATURE), requests[0].Header.Type)
+		assert.Equal(t, uint16(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK), requests[0].Header.Flags)
+		assert.Equal(t, []byte{1, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0}, requests[0].Data)
+		assert.Equal(t, uint16(AUDIT_GET_FEATURE), requests[1].Header.Type)
+	}
+
+	// Nothing to set, only the state is reported
+	lb.Reset()
+	assert.Nil(t, setAuditFeatures(n, &AuditFeaturesPayload{Vers: 1}))
+	assert.Equal(t, "Audit features: only_unset_loginuid=off, loginuid_immutable=on (locked)\n", lb.String())
+	assert.Len(t, k.received(), 3)
+}
+
+func Test_setAuditFeatures_errors(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	n, _, done := makeFeatureClient(t, func(req *syscall.NetlinkMessage) [][]byte {
+		return [][]byte{netlinkReply(syscall.NLMSG_ERROR, req.Header.Seq, int32(-int32(syscall.EPERM)))}
+	})
+	defer done()
+
+	err := setAuditFeatures(n, &AuditFeaturesPayload{Vers: 1, Mask: 2})
+	assert.EqualError(t, err, "Failed to set audit features. Error: operation not permitted, the features may be locked until the next reboot")
+
+	// Failing to read the state is not fatal
+	assert.Nil(t, setAuditFeatures(n, &AuditFeaturesPayload{Vers: 1}))
+	assert.Equal(t, "", lb.String())
+	assert.Equal(t, "Failed to get audit features. Error: operation not permitted\n", elb.String())
+}
@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fields the kernel and libaudit hex encode when they contain spaces, quotes or control characters
+// Encoded values are left unquoted, plain ones are always quoted
+var encodedFields = map[string]bool{
+	"acct": true, "cmd": true, "comm": true, "cwd": true, "exe": true,
+	"key": true, "name": true, "path": true, "proctitle": true,
+}
+
+// Separates the keys of a rule with more than one -k, AUDIT_KEY_SEPARATOR in the kernel
+const auditKeySeparator = "\x01"
+
+// Splits audit message data into its key=value pairs
+// Values wrapped in double or single quotes have the quotes removed, quoted values may contain spaces
+// Unquoted values of fields in encodedFields are hex decoded
+// Userspace records nest their fields in msg='...', those are added as well unless the key is already present
+// If a key shows up more than once the first value is kept
+func parseFields(data string) map[string]string {
+	fields := make(map[string]string, 16)
+	parseFieldsInto(fields, data, true)
+	return fields
+}
+
+func parseFieldsInto(fields map[string]string, data string, nested bool) {
+	eachField(data, func(key, value string, quoted bool) {
+		if !quoted && encodedFields[key] {
+			value = decodeHexField(value)
+		}
+
+		if _, ok := fields[key]; !ok && key != "" {
+			fields[key] = value
+		}
+
+		if nested && key == "msg" && quoted {
+			parseFieldsInto(fields, value, false)
+		}
+	})
+}
+
+// Calls fn with every key=value pair of message data in order, quotes are removed from quoted values
+func eachField(data string, fn func(key, value string, quoted bool)) {
+	for len(data) > 0 {
+		// Skip leading spaces
+		if data[0] == spaceChar {
+			data = data[1:]
+			continue
+		}
+
+		eq := strings.IndexByte(data, '=')
+		if eq < 0 {
+			break
+		}
+
+		key := data[:eq]
+		if sp := strings.LastIndexByte(key, spaceChar); sp >= 0 {
+			// A word without an = sign, skip past it
+			key = key[sp+1:]
+		}
+
+		data = data[eq+1:]
+		value := ""
+		quoted := len(data) > 0 && (data[0] == '"' || data[0] == '\'')
+
+		if quoted {
+			quote := data[0]
+			end := strings.IndexByte(data[1:], quote)
+			if end < 0 {
+				// Unterminated quote, take the rest of the line
+				value = data[1:]
+				data = ""
+			} else {
+				value = data[1 : end+1]
+				data = data[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(data, spaceChar)
+			if end < 0 {
+				end = len(data)
+			}
+
+			value = data[:end]
+			data = data[end:]
+		}
+
+		fn(key, value, quoted)
+	}
+}
+
+// Finds the rule keys (-k) of message data
+// The kernel joins the keys of a rule with more than one -k with \x01 and hex encodes them, userspace tools
+// write them as key="a" key="b" instead. Both are split up, unkeyed rules log key=(null) which is skipped
+func parseKeys(data string) []string {
+	var keys []string
+	eachField(data, func(key, value string, quoted bool) {
+		if key != "key" || (!quoted && value == "(null)") {
+			return
+		}
+
+		if !quoted {
+			value = decodeHexField(value)
+		}
+
+		for _, k := range strings.Split(value, auditKeySeparator) {
+			if k != "" && !hasKey(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	})
+
+	return keys
+}
+
+func hasKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decodes an audit hex encoded value, anything that doesn't look encoded is returned as is
+func decodeHexField(value string) string {
+	if len(value) < 2 || len(value)%2 != 0 {
+		return value
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'A' && c <= 'F') {
+			return value
+		}
+	}
+
+	// Keep the hex if the value isn't utf8, it would not survive json
+	b, err := hex.DecodeString(value)
+	if err != nil || !utf8.Valid(b) {
+		return value
+	}
+
+	return string(b)
+}
+
+// Decodes the proctitle field of a PROCTITLE message into a command line
+// The kernel hex encodes it when it has NUL separated arguments, those are joined with spaces
+func parseProctitle(data string) string {
+	title := parseFields(data)["proctitle"]
+	if strings.IndexByte(title, 0) < 0 {
+		return title
+	}
+
+	return strings.Join(strings.FieldsFunc(title, func(r rune) bool { return r == 0 }), " ")
+}
+
+// Returns the data of the first message of the given type
+func (amg *AuditMessageGroup) firstMessage(msgType uint16) (string, bool) {
+	for _, msg := range amg.Msgs {
+		if msg.Type == msgType {
+			return msg.Data, true
+		}
+	}
+
+	return "", false
+}
+
+// Finds the value of a field in the first message of the given type
+func (amg *AuditMessageGroup) findField(msgType uint16, key string) (string, bool) {
+	data, ok := amg.firstMessage(msgType)
+	if !ok {
+		return "", false
+	}
+
+	v, ok := parseFields(data)[key]
+	return v, ok
+}
+
+// Decodes the destination ip address and port from the hex encoded saddr field of a SOCKADDR message
+// Only AF_INET and AF_INET6 are understood at the moment, anything else returns nil
+func parseSockaddr(saddr string) (net.IP, int) {
+	b, err := hex.DecodeString(saddr)
+	if err != nil || len(b) < 2 {
+		return nil, 0
+	}
+
+	// sa_family is in host byte order, the port is in network byte order
+	family := Endianness.Uint16(b[0:2])
+	switch family {
+	case 2: // AF_INET: family(2) port(2) addr(4)
+		if len(b) < 8 {
+			return nil, 0
+		}
+
+		return net.IPv4(b[4], b[5], b[6], b[7]), int(binary.BigEndian.Uint16(b[2:4]))
+	case 10: // AF_INET6: family(2) port(2) flowinfo(4) addr(16) scope_id(4)
+		if len(b) < 24 {
+			return nil, 0
+		}
+
+		return net.IP(append([]byte{}, b[8:24]...)), int(binary.BigEndian.Uint16(b[2:4]))
+	}
+
+	return nil, 0
+}
+
+// Decodes the sun_path from the hex encoded saddr field of an AF_UNIX SOCKADDR message
+// Abstract socket names start with a NUL, they are returned with an @ in its place like ss and netstat do
+func parseSocketPath(saddr string) string {
+	b, err := hex.DecodeString(saddr)
+	if err != nil || len(b) < 3 || Endianness.Uint16(b[0:2]) != 1 {
+		return ""
+	}
+
+	// AF_UNIX: family(2) path(up to 108)
+	path := b[2:]
+	if path[0] == 0 {
+		return "@" + string(bytes.TrimRight(path[1:], "\x00"))
+	}
+
+	if end := bytes.IndexByte(path, 0); end >= 0 {
+		path = path[:end]
+	}
+
+	return string(path)
+}
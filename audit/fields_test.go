@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseFields(t *testing.T) {
+	f := parseFields(`arch=c000003e syscall=59 success=yes comm="ls" exe="/bin/my ls" key=(null)`)
+	assert.Equal(t, "c000003e", f["arch"])
+	assert.Equal(t, "59", f["syscall"])
+	assert.Equal(t, "ls", f["comm"])
+	assert.Equal(t, "/bin/my ls", f["exe"], "Quoted values can contain spaces")
+	assert.Equal(t, "(null)", f["key"])
+
+	// single quotes, repeated keys, words with no value, trailing junk
+	f = parseFields(`pid=1 uid=0 msg='op=PAM:session_open acct="root"' uid=1 lonely key="a`)
+	assert.Equal(t, "op=PAM:session_open acct=\"root\"", f["msg"])
+	assert.Equal(t, "0", f["uid"], "The first value should win")
+	assert.Equal(t, "a", f["key"], "Unterminated quotes take the rest of the line")
+	assert.NotContains(t, f, "lonely")
+
+	// empty values and empty input
+	f = parseFields("a= b=1")
+	assert.Equal(t, "", f["a"])
+	assert.Equal(t, "1", f["b"])
+	assert.Empty(t, parseFields(""))
+	assert.Empty(t, parseFields("no fields here"))
+}
+
+func Test_parseFields_nested(t *testing.T) {
+	f := parseFields(`pid=1234 uid=0 auid=1000 ses=3 msg='op=PAM:authentication grantors=? acct="bob" exe="/usr/sbin/sshd" hostname=10.0.0.1 addr=10.0.0.1 terminal=ssh res=failed'`)
+	assert.Equal(t, "1234", f["pid"])
+	assert.Equal(t, "PAM:authentication", f["op"])
+	assert.Equal(t, "bob", f["acct"])
+	assert.Equal(t, "/usr/sbin/sshd", f["exe"])
+	assert.Equal(t, "?", f["grantors"])
+	assert.Equal(t, "failed", f["res"])
+	assert.Contains(t, f["msg"], "op=PAM:authentication", "The raw msg should be kept")
+
+	// outer fields win, nesting only goes one level deep
+	f = parseFields(`uid=0 msg='uid=5 op=test inner="msg=x"'`)
+	assert.Equal(t, "0", f["uid"])
+	assert.Equal(t, "test", f["op"])
+	assert.NotContains(t, f, "x")
+
+	// hex encoded values, inside and outside msg
+	f = parseFields(`msg='op=login acct=6D7920757365720A exe="/usr/sbin/sshd" res=success' comm=6C73202D6C name="41424344" cwd=(null) exe=?`)
+	assert.Equal(t, "my user\n", f["acct"], "Unquoted hex values should be decoded")
+	assert.Equal(t, "ls -l", f["comm"])
+	assert.Equal(t, "41424344", f["name"], "Quoted values are never decoded")
+	assert.Equal(t, "(null)", f["cwd"])
+	assert.Equal(t, "/usr/sbin/sshd", f["exe"])
+
+	// only some fields are ever encoded
+	f = parseFields(`arch=C000003E a0=AB`)
+	assert.Equal(t, "C000003E", f["arch"])
+	assert.Equal(t, "AB", f["a0"])
+}
+
+func Test_decodeHexField(t *testing.T) {
+	assert.Equal(t, "ls", decodeHexField("6C73"))
+	assert.Equal(t, "6c73", decodeHexField("6c73"), "Lower case is not something audit produces")
+	assert.Equal(t, "6C7", decodeHexField("6C7"))
+	assert.Equal(t, "?", decodeHexField("?"))
+	assert.Equal(t, "", decodeHexField(""))
+	assert.Equal(t, "FFFE", decodeHexField("FFFE"), "Values that aren't utf8 should stay encoded")
+}
+
+func TestAuditMessageGroup_findField(t *testing.T) {
+	amg := &AuditMessageGroup{
+		Msgs: []*AuditMessage{
+			{Type: 1309, Data: `argc=1 a0="ls"`},
+			{Type: 1300, Data: `syscall=59 exe="/bin/ls"`},
+			{Type: 1300, Data: `syscall=42 exe="/bin/nope"`},
+		},
+	}
+
+	v, ok := amg.findField(1300, "exe")
+	assert.True(t, ok)
+	assert.Equal(t, "/bin/ls", v, "Only the first message of a type is used")
+
+	_, ok = amg.findField(1300, "nope")
+	assert.False(t, ok)
+
+	_, ok = amg.findField(1306, "saddr")
+	assert.False(t, ok)
+}
+
+func Test_parseSockaddr(t *testing.T) {
+	// AF_INET 10.1.2.3:443
+	ip, port := parseSockaddr("020001BB0A0102030000000000000000")
+	assert.Equal(t, net.IPv4(10, 1, 2, 3), ip)
+	assert.Equal(t, 443, port)
+
+	// AF_INET6 [2001:db8::1]:8080
+	ip, port = parseSockaddr("0A001F900000000020010DB800000000000000000000000100000000")
+	assert.Equal(t, net.ParseIP("2001:db8::1"), ip)
+	assert.Equal(t, 8080, port)
+
+	// AF_INET6 mapped v4 addresses are still v6 sockets but compare equal to the v4 address
+	ip, port = parseSockaddr("0A0000500000000000000000000000000000FFFF0A01020300000000")
+	assert.True(t, net.IPv4(10, 1, 2, 3).Equal(ip))
+	assert.Equal(t, 80, port)
+
+	// too short, not hex, unknown families
+	for _, saddr := range []string{"020001BB0A01", "0A001F90000000002001", "nothex", "", "01002F746D702F736F636B"} {
+		ip, port = parseSockaddr(saddr)
+		assert.Nil(t, ip, saddr)
+		assert.Equal(t, 0, port, saddr)
+	}
+}
+
+func Test_parseSocketPath(t *testing.T) {
+	assert.Equal(t, "/var/run/docker.sock", parseSocketPath("01002F7661722F72756E2F646F636B65722E736F636B000000"))
+	assert.Equal(t, "/tmp/sock", parseSocketPath("01002F746D702F736F636B"))
+
+	// abstract sockets
+	assert.Equal(t, "@/tmp/.X11-unix/X0", parseSocketPath("0100002F746D702F2E5831312D756E69782F5830"))
+
+	// unnamed sockets, not hex, other families
+	assert.Equal(t, "", parseSocketPath("0100"))
+	assert.Equal(t, "", parseSocketPath("nothex"))
+	assert.Equal(t, "", parseSocketPath("020001BB0A0102030000000000000000"))
+}
+
+func Test_parseProctitle(t *testing.T) {
+	assert.Equal(t, "ls -la /tmp/a b", parseProctitle("proctitle=6C73002D6C61002F746D702F612062"))
+	assert.Equal(t, "ls -la /tmp/a b", parseProctitle("proctitle=6C73002D6C61002F746D702F61206200"))
+
+	// No arguments are not encoded
+	assert.Equal(t, "/usr/sbin/sshd", parseProctitle(`proctitle="/usr/sbin/sshd"`))
+
+	// Invalid utf8 stays hex
+	assert.Equal(t, "6C7300FF", parseProctitle("proctitle=6C7300FF"))
+	assert.Equal(t, "", parseProctitle("nope"))
+}
+
+func Test_parseKeys(t *testing.T) {
+	assert.Equal(t, []string{"exec"}, parseKeys(`syscall=59 key="exec"`))
+
+	// The kernel hex encodes keys joined with \x01
+	assert.Equal(t, []string{"exec", "priv"}, parseKeys("syscall=59 key=6578656301707269760165786563"))
+
+	// Userspace tools write them as separate fields
+	assert.Equal(t, []string{"exec", "priv"}, parseKeys(`syscall=59 key="exec" key="priv"`))
+
+	// Rules without a key
+	assert.Nil(t, parseKeys("syscall=59 key=(null)"))
+	assert.Nil(t, parseKeys(`syscall=59 comm="a key=b"`))
+	assert.Nil(t, parseKeys("syscall=59 monkey=1"))
+}
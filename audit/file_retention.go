@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// fileRetention removes old rotated copies of the output file, see `output.file.retention` in the example config
+// Rotated files are the ones next to the output file named like our own rotation or logrotate leaves them, see rotatedSuffix
+type fileRetention struct {
+	path     string
+	maxSize  int64         // Total size of the output file and its rotated copies
+	maxAge   time.Duration // Age of a rotated copy, by modification time
+	maxFiles int           // Number of rotated copies
+	interval time.Duration
+}
+
+// What follows the output file name in a rotated copy, like go-audit.log-20180101-150405.1.gz or logrotate's go-audit.log.1
+var rotatedSuffix = regexp.MustCompile(`^(\.[0-9]+|-[0-9]{8}(-[0-9]{6})?(\.[0-9]+)?)(\.gz)?$`)
+
+// Creates the retention settings for the file output, returns nil if no limits are set
+func createFileRetention(config *viper.Viper, path string) (*fileRetention, error) {
+	r := &fileRetention{
+		path:     path,
+		maxSize:  int64(config.GetInt("output.file.retention.max_size")),
+		maxAge:   config.GetDuration("output.file.retention.max_age"),
+		maxFiles: config.GetInt("output.file.retention.max_files"),
+		interval: config.GetDuration("output.file.retention.interval"),
+	}
+
+	if r.maxSize < 0 || r.maxAge < 0 || r.maxFiles < 0 {
+		return nil, fmt.Errorf("output.file.retention limits can not be negative")
+	}
+
+	if r.maxSize == 0 && r.maxAge == 0 && r.maxFiles == 0 {
+		return nil, nil
+	}
+
+	if r.interval <= 0 {
+		return nil, fmt.Errorf("output.file.retention.interval must be greater than 0, %v provided", r.interval)
+	}
+
+	return r, nil
+}
+
+// Prunes every interval until stop is closed
+func (r *fileRetention) run(stop <-chan struct{}) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		if _, err := r.prune(time.Now()); err != nil {
+			el.Println(err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Removes rotated files, oldest first, until every limit is met. The output file itself is never removed
+func (r *fileRetention) prune(now time.Time) ([]string, error) {
+	matches, err := filepath.Glob(escapeGlob(r.path) + "?*")
+	if err != nil {
+		return nil, err
+	}
+
+	type rotated struct {
+		path string
+		info os.FileInfo
+	}
+
+	names := map[string]bool{}
+	for _, m := range matches {
+		names[m] = true
+	}
+
+	files := []rotated{}
+	for _, m := range matches {
+		if !rotatedSuffix.MatchString(m[len(r.path):]) {
+			continue
+		}
+
+		// Both the file and its .gz exist while compressFile is still at it, neither is done yet
+		if names[m+".gz"] || (filepath.Ext(m) == ".gz" && names[m[:len(m)-3]]) {
+			continue
+		}
+
+		info, err := os.Lstat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		files = append(files, rotated{m, info})
+	}
+
+	// Newest first so the oldest are the ones going over the limits
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().After(files[j].info.ModTime())
+	})
+
+	var total int64
+	if info, err := os.Stat(r.path); err == nil {
+		total = info.Size()
+	}
+
+	removed := []string{}
+	kept := 0
+	full := false
+	for _, f := range files {
+		// Once the size limit is hit everything older goes too, even if it would still fit
+		full = full || (r.maxSize > 0 && total+f.info.Size() > r.maxSize)
+		remove := full ||
+			(r.maxAge > 0 && now.Sub(f.info.ModTime()) > r.maxAge) ||
+			(r.maxFiles > 0 && kept >= r.maxFiles)
+
+		if !remove {
+			kept++
+			total += f.info.Size()
+			continue
+		}
+
+		if err := os.Remove(f.path); err != nil {
+			el.Printf("Failed to remove old output file %s. Error: %s\n", f.path, err)
+			continue
+		}
+
+		removed = append(removed, f.path)
+		l.Printf("Removed old output file %s\n", f.path)
+	}
+
+	return removed, nil
+}
+
+// Escapes glob meta characters so a path can be used as a literal prefix
+func escapeGlob(path string) string {
+	escaped := make([]byte, 0, len(path))
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '*', '?', '[', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, path[i])
+	}
+
+	return string(escaped)
+}
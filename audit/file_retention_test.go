@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createFileRetention(t *testing.T) {
+	c := viper.New()
+	r, err := createFileRetention(c, "/tmp/go-audit.log")
+	assert.Nil(t, err)
+	assert.Nil(t, r, "No limits should disable retention")
+
+	c.Set("output.file.retention.max_files", -1)
+	_, err = createFileRetention(c, "/tmp/go-audit.log")
+	assert.EqualError(t, err, "output.file.retention limits can not be negative")
+
+	c.Set("output.file.retention.max_files", 2)
+	_, err = createFileRetention(c, "/tmp/go-audit.log")
+	assert.EqualError(t, err, "output.file.retention.interval must be greater than 0, 0s provided")
+
+	c.Set("output.file.retention.interval", "10s")
+	c.Set("output.file.retention.max_age", "24h")
+	c.Set("output.file.retention.max_size", 100)
+	r, err = createFileRetention(c, "/tmp/go-audit.log")
+	assert.Nil(t, err)
+	assert.Equal(t, &fileRetention{path: "/tmp/go-audit.log", maxSize: 100, maxAge: time.Hour * 24, maxFiles: 2, interval: time.Second * 10}, r)
+}
+
+func TestFileRetention_prune(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) string {
+		p := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(p, make([]byte, size), 0600))
+		assert.Nil(t, os.Chtimes(p, now.Add(-age), now.Add(-age)))
+		return p
+	}
+
+	reset := func() {
+		os.RemoveAll(dir)
+		os.Mkdir(dir, 0700)
+		write("go[1].log", 10, 0)
+		write("go[1].log.1", 10, time.Hour)
+		write("go[1].log.2.gz", 10, time.Hour*2)
+		write("go[1].log-20180101.gz", 10, time.Hour*48)
+		write("other.log.1", 10, time.Hour*100)
+		write("go[1].log.lock", 10, time.Hour*100)
+		write("go[1].logger", 10, time.Hour*100)
+		write("go[1].log-20180102-150405", 10, time.Hour*100)
+		write("go[1].log-20180102-150405.gz", 10, time.Hour*100)
+		os.Mkdir(filepath.Join(dir, "go[1].log.d"), 0700)
+	}
+
+	r := &fileRetention{path: filepath.Join(dir, "go[1].log")}
+	reset()
+	removed, err := r.prune(now)
+	assert.Nil(t, err)
+	assert.Empty(t, removed, "No limits remove nothing")
+
+	r.maxAge = time.Hour * 24
+	removed, _ = r.prune(now)
+	assert.Equal(t, []string{filepath.Join(dir, "go[1].log-20180101.gz")}, removed)
+	assert.Equal(t, "Removed old output file "+filepath.Join(dir, "go[1].log-20180101.gz")+"\n", lb.String())
+
+	r = &fileRetention{path: r.path, maxFiles: 1}
+	reset()
+	removed, _ = r.prune(now)
+	assert.Equal(t, []string{filepath.Join(dir, "go[1].log.2.gz"), filepath.Join(dir, "go[1].log-20180101.gz")}, removed)
+
+	// The active file counts towards the size but is never removed
+	r = &fileRetention{path: r.path, maxSize: 25}
+	reset()
+	removed, _ = r.prune(now)
+	assert.Equal(t, []string{filepath.Join(dir, "go[1].log.2.gz"), filepath.Join(dir, "go[1].log-20180101.gz")}, removed)
+
+	r.maxSize = 5
+	reset()
+	removed, _ = r.prune(now)
+	assert.Len(t, removed, 3)
+	_, err = os.Stat(r.path)
+	assert.Nil(t, err)
+
+	// Only rotated copies go, and none that are still being compressed
+	for _, name := range []string{"other.log.1", "go[1].log.lock", "go[1].logger", "go[1].log-20180102-150405", "go[1].log-20180102-150405.gz"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		assert.Nil(t, err, name)
+	}
+}
+
+func Test_escapeGlob(t *testing.T) {
+	assert.Equal(t, `/var/log/go-audit.log`, escapeGlob("/var/log/go-audit.log"))
+	assert.Equal(t, `/a\*b\?c\[d\\e`, escapeGlob(`/a*b?c[d\e`))
+}
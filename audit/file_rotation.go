@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// fileRotation moves the output file aside once it gets too big or too old, see `output.file.rotation` in the example config
+// Rotated copies are named like go-audit.log-20180101-150405 so fileRetention can prune them
+type fileRotation struct {
+	maxSize  int64         // Bytes written to the file before rotating
+	maxAge   time.Duration // Time since the file was opened before rotating
+	compress bool          // Gzip rotated copies in the background
+}
+
+// Creates the rotation settings for the file output, returns nil if no limits are set
+func createFileRotation(config *viper.Viper) (*fileRotation, error) {
+	r := &fileRotation{
+		maxSize:  int64(config.GetInt("output.file.rotation.max_size")),
+		maxAge:   config.GetDuration("output.file.rotation.max_age"),
+		compress: config.GetBool("output.file.rotation.compress"),
+	}
+
+	if r.maxSize < 0 || r.maxAge < 0 {
+		return nil, errors.New("output.file.rotation limits can not be negative")
+	}
+
+	if r.maxSize == 0 && r.maxAge == 0 {
+		if r.compress {
+			return nil, errors.New("output.file.rotation.compress needs max_size or max_age to be set")
+		}
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+// Reports if a file of size bytes, opened at opened, should be rotated before writing n more bytes
+// Empty files are never rotated
+func (r *fileRotation) due(size int64, n int, opened time.Time, now time.Time) bool {
+	if size == 0 {
+		return false
+	}
+
+	return (r.maxSize > 0 && size+int64(n) > r.maxSize) || (r.maxAge > 0 && now.Sub(opened) >= r.maxAge)
+}
+
+// Picks a name for the rotated copy of path that isn't taken yet, compressed or not
+func (r *fileRotation) name(path string, now time.Time) string {
+	base := path + "-" + now.Format("20060102-150405")
+	name := base
+	for i := 1; ; i++ {
+		_, err := os.Lstat(name)
+		_, gzErr := os.Lstat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+
+		name = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+// Replaces path with path.gz, owned and moded like the output file
+func compressFile(path string, mode os.FileMode, uid, gid int) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Chown(uid, gid)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createFileRotation(t *testing.T) {
+	c := viper.New()
+	r, err := createFileRotation(c)
+	assert.Nil(t, err)
+	assert.Nil(t, r, "No limits should disable rotation")
+
+	c.Set("output.file.rotation.compress", true)
+	_, err = createFileRotation(c)
+	assert.EqualError(t, err, "output.file.rotation.compress needs max_size or max_age to be set")
+
+	c.Set("output.file.rotation.max_size", -1)
+	_, err = createFileRotation(c)
+	assert.EqualError(t, err, "output.file.rotation limits can not be negative")
+
+	c.Set("output.file.rotation.max_size", 100)
+	c.Set("output.file.rotation.max_age", "24h")
+	r, err = createFileRotation(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &fileRotation{maxSize: 100, maxAge: time.Hour * 24, compress: true}, r)
+}
+
+func TestFileRotation_due(t *testing.T) {
+	now := time.Now()
+	r := &fileRotation{maxSize: 10}
+	assert.False(t, r.due(0, 20, now, now), "Empty files should never rotate")
+	assert.False(t, r.due(5, 5, now, now))
+	assert.True(t, r.due(5, 6, now, now))
+	assert.False(t, r.due(5, 1, now.Add(-time.Hour*48), now))
+
+	r = &fileRotation{maxAge: time.Hour}
+	assert.False(t, r.due(5, 100, now.Add(-time.Minute), now))
+	assert.True(t, r.due(5, 1, now.Add(-time.Hour), now))
+}
+
+func TestFileOutput_rotate(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "go-audit.log")
+	o := &fileOutput{
+		path:    path,
+		mode:    0600,
+		uid:     os.Getuid(),
+		gid:     os.Getgid(),
+		rotator: &fileRotation{maxSize: 10},
+	}
+
+	f, size, err := o.openFile()
+	assert.Nil(t, err)
+	o.f, o.size, o.opened = f, size, time.Now()
+	defer o.Close()
+
+	o.Write([]byte("12345\n"))
+	o.Write([]byte("123\n"))
+	rotated, _ := filepath.Glob(path + "-*")
+	assert.Empty(t, rotated)
+
+	// Going over max_size moves the file aside first
+	o.Write([]byte("abc\n"))
+	rotated, _ = filepath.Glob(path + "-*")
+	assert.Len(t, rotated, 1)
+	assert.Contains(t, lb.String(), "Rotated output file to "+rotated[0])
+
+	b, _ := ioutil.ReadFile(rotated[0])
+	assert.Equal(t, "12345\n123\n", string(b))
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "abc\n", string(b))
+
+	// Names don't collide within the same second
+	now := time.Now()
+	assert.NotEqual(t, o.rotator.name(path, now), filepath.Base(rotated[0]))
+	ioutil.WriteFile(path+"-"+now.Format("20060102-150405")+".gz", nil, 0600)
+	ioutil.WriteFile(path+"-"+now.Format("20060102-150405")+".1", nil, 0600)
+	assert.Equal(t, path+"-"+now.Format("20060102-150405")+".2", o.rotator.name(path, now))
+
+	// Rotated copies are compressed in the background
+	o.rotator.compress = true
+	o.Write([]byte("12345\n"))
+	o.mu.Lock()
+	assert.Nil(t, o.rotate(now))
+	o.mu.Unlock()
+
+	gzPath := path + "-" + now.Format("20060102-150405") + ".2.gz"
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path + "-" + now.Format("20060102-150405") + ".2"); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	gzf, err := os.Open(gzPath)
+	assert.Nil(t, err)
+	defer gzf.Close()
+	gz, err := gzip.NewReader(gzf)
+	assert.Nil(t, err)
+	b, _ = ioutil.ReadAll(gz)
+	assert.Equal(t, "abc\n12345\n", string(b))
+	assert.Empty(t, elb.String())
+}
+
+func TestFileOutput_Close(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	o := &fileOutput{
+		path:      filepath.Join(dir, "go-audit.log"),
+		mode:      0600,
+		uid:       os.Getuid(),
+		gid:       os.Getgid(),
+		retention: &fileRetention{path: filepath.Join(dir, "go-audit.log"), maxFiles: 1, interval: time.Hour},
+	}
+	assert.Nil(t, o.Open())
+
+	o.mu.Lock()
+	stop := o.stop
+	o.mu.Unlock()
+	assert.Nil(t, o.Close())
+
+	select {
+	case <-stop:
+	default:
+		t.Fatal("Close should stop the rotation and retention goroutines")
+	}
+
+	// Both return once stop is closed, instead of piling up across reloads
+	done := make(chan bool, 2)
+	go func() {
+		handleLogRotation(o, stop)
+		done <- true
+	}()
+	go func() {
+		o.retention.run(stop)
+		done <- true
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("A file output goroutine did not stop")
+		}
+	}
+	assert.Nil(t, o.Close(), "Closing twice is fine")
+}
@@ -0,0 +1,624 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filterExpr is a compiled `expr` filter condition, like syscall == "connect" && dest_port != 53
+//
+// Operators, loosest first: ||, &&, !, then ==, !=, <, <=, >, >=, =~ and !~ (the right side is a regex literal)
+// and in (the right side is a list, like ["a", "b"], or a list field). Parentheses group as usual
+// Literals are "double" or 'single' quoted strings, numbers, true, false and null
+// Names are the fields in exprFields, anything else is looked up in the flattened format, like path.name or cwd.cwd.
+// Fields an event does not have are null, which only equals null
+// Values are compared as numbers when both sides are numbers or look like one, as strings otherwise
+type filterExpr struct {
+	source string
+	root   exprNode
+}
+
+// Fields expressions can use by name, on top of the flattened format
+var exprFields = map[string]func(e *exprEnv) interface{}{
+	"syscall":        func(e *exprEnv) interface{} { return exprString(e.msg.SyscallName) },
+	"syscall_number": func(e *exprEnv) interface{} { return exprString(e.msg.Syscall) },
+	"success": func(e *exprEnv) interface{} {
+		if e.msg.Success == nil {
+			return nil
+		}
+		return *e.msg.Success
+	},
+	"exit": func(e *exprEnv) interface{} {
+		if e.msg.Exit == nil {
+			return nil
+		}
+		return float64(*e.msg.Exit)
+	},
+	"exe":        func(e *exprEnv) interface{} { return e.syscallField("exe") },
+	"comm":       func(e *exprEnv) interface{} { return e.syscallField("comm") },
+	"pid":        func(e *exprEnv) interface{} { return e.syscallField("pid") },
+	"ppid":       func(e *exprEnv) interface{} { return e.syscallField("ppid") },
+	"user.id":    func(e *exprEnv) interface{} { return e.uid("uid", false) },
+	"user.name":  func(e *exprEnv) interface{} { return e.uid("uid", true) },
+	"auser.id":   func(e *exprEnv) interface{} { return e.uid("auid", false) },
+	"auser.name": func(e *exprEnv) interface{} { return e.uid("auid", true) },
+	"keys":       func(e *exprEnv) interface{} { return e.msg.Keys },
+	"argv":       func(e *exprEnv) interface{} { return e.msg.Argv },
+	"dest_ip": func(e *exprEnv) interface{} {
+		if s := e.msg.Sockaddr; s != nil && (s.Family == "inet" || s.Family == "inet6") {
+			return s.Address
+		}
+		return nil
+	},
+	"dest_port": func(e *exprEnv) interface{} {
+		if s := e.msg.Sockaddr; s != nil && (s.Family == "inet" || s.Family == "inet6") {
+			return float64(s.Port)
+		}
+		return nil
+	},
+	"socket_path": func(e *exprEnv) interface{} { return exprString(e.msg.SocketPath) },
+	"proctitle":   func(e *exprEnv) interface{} { return exprString(e.msg.Proctitle) },
+}
+
+// What an expression is evaluated against, the flattened fields are only worked out if a name needs them
+type exprEnv struct {
+	msg       *AuditMessageGroup
+	syscall   map[string]string
+	flattened map[string]interface{}
+}
+
+func (e *exprEnv) syscallField(name string) interface{} {
+	if e.syscall == nil {
+		data, _ := e.msg.firstMessage(1300)
+		e.syscall = parseFields(data)
+	}
+
+	if v, ok := e.syscall[name]; ok {
+		return v
+	}
+	return nil
+}
+
+func (e *exprEnv) uid(key string, name bool) interface{} {
+	u := e.msg.Uids[key]
+	if u == nil {
+		return nil
+	}
+
+	if name {
+		return u.Name
+	}
+	return u.ID
+}
+
+func (e *exprEnv) lookup(name string) interface{} {
+	if fn, ok := exprFields[name]; ok {
+		return fn(e)
+	}
+
+	if e.flattened == nil {
+		e.flattened = flattenGroup(e.msg, ".")
+	}
+
+	if v, ok := e.flattened[name]; ok {
+		switch ev := v.(type) {
+		case string:
+			return ev
+		case int:
+			return float64(ev)
+		case int64:
+			return float64(ev)
+		default:
+			return fmt.Sprint(ev)
+		}
+	}
+	return nil
+}
+
+// Empty strings are fields the event does not have
+func exprString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Reports if the expression is true for the message group
+func (x *filterExpr) matches(msg *AuditMessageGroup) bool {
+	return exprTruthy(x.root.eval(&exprEnv{msg: msg}))
+}
+
+func (x *filterExpr) String() string {
+	return x.source
+}
+
+type exprNode interface {
+	eval(e *exprEnv) interface{}
+}
+
+type (
+	exprLiteral struct{ v interface{} }
+	exprName    struct{ name string }
+	exprList    []exprNode
+	exprNot     struct{ x exprNode }
+	exprAnd     struct{ l, r exprNode }
+	exprOr      struct{ l, r exprNode }
+	exprCompare struct {
+		op   string
+		l, r exprNode
+	}
+	exprMatch struct {
+		not bool
+		l   exprNode
+		re  *regexp.Regexp
+	}
+	exprIn struct{ l, r exprNode }
+)
+
+func (n exprLiteral) eval(e *exprEnv) interface{} { return n.v }
+func (n exprName) eval(e *exprEnv) interface{}    { return e.lookup(n.name) }
+func (n exprNot) eval(e *exprEnv) interface{}     { return !exprTruthy(n.x.eval(e)) }
+func (n exprAnd) eval(e *exprEnv) interface{} {
+	return exprTruthy(n.l.eval(e)) && exprTruthy(n.r.eval(e))
+}
+func (n exprOr) eval(e *exprEnv) interface{} {
+	return exprTruthy(n.l.eval(e)) || exprTruthy(n.r.eval(e))
+}
+
+func (n exprList) eval(e *exprEnv) interface{} {
+	vs := make([]interface{}, len(n))
+	for i, x := range n {
+		vs[i] = x.eval(e)
+	}
+	return vs
+}
+
+func (n exprCompare) eval(e *exprEnv) interface{} {
+	l, r := n.l.eval(e), n.r.eval(e)
+	switch n.op {
+	case "==":
+		return exprEqual(l, r)
+	case "!=":
+		return !exprEqual(l, r)
+	}
+
+	c, ok := exprOrder(l, r)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func (n exprMatch) eval(e *exprEnv) interface{} {
+	v := n.l.eval(e)
+	if v == nil {
+		return n.not
+	}
+	return n.re.MatchString(exprText(v)) != n.not
+}
+
+func (n exprIn) eval(e *exprEnv) interface{} {
+	l := n.l.eval(e)
+	switch list := n.r.eval(e).(type) {
+	case []interface{}:
+		for _, v := range list {
+			if exprEqual(l, v) {
+				return true
+			}
+		}
+	case []string:
+		for _, v := range list {
+			if exprEqual(l, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func exprTruthy(v interface{}) bool {
+	switch ev := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return ev
+	case string:
+		return ev != ""
+	case float64:
+		return ev != 0
+	}
+	return true
+}
+
+func exprText(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// Numbers as they come from audit records, pid=123, decimal only
+func exprNumber(v interface{}) (float64, bool) {
+	switch ev := v.(type) {
+	case float64:
+		return ev, true
+	case string:
+		return parseDecimal(ev)
+	}
+	return 0, false
+}
+
+// Parses plain decimals like -1 or 2.5. strconv.ParseFloat would also take inf, nan, exponents and hex, which turns
+// record values like a0=1e5 or comm="nan" into numbers
+func parseDecimal(s string) (float64, bool) {
+	digits, dots := 0, 0
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.':
+			dots++
+		case c == '-' && i == 0:
+		default:
+			return 0, false
+		}
+	}
+
+	if digits == 0 || dots > 1 {
+		return 0, false
+	}
+
+	// Anything too big for a float64 comes back as inf with an error
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+func exprEqual(l, r interface{}) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+
+	if lb, ok := l.(bool); ok {
+		rb, ok := r.(bool)
+		return ok && lb == rb
+	}
+
+	if lf, ok := exprNumber(l); ok {
+		if rf, ok := exprNumber(r); ok {
+			return lf == rf
+		}
+	}
+
+	return exprText(l) == exprText(r)
+}
+
+func exprOrder(l, r interface{}) (int, bool) {
+	if l == nil || r == nil {
+		return 0, false
+	}
+
+	if lf, ok := exprNumber(l); ok {
+		if rf, ok := exprNumber(r); ok {
+			switch {
+			case lf < rf:
+				return -1, true
+			case lf > rf:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	return strings.Compare(exprText(l), exprText(r)), true
+}
+
+// Compiles an expression, errors point at the offending token
+func parseFilterExpr(source string) (*filterExpr, error) {
+	p := &exprParser{source: source}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != exprEOF {
+		return nil, p.unexpected()
+	}
+
+	return &filterExpr{source: source, root: root}, nil
+}
+
+const (
+	exprEOF = iota
+	exprIdent
+	exprStr
+	exprNum
+	exprOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	source string
+	pos    int
+	tok    exprToken
+}
+
+// Two character operators go first so == isn't read as =
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func (p *exprParser) next() error {
+	for p.pos < len(p.source) && unicode.IsSpace(rune(p.source[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos >= len(p.source) {
+		p.tok = exprToken{kind: exprEOF, pos: start}
+		return nil
+	}
+
+	c := p.source[p.pos]
+	switch {
+	case c == '"' || c == '\'':
+		end := start + 1
+		var b strings.Builder
+		for ; end < len(p.source) && p.source[end] != c; end++ {
+			if p.source[end] == '\\' && end+1 < len(p.source) {
+				end++
+			}
+			b.WriteByte(p.source[end])
+		}
+
+		if end >= len(p.source) {
+			return fmt.Errorf("unterminated string at %d", start+1)
+		}
+
+		p.pos = end + 1
+		p.tok = exprToken{kind: exprStr, text: b.String(), pos: start}
+		return nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		end := start + 1
+		for end < len(p.source) && (p.source[end] == '.' || (p.source[end] >= '0' && p.source[end] <= '9')) {
+			end++
+		}
+
+		p.pos = end
+		p.tok = exprToken{kind: exprNum, text: p.source[start:end], pos: start}
+		return nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		end := start + 1
+		for end < len(p.source) && (p.source[end] == '_' || p.source[end] == '.' ||
+			unicode.IsLetter(rune(p.source[end])) || unicode.IsDigit(rune(p.source[end]))) {
+			end++
+		}
+
+		p.pos = end
+		p.tok = exprToken{kind: exprIdent, text: p.source[start:end], pos: start}
+		return nil
+	}
+
+	for _, op := range exprOperators {
+		if strings.HasPrefix(p.source[p.pos:], op) {
+			p.pos += len(op)
+			p.tok = exprToken{kind: exprOp, text: op, pos: start}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected `%c` at %d", c, start+1)
+}
+
+func (p *exprParser) unexpected() error {
+	if p.tok.kind == exprEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected `%s` at %d", p.source[p.tok.pos:p.pos], p.tok.pos+1)
+}
+
+// Moves past the current token if it is the operator op
+func (p *exprParser) accept(op string) (bool, error) {
+	if p.tok.kind != exprOp || p.tok.text != op {
+		return false, nil
+	}
+	return true, p.next()
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	for err == nil {
+		var ok bool
+		if ok, err = p.accept("||"); !ok || err != nil {
+			break
+		}
+
+		var r exprNode
+		if r, err = p.parseAnd(); err == nil {
+			l = exprOr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseNot()
+	for err == nil {
+		var ok bool
+		if ok, err = p.accept("&&"); !ok || err != nil {
+			break
+		}
+
+		var r exprNode
+		if r, err = p.parseNot(); err == nil {
+			l = exprAnd{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if ok, err := p.accept("!"); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+
+		x, err := p.parseNot()
+		return exprNot{x}, err
+	}
+
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.tok
+	switch {
+	case op.kind == exprIdent && op.text == "in":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := r.(exprLiteral); ok {
+			return nil, fmt.Errorf("the right side of `in` at %d must be a list or a field", op.pos+1)
+		}
+		return exprIn{l, r}, nil
+	case op.kind != exprOp:
+		return l, nil
+	}
+
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		r, err := p.parseOperand()
+		return exprCompare{op.text, l, r}, err
+	case "=~", "!~":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind != exprStr {
+			return nil, fmt.Errorf("the right side of `%s` at %d must be a quoted regex", op.text, op.pos+1)
+		}
+
+		re, err := regexp.Compile(p.tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("the regex at %d could not be parsed: %s", p.tok.pos+1, err)
+		}
+		return exprMatch{op.text == "!~", l, re}, p.next()
+	}
+
+	return l, nil
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case exprStr:
+		return exprLiteral{tok.text}, p.next()
+	case exprNum:
+		f, ok := parseDecimal(tok.text)
+		if !ok {
+			return nil, fmt.Errorf("`%s` at %d is not a number", tok.text, tok.pos+1)
+		}
+		return exprLiteral{f}, p.next()
+	case exprIdent:
+		switch tok.text {
+		case "true", "false":
+			return exprLiteral{tok.text == "true"}, p.next()
+		case "null":
+			return exprLiteral{nil}, p.next()
+		case "in":
+			return nil, p.unexpected()
+		}
+		return exprName{tok.text}, p.next()
+	case exprOp:
+		switch tok.text {
+		case "(":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+
+			if ok, err := p.accept(")"); !ok || err != nil {
+				if err == nil {
+					err = p.unexpected()
+				}
+				return nil, err
+			}
+			return x, nil
+		case "[":
+			return p.parseList()
+		}
+	}
+
+	return nil, p.unexpected()
+}
+
+func (p *exprParser) parseList() (exprNode, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	list := exprList{}
+	if ok, err := p.accept("]"); ok || err != nil {
+		return list, err
+	}
+
+	for {
+		x, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, x)
+
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+
+		if ok, err := p.accept("]"); !ok || err != nil {
+			if err == nil {
+				err = p.unexpected()
+			}
+			return nil, err
+		}
+		return list, nil
+	}
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_filterExpr(t *testing.T) {
+	success := true
+	exit := int64(-13)
+	connect := &AuditMessageGroup{
+		Syscall:     "42",
+		SyscallName: "connect",
+		Success:     &success,
+		Exit:        &exit,
+		Keys:        []string{"net", "egress"},
+		Sockaddr:    &SocketAddress{Family: "inet", Address: "10.1.2.3", Port: 443},
+		Uids:        map[string]*UidName{"uid": {ID: "998", Name: "chef"}},
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `arch=c000003e syscall=42 success=yes pid=1234 comm="curl" exe="/usr/bin/curl"`},
+			{Type: 1307, Data: `cwd="/var/lib/chef"`},
+		},
+	}
+	execve := &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{Type: 1300, Data: `syscall=59`}}}
+
+	for _, tc := range []struct {
+		expr    string
+		connect bool
+		execve  bool
+	}{
+		{`syscall == "connect" && dest_port != 53 && user.name != "chef"`, false, false},
+		{`syscall == "connect" && dest_port != 53 && user.name == 'chef'`, true, false},
+		{`syscall == "connect" || syscall == "execve"`, true, true},
+		{`!(syscall == "connect")`, false, true},
+		{`syscall in ["bind", "connect"]`, true, false},
+		{`"egress" in keys`, true, false},
+		{`dest_port >= 443 && dest_port < 1024`, true, false},
+		{`dest_ip =~ "^10\\."`, true, false},
+		{`exe !~ "^/usr/bin/"`, false, true},
+		{`pid == 1234 && pid > 999`, true, false},
+		{`exit == -13 && success`, true, false},
+		{`success == null`, false, true},
+		{`dest_port == null`, false, true},
+		{`cwd.cwd == "/var/lib/chef"`, true, false},
+		{`syscall_number == 59`, false, true},
+		{`comm == "curl" && auser.name == null`, true, false},
+	} {
+		x, err := parseFilterExpr(tc.expr)
+		if !assert.Nil(t, err, tc.expr) {
+			continue
+		}
+
+		assert.Equal(t, tc.connect, x.matches(connect), "%s on connect", tc.expr)
+		assert.Equal(t, tc.execve, x.matches(execve), "%s on execve", tc.expr)
+	}
+}
+
+func Test_parseDecimal(t *testing.T) {
+	for in, want := range map[string]float64{"0": 0, "-13": -13, "2.5": 2.5, "1.": 1, ".5": 0.5} {
+		f, ok := parseDecimal(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, f, in)
+	}
+
+	// Record values that only look like numbers to strconv
+	for _, in := range []string{"", "-", ".", "1.2.3", "1-2", "inf", "+Inf", "nan", "NaN", "1e5", "0x1p3", "0x10", "+1", " 1", "1_000", "1" + strings.Repeat("0", 400)} {
+		_, ok := parseDecimal(in)
+		assert.False(t, ok, in)
+	}
+
+	// Compared as text instead
+	x, err := parseFilterExpr(`comm == 100000 || exe == "nan"`)
+	assert.Nil(t, err)
+	assert.False(t, x.matches(&AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `comm="1e5" exe="inf"`}}}))
+	assert.True(t, x.matches(&AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `comm="100000" exe="inf"`}}}))
+	assert.True(t, x.matches(&AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `comm="1e5" exe="nan"`}}}))
+}
+
+func Test_parseFilterExpr_errors(t *testing.T) {
+	for expr, e := range map[string]string{
+		``:                          "unexpected end of expression",
+		`syscall ==`:                "unexpected end of expression",
+		`syscall == "connect`:       "unterminated string at 12",
+		`syscall = "connect"`:       "unexpected `=` at 9",
+		`(syscall == "a"`:           "unexpected end of expression",
+		`syscall == "a" "b"`:        "unexpected `\"b\"` at 16",
+		`exe =~ exe`:                "the right side of `=~` at 5 must be a quoted regex",
+		`exe =~ "("`:                "the regex at 8 could not be parsed: error parsing regexp: missing closing ): `(`",
+		`syscall in "connect"`:      "the right side of `in` at 9 must be a list or a field",
+		`syscall in ["a" "b"]`:      "unexpected `\"b\"` at 17",
+		`dest_port == 1.2.3`:        "`1.2.3` at 14 is not a number",
+		`dest_port == -`:            "`-` at 14 is not a number",
+		`syscall == "a" && $`:       "unexpected `$` at 19",
+		`syscall == "a" && || true`: "unexpected `||` at 19",
+	} {
+		_, err := parseFilterExpr(expr)
+		assert.EqualError(t, err, e, expr)
+	}
+}
+
+func TestCreateFilters_expr(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	c.Set("filters", []interface{}{
+		map[interface{}]interface{}{"expr": `syscall == "connect" && dest_port != 53`},
+		map[interface{}]interface{}{"key": "exec", "expr": `user.name in ["chef", "puppet"]`},
+	})
+
+	f, err := CreateFilters(c)
+	assert.Nil(t, err)
+	assert.Len(t, f, 2)
+	assert.Equal(t, "Ignoring events matching expr=syscall == \"connect\" && dest_port != 53\n"+
+		"Ignoring events matching key=exec expr=user.name in [\"chef\", \"puppet\"]\n", lb.String())
+
+	msg := &AuditMessageGroup{Keys: []string{"exec"}, Uids: map[string]*UidName{"uid": {ID: "0", Name: "puppet"}}}
+	assert.False(t, f[0].matches(msg))
+	assert.True(t, f[1].matches(msg))
+
+	c.Set("filters", []interface{}{map[interface{}]interface{}{"expr": `syscall ==`}})
+	_, err = CreateFilters(c)
+	assert.EqualError(t, err, "`expr` in filter 1 could not be parsed; Value: `syscall ==`; Error: unexpected end of expression")
+}
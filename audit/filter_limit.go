@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// filterLimit lets some of the events matching a filter through instead of dropping all of them
+// Sampling keeps the first of every sample events, the rate limit is a token bucket refilled with rate tokens a second
+// Both are kept per value of per, the rule keys or the exe of the event, or for the filter as a whole
+// It is only used from the receive loop and is not safe for concurrent use
+type filterLimit struct {
+	sample int
+	rate   float64
+	burst  float64
+	per    string
+
+	seen       map[string]int
+	buckets    map[string]*tokenBucket
+	suppressed uint64 // Events dropped since the last report
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Reports if the event may go through, counting it as suppressed if not
+func (f *filterLimit) allow(msg *AuditMessageGroup, now time.Time) bool {
+	key := f.limitKey(msg)
+
+	if f.sample > 1 {
+		if f.seen == nil || len(f.seen) >= defaultMaxTracked {
+			f.seen = map[string]int{}
+		}
+
+		n := f.seen[key]
+		f.seen[key] = (n + 1) % f.sample
+		if n != 0 {
+			f.suppressed++
+			return false
+		}
+	}
+
+	if f.rate > 0 {
+		if f.buckets == nil || len(f.buckets) >= defaultMaxTracked {
+			f.buckets = map[string]*tokenBucket{}
+		}
+
+		b, ok := f.buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: f.burst, last: now}
+			f.buckets[key] = b
+		}
+
+		if elapsed := now.Sub(b.last); elapsed > 0 {
+			b.tokens += elapsed.Seconds() * f.rate
+			if b.tokens > f.burst {
+				b.tokens = f.burst
+			}
+			b.last = now
+		}
+
+		if b.tokens < 1 {
+			f.suppressed++
+			return false
+		}
+		b.tokens--
+	}
+
+	return true
+}
+
+// The value events are limited by
+func (f *filterLimit) limitKey(msg *AuditMessageGroup) string {
+	switch f.per {
+	case "key":
+		return strings.Join(msg.Keys, ",")
+	case "exe":
+		exe, _ := msg.findField(1300, "exe")
+		return exe
+	}
+
+	return ""
+}
+
+func (f *filterLimit) String() string {
+	parts := []string{}
+	if f.sample > 1 {
+		parts = append(parts, fmt.Sprintf("sample=%d", f.sample))
+	}
+
+	if f.rate > 0 {
+		parts = append(parts, fmt.Sprintf("rate=%g burst=%g", f.rate, f.burst))
+	}
+
+	if f.per != "" {
+		parts = append(parts, "per="+f.per)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Parses the sample, rate, burst and per entries of a filter, false is returned if the key is not one of them
+func (f *AuditFilter) parseLimit(k interface{}, v interface{}, i int) (bool, error) {
+	switch k {
+	case "sample", "rate", "burst", "per":
+	default:
+		return false, nil
+	}
+
+	if f.limit == nil {
+		f.limit = &filterLimit{}
+	}
+
+	switch k {
+	case "sample":
+		n, ok := v.(int)
+		if !ok || n < 2 {
+			return true, fmt.Errorf("`sample` in filter %d must be a number greater than 1; Value: `%+v`", i, v)
+		}
+		f.limit.sample = n
+	case "rate", "burst":
+		var n float64
+		switch ev := v.(type) {
+		case int:
+			n = float64(ev)
+		case float64:
+			n = ev
+		}
+
+		if n <= 0 {
+			return true, fmt.Errorf("`%s` in filter %d must be a number greater than 0; Value: `%+v`", k, i, v)
+		}
+
+		if k == "rate" {
+			f.limit.rate = n
+		} else {
+			f.limit.burst = n
+		}
+	case "per":
+		f.limit.per, _ = v.(string)
+		if f.limit.per != "key" && f.limit.per != "exe" {
+			return true, fmt.Errorf("`per` in filter %d must be key or exe; Value: `%+v`", i, v)
+		}
+	}
+
+	return true, nil
+}
+
+// Checks the limit entries of a filter make sense together
+func (f *AuditFilter) checkLimit(i int) error {
+	l := f.limit
+	if l == nil {
+		return nil
+	}
+
+	if l.sample == 0 && l.rate == 0 {
+		return fmt.Errorf("Filter %d needs `sample` or `rate` to use `burst` or `per`", i)
+	}
+
+	if l.burst > 0 && l.rate == 0 {
+		return fmt.Errorf("`burst` in filter %d is only used with `rate`", i)
+	}
+
+	// A bucket that can't hold a single token lets nothing through
+	if l.rate > 0 && l.burst == 0 {
+		l.burst = l.rate
+	}
+
+	if l.rate > 0 && l.burst < 1 {
+		l.burst = 1
+	}
+
+	return nil
+}
+
+// Emits a summary of the events each sampling or rate limiting filter dropped since the last report
+func (a *AuditMarshaller) reportSuppressed(ctx context.Context) {
+	for i, f := range a.filterList {
+		if f.limit == nil || f.limit.suppressed == 0 {
+			continue
+		}
+
+		a.emit(ctx, newInternalEvent(EVENT_KERNEL, fmt.Sprintf(
+			"op=events_suppressed filter=%d suppressed=%d res=0", i+1, f.limit.suppressed,
+		)))
+		f.limit.suppressed = 0
+	}
+}
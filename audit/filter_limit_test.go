@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_filterLimit_sample(t *testing.T) {
+	f := &filterLimit{sample: 3, per: "key"}
+	now := time.Now()
+	a := &AuditMessageGroup{Keys: []string{"a"}}
+	b := &AuditMessageGroup{Keys: []string{"b"}}
+
+	kept := []bool{}
+	for i := 0; i < 7; i++ {
+		kept = append(kept, f.allow(a, now))
+	}
+	assert.Equal(t, []bool{true, false, false, true, false, false, true}, kept)
+
+	// Every key is sampled on its own
+	assert.True(t, f.allow(b, now))
+	assert.False(t, f.allow(b, now))
+	assert.Equal(t, uint64(5), f.suppressed)
+}
+
+func Test_filterLimit_rate(t *testing.T) {
+	f := &filterLimit{rate: 2, burst: 3, per: "exe"}
+	now := time.Now()
+	ls := &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `exe="/bin/ls"`}}}
+	cat := &AuditMessageGroup{Msgs: []*AuditMessage{{Type: 1300, Data: `exe="/bin/cat"`}}}
+
+	// The burst goes through right away
+	for i := 0; i < 3; i++ {
+		assert.True(t, f.allow(ls, now), "Event %d", i)
+	}
+	assert.False(t, f.allow(ls, now))
+	assert.True(t, f.allow(cat, now))
+
+	// 2 tokens a second
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, f.allow(ls, now))
+	assert.False(t, f.allow(ls, now))
+
+	// Never more than the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, f.allow(ls, now), "Event %d", i)
+	}
+	assert.False(t, f.allow(ls, now))
+	assert.Equal(t, uint64(3), f.suppressed)
+}
+
+func Test_parseFilters_limit(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	c.Set("filters", []interface{}{
+		map[interface{}]interface{}{"syscall": "openat", "sample": 100},
+		map[interface{}]interface{}{"key": "net", "rate": 10, "per": "exe"},
+		map[interface{}]interface{}{"syscall": 59, "message_type": 1300, "regex": "ls", "rate": 0.5},
+	})
+
+	f, err := CreateFilters(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &filterLimit{sample: 100}, f[0].limit)
+	assert.Equal(t, &filterLimit{rate: 10, burst: 10, per: "exe"}, f[1].limit)
+	assert.Equal(t, &filterLimit{rate: 0.5, burst: 1}, f[2].limit)
+	assert.Equal(t, "Limiting events matching syscall=openat sample=100\n"+
+		"Limiting events matching key=net rate=10 burst=10 per=exe\n"+
+		"Limiting events matching syscall=59 message_type=1300 regex=ls rate=0.5 burst=1\n", lb.String())
+
+	for _, tc := range []struct {
+		filter map[interface{}]interface{}
+		err    string
+	}{
+		{map[interface{}]interface{}{"key": "a", "sample": 1}, "`sample` in filter 1 must be a number greater than 1; Value: `1`"},
+		{map[interface{}]interface{}{"key": "a", "rate": "fast"}, "`rate` in filter 1 must be a number greater than 0; Value: `fast`"},
+		{map[interface{}]interface{}{"key": "a", "rate": 1, "burst": -1}, "`burst` in filter 1 must be a number greater than 0; Value: `-1`"},
+		{map[interface{}]interface{}{"key": "a", "rate": 1, "per": "uid"}, "`per` in filter 1 must be key or exe; Value: `uid`"},
+		{map[interface{}]interface{}{"key": "a", "per": "exe"}, "Filter 1 needs `sample` or `rate` to use `burst` or `per`"},
+		{map[interface{}]interface{}{"key": "a", "sample": 2, "burst": 3}, "`burst` in filter 1 is only used with `rate`"},
+		{map[interface{}]interface{}{"sample": 10}, "Filter 1 is missing the `regex` entry"},
+	} {
+		c.Set("filters", []interface{}{tc.filter})
+		_, err := CreateFilters(c)
+		assert.EqualError(t, err, tc.err)
+	}
+
+	// Outputs can't limit, nobody would hear about what they suppressed
+	c.Set("output.stdout.filters", []interface{}{map[interface{}]interface{}{"key": "a", "sample": 2}})
+	_, err = createFormattedOutput(c, "stdout")
+	assert.EqualError(t, err, "Filter 1 of output.stdout.filters can't sample or rate limit, only the top level filters can")
+}
+
+func TestAuditMarshaller_limitFilters(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), 1300, 1399, false, false, 0, []AuditFilter{
+		{messageType: 1300, regex: regexp.MustCompile(`comm="cat"`), syscall: "59"},
+		{syscallName: "execve", limit: &filterLimit{sample: 2}},
+	}, nil)
+
+	ls := &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{Type: 1300, Data: `comm="ls"`}}}
+	cat := &AuditMessageGroup{Syscall: "59", SyscallName: "execve", Msgs: []*AuditMessage{{Type: 1300, Data: `comm="cat"`}}}
+	connect := &AuditMessageGroup{Syscall: "42", SyscallName: "connect"}
+
+	// Plain filters win, the rest is sampled
+	assert.True(t, m.dropMessage(cat))
+	assert.False(t, m.dropMessage(ls))
+	assert.True(t, m.dropMessage(ls))
+	assert.False(t, m.dropMessage(ls))
+	assert.False(t, m.dropMessage(connect))
+
+	// Keep mode keeps the sampled events too
+	m.keepOnly = true
+	assert.False(t, m.dropMessage(cat))
+	assert.True(t, m.dropMessage(ls))
+	assert.False(t, m.dropMessage(ls))
+	assert.True(t, m.dropMessage(connect))
+
+	m.reportSuppressed(context.Background())
+	assert.Contains(t, w.String(), `"type":2000,"data":"op=events_suppressed filter=2 suppressed=2 res=0"`)
+	assert.Contains(t, w.String(), `"source":"go-audit"`)
+
+	// Nothing new to report
+	w.Reset()
+	m.reportSuppressed(context.Background())
+	assert.Empty(t, w.String())
+}
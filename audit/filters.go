@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// AuditFilter drops message groups before they reach enrichers, alerts and the output, or with filter_mode keep
+// drops every group that matches none of the filters
+// A regex filter drops groups of syscall that have a message_type record matching regex
+// A filter with parsed field conditions drops groups that match every condition that is set, regex and message_type
+// can be added to those as one more condition
+type AuditFilter struct {
+	messageType uint16
+	regex       *regexp.Regexp
+	syscall     string
+	disabled    bool
+
+	// Parsed field conditions
+	syscallName string // syscall given by name, like connect, matched against syscall_name
+	uid         string
+	username    string
+	exePrefix   string
+	key         string
+	success     *bool
+	dstNet      *net.IPNet
+	expr        *filterExpr
+
+	// Optional, lets some of the matching events through, see filterLimit
+	limit *filterLimit
+}
+
+// Field filters match on what the parser already extracted instead of raw record text
+func (f AuditFilter) hasFieldConditions() bool {
+	return f.syscallName != "" || f.uid != "" || f.username != "" || f.exePrefix != "" || f.key != "" ||
+		f.success != nil || f.dstNet != nil || f.expr != nil || (f.regex == nil && f.syscall != "")
+}
+
+// Reports if every condition of the filter matches the message group
+func (f AuditFilter) matches(msg *AuditMessageGroup) bool {
+	// Regex filters without a syscall only match events without one, like the marshaller's filter lookup
+	if f.syscall != msg.Syscall && (f.syscall != "" || !f.hasFieldConditions()) {
+		return false
+	}
+
+	if f.syscallName != "" && f.syscallName != msg.SyscallName {
+		return false
+	}
+
+	if f.uid != "" || f.username != "" {
+		u := msg.Uids["uid"]
+		if u == nil || (f.uid != "" && f.uid != u.ID) || (f.username != "" && f.username != u.Name) {
+			return false
+		}
+	}
+
+	if f.exePrefix != "" {
+		exe, _ := msg.findField(1300, "exe")
+		if !strings.HasPrefix(exe, f.exePrefix) {
+			return false
+		}
+	}
+
+	if f.key != "" && !hasKey(msg.Keys, f.key) {
+		return false
+	}
+
+	if f.success != nil && (msg.Success == nil || *msg.Success != *f.success) {
+		return false
+	}
+
+	if f.dstNet != nil {
+		s := msg.Sockaddr
+		if s == nil || (s.Family != "inet" && s.Family != "inet6") {
+			return false
+		}
+
+		if ip := net.ParseIP(s.Address); ip == nil || !f.dstNet.Contains(ip) {
+			return false
+		}
+	}
+
+	if f.expr != nil && !f.expr.matches(msg) {
+		return false
+	}
+
+	if f.regex != nil {
+		for _, m := range msg.Msgs {
+			if m.Type == f.messageType && f.regex.MatchString(m.Data) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Parses a parsed field condition into the filter, false is returned if the key is not one
+func (f *AuditFilter) parseFieldCondition(k interface{}, v interface{}, i int) (bool, error) {
+	switch k {
+	case "uid", "username", "exe_prefix", "key", "dst_ip":
+	case "success":
+		b, ok := v.(bool)
+		if !ok {
+			return true, fmt.Errorf("`success` in filter %d could not be parsed; Value: `%+v`", i, v)
+		}
+		f.success = &b
+		return true, nil
+	case "expr":
+		sv, _ := v.(string)
+		x, err := parseFilterExpr(sv)
+		if err != nil {
+			return true, fmt.Errorf("`expr` in filter %d could not be parsed; Value: `%+v`; Error: %s", i, v, err)
+		}
+		f.expr = x
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	sv, err := alertRuleString(k, v, fmt.Sprintf("filter %d", i))
+	if err != nil {
+		return true, err
+	}
+
+	switch k {
+	case "uid":
+		f.uid = sv
+	case "username":
+		f.username = sv
+	case "exe_prefix":
+		f.exePrefix = sv
+	case "key":
+		f.key = sv
+	case "dst_ip":
+		if !strings.Contains(sv, "/") {
+			if strings.Contains(sv, ":") {
+				sv += "/128"
+			} else {
+				sv += "/32"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(sv)
+		if err != nil {
+			return true, fmt.Errorf("`dst_ip` in filter %d could not be parsed; Value: `%s`; Error: %s", i, sv, err)
+		}
+		f.dstNet = n
+	}
+
+	return true, nil
+}
+
+// Describes the conditions of the filter, for the log and the control socket
+func (f AuditFilter) String() string {
+	if f.limit != nil {
+		cp := f
+		cp.limit = nil
+		return cp.String() + " " + f.limit.String()
+	}
+
+	if !f.hasFieldConditions() {
+		return fmt.Sprintf("syscall=%s message_type=%d regex=%s", f.syscall, f.messageType, f.regex)
+	}
+
+	parts := []string{}
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+
+	add("syscall", f.syscall)
+	add("syscall", f.syscallName)
+	add("uid", f.uid)
+	add("username", f.username)
+	add("exe_prefix", f.exePrefix)
+	add("key", f.key)
+	if f.success != nil {
+		add("success", strconv.FormatBool(*f.success))
+	}
+	if f.dstNet != nil {
+		add("dst_ip", f.dstNet.String())
+	}
+	if f.expr != nil {
+		add("expr", f.expr.String())
+	}
+	if f.regex != nil {
+		add("message_type", strconv.Itoa(int(f.messageType)))
+		add("regex", f.regex.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Parses a filter mode, true for keep. Keeping with no filters would drop every event
+func parseFilterMode(config *viper.Viper, key string, filters []AuditFilter) (bool, error) {
+	switch mode := config.GetString(key); mode {
+	case "", "drop":
+		return false, nil
+	case "keep":
+		if len(filters) == 0 {
+			return false, fmt.Errorf("%s is keep but there are no filters, every event would be dropped", key)
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s could not be parsed, it must be drop or keep; Value: `%s`", key, mode)
+	}
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditFilter_matches(t *testing.T) {
+	yes, no := true, false
+	_, tenNet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	msg := &AuditMessageGroup{
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `arch=c000003e syscall=42 success=yes exit=0 uid=0 exe="/usr/lib/systemd/systemd-resolved" key="net"`},
+			{Type: 1306, Data: "saddr=02000035080808080000000000000000"},
+		},
+		Uids:        map[string]*UidName{"uid": {ID: "0", Name: "root"}},
+		Syscall:     "42",
+		SyscallName: "connect",
+		Keys:        []string{"net", "egress"},
+		Success:     &yes,
+		Sockaddr:    &SocketAddress{Family: "inet", Address: "10.1.2.3", Port: 53},
+	}
+
+	for i, tc := range []struct {
+		filter AuditFilter
+		match  bool
+	}{
+		{AuditFilter{syscall: "42"}, true},
+		{AuditFilter{syscall: "43"}, false},
+		{AuditFilter{syscallName: "connect"}, true},
+		{AuditFilter{syscallName: "bind"}, false},
+		{AuditFilter{uid: "0"}, true},
+		{AuditFilter{uid: "1000"}, false},
+		{AuditFilter{username: "root"}, true},
+		{AuditFilter{uid: "0", username: "nobody"}, false},
+		{AuditFilter{exePrefix: "/usr/lib/systemd/"}, true},
+		{AuditFilter{exePrefix: "/usr/bin/"}, false},
+		{AuditFilter{key: "egress"}, true},
+		{AuditFilter{key: "exec"}, false},
+		{AuditFilter{success: &yes}, true},
+		{AuditFilter{success: &no}, false},
+		{AuditFilter{dstNet: tenNet}, true},
+		{AuditFilter{dstNet: &net.IPNet{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)}}, false},
+		{AuditFilter{syscallName: "connect", messageType: 1306, regex: regexp.MustCompile("saddr=0200")}, true},
+		{AuditFilter{syscallName: "connect", messageType: 1300, regex: regexp.MustCompile("saddr=0200")}, false},
+		{AuditFilter{syscallName: "connect", uid: "0", key: "net", success: &yes, dstNet: tenNet}, true},
+		{AuditFilter{syscallName: "connect", uid: "0", key: "net", success: &no, dstNet: tenNet}, false},
+	} {
+		assert.True(t, tc.filter.hasFieldConditions(), "Filter %d", i)
+		assert.Equal(t, tc.match, tc.filter.matches(msg), "Filter %d: %s", i, tc.filter)
+	}
+
+	// Events without the parsed field never match a condition on it
+	empty := &AuditMessageGroup{}
+	for _, f := range []AuditFilter{{uid: "0"}, {success: &no}, {dstNet: tenNet}, {key: "net"}, {exePrefix: "/"}} {
+		assert.False(t, f.matches(empty), "%s", f)
+	}
+}
+
+func TestAuditMarshaller_fieldFilters(t *testing.T) {
+	m := NewAuditMarshaller(nil, 1300, 1399, false, false, 0, []AuditFilter{
+		{messageType: 1300, regex: regexp.MustCompile("comm=\"ls\""), syscall: "59"},
+		{syscallName: "connect", uid: "0"},
+	}, nil)
+
+	assert.Len(t, m.fieldFilters, 1)
+
+	connect := &AuditMessageGroup{Syscall: "42", SyscallName: "connect", Uids: map[string]*UidName{"uid": {ID: "0"}}}
+	ls := &AuditMessageGroup{Syscall: "59", Msgs: []*AuditMessage{{Type: 1300, Data: `comm="ls"`}}}
+	cat := &AuditMessageGroup{Syscall: "59", Msgs: []*AuditMessage{{Type: 1300, Data: `comm="cat"`}}}
+
+	assert.True(t, m.dropMessage(connect))
+	assert.True(t, m.dropMessage(ls))
+	assert.False(t, m.dropMessage(cat))
+
+	// Disabled field filters are left out
+	m.filterList[1].disabled = true
+	m.buildFilters()
+	assert.Empty(t, m.fieldFilters)
+	assert.False(t, m.dropMessage(connect))
+}
+
+func TestAuditMarshaller_keepOnly(t *testing.T) {
+	m := NewAuditMarshaller(nil, 1300, 1399, false, false, 0, []AuditFilter{
+		{messageType: 1300, regex: regexp.MustCompile("comm=\"ls\""), syscall: "59"},
+		{key: "exec"},
+	}, nil)
+	m.keepOnly = true
+
+	keyed := &AuditMessageGroup{Syscall: "42", Keys: []string{"exec"}}
+	ls := &AuditMessageGroup{Syscall: "59", Msgs: []*AuditMessage{{Type: 1300, Data: `comm="ls"`}}}
+	cat := &AuditMessageGroup{Syscall: "59", Msgs: []*AuditMessage{{Type: 1300, Data: `comm="cat"`}}}
+
+	assert.False(t, m.dropMessage(keyed))
+	assert.False(t, m.dropMessage(ls))
+	assert.True(t, m.dropMessage(cat))
+}
+
+func Test_parseFilterMode(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	filters := []AuditFilter{{key: "exec"}}
+	c := viper.New()
+
+	keep, err := parseFilterMode(c, "filter_mode", nil)
+	assert.Nil(t, err)
+	assert.False(t, keep)
+
+	c.Set("filter_mode", "drop")
+	keep, err = parseFilterMode(c, "filter_mode", filters)
+	assert.Nil(t, err)
+	assert.False(t, keep)
+
+	c.Set("filter_mode", "keep")
+	keep, err = parseFilterMode(c, "filter_mode", filters)
+	assert.Nil(t, err)
+	assert.True(t, keep)
+
+	_, err = parseFilterMode(c, "filter_mode", nil)
+	assert.EqualError(t, err, "filter_mode is keep but there are no filters, every event would be dropped")
+
+	c.Set("filter_mode", "allow")
+	_, err = parseFilterMode(c, "filter_mode", filters)
+	assert.EqualError(t, err, "filter_mode could not be parsed, it must be drop or keep; Value: `allow`")
+
+	// Keep mode logs what is kept
+	c.Set("filter_mode", "keep")
+	c.Set("filters", []interface{}{map[interface{}]interface{}{"key": "exec"}})
+	_, err = CreateFilters(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "Keeping only events matching key=exec\n", lb.String())
+	lb.Reset()
+
+	// Outputs have their own mode
+	c.Set("output.stdout.filter_mode", "keep")
+	_, err = createFormattedOutput(c, "stdout")
+	assert.EqualError(t, err, "output.stdout.filter_mode is keep but there are no filters, every event would be dropped")
+
+	b := &bytes.Buffer{}
+	w := NewAuditWriter(b, 1)
+	w.filters, w.keepOnly = filters, true
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 1, Keys: []string{"exec"}}))
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 2, Keys: []string{"net"}}))
+	assert.Contains(t, b.String(), `"sequence":1`)
+	assert.NotContains(t, b.String(), `"sequence":2`)
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// flatColumns are the columns of a flattened event, for outputs that store events in tables like sql and parquet
+// Common SYSCALL fields get their own column for searching, the full event is kept in `event` as json
+// Only append to this list, existing tables and files depend on it
+var flatColumns = []string{
+	"sequence", "timestamp_ms",
+	"syscall", "success", "exit", "pid", "ppid", "uid", "auid", "comm", "exe", "key",
+	"username", "ausername", "cwd", "event",
+}
+
+// Columns filled from the SYSCALL record
+var flatSyscallColumns = flatColumns[2:12]
+
+// The parts of a json event needed to flatten it
+type flatEvent struct {
+	Seq         int               `json:"sequence"`
+	TimestampMs int64             `json:"timestamp_ms"`
+	Msgs        []*AuditMessage   `json:"messages"`
+	UidMap      map[string]string `json:"uid_map"`
+}
+
+// Flattens a json event into values for flatColumns, in the same order
+// sequence and timestamp_ms are ints, event is a string and the others are strings or nil if the field is missing
+func flattenEvent(row []byte) ([]interface{}, error) {
+	e := &flatEvent{}
+	if err := json.Unmarshal(row, e); err != nil {
+		return nil, err
+	}
+
+	var syscallFields, cwdFields map[string]string
+	for _, m := range e.Msgs {
+		data := m.Data
+		if m.Encoding == "base64" {
+			b, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				continue
+			}
+			data = string(b)
+		}
+
+		if m.Type == 1300 && syscallFields == nil {
+			syscallFields = parseFields(data)
+		} else if m.Type == 1307 && cwdFields == nil {
+			cwdFields = parseFields(data)
+		}
+	}
+
+	value := func(fields map[string]string, k string) interface{} {
+		if v, ok := fields[k]; ok {
+			return v
+		}
+		return nil
+	}
+
+	values := []interface{}{int64(e.Seq), e.TimestampMs}
+	for _, c := range flatSyscallColumns {
+		values = append(values, value(syscallFields, c))
+	}
+
+	values = append(values,
+		value(e.UidMap, syscallFields["uid"]),
+		value(e.UidMap, syscallFields["auid"]),
+		value(cwdFields, "cwd"),
+		strings.TrimRight(string(row), "\n"),
+	)
+
+	return values, nil
+}
@@ -0,0 +1,210 @@
+package audit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// Marshaler turns a completed message group into the bytes that are handed to an output
+type Marshaler interface {
+	Marshal(msg *AuditMessageGroup) ([]byte, error)
+}
+
+// MarshalerFactory creates a Marshaler, any format specific settings should be read from `formats.<name>`
+type MarshalerFactory func(config *viper.Viper) (Marshaler, error)
+
+const (
+	// SCHEMA_VERSION is emitted as `schema_version` and must be bumped whenever existing fields are removed or change meaning
+	// Adding new fields does not require a bump
+	// Version 1 is the original shape that had no schema_version field
+	SCHEMA_VERSION = 2
+)
+
+var marshalers = map[string]MarshalerFactory{}
+
+// RegisterMarshaler makes a Marshaler available to the `output.format` config option
+// This is meant to be called from an init() func, registering the same name twice will panic
+func RegisterMarshaler(name string, factory MarshalerFactory) {
+	if _, ok := marshalers[name]; ok {
+		panic(fmt.Sprintf("Marshaler `%s` is already registered", name))
+	}
+
+	marshalers[name] = factory
+}
+
+func init() {
+	RegisterMarshaler("json", func(config *viper.Viper) (Marshaler, error) {
+		j := &JSONMarshaler{
+			Compat: config.GetBool("formats.json.compat"),
+			Parsed: config.GetBool("formats.json.parsed"),
+		}
+
+		switch ts := config.GetString("formats.json.timestamp"); ts {
+		case "", "raw":
+		case "rfc3339":
+			j.RFC3339 = true
+		default:
+			return nil, fmt.Errorf("formats.json.timestamp could not be parsed; Value: `%s`", ts)
+		}
+
+		return j, nil
+	})
+}
+
+// Creates the marshaler named by `output.format`
+func createMarshaler(config *viper.Viper) (Marshaler, error) {
+	return createNamedMarshaler(config, config.GetString("output.format"))
+}
+
+// Creates a registered format by name, outputs can pick their own with `output.<name>.format`
+func createNamedMarshaler(config *viper.Viper, name string) (Marshaler, error) {
+	if name == "" {
+		name = "json"
+	}
+
+	factory, ok := marshalers[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown output format `%s`", name)
+	}
+
+	m, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the `%s` output format. Error: %s", name, err)
+	}
+
+	return m, nil
+}
+
+// JSONMarshaler is the default format, one json object per line
+type JSONMarshaler struct {
+	// Compat emits the original schema version 1 shape for consumers that can't handle new fields yet
+	Compat bool
+
+	// Parsed adds the key=value pairs of every message as a `fields` object, data is kept as is
+	Parsed bool
+
+	// RFC3339 rewrites `timestamp` from seconds.milliseconds to RFC3339 with milliseconds in output.timezone
+	RFC3339 bool
+}
+
+// versionedGroup places the schema version ahead of the message group fields
+type versionedGroup struct {
+	SchemaVersion int `json:"schema_version"`
+	*AuditMessageGroup
+}
+
+// legacyGroup is the frozen schema version 1 shape
+type legacyGroup struct {
+	Seq       int               `json:"sequence"`
+	AuditTime string            `json:"timestamp"`
+	Msgs      []*AuditMessage   `json:"messages"`
+	UidMap    map[string]string `json:"uid_map"`
+}
+
+func (j *JSONMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	if msgs, ok := encodeInvalidUTF8(msg.Msgs); ok && !j.Compat {
+		// Leave the original alone, other outputs and subscribers may still be looking at it
+		cp := *msg
+		cp.Msgs = msgs
+		msg = &cp
+	}
+
+	if j.Parsed && !j.Compat {
+		cp := *msg
+		cp.Msgs = parseMessageFields(msg.Msgs)
+		msg = &cp
+	}
+
+	// Timestamps that could not be parsed are left as they came
+	if j.RFC3339 && !j.Compat && msg.TimestampMs != 0 {
+		cp := *msg
+		cp.AuditTime = time.Unix(0, msg.TimestampMs*int64(time.Millisecond)).In(outputLocation).Format(humanTimeFormat)
+		msg = &cp
+	}
+
+	var v interface{} = &versionedGroup{SCHEMA_VERSION, msg}
+	if j.Compat {
+		v = &legacyGroup{
+			Seq:       msg.Seq,
+			AuditTime: msg.AuditTime,
+			Msgs:      msg.Msgs,
+			UidMap:    legacyUidMap(msg.UidMap),
+		}
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// Version 1 looked the unset id up like any other, which always came back as UNKNOWN_USER
+func legacyUidMap(uidMap map[string]string) map[string]string {
+	if name, ok := uidMap[unsetId]; !ok || name == "UNKNOWN_USER" {
+		return uidMap
+	}
+
+	cp := make(map[string]string, len(uidMap))
+	for k, v := range uidMap {
+		cp[k] = v
+	}
+	cp[unsetId] = "UNKNOWN_USER"
+	return cp
+}
+
+// Copies the messages with their fields parsed, see parseFields
+// Messages that had to be base64 encoded are left alone, their fields would not survive json either
+func parseMessageFields(msgs []*AuditMessage) []*AuditMessage {
+	out := make([]*AuditMessage, len(msgs))
+	for i, m := range msgs {
+		out[i] = m
+		if m.Encoding == "" {
+			cp := *m
+			cp.Fields = parseFields(m.Data)
+			out[i] = &cp
+		}
+	}
+
+	return out
+}
+
+// json.Marshal replaces invalid utf8 with U+FFFD, messages with data like that are swapped for base64 encoded copies
+// true is returned if anything had to be encoded
+func encodeInvalidUTF8(msgs []*AuditMessage) ([]*AuditMessage, bool) {
+	var out []*AuditMessage
+
+	for i, m := range msgs {
+		if utf8.ValidString(m.Data) {
+			if out != nil {
+				out = append(out, m)
+			}
+			continue
+		}
+
+		if out == nil {
+			out = make([]*AuditMessage, i, len(msgs))
+			copy(out, msgs[:i])
+		}
+
+		out = append(out, &AuditMessage{
+			Type:      m.Type,
+			Data:      base64.StdEncoding.EncodeToString([]byte(m.Data)),
+			Encoding:  "base64",
+			Seq:       m.Seq,
+			AuditTime: m.AuditTime,
+		})
+	}
+
+	if out == nil {
+		return msgs, false
+	}
+
+	return out, true
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterMarshaler("cef", createCEFMarshaler)
+}
+
+// CEF severities for the alert severities, an event without an alert gets formats.cef.severity
+var cefAlertSeverities = []int{1, 3, 5, 8, 10}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+var cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+// CEFMarshaler writes every event as an ArcSight Common Event Format line
+// CEF:0|Vendor|Product|Version|Signature ID|Name|Severity|Extension
+// The signature id and name are the syscall name, or the type of the first record for events without a syscall.
+// Alerts use the rule as the name and their severity. Extensions are filled from the event, see cefFields
+type CEFMarshaler struct {
+	Vendor     string
+	Product    string
+	Version    string
+	Severity   int
+	Extensions []CEFExtension
+}
+
+// CEFExtension fills the CEF extension Key with Field of the event
+type CEFExtension struct {
+	Key   string
+	Field string
+}
+
+func createCEFMarshaler(config *viper.Viper) (Marshaler, error) {
+	c := &CEFMarshaler{
+		Vendor:   config.GetString("formats.cef.vendor"),
+		Product:  config.GetString("formats.cef.product"),
+		Version:  config.GetString("formats.cef.version"),
+		Severity: config.GetInt("formats.cef.severity"),
+	}
+
+	if c.Severity < 0 || c.Severity > 10 {
+		return nil, fmt.Errorf("formats.cef.severity must be between 0 and 10, %v provided", c.Severity)
+	}
+
+	// A list instead of a map, viper lower cases map keys and CEF keys are case sensitive
+	for i, e := range config.GetStringSlice("formats.cef.extensions") {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || !cefKey(parts[0]) || parts[1] == "" {
+			return nil, fmt.Errorf("formats.cef.extensions entry %d could not be parsed, it must look like key=field; Value: `%s`", i+1, e)
+		}
+
+		c.Extensions = append(c.Extensions, CEFExtension{Key: parts[0], Field: parts[1]})
+	}
+
+	return c, nil
+}
+
+func (c *CEFMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	signature := msg.SyscallName
+	if signature == "" && msg.Syscall != "" {
+		signature = "syscall " + msg.Syscall
+	}
+	if signature == "" && len(msg.Msgs) > 0 {
+		signature = recordTypeName(msg.Msgs[0].Type)
+	}
+
+	name, severity := signature, c.Severity
+	if msg.Alert != nil {
+		name = msg.Alert.Rule
+		if msg.Alert.level < len(cefAlertSeverities) {
+			severity = cefAlertSeverities[msg.Alert.level]
+		}
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(c.Vendor),
+		cefHeaderEscaper.Replace(c.Product),
+		cefHeaderEscaper.Replace(c.Version),
+		cefHeaderEscaper.Replace(signature),
+		cefHeaderEscaper.Replace(name),
+		severity,
+	)
+
+	fields := cefFields(msg)
+	labels := map[string]bool{}
+	for _, e := range c.Extensions {
+		if strings.HasSuffix(e.Key, "Label") {
+			labels[e.Key] = true
+		}
+	}
+
+	first := true
+	add := func(k, v string) {
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(cefExtensionEscaper.Replace(v))
+	}
+
+	for _, e := range c.Extensions {
+		v, ok := fields[e.Field]
+		if !ok || v == "" {
+			continue
+		}
+
+		add(e.Key, v)
+
+		// Custom fields like cs1 need a label to mean anything, the field name is used unless one is mapped
+		if cefCustomKey(e.Key) && !labels[e.Key+"Label"] {
+			add(e.Key+"Label", e.Field)
+		}
+	}
+
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// The fields extensions can be filled from: every key of the flattened format, like syscall.exe or path.1.name, and
+// sequence, timestamp_ms, syscall_name, keys, argv, proctitle, exit_errno, username, ausername, sockaddr.family,
+// sockaddr.address, sockaddr.port, alert.rule and alert.severity
+func cefFields(msg *AuditMessageGroup) map[string]string {
+	fields := map[string]string{}
+	for k, v := range flattenGroup(msg, ".") {
+		fields[k] = fmt.Sprint(v)
+	}
+
+	fields["syscall_name"] = msg.SyscallName
+	fields["keys"] = strings.Join(msg.Keys, ",")
+	fields["argv"] = strings.Join(msg.Argv, " ")
+	fields["proctitle"] = msg.Proctitle
+	fields["exit_errno"] = msg.ExitErrno
+
+	if u := msg.Uids["uid"]; u != nil {
+		fields["username"] = u.Name
+	}
+
+	if u := msg.Uids["auid"]; u != nil {
+		fields["ausername"] = u.Name
+	}
+
+	if s := msg.Sockaddr; s != nil {
+		fields["sockaddr.family"] = s.Family
+		fields["sockaddr.address"] = s.Address
+		if s.Port != 0 {
+			fields["sockaddr.port"] = strconv.Itoa(s.Port)
+		}
+	}
+
+	if a := msg.Alert; a != nil {
+		fields["alert.rule"] = a.Rule
+		fields["alert.severity"] = a.Severity
+	}
+
+	return fields
+}
+
+// Extension keys are letters and digits
+func cefKey(k string) bool {
+	if k == "" {
+		return false
+	}
+
+	for _, r := range k {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The custom extensions that come with a label, like cs1 and cs1Label
+func cefCustomKey(k string) bool {
+	for _, prefix := range []string{"cs", "cn", "cfp", "flexString", "flexNumber", "flexDate", "c6a", "deviceCustomDate"} {
+		if n := strings.TrimPrefix(k, prefix); n != k && n != "" && strings.Trim(n, "0123456789") == "" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createCEFMarshaler(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "cef")
+	c.Set("formats.cef.vendor", "slackhq")
+	c.Set("formats.cef.product", "go-audit")
+	c.Set("formats.cef.version", "1")
+
+	c.Set("formats.cef.severity", 11)
+	m, err := createMarshaler(c)
+	assert.EqualError(t, err, "Failed to create the `cef` output format. Error: formats.cef.severity must be between 0 and 10, 11 provided")
+	assert.Nil(t, m)
+	c.Set("formats.cef.severity", 3)
+
+	for _, e := range []string{"rt", "rt=", "=sequence", "r t=sequence"} {
+		c.Set("formats.cef.extensions", []string{"externalId=sequence", e})
+		m, err = createMarshaler(c)
+		assert.EqualError(t, err, "Failed to create the `cef` output format. Error: formats.cef.extensions entry 2 could not be parsed, it must look like key=field; Value: `"+e+"`")
+		assert.Nil(t, m)
+	}
+
+	c.Set("formats.cef.extensions", []string{"externalId=sequence", "cs1=keys"})
+	m, err = createMarshaler(c)
+	assert.Nil(t, err)
+	assert.Equal(t, &CEFMarshaler{
+		Vendor:     "slackhq",
+		Product:    "go-audit",
+		Version:    "1",
+		Severity:   3,
+		Extensions: []CEFExtension{{"externalId", "sequence"}, {"cs1", "keys"}},
+	}, m)
+}
+
+func TestCEFMarshaler_Marshal(t *testing.T) {
+	m := &CEFMarshaler{
+		Vendor:   "slack|hq",
+		Product:  "go-audit",
+		Version:  "1",
+		Severity: 3,
+		Extensions: []CEFExtension{
+			{"rt", "timestamp_ms"}, {"externalId", "sequence"}, {"act", "syscall_name"}, {"suser", "username"},
+			{"sproc", "syscall.comm"}, {"filePath", "path.name"}, {"cs1", "keys"}, {"cs2", "syscall.exe"},
+			{"cs2Label", "syscall.comm"}, {"dst", "sockaddr.address"}, {"dpt", "sockaddr.port"},
+		},
+	}
+
+	exit := int64(0)
+	b, err := m.Marshal(&AuditMessageGroup{
+		Seq:         10,
+		AuditTime:   "1500000000.123",
+		TimestampMs: 1500000000123,
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `syscall=59 success=yes uid=1000 comm="a=b" exe="/bin/ls"`},
+			{Type: 1302, Data: `item=0 name="/tmp/x\y"`},
+		},
+		Uids:        map[string]*UidName{"uid": {ID: "1000", Name: "alice"}},
+		Syscall:     "59",
+		SyscallName: "execve",
+		Keys:        []string{"exec", "watch"},
+		Exit:        &exit,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		`CEF:0|slack\|hq|go-audit|1|execve|execve|3|rt=1500000000123 externalId=10 act=execve suser=alice sproc=a\=b `+
+			`filePath=/tmp/x\\y cs1=exec,watch cs1Label=keys cs2=/bin/ls cs2Label=a\=b`+"\n",
+		string(b),
+	)
+
+	// Alerts name the event and set the severity, events without a syscall use the record type
+	b, err = m.Marshal(&AuditMessageGroup{
+		Seq:      11,
+		Msgs:     []*AuditMessage{{Type: 1112, Data: "op=login\nacct=\"bob\""}},
+		Sockaddr: &SocketAddress{Family: "inet", Address: "10.0.0.1", Port: 22},
+		Alert:    &Alert{Rule: "login|bob", Severity: "high", level: 3},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `CEF:0|slack\|hq|go-audit|1|USER_LOGIN|login\|bob|8|externalId=11 dst=10.0.0.1 dpt=22`+"\n", string(b))
+
+	// A syscall without a known name
+	b, err = m.Marshal(&AuditMessageGroup{Seq: 12, Syscall: "999", Msgs: []*AuditMessage{{Type: 1300, Data: "syscall=999"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "CEF:0|slack\\|hq|go-audit|1|syscall 999|syscall 999|3|externalId=12\n", string(b))
+}
+
+func Test_cefCustomKey(t *testing.T) {
+	for _, k := range []string{"cs1", "cn3", "cfp2", "flexString1", "c6a4", "deviceCustomDate2"} {
+		assert.True(t, cefCustomKey(k), k)
+	}
+
+	for _, k := range []string{"cs", "cs1Label", "act", "cnt", "suser"} {
+		assert.False(t, cefCustomKey(k), k)
+	}
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterMarshaler("flattened", func(config *viper.Viper) (Marshaler, error) {
+		sep := config.GetString("formats.flattened.separator")
+		if sep == "" {
+			return nil, errors.New("formats.flattened.separator must be set")
+		}
+
+		return &FlattenedMarshaler{Separator: sep}, nil
+	})
+}
+
+// FlattenedMarshaler writes every event as a single level json object, for systems that can't search nested objects
+// Each parsed field of a record gets its own key named after the record type, like syscall.exe or cwd.cwd
+// Later records of the same type are numbered from 1, so the second PATH record of an event is path.1.name
+// Records without any key=value pairs keep their data as <type>.data
+type FlattenedMarshaler struct {
+	// Separator goes between the parts of a key
+	Separator string
+}
+
+func (f *FlattenedMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	b, err := json.Marshal(flattenGroup(msg, f.Separator))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// The keys and values of the flattened format
+func flattenGroup(msg *AuditMessageGroup, sep string) map[string]interface{} {
+	v := map[string]interface{}{
+		"schema_version": SCHEMA_VERSION,
+		"sequence":       msg.Seq,
+		"timestamp":      msg.AuditTime,
+	}
+
+	if msg.TimestampMs != 0 {
+		v["timestamp_ms"] = msg.TimestampMs
+	}
+
+	if msg.Source != "" {
+		v["source"] = msg.Source
+	}
+
+	if msg.Dedup != nil {
+		v["dedup"+sep+"count"] = msg.Dedup.Count
+		v["dedup"+sep+"last"] = msg.Dedup.Last
+	}
+
+	for uid, name := range msg.UidMap {
+		v["uid_map"+sep+uid] = name
+	}
+
+	seen := map[uint16]int{}
+	for _, m := range msg.Msgs {
+		prefix := flattenedTypeName(m.Type)
+		if n := seen[m.Type]; n > 0 {
+			prefix += sep + strconv.Itoa(n)
+		}
+		seen[m.Type]++
+
+		fields := parseFields(m.Data)
+		if len(fields) == 0 {
+			v[prefix+sep+"data"] = m.Data
+			continue
+		}
+
+		for k, value := range fields {
+			v[prefix+sep+k] = value
+		}
+	}
+
+	return v
+}
+
+// Lower case record type names, type_1234 for types without one
+func flattenedTypeName(t uint16) string {
+	if name, ok := recordTypeNames[t]; ok {
+		return strings.ToLower(name)
+	}
+
+	return "type_" + strconv.Itoa(int(t))
+}
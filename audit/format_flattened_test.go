@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenedMarshaler_Marshal(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "flattened")
+	m, err := createMarshaler(c)
+	assert.EqualError(t, err, "Failed to create the `flattened` output format. Error: formats.flattened.separator must be set")
+	assert.Nil(t, m)
+
+	c.Set("formats.flattened.separator", ".")
+	m, err = createMarshaler(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &FlattenedMarshaler{}, m)
+
+	b, err := m.Marshal(&AuditMessageGroup{
+		Seq:         10,
+		AuditTime:   "1500000000.123",
+		TimestampMs: 1500000000123,
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: "arch=c000003e syscall=59 success=yes uid=1000 exe=\"/bin/ls\""},
+			{Type: 1307, Data: "cwd=\"/root\""},
+			{Type: 1302, Data: "item=0 name=\"/bin/ls\""},
+			{Type: 1302, Data: "item=1 name=\"/lib64/ld-linux-x86-64.so.2\""},
+			{Type: 1999, Data: "what=yes"},
+			{Type: 1124, Data: "no pairs here"},
+		},
+		UidMap: map[string]string{"1000": "alice"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		`{"cwd.cwd":"/root",`+
+			`"path.1.item":"1","path.1.name":"/lib64/ld-linux-x86-64.so.2","path.item":"0","path.name":"/bin/ls",`+
+			`"schema_version":2,"sequence":10,`+
+			`"syscall.arch":"c000003e","syscall.exe":"/bin/ls","syscall.success":"yes","syscall.syscall":"59","syscall.uid":"1000",`+
+			`"timestamp":"1500000000.123","timestamp_ms":1500000000123,"type_1999.what":"yes","uid_map.1000":"alice",`+
+			`"user_tty.data":"no pairs here"}`+"\n",
+		string(b),
+	)
+
+	// Internal events have a source and keys can use another separator
+	m = &FlattenedMarshaler{Separator: "_"}
+	b, err = m.Marshal(&AuditMessageGroup{Seq: 0, AuditTime: "1", Msgs: []*AuditMessage{{Type: 1305, Data: "op=x"}}, Source: "go-audit"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"config_change_op":"x","schema_version":2,"sequence":0,"source":"go-audit","timestamp":"1"}`+"\n", string(b))
+}
@@ -0,0 +1,212 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterMarshaler("msgpack", func(config *viper.Viper) (Marshaler, error) {
+		return &MsgpackMarshaler{}, nil
+	})
+}
+
+// MsgpackMarshaler writes every event as a msgpack map with the same keys and values as the json format, json
+// consumers only need to swap their decoder. Values are self delimiting so events are written back to back.
+// Data that is not valid utf8 is base64 encoded with `encoding` set, the same as json
+type MsgpackMarshaler struct{}
+
+func (m *MsgpackMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	if msgs, ok := encodeInvalidUTF8(msg.Msgs); ok {
+		cp := *msg
+		cp.Msgs = msgs
+		msg = &cp
+	}
+
+	return msgpackAppendValue(make([]byte, 0, 512), reflect.ValueOf(&versionedGroup{SCHEMA_VERSION, msg}))
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+)
+
+// Appends v the way encoding/json would write it, following the json struct tags
+func msgpackAppendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, 0xc0), nil
+	}
+
+	if v.Type() == jsonNumberType {
+		return msgpackAppend(b, json.Number(v.String()))
+	}
+
+	// Types with their own json shape, like time.Time, go through json
+	if v.Kind() != reflect.Pointer && v.Type().Implements(jsonMarshalerType) {
+		return msgpackAppendJSON(b, v.Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return msgpackAppendValue(b, v.Elem())
+	case reflect.Bool:
+		return msgpackAppend(b, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return msgpackAppendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return msgpackAppend(b, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return msgpackAppend(b, v.Float())
+	case reflect.String:
+		return msgpackAppend(b, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return msgpackAppend(b, v.Bytes())
+		}
+
+		b = msgpackAppendCollection(b, v.Len(), 0x90, 0xdc)
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if b, err = msgpackAppendValue(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return msgpackAppendJSON(b, v.Interface())
+		}
+
+		// Sorted like json does, the same event always encodes the same way
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		b = msgpackAppendCollection(b, len(keys), 0x80, 0xde)
+		for _, k := range keys {
+			b, _ = msgpackAppend(b, k.String())
+
+			var err error
+			if b, err = msgpackAppendValue(b, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := msgpackStructFields(v, nil)
+
+		b = msgpackAppendCollection(b, len(fields), 0x80, 0xde)
+		for _, f := range fields {
+			b, _ = msgpackAppend(b, f.name)
+
+			var err error
+			if b, err = msgpackAppendValue(b, f.value); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("Can not encode %s as msgpack", v.Type())
+}
+
+type msgpackField struct {
+	name  string
+	value reflect.Value
+}
+
+// The exported fields of a struct that json would write, fields of embedded structs are included in their place
+func msgpackStructFields(v reflect.Value, fields []msgpackField) []msgpackField {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		fv := v.Field(i)
+		if sf.Anonymous && tag == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+
+			if fv.Kind() == reflect.Struct {
+				fields = msgpackStructFields(fv, fields)
+				continue
+			}
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+
+		if strings.Contains(","+opts+",", ",omitempty,") && msgpackEmpty(fv) {
+			continue
+		}
+
+		fields = append(fields, msgpackField{name, fv})
+	}
+
+	return fields
+}
+
+// The values json leaves out with omitempty
+func msgpackEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// Encodes v as json and writes the result, for the odd value that has no direct msgpack shape
+func msgpackAppendJSON(b []byte, v interface{}) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+
+	var out interface{}
+	if err := d.Decode(&out); err != nil {
+		return nil, err
+	}
+
+	return msgpackAppend(b, out)
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgpackMarshaler_Marshal(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "msgpack")
+	m, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &MsgpackMarshaler{}, m)
+
+	success := true
+	exit := int64(-13)
+	msg := &AuditMessageGroup{
+		Seq:         10,
+		AuditTime:   "1500000000.123",
+		TimestampMs: 1500000000123,
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: "arch=c000003e syscall=59 success=yes", Seq: 10},
+			{Type: 1302, Data: "name=\"\xff\xfe\""},
+		},
+		UidMap:      map[string]string{"0": "root"},
+		Uids:        map[string]*UidName{"uid": {ID: "0", Name: "root"}},
+		Syscall:     "59",
+		SyscallName: "execve",
+		Keys:        []string{"exec"},
+		Success:     &success,
+		Exit:        &exit,
+		Sockaddr:    &SocketAddress{Family: "inet", Address: "10.0.0.1", Port: 443},
+		Paths:       []*AuditPath{{Item: 0, Name: "/bin/ls"}},
+		Extra:       map[string]interface{}{"score": 1.5, "tags": []string{"a"}, "n": json.Number("12")},
+		Alert:       &Alert{Rule: "exec", Severity: "high", level: 3},
+	}
+
+	b, err := m.Marshal(msg)
+	assert.Nil(t, err)
+
+	r := bufio.NewReader(bytes.NewReader(b))
+	v, err := msgpackRead(r)
+	assert.Nil(t, err)
+	_, err = r.ReadByte()
+	assert.NotNil(t, err, "Trailing bytes after the event")
+
+	// Written back as json it must be the json format
+	got, err := json.Marshal(v)
+	assert.Nil(t, err)
+
+	want, err := (&JSONMarshaler{}).Marshal(msg)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(want), string(got))
+
+	// Integers stay integers
+	assert.Equal(t, int64(SCHEMA_VERSION), v.(map[string]interface{})["schema_version"])
+	assert.Equal(t, int64(-13), v.(map[string]interface{})["exit"])
+}
+
+func TestMsgpackMarshaler_empty(t *testing.T) {
+	b, err := (&MsgpackMarshaler{}).Marshal(&AuditMessageGroup{Seq: 1, AuditTime: "1.000"})
+	assert.Nil(t, err)
+
+	v, err := msgpackRead(bufio.NewReader(bytes.NewReader(b)))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"schema_version": int64(SCHEMA_VERSION),
+		"sequence":       int64(1),
+		"timestamp":      "1.000",
+		"messages":       nil,
+		"uid_map":        nil,
+	}, v)
+}
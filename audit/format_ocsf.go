@@ -0,0 +1,356 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterMarshaler("ocsf", func(config *viper.Viper) (Marshaler, error) {
+		hostname, _ := os.Hostname()
+		return &OCSFMarshaler{
+			Vendor:   config.GetString("formats.ocsf.vendor"),
+			Hostname: hostname,
+			RawData:  config.GetBool("formats.ocsf.raw_data"),
+		}, nil
+	})
+}
+
+// The version of the schema events are written in, https://schema.ocsf.io
+const ocsfVersion = "1.1.0"
+
+// An OCSF class and the category it belongs to
+type ocsfClass struct {
+	uid          int
+	name         string
+	categoryUID  int
+	categoryName string
+}
+
+var (
+	ocsfBaseEvent       = ocsfClass{0, "Base Event", 0, "Uncategorized"}
+	ocsfFileActivity    = ocsfClass{1001, "File System Activity", 1, "System Activity"}
+	ocsfProcessActivity = ocsfClass{1007, "Process Activity", 1, "System Activity"}
+	ocsfNetworkActivity = ocsfClass{4001, "Network Activity", 4, "Network Activity"}
+)
+
+// An activity of a class, type_uid is class_uid * 100 + activity_id
+type ocsfActivity struct {
+	class ocsfClass
+	id    int
+	name  string
+}
+
+var (
+	ocsfUnknown           = ocsfActivity{ocsfBaseEvent, 0, "Unknown"}
+	ocsfProcessLaunch     = ocsfActivity{ocsfProcessActivity, 1, "Launch"}
+	ocsfProcessTerminate  = ocsfActivity{ocsfProcessActivity, 2, "Terminate"}
+	ocsfProcessInject     = ocsfActivity{ocsfProcessActivity, 4, "Inject"}
+	ocsfProcessSetUserID  = ocsfActivity{ocsfProcessActivity, 5, "Set User ID"}
+	ocsfFileCreate        = ocsfActivity{ocsfFileActivity, 1, "Create"}
+	ocsfFileUpdate        = ocsfActivity{ocsfFileActivity, 3, "Update"}
+	ocsfFileDelete        = ocsfActivity{ocsfFileActivity, 4, "Delete"}
+	ocsfFileRename        = ocsfActivity{ocsfFileActivity, 5, "Rename"}
+	ocsfFileSetAttributes = ocsfActivity{ocsfFileActivity, 6, "Set Attributes"}
+	ocsfFileSetSecurity   = ocsfActivity{ocsfFileActivity, 7, "Set Security"}
+	ocsfFileMount         = ocsfActivity{ocsfFileActivity, 12, "Mount"}
+	ocsfFileUnmount       = ocsfActivity{ocsfFileActivity, 13, "Unmount"}
+	ocsfFileOpen          = ocsfActivity{ocsfFileActivity, 14, "Open"}
+	ocsfNetworkOpen       = ocsfActivity{ocsfNetworkActivity, 1, "Open"}
+	ocsfNetworkTraffic    = ocsfActivity{ocsfNetworkActivity, 6, "Traffic"}
+	ocsfNetworkListen     = ocsfActivity{ocsfNetworkActivity, 7, "Listen"}
+	ocsfNetworkOther      = ocsfActivity{ocsfNetworkActivity, 99, "Other"}
+)
+
+// The activity of each syscall, filled from ocsfActivitySyscalls
+var ocsfSyscallActivities = map[string]ocsfActivity{}
+
+var ocsfActivitySyscalls = map[ocsfActivity][]string{
+	ocsfProcessLaunch:     {"execve", "execveat"},
+	ocsfProcessTerminate:  {"kill", "tkill", "tgkill", "pidfd_send_signal"},
+	ocsfProcessInject:     {"ptrace", "process_vm_writev"},
+	ocsfProcessSetUserID:  {"setuid", "setreuid", "setresuid", "setfsuid", "setgid", "setregid", "setresgid", "setfsgid"},
+	ocsfFileCreate:        {"creat", "mkdir", "mkdirat", "mknod", "mknodat", "link", "linkat", "symlink", "symlinkat"},
+	ocsfFileUpdate:        {"truncate", "ftruncate"},
+	ocsfFileDelete:        {"unlink", "unlinkat", "rmdir"},
+	ocsfFileRename:        {"rename", "renameat", "renameat2"},
+	ocsfFileSetAttributes: {"setxattr", "lsetxattr", "fsetxattr", "removexattr", "lremovexattr", "fremovexattr", "utime", "utimes", "utimensat", "futimesat"},
+	ocsfFileSetSecurity:   {"chmod", "fchmod", "fchmodat", "chown", "fchown", "fchownat", "lchown"},
+	ocsfFileMount:         {"mount"},
+	ocsfFileUnmount:       {"umount", "umount2"},
+	ocsfFileOpen:          {"open", "openat", "openat2", "open_by_handle_at"},
+	ocsfNetworkOpen:       {"connect", "accept", "accept4"},
+	ocsfNetworkTraffic:    {"sendto", "sendmsg", "sendmmsg", "recvfrom", "recvmsg", "recvmmsg"},
+	ocsfNetworkListen:     {"bind", "listen"},
+}
+
+// OCSF severity_id by alert severity, Informational to Critical. Events without an alert are Informational
+var ocsfSeverities = []string{"Informational", "Low", "Medium", "High", "Critical"}
+
+func init() {
+	for a, syscalls := range ocsfActivitySyscalls {
+		for _, s := range syscalls {
+			ocsfSyscallActivities[s] = a
+		}
+	}
+}
+
+// OCSFMarshaler writes every event as an Open Cybersecurity Schema Framework event, one json object per line
+// Syscalls are mapped to Process Activity, File System Activity or Network Activity by name, like execve to
+// Process Activity: Launch. Anything else, including userspace events, is a Base Event
+// Rule keys become metadata.labels and fields OCSF has no place for are kept in unmapped
+type OCSFMarshaler struct {
+	Vendor   string
+	Hostname string
+
+	// RawData adds the records as the kernel sent them, like the raw format
+	RawData bool
+}
+
+func (o *OCSFMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	activity := ocsfUnknown
+	if a, ok := ocsfSyscallActivities[msg.SyscallName]; ok {
+		activity = a
+	} else if msg.Sockaddr != nil && msg.Syscall != "" {
+		// Any other syscall with a socket address, like getpeername
+		activity = ocsfNetworkOther
+	}
+
+	e := map[string]interface{}{
+		"class_uid":     activity.class.uid,
+		"class_name":    activity.class.name,
+		"category_uid":  activity.class.categoryUID,
+		"category_name": activity.class.categoryName,
+		"activity_id":   activity.id,
+		"activity_name": activity.name,
+		"type_uid":      activity.class.uid*100 + activity.id,
+		"type_name":     activity.class.name + ": " + activity.name,
+		"time":          msg.TimestampMs,
+		"metadata":      o.metadata(msg),
+		"device":        map[string]interface{}{"hostname": o.Hostname, "type_id": 0},
+	}
+
+	severity := 0
+	if msg.Alert != nil && msg.Alert.level < len(ocsfSeverities) {
+		severity = msg.Alert.level
+		e["message"] = msg.Alert.Rule
+	}
+	e["severity_id"] = severity + 1
+	e["severity"] = ocsfSeverities[severity]
+
+	if msg.Success != nil {
+		e["status_id"], e["status"] = 2, "Failure"
+		if *msg.Success {
+			e["status_id"], e["status"] = 1, "Success"
+		}
+	}
+
+	if msg.ExitErrno != "" {
+		e["status_code"] = msg.ExitErrno
+	}
+
+	syscallFields := map[string]string{}
+	for _, m := range msg.Msgs {
+		if m.Type == 1300 {
+			syscallFields = parseFields(m.Data)
+			break
+		}
+	}
+
+	actor := map[string]interface{}{}
+	if p := ocsfProcess(syscallFields); len(p) > 0 {
+		actor["process"] = p
+	}
+	if u := ocsfUser(msg, "uid"); u != nil {
+		actor["user"] = u
+	}
+
+	switch activity.class {
+	case ocsfProcessActivity:
+		if activity == ocsfProcessLaunch {
+			// The caller became the launched process, the parent is the one that launched it
+			p := ocsfProcess(syscallFields)
+			if len(msg.Argv) > 0 {
+				p["cmd_line"] = strings.Join(msg.Argv, " ")
+			}
+			e["process"] = p
+
+			if parent, ok := p["parent_process"]; ok {
+				actor["process"] = parent
+				delete(p, "parent_process")
+			} else {
+				delete(actor, "process")
+			}
+		} else if p := ocsfTargetProcess(msg); p != nil {
+			e["process"] = p
+		}
+
+	case ocsfFileActivity:
+		if path := ocsfPath(msg, "DELETE"); path != "" && activity == ocsfFileRename {
+			e["file"] = map[string]interface{}{"path": path, "name": filepath.Base(path)}
+			if result := ocsfPath(msg, "CREATE"); result != "" {
+				e["file_result"] = map[string]interface{}{"path": result, "name": filepath.Base(result)}
+			}
+		} else if path := ocsfPath(msg, ""); path != "" {
+			e["file"] = map[string]interface{}{"path": path, "name": filepath.Base(path)}
+		}
+
+	case ocsfNetworkActivity:
+		if s := msg.Sockaddr; s != nil && (s.Family == "inet" || s.Family == "inet6") {
+			endpoint := map[string]interface{}{"ip": s.Address}
+			if s.Port != 0 {
+				endpoint["port"] = s.Port
+			}
+
+			// A bound or listening socket is the local end
+			if activity == ocsfNetworkListen {
+				e["src_endpoint"] = endpoint
+			} else {
+				e["dst_endpoint"] = endpoint
+			}
+		}
+	}
+
+	if len(actor) > 0 {
+		e["actor"] = actor
+	}
+
+	if unmapped := o.unmapped(msg, syscallFields); len(unmapped) > 0 {
+		e["unmapped"] = unmapped
+	}
+
+	if o.RawData {
+		raw, _ := (&RawMarshaler{}).Marshal(msg)
+		e["raw_data"] = strings.TrimRight(string(raw), "\n")
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+func (o *OCSFMarshaler) metadata(msg *AuditMessageGroup) map[string]interface{} {
+	m := map[string]interface{}{
+		"version":       ocsfVersion,
+		"product":       map[string]interface{}{"name": "go-audit", "vendor_name": o.Vendor},
+		"uid":           strconv.Itoa(msg.Seq),
+		"original_time": msg.AuditTime,
+	}
+
+	if len(msg.Keys) > 0 {
+		m["labels"] = msg.Keys
+	}
+
+	return m
+}
+
+// The audit fields OCSF has no place for
+func (o *OCSFMarshaler) unmapped(msg *AuditMessageGroup, syscallFields map[string]string) map[string]interface{} {
+	u := map[string]interface{}{}
+	for _, k := range []string{"arch", "syscall", "exit", "auid", "ses", "euid", "suid", "fsuid", "gid", "egid", "tty"} {
+		if v, ok := syscallFields[k]; ok {
+			u[k] = v
+		}
+	}
+
+	if msg.SyscallName != "" {
+		u["syscall_name"] = msg.SyscallName
+	}
+
+	if au := ocsfUser(msg, "auid"); au != nil {
+		u["auser"] = au
+	}
+
+	if msg.Sockaddr != nil && msg.Sockaddr.Family == "unix" {
+		u["socket_path"] = msg.Sockaddr.Address
+	}
+
+	if msg.Source != "" {
+		u["source"] = msg.Source
+	}
+
+	return u
+}
+
+// The calling process from the SYSCALL record
+func ocsfProcess(fields map[string]string) map[string]interface{} {
+	p := map[string]interface{}{}
+	if pid, err := strconv.Atoi(fields["pid"]); err == nil {
+		p["pid"] = pid
+	}
+
+	if ppid, err := strconv.Atoi(fields["ppid"]); err == nil {
+		p["parent_process"] = map[string]interface{}{"pid": ppid}
+	}
+
+	if comm := fields["comm"]; comm != "" {
+		p["name"] = comm
+	}
+
+	if exe := fields["exe"]; exe != "" {
+		p["file"] = map[string]interface{}{"path": exe, "name": filepath.Base(exe)}
+	}
+
+	return p
+}
+
+// The process a kill or ptrace was aimed at, from the OBJ_PID record. nil if there is none
+func ocsfTargetProcess(msg *AuditMessageGroup) map[string]interface{} {
+	for _, m := range msg.Msgs {
+		if m.Type != 1318 {
+			continue
+		}
+
+		fields := parseFields(m.Data)
+		p := map[string]interface{}{}
+		if pid, err := strconv.Atoi(fields["opid"]); err == nil {
+			p["pid"] = pid
+		}
+
+		if comm := fields["ocomm"]; comm != "" {
+			p["name"] = comm
+		}
+
+		return p
+	}
+
+	return nil
+}
+
+// A user from one of the uid fields, nil if the event does not have it
+func ocsfUser(msg *AuditMessageGroup, field string) map[string]interface{} {
+	u := msg.Uids[field]
+	if u == nil {
+		return nil
+	}
+
+	user := map[string]interface{}{"uid": u.ID}
+	if u.Name != "" {
+		user["name"] = u.Name
+	}
+
+	return user
+}
+
+// The first path with the nametype, or the first that isn't a parent directory if nametype is empty
+func ocsfPath(msg *AuditMessageGroup, nametype string) string {
+	for _, p := range msg.Paths {
+		if p.Name == "" {
+			continue
+		}
+
+		if (nametype == "" && p.Nametype != "PARENT") || (nametype != "" && p.Nametype == nametype) {
+			return p.Name
+		}
+	}
+
+	return ""
+}
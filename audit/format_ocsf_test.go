@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func ocsfEvent(t *testing.T, m Marshaler, msg *AuditMessageGroup) map[string]interface{} {
+	b, err := m.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, byte('\n'), b[len(b)-1])
+
+	e := map[string]interface{}{}
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func TestOCSFMarshaler_process(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "ocsf")
+	c.Set("formats.ocsf.vendor", "slackhq")
+	m, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &OCSFMarshaler{}, m)
+
+	m.(*OCSFMarshaler).Hostname = "host"
+	success := true
+	e := ocsfEvent(t, m, &AuditMessageGroup{
+		Seq:         10,
+		AuditTime:   "1500000000.123",
+		TimestampMs: 1500000000123,
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `arch=c000003e syscall=59 success=yes exit=0 ppid=1 pid=2 auid=1000 uid=0 comm="ls" exe="/bin/ls"`},
+		},
+		Uids:        map[string]*UidName{"uid": {ID: "0", Name: "root"}, "auid": {ID: "1000", Name: "alice"}},
+		Syscall:     "59",
+		SyscallName: "execve",
+		Keys:        []string{"exec"},
+		Success:     &success,
+		Argv:        []string{"ls", "-l"},
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"class_uid":     1007.0,
+		"class_name":    "Process Activity",
+		"category_uid":  1.0,
+		"category_name": "System Activity",
+		"activity_id":   1.0,
+		"activity_name": "Launch",
+		"type_uid":      100701.0,
+		"type_name":     "Process Activity: Launch",
+		"time":          1500000000123.0,
+		"severity_id":   1.0,
+		"severity":      "Informational",
+		"status_id":     1.0,
+		"status":        "Success",
+		"metadata": map[string]interface{}{
+			"version":       "1.1.0",
+			"product":       map[string]interface{}{"name": "go-audit", "vendor_name": "slackhq"},
+			"uid":           "10",
+			"original_time": "1500000000.123",
+			"labels":        []interface{}{"exec"},
+		},
+		"device": map[string]interface{}{"hostname": "host", "type_id": 0.0},
+		"actor": map[string]interface{}{
+			"process": map[string]interface{}{"pid": 1.0},
+			"user":    map[string]interface{}{"uid": "0", "name": "root"},
+		},
+		"process": map[string]interface{}{
+			"pid":      2.0,
+			"name":     "ls",
+			"cmd_line": "ls -l",
+			"file":     map[string]interface{}{"path": "/bin/ls", "name": "ls"},
+		},
+		"unmapped": map[string]interface{}{
+			"arch":         "c000003e",
+			"syscall":      "59",
+			"syscall_name": "execve",
+			"exit":         "0",
+			"auid":         "1000",
+			"auser":        map[string]interface{}{"uid": "1000", "name": "alice"},
+		},
+	}, e)
+
+	// The target of a kill is the OBJ_PID record
+	e = ocsfEvent(t, m, &AuditMessageGroup{
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `syscall=62 pid=2 comm="kill"`},
+			{Type: 1318, Data: `opid=3 oauid=-1 ouid=0 ocomm="sleep"`},
+		},
+		SyscallName: "kill",
+		Alert:       &Alert{Rule: "kill", Severity: "high", level: 3},
+	})
+
+	assert.Equal(t, 100702.0, e["type_uid"])
+	assert.Equal(t, map[string]interface{}{"pid": 3.0, "name": "sleep"}, e["process"])
+	assert.Equal(t, map[string]interface{}{"process": map[string]interface{}{"pid": 2.0, "name": "kill"}}, e["actor"])
+	assert.Equal(t, 4.0, e["severity_id"])
+	assert.Equal(t, "High", e["severity"])
+	assert.Equal(t, "kill", e["message"])
+}
+
+func TestOCSFMarshaler_file(t *testing.T) {
+	m := &OCSFMarshaler{Vendor: "slackhq", Hostname: "host"}
+	failed := false
+
+	e := ocsfEvent(t, m, &AuditMessageGroup{
+		SyscallName: "renameat2",
+		Success:     &failed,
+		ExitErrno:   "EACCES",
+		Paths: []*AuditPath{
+			{Item: 0, Name: "/tmp/", Nametype: "PARENT"},
+			{Item: 1, Name: "/tmp/a", Nametype: "DELETE"},
+			{Item: 2, Name: "/tmp/b", Nametype: "CREATE"},
+		},
+	})
+
+	assert.Equal(t, 100105.0, e["type_uid"])
+	assert.Equal(t, "File System Activity: Rename", e["type_name"])
+	assert.Equal(t, map[string]interface{}{"path": "/tmp/a", "name": "a"}, e["file"])
+	assert.Equal(t, map[string]interface{}{"path": "/tmp/b", "name": "b"}, e["file_result"])
+	assert.Equal(t, 2.0, e["status_id"])
+	assert.Equal(t, "Failure", e["status"])
+	assert.Equal(t, "EACCES", e["status_code"])
+
+	e = ocsfEvent(t, m, &AuditMessageGroup{
+		SyscallName: "openat",
+		Paths:       []*AuditPath{{Item: 0, Name: "/etc/shadow", Nametype: "NORMAL"}},
+	})
+
+	assert.Equal(t, 100114.0, e["type_uid"])
+	assert.Equal(t, map[string]interface{}{"path": "/etc/shadow", "name": "shadow"}, e["file"])
+	assert.NotContains(t, e, "actor")
+	assert.NotContains(t, e, "status_id")
+}
+
+func TestOCSFMarshaler_network(t *testing.T) {
+	m := &OCSFMarshaler{Vendor: "slackhq", Hostname: "host"}
+
+	e := ocsfEvent(t, m, &AuditMessageGroup{
+		Syscall:     "42",
+		SyscallName: "connect",
+		Sockaddr:    &SocketAddress{Family: "inet", Address: "10.0.0.1", Port: 443},
+	})
+	assert.Equal(t, 400101.0, e["type_uid"])
+	assert.Equal(t, map[string]interface{}{"ip": "10.0.0.1", "port": 443.0}, e["dst_endpoint"])
+
+	e = ocsfEvent(t, m, &AuditMessageGroup{
+		Syscall:     "49",
+		SyscallName: "bind",
+		Sockaddr:    &SocketAddress{Family: "inet6", Address: "::1", Port: 8080},
+	})
+	assert.Equal(t, 400107.0, e["type_uid"])
+	assert.Equal(t, map[string]interface{}{"ip": "::1", "port": 8080.0}, e["src_endpoint"])
+
+	// Unix sockets have no endpoint
+	e = ocsfEvent(t, m, &AuditMessageGroup{
+		Syscall:  "51",
+		Sockaddr: &SocketAddress{Family: "unix", Address: "/run/docker.sock"},
+	})
+	assert.Equal(t, 400199.0, e["type_uid"])
+	assert.NotContains(t, e, "dst_endpoint")
+	assert.Equal(t, map[string]interface{}{"socket_path": "/run/docker.sock"}, e["unmapped"])
+}
+
+func TestOCSFMarshaler_base(t *testing.T) {
+	m := &OCSFMarshaler{Vendor: "slackhq", Hostname: "host", RawData: true}
+
+	e := ocsfEvent(t, m, &AuditMessageGroup{
+		Seq:       11,
+		AuditTime: "1500000000.123",
+		Msgs:      []*AuditMessage{{Type: 1112, Data: "op=login acct=\"bob\"", Seq: 11, AuditTime: "1500000000.123"}},
+		Source:    "",
+	})
+
+	assert.Equal(t, 0.0, e["class_uid"])
+	assert.Equal(t, "Base Event: Unknown", e["type_name"])
+	assert.Equal(t, `type=USER_LOGIN msg=audit(1500000000.123:11): op=login acct="bob"`, e["raw_data"])
+	assert.NotContains(t, e, "unmapped")
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterMarshaler("raw", func(config *viper.Viper) (Marshaler, error) {
+		return &RawMarshaler{}, nil
+	})
+}
+
+// RawMarshaler writes the records of an event as the kernel sent them, one line per record like audit.log
+// type=SYSCALL msg=audit(1500000000.123:10): arch=c000003e syscall=59 ...
+// Nothing go-audit adds, like uid_map or alerts, is included. Data is written as is, invalid utf8 included
+type RawMarshaler struct{}
+
+func (r *RawMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	b := &bytes.Buffer{}
+	for _, m := range msg.Msgs {
+		b.WriteString("type=")
+		b.WriteString(recordTypeName(m.Type))
+
+		switch {
+		case m.parseErr != nil:
+			// The header could not be parsed, it is still part of data
+			b.WriteString(" msg=")
+			b.WriteString(m.Data)
+		case m.AuditTime != "":
+			fmt.Fprintf(b, " msg=audit(%s:%d): %s", m.AuditTime, m.Seq, m.Data)
+		default:
+			// Records made up by go-audit itself get the header of their event
+			fmt.Fprintf(b, " msg=audit(%s:%d): %s", msg.AuditTime, msg.Seq, m.Data)
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawMarshaler_Marshal(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "raw")
+	m, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &RawMarshaler{}, m)
+
+	b, err := m.Marshal(&AuditMessageGroup{
+		Seq:       10,
+		AuditTime: "1500000000.123",
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: "arch=c000003e syscall=59 success=yes", Seq: 10, AuditTime: "1500000000.123"},
+			{Type: 1302, Data: "item=0 name=\"/bin/ls\"", Seq: 10, AuditTime: "1500000000.123"},
+			{Type: 1999, Data: "what=\xff", Seq: 10, AuditTime: "1500000000.123"},
+			{Type: 1300, Data: "audit(15000:", parseErr: errors.New("Header is too short")},
+			// Made up by go-audit
+			{Type: 1305, Data: "op=tamper"},
+		},
+		UidMap: map[string]string{"0": "root"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		"type=SYSCALL msg=audit(1500000000.123:10): arch=c000003e syscall=59 success=yes\n"+
+			"type=PATH msg=audit(1500000000.123:10): item=0 name=\"/bin/ls\"\n"+
+			"type=UNKNOWN[1999] msg=audit(1500000000.123:10): what=\xff\n"+
+			"type=SYSCALL msg=audit(15000:\n"+
+			"type=CONFIG_CHANGE msg=audit(1500000000.123:10): op=tamper\n",
+		string(b),
+	)
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterMarshaler("template", createTemplateMarshaler)
+}
+
+// Functions available to format templates on top of the text/template builtins
+var formatTemplateFuncs = template.FuncMap{
+	"json":  webhookFuncs["json"],
+	"join":  strings.Join,
+	"quote": strconv.Quote,
+	// {{default "-" .Username}}
+	"default": func(d string, v string) string {
+		if v == "" {
+			return d
+		}
+		return v
+	},
+}
+
+// TemplateMarshaler writes every event as the output of a text/template, for systems that expect a fixed layout
+// The template is executed with a templateEvent, a newline is added if the output does not end with one
+type TemplateMarshaler struct {
+	Template *template.Template
+}
+
+// templateEvent is what format templates are executed against. It has everything alert templates do, like .Seq,
+// .SyscallName, .Hostname, .Time and .Fields.exe, plus the fields most layouts need by name
+type templateEvent struct {
+	*alertTemplateData
+
+	Timestamp string // The audit timestamp as the kernel sent it, like 1500000000.123
+	Exe       string
+	Comm      string
+	Pid       string
+	Ppid      string
+	Cwd       string
+	Uid       string
+	Username  string
+	Auid      string
+	AUsername string
+
+	flattened map[string]interface{}
+}
+
+func createTemplateMarshaler(config *viper.Viper) (Marshaler, error) {
+	text := config.GetString("formats.template.template")
+	if text == "" {
+		return nil, errors.New("formats.template.template must be set")
+	}
+
+	tmpl, err := template.New("format").Funcs(formatTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse formats.template.template. Error: %s", err)
+	}
+
+	return &TemplateMarshaler{Template: tmpl}, nil
+}
+
+func (t *TemplateMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if err := t.Template.Execute(b, newTemplateEvent(msg)); err != nil {
+		return nil, fmt.Errorf("Failed to execute template. Error: %s", err)
+	}
+
+	if b.Len() == 0 || b.Bytes()[b.Len()-1] != '\n' {
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes(), nil
+}
+
+func newTemplateEvent(msg *AuditMessageGroup) *templateEvent {
+	data := newAlertTemplateData(msg)
+	e := &templateEvent{
+		alertTemplateData: data,
+		Timestamp:         msg.AuditTime,
+		Exe:               data.Fields["exe"],
+		Comm:              data.Fields["comm"],
+		Pid:               data.Fields["pid"],
+		Ppid:              data.Fields["ppid"],
+	}
+
+	if cwd, ok := msg.firstMessage(1307); ok {
+		e.Cwd = parseFields(cwd)["cwd"]
+	}
+
+	if u := msg.Uids["uid"]; u != nil {
+		e.Uid, e.Username = u.ID, u.Name
+	}
+
+	if u := msg.Uids["auid"]; u != nil {
+		e.Auid, e.AUsername = u.ID, u.Name
+	}
+
+	return e
+}
+
+// Field is any key of the flattened format, like path.1.name or cwd.cwd. Empty if the event does not have it
+func (e *templateEvent) Field(name string) string {
+	if e.flattened == nil {
+		e.flattened = flattenGroup(e.AuditMessageGroup, ".")
+	}
+
+	if v, ok := e.flattened[name]; ok {
+		return fmt.Sprint(v)
+	}
+
+	return ""
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateMarshaler_Marshal(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "template")
+	c.Set("formats.template.template", `{{.Timestamp}} {{.Seq}} {{.SyscallName}} {{.Exe}} by {{.Username}}/{{.AUsername}} in {{.Cwd}} {{quote (join .Argv " ")}} {{.Field "path.1.name"}}{{.Field "missing"}} {{default "-" .ExitErrno}}`)
+	m, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &TemplateMarshaler{}, m)
+
+	msg := &AuditMessageGroup{
+		Seq:         10,
+		AuditTime:   "1500000000.123",
+		TimestampMs: 1500000000123,
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `arch=c000003e syscall=59 success=yes exit=0 pid=2 comm="ls" exe="/bin/ls"`},
+			{Type: 1307, Data: `cwd="/root"`},
+			{Type: 1302, Data: `item=0 name="/bin/ls"`},
+			{Type: 1302, Data: `item=1 name="/lib64/ld-linux-x86-64.so.2"`},
+		},
+		Uids:        map[string]*UidName{"uid": {ID: "0", Name: "root"}, "auid": {ID: "1000", Name: "alice"}},
+		SyscallName: "execve",
+		Argv:        []string{"ls", "-l"},
+	}
+
+	b, err := m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "1500000000.123 10 execve /bin/ls by root/alice in /root \"ls -l\" /lib64/ld-linux-x86-64.so.2 -\n", string(b))
+
+	// A trailing newline is not doubled, the time is in output.timezone
+	c.Set("formats.template.template", "{{.Time}} {{.Comm}} {{.Fields.missing}} {{json .Argv}}\n")
+	m, err = createMarshaler(c)
+	assert.Nil(t, err)
+
+	b, err = m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "2017-07-14T02:40:00.123Z ls  [\"ls\",\"-l\"]\n", string(b))
+
+	// Execution errors are returned
+	c.Set("formats.template.template", "{{.Alert.Rule}}")
+	m, err = createMarshaler(c)
+	assert.Nil(t, err)
+
+	_, err = m.Marshal(msg)
+	assert.Contains(t, err.Error(), "Failed to execute template. Error: ")
+}
+
+func Test_createTemplateMarshaler(t *testing.T) {
+	c := viper.New()
+	c.Set("output.format", "template")
+
+	_, err := createMarshaler(c)
+	assert.EqualError(t, err, "Failed to create the `template` output format. Error: formats.template.template must be set")
+
+	c.Set("formats.template.template", "{{.Exe")
+	_, err = createMarshaler(c)
+	assert.Contains(t, err.Error(), "Could not parse formats.template.template. Error: ")
+
+	c.Set("formats.template.template", "{{nope .Exe}}")
+	_, err = createMarshaler(c)
+	assert.Contains(t, err.Error(), `function "nope" not defined`)
+}
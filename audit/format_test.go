@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterMarshaler(t *testing.T) {
+	defer delete(marshalers, "test")
+
+	RegisterMarshaler("test", func(config *viper.Viper) (Marshaler, error) {
+		return nil, errors.New("derp")
+	})
+	assert.Contains(t, marshalers, "test")
+
+	assert.Panics(t, func() {
+		RegisterMarshaler("test", nil)
+	}, "Registering the same name twice should panic")
+}
+
+func Test_createMarshaler(t *testing.T) {
+	defer delete(marshalers, "test")
+
+	// default
+	c := viper.New()
+	m, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &JSONMarshaler{}, m)
+
+	// unknown
+	c.Set("output.format", "nope")
+	m, err = createMarshaler(c)
+	assert.EqualError(t, err, "Unknown output format `nope`")
+	assert.Nil(t, m)
+
+	// factory error
+	RegisterMarshaler("test", func(config *viper.Viper) (Marshaler, error) {
+		return nil, errors.New("derp")
+	})
+	c.Set("output.format", "test")
+	m, err = createMarshaler(c)
+	assert.EqualError(t, err, "Failed to create the `test` output format. Error: derp")
+	assert.Nil(t, m)
+}
+
+func TestJSONMarshaler_Marshal(t *testing.T) {
+	m := &JSONMarshaler{}
+	b, err := m.Marshal(&AuditMessageGroup{
+		Seq:       1,
+		AuditTime: "10000001",
+		Msgs:      []*AuditMessage{{Type: 1300, Data: "hi <there>"}},
+		UidMap:    map[string]string{"0": "root"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		"{\"schema_version\":2,\"sequence\":1,\"timestamp\":\"10000001\",\"messages\":[{\"type\":1300,\"data\":\"hi \\u003cthere\\u003e\"}],\"uid_map\":{\"0\":\"root\"}}\n",
+		string(b),
+	)
+}
+
+func TestJSONMarshaler_Compat(t *testing.T) {
+	m := &JSONMarshaler{Compat: true}
+	b, err := m.Marshal(&AuditMessageGroup{
+		Seq:       1,
+		AuditTime: "10000001",
+		Msgs:      []*AuditMessage{{Type: 1300, Data: "hi there"}},
+		UidMap:    map[string]string{"0": "root"},
+		Syscall:   "59",
+		Extra:     map[string]interface{}{"not": "included"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		"{\"sequence\":1,\"timestamp\":\"10000001\",\"messages\":[{\"type\":1300,\"data\":\"hi there\"}],\"uid_map\":{\"0\":\"root\"}}\n",
+		string(b),
+	)
+
+	// The unset id keeps the name it always had, whatever uid_lookup.unset says
+	msg := &AuditMessageGroup{Seq: 1, AuditTime: "1", UidMap: map[string]string{"0": "root", unsetId: "unset"}}
+	b, err = m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"uid_map":{"0":"root","4294967295":"UNKNOWN_USER"}`)
+	assert.Equal(t, "unset", msg.UidMap[unsetId], "The group should be left alone")
+
+	b, err = (&JSONMarshaler{}).Marshal(msg)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"uid_map":{"0":"root","4294967295":"unset"}`)
+
+	// configured through formats.json.compat
+	c := viper.New()
+	c.Set("formats.json.compat", true)
+	jm, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.True(t, jm.(*JSONMarshaler).Compat)
+}
+
+func TestJSONMarshaler_invalidUTF8(t *testing.T) {
+	msg := &AuditMessageGroup{
+		Seq:       1,
+		AuditTime: "10000001",
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: "fine"},
+			{Type: 1302, Data: "name=\xff\xfe"},
+		},
+		UidMap: map[string]string{},
+	}
+
+	m := &JSONMarshaler{}
+	b, err := m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		"{\"schema_version\":2,\"sequence\":1,\"timestamp\":\"10000001\",\"messages\":[{\"type\":1300,\"data\":\"fine\"},{\"type\":1302,\"data\":\"bmFtZT3//g==\",\"encoding\":\"base64\"}],\"uid_map\":{}}\n",
+		string(b),
+	)
+	assert.Equal(t, "name=\xff\xfe", msg.Msgs[1].Data, "The original message should not be changed")
+
+	// compat keeps the original behavior
+	m.Compat = true
+	b, err = m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "\"data\":\"name=��\"")
+}
+
+func TestJSONMarshaler_Parsed(t *testing.T) {
+	msg := &AuditMessageGroup{
+		Seq:       1,
+		AuditTime: "10000001",
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: `syscall=59 uid=0 exe="/bin/ls"`},
+			{Type: 1100, Data: `pid=1 msg='op=login acct="bob" res=success'`},
+			{Type: 1302, Data: "name=\xff\xfe"},
+		},
+		UidMap: map[string]string{},
+	}
+
+	m := &JSONMarshaler{Parsed: true}
+	b, err := m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		`{"schema_version":2,"sequence":1,"timestamp":"10000001","messages":[`+
+			`{"type":1300,"data":"syscall=59 uid=0 exe=\"/bin/ls\"","fields":{"exe":"/bin/ls","syscall":"59","uid":"0"}},`+
+			`{"type":1100,"data":"pid=1 msg='op=login acct=\"bob\" res=success'","fields":{"acct":"bob","msg":"op=login acct=\"bob\" res=success","op":"login","pid":"1","res":"success"}},`+
+			`{"type":1302,"data":"bmFtZT3//g==","encoding":"base64"}],"uid_map":{}}`+"\n",
+		string(b),
+	)
+	assert.Nil(t, msg.Msgs[0].Fields, "The original message should not be changed")
+
+	// compat wins
+	m.Compat = true
+	b, err = m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "fields")
+
+	// configured through formats.json.parsed
+	c := viper.New()
+	c.Set("formats.json.parsed", true)
+	jm, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.True(t, jm.(*JSONMarshaler).Parsed)
+}
+
+func TestJSONMarshaler_RFC3339(t *testing.T) {
+	defer func() { outputLocation = time.UTC }()
+
+	msg := &AuditMessageGroup{Seq: 1, AuditTime: "1500000000.123", TimestampMs: 1500000000123, UidMap: map[string]string{}}
+	m := &JSONMarshaler{RFC3339: true}
+	b, err := m.Marshal(msg)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"timestamp":"2017-07-14T02:40:00.123Z","timestamp_ms":1500000000123`)
+	assert.Equal(t, "1500000000.123", msg.AuditTime, "The original message should not be changed")
+
+	outputLocation = time.FixedZone("", -5*60*60)
+	b, _ = m.Marshal(msg)
+	assert.Contains(t, string(b), `"timestamp":"2017-07-13T21:40:00.123-05:00"`)
+
+	// Timestamps that didn't parse are left alone
+	b, _ = m.Marshal(&AuditMessageGroup{AuditTime: "nope", UidMap: map[string]string{}})
+	assert.Contains(t, string(b), `"timestamp":"nope"`)
+
+	// compat wins
+	m.Compat = true
+	b, _ = m.Marshal(msg)
+	assert.Contains(t, string(b), `"timestamp":"1500000000.123"`)
+
+	// configured through formats.json.timestamp
+	c := viper.New()
+	c.Set("formats.json.timestamp", "rfc3339")
+	jm, err := createMarshaler(c)
+	assert.Nil(t, err)
+	assert.True(t, jm.(*JSONMarshaler).RFC3339)
+
+	c.Set("formats.json.timestamp", "unix")
+	_, err = createMarshaler(c)
+	assert.EqualError(t, err, "Failed to create the `json` output format. Error: formats.json.timestamp could not be parsed; Value: `unix`")
+}
+
+func Test_encodeInvalidUTF8(t *testing.T) {
+	msgs := []*AuditMessage{{Data: "a"}, {Data: "b"}}
+	out, ok := encodeInvalidUTF8(msgs)
+	assert.False(t, ok)
+	assert.Equal(t, msgs, out)
+
+	msgs = []*AuditMessage{{Data: "\xff"}, {Data: "b"}}
+	out, ok = encodeInvalidUTF8(msgs)
+	assert.True(t, ok)
+	assert.Equal(t, []*AuditMessage{{Data: "/w==", Encoding: "base64"}, msgs[1]}, out)
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Reads `health` from the config, returns how long /readyz tolerates no audit records, 0 disables that check
+func createHealth(config *viper.Viper) (time.Duration, error) {
+	maxEventAge := config.GetDuration("health.max_event_age")
+	if maxEventAge < 0 {
+		return 0, fmt.Errorf("health.max_event_age must be 0 or greater, %v provided", maxEventAge)
+	}
+
+	return maxEventAge, nil
+}
+
+// What /healthz and /readyz report
+type healthStatus struct {
+	Ready        bool            `json:"ready"`
+	Registered   bool            `json:"registered"`             // Whether the kernel sends audit records to go-audit, multicast listeners always are
+	Outputs      map[string]bool `json:"outputs"`                // Whether each output believes it can accept writes
+	LastEventAge *float64        `json:"last_event_age_seconds"` // Since the last netlink message, null before the first one
+	Problems     []string        `json:"problems,omitempty"`     // Why it is not ready
+}
+
+// /healthz answers 200 as long as the receive loop does, /readyz only when nothing is wrong with the pipeline
+// Both return the same json, a receive loop that is stuck gets a 503 from either
+func (s *metricsServer) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var h healthStatus
+	if !s.onLoop(func(a *AuditMarshaller) { h = a.health(time.Now(), s.started, s.maxEventAge) }) {
+		http.Error(w, "The receive loop did not answer in time", http.StatusServiceUnavailable)
+		return
+	}
+
+	code := http.StatusOK
+	if r.URL.Path == "/readyz" && !h.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(h)
+}
+
+// Checks the netlink registration, every output and, with maxEventAge, how long ago a record arrived
+// Before the first record the age is counted from started, a host that never sends anything is not ready either
+func (a *AuditMarshaller) health(now, started time.Time, maxEventAge time.Duration) healthStatus {
+	h := healthStatus{Registered: a.registered, Outputs: map[string]bool{}}
+	if !a.registered {
+		h.Problems = append(h.Problems, "go-audit is not the registered audit daemon")
+	}
+
+	if writers := outputWriters(a.writer); writers != nil {
+		for _, w := range writers {
+			h.Outputs[w.name] = w.Healthy()
+		}
+	} else if a.writer != nil {
+		h.Outputs["output"] = a.writer.Healthy()
+	}
+
+	var names []string
+	for name, ok := range h.Outputs {
+		if !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		h.Problems = append(h.Problems, fmt.Sprintf("Output %s is not connected", name))
+	}
+
+	since := started
+	if !a.lastReceived.IsZero() {
+		since = a.lastReceived
+		age := now.Sub(a.lastReceived).Seconds()
+		h.LastEventAge = &age
+	}
+
+	if maxEventAge > 0 && now.Sub(since) > maxEventAge {
+		h.Problems = append(h.Problems, fmt.Sprintf("No audit records received for %v", now.Sub(since).Round(time.Second)))
+	}
+
+	h.Ready = len(h.Problems) == 0
+	return h
+}
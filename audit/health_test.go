@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createHealth(t *testing.T) {
+	c := viper.New()
+	maxEventAge, err := createHealth(c)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), maxEventAge)
+
+	c.Set("health.max_event_age", "-1s")
+	_, err = createHealth(c)
+	assert.EqualError(t, err, "health.max_event_age must be 0 or greater, -1s provided")
+
+	c.Set("health.max_event_age", "10m")
+	maxEventAge, err = createHealth(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Minute, maxEventAge)
+}
+
+func TestAuditMarshaller_health(t *testing.T) {
+	file, tcp := NewAuditWriter(&bytes.Buffer{}, 1), NewAuditWriter(&FailWriter{}, 1)
+	file.name, tcp.name = "file", "tcp"
+	m := NewAuditMarshaller(&multiWriter{names: []string{"file", "tcp"}, writers: []*OutputWriter{file, tcp}}, 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+	m.registered = true
+
+	started := time.Unix(1000, 0)
+	h := m.health(started.Add(time.Minute), started, 0)
+	assert.Equal(t, healthStatus{Ready: true, Registered: true, Outputs: map[string]bool{"file": true, "tcp": true}}, h)
+
+	// Nothing arrived since start up
+	h = m.health(started.Add(time.Minute), started, 30*time.Second)
+	assert.False(t, h.Ready)
+	assert.Nil(t, h.LastEventAge)
+	assert.Equal(t, []string{"No audit records received for 1m0s"}, h.Problems)
+
+	m.lastReceived = started.Add(45 * time.Second)
+	h = m.health(started.Add(time.Minute), started, 30*time.Second)
+	assert.True(t, h.Ready)
+	if assert.NotNil(t, h.LastEventAge) {
+		assert.Equal(t, 15.0, *h.LastEventAge)
+	}
+
+	m.registered = false
+	tcp.w.Write([]byte("{}"))
+	h = m.health(started.Add(time.Minute), started, 0)
+	assert.False(t, h.Ready)
+	assert.Equal(t, map[string]bool{"file": true, "tcp": false}, h.Outputs)
+	assert.Equal(t, []string{"go-audit is not the registered audit daemon", "Output tcp is not connected"}, h.Problems)
+
+	// Writers that are not OutputWriters are reported as a whole
+	m = NewAuditMarshaller(&recordingWriter{closed: true}, 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+	assert.Equal(t, map[string]bool{"output": false}, m.health(started, started, 0).Outputs)
+}
+
+func Test_metricsServer_health(t *testing.T) {
+	_, _ = hookLogger()
+	defer resetLogger()
+
+	s, err := listenMetrics("127.0.0.1:0", 0)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	w := &recordingWriter{}
+	m := NewAuditMarshaller(w, 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+	m.registered = true
+
+	// Stands in for the receive loop
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case fn := <-s.requests:
+				fn(m)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	get := func(path string) (int, healthStatus) {
+		res, err := http.Get("http://" + s.ln.Addr().String() + path)
+		if !assert.Nil(t, err) {
+			return 0, healthStatus{}
+		}
+		defer res.Body.Close()
+
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		var h healthStatus
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&h))
+		return res.StatusCode, h
+	}
+
+	code, h := get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, h.Ready)
+
+	assert.True(t, s.onLoop(func(*AuditMarshaller) { w.closed = true }))
+	code, h = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"Output output is not connected"}, h.Problems)
+
+	code, h = get("/healthz")
+	assert.Equal(t, http.StatusOK, code, "Liveness only needs the receive loop")
+	assert.False(t, h.Ready)
+}
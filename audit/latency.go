@@ -0,0 +1,29 @@
+package audit
+
+import "time"
+
+// EventLatency breaks down where time was spent on an event before it was handed to the output, in milliseconds
+type EventLatency struct {
+	Receive  float64 `json:"receive"`  // From the audit timestamp until the first record was received
+	Assemble float64 `json:"assemble"` // From the first record until the group was complete, EOE or COMPLETE_AFTER
+	Process  float64 `json:"process"`  // Filters, enrichers, alerts and subscribers
+}
+
+func newEventLatency(msg *AuditMessageGroup, completed, processed time.Time) *EventLatency {
+	l := &EventLatency{
+		Assemble: millis(completed.Sub(msg.received)),
+		Process:  millis(processed.Sub(completed)),
+	}
+
+	// The kernel clock and ours are the same but the audit timestamp only has millisecond precision
+	if t, ok := parseAuditTime(msg.AuditTime); ok {
+		l.Receive = millis(msg.received.Sub(t))
+	}
+
+	return l
+}
+
+// Converts a duration to milliseconds, keeping microsecond precision
+func millis(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000
+}
@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newEventLatency(t *testing.T) {
+	received := time.Unix(10000001, 125500000)
+	msg := &AuditMessageGroup{AuditTime: "10000001.123", received: received}
+
+	l := newEventLatency(msg, received.Add(time.Millisecond*40), received.Add(time.Millisecond*41+time.Microsecond*250))
+	assert.Equal(t, &EventLatency{Receive: 2.5, Assemble: 40, Process: 1.25}, l)
+
+	// No usable timestamp leaves receive at 0
+	msg.AuditTime = ""
+	assert.Equal(t, 0.0, newEventLatency(msg, received, received).Receive)
+}
+
+func Test_millis(t *testing.T) {
+	assert.Equal(t, 0.0, millis(0))
+	assert.Equal(t, 1.0, millis(time.Millisecond))
+	assert.Equal(t, 0.001, millis(time.Microsecond+time.Nanosecond*999))
+	assert.Equal(t, 2500.0, millis(time.Millisecond*2500))
+}
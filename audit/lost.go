@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Polls the kernel audit status and hands the lost counter to the receive loop until the context is done
+// The kernel counts every event it dropped, because of the backlog or rate limit, since boot
+func watchKernelLost(ctx context.Context, interval time.Duration, lost chan<- uint32) {
+	n, err := dialNetlink()
+	if err != nil {
+		el.Printf("Failed to watch the kernel lost counter. Error: %s\n", err)
+		return
+	}
+	defer n.Close()
+
+	if err := n.SetReceiveTimeout(rulesTimeout); err != nil {
+		el.Printf("Failed to watch the kernel lost counter. Error: %s\n", err)
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if s, err := getAuditStatus(n); err != nil {
+			el.Printf("Failed to get the audit status. Error: %s\n", err)
+		} else {
+			select {
+			case lost <- s.Lost:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Reports any growth of the kernel lost counter, the first value seen is only the baseline
+func (a *AuditMarshaller) kernelLost(ctx context.Context, lost uint32) {
+	if !a.lostSeen || lost < a.lastLost {
+		if !a.lostSeen && lost > 0 {
+			el.Printf("The kernel has lost %d audit events since boot\n", lost)
+		}
+
+		a.lostSeen = true
+		a.lastLost = lost
+		return
+	}
+
+	if lost == a.lastLost {
+		return
+	}
+
+	count := lost - a.lastLost
+	a.lastLost = lost
+	a.stats.KernelLost += uint64(count)
+
+	el.Printf("The kernel lost %d audit events, %d since boot\n", count, lost)
+	a.emit(ctx, newInternalEvent(EVENT_KERNEL, fmt.Sprintf("op=events_lost reason=kernel lost=%d total=%d res=0", count, lost)))
+}
+
+// Reports sequences that never showed up, see detectMissing
+func (a *AuditMarshaller) reportMissed(ctx context.Context, missed []int) {
+	sort.Ints(missed)
+	a.stats.Missed += uint64(len(missed))
+
+	a.emit(ctx, newInternalEvent(EVENT_KERNEL, fmt.Sprintf(
+		"op=events_lost reason=sequence lost=%d first=%d last=%d res=0", len(missed), missed[0], missed[len(missed)-1],
+	)))
+}
+
+// Counts a receive buffer overflow, the records that were dropped show up as missed sequences
+func (a *AuditMarshaller) overrun() {
+	a.stats.Overruns++
+	el.Printf("The netlink receive buffer overflowed and audit records were dropped, total overruns: %d. Consider raising socket_buffer.receive\n", a.stats.Overruns)
+}
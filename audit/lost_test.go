@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMarshaller_kernelLost(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), true, false, 0, []AuditFilter{}, nil)
+
+	// The first value is the baseline
+	m.kernelLost(context.Background(), 5)
+	assert.Equal(t, "The kernel has lost 5 audit events since boot\n", elb.String())
+	assert.Equal(t, 0, w.Len())
+
+	m.kernelLost(context.Background(), 5)
+	assert.Equal(t, 0, w.Len())
+
+	elb.Reset()
+	m.kernelLost(context.Background(), 8)
+	assert.Equal(t, uint64(3), m.stats.KernelLost)
+	assert.Equal(t, "The kernel lost 3 audit events, 8 since boot\n", elb.String())
+	assert.Contains(t, w.String(), `"messages":[{"type":2000,"data":"op=events_lost reason=kernel lost=3 total=8 res=0"}]`)
+	assert.Contains(t, w.String(), `"source":"go-audit"`)
+
+	// A lower counter is a new baseline
+	w.Reset()
+	m.kernelLost(context.Background(), 1)
+	m.kernelLost(context.Background(), 2)
+	assert.Equal(t, uint64(4), m.stats.KernelLost)
+	assert.Contains(t, w.String(), "op=events_lost reason=kernel lost=1 total=2 res=0")
+}
+
+func TestAuditMarshaller_reportMissed(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), true, false, 3, []AuditFilter{}, nil)
+
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): hi there"))
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:5): hi there"))
+	assert.Equal(t, 0, w.Len())
+
+	// 2 to 4 are now too far behind
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:9): hi there"))
+	assert.Equal(t, uint64(3), m.stats.Missed)
+	assert.Contains(t, w.String(), `{"type":2000,"data":"op=events_lost reason=sequence lost=3 first=2 last=4 res=0"}`)
+	assert.Contains(t, elb.String(), "Likely missed sequence 2, current 9")
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"strings"
+)
+
+// APPARMOR_* record types, see linux/audit.h
+var apparmorResults = map[uint16]string{
+	1501: "audit",
+	1502: "allowed",
+	1503: "denied",
+	1504: "hint",
+	1505: "status",
+	1506: "error",
+}
+
+// MACDecision is the outcome of an SELinux or AppArmor check, from an AVC or APPARMOR record
+type MACDecision struct {
+	System      string   `json:"system"`                // selinux or apparmor
+	Result      string   `json:"result"`                // Like denied, granted or allowed, always lower case
+	Operation   string   `json:"operation,omitempty"`   // AppArmor only, selinux records have the syscall instead
+	Permissions []string `json:"permissions,omitempty"` // What was asked for, { read write } or requested_mask
+	Denied      string   `json:"denied,omitempty"`      // AppArmor denied_mask
+	Name        string   `json:"name,omitempty"`
+	Profile     string   `json:"profile,omitempty"`  // AppArmor
+	Scontext    string   `json:"scontext,omitempty"` // SELinux
+	Tcontext    string   `json:"tcontext,omitempty"`
+	Tclass      string   `json:"tclass,omitempty"`
+	Permissive  bool     `json:"permissive,omitempty"` // SELinux logged the denial but allowed it
+}
+
+// Parses an AVC (1400) or APPARMOR (1501-1506) record, nil if it is neither
+func parseMACDecision(am *AuditMessage) *MACDecision {
+	fields := parseFields(am.Data)
+
+	// AppArmor uses AVC records on most kernels and APPARMOR records on some
+	if result, ok := apparmorResults[am.Type]; ok || fields["apparmor"] != "" {
+		if fields["apparmor"] != "" {
+			result = strings.ToLower(fields["apparmor"])
+		}
+
+		d := &MACDecision{
+			System:    "apparmor",
+			Result:    result,
+			Operation: fields["operation"],
+			Denied:    fields["denied_mask"],
+			Name:      fields["name"],
+			Profile:   fields["profile"],
+		}
+
+		if requested := fields["requested_mask"]; requested != "" {
+			d.Permissions = []string{requested}
+		}
+
+		return d
+	}
+
+	if am.Type != 1400 {
+		return nil
+	}
+
+	// avc:  denied  { read write } for  pid=1 comm="httpd" ... scontext=... tcontext=... tclass=file permissive=0
+	data := strings.TrimPrefix(strings.TrimSpace(am.Data), "avc:")
+	words := strings.Fields(data)
+	if len(words) == 0 {
+		return nil
+	}
+
+	d := &MACDecision{
+		System:     "selinux",
+		Result:     strings.ToLower(words[0]),
+		Name:       fields["name"],
+		Scontext:   fields["scontext"],
+		Tcontext:   fields["tcontext"],
+		Tclass:     fields["tclass"],
+		Permissive: fields["permissive"] == "1",
+	}
+
+	if open := strings.IndexByte(data, '{'); open >= 0 {
+		if end := strings.IndexByte(data[open:], '}'); end >= 0 {
+			d.Permissions = strings.Fields(data[open+1 : open+end])
+		}
+	}
+
+	return d
+}
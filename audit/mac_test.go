@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseMACDecision(t *testing.T) {
+	assert.Equal(t, &MACDecision{
+		System:      "selinux",
+		Result:      "denied",
+		Permissions: []string{"read", "write"},
+		Name:        "shadow",
+		Scontext:    "system_u:system_r:httpd_t:s0",
+		Tcontext:    "system_u:object_r:shadow_t:s0",
+		Tclass:      "file",
+	}, parseMACDecision(&AuditMessage{
+		Type: 1400,
+		Data: `avc:  denied  { read write } for  pid=1234 comm="httpd" name="shadow" dev="dm-0" ino=1 scontext=system_u:system_r:httpd_t:s0 tcontext=system_u:object_r:shadow_t:s0 tclass=file permissive=0`,
+	}))
+
+	assert.Equal(t, &MACDecision{
+		System:      "selinux",
+		Result:      "granted",
+		Permissions: []string{"setenforce"},
+		Scontext:    "unconfined_u:unconfined_r:unconfined_t:s0",
+		Tcontext:    "system_u:object_r:security_t:s0",
+		Tclass:      "security",
+		Permissive:  true,
+	}, parseMACDecision(&AuditMessage{
+		Type: 1400,
+		Data: `avc:  granted  { setenforce } for  pid=1 comm="setenforce" scontext=unconfined_u:unconfined_r:unconfined_t:s0 tcontext=system_u:object_r:security_t:s0 tclass=security permissive=1`,
+	}))
+
+	// AppArmor in an AVC record
+	assert.Equal(t, &MACDecision{
+		System:      "apparmor",
+		Result:      "denied",
+		Operation:   "open",
+		Permissions: []string{"r"},
+		Denied:      "r",
+		Name:        "/etc/shadow",
+		Profile:     "/usr/sbin/cupsd",
+	}, parseMACDecision(&AuditMessage{
+		Type: 1400,
+		Data: `apparmor="DENIED" operation="open" profile="/usr/sbin/cupsd" name="/etc/shadow" pid=1 comm="cupsd" requested_mask="r" denied_mask="r" fsuid=0 ouid=0`,
+	}))
+
+	// and in an APPARMOR record, the type gives the result
+	assert.Equal(t, &MACDecision{
+		System:    "apparmor",
+		Result:    "status",
+		Operation: "profile_load",
+		Name:      "docker-default",
+	}, parseMACDecision(&AuditMessage{Type: 1505, Data: `operation="profile_load" name="docker-default" pid=1 comm="apparmor_parser"`}))
+
+	assert.Nil(t, parseMACDecision(&AuditMessage{Type: 1400, Data: ""}))
+	assert.Nil(t, parseMACDecision(&AuditMessage{Type: 1300, Data: "syscall=2"}))
+}
+
+func TestAuditMessageGroup_AddMessage_mac(t *testing.T) {
+	uidMap = map[string]string{"0": "root"}
+
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1400, Data: `apparmor="DENIED" operation="open" profile="a" ouid=0`})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1400, Data: `apparmor="ALLOWED" operation="open" profile="b"`})
+	assert.Equal(t, "a", amg.MAC.Profile, "The first record should win")
+	assert.Equal(t, map[string]string{"0": "root"}, amg.UidMap)
+}
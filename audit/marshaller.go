@@ -0,0 +1,400 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	EVENT_EOE    = 1320 // End of multi packet event
+	EVENT_KERNEL = 2000 // Asynchronous kernel record, go-audit reports lost events as one
+)
+
+type AuditMarshaller struct {
+	msgs          map[int]*AuditMessageGroup
+	writer        AuditWriter
+	lastSeq       int
+	lastLost      uint32 // Last kernel lost counter, see kernelLost
+	lostSeen      bool
+	missed        map[int]bool
+	worstLag      int
+	eventMin      uint16
+	eventMax      uint16
+	trackMessages bool
+	logOutOfOrder bool
+	maxOutOfOrder int
+	attempts      int
+	filters       map[string]map[uint16][]*regexp.Regexp // { syscall: { mtype: [regexp, ...] } }
+	fieldFilters  []AuditFilter                          // Enabled filters with parsed field conditions, see AuditFilter.matches
+	limitFilters  []AuditFilter                          // Enabled filters that sample or rate limit, see filterLimit
+	filterList    []AuditFilter                          // As configured, filters is built from the enabled ones
+	keepOnly      bool                                   // Drop the groups no filter matches instead of the ones that match, see filter_mode
+	enrichers     []NamedEnricher
+	subscribers   []func(*AuditMessageGroup)
+	alerter       *Alerter
+	latency       bool
+	slowOutput    time.Duration
+	completeAfter time.Duration // How long a group waits for more records, COMPLETE_AFTER if 0
+	checkpoint    *checkpoint
+	dedup         *deduper  // Optional, see `dedup` in the example config
+	registered    bool      // Whether the kernel sends records to this socket, see health
+	lastReceived  time.Time // When the last netlink message arrived, any type
+	stats         marshallerStats
+}
+
+// Counters for the control socket, see control.go
+type marshallerStats struct {
+	Received     uint64 `json:"received"`     // Netlink messages in the event range, not counting EOE
+	Completed    uint64 `json:"completed"`    // Message groups that went through the pipeline
+	Filtered     uint64 `json:"filtered"`     // Message groups dropped by filters
+	ParseErrors  uint64 `json:"parse_errors"` // Messages whose header could not be parsed
+	Missed       uint64 `json:"missed"`       // Sequences presumed dropped, see detectMissing
+	KernelLost   uint64 `json:"kernel_lost"`  // Events the kernel reported lost since go-audit started
+	Takeovers    uint64 `json:"takeovers"`    // Times another process registered as the audit daemon
+	Overruns     uint64 `json:"overruns"`     // Times the netlink receive buffer overflowed and the kernel dropped records
+	Deduplicated uint64 `json:"deduplicated"` // Message groups folded into an identical one, see deduper
+
+	// Message groups completed by their EOE record and by waiting out completeAfter, filtered ones included
+	FlushedEOE     uint64 `json:"flushed_eoe"`
+	FlushedTimeout uint64 `json:"flushed_timeout"`
+}
+
+// Create a new marshaller
+func NewAuditMarshaller(w AuditWriter, eventMin uint16, eventMax uint16, trackMessages, logOOO bool, maxOOO int, filters []AuditFilter, enrichers []NamedEnricher) *AuditMarshaller {
+	am := AuditMarshaller{
+		writer:        w,
+		msgs:          make(map[int]*AuditMessageGroup, 5), // It is not typical to have more than 2 message groups at any given time
+		missed:        make(map[int]bool, 10),
+		eventMin:      eventMin,
+		eventMax:      eventMax,
+		trackMessages: trackMessages,
+		logOutOfOrder: logOOO,
+		maxOutOfOrder: maxOOO,
+		filterList:    filters,
+		enrichers:     enrichers,
+	}
+
+	am.buildFilters()
+	return &am
+}
+
+// (Re)builds the filter lookup from the enabled filters in filterList
+func (a *AuditMarshaller) buildFilters() {
+	a.filters = make(map[string]map[uint16][]*regexp.Regexp)
+	a.fieldFilters = nil
+	a.limitFilters = nil
+
+	for _, filter := range a.filterList {
+		if filter.disabled {
+			continue
+		}
+
+		if filter.limit != nil {
+			a.limitFilters = append(a.limitFilters, filter)
+			continue
+		}
+
+		if filter.hasFieldConditions() {
+			a.fieldFilters = append(a.fieldFilters, filter)
+			continue
+		}
+
+		if _, ok := a.filters[filter.syscall]; !ok {
+			a.filters[filter.syscall] = make(map[uint16][]*regexp.Regexp)
+		}
+
+		if _, ok := a.filters[filter.syscall][filter.messageType]; !ok {
+			a.filters[filter.syscall][filter.messageType] = []*regexp.Regexp{}
+		}
+
+		a.filters[filter.syscall][filter.messageType] = append(a.filters[filter.syscall][filter.messageType], filter.regex)
+	}
+}
+
+// Ingests a netlink message and likely prepares it to be logged
+// The context is handed down to user lookups, enrichers and the writer
+func (a *AuditMarshaller) Consume(ctx context.Context, nlMsg *syscall.NetlinkMessage) {
+	aMsg := NewAuditMessage(nlMsg)
+	a.lastReceived = time.Now()
+
+	if aMsg.Seq == 0 {
+		// Don't lose malformed records we would otherwise have processed
+		if aMsg.parseErr != nil && nlMsg.Header.Type >= a.eventMin && nlMsg.Header.Type <= a.eventMax {
+			a.emitParseError(ctx, aMsg)
+		}
+
+		// We got an invalid audit message, return the current message and reset
+		a.flushOld(ctx)
+		return
+	}
+
+	if a.trackMessages {
+		if missed := a.detectMissing(aMsg.Seq); len(missed) > 0 {
+			a.reportMissed(ctx, missed)
+		}
+	}
+
+	if nlMsg.Header.Type == EVENT_EOE {
+		// This is end of event msg, flush the msg with that sequence and discard this one
+		// It counts even when 1320 is outside the event range, the records before it may not be
+		if _, ok := a.msgs[aMsg.Seq]; ok {
+			a.stats.FlushedEOE++
+		}
+		a.completeMessage(ctx, aMsg.Seq)
+		a.flushOld(ctx)
+		return
+	} else if nlMsg.Header.Type < a.eventMin || nlMsg.Header.Type > a.eventMax {
+		// Drop all audit messages that aren't things we care about
+		a.flushOld(ctx)
+		return
+	}
+
+	a.stats.Received++
+	if val, ok := a.msgs[aMsg.Seq]; ok {
+		// Use the original AuditMessageGroup if we have one
+		val.AddMessage(ctx, aMsg)
+	} else {
+		// Create a new AuditMessageGroup
+		msg := NewAuditMessageGroup(ctx, aMsg)
+		if a.completeAfter > 0 {
+			msg.CompleteAfter = msg.received.Add(a.completeAfter)
+		}
+		a.msgs[aMsg.Seq] = msg
+	}
+
+	a.flushOld(ctx)
+}
+
+// Outputs any messages that are old enough, in sequence order
+// This is because not every event ends with an EOE, like user space events or events whose EOE was lost
+func (a *AuditMarshaller) flushOld(ctx context.Context) {
+	now := time.Now()
+	var old []int
+	for seq, msg := range a.msgs {
+		if msg.CompleteAfter.Before(now) || now.Equal(msg.CompleteAfter) {
+			old = append(old, seq)
+		}
+	}
+
+	sort.Ints(old)
+	for _, seq := range old {
+		a.stats.FlushedTimeout++
+		a.completeMessage(ctx, seq)
+	}
+
+	a.flushDedup(ctx, false)
+}
+
+// Completes every pending group in sequence order without waiting out completeAfter, then whatever dedup holds
+// Used on shutdown, returns how many groups were pending
+func (a *AuditMarshaller) flushAll(ctx context.Context) int {
+	seqs := make([]int, 0, len(a.msgs))
+	for seq := range a.msgs {
+		seqs = append(seqs, seq)
+	}
+
+	sort.Ints(seqs)
+	for _, seq := range seqs {
+		a.completeMessage(ctx, seq)
+	}
+
+	a.flushDedup(ctx, true)
+	return len(seqs)
+}
+
+// Write a complete message group to the configured output in json format
+func (a *AuditMarshaller) completeMessage(ctx context.Context, seq int) {
+	var msg *AuditMessageGroup
+	var ok bool
+
+	if msg, ok = a.msgs[seq]; !ok {
+		//TODO: attempted to complete a missing message, log?
+		return
+	}
+
+	completed := time.Now()
+
+	// Records can arrive interleaved with other sequences, put them in record type order. Records of the same
+	// type keep the order they arrived in, like PATH items and EXECVE fragments
+	sort.SliceStable(msg.Msgs, func(i, j int) bool { return msg.Msgs[i].Type < msg.Msgs[j].Type })
+	msg.Argv = msg.reassembleExecve()
+
+	// Filtered groups count as processed too
+	if a.checkpoint != nil {
+		a.checkpoint.observe(seq, completed)
+	}
+
+	// Changes to the audit subsystem itself are never filtered
+	msg.Tamper = detectTamper(msg)
+
+	if msg.Tamper == nil && a.dropMessage(msg) && (a.alerter == nil || !a.alerter.Forced(msg)) {
+		a.stats.Filtered++
+		delete(a.msgs, seq)
+		return
+	}
+
+	a.stats.Completed++
+
+	a.enrich(ctx, msg)
+
+	if a.alerter != nil {
+		a.alerter.Process(msg)
+	}
+
+	// Canaries only go to alert sinks, unless the audit subsystem was tampered with
+	canary := msg.Alert != nil && msg.Alert.canary && msg.Tamper == nil
+
+	// Subscribers include /events and the osquery table, canaries stay out of them like they stay out of outputs
+	if !canary {
+		for _, fn := range a.subscribers {
+			fn(msg)
+		}
+	}
+
+	if a.latency {
+		msg.Latency = newEventLatency(msg, completed, time.Now())
+	}
+
+	switch {
+	case canary:
+		// Only the alert sinks get it
+	case msg.Alert != nil || msg.Tamper != nil || a.dedup == nil || !a.dedup.hold(msg, time.Now()):
+		// Alerts and tampering are never held back or folded into another event
+		a.write(ctx, msg)
+	}
+
+	delete(a.msgs, seq)
+}
+
+// Passes a message whose header could not be parsed along as is, filters, enrichers and alerts are skipped
+func (a *AuditMarshaller) emitParseError(ctx context.Context, am *AuditMessage) {
+	a.stats.ParseErrors++
+	msg := &AuditMessageGroup{
+		AuditTime:        am.AuditTime,
+		Msgs:             []*AuditMessage{am},
+		UidMap:           map[string]string{},
+		ParseError:       true,
+		ParseErrorReason: am.parseErr.Error(),
+	}
+
+	a.emit(ctx, msg)
+}
+
+// Hands a message group to subscribers and the writer without going through filters, enrichers or alerts
+func (a *AuditMarshaller) emit(ctx context.Context, msg *AuditMessageGroup) {
+	for _, fn := range a.subscribers {
+		fn(msg)
+	}
+
+	a.write(ctx, msg)
+}
+
+// Writes a message group to the output, if there is one
+func (a *AuditMarshaller) write(ctx context.Context, msg *AuditMessageGroup) {
+	if a.writer == nil {
+		return
+	}
+
+	start := time.Now()
+	if err := a.writer.Write(ctx, msg); err != nil {
+		el.Println("Failed to write message. Error:", err)
+		os.Exit(1)
+	}
+
+	// The event is already gone by now so slow outputs are logged instead
+	if took := time.Since(start); a.latency && a.slowOutput > 0 && took > a.slowOutput {
+		el.Printf("Writing sequence %d took %s\n", msg.Seq, took)
+	}
+}
+
+// Runs all enabled enrichers against the message group
+func (a *AuditMarshaller) enrich(ctx context.Context, msg *AuditMessageGroup) {
+	for _, e := range a.enrichers {
+		if err := e.Fn(ctx, msg); err != nil {
+			el.Printf("Enricher `%s` failed on sequence %d. Error: %s\n", e.Name, msg.Seq, err)
+		}
+	}
+}
+
+// Reports if the group should be dropped, matching groups are in drop mode and the others are in keep mode
+// Groups no other filter decided on are up to the first sampling or rate limiting filter that matches them
+func (a *AuditMarshaller) dropMessage(msg *AuditMessageGroup) bool {
+	if a.matchesFilter(msg) {
+		return !a.keepOnly
+	}
+
+	for _, f := range a.limitFilters {
+		if f.matches(msg) {
+			return !f.limit.allow(msg, time.Now())
+		}
+	}
+
+	return a.keepOnly
+}
+
+// Reports if any enabled filter matches the group
+func (a *AuditMarshaller) matchesFilter(msg *AuditMessageGroup) bool {
+	for _, f := range a.fieldFilters {
+		if f.matches(msg) {
+			return true
+		}
+	}
+
+	filters, ok := a.filters[msg.Syscall]
+	if !ok {
+		return false
+	}
+
+	for _, msg := range msg.Msgs {
+		if fg, ok := filters[msg.Type]; ok {
+			for _, filter := range fg {
+				if filter.MatchString(msg.Data) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// Track sequence numbers and log if we suspect we missed a message
+// Returns the sequences that are now presumed dropped
+func (a *AuditMarshaller) detectMissing(seq int) []int {
+	var dropped []int
+	if seq > a.lastSeq+1 && a.lastSeq != 0 {
+		// We likely leap frogged over a msg, wait until the next sequence to make sure
+		for i := a.lastSeq + 1; i < seq; i++ {
+			a.missed[i] = true
+		}
+	}
+
+	for missedSeq := range a.missed {
+		if missedSeq == seq {
+			lag := a.lastSeq - missedSeq
+			if lag > a.worstLag {
+				a.worstLag = lag
+			}
+
+			if a.logOutOfOrder {
+				el.Println("Got sequence", missedSeq, "after", lag, "messages. Worst lag so far", a.worstLag, "messages")
+			}
+			delete(a.missed, missedSeq)
+		} else if seq-missedSeq > a.maxOutOfOrder {
+			el.Printf("Likely missed sequence %d, current %d, worst message delay %d\n", missedSeq, seq, a.worstLag)
+			delete(a.missed, missedSeq)
+			dropped = append(dropped, missedSeq)
+		}
+	}
+
+	if seq > a.lastSeq {
+		// Keep track of the largest sequence
+		a.lastSeq = seq
+	}
+
+	return dropped
+}
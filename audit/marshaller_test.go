@@ -0,0 +1,263 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshallerConstants(t *testing.T) {
+	assert.Equal(t, 1320, EVENT_EOE)
+}
+
+func TestAuditMarshaller_Consume(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1100), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+
+	// Flush group on 1320
+	m.Consume(context.Background(), &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1300),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:1): hi there"),
+	})
+
+	m.Consume(context.Background(), &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1301),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:1): hi there"),
+	})
+
+	m.Consume(context.Background(), new1320("1"))
+
+	assert.Equal(
+		t,
+		"{\"schema_version\":2,\"sequence\":1,\"timestamp\":\"10000001\",\"timestamp_ms\":10000001000,\"messages\":[{\"type\":1300,\"data\":\"hi there\"},{\"type\":1301,\"data\":\"hi there\"}],\"uid_map\":{}}\n",
+		w.String(),
+	)
+	assert.Equal(t, 0, len(m.msgs))
+
+	// Ignore below 1100
+	w.Reset()
+	m.Consume(context.Background(), &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1099),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:2): hi there"),
+	})
+
+	assert.Equal(t, 0, len(m.msgs))
+
+	// Ignore above 1399
+	w.Reset()
+	m.Consume(context.Background(), &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1400),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:3): hi there"),
+	})
+
+	assert.Equal(t, 0, len(m.msgs))
+
+	// Ignore sequences of 0
+	w.Reset()
+	m.Consume(context.Background(), &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1400),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:0): hi there"),
+	})
+
+	assert.Equal(t, 0, len(m.msgs))
+
+	// Should flush old msgs after 2 seconds
+	w.Reset()
+	m.Consume(context.Background(), &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1300),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:4): hi there"),
+	})
+
+	start := time.Now()
+	for len(m.msgs) != 0 {
+		m.Consume(context.Background(), new1320("0"))
+	}
+
+	assert.Equal(t, "{\"schema_version\":2,\"sequence\":4,\"timestamp\":\"10000001\",\"timestamp_ms\":10000001000,\"messages\":[{\"type\":1300,\"data\":\"hi there\"}],\"uid_map\":{}}\n", w.String())
+	expected := start.Add(time.Second * 2)
+	assert.True(t, expected.Equal(time.Now()) || expected.Before(time.Now()), "Should have taken at least 2 seconds to flush")
+	assert.Equal(t, 0, len(m.msgs))
+}
+
+func TestAuditMarshaller_completeMessage(t *testing.T) {
+	//TODO: cant test because completeMessage calls exit
+	t.Skip()
+	return
+	// lb, elb := hookLogger()
+	// m := NewAuditMarshaller(NewAuditWriter(&FailWriter{}, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+
+	// m.Consume(&syscall.NetlinkMessage{
+	// 	Header: syscall.NlMsghdr{
+	// 		Len:   uint32(44),
+	// 		Type:  uint16(1300),
+	// 		Flags: uint16(0),
+	// 		Seq:   uint32(0),
+	// 		Pid:   uint32(0),
+	// 	},
+	// 	Data: []byte("audit(10000001:4): hi there"),
+	// })
+
+	// m.completeMessage(4)
+	// assert.Equal(t, "!", lb.String())
+	// assert.Equal(t, "!", elb.String())
+}
+
+func new1320(seq string) *syscall.NetlinkMessage {
+	return &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1320),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:" + seq + "): "),
+	}
+}
+
+// Helper to build a netlink message of the given type
+func newNlMsg(mtype uint16, data string) *syscall.NetlinkMessage {
+	return &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  mtype,
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte(data),
+	}
+}
+
+type FailWriter struct{}
+
+func (f *FailWriter) Write(p []byte) (n int, err error) {
+	return 0, errors.New("derp")
+}
+
+func TestAuditMarshaller_parseError(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+
+	// malformed records in range are written as is
+	m.Consume(context.Background(), newNlMsg(1300, "garbage"))
+	assert.Equal(
+		t,
+		"{\"schema_version\":2,\"sequence\":0,\"timestamp\":\"\",\"messages\":[{\"type\":1300,\"data\":\"garbage\"}],\"uid_map\":{},\"parse_error\":true,\"parse_error_reason\":\"Header is too short\"}\n",
+		w.String(),
+	)
+
+	// out of range records, like netlink acks, are still ignored
+	w.Reset()
+	m.Consume(context.Background(), newNlMsg(2, "garbage"))
+	assert.Empty(t, w.String())
+}
+
+func TestAuditMarshaller_latency(t *testing.T) {
+	var got []*AuditMessageGroup
+	m := NewAuditMarshaller(nil, uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+	m.subscribers = []func(*AuditMessageGroup){func(msg *AuditMessageGroup) { got = append(got, msg) }}
+
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): hi"))
+	m.Consume(context.Background(), newNlMsg(1320, "audit(10000001:1): "))
+	assert.Len(t, got, 1)
+	assert.Nil(t, got[0].Latency, "Latency should only be added when enabled")
+
+	m.latency = true
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:2): hi"))
+	m.Consume(context.Background(), newNlMsg(1320, "audit(10000001:2): "))
+	assert.Len(t, got, 2)
+	if assert.NotNil(t, got[1].Latency) {
+		assert.True(t, got[1].Latency.Receive > 0, "The event is from 1970, it should have taken a while")
+		assert.True(t, got[1].Latency.Assemble >= 0)
+		assert.True(t, got[1].Latency.Process >= 0)
+	}
+}
+
+func TestAuditMarshaller_execve(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): syscall=59"))
+	m.Consume(context.Background(), newNlMsg(1309, `audit(10000001:1): argc=2 a0="echo" a1_len=10 a1[0]=68656C`))
+	m.Consume(context.Background(), newNlMsg(1309, `audit(10000001:1): a1[1]=6C6F`))
+	m.Consume(context.Background(), new1320("1"))
+
+	assert.Contains(t, w.String(), `"argv":["echo","hello"]`)
+}
+
+func TestAuditMarshaller_reassembly(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1310), false, false, 0, []AuditFilter{}, nil)
+
+	// Records of two sequences arrive interleaved and out of type order
+	m.Consume(context.Background(), newNlMsg(1307, "audit(10000001:2): cwd=\"/\""))
+	m.Consume(context.Background(), newNlMsg(1302, "audit(10000001:1): item=0"))
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:2): syscall=59"))
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): syscall=2"))
+	m.Consume(context.Background(), newNlMsg(1302, "audit(10000001:1): item=1"))
+
+	// The EOE completes a sequence even though 1320 is outside the event range
+	m.Consume(context.Background(), new1320("1"))
+	assert.Contains(t, w.String(), `"sequence":1,`)
+	assert.Contains(t, w.String(), `"messages":[{"type":1300,"data":"syscall=2"},{"type":1302,"data":"item=0"},{"type":1302,"data":"item=1"}]`)
+	assert.Len(t, m.msgs, 1)
+
+	// Timed out groups are completed in sequence order
+	w.Reset()
+	m.Consume(context.Background(), newNlMsg(1305, "audit(10000001:4): op=set"))
+	m.Consume(context.Background(), newNlMsg(1305, "audit(10000001:3): op=set"))
+	for _, msg := range m.msgs {
+		msg.CompleteAfter = time.Now()
+	}
+
+	m.flushOld(context.Background())
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"sequence":2,`)
+	assert.Contains(t, lines[0], `"messages":[{"type":1300,"data":"syscall=59"},{"type":1307,"data":"cwd=\"/\""}]`)
+	assert.Contains(t, lines[1], `"sequence":3,`)
+	assert.Contains(t, lines[2], `"sequence":4,`)
+}
@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Reads the metrics settings, an empty address means it is disabled
+func createMetrics(config *viper.Viper) (string, error) {
+	if !config.GetBool("metrics.enabled") {
+		return "", nil
+	}
+
+	address := config.GetString("metrics.address")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", fmt.Errorf("metrics.address could not be parsed. Error: %s", err)
+	}
+
+	return address, nil
+}
+
+// metricsServer serves GET /metrics in the prometheus text format and the /healthz and /readyz checks, see `metrics`
+// and `health` in the example config
+// Everything is read on the receive loop like control socket commands, a request waits for it
+type metricsServer struct {
+	srv         *http.Server
+	ln          net.Listener
+	requests    chan func(*AuditMarshaller)
+	maxEventAge time.Duration // See readiness
+	started     time.Time
+}
+
+func listenMetrics(address string, maxEventAge time.Duration) (*metricsServer, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen for metrics. Error: %s", err)
+	}
+
+	s := &metricsServer{
+		ln:          ln,
+		requests:    make(chan func(*AuditMarshaller)),
+		maxEventAge: maxEventAge,
+		started:     time.Now(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/healthz", s.serveHealth)
+	mux.HandleFunc("/readyz", s.serveHealth)
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: controlTimeout}
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			el.Printf("Metrics endpoint stopped. Error: %s\n", err)
+		}
+	}()
+
+	l.Printf("Serving metrics on http://%s/metrics\n", ln.Addr())
+	return s, nil
+}
+
+func (s *metricsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var families []metricFamily
+	if !s.onLoop(func(a *AuditMarshaller) { families = a.metrics() }) {
+		http.Error(w, "The receive loop did not answer in time", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(prometheusText(families))
+}
+
+// Runs fn on the receive loop, false if the loop did not get to it in time
+// fn may still run after that, it must only touch what the caller no longer reads
+func (s *metricsServer) onLoop(fn func(*AuditMarshaller)) bool {
+	done := make(chan struct{})
+	timeout := time.After(controlTimeout)
+
+	select {
+	case s.requests <- func(a *AuditMarshaller) { fn(a); close(done) }:
+	case <-timeout:
+		return false
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+func (s *metricsServer) Close() error {
+	return s.srv.Close()
+}
+
+// metricFamily is one metric and its samples, for prometheus by prometheusText and for statsd by StatsdClient
+type metricFamily struct {
+	name    string // Without any prefix, like records_received_total
+	kind    string // counter or gauge
+	help    string
+	samples []metricSample
+}
+
+// metricSample is one value of a metric with at most one label, like output="file"
+type metricSample struct {
+	label string // Empty without a label
+	value string // Of the label
+	v     float64
+}
+
+type metricSet []metricFamily
+
+func (m *metricSet) metric(name, kind, help string, samples ...metricSample) {
+	*m = append(*m, metricFamily{name: name, kind: kind, help: help, samples: samples})
+}
+
+func (m *metricSet) counter(name, help string, v uint64) {
+	m.metric(name, "counter", help, metricSample{v: float64(v)})
+}
+
+func (m *metricSet) gauge(name, help string, v float64) {
+	m.metric(name, "gauge", help, metricSample{v: v})
+}
+
+// Renders metrics in the prometheus text format, every name is prefixed with go_audit_
+func prometheusText(families []metricFamily) []byte {
+	b := &bytes.Buffer{}
+	for _, f := range families {
+		fmt.Fprintf(b, "# HELP go_audit_%s %s\n# TYPE go_audit_%s %s\n", f.name, f.help, f.name, f.kind)
+		for _, s := range f.samples {
+			b.WriteString("go_audit_" + f.name)
+			if s.label != "" {
+				b.WriteString("{" + metricLabel(s.label, s.value) + "}")
+			}
+			b.WriteString(" " + strconv.FormatFloat(s.v, 'g', -1, 64) + "\n")
+		}
+	}
+
+	return b.Bytes()
+}
+
+// A label value with quotes, backslashes and newlines escaped
+func metricLabel(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+// Reads every metric, must be called on the receive loop
+func (a *AuditMarshaller) metrics() []metricFamily {
+	m := metricSet{}
+	s := a.stats
+
+	m.counter("records_received_total", "Audit records received in the event range, not counting EOE", s.Received)
+	m.counter("events_completed_total", "Events that were parsed and went through the pipeline", s.Completed)
+	m.counter("events_filtered_total", "Events dropped by filters", s.Filtered)
+	m.counter("events_deduplicated_total", "Events folded into an identical one", s.Deduplicated)
+	m.counter("parse_errors_total", "Records whose header could not be parsed", s.ParseErrors)
+	m.counter("events_missed_total", "Sequences presumed dropped", s.Missed)
+	m.counter("kernel_lost_total", "Events the kernel reported lost since go-audit started", s.KernelLost)
+	m.counter("takeovers_total", "Times another process registered as the audit daemon", s.Takeovers)
+	m.counter("overruns_total", "Times the netlink receive buffer overflowed", s.Overruns)
+	m.metric("groups_flushed_total", "counter", "Events completed by their EOE record or by waiting out events.complete_after",
+		metricSample{"reason", "eoe", float64(s.FlushedEOE)},
+		metricSample{"reason", "timeout", float64(s.FlushedTimeout)},
+	)
+	m.gauge("pending_groups", "Events still waiting for more records", float64(len(a.msgs)))
+
+	m.gauge("uid_cache_size", "Uids and gids with a cached name", float64(len(uidMap)+len(gidMap)))
+	m.metric("uid_lookups_total", "counter", "Uid and gid name lookups by whether the cache had the answer",
+		metricSample{"result", "hit", float64(atomic.LoadUint64(&idCacheHits))},
+		metricSample{"result", "miss", float64(atomic.LoadUint64(&idCacheMisses))},
+	)
+
+	writers := outputWriters(a.writer)
+	var written, failed, healthy []metricSample
+	for _, w := range writers {
+		written = append(written, metricSample{"output", w.name, float64(atomic.LoadUint64(&w.written))})
+		failed = append(failed, metricSample{"output", w.name, float64(atomic.LoadUint64(&w.failed))})
+
+		up := 0.0
+		if w.Healthy() {
+			up = 1
+		}
+		healthy = append(healthy, metricSample{"output", w.name, up})
+	}
+
+	if len(writers) > 0 {
+		m.metric("output_written_total", "counter", "Events written to each output", written...)
+		m.metric("output_errors_total", "counter", "Failed write attempts of each output", failed...)
+		m.metric("output_healthy", "gauge", "1 if the output believes it can accept writes", healthy...)
+	}
+
+	return m
+}
+
+// The named outputs behind w, nil if it is not made of OutputWriters
+func outputWriters(w AuditWriter) []*OutputWriter {
+	switch t := w.(type) {
+	case *OutputWriter:
+		return []*OutputWriter{t}
+	case *multiWriter:
+		return t.writers
+	}
+
+	return nil
+}
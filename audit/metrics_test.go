@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createMetrics(t *testing.T) {
+	c := viper.New()
+	c.Set("metrics.address", "nope")
+	address, err := createMetrics(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "", address, "Disabled metrics should not be served")
+
+	c.Set("metrics.enabled", true)
+	_, err = createMetrics(c)
+	assert.EqualError(t, err, "metrics.address could not be parsed. Error: address nope: missing port in address")
+
+	c.Set("metrics.address", "127.0.0.1:9851")
+	address, err = createMetrics(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:9851", address)
+}
+
+func Test_metricLabel(t *testing.T) {
+	assert.Equal(t, `output="file"`, metricLabel("output", "file"))
+	assert.Equal(t, `output="a\"b\\c\nd"`, metricLabel("output", "a\"b\\c\nd"))
+}
+
+func TestAuditMarshaller_metrics(t *testing.T) {
+	w := NewAuditWriter(&bytes.Buffer{}, 1)
+	w.name = "file"
+	ctx := context.Background()
+	m := NewAuditMarshaller(w, 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+
+	m.Consume(ctx, newNlMsg(1300, "audit(10000001:1): hi"))
+	m.Consume(ctx, newNlMsg(1320, "audit(10000001:1): "))
+	m.Consume(ctx, newNlMsg(1300, "audit(10000001:2): hi"))
+	m.stats.KernelLost = 4
+
+	out := string(prometheusText(m.metrics()))
+	assert.Contains(t, out, "# HELP go_audit_records_received_total ")
+	assert.Contains(t, out, "# TYPE go_audit_records_received_total counter\ngo_audit_records_received_total 2\n")
+	assert.Contains(t, out, "go_audit_events_completed_total 1\n")
+	assert.Contains(t, out, "go_audit_kernel_lost_total 4\n")
+	assert.Contains(t, out, "go_audit_groups_flushed_total{reason=\"eoe\"} 1\ngo_audit_groups_flushed_total{reason=\"timeout\"} 0\n")
+	assert.Contains(t, out, "# TYPE go_audit_pending_groups gauge\ngo_audit_pending_groups 1\n")
+	assert.Contains(t, out, "go_audit_output_written_total{output=\"file\"} 1\n")
+	assert.Contains(t, out, "go_audit_output_errors_total{output=\"file\"} 0\n")
+	assert.Contains(t, out, "go_audit_output_healthy{output=\"file\"} 1\n")
+
+	// Outputs that are not OutputWriters have nothing to report
+	m = NewAuditMarshaller(&recordingWriter{}, 1300, 1399, false, false, 0, []AuditFilter{}, nil)
+	assert.NotContains(t, string(prometheusText(m.metrics())), "go_audit_output_")
+}
+
+func TestClient_metrics(t *testing.T) {
+	_, _ = hookLogger()
+	defer resetLogger()
+
+	f := &fakeReceiver{msgs: make(chan *syscall.NetlinkMessage)}
+	c := NewClient(ClientOptions{Writer: &recordingWriter{}, MetricsAddress: "127.0.0.1:0"})
+	c.nl = f
+
+	up := make(chan net.Addr, 1)
+	c.metricsUp = func(addr net.Addr) { up <- addr }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	var address string
+	select {
+	case addr := <-up:
+		address = addr.String()
+	case err := <-done:
+		t.Fatalf("Run returned early. Error: %s", err)
+	}
+
+	f.msgs <- newNlMsg(1300, "audit(10000001:1): hi")
+
+	res, err := http.Get("http://" + address + "/metrics")
+	if assert.Nil(t, err) {
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/plain; version=0.0.4", res.Header.Get("Content-Type"))
+		assert.Contains(t, string(b), "go_audit_records_received_total 1\n")
+	}
+
+	res, err = http.Post("http://"+address+"/metrics", "text/plain", nil)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
@@ -0,0 +1,308 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Just enough of msgpack to speak the fluentd forward protocol, see output_fluentd.go
+// https://github.com/msgpack/msgpack/blob/master/spec.md
+// Values are read into plain go types: nil, bool, int64, uint64, float64, string, []byte, []interface{},
+// map[string]interface{} (other key types are formatted as strings) and msgpackExt
+
+// Nothing go-audit reads comes close to this, it stops a bad length from allocating everything
+const msgpackMaxLength = 64 * 1024 * 1024
+
+// An extension type, like the fluentd EventTime
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// Appends v, maps are written with their keys sorted so the same value always encodes the same way
+func msgpackAppend(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return msgpackAppendInt(b, int64(v)), nil
+	case int64:
+		return msgpackAppendInt(b, v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), v), nil
+		}
+		return msgpackAppendInt(b, int64(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return msgpackAppendInt(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return msgpackAppend(b, f)
+	case string:
+		return append(msgpackAppendLength(b, len(v), 0xa0, 32, 0xd9), v...), nil
+	case []byte:
+		return append(msgpackAppendLength(b, len(v), 0, 0, 0xc4), v...), nil
+	case []interface{}:
+		b = msgpackAppendCollection(b, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			var err error
+			if b, err = msgpackAppend(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = msgpackAppendCollection(b, len(v), 0x80, 0xde)
+		for _, k := range sortedKeys(v) {
+			b, _ = msgpackAppend(b, k)
+
+			var err error
+			if b, err = msgpackAppend(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case msgpackExt:
+		switch len(v.Data) {
+		case 1:
+			b = append(b, 0xd4)
+		case 2:
+			b = append(b, 0xd5)
+		case 4:
+			b = append(b, 0xd6)
+		case 8:
+			b = append(b, 0xd7)
+		case 16:
+			b = append(b, 0xd8)
+		default:
+			b = msgpackAppendLength(b, len(v.Data), 0, 0, 0xc7)
+		}
+		return append(append(b, byte(v.Type)), v.Data...), nil
+	}
+
+	return nil, fmt.Errorf("Can not encode %T as msgpack", v)
+}
+
+func msgpackAppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 127:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+// Writes the type and length of a str, bin or ext. fix is the fixed size type for lengths under fixMax, if any,
+// the 8, 16 and 32 bit length types follow base
+func msgpackAppendLength(b []byte, n int, fix byte, fixMax int, base byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, base, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, base+1), uint16(n))
+	}
+
+	return binary.BigEndian.AppendUint32(append(b, base+2), uint32(n))
+}
+
+// Writes the type and length of an array or map, base is the 16 bit type and the 32 bit one follows it
+func msgpackAppendCollection(b []byte, n int, fix byte, base byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, base), uint16(n))
+	}
+
+	return binary.BigEndian.AppendUint32(append(b, base+1), uint32(n))
+}
+
+// Reads one value
+func msgpackRead(r *bufio.Reader) (interface{}, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xf0 == 0x80:
+		return msgpackReadMap(r, int(t&0x0f))
+	case t&0xf0 == 0x90:
+		return msgpackReadArray(r, int(t&0x0f))
+	case t&0xe0 == 0xa0:
+		b, err := msgpackReadBytes(r, int(t&0x1f))
+		return string(b), err
+	}
+
+	switch t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := msgpackReadUint(r, 1<<(t-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackReadBytes(r, int(n))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := msgpackReadUint(r, 1<<(t-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackReadExt(r, int(n))
+	case 0xca:
+		n, err := msgpackReadUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := msgpackReadUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce:
+		n, err := msgpackReadUint(r, 1<<(t-0xcc))
+		return int64(n), err
+	case 0xcf:
+		return msgpackReadUint(r, 8)
+	case 0xd0:
+		n, err := msgpackReadUint(r, 1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := msgpackReadUint(r, 2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := msgpackReadUint(r, 4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := msgpackReadUint(r, 8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return msgpackReadExt(r, 1<<(t-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := msgpackReadUint(r, 1<<(t-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		b, err := msgpackReadBytes(r, int(n))
+		return string(b), err
+	case 0xdc, 0xdd:
+		n, err := msgpackReadUint(r, 2<<(t-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackReadArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := msgpackReadUint(r, 2<<(t-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackReadMap(r, int(n))
+	}
+
+	return nil, fmt.Errorf("Unknown msgpack type 0x%x", t)
+}
+
+// Reads a big endian unsigned int of size bytes
+func msgpackReadUint(r *bufio.Reader, size int) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[8-size:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func msgpackReadBytes(r *bufio.Reader, n int) ([]byte, error) {
+	if n > msgpackMaxLength {
+		return nil, errors.New("msgpack value is too long")
+	}
+
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func msgpackReadExt(r *bufio.Reader, n int) (interface{}, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := msgpackReadBytes(r, n)
+	return msgpackExt{Type: int8(t), Data: b}, err
+}
+
+func msgpackReadArray(r *bufio.Reader, n int) (interface{}, error) {
+	if n > msgpackMaxLength {
+		return nil, errors.New("msgpack array is too long")
+	}
+
+	// Grown as values are read, a bad length fails on the data running out instead of allocating it all up front
+	a := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := msgpackRead(r)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+
+	return a, nil
+}
+
+func msgpackReadMap(r *bufio.Reader, n int) (interface{}, error) {
+	if n > msgpackMaxLength {
+		return nil, errors.New("msgpack map is too long")
+	}
+
+	m := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := msgpackRead(r)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := msgpackRead(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if s, ok := k.(string); ok {
+			m[s] = v
+		} else {
+			m[fmt.Sprint(k)] = v
+		}
+	}
+
+	return m, nil
+}
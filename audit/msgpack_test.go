@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_msgpackRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(128), int64(-1), int64(-32), int64(-33), int64(-129), int64(40000), int64(-40000),
+		int64(1 << 40), int64(math.MinInt64), uint64(math.MaxUint64),
+		1.5,
+		"", "abc", strings.Repeat("x", 31), strings.Repeat("x", 32), strings.Repeat("x", 300), strings.Repeat("x", 70000),
+		[]byte{}, []byte{1, 2, 3},
+		[]interface{}{}, []interface{}{int64(1), "two"}, make([]interface{}, 20),
+		map[string]interface{}{}, map[string]interface{}{"a": int64(1), "b": []interface{}{"c"}},
+		msgpackExt{Type: 0, Data: []byte{0, 0, 0, 1, 0, 0, 0, 2}}, msgpackExt{Type: 5, Data: []byte{1, 2, 3}},
+	}
+
+	for _, v := range values {
+		b, err := msgpackAppend(nil, v)
+		assert.Nil(t, err)
+
+		r := bufio.NewReader(bytes.NewReader(b))
+		got, err := msgpackRead(r)
+		assert.Nil(t, err)
+		assert.Equal(t, v, got)
+
+		_, err = r.ReadByte()
+		assert.NotNil(t, err, "Trailing bytes after %v", v)
+	}
+}
+
+func Test_msgpackAppend(t *testing.T) {
+	// The encodings the spec gives
+	b, _ := msgpackAppend(nil, map[string]interface{}{"compact": true, "schema": int64(0)})
+	assert.Equal(t, []byte("\x82\xa7compact\xc3\xa6schema\x00"), b)
+
+	b, _ = msgpackAppend(nil, json.Number("300"))
+	assert.Equal(t, []byte{0xd1, 0x01, 0x2c}, b)
+
+	b, _ = msgpackAppend(nil, json.Number("1.5"))
+	assert.Equal(t, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, b)
+
+	_, err := msgpackAppend(nil, struct{}{})
+	assert.EqualError(t, err, "Can not encode struct {} as msgpack")
+}
+
+func Test_msgpackRead(t *testing.T) {
+	// float32 and a map with an int key
+	v, err := msgpackRead(bufio.NewReader(bytes.NewReader([]byte{0xca, 0x3f, 0xc0, 0, 0})))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, v)
+
+	v, err = msgpackRead(bufio.NewReader(bytes.NewReader([]byte{0x81, 0x01, 0xa1, 'a'})))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"1": "a"}, v)
+
+	_, err = msgpackRead(bufio.NewReader(bytes.NewReader([]byte{0xc1})))
+	assert.EqualError(t, err, "Unknown msgpack type 0xc1")
+
+	// A length longer than the data
+	_, err = msgpackRead(bufio.NewReader(bytes.NewReader([]byte{0xdd, 0xff, 0xff, 0xff, 0x00})))
+	assert.EqualError(t, err, "msgpack array is too long")
+
+	_, err = msgpackRead(bufio.NewReader(bytes.NewReader([]byte{0xdc, 0xff, 0xff})))
+	assert.EqualError(t, err, "EOF")
+}
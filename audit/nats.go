@@ -0,0 +1,476 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Just enough of the nats client protocol to publish events, see output_nats.go
+// https://docs.nats.io/reference/reference-protocols/nats-protocol
+
+// Nothing go-audit publishes comes close to this, it stops a bad MSG length from allocating everything
+const natsMaxPayload = 64 * 1024 * 1024
+
+// nkey prefixes, the seed prefix is combined with the type of key it is a seed for
+const (
+	natsPrefixSeed = 18 << 3
+	natsPrefixUser = 20 << 3
+)
+
+var natsKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// natsAuth is how go-audit proves who it is, only one way should be set
+type natsAuth struct {
+	user     string
+	password string
+	token    string
+	seed     string // nkey seed, signs the server nonce
+	jwt      string // From a credentials file, along with its seed
+}
+
+// The parts of the server INFO that matter here
+type natsInfo struct {
+	ServerId    string `json:"server_id"`
+	MaxPayload  int    `json:"max_payload"`
+	TLSRequired bool   `json:"tls_required"`
+	Nonce       string `json:"nonce"`
+}
+
+// natsConn is a connection to a nats server
+// A reader goroutine answers server PINGs and hands out replies, a protocol error or a broken connection is sticky
+type natsConn struct {
+	conn    net.Conn
+	info    natsInfo
+	inbox   string        // Prefix of reply subjects, replies are subscribed to with sid 1
+	timeout time.Duration // For writes, a server that stops reading should not block go-audit forever
+
+	mu  sync.Mutex // Guards w and seq
+	w   *bufio.Writer
+	seq int
+
+	pongs   chan struct{}
+	replies chan natsMsg
+
+	errMu sync.Mutex
+	err   error
+	done  chan struct{}
+}
+
+// A message delivered to the reply subscription
+type natsMsg struct {
+	subject string
+	data    []byte
+}
+
+// Connects and authenticates, tlsConfig is used if it is set or the server requires tls
+func dialNats(address string, tlsConfig *tls.Config, auth *natsAuth, timeout time.Duration) (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &natsConn{
+		conn:    conn,
+		timeout: timeout,
+		pongs:   make(chan struct{}, 16),
+		replies: make(chan natsMsg, 16),
+		done:    make(chan struct{}),
+	}
+
+	r, err := c.handshake(address, tlsConfig, auth, timeout)
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop(r)
+	return c, nil
+}
+
+// Reads the server INFO, upgrades to tls if needed and sends CONNECT, returns the reader for the rest of the connection
+func (c *natsConn) handshake(address string, tlsConfig *tls.Config, auth *natsAuth, timeout time.Duration) (*bufio.Reader, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	r := bufio.NewReader(c.conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "INFO ") {
+		return nil, fmt.Errorf("Expected INFO from the nats server, got `%s`", strings.TrimSpace(line))
+	}
+
+	if err := json.Unmarshal([]byte(line[5:]), &c.info); err != nil {
+		return nil, fmt.Errorf("Failed to parse the nats server INFO. Error: %s", err)
+	}
+
+	if tlsConfig == nil && c.info.TLSRequired {
+		tlsConfig = &tls.Config{}
+	}
+
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+
+		tc := tls.Client(c.conn, tlsConfig)
+		if err := tc.Handshake(); err != nil {
+			return nil, fmt.Errorf("Failed the tls handshake. Error: %s", err)
+		}
+
+		c.conn = tc
+		r = bufio.NewReader(c.conn)
+	}
+
+	connect, err := c.connectOptions(tlsConfig != nil, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	c.w = bufio.NewWriter(c.conn)
+	fmt.Fprintf(c.w, "CONNECT %s\r\nPING\r\n", connect)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// Bad credentials are answered with an -ERR instead of the PONG
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return r, nil
+		case strings.HasPrefix(line, "-ERR"):
+			return nil, fmt.Errorf("The nats server refused the connection. Error: %s", strings.Trim(line[4:], " '"))
+		}
+	}
+}
+
+// Builds the CONNECT options, signing the server nonce for nkey and credentials auth
+func (c *natsConn) connectOptions(secure bool, auth *natsAuth) ([]byte, error) {
+	opts := map[string]interface{}{
+		"verbose":      false,
+		"pedantic":     false,
+		"tls_required": secure,
+		"name":         "go-audit",
+		"lang":         "go",
+		"protocol":     1,
+	}
+
+	switch {
+	case auth.seed != "":
+		priv, pub, err := natsDecodeSeed(auth.seed)
+		if err != nil {
+			return nil, err
+		}
+
+		opts["sig"] = base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, []byte(c.info.Nonce)))
+		if auth.jwt != "" {
+			opts["jwt"] = auth.jwt
+		} else {
+			opts["nkey"] = natsEncodeKey(natsPrefixUser, pub)
+		}
+	case auth.token != "":
+		opts["auth_token"] = auth.token
+	case auth.user != "":
+		opts["user"] = auth.user
+		opts["pass"] = auth.password
+	}
+
+	return json.Marshal(opts)
+}
+
+// Reads server messages until the connection breaks
+func (c *natsConn) readLoop(r *bufio.Reader) {
+	defer close(c.done)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			c.mu.Lock()
+			c.w.WriteString("PONG\r\n")
+			err = c.w.Flush()
+			c.mu.Unlock()
+		case line == "PONG":
+			select {
+			case c.pongs <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(line, "MSG "):
+			err = c.readMsg(r, strings.Fields(line))
+		case strings.HasPrefix(line, "-ERR"):
+			// The server closes the connection after most errors, the reason is the useful part
+			err = fmt.Errorf("The nats server returned an error. Error: %s", strings.Trim(line[4:], " '"))
+		}
+
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// Reads the payload of `MSG <subject> <sid> [reply-to] <#bytes>`
+func (c *natsConn) readMsg(r *bufio.Reader, fields []string) error {
+	if len(fields) < 4 || len(fields) > 5 {
+		return fmt.Errorf("Failed to parse a nats MSG; Value: `%s`", strings.Join(fields, " "))
+	}
+
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 || size > natsMaxPayload {
+		return fmt.Errorf("Failed to parse a nats MSG; Value: `%s`", strings.Join(fields, " "))
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	select {
+	case c.replies <- natsMsg{subject: fields[1], data: data[:size]}:
+	default:
+		// Nobody is waiting for it, a late reply to a request that timed out
+	}
+
+	return nil
+}
+
+// Records the first error, every call after that returns it
+func (c *natsConn) fail(err error) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+}
+
+func (c *natsConn) error() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// Sends a PUB, reply is optional. Nothing else flushes the connection so publishes are not left buffered
+func (c *natsConn) publish(subject, reply string, data []byte) error {
+	if err := c.error(); err != nil {
+		return err
+	}
+
+	if c.info.MaxPayload > 0 && len(data) > c.info.MaxPayload {
+		return fmt.Errorf("The event is bigger than the max_payload of the nats server, %d bytes", len(data))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if reply != "" {
+		fmt.Fprintf(c.w, "PUB %s %s %d\r\n", subject, reply, len(data))
+	} else {
+		fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(data))
+	}
+	c.w.Write(data)
+	c.w.WriteString("\r\n")
+	err := c.w.Flush()
+
+	if err != nil {
+		c.fail(err)
+	}
+
+	return err
+}
+
+// Waits for the server to have processed everything published so far
+func (c *natsConn) flush(timeout time.Duration) error {
+	// A PONG for an earlier flush that timed out does not count
+	for len(c.pongs) > 0 {
+		<-c.pongs
+	}
+
+	c.mu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	c.w.WriteString("PING\r\n")
+	err := c.w.Flush()
+	c.mu.Unlock()
+
+	if err != nil {
+		c.fail(err)
+		return err
+	}
+
+	select {
+	case <-c.pongs:
+		return nil
+	case <-c.done:
+		return c.error()
+	case <-time.After(timeout):
+		return errors.New("The nats server did not answer a PING in time")
+	}
+}
+
+// Publishes with a reply subject and waits for the reply, only one request can be in flight at a time
+func (c *natsConn) request(subject string, data []byte, timeout time.Duration) ([]byte, error) {
+	if c.inbox == "" {
+		if err := c.subscribeInbox(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	c.seq++
+	reply := c.inbox + "." + strconv.Itoa(c.seq)
+	c.mu.Unlock()
+
+	// A reply that came in after an earlier request timed out is not for this one
+	for len(c.replies) > 0 {
+		<-c.replies
+	}
+
+	if err := c.publish(subject, reply, data); err != nil {
+		return nil, err
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case m := <-c.replies:
+			if m.subject == reply {
+				return m.data, nil
+			}
+		case <-c.done:
+			return nil, c.error()
+		case <-deadline:
+			return nil, fmt.Errorf("No reply on %s within %s", subject, timeout)
+		}
+	}
+}
+
+// Subscribes to a random reply prefix
+func (c *natsConn) subscribeInbox() error {
+	id := make([]byte, 11)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inbox = "_INBOX." + hex.EncodeToString(id)
+	fmt.Fprintf(c.w, "SUB %s.* 1\r\n", c.inbox)
+	return nil
+}
+
+func (c *natsConn) close() error {
+	c.mu.Lock()
+	err := c.w.Flush()
+	c.mu.Unlock()
+
+	c.fail(errors.New("The nats connection is closed"))
+	<-c.done
+	return err
+}
+
+// Decodes an nkey seed like SUAM..., returning the private and public keys
+func natsDecodeSeed(seed string) (ed25519.PrivateKey, ed25519.PublicKey, error) {
+	raw, err := natsKeyEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil || len(raw) != 2+ed25519.SeedSize+2 {
+		return nil, nil, errors.New("The nkey seed could not be parsed")
+	}
+
+	body, sum := raw[:len(raw)-2], binary.LittleEndian.Uint16(raw[len(raw)-2:])
+	if crc16(body) != sum {
+		return nil, nil, errors.New("The nkey seed has a bad checksum")
+	}
+
+	if body[0]&0xf8 != natsPrefixSeed {
+		return nil, nil, errors.New("The nkey seed is not a seed")
+	}
+
+	if typ := (body[0]&7)<<5 | (body[1]&0xf8)>>3; typ != natsPrefixUser {
+		return nil, nil, errors.New("The nkey seed is not for a user")
+	}
+
+	priv := ed25519.NewKeyFromSeed(body[2:])
+	return priv, priv.Public().(ed25519.PublicKey), nil
+}
+
+// Encodes a public key with its prefix and checksum
+func natsEncodeKey(prefix byte, key []byte) string {
+	raw := append([]byte{prefix}, key...)
+	sum := make([]byte, 2)
+	binary.LittleEndian.PutUint16(sum, crc16(raw))
+	return natsKeyEncoding.EncodeToString(append(raw, sum...))
+}
+
+// Reads the jwt and nkey seed out of a nats credentials file
+func parseNatsCredentials(b []byte) (jwt string, seed string, err error) {
+	var section string
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		text := strings.TrimSpace(string(line))
+		switch {
+		case strings.HasPrefix(text, "-----BEGIN"):
+			section = text
+		case strings.HasPrefix(text, "------END") || strings.HasPrefix(text, "-----END"):
+			section = ""
+		case text == "" || section == "":
+		case strings.Contains(section, "JWT") && jwt == "":
+			jwt = text
+		case strings.Contains(section, "SEED") && seed == "":
+			seed = text
+		}
+	}
+
+	if jwt == "" || seed == "" {
+		return "", "", errors.New("The nats credentials file needs a user jwt and nkey seed")
+	}
+
+	return jwt, seed, nil
+}
+
+// CRC-16/XMODEM, as nkeys use it
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Encodes a user nkey seed the way nsc would
+func testNatsSeed(seed []byte) string {
+	raw := append([]byte{natsPrefixSeed | natsPrefixUser>>5, (natsPrefixUser & 31) << 3}, seed...)
+	return natsEncodeKey(raw[0], raw[1:])
+}
+
+func Test_natsDecodeSeed(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 1
+
+	priv, pub, err := natsDecodeSeed(testNatsSeed(seed))
+	assert.Nil(t, err)
+	assert.Equal(t, ed25519.NewKeyFromSeed(seed), priv)
+	assert.Equal(t, ed25519.NewKeyFromSeed(seed).Public(), pub)
+	assert.Equal(t, "SU", testNatsSeed(seed)[:2])
+	assert.Equal(t, "U", natsEncodeKey(natsPrefixUser, pub)[:1])
+
+	_, _, err = natsDecodeSeed("not a seed")
+	assert.EqualError(t, err, "The nkey seed could not be parsed")
+
+	// Flipping a character breaks the checksum
+	s := []byte(testNatsSeed(seed))
+	s[10] = 'A' + (s[10]-'A'+1)%26
+	_, _, err = natsDecodeSeed(string(s))
+	assert.EqualError(t, err, "The nkey seed has a bad checksum")
+
+	// A public key is not a seed
+	_, _, err = natsDecodeSeed(natsEncodeKey(natsPrefixUser, append([]byte{0}, pub...)))
+	assert.EqualError(t, err, "The nkey seed is not a seed")
+
+	// An account seed is not for a user
+	raw := append([]byte{natsPrefixSeed, 0}, seed...)
+	_, _, err = natsDecodeSeed(natsEncodeKey(raw[0], raw[1:]))
+	assert.EqualError(t, err, "The nkey seed is not for a user")
+}
+
+func Test_parseNatsCredentials(t *testing.T) {
+	creds := `-----BEGIN NATS USER JWT-----
+eyJ0eXAiOiJKV1QiLCJhbGciOiJlZDI1NTE5LW5rZXkifQ.payload.sig
+------END NATS USER JWT------
+
+************************* IMPORTANT *************************
+NKEY Seed printed below can be used to sign and prove identity.
+
+-----BEGIN USER NKEY SEED-----
+SUAEXAMPLE
+------END USER NKEY SEED------
+
+*************************************************************
+`
+
+	jwt, seed, err := parseNatsCredentials([]byte(creds))
+	assert.Nil(t, err)
+	assert.Equal(t, "eyJ0eXAiOiJKV1QiLCJhbGciOiJlZDI1NTE5LW5rZXkifQ.payload.sig", jwt)
+	assert.Equal(t, "SUAEXAMPLE", seed)
+
+	_, _, err = parseNatsCredentials([]byte("-----BEGIN NATS USER JWT-----\nabc\n------END NATS USER JWT------\n"))
+	assert.EqualError(t, err, "The nats credentials file needs a user jwt and nkey seed")
+}
+
+func Test_crc16(t *testing.T) {
+	// The XMODEM check value
+	assert.Equal(t, uint16(0x31c3), crc16([]byte("123456789")))
+}
@@ -0,0 +1,367 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// osquery table constraint operators, see osquery/core/tables.h
+const (
+	osqueryGreaterThan        = 4
+	osqueryLessThanOrEquals   = 8
+	osqueryLessThan           = 16
+	osqueryGreaterThanOrEqual = 32
+)
+
+// osquery table names are lower case
+var osqueryTableName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// osqueryExtension registers with osquery as an extension and serves recent events as a table
+// See `osquery` in the example config and https://osquery.readthedocs.io/en/stable/development/osquery-sdk/
+type osqueryExtension struct {
+	socket   string
+	table    string
+	interval time.Duration
+	events   *recentEvents
+}
+
+// Creates the osquery extension, returns nil if it is not enabled
+func createOsqueryExtension(config *viper.Viper) (*osqueryExtension, error) {
+	if !config.GetBool("osquery.enabled") {
+		return nil, nil
+	}
+
+	socket := config.GetString("osquery.socket")
+	if socket == "" {
+		return nil, errors.New("osquery.socket must be set")
+	}
+
+	table := config.GetString("osquery.table")
+	if !osqueryTableName.MatchString(table) {
+		return nil, fmt.Errorf("osquery.table could not be parsed; Value: `%s`", table)
+	}
+
+	maxEvents := config.GetInt("osquery.max_events")
+	if maxEvents < 1 {
+		return nil, fmt.Errorf("osquery.max_events must be greater than 0, %v provided", maxEvents)
+	}
+
+	interval := config.GetDuration("osquery.interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("osquery.interval must be greater than 0, %v provided", interval)
+	}
+
+	return &osqueryExtension{
+		socket:   socket,
+		table:    table,
+		interval: interval,
+		events:   newRecentEvents(maxEvents, config.GetInt("osquery.max_bytes")),
+	}, nil
+}
+
+// The table columns, the flattened event plus the event time in seconds
+func osqueryColumns() []map[string]string {
+	columns := []map[string]string{{"id": "column", "name": "time", "type": "BIGINT", "op": "0"}}
+	for i, name := range flatColumns {
+		typ := "TEXT"
+		if i < 2 {
+			typ = "BIGINT"
+		}
+		columns = append(columns, map[string]string{"id": "column", "name": name, "type": typ, "op": "0"})
+	}
+
+	return columns
+}
+
+// Registers with osquery and serves the table until the context is done
+// osquery may start later or restart, registration is retried every interval and the manager is pinged to notice restarts
+func (o *osqueryExtension) run(ctx context.Context) {
+	failing := false
+	for {
+		uuid, err := o.register()
+		if err == nil {
+			err = o.serve(ctx, uuid)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Only log the first of a run of failures, osquery not running yet is common
+		if err != nil && !failing {
+			el.Printf("osquery extension is not registered, retrying every %s. Error: %s\n", o.interval, err)
+		}
+		failing = err != nil
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(o.interval):
+		}
+	}
+}
+
+// Calls a method on the osquery extension manager, args writes the argument fields
+func (o *osqueryExtension) call(method string, args func(t *thriftConn)) (map[int16]interface{}, error) {
+	conn, err := net.DialTimeout("unix", o.socket, o.interval)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(o.interval))
+
+	t := newThriftConn(conn)
+	t.writeMessageBegin(method, thriftCall, 1)
+	args(t)
+	t.writeFieldStop()
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+
+	_, typ, _, _ := t.readMessageBegin()
+	result := t.readStruct()
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	if typ == thriftException {
+		return nil, fmt.Errorf("osquery %s failed. Error: %v", method, result[1])
+	}
+
+	// The result struct holds the return value in field 0
+	status, _ := result[0].(map[int16]interface{})
+	if status == nil {
+		return nil, fmt.Errorf("osquery %s returned nothing", method)
+	}
+
+	return status, nil
+}
+
+// Registers the table, returns the uuid osquery assigned to us
+func (o *osqueryExtension) register() (int64, error) {
+	status, err := o.call("registerExtension", func(t *thriftConn) {
+		t.writeFieldBegin(thriftStruct, 1)
+		t.writeFieldBegin(thriftString, 1)
+		t.writeString("go-audit")
+		t.writeFieldBegin(thriftString, 2)
+		t.writeString("1.0.0")
+		t.writeFieldBegin(thriftString, 3)
+		t.writeString("")
+		t.writeFieldBegin(thriftString, 4)
+		t.writeString("")
+		t.writeFieldStop()
+
+		t.writeFieldBegin(thriftMap, 2)
+		t.writeMapBegin(thriftString, thriftMap, 1)
+		t.writeString("table")
+		t.writeMapBegin(thriftString, thriftList, 1)
+		t.writeString(o.table)
+		t.writeRows(osqueryColumns())
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if code, _ := status[1].(int32); code != 0 {
+		return 0, fmt.Errorf("osquery refused the extension. Code: %d; Message: %v", code, status[2])
+	}
+
+	uuid, _ := status[3].(int64)
+	l.Printf("Registered with osquery as extension %d, serving table %s\n", uuid, o.table)
+	return uuid, nil
+}
+
+// Serves osquery on the extension socket until the context is done or the manager stops answering pings
+func (o *osqueryExtension) serve(ctx context.Context, uuid int64) error {
+	path := fmt.Sprintf("%s.%d", o.socket, uuid)
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("Failed to open osquery extension socket. Error: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go o.handle(conn)
+		}
+	}()
+
+	defer ln.Close()
+
+	t := time.NewTicker(o.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		if _, err := o.call("ping", func(t *thriftConn) {}); err != nil {
+			return fmt.Errorf("osquery stopped answering. Error: %s", err)
+		}
+	}
+}
+
+// Answers calls from osquery on a connection until it is closed
+func (o *osqueryExtension) handle(conn net.Conn) {
+	defer conn.Close()
+
+	t := newThriftConn(conn)
+	for {
+		method, _, seq, err := t.readMessageBegin()
+		args := t.readStruct()
+		if err != nil || t.err != nil {
+			return
+		}
+
+		switch method {
+		case "ping":
+			t.writeMessageBegin(method, thriftReply, seq)
+			t.writeFieldBegin(thriftStruct, 0)
+			writeOsqueryStatus(t, 0, "OK")
+			t.writeFieldStop()
+		case "call":
+			registry, _ := args[1].(string)
+			item, _ := args[2].(string)
+			request, _ := args[3].(map[string]interface{})
+
+			t.writeMessageBegin(method, thriftReply, seq)
+			t.writeFieldBegin(thriftStruct, 0)
+			t.writeFieldBegin(thriftStruct, 1)
+			rows, err := o.callTable(registry, item, request)
+			if err != nil {
+				writeOsqueryStatus(t, 1, err.Error())
+			} else {
+				writeOsqueryStatus(t, 0, "OK")
+			}
+			t.writeFieldBegin(thriftList, 2)
+			t.writeRows(rows)
+			t.writeFieldStop()
+			t.writeFieldStop()
+		case "shutdown":
+			// osquery is going away, the manager ping will notice
+			t.writeMessageBegin(method, thriftReply, seq)
+			t.writeFieldStop()
+		default:
+			t.writeMessageBegin(method, thriftException, seq)
+			t.writeFieldBegin(thriftString, 1)
+			t.writeString("Unknown method " + method)
+			t.writeFieldBegin(thriftI32, 2)
+			t.write(int32(1)) // UNKNOWN_METHOD
+			t.writeFieldStop()
+		}
+
+		if err := t.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Writes the fields of an ExtensionStatus struct
+func writeOsqueryStatus(t *thriftConn, code int32, message string) {
+	t.writeFieldBegin(thriftI32, 1)
+	t.write(code)
+	t.writeFieldBegin(thriftString, 2)
+	t.writeString(message)
+	t.writeFieldStop()
+}
+
+// Runs a table plugin action
+func (o *osqueryExtension) callTable(registry, item string, request map[string]interface{}) ([]map[string]string, error) {
+	if registry != "table" || item != o.table {
+		return nil, fmt.Errorf("Unknown plugin %s/%s", registry, item)
+	}
+
+	switch action, _ := request["action"].(string); action {
+	case "columns":
+		return osqueryColumns(), nil
+	case "generate":
+		ctx, _ := request["context"].(string)
+		return o.generate(osqueryQuery(ctx, o.events.maxEvents)), nil
+	default:
+		return nil, fmt.Errorf("Unknown table action `%s`", action)
+	}
+}
+
+// Builds the query from the time constraints in the osquery query context, osquery applies every other constraint itself
+func osqueryQuery(queryContext string, limit int) *recentQuery {
+	q := &recentQuery{limit: limit}
+
+	var qc struct {
+		Constraints []struct {
+			Name string `json:"name"`
+			List []struct {
+				Op   int    `json:"op"`
+				Expr string `json:"expr"`
+			} `json:"list"`
+		} `json:"constraints"`
+	}
+
+	if err := json.Unmarshal([]byte(queryContext), &qc); err != nil {
+		return q
+	}
+
+	for _, c := range qc.Constraints {
+		if c.Name != "time" {
+			continue
+		}
+
+		for _, e := range c.List {
+			sec, err := strconv.ParseInt(e.Expr, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			// Bounds are widened to whole seconds, osquery filters the rest
+			switch e.Op {
+			case osqueryGreaterThan, osqueryGreaterThanOrEqual:
+				q.since = time.Unix(sec, 0)
+			case osqueryLessThan, osqueryLessThanOrEquals:
+				q.until = time.Unix(sec+1, 0)
+			}
+		}
+	}
+
+	return q
+}
+
+// Flattens the matching events into table rows, missing fields are empty
+func (o *osqueryExtension) generate(q *recentQuery) []map[string]string {
+	rows := []map[string]string{}
+	for _, e := range o.events.query(q) {
+		values, err := flattenEvent(e)
+		if err != nil {
+			continue
+		}
+
+		row := map[string]string{"time": strconv.FormatInt(values[1].(int64)/1000, 10)}
+		for i, name := range flatColumns {
+			if values[i] != nil {
+				row[name] = fmt.Sprint(values[i])
+			} else {
+				row[name] = ""
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
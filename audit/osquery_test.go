@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createOsqueryExtension(t *testing.T) {
+	c := viper.New()
+	o, err := createOsqueryExtension(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o)
+
+	c.Set("osquery.enabled", true)
+	_, err = createOsqueryExtension(c)
+	assert.EqualError(t, err, "osquery.socket must be set")
+
+	c.Set("osquery.socket", "/var/osquery/osquery.em")
+	c.Set("osquery.table", "go-audit")
+	_, err = createOsqueryExtension(c)
+	assert.EqualError(t, err, "osquery.table could not be parsed; Value: `go-audit`")
+
+	c.Set("osquery.table", "go_audit_events")
+	_, err = createOsqueryExtension(c)
+	assert.EqualError(t, err, "osquery.max_events must be greater than 0, 0 provided")
+
+	c.Set("osquery.max_events", 10)
+	_, err = createOsqueryExtension(c)
+	assert.EqualError(t, err, "osquery.interval must be greater than 0, 0s provided")
+
+	c.Set("osquery.interval", "5s")
+	o, err = createOsqueryExtension(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/osquery/osquery.em", o.socket)
+	assert.Equal(t, "go_audit_events", o.table)
+	assert.Equal(t, 5*time.Second, o.interval)
+	assert.Equal(t, 10, o.events.maxEvents)
+}
+
+func Test_osqueryQuery(t *testing.T) {
+	assert.Equal(t, &recentQuery{limit: 10}, osqueryQuery("", 10))
+	assert.Equal(t, &recentQuery{limit: 10}, osqueryQuery(`{"constraints":[{"name":"uid","list":[{"op":2,"expr":"0"}]}]}`, 10))
+	assert.Equal(t, &recentQuery{limit: 10}, osqueryQuery(`{"constraints":[{"name":"time","list":[{"op":32,"expr":"nope"}]}]}`, 10))
+
+	assert.Equal(t,
+		&recentQuery{limit: 10, since: time.Unix(100, 0), until: time.Unix(201, 0)},
+		osqueryQuery(`{"constraints":[{"name":"time","list":[{"op":4,"expr":"100"},{"op":16,"expr":"200"}]}]}`, 10),
+	)
+}
+
+// Stands in for the osquery extension manager, it accepts any extension as uuid 7 and answers pings
+func fakeOsqueryManager(t *testing.T, path string, calls chan<- string) net.Listener {
+	ln, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			tc := newThriftConn(conn)
+			method, _, seq, _ := tc.readMessageBegin()
+			args := tc.readStruct()
+			if method == "registerExtension" {
+				info := args[1].(map[int16]interface{})
+				registry := args[2].(map[string]interface{})["table"].(map[string]interface{})
+				calls <- method + " " + info[1].(string) + " " + registry["go_audit_events"].([]interface{})[1].(map[string]interface{})["name"].(string)
+			} else {
+				calls <- method
+			}
+
+			tc.writeMessageBegin(method, thriftReply, seq)
+			tc.writeFieldBegin(thriftStruct, 0)
+			tc.writeFieldBegin(thriftI32, 1)
+			tc.write(int32(0))
+			tc.writeFieldBegin(thriftString, 2)
+			tc.writeString("OK")
+			tc.writeFieldBegin(thriftI64, 3)
+			tc.write(int64(7))
+			tc.writeFieldStop()
+			tc.writeFieldStop()
+			tc.flush()
+			conn.Close()
+		}
+	}()
+
+	return ln
+}
+
+// Calls the extension the way osquery would
+func osqueryCall(t *testing.T, conn net.Conn, method string, action string) (int32, map[int16]interface{}) {
+	tc := newThriftConn(conn)
+	tc.writeMessageBegin(method, thriftCall, 3)
+	if action != "" {
+		tc.writeFieldBegin(thriftString, 1)
+		tc.writeString("table")
+		tc.writeFieldBegin(thriftString, 2)
+		tc.writeString("go_audit_events")
+		tc.writeFieldBegin(thriftMap, 3)
+		tc.writeStringMap(map[string]string{"action": action, "context": `{"constraints":[]}`})
+	}
+	tc.writeFieldStop()
+	assert.Nil(t, tc.flush())
+
+	_, typ, seq, err := tc.readMessageBegin()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), seq)
+	return typ, tc.readStruct()
+}
+
+func TestOsqueryExtension(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	o := &osqueryExtension{
+		socket:   filepath.Join(dir, "osquery.em"),
+		table:    "go_audit_events",
+		interval: 50 * time.Millisecond,
+		events:   newRecentEvents(10, 0),
+	}
+	event := queryEvent(1, "100.000", "0", "/bin/sh")
+	event.TimestampMs = 100000
+	o.events.add(event)
+
+	// osquery is not running yet
+	_, err = o.register()
+	assert.Contains(t, err.Error(), "connect: no such file or directory")
+
+	calls := make(chan string, 100)
+	manager := fakeOsqueryManager(t, o.socket, calls)
+	defer manager.Close()
+
+	uuid, err := o.register()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), uuid)
+	assert.Equal(t, "registerExtension go-audit sequence", <-calls)
+	assert.Equal(t, "Registered with osquery as extension 7, serving table go_audit_events\n", lb.String())
+
+	served := make(chan error)
+	go func() {
+		served <- o.serve(context.Background(), uuid)
+	}()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", o.socket+".7"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	typ, reply := osqueryCall(t, conn, "ping", "")
+	assert.Equal(t, int32(thriftReply), typ)
+	assert.Equal(t, map[int16]interface{}{0: map[int16]interface{}{1: int32(0), 2: "OK"}}, reply)
+
+	typ, reply = osqueryCall(t, conn, "call", "columns")
+	assert.Equal(t, int32(thriftReply), typ)
+	response := reply[0].(map[int16]interface{})
+	assert.Equal(t, map[int16]interface{}{1: int32(0), 2: "OK"}, response[1])
+	columns := response[2].([]interface{})
+	assert.Len(t, columns, len(flatColumns)+1)
+	assert.Equal(t, map[string]interface{}{"id": "column", "name": "time", "type": "BIGINT", "op": "0"}, columns[0])
+	assert.Equal(t, map[string]interface{}{"id": "column", "name": "exe", "type": "TEXT", "op": "0"}, columns[11])
+
+	typ, reply = osqueryCall(t, conn, "call", "generate")
+	assert.Equal(t, int32(thriftReply), typ)
+	response = reply[0].(map[int16]interface{})
+	rows := response[2].([]interface{})
+	assert.Len(t, rows, 1)
+	row := rows[0].(map[string]interface{})
+	assert.Equal(t, "100", row["time"])
+	assert.Equal(t, "1", row["sequence"])
+	assert.Equal(t, "100000", row["timestamp_ms"])
+	assert.Equal(t, "0", row["uid"])
+	assert.Equal(t, "/bin/sh", row["exe"])
+	assert.Equal(t, "", row["syscall"])
+
+	typ, reply = osqueryCall(t, conn, "call", "delete")
+	assert.Equal(t, int32(thriftReply), typ)
+	response = reply[0].(map[int16]interface{})
+	assert.Equal(t, map[int16]interface{}{1: int32(1), 2: "Unknown table action `delete`"}, response[1])
+
+	typ, reply = osqueryCall(t, conn, "nope", "")
+	assert.Equal(t, int32(thriftException), typ)
+	assert.Equal(t, map[int16]interface{}{1: "Unknown method nope", 2: int32(1)}, reply)
+
+	// The manager is pinged every interval
+	assert.Equal(t, "ping", <-calls)
+
+	// osquery went away
+	manager.Close()
+	select {
+	case err := <-served:
+		assert.Contains(t, err.Error(), "osquery stopped answering. Error: dial unix")
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return")
+	}
+
+	assert.Equal(t, "", elb.String())
+}
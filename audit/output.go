@@ -0,0 +1,311 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Output is a destination for marshaled audit events
+// Retries, formatting and the like are handled by the OutputWriter that wraps the output
+type Output interface {
+	// Open prepares the output for writing, calling it again should re-establish the underlying connection or file
+	Open() error
+
+	// Write is called with a single marshaled message group
+	Write(p []byte) (int, error)
+
+	// Flush pushes out anything the output may have buffered
+	Flush() error
+
+	// Close flushes and releases any resources held by the output
+	Close() error
+
+	// Healthy reports if the output believes it can currently accept writes
+	Healthy() bool
+}
+
+// OutputFactory creates an Output, any output specific settings should be read from `output.<name>`
+type OutputFactory func(config *viper.Viper) (Output, error)
+
+var outputs = map[string]OutputFactory{}
+
+// RegisterOutput makes an Output available to be enabled with `output.<name>.enabled`
+// This is meant to be called from an init() func, registering the same name twice will panic
+func RegisterOutput(name string, factory OutputFactory) {
+	if _, ok := outputs[name]; ok {
+		panic(fmt.Sprintf("Output `%s` is already registered", name))
+	}
+
+	outputs[name] = factory
+}
+
+func init() {
+	RegisterOutput("syslog", createSyslogOutput)
+	RegisterOutput("file", createFileOutput)
+	RegisterOutput("stdout", createStdOutOutput)
+}
+
+// Creates every enabled output, each wrapped in its own OutputWriter
+// Every output gets every event when more than one is enabled, in the format from `output.<name>.format` or `output.format`
+// Outputs with `output.<name>.filters` skip the events matching them, or the others with `output.<name>.filter_mode: keep`
+func createOutput(config *viper.Viper) (AuditWriter, error) {
+	m, err := createOutputWriters(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.startSpools(config); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m.writer(), nil
+}
+
+// Creates every enabled output like createOutput does but leaves the spools to startSpools, a reload has to close the
+// outputs it replaces before their spools can be picked up again
+func createOutputWriters(config *viper.Viper) (*multiWriter, error) {
+	enabled, dl, err := enabledOutputs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &multiWriter{names: enabled}
+	for _, name := range enabled {
+		writer, err := createFormattedOutput(config, name)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+
+		writer.deadLetter = dl
+		m.writers = append(m.writers, writer)
+	}
+
+	return m, nil
+}
+
+// The names of the enabled outputs, sorted, and the dead letter file they share if there is one
+func enabledOutputs(config *viper.Viper) ([]string, *deadLetter, error) {
+	enabled := []string{}
+	for name := range outputs {
+		if config.GetBool("output." + name + ".enabled") {
+			enabled = append(enabled, name)
+		}
+	}
+
+	if len(enabled) == 0 {
+		return nil, nil, errors.New("No outputs were configured")
+	}
+
+	sort.Strings(enabled)
+	if err := checkFailoverMembers(config, enabled); err != nil {
+		return nil, nil, err
+	}
+
+	dl, err := createDeadLetter(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Dead letters are replayed to the output, there would be no telling which one they came from
+	if dl != nil && len(enabled) > 1 {
+		return nil, nil, errors.New("output.dead_letter can only be used with a single output, use output.spool with several")
+	}
+
+	// A spooled event never runs out of attempts, so nothing would go to the dead letter file
+	if dl != nil && config.GetString("output.spool.dir") != "" {
+		return nil, nil, errors.New("output.spool and output.dead_letter can't be used together")
+	}
+
+	return enabled, dl, nil
+}
+
+// Creates and starts the spool of every output that has `output.spool.dir` set
+func (m *multiWriter) startSpools(config *viper.Viper) error {
+	for i, writer := range m.writers {
+		spool, err := createSpool(config, m.names[i])
+		if err != nil {
+			return err
+		}
+
+		if spool != nil {
+			writer.spool = spool
+			writer.spool.start(writer.writeOnce, writer.Flush)
+		}
+	}
+
+	return nil
+}
+
+// The writer to hand out, a single output is used as is
+func (m *multiWriter) writer() AuditWriter {
+	if len(m.writers) == 1 {
+		return m.writers[0]
+	}
+
+	return m
+}
+
+// The format an output writes, `output.<name>.format` if it is set or else `output.format`
+func outputFormat(config *viper.Viper, name string) string {
+	if config.IsSet("output." + name + ".format") {
+		return config.GetString("output." + name + ".format")
+	}
+
+	return config.GetString("output.format")
+}
+
+// Creates a registered output along with its format and filters
+func createFormattedOutput(config *viper.Viper, name string) (*OutputWriter, error) {
+	marshaler, err := createNamedMarshaler(config, outputFormat(config, name))
+	if err != nil {
+		return nil, err
+	}
+
+	filters, keepOnly, err := parseOutputFilters(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := createNamedOutput(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range filters {
+		if keepOnly {
+			l.Printf("Output %s is only writing events matching %s\n", name, f)
+		} else {
+			l.Printf("Output %s is ignoring events matching %s\n", name, f)
+		}
+	}
+
+	writer.name = name
+	writer.m = marshaler
+	writer.filters = filters
+	writer.keepOnly = keepOnly
+	return writer, nil
+}
+
+// Reads `output.<name>.filters` and `output.<name>.filter_mode`
+func parseOutputFilters(config *viper.Viper, name string) ([]AuditFilter, bool, error) {
+	filters, err := parseFilters(config, "output."+name+".filters")
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to parse output.%s.filters. Error: %s", name, err)
+	}
+
+	for i, f := range filters {
+		if f.limit != nil {
+			return nil, false, fmt.Errorf("Filter %d of output.%s.filters can't sample or rate limit, only the top level filters can", i+1, name)
+		}
+	}
+
+	keepOnly, err := parseFilterMode(config, "output."+name+".filter_mode", filters)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return filters, keepOnly, nil
+}
+
+// Outputs in a failover chain are written to by the failover output, enabling them as well would write events twice
+func checkFailoverMembers(config *viper.Viper, enabled []string) error {
+	if !config.GetBool("output.failover.enabled") {
+		return nil
+	}
+
+	for _, member := range config.GetStringSlice("output.failover.outputs") {
+		for _, name := range enabled {
+			if name == member {
+				return fmt.Errorf("Output `%s` is in output.failover.outputs and can't be enabled on its own too", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Creates and opens a registered output
+func createNamedOutput(config *viper.Viper, name string) (*OutputWriter, error) {
+	o, attempts, err := newNamedOutput(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.Open(); err != nil {
+		return nil, err
+	}
+
+	return NewAuditWriter(o, attempts), nil
+}
+
+// Creates a registered output without opening it
+func newNamedOutput(config *viper.Viper, name string) (Output, int, error) {
+	factory, ok := outputs[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("Unknown output `%s`", name)
+	}
+
+	attempts := config.GetInt("output." + name + ".attempts")
+	if attempts < 1 {
+		return nil, 0, fmt.Errorf("Output attempts for %s must be at least 1, %v provided", name, attempts)
+	}
+
+	o, err := factory(config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return o, attempts, nil
+}
+
+// WriterOutput adapts a plain io.Writer into an Output
+// If the writer is also an io.Closer it will be closed along with the output
+type WriterOutput struct {
+	w   io.Writer
+	mu  sync.Mutex
+	err error
+}
+
+func NewWriterOutput(w io.Writer) *WriterOutput {
+	return &WriterOutput{w: w}
+}
+
+func (o *WriterOutput) Open() error {
+	return nil
+}
+
+func (o *WriterOutput) Write(p []byte) (n int, err error) {
+	n, err = o.w.Write(p)
+
+	o.mu.Lock()
+	o.err = err
+	o.mu.Unlock()
+
+	return n, err
+}
+
+func (o *WriterOutput) Flush() error {
+	return nil
+}
+
+func (o *WriterOutput) Close() error {
+	if c, ok := o.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Healthy is true as long as the last write succeeded
+func (o *WriterOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// batchOutput collects events for outputs that send them in batches, like clickhouse and sql
+// A batch is sent once it is full and every interval, send must not keep the rows it is given
+type batchOutput struct {
+	size     int
+	interval time.Duration
+	send     func(rows [][]byte) error
+
+	mu   sync.Mutex
+	rows [][]byte
+	err  error // Last send error, nil if it succeeded
+	stop chan struct{}
+}
+
+func newBatchOutput(size int, interval time.Duration, send func(rows [][]byte) error) *batchOutput {
+	return &batchOutput{size: size, interval: interval, send: send}
+}
+
+// Open starts sending partial batches every interval
+func (o *batchOutput) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop == nil {
+		o.stop = make(chan struct{})
+		go o.run(o.stop)
+	}
+
+	return nil
+}
+
+func (o *batchOutput) run(stop chan struct{}) {
+	t := time.NewTicker(o.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		if err := o.Flush(); err != nil {
+			el.Println(err)
+		}
+	}
+}
+
+// Write adds the event to the batch, a full batch is sent first
+// If that fails the event is refused so the writer can retry it, the batch is kept for the next attempt
+func (o *batchOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.rows) >= o.size {
+		if err := o.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	// The writer may reuse p
+	o.rows = append(o.rows, append([]byte{}, p...))
+	return len(p), nil
+}
+
+func (o *batchOutput) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.flush()
+}
+
+// Sends the current batch, must be called with mu held
+func (o *batchOutput) flush() error {
+	if len(o.rows) == 0 {
+		return nil
+	}
+
+	if o.err = o.send(o.rows); o.err != nil {
+		return o.err
+	}
+
+	o.rows = o.rows[:0]
+	return nil
+}
+
+// Close sends whatever is left and stops the interval
+func (o *batchOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+
+	if err := o.flush(); err != nil {
+		return fmt.Errorf("%s, %d events were lost", err, len(o.rows))
+	}
+
+	return nil
+}
+
+// Healthy is true as long as the last send succeeded
+func (o *batchOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("clickhouse", createClickhouseOutput)
+}
+
+// Database and table names are put in the insert query as is
+var clickhouseTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// clickhouseOutput batches events and inserts them through the ClickHouse http interface as JSONEachRow
+// The table only needs columns for the fields it cares about, see examples/clickhouse/schema.sql
+type clickhouseOutput struct {
+	*batchOutput
+	url      string
+	query    string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func createClickhouseOutput(config *viper.Viper) (Output, error) {
+	// Rows are inserted as JSONEachRow, so only json can be used
+	if f := outputFormat(config, "clickhouse"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output clickhouse requires the json output format, `%s` is configured", f)
+	}
+
+	u, err := url.Parse(config.GetString("output.clickhouse.url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("output.clickhouse.url could not be parsed; Value: `%s`", config.GetString("output.clickhouse.url"))
+	}
+
+	table := config.GetString("output.clickhouse.table")
+	if !clickhouseTableName.MatchString(table) {
+		return nil, fmt.Errorf("output.clickhouse.table must be a table name like database.table; Value: `%s`", table)
+	}
+
+	batchSize := config.GetInt("output.clickhouse.batch_size")
+	if batchSize < 1 {
+		return nil, fmt.Errorf("output.clickhouse.batch_size must be greater than 0, %v provided", batchSize)
+	}
+
+	interval := config.GetDuration("output.clickhouse.flush_interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("output.clickhouse.flush_interval must be greater than 0, %v provided", interval)
+	}
+
+	// Columns that are not part of the event are left to their defaults, nested objects can go in String columns
+	q := url.Values{}
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	q.Set("input_format_skip_unknown_fields", "1")
+	q.Set("input_format_json_read_objects_as_strings", "1")
+	q.Set("input_format_json_named_tuples_as_objects", "1")
+	q.Set("input_format_json_defaults_for_missing_elements_in_named_tuple", "1")
+
+	o := &clickhouseOutput{
+		url:      strings.TrimSuffix(u.String(), "/") + "/",
+		query:    q.Encode(),
+		user:     config.GetString("output.clickhouse.user"),
+		password: config.GetString("output.clickhouse.password"),
+		client:   &http.Client{Timeout: config.GetDuration("output.clickhouse.timeout")},
+	}
+
+	o.batchOutput = newBatchOutput(batchSize, interval, o.insert)
+	return o, nil
+}
+
+// Inserts a batch as one request
+func (o *clickhouseOutput) insert(rows [][]byte) error {
+	body := &bytes.Buffer{}
+	for _, row := range rows {
+		body.Write(bytes.TrimRight(row, "\n"))
+		body.WriteByte('\n')
+	}
+
+	return o.post(body)
+}
+
+func (o *clickhouseOutput) post(body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, o.url+"?"+o.query, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if o.user != "" {
+		req.Header.Set("X-ClickHouse-User", o.user)
+		req.Header.Set("X-ClickHouse-Key", o.password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to insert into clickhouse. Error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// ClickHouse explains what went wrong in the body
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Failed to insert into clickhouse. Status: %s; Error: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
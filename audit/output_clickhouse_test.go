@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createClickhouseOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.clickhouse.url", "http://127.0.0.1:8123")
+	c.Set("output.clickhouse.table", "go_audit.events")
+	c.Set("output.clickhouse.batch_size", 10)
+	c.Set("output.clickhouse.flush_interval", "5s")
+
+	c.Set("output.format", "msgpack")
+	_, err := createClickhouseOutput(c)
+	assert.EqualError(t, err, "Output clickhouse requires the json output format, `msgpack` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.clickhouse.url", "127.0.0.1:8123")
+	_, err = createClickhouseOutput(c)
+	assert.EqualError(t, err, "output.clickhouse.url could not be parsed; Value: `127.0.0.1:8123`")
+	c.Set("output.clickhouse.url", "http://127.0.0.1:8123")
+
+	c.Set("output.clickhouse.table", "events; DROP TABLE events")
+	_, err = createClickhouseOutput(c)
+	assert.EqualError(t, err, "output.clickhouse.table must be a table name like database.table; Value: `events; DROP TABLE events`")
+	c.Set("output.clickhouse.table", "go_audit.events")
+
+	c.Set("output.clickhouse.batch_size", 0)
+	_, err = createClickhouseOutput(c)
+	assert.EqualError(t, err, "output.clickhouse.batch_size must be greater than 0, 0 provided")
+	c.Set("output.clickhouse.batch_size", 10)
+
+	c.Set("output.clickhouse.flush_interval", "0s")
+	_, err = createClickhouseOutput(c)
+	assert.EqualError(t, err, "output.clickhouse.flush_interval must be greater than 0, 0s provided")
+	c.Set("output.clickhouse.flush_interval", "5s")
+
+	o, err := createClickhouseOutput(c)
+	assert.Nil(t, err)
+	co := o.(*clickhouseOutput)
+	assert.Equal(t, "http://127.0.0.1:8123/", co.url)
+	assert.Contains(t, co.query, "query=INSERT+INTO+go_audit.events+FORMAT+JSONEachRow")
+	assert.Contains(t, co.query, "input_format_skip_unknown_fields=1")
+	assert.Equal(t, 10, co.size)
+}
+
+func TestClickhouseOutput(t *testing.T) {
+	var bodies []string
+	var users []string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "INSERT INTO go_audit.events FORMAT JSONEachRow", r.URL.Query().Get("query"))
+
+		if status != http.StatusOK {
+			http.Error(w, "Code: 60. DB::Exception: Table go_audit.events doesn't exist", status)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		users = append(users, r.Header.Get("X-ClickHouse-User")+":"+r.Header.Get("X-ClickHouse-Key"))
+	}))
+	defer ts.Close()
+
+	c := viper.New()
+	c.Set("output.clickhouse.url", ts.URL)
+	c.Set("output.clickhouse.table", "go_audit.events")
+	c.Set("output.clickhouse.user", "go_audit")
+	c.Set("output.clickhouse.password", "secret")
+	c.Set("output.clickhouse.batch_size", 2)
+	c.Set("output.clickhouse.flush_interval", "1h")
+	c.Set("output.clickhouse.timeout", "5s")
+
+	o, err := createClickhouseOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+
+	// Nothing is sent until the batch is full
+	p := []byte("{\"sequence\":1}\n")
+	n, err := o.Write(p)
+	assert.Nil(t, err)
+	assert.Equal(t, len(p), n)
+	p[12] = '2' // The row must have been copied
+	o.Write(p)
+	assert.Len(t, bodies, 0)
+
+	o.Write([]byte("{\"sequence\":3}\n"))
+	assert.Equal(t, []string{"{\"sequence\":1}\n{\"sequence\":2}\n"}, bodies)
+	assert.Equal(t, []string{"go_audit:secret"}, users)
+
+	// Failed inserts keep the batch and refuse the event
+	o.Write([]byte("{\"sequence\":4}"))
+	status = http.StatusNotFound
+	_, err = o.Write([]byte("{\"sequence\":5}\n"))
+	assert.EqualError(t, err, "Failed to insert into clickhouse. Status: 404 Not Found; Error: Code: 60. DB::Exception: Table go_audit.events doesn't exist")
+	assert.False(t, o.Healthy())
+
+	status = http.StatusOK
+	_, err = o.Write([]byte("{\"sequence\":5}\n"))
+	assert.Nil(t, err)
+	assert.True(t, o.Healthy())
+	assert.Equal(t, "{\"sequence\":3}\n{\"sequence\":4}\n", bodies[1])
+
+	// Close sends what is left
+	assert.Nil(t, o.Close())
+	assert.Equal(t, "{\"sequence\":5}\n", bodies[2])
+	assert.Nil(t, o.Flush())
+	assert.Len(t, bodies, 3)
+}
+
+func TestClickhouseOutput_interval(t *testing.T) {
+	sent := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		sent <- string(b)
+	}))
+	defer ts.Close()
+
+	c := viper.New()
+	c.Set("output.clickhouse.url", ts.URL)
+	c.Set("output.clickhouse.table", "events")
+	c.Set("output.clickhouse.batch_size", 100)
+	c.Set("output.clickhouse.flush_interval", "10ms")
+
+	o, err := createClickhouseOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+	defer o.Close()
+
+	o.Write([]byte("{\"sequence\":1}\n"))
+	select {
+	case b := <-sent:
+		assert.Equal(t, "{\"sequence\":1}\n", b)
+	case <-time.After(time.Second):
+		t.Fatal("Partial batch was not inserted")
+	}
+}
@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("elasticsearch", createElasticsearchOutput)
+}
+
+// Matches the date part of index patterns like go-audit-%{+yyyy.MM.dd}
+var elasticsearchIndexDate = regexp.MustCompile(`%\{\+([^}]+)\}`)
+
+// Joda style date fields, as logstash takes them, to the go layout
+var elasticsearchDateLayout = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15")
+
+// elasticsearchOutput indexes batches of events through the _bulk api, elasticsearch and opensearch both work
+// Events the cluster pushes back on with a 429 are retried with exponential backoff, other rejected events are dropped
+type elasticsearchOutput struct {
+	*batchOutput
+	url        string
+	index      string
+	user       string
+	password   string
+	apiKey     string
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	client     *http.Client
+	sleep      func(time.Duration) // Replaced in tests
+}
+
+// The parts of a _bulk response needed to find events that should be retried
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func createElasticsearchOutput(config *viper.Viper) (Output, error) {
+	// Events are indexed as they are, so only json can be used
+	if f := outputFormat(config, "elasticsearch"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output elasticsearch requires the json output format, `%s` is configured", f)
+	}
+
+	u, err := url.Parse(config.GetString("output.elasticsearch.url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("output.elasticsearch.url could not be parsed; Value: `%s`", config.GetString("output.elasticsearch.url"))
+	}
+
+	index := config.GetString("output.elasticsearch.index")
+	name := elasticsearchIndexDate.ReplaceAllString(index, "")
+	if name == "" || name != strings.ToLower(name) || strings.ContainsAny(name, " \"*\\<|,>/?#%{}") {
+		return nil, fmt.Errorf("output.elasticsearch.index must be a lowercase index name; Value: `%s`", index)
+	}
+
+	batchSize := config.GetInt("output.elasticsearch.batch_size")
+	if batchSize < 1 {
+		return nil, fmt.Errorf("output.elasticsearch.batch_size must be greater than 0, %v provided", batchSize)
+	}
+
+	interval := config.GetDuration("output.elasticsearch.flush_interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("output.elasticsearch.flush_interval must be greater than 0, %v provided", interval)
+	}
+
+	attempts := config.GetInt("output.elasticsearch.retry.attempts")
+	if attempts < 1 {
+		return nil, fmt.Errorf("output.elasticsearch.retry.attempts must be at least 1, %v provided", attempts)
+	}
+
+	user, apiKey := config.GetString("output.elasticsearch.user"), config.GetString("output.elasticsearch.api_key")
+	if user != "" && apiKey != "" {
+		return nil, errors.New("Only one of output.elasticsearch.user or output.elasticsearch.api_key can be set")
+	}
+
+	tlsConfig, err := createTLSConfig(config, "output.elasticsearch.tls")
+	if err != nil {
+		return nil, err
+	}
+
+	o := &elasticsearchOutput{
+		url:        strings.TrimSuffix(u.String(), "/") + "/_bulk",
+		index:      index,
+		user:       user,
+		password:   config.GetString("output.elasticsearch.password"),
+		apiKey:     apiKey,
+		attempts:   attempts,
+		backoff:    config.GetDuration("output.elasticsearch.retry.backoff"),
+		maxBackoff: config.GetDuration("output.elasticsearch.retry.max_backoff"),
+		client: &http.Client{
+			Timeout:   config.GetDuration("output.elasticsearch.timeout"),
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		sleep: time.Sleep,
+	}
+
+	o.batchOutput = newBatchOutput(batchSize, interval, o.bulk)
+	return o, nil
+}
+
+// Returns the index an event goes in, dates come from the event timestamp in UTC or the current time if it has none
+func (o *elasticsearchOutput) indexFor(row []byte) string {
+	if !strings.Contains(o.index, "%{+") {
+		return o.index
+	}
+
+	var ts struct {
+		TimestampMs int64 `json:"timestamp_ms"`
+	}
+
+	t := time.Now()
+	if json.Unmarshal(row, &ts) == nil && ts.TimestampMs != 0 {
+		t = time.Unix(0, ts.TimestampMs*int64(time.Millisecond))
+	}
+
+	return elasticsearchIndexDate.ReplaceAllStringFunc(o.index, func(m string) string {
+		layout := elasticsearchIndexDate.FindStringSubmatch(m)[1]
+		return t.UTC().Format(elasticsearchDateLayout.Replace(layout))
+	})
+}
+
+// Indexes a batch, retrying the events that were pushed back on
+// If they still fail the whole batch is kept for the next attempt, events that made it in will be indexed again
+func (o *elasticsearchOutput) bulk(rows [][]byte) error {
+	wait := o.backoff
+	for i := 0; ; i++ {
+		retry, err := o.post(rows)
+		if err == nil && len(retry) == 0 {
+			return nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("Elasticsearch rejected %d events with 429 Too Many Requests", len(retry))
+			rows = retry
+		}
+
+		if i == o.attempts-1 {
+			return err
+		}
+
+		el.Printf("Failed to index events, retrying in %s. Error: %s\n", wait, err)
+		o.sleep(wait)
+
+		if wait *= 2; o.maxBackoff > 0 && wait > o.maxBackoff {
+			wait = o.maxBackoff
+		}
+	}
+}
+
+// Sends one _bulk request, returning the events that should be retried
+func (o *elasticsearchOutput) post(rows [][]byte) ([][]byte, error) {
+	body := &bytes.Buffer{}
+	for _, row := range rows {
+		action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": o.indexFor(row)}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(bytes.TrimRight(row, "\n"))
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+o.apiKey)
+	} else if o.user != "" {
+		req.SetBasicAuth(o.user, o.password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to index events. Error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Failed to index events. Status: %s; Error: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var br elasticsearchBulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, fmt.Errorf("Failed to parse the elasticsearch response. Error: %s", err)
+	}
+
+	if !br.Errors {
+		return nil, nil
+	}
+
+	// Items come back in the order they were sent
+	var retry [][]byte
+	dropped := 0
+	for i, item := range br.Items {
+		for _, r := range item {
+			switch {
+			case r.Status == http.StatusTooManyRequests && i < len(rows):
+				retry = append(retry, rows[i])
+			case r.Status > 299:
+				// Mapping conflicts and the like won't go away by trying again
+				if dropped == 0 {
+					el.Printf("Elasticsearch rejected an event. Status: %d; Error: %s\n", r.Status, r.Error)
+				}
+				dropped++
+			}
+		}
+	}
+
+	if dropped > 0 {
+		el.Printf("Dropped %d events elasticsearch rejected\n", dropped)
+	}
+
+	return retry, nil
+}
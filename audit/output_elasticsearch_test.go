@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createElasticsearchOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.elasticsearch.url", "http://127.0.0.1:9200")
+	c.Set("output.elasticsearch.index", "go-audit-%{+yyyy.MM.dd}")
+	c.Set("output.elasticsearch.batch_size", 10)
+	c.Set("output.elasticsearch.flush_interval", "5s")
+	c.Set("output.elasticsearch.retry.attempts", 5)
+
+	c.Set("output.format", "msgpack")
+	_, err := createElasticsearchOutput(c)
+	assert.EqualError(t, err, "Output elasticsearch requires the json output format, `msgpack` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.elasticsearch.url", "127.0.0.1:9200")
+	_, err = createElasticsearchOutput(c)
+	assert.EqualError(t, err, "output.elasticsearch.url could not be parsed; Value: `127.0.0.1:9200`")
+	c.Set("output.elasticsearch.url", "http://127.0.0.1:9200/")
+
+	for _, index := range []string{"", "Go-Audit", "go audit", "go-audit-%{yyyy}"} {
+		c.Set("output.elasticsearch.index", index)
+		_, err = createElasticsearchOutput(c)
+		assert.EqualError(t, err, "output.elasticsearch.index must be a lowercase index name; Value: `"+index+"`")
+	}
+	c.Set("output.elasticsearch.index", "go-audit-%{+yyyy.MM.dd}")
+
+	c.Set("output.elasticsearch.batch_size", 0)
+	_, err = createElasticsearchOutput(c)
+	assert.EqualError(t, err, "output.elasticsearch.batch_size must be greater than 0, 0 provided")
+	c.Set("output.elasticsearch.batch_size", 10)
+
+	c.Set("output.elasticsearch.retry.attempts", 0)
+	_, err = createElasticsearchOutput(c)
+	assert.EqualError(t, err, "output.elasticsearch.retry.attempts must be at least 1, 0 provided")
+	c.Set("output.elasticsearch.retry.attempts", 5)
+
+	c.Set("output.elasticsearch.user", "go_audit")
+	c.Set("output.elasticsearch.api_key", "key")
+	_, err = createElasticsearchOutput(c)
+	assert.EqualError(t, err, "Only one of output.elasticsearch.user or output.elasticsearch.api_key can be set")
+	c.Set("output.elasticsearch.user", "")
+
+	o, err := createElasticsearchOutput(c)
+	assert.Nil(t, err)
+	eo := o.(*elasticsearchOutput)
+	assert.Equal(t, "http://127.0.0.1:9200/_bulk", eo.url)
+	assert.Equal(t, 10, eo.size)
+}
+
+func Test_elasticsearchOutput_indexFor(t *testing.T) {
+	o := &elasticsearchOutput{index: "go-audit"}
+	assert.Equal(t, "go-audit", o.indexFor([]byte("{}")))
+
+	// 2017-05-25 23:59:59.999 UTC
+	o.index = "go-audit-%{+yyyy.MM.dd}"
+	assert.Equal(t, "go-audit-2017.05.25", o.indexFor([]byte("{\"timestamp_ms\":1495756799999}")))
+
+	o.index = "audit-%{+yy}-%{+MM.dd.HH}"
+	assert.Equal(t, "audit-17-05.25.23", o.indexFor([]byte("{\"timestamp_ms\":1495756799999}")))
+
+	// Without a timestamp the event goes in today's index
+	o.index = "go-audit-%{+yyyy.MM.dd}"
+	assert.Equal(t, "go-audit-"+time.Now().UTC().Format("2006.01.02"), o.indexFor([]byte("{\"sequence\":1}")))
+}
+
+func TestElasticsearchOutput(t *testing.T) {
+	var bodies []string
+	var auth []string
+	responses := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		auth = append(auth, r.Header.Get("Authorization"))
+
+		resp := "{\"errors\":false,\"items\":[]}"
+		if len(responses) > 0 {
+			resp, responses = responses[0], responses[1:]
+		}
+
+		if strings.HasPrefix(resp, "429") {
+			http.Error(w, "{\"status\":429}", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	c.Set("output.elasticsearch.url", ts.URL)
+	c.Set("output.elasticsearch.index", "go-audit-%{+yyyy.MM.dd}")
+	c.Set("output.elasticsearch.batch_size", 3)
+	c.Set("output.elasticsearch.flush_interval", "1h")
+	c.Set("output.elasticsearch.retry.attempts", 3)
+	c.Set("output.elasticsearch.retry.backoff", "1s")
+	c.Set("output.elasticsearch.api_key", "c2VjcmV0")
+	o, err := createElasticsearchOutput(c)
+	assert.Nil(t, err)
+
+	eo := o.(*elasticsearchOutput)
+	var waits []time.Duration
+	eo.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	action := "{\"index\":{\"_index\":\"go-audit-2017.05.25\"}}\n"
+	row := func(seq string) string {
+		return "{\"sequence\":" + seq + ",\"timestamp_ms\":1495756799999}\n"
+	}
+
+	// The cluster pushes back on the whole request, then on the second event, and drops the third
+	responses = []string{
+		"429",
+		"{\"errors\":true,\"items\":[{\"index\":{\"status\":201}},{\"index\":{\"status\":429}},{\"index\":{\"status\":400,\"error\":{\"type\":\"mapper_parsing_exception\"}}}]}",
+	}
+	o.Write([]byte(row("1")))
+	o.Write([]byte(row("2")))
+	o.Write([]byte(row("3")))
+	assert.Nil(t, o.Flush())
+
+	assert.Equal(t, []string{
+		action + row("1") + action + row("2") + action + row("3"),
+		action + row("1") + action + row("2") + action + row("3"),
+		action + row("2"),
+	}, bodies)
+	assert.Equal(t, []string{"ApiKey c2VjcmV0", "ApiKey c2VjcmV0", "ApiKey c2VjcmV0"}, auth)
+	assert.Equal(t, []time.Duration{time.Second, time.Second * 2}, waits)
+	assert.Contains(t, elb.String(), "Failed to index events, retrying in 1s. Error: Failed to index events. Status: 429 Too Many Requests")
+	assert.Contains(t, elb.String(), "Elasticsearch rejected an event. Status: 400; Error: {\"type\":\"mapper_parsing_exception\"}")
+	assert.Contains(t, elb.String(), "Dropped 1 events elasticsearch rejected")
+	assert.True(t, o.Healthy())
+
+	// Giving up keeps the batch
+	responses = []string{"429", "429", "429"}
+	o.Write([]byte(row("4")))
+	assert.EqualError(t, o.Flush(), "Failed to index events. Status: 429 Too Many Requests; Error: {\"status\":429}")
+	assert.False(t, o.Healthy())
+
+	assert.Nil(t, o.Flush())
+	assert.Equal(t, action+row("4"), bodies[len(bodies)-1])
+}
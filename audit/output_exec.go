@@ -0,0 +1,336 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("exec", createExecOutput)
+}
+
+// Longest stderr line of a plugin that is logged in one piece
+const execMaxStderrLine = 4096
+
+// execOutput streams newline delimited events to the stdin of a plugin, a program that can be written in any language
+// Writes only queue the event, a single goroutine feeds the plugin and restarts it with backoff whenever it exits
+// Events still in the pipe or not yet handled when the plugin dies are lost, like events in a socket buffer
+type execOutput struct {
+	command      string
+	args         []string
+	env          []string
+	writeTimeout time.Duration
+	backoff      time.Duration
+	maxBackoff   time.Duration
+
+	queue   chan []byte
+	pending int64 // Queued events plus the one being sent, accessed atomically
+
+	mu   sync.Mutex
+	err  error // Last start or write error, nil once an event was sent
+	stop chan struct{}
+	done chan struct{}
+}
+
+// A running plugin
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	exited chan struct{} // Closed once it exited
+	err    error         // From Wait, only read after exited is closed
+}
+
+func createExecOutput(config *viper.Viper) (Output, error) {
+	// Events are framed by newlines, so only json can be used
+	if f := outputFormat(config, "exec"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output exec requires the json output format, `%s` is configured", f)
+	}
+
+	command := config.GetString("output.exec.command")
+	if command == "" {
+		return nil, errors.New("output.exec.command must be set")
+	}
+
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find output.exec.command. Error: %s", err)
+	}
+
+	queueSize := config.GetInt("output.exec.queue_size")
+	if queueSize < 1 {
+		return nil, fmt.Errorf("output.exec.queue_size must be greater than 0, %v provided", queueSize)
+	}
+
+	writeTimeout := config.GetDuration("output.exec.write_timeout")
+	if writeTimeout <= 0 {
+		return nil, fmt.Errorf("output.exec.write_timeout must be greater than 0, %v provided", writeTimeout)
+	}
+
+	backoff := config.GetDuration("output.exec.restart.backoff")
+	if backoff <= 0 {
+		return nil, fmt.Errorf("output.exec.restart.backoff must be greater than 0, %v provided", backoff)
+	}
+
+	return &execOutput{
+		command:      path,
+		args:         config.GetStringSlice("output.exec.args"),
+		env:          config.GetStringSlice("output.exec.env"),
+		writeTimeout: writeTimeout,
+		backoff:      backoff,
+		maxBackoff:   config.GetDuration("output.exec.restart.max_backoff"),
+		queue:        make(chan []byte, queueSize),
+	}, nil
+}
+
+// Open starts the plugin and feeds it queued events
+func (o *execOutput) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop == nil {
+		o.stop = make(chan struct{})
+		o.done = make(chan struct{})
+		go o.run(o.stop, o.done)
+	}
+
+	return nil
+}
+
+// Write queues the event, it is refused if the queue is full so the writer can retry it
+func (o *execOutput) Write(p []byte) (int, error) {
+	// The writer may reuse p
+	line := append(bytes.TrimRight(append([]byte{}, p...), "\n"), '\n')
+
+	atomic.AddInt64(&o.pending, 1)
+	select {
+	case o.queue <- line:
+		return len(p), nil
+	default:
+		atomic.AddInt64(&o.pending, -1)
+		return 0, fmt.Errorf("The output.exec queue is full, %d events are waiting", cap(o.queue))
+	}
+}
+
+// Flush waits up to write_timeout for the queue to be handed to the plugin
+func (o *execOutput) Flush() error {
+	deadline := time.Now().Add(o.writeTimeout)
+	for {
+		n := atomic.LoadInt64(&o.pending)
+		if n == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Failed to flush the output.exec queue, %d events are still waiting", n)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Close gives the queue a chance to drain, then closes the plugin's stdin and waits for it to exit
+func (o *execOutput) Close() error {
+	err := o.Flush()
+
+	o.mu.Lock()
+	stop, done := o.stop, o.done
+	o.stop = nil
+	o.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s, they were lost", err)
+	}
+
+	return nil
+}
+
+// Healthy is true as long as the plugin took the last event and the queue has room
+func (o *execOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil && len(o.queue) < cap(o.queue)
+}
+
+func (o *execOutput) setErr(err error) {
+	o.mu.Lock()
+	o.err = err
+	o.mu.Unlock()
+}
+
+// Feeds queued events to the plugin one at a time, an event is only taken off once the plugin took it
+func (o *execOutput) run(stop, done chan struct{}) {
+	defer close(done)
+
+	var p *execProcess
+	defer func() {
+		if p != nil {
+			o.end(p)
+		}
+	}()
+
+	wait := o.backoff
+	for {
+		// Started before the first event so the plugin can get ready, like connecting to where it sends events
+		if p == nil {
+			if p = o.restart(stop, &wait); p == nil {
+				return
+			}
+		}
+
+		var line []byte
+		select {
+		case <-stop:
+			return
+		case <-p.exited:
+			o.setErr(fmt.Errorf("%s exited. Error: %v", o.command, p.err))
+			el.Printf("%s exited, restarting it in %s. Error: %v\n", o.command, wait, p.err)
+			p = nil
+			if !o.sleep(stop, &wait) {
+				return
+			}
+			continue
+		case line = <-o.queue:
+		}
+
+		for {
+			if p == nil {
+				if p = o.restart(stop, &wait); p == nil {
+					return
+				}
+			}
+
+			p.stdin.SetWriteDeadline(time.Now().Add(o.writeTimeout))
+
+			// A partially written event would garble the stream, the plugin is replaced and gets it again in full
+			if _, err := p.stdin.Write(line); err != nil {
+				o.setErr(fmt.Errorf("Failed to write to %s. Error: %s", o.command, err))
+				el.Printf("Failed to write to %s, restarting it in %s. Error: %s\n", o.command, wait, err)
+				o.end(p)
+				p = nil
+				if !o.sleep(stop, &wait) {
+					return
+				}
+				continue
+			}
+
+			break
+		}
+
+		o.setErr(nil)
+		wait = o.backoff
+		atomic.AddInt64(&o.pending, -1)
+	}
+}
+
+// Starts the plugin, retrying with backoff. nil if stop was closed first
+func (o *execOutput) restart(stop chan struct{}, wait *time.Duration) *execProcess {
+	for {
+		p, err := o.start()
+		if err == nil {
+			return p
+		}
+
+		o.setErr(fmt.Errorf("Failed to start %s. Error: %s", o.command, err))
+		el.Printf("Failed to start %s, retrying in %s. Error: %s\n", o.command, *wait, err)
+		if !o.sleep(stop, wait) {
+			return nil
+		}
+	}
+}
+
+// Waits wait, doubling it up to max_backoff. false if stop was closed first
+func (o *execOutput) sleep(stop chan struct{}, wait *time.Duration) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(*wait):
+	}
+
+	if *wait *= 2; o.maxBackoff > 0 && *wait > o.maxBackoff {
+		*wait = o.maxBackoff
+	}
+
+	return true
+}
+
+func (o *execOutput) start() (*execProcess, error) {
+	// Our own pipe instead of StdinPipe, writes need a deadline so a stuck plugin can't stall the output forever
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(o.command, o.args...)
+	cmd.Env = append(os.Environ(), o.env...)
+	cmd.Stdin = r
+	cmd.Stderr = &execStderr{command: o.command}
+	// Children of the plugin can keep stderr open after it exited
+	cmd.WaitDelay = o.writeTimeout
+
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+
+	r.Close()
+
+	p := &execProcess{cmd: cmd, stdin: w, exited: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.exited)
+	}()
+
+	return p, nil
+}
+
+// Closes stdin so the plugin can finish up and exit, it is killed if it takes longer than write_timeout
+func (o *execOutput) end(p *execProcess) {
+	p.stdin.Close()
+
+	select {
+	case <-p.exited:
+	case <-time.After(o.writeTimeout):
+		el.Printf("%s did not exit within %s of closing its stdin, killing it\n", o.command, o.writeTimeout)
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// Logs what a plugin writes to stderr, a line at a time
+type execStderr struct {
+	command string
+	line    []byte
+}
+
+func (s *execStderr) Write(p []byte) (int, error) {
+	s.line = append(s.line, p...)
+	for {
+		i := bytes.IndexByte(s.line, '\n')
+		if i < 0 {
+			if len(s.line) >= execMaxStderrLine {
+				i = len(s.line)
+			} else {
+				break
+			}
+		}
+
+		el.Printf("%s: %s\n", s.command, s.line[:i])
+		s.line = s.line[min(i+1, len(s.line)):]
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createExecOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.exec.command", "cat")
+	c.Set("output.exec.queue_size", 10)
+	c.Set("output.exec.write_timeout", "1s")
+	c.Set("output.exec.restart.backoff", "1s")
+
+	c.Set("output.format", "protobuf")
+	_, err := createExecOutput(c)
+	assert.EqualError(t, err, "Output exec requires the json output format, `protobuf` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.exec.command", "")
+	_, err = createExecOutput(c)
+	assert.EqualError(t, err, "output.exec.command must be set")
+
+	c.Set("output.exec.command", "go-audit-does-not-exist")
+	_, err = createExecOutput(c)
+	assert.EqualError(t, err, "Failed to find output.exec.command. Error: exec: \"go-audit-does-not-exist\": executable file not found in $PATH")
+	c.Set("output.exec.command", "cat")
+
+	c.Set("output.exec.queue_size", 0)
+	_, err = createExecOutput(c)
+	assert.EqualError(t, err, "output.exec.queue_size must be greater than 0, 0 provided")
+	c.Set("output.exec.queue_size", 10)
+
+	c.Set("output.exec.write_timeout", "0s")
+	_, err = createExecOutput(c)
+	assert.EqualError(t, err, "output.exec.write_timeout must be greater than 0, 0s provided")
+	c.Set("output.exec.write_timeout", "1s")
+
+	c.Set("output.exec.restart.backoff", "0s")
+	_, err = createExecOutput(c)
+	assert.EqualError(t, err, "output.exec.restart.backoff must be greater than 0, 0s provided")
+	c.Set("output.exec.restart.backoff", "1s")
+
+	// The command is looked up in PATH
+	o, err := createExecOutput(c)
+	assert.Nil(t, err)
+	assert.True(t, path.IsAbs(o.(*execOutput).command))
+}
+
+func newTestExecOutput(t *testing.T, script string, env ...string) *execOutput {
+	c := viper.New()
+	c.Set("output.exec.command", "/bin/sh")
+	c.Set("output.exec.args", []string{"-c", script})
+	c.Set("output.exec.env", env)
+	c.Set("output.exec.queue_size", 10)
+	c.Set("output.exec.write_timeout", "2s")
+	c.Set("output.exec.restart.backoff", "10ms")
+	c.Set("output.exec.restart.max_backoff", "50ms")
+
+	o, err := createExecOutput(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o.(*execOutput)
+}
+
+func TestExecOutput(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := path.Join(dir, "events")
+	o := newTestExecOutput(t, `echo started >&2; cat >> "$EVENTS"`, "EVENTS="+out)
+	assert.Nil(t, o.Open())
+
+	for _, e := range []string{`{"sequence":1}`, `{"sequence":2}` + "\n"} {
+		n, err := o.Write([]byte(e))
+		assert.Nil(t, err)
+		assert.Equal(t, len(e), n)
+	}
+
+	assert.Nil(t, o.Flush())
+	assert.Nil(t, o.Close())
+	assert.True(t, o.Healthy())
+
+	// Closing stdin let cat finish writing
+	b, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"sequence\":1}\n{\"sequence\":2}\n", string(b))
+	assert.Contains(t, elb.String(), "/bin/sh: started\n")
+}
+
+func TestExecOutput_restart(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Takes one event and exits, every event needs a new plugin
+	out := path.Join(dir, "events")
+	o := newTestExecOutput(t, `read -r line; echo "$line" >> "$EVENTS"; exit 3`, "EVENTS="+out)
+	assert.Nil(t, o.Open())
+
+	// Events still in the pipe when a plugin exits are gone with it, so each one waits for the last to be written
+	for i := 1; i <= 3; i++ {
+		_, err := o.Write([]byte(`{"sequence":1}`))
+		assert.Nil(t, err)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			b, _ := ioutil.ReadFile(out)
+			if strings.Count(string(b), "\n") == i || time.Now().After(deadline) {
+				assert.Equal(t, strings.Repeat("{\"sequence\":1}\n", i), string(b))
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	assert.Nil(t, o.Close())
+	assert.Contains(t, elb.String(), "/bin/sh exited, restarting it in ")
+	assert.Contains(t, elb.String(), "Error: exit status 3\n")
+}
+
+func TestExecOutput_stuck(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	// Never reads stdin and does not notice it closing, it has to be killed
+	o := newTestExecOutput(t, `exec sleep 60`)
+	o.writeTimeout = 100 * time.Millisecond
+	assert.Nil(t, o.Open())
+
+	// Bigger than a pipe buffer, the write can't finish
+	_, err := o.Write([]byte(strings.Repeat("a", 1<<20)))
+	assert.Nil(t, err)
+
+	err = o.Close()
+	assert.EqualError(t, err, "Failed to flush the output.exec queue, 1 events are still waiting, they were lost")
+	assert.False(t, o.Healthy())
+	assert.Contains(t, elb.String(), "Failed to write to /bin/sh, restarting it in ")
+	assert.Contains(t, elb.String(), "/bin/sh did not exit within 100ms of closing its stdin, killing it\n")
+}
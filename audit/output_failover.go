@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("failover", createFailoverOutput)
+}
+
+// failoverOutput writes to the first output in its chain that accepts the write
+// An output that has been failing for longer than `after` is skipped, and only retried once every `after`, until a write succeeds again
+type failoverOutput struct {
+	names   []string
+	outputs []Output
+	after   time.Duration
+
+	mu     sync.Mutex
+	active int
+	down   []time.Time // When each output started failing, zero while healthy
+	probe  []time.Time // When a skipped output should be tried again
+}
+
+func createFailoverOutput(config *viper.Viper) (Output, error) {
+	names := config.GetStringSlice("output.failover.outputs")
+	if len(names) < 2 {
+		return nil, errors.New("output.failover.outputs needs at least 2 outputs")
+	}
+
+	after := config.GetDuration("output.failover.after")
+	if after <= 0 {
+		return nil, fmt.Errorf("output.failover.after must be greater than 0, %v provided", after)
+	}
+
+	f := &failoverOutput{
+		names: names,
+		after: after,
+		down:  make([]time.Time, len(names)),
+		probe: make([]time.Time, len(names)),
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "failover" || seen[name] {
+			return nil, fmt.Errorf("Output `%s` can only be used once in output.failover.outputs", name)
+		}
+		seen[name] = true
+
+		// Members are configured under output.<name> as usual, their enabled and attempts settings are ignored
+		factory, ok := outputs[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown output `%s` in output.failover.outputs", name)
+		}
+
+		o, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create failover output %s. Error: %s", name, err)
+		}
+
+		f.outputs = append(f.outputs, o)
+	}
+
+	return f, nil
+}
+
+func (f *failoverOutput) Open() error {
+	for i, o := range f.outputs {
+		if err := o.Open(); err != nil {
+			return fmt.Errorf("Failed to open failover output %s. Error: %s", f.names[i], err)
+		}
+	}
+
+	return nil
+}
+
+func (f *failoverOutput) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.write(p, time.Now())
+}
+
+func (f *failoverOutput) write(p []byte, now time.Time) (n int, err error) {
+	last := len(f.outputs) - 1
+	for i, o := range f.outputs {
+		// The last output is always tried, there is nowhere else to go
+		failing := !f.down[i].IsZero() && now.Sub(f.down[i]) >= f.after
+		if i != last && failing && now.Before(f.probe[i]) {
+			continue
+		}
+
+		if n, err = o.Write(p); err == nil {
+			if !f.down[i].IsZero() {
+				l.Printf("Output `%s` recovered\n", f.names[i])
+				f.down[i] = time.Time{}
+			}
+
+			if f.active != i {
+				l.Printf("Writing to output `%s`\n", f.names[i])
+				f.active = i
+			}
+
+			return n, nil
+		}
+
+		if f.down[i].IsZero() {
+			f.down[i] = now
+		}
+		f.probe[i] = now.Add(f.after)
+
+		if i != last {
+			el.Printf("Failed to write to output `%s`, trying `%s`. Error: %s\n", f.names[i], f.names[i+1], err)
+		}
+	}
+
+	return n, err
+}
+
+func (f *failoverOutput) Flush() error {
+	var err error
+	for i, o := range f.outputs {
+		if ferr := o.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("Failed to flush failover output %s. Error: %s", f.names[i], ferr)
+		}
+	}
+
+	return err
+}
+
+func (f *failoverOutput) Close() error {
+	var err error
+	for i, o := range f.outputs {
+		if cerr := o.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Failed to close failover output %s. Error: %s", f.names[i], cerr)
+		}
+	}
+
+	return err
+}
+
+// Healthy is true as long as any output in the chain is
+func (f *failoverOutput) Healthy() bool {
+	for _, o := range f.outputs {
+		if o.Healthy() {
+			return true
+		}
+	}
+
+	return false
+}
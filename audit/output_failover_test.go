@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createFailoverOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.failover.after", "30s")
+
+	_, err := createFailoverOutput(c)
+	assert.EqualError(t, err, "output.failover.outputs needs at least 2 outputs")
+
+	c.Set("output.failover.outputs", []string{"stdout", "nope"})
+	_, err = createFailoverOutput(c)
+	assert.EqualError(t, err, "Unknown output `nope` in output.failover.outputs")
+
+	c.Set("output.failover.outputs", []string{"stdout", "failover"})
+	_, err = createFailoverOutput(c)
+	assert.EqualError(t, err, "Output `failover` can only be used once in output.failover.outputs")
+
+	c.Set("output.failover.outputs", []string{"stdout", "stdout"})
+	_, err = createFailoverOutput(c)
+	assert.EqualError(t, err, "Output `stdout` can only be used once in output.failover.outputs")
+
+	c.Set("output.failover.outputs", []string{"file", "stdout"})
+	_, err = createFailoverOutput(c)
+	assert.EqualError(t, err, "Failed to create failover output file. Error: Output file mode should be greater than 0000")
+
+	c.Set("output.failover.after", "0s")
+	_, err = createFailoverOutput(c)
+	assert.EqualError(t, err, "output.failover.after must be greater than 0, 0s provided")
+
+	// enabled like any other output
+	c = viper.New()
+	c.Set("output.failover.enabled", true)
+	c.Set("output.failover.attempts", 1)
+	c.Set("output.failover.after", "30s")
+	c.Set("output.failover.outputs", []string{"syslog", "stdout"})
+	c.Set("output.syslog.network", "unixgram")
+	c.Set("output.syslog.address", "/does/not/exist")
+	_, err = createOutput(c)
+	assert.Contains(t, err.Error(), "Failed to create failover output syslog. Error: Failed to open syslog writer.")
+
+	c.Set("output.failover.outputs", []string{"stdout", "stdout2"})
+	_, err = createOutput(c)
+	assert.EqualError(t, err, "Unknown output `stdout2` in output.failover.outputs")
+}
+
+func TestFailoverOutput_write(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	primary, fallback := NewWriterOutput(&FailWriter{}), NewWriterOutput(&bytes.Buffer{})
+	f := &failoverOutput{
+		names:   []string{"primary", "fallback"},
+		outputs: []Output{primary, fallback},
+		after:   time.Second * 30,
+		down:    make([]time.Time, 2),
+		probe:   make([]time.Time, 2),
+	}
+	assert.Nil(t, f.Open())
+
+	// A failed write goes to the next output right away
+	now := time.Unix(1000, 0)
+	n, err := f.write([]byte("1"), now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "1", fallback.w.(*bytes.Buffer).String())
+	assert.Equal(t, 1, f.active)
+	assert.Equal(t, now, f.down[0])
+	assert.True(t, f.Healthy())
+	assert.Equal(t, "Failed to write to output `primary`, trying `fallback`. Error: derp\n", elb.String())
+	assert.Equal(t, "Writing to output `fallback`\n", lb.String())
+
+	// The primary is still tried for every write until it has been failing for longer than after
+	primary.w = &bytes.Buffer{}
+	_, err = f.write([]byte("2"), now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", primary.w.(*bytes.Buffer).String())
+	assert.Equal(t, 0, f.active)
+	assert.True(t, f.down[0].IsZero(), "The primary should have recovered")
+	assert.Equal(t, "Writing to output `fallback`\nOutput `primary` recovered\nWriting to output `primary`\n", lb.String())
+
+	// Once it has been failing long enough the primary is skipped until it is time to probe again
+	primary.w = &FailWriter{}
+	f.write([]byte("3"), now)
+	f.write([]byte("4"), now.Add(time.Second*30))
+	assert.Equal(t, "134", fallback.w.(*bytes.Buffer).String())
+
+	primary.w = &bytes.Buffer{}
+	f.write([]byte("5"), now.Add(time.Second*59))
+	assert.Equal(t, "1345", fallback.w.(*bytes.Buffer).String())
+	assert.Equal(t, "", primary.w.(*bytes.Buffer).String())
+
+	// And returns when the primary recovers
+	f.write([]byte("6"), now.Add(time.Second*60))
+	assert.Equal(t, "6", primary.w.(*bytes.Buffer).String())
+	assert.Equal(t, 0, f.active)
+
+	// The last output is always tried and its error returned
+	primary.w = &FailWriter{}
+	fallback.w = &FailWriter{}
+	_, err = f.write([]byte("7"), now.Add(time.Second*61))
+	assert.EqualError(t, err, "derp")
+	assert.False(t, f.Healthy())
+
+	_, err = f.write([]byte("8"), now.Add(time.Second*100))
+	assert.EqualError(t, err, "derp")
+
+	assert.Nil(t, f.Flush())
+	assert.Nil(t, f.Close())
+}
@@ -0,0 +1,341 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("fluentd", createFluentdOutput)
+}
+
+// fluentdOutput sends batches of events to fluentd or fluent-bit with the forward protocol, in forward mode
+// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+// Each event becomes a record with the same fields as the json format, stamped with its audit time
+type fluentdOutput struct {
+	*batchOutput
+	address      string
+	tlsConfig    *tls.Config // nil for plain tcp
+	tag          string
+	timeout      time.Duration
+	requireAck   bool
+	sharedKey    string // Turns on the handshake
+	selfHostname string
+	username     string
+	password     string
+
+	// Only used by send, which batchOutput never calls concurrently
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func createFluentdOutput(config *viper.Viper) (Output, error) {
+	// Records are made from the json events
+	if f := outputFormat(config, "fluentd"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output fluentd requires the json output format, `%s` is configured", f)
+	}
+
+	address := config.GetString("output.fluentd.address")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("output.fluentd.address could not be parsed; Value: `%s`", address)
+	}
+
+	tag := config.GetString("output.fluentd.tag")
+	if tag == "" {
+		return nil, errors.New("output.fluentd.tag must be set")
+	}
+
+	batchSize := config.GetInt("output.fluentd.batch_size")
+	if batchSize < 1 {
+		return nil, fmt.Errorf("output.fluentd.batch_size must be greater than 0, %v provided", batchSize)
+	}
+
+	interval := config.GetDuration("output.fluentd.flush_interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("output.fluentd.flush_interval must be greater than 0, %v provided", interval)
+	}
+
+	timeout := config.GetDuration("output.fluentd.timeout")
+	if timeout <= 0 {
+		return nil, fmt.Errorf("output.fluentd.timeout must be greater than 0, %v provided", timeout)
+	}
+
+	o := &fluentdOutput{
+		address:      address,
+		tag:          tag,
+		timeout:      timeout,
+		requireAck:   config.GetBool("output.fluentd.require_ack"),
+		sharedKey:    config.GetString("output.fluentd.shared_key"),
+		selfHostname: config.GetString("output.fluentd.self_hostname"),
+		username:     config.GetString("output.fluentd.username"),
+		password:     config.GetString("output.fluentd.password"),
+	}
+
+	if o.username != "" && o.sharedKey == "" {
+		return nil, errors.New("output.fluentd.username needs output.fluentd.shared_key to be set")
+	}
+
+	if o.selfHostname == "" {
+		o.selfHostname, _ = os.Hostname()
+	}
+
+	if config.GetBool("output.fluentd.tls.enabled") {
+		tlsConfig, err := createTLSConfig(config, "output.fluentd.tls")
+		if err != nil {
+			return nil, err
+		}
+
+		o.tlsConfig = tlsConfig
+	}
+
+	o.batchOutput = newBatchOutput(batchSize, interval, o.send)
+	return o, nil
+}
+
+// Sends a batch as one forward mode message, waiting for the ack if require_ack is on
+// Any error drops the connection, the next batch reconnects
+func (o *fluentdOutput) send(rows [][]byte) error {
+	msg, chunk, err := o.encode(rows)
+	if err != nil || msg == nil {
+		return err
+	}
+
+	if o.conn != nil && peerClosed(o.conn) {
+		o.disconnect()
+	}
+
+	if o.conn == nil {
+		if err := o.connect(); err != nil {
+			return fmt.Errorf("Failed to connect to fluentd at %s. Error: %s", o.address, err)
+		}
+	}
+
+	o.conn.SetDeadline(time.Now().Add(o.timeout))
+	if _, err := o.conn.Write(msg); err != nil {
+		o.disconnect()
+		return fmt.Errorf("Failed to send %d events to fluentd. Error: %s", len(rows), err)
+	}
+
+	if o.requireAck {
+		resp, err := msgpackRead(o.r)
+		if err != nil {
+			o.disconnect()
+			return fmt.Errorf("Failed to read the fluentd ack for %d events. Error: %s", len(rows), err)
+		}
+
+		if m, _ := resp.(map[string]interface{}); m == nil || m["ack"] != chunk {
+			o.disconnect()
+			return fmt.Errorf("Unexpected ack from fluentd; Value: `%v`", resp)
+		}
+	}
+
+	return nil
+}
+
+// Builds [tag, [[time, record], ...], option], returns the chunk id the ack has to match. nil if no event was left
+func (o *fluentdOutput) encode(rows [][]byte) ([]byte, string, error) {
+	entries := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		d := json.NewDecoder(bytes.NewReader(row))
+		// Keeps large numbers, like inodes, exact
+		d.UseNumber()
+
+		record := map[string]interface{}{}
+		if err := d.Decode(&record); err != nil {
+			el.Printf("Dropping an event fluentd can not take. Error: %s\n", err)
+			continue
+		}
+
+		entries = append(entries, []interface{}{fluentdTime(record), record})
+	}
+
+	if len(entries) == 0 {
+		return nil, "", nil
+	}
+
+	option := map[string]interface{}{"size": len(entries)}
+
+	var chunk string
+	if o.requireAck {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, "", err
+		}
+
+		chunk = base64.StdEncoding.EncodeToString(id)
+		option["chunk"] = chunk
+	}
+
+	msg, err := msgpackAppend(nil, []interface{}{o.tag, entries, option})
+	return msg, chunk, err
+}
+
+// The audit time as a fluentd EventTime, seconds and nanoseconds. Now for events without a timestamp_ms
+func fluentdTime(record map[string]interface{}) msgpackExt {
+	t := time.Now()
+	if n, ok := record["timestamp_ms"].(json.Number); ok {
+		if ms, err := n.Int64(); err == nil && ms > 0 {
+			t = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return msgpackExt{Type: 0, Data: b}
+}
+
+func (o *fluentdOutput) connect() error {
+	d := &net.Dialer{Timeout: o.timeout}
+
+	var conn net.Conn
+	var err error
+	if o.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(d, "tcp", o.address, o.tlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", o.address)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	o.conn, o.r = conn, bufio.NewReader(conn)
+	if o.sharedKey == "" {
+		return nil
+	}
+
+	if err := o.handshake(); err != nil {
+		o.disconnect()
+		return err
+	}
+
+	return nil
+}
+
+// The shared key handshake: HELO from the server, PING from us, PONG from the server
+// Both sides prove they know the shared key by hashing it with the server nonce and a salt of ours
+func (o *fluentdOutput) handshake() error {
+	o.conn.SetDeadline(time.Now().Add(o.timeout))
+
+	helo, err := msgpackRead(o.r)
+	if err != nil {
+		return fmt.Errorf("Failed to read HELO. Error: %s", err)
+	}
+
+	h, _ := helo.([]interface{})
+	if len(h) < 2 || h[0] != "HELO" {
+		return fmt.Errorf("Expected HELO from fluentd; Value: `%v`", helo)
+	}
+
+	options, _ := h[1].(map[string]interface{})
+	nonce := msgpackBytes(options["nonce"])
+	auth := msgpackBytes(options["auth"])
+
+	if len(auth) > 0 && o.username == "" {
+		return errors.New("fluentd requires a username and password")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	saltHex := hex.EncodeToString(salt)
+
+	ping := []interface{}{
+		"PING",
+		o.selfHostname,
+		saltHex,
+		sha512Hex(saltHex, o.selfHostname, string(nonce), o.sharedKey),
+		"",
+		"",
+	}
+
+	if len(auth) > 0 {
+		ping[4] = o.username
+		ping[5] = sha512Hex(string(auth), o.username, o.password)
+	}
+
+	b, err := msgpackAppend(nil, ping)
+	if err != nil {
+		return err
+	}
+
+	if _, err := o.conn.Write(b); err != nil {
+		return err
+	}
+
+	pong, err := msgpackRead(o.r)
+	if err != nil {
+		return fmt.Errorf("Failed to read PONG. Error: %s", err)
+	}
+
+	p, _ := pong.([]interface{})
+	if len(p) < 5 || p[0] != "PONG" {
+		return fmt.Errorf("Expected PONG from fluentd; Value: `%v`", pong)
+	}
+
+	if ok, _ := p[1].(bool); !ok {
+		return fmt.Errorf("fluentd refused the handshake. Error: %v", p[2])
+	}
+
+	serverHostname, _ := p[3].(string)
+	if p[4] != sha512Hex(saltHex, serverHostname, string(nonce), o.sharedKey) {
+		return errors.New("fluentd does not know the shared key")
+	}
+
+	return nil
+}
+
+func (o *fluentdOutput) disconnect() {
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn, o.r = nil, nil
+	}
+}
+
+// Close sends what is left and drops the connection
+func (o *fluentdOutput) Close() error {
+	err := o.batchOutput.Close()
+
+	o.batchOutput.mu.Lock()
+	o.disconnect()
+	o.batchOutput.mu.Unlock()
+
+	return err
+}
+
+// fluentd sends binary fields as bin or str depending on the version
+func msgpackBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+
+	return nil
+}
+
+func sha512Hex(parts ...string) string {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,274 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// A fluentd forward input, with the shared key handshake if sharedKey is set
+type testFluentd struct {
+	ln        net.Listener
+	sharedKey string
+	auth      string // Asks for user auth if set
+	password  string
+	ack       bool
+
+	mu       sync.Mutex
+	messages []interface{}
+	pings    []interface{}
+}
+
+func newTestFluentd(t *testing.T, sharedKey, auth, password string, ack bool) *testFluentd {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	f := &testFluentd{ln: ln, sharedKey: sharedKey, auth: auth, password: password, ack: ack}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *testFluentd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	if f.sharedKey != "" {
+		helo, _ := msgpackAppend(nil, []interface{}{"HELO", map[string]interface{}{
+			"nonce":     []byte("nonce"),
+			"auth":      []byte(f.auth),
+			"keepalive": true,
+		}})
+		conn.Write(helo)
+
+		v, err := msgpackRead(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.pings = append(f.pings, v)
+		f.mu.Unlock()
+
+		ping := v.([]interface{})
+		ok := ping[3] == sha512Hex(ping[2].(string), ping[1].(string), "nonce", f.sharedKey)
+		reason := "shared key mismatch"
+		if ok && f.auth != "" && ping[5] != sha512Hex(f.auth, ping[4].(string), f.password) {
+			ok, reason = false, "username/password mismatch"
+		}
+
+		if !ok {
+			pong, _ := msgpackAppend(nil, []interface{}{"PONG", false, reason, "", ""})
+			conn.Write(pong)
+			return
+		}
+
+		pong, _ := msgpackAppend(nil, []interface{}{"PONG", true, "", "fluentd1", sha512Hex(ping[2].(string), "fluentd1", "nonce", f.sharedKey)})
+		conn.Write(pong)
+	}
+
+	for {
+		v, err := msgpackRead(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.messages = append(f.messages, v)
+		f.mu.Unlock()
+
+		if f.ack {
+			option := v.([]interface{})[2].(map[string]interface{})
+			b, _ := msgpackAppend(nil, map[string]interface{}{"ack": option["chunk"]})
+			conn.Write(b)
+		}
+	}
+}
+
+func (f *testFluentd) getMessages() []interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]interface{}{}, f.messages...)
+}
+
+func testFluentdConfig(address string) *viper.Viper {
+	c := viper.New()
+	c.Set("output.fluentd.address", address)
+	c.Set("output.fluentd.tag", "go-audit")
+	c.Set("output.fluentd.batch_size", 10)
+	c.Set("output.fluentd.flush_interval", "1h")
+	c.Set("output.fluentd.timeout", "1s")
+	return c
+}
+
+func Test_createFluentdOutput(t *testing.T) {
+	c := testFluentdConfig("127.0.0.1:24224")
+
+	c.Set("output.format", "protobuf")
+	_, err := createFluentdOutput(c)
+	assert.EqualError(t, err, "Output fluentd requires the json output format, `protobuf` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.fluentd.address", "127.0.0.1")
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "output.fluentd.address could not be parsed; Value: `127.0.0.1`")
+	c.Set("output.fluentd.address", "127.0.0.1:24224")
+
+	c.Set("output.fluentd.tag", "")
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "output.fluentd.tag must be set")
+	c.Set("output.fluentd.tag", "go-audit")
+
+	c.Set("output.fluentd.batch_size", 0)
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "output.fluentd.batch_size must be greater than 0, 0 provided")
+	c.Set("output.fluentd.batch_size", 10)
+
+	c.Set("output.fluentd.flush_interval", "0s")
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "output.fluentd.flush_interval must be greater than 0, 0s provided")
+	c.Set("output.fluentd.flush_interval", "1h")
+
+	c.Set("output.fluentd.timeout", "0s")
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "output.fluentd.timeout must be greater than 0, 0s provided")
+	c.Set("output.fluentd.timeout", "1s")
+
+	c.Set("output.fluentd.username", "go_audit")
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "output.fluentd.username needs output.fluentd.shared_key to be set")
+	c.Set("output.fluentd.username", "")
+
+	c.Set("output.fluentd.tls.enabled", true)
+	c.Set("output.fluentd.tls.ca_file", "/tmp/go-audit-does-not-exist")
+	_, err = createFluentdOutput(c)
+	assert.EqualError(t, err, "Failed to read output.fluentd.tls.ca_file. Error: open /tmp/go-audit-does-not-exist: no such file or directory")
+}
+
+func TestFluentdOutput_forward(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	f := newTestFluentd(t, "", "", "", false)
+	defer f.ln.Close()
+
+	o, err := createFluentdOutput(testFluentdConfig(f.ln.Addr().String()))
+	assert.Nil(t, err)
+
+	o.Write([]byte(`{"sequence":1,"timestamp_ms":1500000000123,"messages":[{"type":1300,"data":"inode=18446744073709551615"}]}` + "\n"))
+	o.Write([]byte("not json\n"))
+	assert.Nil(t, o.Flush())
+
+	// Nothing left to send
+	assert.Nil(t, o.Flush())
+
+	assert.Nil(t, o.Close())
+	assert.Equal(t, "", lb.String())
+	assert.Contains(t, elb.String(), "Dropping an event fluentd can not take. Error: invalid character 'o' in literal null (expecting 'u')\n")
+
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint32(ts, 1500000000)
+	binary.BigEndian.PutUint32(ts[4:], 123000000)
+
+	assert.Eventually(t, func() bool { return len(f.getMessages()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []interface{}{
+		"go-audit",
+		[]interface{}{
+			[]interface{}{
+				msgpackExt{Type: 0, Data: ts},
+				map[string]interface{}{
+					"sequence":     int64(1),
+					"timestamp_ms": int64(1500000000123),
+					"messages":     []interface{}{map[string]interface{}{"type": int64(1300), "data": "inode=18446744073709551615"}},
+				},
+			},
+		},
+		map[string]interface{}{"size": int64(1)},
+	}, f.getMessages()[0])
+}
+
+func TestFluentdOutput_handshake(t *testing.T) {
+	f := newTestFluentd(t, "secret", "salt", "password", true)
+	defer f.ln.Close()
+
+	c := testFluentdConfig(f.ln.Addr().String())
+	c.Set("output.fluentd.require_ack", true)
+	c.Set("output.fluentd.shared_key", "secret")
+	c.Set("output.fluentd.self_hostname", "web1")
+	c.Set("output.fluentd.username", "go_audit")
+	c.Set("output.fluentd.password", "wrong")
+
+	o, err := createFluentdOutput(c)
+	assert.Nil(t, err)
+
+	o.Write([]byte(`{"sequence":1}`))
+	assert.EqualError(t, o.Flush(), "Failed to connect to fluentd at "+f.ln.Addr().String()+". Error: fluentd refused the handshake. Error: username/password mismatch")
+	assert.False(t, o.Healthy())
+
+	o.(*fluentdOutput).password = "password"
+	assert.Nil(t, o.Flush())
+	assert.True(t, o.Healthy())
+
+	// The connection is reused
+	o.Write([]byte(`{"sequence":2}`))
+	assert.Nil(t, o.Flush())
+	assert.Nil(t, o.Close())
+
+	f.mu.Lock()
+	assert.Len(t, f.pings, 2)
+	assert.Equal(t, "web1", f.pings[1].([]interface{})[1])
+	assert.Equal(t, "go_audit", f.pings[1].([]interface{})[4])
+	f.mu.Unlock()
+
+	messages := f.getMessages()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, int64(2), messages[1].([]interface{})[1].([]interface{})[0].([]interface{})[1].(map[string]interface{})["sequence"])
+	assert.NotEmpty(t, messages[1].([]interface{})[2].(map[string]interface{})["chunk"])
+}
+
+func TestFluentdOutput_wrongKey(t *testing.T) {
+	f := newTestFluentd(t, "secret", "", "", false)
+	defer f.ln.Close()
+
+	c := testFluentdConfig(f.ln.Addr().String())
+	c.Set("output.fluentd.shared_key", "wrong")
+
+	o, err := createFluentdOutput(c)
+	assert.Nil(t, err)
+
+	o.Write([]byte(`{"sequence":1}`))
+	assert.EqualError(t, o.Flush(), "Failed to connect to fluentd at "+f.ln.Addr().String()+". Error: fluentd refused the handshake. Error: shared key mismatch")
+}
+
+func TestFluentdOutput_noAck(t *testing.T) {
+	// Takes events but never acks them
+	f := newTestFluentd(t, "", "", "", false)
+	defer f.ln.Close()
+
+	c := testFluentdConfig(f.ln.Addr().String())
+	c.Set("output.fluentd.require_ack", true)
+	c.Set("output.fluentd.timeout", "100ms")
+
+	o, err := createFluentdOutput(c)
+	assert.Nil(t, err)
+
+	o.Write([]byte(`{"sequence":1}`))
+	err = o.Flush()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Failed to read the fluentd ack for 1 events. Error: ")
+	assert.Contains(t, err.Error(), "i/o timeout")
+}
@@ -0,0 +1,305 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("grpc", createGRPCOutput)
+}
+
+// The method events are streamed to, see examples/grpc/go-audit.proto
+const grpcStreamMethod = "/goaudit.v1.Collector/Stream"
+
+// grpcOutput streams events to a collector with the client streaming Collector.Stream call, over http/2 with or without tls
+// A stream is ended every max_events or max_age, that is when the collector confirms how many events it received
+// Writes block while the collector's http/2 flow control window is used up, for at most timeout
+type grpcOutput struct {
+	url       string
+	client    *http.Client
+	metadata  map[string]string
+	timeout   time.Duration
+	maxEvents int
+	maxAge    time.Duration
+
+	mu     sync.Mutex
+	stream *grpcStream
+	err    error // Last stream error, nil once an event was written
+}
+
+// One Collector.Stream call
+type grpcStream struct {
+	r      *io.PipeReader // The request body, closing it is how a write is interrupted
+	w      *io.PipeWriter
+	sent   uint64
+	opened time.Time
+
+	done     chan struct{} // Closed once the call returned
+	err      error         // Why the call failed, only read after done is closed
+	received uint64        // From the StreamSummary, only read after done is closed
+}
+
+func createGRPCOutput(config *viper.Viper) (Output, error) {
+	// Every event is sent as one message, which is what the protobuf format makes
+	if f := outputFormat(config, "grpc"); f != "protobuf" {
+		return nil, fmt.Errorf("Output grpc requires the protobuf output format, `%s` is configured", f)
+	}
+
+	address := config.GetString("output.grpc.address")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("output.grpc.address could not be parsed; Value: `%s`", address)
+	}
+
+	timeout := config.GetDuration("output.grpc.timeout")
+	if timeout <= 0 {
+		return nil, fmt.Errorf("output.grpc.timeout must be greater than 0, %v provided", timeout)
+	}
+
+	maxEvents := config.GetInt("output.grpc.stream.max_events")
+	if maxEvents < 1 {
+		return nil, fmt.Errorf("output.grpc.stream.max_events must be greater than 0, %v provided", maxEvents)
+	}
+
+	maxAge := config.GetDuration("output.grpc.stream.max_age")
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("output.grpc.stream.max_age must be greater than 0, %v provided", maxAge)
+	}
+
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{Timeout: timeout}).DialContext,
+		HTTP2: &http.HTTP2Config{
+			SendPingTimeout: config.GetDuration("output.grpc.keepalive.time"),
+			PingTimeout:     config.GetDuration("output.grpc.keepalive.timeout"),
+		},
+		Protocols: &http.Protocols{},
+	}
+
+	u := &url.URL{Scheme: "http", Host: address, Path: grpcStreamMethod}
+	if config.GetBool("output.grpc.tls.enabled") {
+		tlsConfig, err := createTLSConfig(config, "output.grpc.tls")
+		if err != nil {
+			return nil, err
+		}
+
+		u.Scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+		transport.Protocols.SetHTTP2(true)
+	} else {
+		// Prior knowledge http/2, like grpc does without tls
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &grpcOutput{
+		url:       u.String(),
+		client:    &http.Client{Transport: transport},
+		metadata:  config.GetStringMapString("output.grpc.metadata"),
+		timeout:   timeout,
+		maxEvents: maxEvents,
+		maxAge:    maxAge,
+	}, nil
+}
+
+// Open does nothing, the first write starts a stream so a collector that is down does not stop go-audit starting
+func (o *grpcOutput) Open() error {
+	return nil
+}
+
+// Write sends the event on the current stream, starting one if needed. A failed write ends the stream
+func (o *grpcOutput) Write(p []byte) (int, error) {
+	msg, err := grpcFrame(p)
+	if err != nil {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stream == nil {
+		o.stream = o.open()
+	}
+
+	if err := o.stream.write(msg, o.timeout); err != nil {
+		o.stream.w.CloseWithError(err)
+		o.stream = nil
+		o.err = err
+		return 0, err
+	}
+
+	o.err = nil
+
+	if o.stream.sent >= uint64(o.maxEvents) || time.Since(o.stream.opened) >= o.maxAge {
+		// The event made it onto the stream, a collector that loses it is only logged
+		if err := o.finish(); err != nil {
+			el.Printf("Output grpc stream failed. Error: %s\n", err)
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush ends the current stream, so the collector confirms every event written so far
+func (o *grpcOutput) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.finish()
+}
+
+func (o *grpcOutput) Close() error {
+	err := o.Flush()
+	o.client.CloseIdleConnections()
+	return err
+}
+
+// Healthy is true as long as the last write succeeded
+func (o *grpcOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil
+}
+
+// Starts a stream, the call runs until the stream is ended or fails. Must be called with mu held
+func (o *grpcOutput) open() *grpcStream {
+	r, w := io.Pipe()
+	s := &grpcStream{r: r, w: w, opened: time.Now(), done: make(chan struct{})}
+
+	req, _ := http.NewRequest(http.MethodPost, o.url, r)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "go-audit")
+	for k, v := range o.metadata {
+		req.Header.Set(k, v)
+	}
+
+	go func() {
+		defer close(s.done)
+		s.received, s.err = grpcCall(o.client, req)
+		// Unblocks a write that is waiting on a call that is over
+		r.CloseWithError(fmt.Errorf("The collector ended the stream. Error: %v", s.err))
+	}()
+
+	return s
+}
+
+// Ends the current stream and checks that the collector received everything. Must be called with mu held
+func (o *grpcOutput) finish() error {
+	s := o.stream
+	if s == nil {
+		return nil
+	}
+
+	o.stream = nil
+	s.w.Close()
+
+	select {
+	case <-s.done:
+	case <-time.After(o.timeout):
+		s.w.CloseWithError(errors.New("Timed out"))
+		return fmt.Errorf("The collector did not end the stream within %s, %d events may be lost", o.timeout, s.sent)
+	}
+
+	if s.err != nil {
+		return fmt.Errorf("%s, %d events may be lost", s.err, s.sent)
+	}
+
+	if s.received < s.sent {
+		return fmt.Errorf("The collector received %d of the %d events sent", s.received, s.sent)
+	}
+
+	return nil
+}
+
+// Writes a message, giving up after timeout if the collector is not taking it
+func (s *grpcStream) write(msg []byte, timeout time.Duration) error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+
+	t := time.AfterFunc(timeout, func() {
+		s.r.CloseWithError(fmt.Errorf("The collector did not take the event within %s", timeout))
+	})
+	defer t.Stop()
+
+	if _, err := s.w.Write(msg); err != nil {
+		return fmt.Errorf("Failed to stream to the collector. Error: %s", err)
+	}
+
+	s.sent++
+	return nil
+}
+
+// Turns a length prefixed protobuf event into a grpc message: not compressed, big endian length, the message
+func grpcFrame(p []byte) ([]byte, error) {
+	size, n := binary.Uvarint(p)
+	if n <= 0 || uint64(len(p)-n) != size {
+		return nil, errors.New("The event is not a length prefixed protobuf message")
+	}
+
+	msg := make([]byte, 5+size)
+	binary.BigEndian.PutUint32(msg[1:], uint32(size))
+	copy(msg[5:], p[n:])
+	return msg, nil
+}
+
+// Makes the call and reads the StreamSummary, the grpc status decides if it worked
+func grpcCall(client *http.Client, req *http.Request) (uint64, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("Unexpected response from the collector. Status: %s; Body: %s", resp.Status, msg)
+	}
+
+	var received uint64
+	r := bufio.NewReader(resp.Body)
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		b := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+
+		if header[0] == 0 {
+			received = protoUint(b, 1)
+		}
+	}
+
+	// A call that fails right away has the status in the headers instead of the trailers
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+
+	if status != "0" {
+		// grpc-message is percent encoded
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		return 0, fmt.Errorf("The collector returned grpc status %s. Error: %s", status, message)
+	}
+
+	return received, nil
+}
@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// A collector that records the events of every stream, answering with how many it received minus short
+type testCollector struct {
+	*httptest.Server
+	short  uint64
+	status string // Returned without reading anything if set
+
+	mu      sync.Mutex
+	streams [][]string
+	headers http.Header
+}
+
+func newTestCollector(t *testing.T, short uint64, status string) *testCollector {
+	c := &testCollector{short: short, status: status}
+	c.Server = httptest.NewUnstartedServer(http.HandlerFunc(c.serve))
+	c.Config.Protocols = &http.Protocols{}
+	c.Config.Protocols.SetUnencryptedHTTP2(true)
+	c.Start()
+	return c
+}
+
+func (c *testCollector) serve(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.headers = r.Header
+	c.mu.Unlock()
+
+	if r.ProtoMajor != 2 || r.URL.Path != grpcStreamMethod || r.Header.Get("Content-Type") != "application/grpc+proto" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var events []string
+	br := bufio.NewReader(r.Body)
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			break
+		}
+
+		b := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(br, b); err != nil {
+			break
+		}
+
+		events = append(events, string(b))
+	}
+
+	c.mu.Lock()
+	c.streams = append(c.streams, events)
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/grpc")
+	if c.status != "" {
+		// Trailers only, the status is in the headers
+		w.Header().Set("Grpc-Status", c.status)
+		w.Header().Set("Grpc-Message", "bad%20token")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	summary := &protoBuffer{}
+	summary.uint(1, uint64(len(events))-c.short)
+	msg := make([]byte, 5+len(summary.b))
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(summary.b)))
+	copy(msg[5:], summary.b)
+	w.Write(msg)
+
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func (c *testCollector) getStreams() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]string{}, c.streams...)
+}
+
+func testGRPCConfig(address string) *viper.Viper {
+	c := viper.New()
+	c.Set("output.grpc.format", "protobuf")
+	c.Set("output.grpc.address", address)
+	c.Set("output.grpc.timeout", "1s")
+	c.Set("output.grpc.stream.max_events", 2)
+	c.Set("output.grpc.stream.max_age", "1m")
+	return c
+}
+
+// A length prefixed event like the protobuf format makes
+func testGRPCEvent(s string) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(s))), s...)
+}
+
+func Test_createGRPCOutput(t *testing.T) {
+	c := testGRPCConfig("127.0.0.1")
+
+	c.Set("output.grpc.format", "json")
+	_, err := createGRPCOutput(c)
+	assert.EqualError(t, err, "Output grpc requires the protobuf output format, `json` is configured")
+	c.Set("output.grpc.format", "protobuf")
+
+	_, err = createGRPCOutput(c)
+	assert.EqualError(t, err, "output.grpc.address could not be parsed; Value: `127.0.0.1`")
+	c.Set("output.grpc.address", "127.0.0.1:50051")
+
+	c.Set("output.grpc.timeout", "0s")
+	_, err = createGRPCOutput(c)
+	assert.EqualError(t, err, "output.grpc.timeout must be greater than 0, 0s provided")
+	c.Set("output.grpc.timeout", "1s")
+
+	c.Set("output.grpc.stream.max_events", 0)
+	_, err = createGRPCOutput(c)
+	assert.EqualError(t, err, "output.grpc.stream.max_events must be greater than 0, 0 provided")
+	c.Set("output.grpc.stream.max_events", 2)
+
+	c.Set("output.grpc.stream.max_age", "0s")
+	_, err = createGRPCOutput(c)
+	assert.EqualError(t, err, "output.grpc.stream.max_age must be greater than 0, 0s provided")
+	c.Set("output.grpc.stream.max_age", "1m")
+
+	o, err := createGRPCOutput(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://127.0.0.1:50051/goaudit.v1.Collector/Stream", o.(*grpcOutput).url)
+
+	c.Set("output.grpc.tls.enabled", true)
+	o, err = createGRPCOutput(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://127.0.0.1:50051/goaudit.v1.Collector/Stream", o.(*grpcOutput).url)
+
+	c.Set("output.grpc.tls.ca_file", "/tmp/go-audit-does-not-exist")
+	_, err = createGRPCOutput(c)
+	assert.EqualError(t, err, "Failed to read output.grpc.tls.ca_file. Error: open /tmp/go-audit-does-not-exist: no such file or directory")
+}
+
+func TestGRPCOutput_stream(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	s := newTestCollector(t, 0, "")
+	defer s.Close()
+
+	c := testGRPCConfig(strings.TrimPrefix(s.URL, "http://"))
+	c.Set("output.grpc.metadata", map[string]string{"authorization": "Bearer secret"})
+
+	o, err := createGRPCOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+
+	// The stream ends after max_events, the last event is on a stream that is ended by the flush
+	for _, e := range []string{"one", "two", "three"} {
+		n, err := o.Write(testGRPCEvent(e))
+		assert.Nil(t, err)
+		assert.Equal(t, len(e)+1, n)
+	}
+
+	assert.Equal(t, [][]string{{"one", "two"}}, s.getStreams())
+	assert.Nil(t, o.Flush())
+	assert.Equal(t, [][]string{{"one", "two"}, {"three"}}, s.getStreams())
+	assert.True(t, o.Healthy())
+
+	s.mu.Lock()
+	assert.Equal(t, "Bearer secret", s.headers.Get("Authorization"))
+	assert.Equal(t, "trailers", s.headers.Get("Te"))
+	s.mu.Unlock()
+
+	// Nothing to flush
+	assert.Nil(t, o.Flush())
+
+	_, err = o.Write([]byte("not protobuf"))
+	assert.EqualError(t, err, "The event is not a length prefixed protobuf message")
+
+	assert.Nil(t, o.Close())
+	assert.Equal(t, "", elb.String())
+}
+
+func TestGRPCOutput_lost(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	s := newTestCollector(t, 1, "")
+	defer s.Close()
+
+	o, err := createGRPCOutput(testGRPCConfig(strings.TrimPrefix(s.URL, "http://")))
+	assert.Nil(t, err)
+
+	_, err = o.Write(testGRPCEvent("one"))
+	assert.Nil(t, err)
+	_, err = o.Write(testGRPCEvent("two"))
+	assert.Nil(t, err)
+	assert.Contains(t, elb.String(), "Output grpc stream failed. Error: The collector received 1 of the 2 events sent\n")
+
+	_, err = o.Write(testGRPCEvent("three"))
+	assert.Nil(t, err)
+	assert.EqualError(t, o.Close(), "The collector received 0 of the 1 events sent")
+}
+
+func TestGRPCOutput_status(t *testing.T) {
+	s := newTestCollector(t, 0, "16")
+	defer s.Close()
+
+	o, err := createGRPCOutput(testGRPCConfig(strings.TrimPrefix(s.URL, "http://")))
+	assert.Nil(t, err)
+
+	_, err = o.Write(testGRPCEvent("one"))
+	assert.Nil(t, err)
+	assert.EqualError(t, o.Flush(), "The collector returned grpc status 16. Error: bad token, 1 events may be lost")
+}
+
+func TestGRPCOutput_timeout(t *testing.T) {
+	// A collector that never reads, once the flow control window is used up writes block
+	block := make(chan struct{})
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		ioutil.ReadAll(r.Body)
+	}))
+	s.Config.Protocols = &http.Protocols{}
+	s.Config.Protocols.SetUnencryptedHTTP2(true)
+	s.Start()
+	defer s.Close()
+	defer close(block)
+
+	c := testGRPCConfig(strings.TrimPrefix(s.URL, "http://"))
+	c.Set("output.grpc.timeout", "200ms")
+
+	o, err := createGRPCOutput(c)
+	assert.Nil(t, err)
+
+	_, err = o.Write(testGRPCEvent(strings.Repeat("x", 4*1024*1024)))
+	assert.EqualError(t, err, "Failed to stream to the collector. Error: The collector did not take the event within 200ms")
+	assert.False(t, o.Healthy())
+}
@@ -0,0 +1,279 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("http", createHTTPOutput)
+}
+
+// httpOutput batches events and POSTs them as newline delimited events, like HEC style collectors take them
+// A batch is tried a few times with exponential backoff, batches that still fail go to the spool if there is one
+type httpOutput struct {
+	*batchOutput
+	url        string
+	headers    map[string]string
+	gzip       bool
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	client     *http.Client
+	spool      *httpSpool
+	sleep      func(time.Duration) // Replaced in tests
+}
+
+func createHTTPOutput(config *viper.Viper) (Output, error) {
+	u, err := url.Parse(config.GetString("output.http.url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("output.http.url could not be parsed; Value: `%s`", config.GetString("output.http.url"))
+	}
+
+	batchSize := config.GetInt("output.http.batch_size")
+	if batchSize < 1 {
+		return nil, fmt.Errorf("output.http.batch_size must be greater than 0, %v provided", batchSize)
+	}
+
+	interval := config.GetDuration("output.http.flush_interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("output.http.flush_interval must be greater than 0, %v provided", interval)
+	}
+
+	attempts := config.GetInt("output.http.retry.attempts")
+	if attempts < 1 {
+		return nil, fmt.Errorf("output.http.retry.attempts must be at least 1, %v provided", attempts)
+	}
+
+	tlsConfig, err := createTLSConfig(config, "output.http.tls")
+	if err != nil {
+		return nil, err
+	}
+
+	spool, err := createHTTPSpool(config)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &httpOutput{
+		url:        u.String(),
+		headers:    config.GetStringMapString("output.http.headers"),
+		gzip:       config.GetBool("output.http.gzip"),
+		attempts:   attempts,
+		backoff:    config.GetDuration("output.http.retry.backoff"),
+		maxBackoff: config.GetDuration("output.http.retry.max_backoff"),
+		client: &http.Client{
+			Timeout:   config.GetDuration("output.http.timeout"),
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		spool: spool,
+		sleep: time.Sleep,
+	}
+
+	o.batchOutput = newBatchOutput(batchSize, interval, o.send)
+	return o, nil
+}
+
+// Sends a batch, spooling it if every attempt fails. Spooled batches are sent again after the next success
+func (o *httpOutput) send(rows [][]byte) error {
+	body := &bytes.Buffer{}
+	for _, row := range rows {
+		body.Write(bytes.TrimRight(row, "\n"))
+		body.WriteByte('\n')
+	}
+
+	if err := o.retry(body.Bytes()); err != nil {
+		if o.spool == nil {
+			return err
+		}
+
+		if serr := o.spool.add(body.Bytes()); serr != nil {
+			return fmt.Errorf("%s, the spool could not be written either. Error: %s", err, serr)
+		}
+
+		el.Printf("Spooled %d events. Error: %s\n", len(rows), err)
+		return nil
+	}
+
+	if o.spool != nil {
+		o.spool.drain(o.post)
+	}
+
+	return nil
+}
+
+// Posts the body up to attempts times, doubling the wait between attempts up to maxBackoff
+func (o *httpOutput) retry(body []byte) (err error) {
+	wait := o.backoff
+	for i := 0; i < o.attempts; i++ {
+		if err = o.post(body); err == nil {
+			return nil
+		}
+
+		if i != o.attempts-1 {
+			el.Printf("Failed to post events, retrying in %s. Error: %s\n", wait, err)
+			o.sleep(wait)
+
+			if wait *= 2; o.maxBackoff > 0 && wait > o.maxBackoff {
+				wait = o.maxBackoff
+			}
+		}
+	}
+
+	return err
+}
+
+func (o *httpOutput) post(body []byte) error {
+	var r io.Reader = bytes.NewReader(body)
+	if o.gzip {
+		b := &bytes.Buffer{}
+		gz := gzip.NewWriter(b)
+		gz.Write(body)
+		gz.Close()
+		r = b
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.url, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if o.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to post events. Error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Failed to post events. Status: %s; Error: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// httpSpool keeps batches that could not be posted as files in a directory, oldest first
+type httpSpool struct {
+	dir     string
+	maxSize int64
+
+	mu  sync.Mutex
+	seq int
+}
+
+// Creates the spool, returns nil if `output.http.spool.dir` is not set
+func createHTTPSpool(config *viper.Viper) (*httpSpool, error) {
+	dir := config.GetString("output.http.spool.dir")
+	if dir == "" {
+		return nil, nil
+	}
+
+	maxSize := int64(config.GetInt("output.http.spool.max_size"))
+	if maxSize < 1 {
+		return nil, fmt.Errorf("output.http.spool.max_size must be greater than 0, %v provided", maxSize)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create output.http.spool.dir. Error: %s", err)
+	}
+
+	return &httpSpool{dir: dir, maxSize: maxSize}, nil
+}
+
+// Spooled batches in the order they were added
+func (s *httpSpool) files() ([]string, int64, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.ndjson"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Strings(files)
+
+	var size int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return files, size, nil
+}
+
+// Adds a batch, refuses to grow the spool past maxSize
+func (s *httpSpool) add(body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, size, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	if size+int64(len(body)) > s.maxSize {
+		return errors.New("Spool is full")
+	}
+
+	// Names sort in the order batches were added, even within the same nanosecond
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d.ndjson", time.Now().UnixNano(), s.seq%1000000))
+	if err := ioutil.WriteFile(name+".tmp", body, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// Posts spooled batches in order, stopping at the first failure
+func (s *httpSpool) drain(post func([]byte) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, _, err := s.files()
+	if err != nil || len(files) == 0 {
+		return
+	}
+
+	sent := 0
+	for _, f := range files {
+		body, err := ioutil.ReadFile(f)
+		if err != nil {
+			el.Printf("Failed to read spooled events. Error: %s\n", err)
+			break
+		}
+
+		if err := post(body); err != nil {
+			el.Printf("Failed to send spooled events, %d batches are left. Error: %s\n", len(files)-sent, err)
+			break
+		}
+
+		os.Remove(f)
+		sent++
+	}
+
+	if sent > 0 {
+		l.Printf("Sent %d spooled batches\n", sent)
+	}
+}
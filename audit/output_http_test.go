@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createHTTPOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.http.url", "https://collector.example.com/ingest")
+	c.Set("output.http.batch_size", 10)
+	c.Set("output.http.flush_interval", "5s")
+	c.Set("output.http.retry.attempts", 3)
+	c.Set("output.http.headers", map[string]string{"Authorization": "Splunk token"})
+
+	c.Set("output.http.url", "collector.example.com")
+	_, err := createHTTPOutput(c)
+	assert.EqualError(t, err, "output.http.url could not be parsed; Value: `collector.example.com`")
+	c.Set("output.http.url", "https://collector.example.com/ingest")
+
+	c.Set("output.http.batch_size", 0)
+	_, err = createHTTPOutput(c)
+	assert.EqualError(t, err, "output.http.batch_size must be greater than 0, 0 provided")
+	c.Set("output.http.batch_size", 10)
+
+	c.Set("output.http.retry.attempts", 0)
+	_, err = createHTTPOutput(c)
+	assert.EqualError(t, err, "output.http.retry.attempts must be at least 1, 0 provided")
+	c.Set("output.http.retry.attempts", 3)
+
+	c.Set("output.http.tls.cert_file", "/etc/go-audit/client.pem")
+	_, err = createHTTPOutput(c)
+	assert.EqualError(t, err, "output.http.tls.cert_file and output.http.tls.key_file must be set together")
+	c.Set("output.http.tls.cert_file", "")
+
+	o, err := createHTTPOutput(c)
+	assert.Nil(t, err)
+	ho := o.(*httpOutput)
+	assert.Equal(t, "https://collector.example.com/ingest", ho.url)
+	assert.Equal(t, map[string]string{"Authorization": "Splunk token"}, ho.headers)
+	assert.Nil(t, ho.spool)
+	assert.Equal(t, 10, ho.size)
+}
+
+func TestHTTPOutput(t *testing.T) {
+	var bodies []string
+	var auth []string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			assert.Nil(t, err)
+			body = gz
+		}
+
+		b, _ := ioutil.ReadAll(body)
+		if status == http.StatusOK {
+			bodies = append(bodies, string(b))
+			auth = append(auth, r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := viper.New()
+	c.Set("output.http.url", ts.URL)
+	c.Set("output.http.batch_size", 2)
+	c.Set("output.http.flush_interval", "1h")
+	c.Set("output.http.retry.attempts", 3)
+	c.Set("output.http.retry.backoff", "1s")
+	c.Set("output.http.retry.max_backoff", "1500ms")
+	c.Set("output.http.gzip", true)
+	c.Set("output.http.headers", map[string]string{"Authorization": "Splunk token"})
+	o, err := createHTTPOutput(c)
+	assert.Nil(t, err)
+
+	ho := o.(*httpOutput)
+	var waits []time.Duration
+	ho.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	o.Write([]byte("{\"sequence\":1}\n"))
+	o.Write([]byte("{\"sequence\":2}\n"))
+	assert.Empty(t, bodies)
+
+	// A full batch is sent before the next event is added
+	o.Write([]byte("{\"sequence\":3}\n"))
+	assert.Equal(t, []string{"{\"sequence\":1}\n{\"sequence\":2}\n"}, bodies)
+	assert.Equal(t, []string{"Splunk token"}, auth)
+
+	// Failures are retried with backoff, then refused
+	status = http.StatusServiceUnavailable
+	assert.Contains(t, o.Flush().Error(), "Failed to post events. Status: 503 Service Unavailable")
+	assert.Equal(t, []time.Duration{time.Second, time.Millisecond * 1500}, waits)
+	assert.False(t, o.Healthy())
+	assert.Contains(t, elb.String(), "Failed to post events, retrying in 1s.")
+
+	// With a spool failed batches are kept and sent after the next success
+	ho.spool = &httpSpool{dir: dir, maxSize: 1024}
+	assert.Nil(t, o.Flush())
+	assert.Contains(t, elb.String(), "Spooled 1 events.")
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	assert.Len(t, files, 1)
+
+	status = http.StatusOK
+	o.Write([]byte("{\"sequence\":4}\n"))
+	assert.Nil(t, o.Flush())
+	assert.Equal(t, []string{"{\"sequence\":1}\n{\"sequence\":2}\n", "{\"sequence\":4}\n", "{\"sequence\":3}\n"}, bodies)
+	files, _ = filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	assert.Empty(t, files)
+
+	// A full spool refuses the batch
+	status = http.StatusServiceUnavailable
+	ho.spool.maxSize = 5
+	o.Write([]byte("{\"sequence\":5}\n"))
+	assert.Contains(t, o.Flush().Error(), "the spool could not be written either. Error: Spool is full")
+}
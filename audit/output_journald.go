@@ -0,0 +1,256 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("journald", createJournaldOutput)
+}
+
+// Journal fields for the flattened SYSCALL and CWD columns, sequence and the full event are handled on their own
+var journaldFlatFields = map[string]string{
+	"syscall": "SYSCALL", "success": "SUCCESS", "exit": "EXIT", "pid": "PID", "ppid": "PPID",
+	"uid": "UID", "auid": "AUID", "comm": "COMM", "exe": "EXE", "key": "KEY",
+	"username": "USERNAME", "ausername": "AUSERNAME", "cwd": "CWD",
+}
+
+// journaldOutput sends events to systemd-journald over its native protocol
+// MESSAGE is the full json event, common fields are added as their own journal fields so journalctl can filter on them
+type journaldOutput struct {
+	socket     string
+	identifier string
+	priority   int
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+	err  error
+}
+
+func createJournaldOutput(config *viper.Viper) (Output, error) {
+	// Journal fields come from the json form of the event
+	if f := outputFormat(config, "journald"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output journald requires the json output format, `%s` is configured", f)
+	}
+
+	priority := config.GetInt("output.journald.priority")
+	if priority < 0 || priority > 7 {
+		return nil, fmt.Errorf("output.journald.priority must be between 0 and 7, %v provided", priority)
+	}
+
+	return &journaldOutput{
+		socket:     config.GetString("output.journald.socket"),
+		identifier: config.GetString("output.journald.identifier"),
+		priority:   priority,
+	}, nil
+}
+
+// Open (re)connects to the journal socket
+func (o *journaldOutput) Open() error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: o.socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("Failed to connect to journald. Error: %s", err)
+	}
+
+	o.mu.Lock()
+	old := o.conn
+	o.conn, o.err = conn, nil
+	o.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+
+	return nil
+}
+
+func (o *journaldOutput) Write(p []byte) (int, error) {
+	entry, err := o.entry(p)
+	if err != nil {
+		// Retrying won't help, still hand the event over as it is
+		entry = o.fields(map[string][]string{"MESSAGE": {string(bytes.TrimRight(p, "\n"))}})
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		return 0, fmt.Errorf("Failed to write to journald. Error: not connected")
+	}
+
+	_, o.err = o.conn.Write(entry)
+	if o.err != nil && isMessageTooLarge(o.err) {
+		// Entries bigger than a datagram are handed over as a file descriptor, like sd_journal_send does
+		o.err = o.sendFd(entry)
+	}
+
+	if o.err != nil {
+		return 0, fmt.Errorf("Failed to write to journald. Error: %s", o.err)
+	}
+
+	return len(p), nil
+}
+
+func (o *journaldOutput) Flush() error {
+	return nil
+}
+
+func (o *journaldOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+// Healthy is true as long as the last write succeeded
+func (o *journaldOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.conn != nil && o.err == nil
+}
+
+// Builds the journal entry for a json event
+func (o *journaldOutput) entry(p []byte) ([]byte, error) {
+	e := &flatEvent{}
+	if err := json.Unmarshal(p, e); err != nil {
+		return nil, err
+	}
+
+	values, err := flattenEvent(p)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string][]string{
+		"MESSAGE":   {values[len(values)-1].(string)},
+		"AUDIT_SEQ": {strconv.Itoa(e.Seq)},
+	}
+
+	for i, c := range flatColumns {
+		if name, ok := journaldFlatFields[c]; ok && values[i] != nil {
+			fields[name] = []string{values[i].(string)}
+		}
+	}
+
+	// Fields can repeat, journalctl AUDIT_TYPE=1309 finds every event with an EXECVE record
+	for _, m := range e.Msgs {
+		fields["AUDIT_TYPE"] = append(fields["AUDIT_TYPE"], strconv.Itoa(int(m.Type)))
+	}
+
+	for uid, name := range e.UidMap {
+		if k := "UID_MAP_" + uid; journaldFieldName(k) {
+			fields[k] = []string{name}
+		}
+	}
+
+	return o.fields(fields), nil
+}
+
+// Serializes fields in the native protocol, the identifier and priority are always added
+func (o *journaldOutput) fields(fields map[string][]string) []byte {
+	fields["SYSLOG_IDENTIFIER"] = []string{o.identifier}
+	fields["PRIORITY"] = []string{strconv.Itoa(o.priority)}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := &bytes.Buffer{}
+	for _, k := range keys {
+		for _, v := range fields[k] {
+			if !strings.Contains(v, "\n") {
+				fmt.Fprintf(b, "%s=%s\n", k, v)
+				continue
+			}
+
+			// Values with newlines are length prefixed
+			b.WriteString(k)
+			b.WriteByte('\n')
+			binary.Write(b, binary.LittleEndian, uint64(len(v)))
+			b.WriteString(v)
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.Bytes()
+}
+
+// Writes the entry to an unlinked file in /dev/shm and passes the descriptor, must be called with mu held
+func (o *journaldOutput) sendFd(entry []byte) error {
+	f, err := ioutil.TempFile("/dev/shm", "go-audit-journal-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+
+	if _, err := f.Write(entry); err != nil {
+		return err
+	}
+
+	// WriteMsgUnix refuses connected datagram sockets
+	raw, err := o.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	err = raw.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, syscall.UnixRights(int(f.Fd())), nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+// Journal field names are uppercase letters, digits and underscores, not starting with an underscore or digit
+func journaldFieldName(k string) bool {
+	if k == "" || len(k) > 64 || k[0] == '_' || (k[0] >= '0' && k[0] <= '9') {
+		return false
+	}
+
+	for _, c := range k {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isMessageTooLarge(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+
+	return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
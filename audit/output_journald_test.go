@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createJournaldOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.journald.socket", "/run/systemd/journal/socket")
+	c.Set("output.journald.identifier", "go-audit")
+	c.Set("output.journald.priority", 6)
+
+	c.Set("output.format", "msgpack")
+	_, err := createJournaldOutput(c)
+	assert.EqualError(t, err, "Output journald requires the json output format, `msgpack` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.journald.priority", 8)
+	_, err = createJournaldOutput(c)
+	assert.EqualError(t, err, "output.journald.priority must be between 0 and 7, 8 provided")
+	c.Set("output.journald.priority", 6)
+
+	c.Set("output.journald.socket", "/tmp/go-audit-does-not-exist")
+	o, err := createJournaldOutput(c)
+	assert.Nil(t, err)
+	assert.Contains(t, o.Open().Error(), "Failed to connect to journald. Error: ")
+	_, err = o.Write([]byte("{}"))
+	assert.EqualError(t, err, "Failed to write to journald. Error: not connected")
+	assert.False(t, o.Healthy())
+}
+
+func TestJournaldOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "socket")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	c := viper.New()
+	c.Set("output.journald.socket", socket)
+	c.Set("output.journald.identifier", "go-audit")
+	c.Set("output.journald.priority", 5)
+	o, err := createJournaldOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+	defer o.Close()
+
+	event := `{"sequence":1,"timestamp_ms":1495756799999,"messages":[` +
+		`{"type":1300,"data":"arch=c000003e syscall=59 success=yes exit=0 pid=10 ppid=9 uid=1000 auid=1000 comm=\"sh\" exe=\"/bin/sh\" key=(null)"},` +
+		`{"type":1309,"data":"argc=1 a0=\"sh\""},{"type":1307,"data":"cwd=\"/root\""}],"uid_map":{"1000":"alice"}}` + "\n"
+	_, err = o.Write([]byte(event))
+	assert.Nil(t, err)
+	assert.True(t, o.Healthy())
+
+	b := make([]byte, 64*1024)
+	n, err := ln.Read(b)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"AUDIT_SEQ=1",
+		"AUDIT_TYPE=1300",
+		"AUDIT_TYPE=1309",
+		"AUDIT_TYPE=1307",
+		"AUID=1000",
+		"AUSERNAME=alice",
+		"COMM=sh",
+		"CWD=/root",
+		"EXE=/bin/sh",
+		"EXIT=0",
+		"KEY=(null)",
+		"MESSAGE=" + strings.TrimRight(event, "\n"),
+		"PID=10",
+		"PPID=9",
+		"PRIORITY=5",
+		"SUCCESS=yes",
+		"SYSCALL=59",
+		"SYSLOG_IDENTIFIER=go-audit",
+		"UID=1000",
+		"UID_MAP_1000=alice",
+		"USERNAME=alice",
+	}, "\n")+"\n", string(b[:n]))
+
+	// Values with newlines are length prefixed
+	jo := o.(*journaldOutput)
+	entry := jo.fields(map[string][]string{"MESSAGE": {"a\nb"}})
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, 3)
+	assert.Equal(t, "MESSAGE\n"+string(size)+"a\nb\nPRIORITY=5\nSYSLOG_IDENTIFIER=go-audit\n", string(entry))
+
+	// Entries too big for a datagram are passed as a file descriptor
+	big := `{"sequence":2,"messages":[{"type":1309,"data":"a0=\"` + strings.Repeat("x", 1024*1024) + `\""}]}`
+	_, err = o.Write([]byte(big))
+	assert.Nil(t, err)
+
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := ln.ReadMsgUnix(b, oob)
+	assert.Nil(t, err)
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	assert.Nil(t, err)
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	assert.Nil(t, err)
+
+	f := os.NewFile(uintptr(fds[0]), "journal")
+	defer f.Close()
+	f.Seek(0, 0)
+	contents, _ := ioutil.ReadAll(f)
+	assert.True(t, bytes.Contains(contents, []byte("AUDIT_SEQ=2\nAUDIT_TYPE=1309\nMESSAGE="+big+"\n")))
+}
@@ -0,0 +1,243 @@
+package audit
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("nats", createNATSOutput)
+}
+
+// natsOutput publishes every event to a nats subject. With jetstream enabled a write only succeeds once the
+// stream has stored the event, otherwise once the server has taken it
+// The connection is made on the first write and again after any failure
+type natsOutput struct {
+	address    string
+	subject    string
+	tlsConfig  *tls.Config // nil unless tls is enabled or the url is tls://, the server can still require it
+	auth       *natsAuth
+	timeout    time.Duration
+	jetstream  bool
+	ackTimeout time.Duration
+
+	mu   sync.Mutex
+	conn *natsConn
+	err  error // Last connect or publish error, nil once an event was published
+}
+
+// The JetStream publish ack, or the reason the stream refused the event
+type natsPubAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+func createNATSOutput(config *viper.Viper) (Output, error) {
+	u, err := url.Parse(config.GetString("output.nats.url"))
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+		return nil, fmt.Errorf("output.nats.url could not be parsed; Value: `%s`", config.GetString("output.nats.url"))
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	subject := config.GetString("output.nats.subject")
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n*>") {
+		return nil, fmt.Errorf("output.nats.subject could not be parsed; Value: `%s`", subject)
+	}
+
+	auth, err := natsOutputAuth(config)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &natsOutput{
+		address:    address,
+		subject:    subject,
+		auth:       auth,
+		timeout:    config.GetDuration("output.nats.timeout"),
+		jetstream:  config.GetBool("output.nats.jetstream.enabled"),
+		ackTimeout: config.GetDuration("output.nats.jetstream.ack_timeout"),
+	}
+
+	if o.timeout <= 0 {
+		return nil, fmt.Errorf("output.nats.timeout must be greater than 0, %v provided", o.timeout)
+	}
+
+	if o.jetstream && o.ackTimeout <= 0 {
+		return nil, fmt.Errorf("output.nats.jetstream.ack_timeout must be greater than 0, %v provided", o.ackTimeout)
+	}
+
+	if u.Scheme == "tls" || config.GetBool("output.nats.tls.enabled") {
+		if o.tlsConfig, err = createTLSConfig(config, "output.nats.tls"); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// Reads the one auth method that is configured
+func natsOutputAuth(config *viper.Viper) (*natsAuth, error) {
+	auth := &natsAuth{
+		user:     config.GetString("output.nats.user"),
+		password: config.GetString("output.nats.password"),
+		token:    config.GetString("output.nats.token"),
+	}
+
+	seedFile := config.GetString("output.nats.nkey_seed_file")
+	credsFile := config.GetString("output.nats.credentials_file")
+
+	set := 0
+	for _, v := range []string{auth.user, auth.token, seedFile, credsFile} {
+		if v != "" {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return nil, errors.New("Only one of output.nats.user, output.nats.token, output.nats.nkey_seed_file or output.nats.credentials_file can be set")
+	}
+
+	if seedFile != "" {
+		b, err := ioutil.ReadFile(seedFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read output.nats.nkey_seed_file. Error: %s", err)
+		}
+
+		auth.seed = strings.TrimSpace(string(b))
+		if _, _, err := natsDecodeSeed(auth.seed); err != nil {
+			return nil, fmt.Errorf("Failed to parse output.nats.nkey_seed_file. Error: %s", err)
+		}
+	}
+
+	if credsFile != "" {
+		b, err := ioutil.ReadFile(credsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read output.nats.credentials_file. Error: %s", err)
+		}
+
+		if auth.jwt, auth.seed, err = parseNatsCredentials(b); err != nil {
+			return nil, fmt.Errorf("Failed to parse output.nats.credentials_file. Error: %s", err)
+		}
+
+		if _, _, err := natsDecodeSeed(auth.seed); err != nil {
+			return nil, fmt.Errorf("Failed to parse output.nats.credentials_file. Error: %s", err)
+		}
+	}
+
+	return auth, nil
+}
+
+// Open does nothing, the connection is made by the first write so a server that is down does not stop go-audit starting
+func (o *natsOutput) Open() error {
+	return nil
+}
+
+// Write publishes the event, a failed publish drops the connection so the retry starts with a fresh one
+func (o *natsOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.publish(p); err != nil {
+		if o.conn != nil {
+			o.conn.close()
+			o.conn = nil
+		}
+
+		o.err = err
+		return 0, err
+	}
+
+	o.err = nil
+	return len(p), nil
+}
+
+// Must be called with mu held
+func (o *natsOutput) publish(p []byte) error {
+	if o.conn == nil {
+		c, err := dialNats(o.address, o.tlsConfig, o.auth, o.timeout)
+		if err != nil {
+			return fmt.Errorf("Failed to connect to nats at %s. Error: %s", o.address, err)
+		}
+
+		o.conn = c
+	}
+
+	if !o.jetstream {
+		if err := o.conn.publish(o.subject, "", p); err != nil {
+			return fmt.Errorf("Failed to publish to %s. Error: %s", o.subject, err)
+		}
+
+		return nil
+	}
+
+	reply, err := o.conn.request(o.subject, p, o.ackTimeout)
+	if err != nil {
+		return fmt.Errorf("Failed to publish to %s. Error: %s", o.subject, err)
+	}
+
+	ack := natsPubAck{}
+	if err := json.Unmarshal(reply, &ack); err != nil {
+		return fmt.Errorf("Failed to parse the JetStream ack for %s; Value: `%s`", o.subject, reply)
+	}
+
+	if ack.Error != nil {
+		return fmt.Errorf("JetStream refused the event for %s. Error: %s (%d)", o.subject, ack.Error.Description, ack.Error.Code)
+	}
+
+	if ack.Stream == "" {
+		return fmt.Errorf("No JetStream stream is listening on %s", o.subject)
+	}
+
+	return nil
+}
+
+// Flush waits for the server to have processed every published event
+func (o *natsOutput) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+
+	return o.conn.flush(o.timeout)
+}
+
+func (o *natsOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+
+	err := o.conn.flush(o.timeout)
+	o.conn.close()
+	o.conn = nil
+	return err
+}
+
+// Healthy is true as long as the last publish succeeded
+func (o *natsOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil
+}
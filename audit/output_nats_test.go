@@ -0,0 +1,284 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// A nats server that accepts one client at a time, records what is published and acks it like JetStream would
+type testNatsServer struct {
+	ln    net.Listener
+	nonce string
+	ack   string // Sent back to publishes with a reply subject, if not empty
+
+	mu        sync.Mutex
+	connects  []map[string]interface{}
+	published []string
+	conns     []net.Conn
+}
+
+func newTestNatsServer(t *testing.T) *testNatsServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &testNatsServer{ln: ln, nonce: "abc123", ack: `{"stream":"GO_AUDIT","seq":1}`}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testNatsServer) url() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *testNatsServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1024,\"nonce\":%q}\r\n", s.nonce)
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CONNECT":
+			opts := map[string]interface{}{}
+			json.Unmarshal([]byte(line[8:]), &opts)
+			s.mu.Lock()
+			s.connects = append(s.connects, opts)
+			s.mu.Unlock()
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.published = append(s.published, fields[1]+" "+string(data[:size]))
+			ack := s.ack
+			s.mu.Unlock()
+
+			if len(fields) == 4 && ack != "" {
+				fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(ack), ack)
+			}
+		}
+	}
+}
+
+// Drops every connection, like a server restart
+func (s *testNatsServer) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testNatsServer) getPublished() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.published...)
+}
+
+func Test_createNATSOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.nats.subject", "go-audit.events")
+	c.Set("output.nats.timeout", "1s")
+
+	c.Set("output.nats.url", "http://127.0.0.1:4222")
+	_, err := createNATSOutput(c)
+	assert.EqualError(t, err, "output.nats.url could not be parsed; Value: `http://127.0.0.1:4222`")
+
+	// The port defaults to 4222
+	c.Set("output.nats.url", "nats://127.0.0.1")
+	o, err := createNATSOutput(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:4222", o.(*natsOutput).address)
+	assert.Nil(t, o.(*natsOutput).tlsConfig)
+
+	c.Set("output.nats.subject", "go-audit.*")
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "output.nats.subject could not be parsed; Value: `go-audit.*`")
+	c.Set("output.nats.subject", "go-audit.events")
+
+	c.Set("output.nats.timeout", "0s")
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "output.nats.timeout must be greater than 0, 0s provided")
+	c.Set("output.nats.timeout", "1s")
+
+	c.Set("output.nats.jetstream.enabled", true)
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "output.nats.jetstream.ack_timeout must be greater than 0, 0s provided")
+	c.Set("output.nats.jetstream.enabled", false)
+
+	// tls:// turns tls on
+	c.Set("output.nats.url", "tls://127.0.0.1:4443")
+	o, err = createNATSOutput(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, o.(*natsOutput).tlsConfig)
+
+	c.Set("output.nats.user", "go_audit")
+	c.Set("output.nats.token", "secret")
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "Only one of output.nats.user, output.nats.token, output.nats.nkey_seed_file or output.nats.credentials_file can be set")
+	c.Set("output.nats.user", "")
+	c.Set("output.nats.token", "")
+
+	c.Set("output.nats.nkey_seed_file", "/tmp/go-audit-does-not-exist")
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "Failed to read output.nats.nkey_seed_file. Error: open /tmp/go-audit-does-not-exist: no such file or directory")
+
+	f, err := ioutil.TempFile("", "go-audit-nats")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("SUAnotaseed\n")
+	f.Close()
+
+	c.Set("output.nats.nkey_seed_file", f.Name())
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "Failed to parse output.nats.nkey_seed_file. Error: The nkey seed could not be parsed")
+	c.Set("output.nats.nkey_seed_file", "")
+
+	c.Set("output.nats.credentials_file", f.Name())
+	_, err = createNATSOutput(c)
+	assert.EqualError(t, err, "Failed to parse output.nats.credentials_file. Error: The nats credentials file needs a user jwt and nkey seed")
+}
+
+func TestNATSOutput_publish(t *testing.T) {
+	s := newTestNatsServer(t)
+	defer s.ln.Close()
+
+	c := viper.New()
+	c.Set("output.nats.url", s.url())
+	c.Set("output.nats.subject", "go-audit.events")
+	c.Set("output.nats.timeout", "1s")
+	c.Set("output.nats.user", "go_audit")
+	c.Set("output.nats.password", "secret")
+
+	o, err := createNATSOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+
+	n, err := o.Write([]byte(`{"sequence":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 14, n)
+	assert.Nil(t, o.Flush())
+	assert.Equal(t, []string{`go-audit.events {"sequence":1}`}, s.getPublished())
+	assert.True(t, o.Healthy())
+
+	s.mu.Lock()
+	assert.Equal(t, "go_audit", s.connects[0]["user"])
+	assert.Equal(t, "secret", s.connects[0]["pass"])
+	assert.Equal(t, "go-audit", s.connects[0]["name"])
+	s.mu.Unlock()
+
+	// Bigger than the max_payload the server announced
+	_, err = o.Write(make([]byte, 2048))
+	assert.EqualError(t, err, "Failed to publish to go-audit.events. Error: The event is bigger than the max_payload of the nats server, 2048 bytes")
+	assert.False(t, o.Healthy())
+
+	// The write after a dropped connection reconnects
+	s.disconnect()
+	time.Sleep(50 * time.Millisecond)
+	_, err = o.Write([]byte(`{"sequence":2}`))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Close())
+	assert.True(t, o.Healthy())
+
+	assert.Equal(t, []string{`go-audit.events {"sequence":1}`, `go-audit.events {"sequence":2}`}, s.getPublished())
+}
+
+func TestNATSOutput_jetstream(t *testing.T) {
+	s := newTestNatsServer(t)
+	defer s.ln.Close()
+
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 7
+
+	f, err := ioutil.TempFile("", "go-audit-nats")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(testNatsSeed(seed) + "\n")
+	f.Close()
+
+	c := viper.New()
+	c.Set("output.nats.url", s.url())
+	c.Set("output.nats.subject", "go-audit.events")
+	c.Set("output.nats.timeout", "1s")
+	c.Set("output.nats.nkey_seed_file", f.Name())
+	c.Set("output.nats.jetstream.enabled", true)
+	c.Set("output.nats.jetstream.ack_timeout", "200ms")
+
+	o, err := createNATSOutput(c)
+	assert.Nil(t, err)
+
+	_, err = o.Write([]byte(`{"sequence":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`go-audit.events {"sequence":1}`}, s.getPublished())
+
+	// The nonce was signed with the key the seed is for
+	pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	s.mu.Lock()
+	assert.Equal(t, natsEncodeKey(natsPrefixUser, pub), s.connects[0]["nkey"])
+	sig, err := base64.RawURLEncoding.DecodeString(s.connects[0]["sig"].(string))
+	s.mu.Unlock()
+	assert.Nil(t, err)
+	assert.True(t, ed25519.Verify(pub, []byte(s.nonce), sig))
+
+	s.mu.Lock()
+	s.ack = `{"error":{"code":503,"description":"insufficient resources"}}`
+	s.mu.Unlock()
+	_, err = o.Write([]byte(`{"sequence":2}`))
+	assert.EqualError(t, err, "JetStream refused the event for go-audit.events. Error: insufficient resources (503)")
+
+	// No stream answers
+	s.mu.Lock()
+	s.ack = ""
+	s.mu.Unlock()
+	_, err = o.Write([]byte(`{"sequence":3}`))
+	assert.EqualError(t, err, "Failed to publish to go-audit.events. Error: No reply on go-audit.events within 200ms")
+	assert.False(t, o.Healthy())
+
+	assert.Nil(t, o.Close())
+}
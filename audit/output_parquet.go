@@ -0,0 +1,246 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("parquet", createParquetOutput)
+}
+
+// The parquet schema, the flattened event columns with nulls for missing fields
+var parquetColumns = func() []parquetColumn {
+	columns := []parquetColumn{
+		{name: "sequence", typ: parquetInt64, converted: -1},
+		{name: "timestamp_ms", typ: parquetInt64, converted: parquetTimestampMillis},
+	}
+
+	for _, name := range flatColumns[2 : len(flatColumns)-1] {
+		columns = append(columns, parquetColumn{name: name, typ: parquetByteArray, converted: parquetUTF8, optional: true})
+	}
+
+	return append(columns, parquetColumn{name: "event", typ: parquetByteArray, converted: parquetUTF8})
+}()
+
+// parquetOutput writes events to parquet files partitioned by event time, like <dir>/dt=2017-10-06/hour=17/<file>.parquet
+// A file is written once it reaches max_size, the partition changes or roll_interval passes, until then events are kept in memory
+type parquetOutput struct {
+	dir       string
+	maxSize   int
+	interval  time.Duration
+	codec     int32
+	hostname  string
+	partition func(t time.Time) string
+
+	mu      sync.Mutex
+	current *parquetWriter
+	part    string // Partition of the current file
+	err     error  // Last error writing a file, nil if it succeeded
+	stop    chan struct{}
+}
+
+func createParquetOutput(config *viper.Viper) (Output, error) {
+	// Events are flattened from their json form
+	if f := outputFormat(config, "parquet"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output parquet requires the json output format, `%s` is configured", f)
+	}
+
+	dir := config.GetString("output.parquet.dir")
+	if dir == "" {
+		return nil, fmt.Errorf("output.parquet.dir must be set")
+	}
+
+	o := &parquetOutput{
+		dir:      dir,
+		maxSize:  config.GetInt("output.parquet.max_size"),
+		interval: config.GetDuration("output.parquet.roll_interval"),
+	}
+
+	switch p := config.GetString("output.parquet.partition"); p {
+	case "hour":
+		o.partition = func(t time.Time) string { return t.UTC().Format("dt=2006-01-02/hour=15") }
+	case "day":
+		o.partition = func(t time.Time) string { return t.UTC().Format("dt=2006-01-02") }
+	default:
+		return nil, fmt.Errorf("output.parquet.partition must be hour or day; Value: `%s`", p)
+	}
+
+	switch c := config.GetString("output.parquet.compression"); c {
+	case "gzip":
+		o.codec = parquetGzip
+	case "none":
+		o.codec = parquetUncompressed
+	default:
+		return nil, fmt.Errorf("output.parquet.compression must be gzip or none; Value: `%s`", c)
+	}
+
+	if o.maxSize < 1 {
+		return nil, fmt.Errorf("output.parquet.max_size must be greater than 0, %v provided", o.maxSize)
+	}
+
+	if o.interval <= 0 {
+		return nil, fmt.Errorf("output.parquet.roll_interval must be greater than 0, %v provided", o.interval)
+	}
+
+	// Part of the file names so files from many hosts can share a bucket
+	o.hostname, _ = os.Hostname()
+	if o.hostname == "" {
+		o.hostname = "unknown"
+	}
+
+	return o, nil
+}
+
+// Open starts writing files every roll_interval
+func (o *parquetOutput) Open() error {
+	if err := os.MkdirAll(o.dir, 0750); err != nil {
+		return fmt.Errorf("Failed to create output.parquet.dir. Error: %s", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop == nil {
+		o.stop = make(chan struct{})
+		go o.run(o.stop)
+	}
+
+	return nil
+}
+
+func (o *parquetOutput) run(stop chan struct{}) {
+	t := time.NewTicker(o.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		if err := o.Flush(); err != nil {
+			el.Println(err)
+		}
+	}
+}
+
+// Write adds the event to the current file, a full file or one for another partition is written first
+// If that fails the event is refused so the writer can retry it
+func (o *parquetOutput) Write(p []byte) (int, error) {
+	row, err := flattenEvent(p)
+	if err != nil {
+		// Retrying won't fix it
+		el.Printf("Skipping event that could not be flattened for the parquet output. Error: %s\n", err)
+		return len(p), nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	part := o.partition(o.partitionTime(row[1].(int64)))
+	if o.current != nil && (o.part != part || o.current.size >= o.maxSize) {
+		if err := o.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	if o.current == nil {
+		o.current = newParquetWriter(parquetColumns, o.codec)
+		o.part = part
+	}
+
+	if err := o.current.add(row); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Events are partitioned by their own time, events without one by the time they are written
+func (o *parquetOutput) partitionTime(ms int64) time.Time {
+	if ms > 0 {
+		return time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	return time.Now()
+}
+
+// Flush writes the current file, if it has any events
+func (o *parquetOutput) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.roll()
+}
+
+// Writes the current file, must be called with mu held
+// Files are written under a temporary name first so readers never see a partial file
+func (o *parquetOutput) roll() error {
+	if o.current == nil {
+		return nil
+	}
+
+	dir := filepath.Join(o.dir, o.part)
+	name := filepath.Join(dir, fmt.Sprintf("go-audit-%s-%d.parquet", o.hostname, time.Now().UnixNano()))
+	o.err = o.writeFile(dir, name)
+	if o.err != nil {
+		return fmt.Errorf("Failed to write parquet file %s. Error: %s", name, o.err)
+	}
+
+	o.current = nil
+	return nil
+}
+
+func (o *parquetOutput) writeFile(dir, name string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	if err := o.current.writeTo(f); err != nil {
+		f.Close()
+		os.Remove(name + ".tmp")
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// Close writes the current file and stops the roll interval
+func (o *parquetOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+
+	if err := o.roll(); err != nil {
+		return fmt.Errorf("%s, %d events were lost", err, o.current.rows)
+	}
+
+	return nil
+}
+
+// Healthy is true as long as the last file was written
+func (o *parquetOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func newParquetTestConfig(dir string) *viper.Viper {
+	c := viper.New()
+	c.Set("output.parquet.dir", dir)
+	c.Set("output.parquet.partition", "hour")
+	c.Set("output.parquet.compression", "gzip")
+	c.Set("output.parquet.max_size", 1024)
+	c.Set("output.parquet.roll_interval", "1h")
+	return c
+}
+
+// Lists the files under dir relative to it, without the host and time part of the names
+func parquetFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.Dir(rel)+"/"+filepath.Ext(path))
+		}
+		return nil
+	})
+
+	sort.Strings(files)
+	return files
+}
+
+func Test_createParquetOutput(t *testing.T) {
+	c := newParquetTestConfig("/tmp")
+
+	c.Set("output.format", "msgpack")
+	_, err := createParquetOutput(c)
+	assert.EqualError(t, err, "Output parquet requires the json output format, `msgpack` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.parquet.dir", "")
+	_, err = createParquetOutput(c)
+	assert.EqualError(t, err, "output.parquet.dir must be set")
+	c.Set("output.parquet.dir", "/tmp")
+
+	c.Set("output.parquet.partition", "minute")
+	_, err = createParquetOutput(c)
+	assert.EqualError(t, err, "output.parquet.partition must be hour or day; Value: `minute`")
+	c.Set("output.parquet.partition", "day")
+
+	c.Set("output.parquet.compression", "snappy")
+	_, err = createParquetOutput(c)
+	assert.EqualError(t, err, "output.parquet.compression must be gzip or none; Value: `snappy`")
+	c.Set("output.parquet.compression", "none")
+
+	c.Set("output.parquet.max_size", 0)
+	_, err = createParquetOutput(c)
+	assert.EqualError(t, err, "output.parquet.max_size must be greater than 0, 0 provided")
+	c.Set("output.parquet.max_size", 1024)
+
+	c.Set("output.parquet.roll_interval", "0s")
+	_, err = createParquetOutput(c)
+	assert.EqualError(t, err, "output.parquet.roll_interval must be greater than 0, 0s provided")
+	c.Set("output.parquet.roll_interval", "1h")
+
+	o, err := createParquetOutput(c)
+	assert.Nil(t, err)
+	po := o.(*parquetOutput)
+	assert.Equal(t, int32(parquetUncompressed), po.codec)
+	assert.Equal(t, "dt=2017-10-06", po.partition(po.partitionTime(1507312034123)))
+	assert.NotEmpty(t, po.hostname)
+
+	assert.Len(t, parquetColumns, len(flatColumns))
+	assert.Equal(t, parquetColumn{name: "exe", typ: parquetByteArray, converted: parquetUTF8, optional: true}, parquetColumns[10])
+}
+
+func TestParquetOutput(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	o, err := createParquetOutput(newParquetTestConfig(filepath.Join(dir, "events")))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+
+	event := func(ts string) []byte {
+		return []byte(`{"sequence":1,"timestamp_ms":` + ts + `,"messages":[{"type":1300,"data":"syscall=59 exe=\"/bin/ls\""}],"uid_map":{}}` + "\n")
+	}
+
+	// Nothing is written until the partition changes
+	_, err = o.Write(event("1507312034123"))
+	assert.Nil(t, err)
+	o.Write(event("1507312035000"))
+	assert.Equal(t, []string{}, parquetFiles(t, dir))
+
+	o.Write(event("1507315634123"))
+	assert.Equal(t, []string{"events/dt=2017-10-06/hour=17/.parquet"}, parquetFiles(t, dir))
+
+	// Or the file is full
+	for i := 0; i < 10; i++ {
+		o.Write(event("1507315634123"))
+	}
+	assert.Equal(t, []string{"events/dt=2017-10-06/hour=17/.parquet", "events/dt=2017-10-06/hour=18/.parquet"}, parquetFiles(t, dir))
+
+	// Events that can't be flattened are dropped
+	n, err := o.Write([]byte("nope"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.Contains(t, elb.String(), "Skipping event that could not be flattened for the parquet output.")
+
+	// Close writes what is left
+	assert.Nil(t, o.Close())
+	assert.True(t, o.Healthy())
+	assert.Equal(t, []string{"events/dt=2017-10-06/hour=17/.parquet", "events/dt=2017-10-06/hour=18/.parquet", "events/dt=2017-10-06/hour=18/.parquet"}, parquetFiles(t, dir))
+	assert.Nil(t, o.Flush())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "events/dt=2017-10-06/hour=17/*"))
+	assert.True(t, strings.HasPrefix(filepath.Base(files[0]), "go-audit-"))
+	b, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, "PAR1", string(b[:4]))
+	assert.Equal(t, "PAR1", string(b[len(b)-4:]))
+
+	// Failed writes keep the events and refuse new ones
+	o, err = createParquetOutput(newParquetTestConfig(filepath.Join(dir, "fail")))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+	o.Write(event("1507312034123"))
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fail", "dt=2017-10-06"), nil, 0600))
+	_, err = o.Write(event("1507315634123"))
+	assert.Contains(t, err.Error(), "Failed to write parquet file "+filepath.Join(dir, "fail", "dt=2017-10-06", "hour=17"))
+	assert.False(t, o.Healthy())
+	assert.Contains(t, o.Close().Error(), ", 1 events were lost")
+}
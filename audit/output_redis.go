@@ -0,0 +1,350 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("redis", createRedisOutput)
+}
+
+// redisOutput adds every event to a redis stream with XADD, or publishes it to a channel with PUBLISH
+// Connections are kept in a small pool and made on demand, so a redis that is down does not stop go-audit starting
+type redisOutput struct {
+	mode   string // stream or pubsub
+	key    string // Stream key or channel name
+	field  string // Stream entry field holding the event
+	maxLen int    // Approximate stream length to trim to, 0 to never trim
+	pool   *redisPool
+
+	mu  sync.Mutex
+	err error // Last error, nil once an event was written
+}
+
+func createRedisOutput(config *viper.Viper) (Output, error) {
+	address := config.GetString("output.redis.address")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("output.redis.address could not be parsed; Value: `%s`", address)
+	}
+
+	mode := config.GetString("output.redis.mode")
+	if mode != "stream" && mode != "pubsub" {
+		return nil, fmt.Errorf("output.redis.mode must be stream or pubsub, `%s` provided", mode)
+	}
+
+	key := config.GetString("output.redis.key")
+	if key == "" {
+		return nil, errors.New("output.redis.key must be set")
+	}
+
+	maxLen := config.GetInt("output.redis.stream.max_len")
+	if maxLen < 0 {
+		return nil, fmt.Errorf("output.redis.stream.max_len must be 0 or greater, %v provided", maxLen)
+	}
+
+	poolSize := config.GetInt("output.redis.pool_size")
+	if poolSize < 1 {
+		return nil, fmt.Errorf("output.redis.pool_size must be greater than 0, %v provided", poolSize)
+	}
+
+	timeout := config.GetDuration("output.redis.timeout")
+	if timeout <= 0 {
+		return nil, fmt.Errorf("output.redis.timeout must be greater than 0, %v provided", timeout)
+	}
+
+	pool := &redisPool{
+		address:  address,
+		username: config.GetString("output.redis.username"),
+		password: config.GetString("output.redis.password"),
+		db:       config.GetInt("output.redis.db"),
+		timeout:  timeout,
+		idle:     make(chan *redisConn, poolSize),
+	}
+
+	if config.GetBool("output.redis.tls.enabled") {
+		tlsConfig, err := createTLSConfig(config, "output.redis.tls")
+		if err != nil {
+			return nil, err
+		}
+
+		pool.tlsConfig = tlsConfig
+	}
+
+	return &redisOutput{
+		mode:   mode,
+		key:    key,
+		field:  config.GetString("output.redis.stream.field"),
+		maxLen: maxLen,
+		pool:   pool,
+	}, nil
+}
+
+func (o *redisOutput) Open() error {
+	return nil
+}
+
+func (o *redisOutput) Write(p []byte) (int, error) {
+	args := []string{"PUBLISH", o.key, string(p)}
+	if o.mode == "stream" {
+		args = []string{"XADD", o.key}
+		if o.maxLen > 0 {
+			args = append(args, "MAXLEN", "~", strconv.Itoa(o.maxLen))
+		}
+		args = append(args, "*", o.field, string(p))
+	}
+
+	_, err := o.pool.do(args...)
+
+	o.mu.Lock()
+	o.err = err
+	o.mu.Unlock()
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to %s to redis %s. Error: %s", args[0], o.key, err)
+	}
+
+	return len(p), nil
+}
+
+// Flush does nothing, every write waits for redis to answer
+func (o *redisOutput) Flush() error {
+	return nil
+}
+
+func (o *redisOutput) Close() error {
+	o.pool.close()
+	return nil
+}
+
+// Healthy is true as long as the last write succeeded
+func (o *redisOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil
+}
+
+// redisPool keeps up to cap(idle) connections around for reuse
+type redisPool struct {
+	address   string
+	tlsConfig *tls.Config // nil for plain tcp
+	username  string
+	password  string
+	db        int
+	timeout   time.Duration
+	idle      chan *redisConn
+}
+
+// Runs a command on a pooled connection. Idle connections may have been closed by the server in the meantime,
+// so if a reused one is broken the pool is emptied and the command is tried once more on a fresh connection
+func (p *redisPool) do(args ...string) (interface{}, error) {
+	c, reused, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := p.run(c, args)
+	if err == nil || !reused {
+		return reply, err
+	}
+
+	if _, ok := err.(redisError); ok {
+		return nil, err
+	}
+
+	p.close()
+	if c, err = p.dial(); err != nil {
+		return nil, err
+	}
+
+	return p.run(c, args)
+}
+
+// Runs a command, the connection goes back to the pool unless it broke
+func (p *redisPool) run(c *redisConn, args []string) (interface{}, error) {
+	reply, err := c.do(p.timeout, args...)
+	if _, ok := err.(redisError); err == nil || ok {
+		p.put(c)
+	} else {
+		c.conn.Close()
+	}
+
+	return reply, err
+}
+
+// Takes an idle connection or makes a new one, reports if it was reused
+func (p *redisPool) get() (*redisConn, bool, error) {
+	select {
+	case c := <-p.idle:
+		return c, true, nil
+	default:
+	}
+
+	c, err := p.dial()
+	return c, false, err
+}
+
+// Returns a connection to the pool, it is closed if the pool is full
+func (p *redisPool) put(c *redisConn) {
+	select {
+	case p.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (p *redisPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Connects, authenticates and selects the db
+func (p *redisPool) dial() (*redisConn, error) {
+	d := &net.Dialer{Timeout: p.timeout}
+
+	var conn net.Conn
+	var err error
+	if p.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(d, "tcp", p.address, p.tlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", p.address)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to %s. Error: %s", p.address, err)
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	if p.password != "" {
+		// Redis 6 acl users authenticate with a username, older servers only know the password
+		args := []string{"AUTH", p.password}
+		if p.username != "" {
+			args = []string{"AUTH", p.username, p.password}
+		}
+
+		if _, err := c.do(p.timeout, args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to authenticate to %s. Error: %s", p.address, err)
+		}
+	}
+
+	if p.db != 0 {
+		if _, err := c.do(p.timeout, "SELECT", strconv.Itoa(p.db)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to select db %d on %s. Error: %s", p.db, p.address, err)
+		}
+	}
+
+	return c, nil
+}
+
+// An error reply from redis, the connection is still usable after one
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// A connection speaking RESP, one command at a time
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// Sends a command and reads the reply
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// Reads one reply, arrays are returned as []interface{} and nil bulk strings as nil
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("Empty reply from redis")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("Failed to parse a redis reply; Value: `%s`", line)
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("Failed to parse a redis reply; Value: `%s`", line)
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, n)
+		for i := range items {
+			// An error inside an array, like from EXEC, is returned as the item
+			item, err := c.read()
+			if rerr, ok := err.(redisError); ok {
+				item = rerr
+			} else if err != nil {
+				return nil, err
+			}
+
+			items[i] = item
+		}
+
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("Failed to parse a redis reply; Value: `%s`", line)
+}
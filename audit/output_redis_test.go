@@ -0,0 +1,252 @@
+package audit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// A redis server that records the commands it gets and answers them like redis would
+type testRedisServer struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	commands [][]string
+	accepted int
+	conns    []net.Conn
+}
+
+func newTestRedisServer(t *testing.T, password string) *testRedisServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &testRedisServer{ln: ln, password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.accepted++
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, err = r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = string(b[:size])
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		s.mu.Unlock()
+
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] != s.password {
+				io.WriteString(conn, "-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+				continue
+			}
+			authed = true
+			io.WriteString(conn, "+OK\r\n")
+		case !authed:
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "SELECT":
+			io.WriteString(conn, "+OK\r\n")
+		case args[0] == "XADD":
+			if args[1] == "not-a-stream" {
+				io.WriteString(conn, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "$15\r\n1700000000000-0\r\n")
+		case args[0] == "PUBLISH":
+			io.WriteString(conn, ":2\r\n")
+		}
+	}
+}
+
+// Drops every connection, like a redis restart or idle timeout
+func (s *testRedisServer) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testRedisServer) getCommands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string{}, s.commands...)
+}
+
+func testRedisConfig(address string) *viper.Viper {
+	c := viper.New()
+	c.Set("output.redis.address", address)
+	c.Set("output.redis.mode", "stream")
+	c.Set("output.redis.key", "go-audit")
+	c.Set("output.redis.stream.field", "event")
+	c.Set("output.redis.pool_size", 2)
+	c.Set("output.redis.timeout", "1s")
+	return c
+}
+
+func Test_createRedisOutput(t *testing.T) {
+	c := testRedisConfig("127.0.0.1")
+	_, err := createRedisOutput(c)
+	assert.EqualError(t, err, "output.redis.address could not be parsed; Value: `127.0.0.1`")
+	c.Set("output.redis.address", "127.0.0.1:6379")
+
+	c.Set("output.redis.mode", "list")
+	_, err = createRedisOutput(c)
+	assert.EqualError(t, err, "output.redis.mode must be stream or pubsub, `list` provided")
+	c.Set("output.redis.mode", "pubsub")
+
+	c.Set("output.redis.key", "")
+	_, err = createRedisOutput(c)
+	assert.EqualError(t, err, "output.redis.key must be set")
+	c.Set("output.redis.key", "go-audit")
+
+	c.Set("output.redis.stream.max_len", -1)
+	_, err = createRedisOutput(c)
+	assert.EqualError(t, err, "output.redis.stream.max_len must be 0 or greater, -1 provided")
+	c.Set("output.redis.stream.max_len", 0)
+
+	c.Set("output.redis.pool_size", 0)
+	_, err = createRedisOutput(c)
+	assert.EqualError(t, err, "output.redis.pool_size must be greater than 0, 0 provided")
+	c.Set("output.redis.pool_size", 2)
+
+	c.Set("output.redis.timeout", "0s")
+	_, err = createRedisOutput(c)
+	assert.EqualError(t, err, "output.redis.timeout must be greater than 0, 0s provided")
+	c.Set("output.redis.timeout", "1s")
+
+	c.Set("output.redis.tls.enabled", true)
+	c.Set("output.redis.tls.ca_file", "/tmp/go-audit-does-not-exist")
+	_, err = createRedisOutput(c)
+	assert.EqualError(t, err, "Failed to read output.redis.tls.ca_file. Error: open /tmp/go-audit-does-not-exist: no such file or directory")
+}
+
+func TestRedisOutput_stream(t *testing.T) {
+	s := newTestRedisServer(t, "secret")
+	defer s.ln.Close()
+
+	c := testRedisConfig(s.ln.Addr().String())
+	c.Set("output.redis.stream.max_len", 1000)
+	c.Set("output.redis.username", "go_audit")
+	c.Set("output.redis.password", "secret")
+	c.Set("output.redis.db", 2)
+
+	o, err := createRedisOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+
+	n, err := o.Write([]byte(`{"sequence":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 14, n)
+	assert.True(t, o.Healthy())
+
+	_, err = o.Write([]byte(`{"sequence":2}`))
+	assert.Nil(t, err)
+
+	assert.Equal(t, [][]string{
+		{"AUTH", "go_audit", "secret"},
+		{"SELECT", "2"},
+		{"XADD", "go-audit", "MAXLEN", "~", "1000", "*", "event", `{"sequence":1}`},
+		{"XADD", "go-audit", "MAXLEN", "~", "1000", "*", "event", `{"sequence":2}`},
+	}, s.getCommands())
+
+	// The pooled connection was reused
+	s.mu.Lock()
+	assert.Equal(t, 1, s.accepted)
+	s.mu.Unlock()
+
+	// A connection the server closed while it was idle is replaced without failing the write
+	s.disconnect()
+	_, err = o.Write([]byte(`{"sequence":3}`))
+	assert.Nil(t, err)
+
+	s.mu.Lock()
+	assert.Equal(t, 2, s.accepted)
+	s.mu.Unlock()
+
+	// Error replies fail the write but keep the connection
+	o.(*redisOutput).key = "not-a-stream"
+	_, err = o.Write([]byte(`{"sequence":4}`))
+	assert.EqualError(t, err, "Failed to XADD to redis not-a-stream. Error: WRONGTYPE Operation against a key holding the wrong kind of value")
+	assert.False(t, o.Healthy())
+
+	s.mu.Lock()
+	assert.Equal(t, 2, s.accepted)
+	s.mu.Unlock()
+
+	assert.Nil(t, o.Close())
+}
+
+func TestRedisOutput_pubsub(t *testing.T) {
+	s := newTestRedisServer(t, "secret")
+	defer s.ln.Close()
+
+	c := testRedisConfig(s.ln.Addr().String())
+	c.Set("output.redis.mode", "pubsub")
+	c.Set("output.redis.password", "wrong")
+
+	o, err := createRedisOutput(c)
+	assert.Nil(t, err)
+
+	_, err = o.Write([]byte(`{"sequence":1}`))
+	assert.EqualError(t, err, "Failed to PUBLISH to redis go-audit. Error: Failed to authenticate to "+s.ln.Addr().String()+". Error: WRONGPASS invalid username-password pair or user is disabled.")
+
+	o.(*redisOutput).pool.password = "secret"
+	_, err = o.Write([]byte(`{"sequence":2}`))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Close())
+
+	assert.Equal(t, [][]string{
+		{"AUTH", "wrong"},
+		{"AUTH", "secret"},
+		{"PUBLISH", "go-audit", `{"sequence":2}`},
+	}, s.getCommands())
+}
@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("sql", createSQLOutput)
+}
+
+// sqlDialect holds what differs between the supported databases
+// Migrations are only ever appended to, the number applied is kept in go_audit_schema
+type sqlDialect struct {
+	tags        string // Build tags that link the driver in
+	placeholder func(i int) string
+	migrations  [][]string
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	"sqlite3": {
+		tags:        "sqlite",
+		placeholder: func(i int) string { return "?" },
+		migrations: [][]string{
+			{
+				`CREATE TABLE go_audit_events (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					sequence INTEGER NOT NULL,
+					timestamp_ms INTEGER NOT NULL,
+					syscall TEXT, success TEXT, exit TEXT, pid TEXT, ppid TEXT,
+					uid TEXT, username TEXT, auid TEXT, ausername TEXT,
+					comm TEXT, exe TEXT, cwd TEXT, key TEXT,
+					event TEXT NOT NULL
+				)`,
+				`CREATE INDEX go_audit_events_time ON go_audit_events (timestamp_ms)`,
+				`CREATE INDEX go_audit_events_auid ON go_audit_events (auid)`,
+				`CREATE INDEX go_audit_events_exe ON go_audit_events (exe)`,
+			},
+		},
+	},
+	"postgres": {
+		tags:        "postgres",
+		placeholder: func(i int) string { return fmt.Sprintf("$%d", i) },
+		migrations: [][]string{
+			{
+				`CREATE TABLE go_audit_events (
+					id BIGSERIAL PRIMARY KEY,
+					sequence BIGINT NOT NULL,
+					timestamp_ms BIGINT NOT NULL,
+					syscall TEXT, success TEXT, exit TEXT, pid TEXT, ppid TEXT,
+					uid TEXT, username TEXT, auid TEXT, ausername TEXT,
+					comm TEXT, exe TEXT, cwd TEXT, key TEXT,
+					event JSONB NOT NULL
+				)`,
+				`CREATE INDEX go_audit_events_time ON go_audit_events (timestamp_ms)`,
+				`CREATE INDEX go_audit_events_auid ON go_audit_events (auid)`,
+				`CREATE INDEX go_audit_events_exe ON go_audit_events (exe)`,
+			},
+		},
+	},
+}
+
+// sqlOutput inserts events into a go_audit_events table, one batch per transaction
+// The columns are the flattened event, see flatten.go
+type sqlOutput struct {
+	*batchOutput
+	driver  string
+	dialect *sqlDialect
+	db      *sql.DB
+	insertQ string
+}
+
+func createSQLOutput(config *viper.Viper) (Output, error) {
+	// Events are flattened from their json form
+	if f := outputFormat(config, "sql"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output sql requires the json output format, `%s` is configured", f)
+	}
+
+	driver := config.GetString("output.sql.driver")
+	dialect, ok := sqlDialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("output.sql.driver must be sqlite3 or postgres; Value: `%s`", driver)
+	}
+
+	if !sqlDriverBuiltIn(driver) {
+		return nil, fmt.Errorf("Output sql driver `%s` is not built in, build go-audit with `-tags %s`", driver, dialect.tags)
+	}
+
+	dsn := config.GetString("output.sql.dsn")
+	if dsn == "" {
+		return nil, fmt.Errorf("output.sql.dsn must be set")
+	}
+
+	batchSize := config.GetInt("output.sql.batch_size")
+	if batchSize < 1 {
+		return nil, fmt.Errorf("output.sql.batch_size must be greater than 0, %v provided", batchSize)
+	}
+
+	interval := config.GetDuration("output.sql.flush_interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("output.sql.flush_interval must be greater than 0, %v provided", interval)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open sql database. Error: %s", err)
+	}
+
+	values := make([]string, len(flatColumns))
+	for i := range values {
+		values[i] = dialect.placeholder(i + 1)
+	}
+
+	o := &sqlOutput{
+		driver:  driver,
+		dialect: dialect,
+		db:      db,
+		insertQ: fmt.Sprintf("INSERT INTO go_audit_events (%s) VALUES (%s)", strings.Join(flatColumns, ", "), strings.Join(values, ", ")),
+	}
+
+	o.batchOutput = newBatchOutput(batchSize, interval, o.insert)
+	return o, nil
+}
+
+// Drivers are not linked in by default to keep cgo out of the regular build
+func sqlDriverBuiltIn(driver string) bool {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Open brings the schema up to date before any events are written
+func (o *sqlOutput) Open() error {
+	if err := o.db.Ping(); err != nil {
+		return fmt.Errorf("Failed to connect to sql database. Error: %s", err)
+	}
+
+	if err := o.migrate(); err != nil {
+		return err
+	}
+
+	return o.batchOutput.Open()
+}
+
+// Applies the migrations that have not been applied yet, each in its own transaction
+func (o *sqlOutput) migrate() error {
+	if _, err := o.db.Exec("CREATE TABLE IF NOT EXISTS go_audit_schema (version INTEGER NOT NULL)"); err != nil {
+		return fmt.Errorf("Failed to create sql schema table. Error: %s", err)
+	}
+
+	var version int
+	if err := o.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM go_audit_schema").Scan(&version); err != nil {
+		return fmt.Errorf("Failed to read sql schema version. Error: %s", err)
+	}
+
+	if version > len(o.dialect.migrations) {
+		return fmt.Errorf("sql schema version %d is newer than this go-audit supports (%d)", version, len(o.dialect.migrations))
+	}
+
+	for i := version; i < len(o.dialect.migrations); i++ {
+		tx, err := o.db.Begin()
+		if err != nil {
+			return fmt.Errorf("Failed to migrate sql schema to version %d. Error: %s", i+1, err)
+		}
+
+		for _, stmt := range o.dialect.migrations[i] {
+			if _, err = tx.Exec(stmt); err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO go_audit_schema (version) VALUES ("+o.dialect.placeholder(1)+")", i+1)
+		}
+
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to migrate sql schema to version %d. Error: %s", i+1, err)
+		}
+
+		l.Printf("Migrated sql schema to version %d\n", i+1)
+	}
+
+	return nil
+}
+
+// Inserts a batch in a single transaction
+func (o *sqlOutput) insert(rows [][]byte) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to insert into sql database. Error: %s", err)
+	}
+
+	stmt, err := tx.Prepare(o.insertQ)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to insert into sql database. Error: %s", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		args, err := flattenEvent(row)
+		if err != nil {
+			// Retrying won't fix it, don't hold up the rest of the batch
+			el.Printf("Skipping event that could not be flattened for the sql output. Error: %s\n", err)
+			continue
+		}
+
+		if _, err := stmt.Exec(args...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to insert into sql database. Error: %s", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to insert into sql database. Error: %s", err)
+	}
+
+	return nil
+}
+
+// Close inserts whatever is left and closes the database
+func (o *sqlOutput) Close() error {
+	err := o.batchOutput.Close()
+	if cerr := o.db.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+// +build postgres
+
+package audit
+
+// Links the PostgreSQL driver in for the sql output
+import _ "github.com/lib/pq"
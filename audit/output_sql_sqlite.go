@@ -0,0 +1,7 @@
+//go:build sqlite
+// +build sqlite
+
+package audit
+
+// Links the SQLite driver in for the sql output, it requires cgo
+import _ "github.com/mattn/go-sqlite3"
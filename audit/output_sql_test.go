@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// A database/sql driver that records statements, the dsn picks the fakeDB
+type fakeSQLDriver struct{}
+
+type fakeDB struct {
+	mu      sync.Mutex
+	stmts   []string
+	args    [][]driver.Value
+	version int64
+	fail    string // Statements containing this fail
+}
+
+var fakeDBs = map[string]*fakeDB{}
+
+func init() {
+	sql.Register("fakesql", fakeSQLDriver{})
+	sqlDialects["fakesql"] = &sqlDialect{
+		tags:        "fake",
+		placeholder: sqlDialects["postgres"].placeholder,
+		migrations:  [][]string{{"CREATE TABLE one"}, {"CREATE TABLE two", "CREATE INDEX two"}},
+	}
+}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{fakeDBs[name]}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c.db, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return &fakeTx{c.db}, c.db.record("BEGIN", nil) }
+
+type fakeTx struct{ db *fakeDB }
+
+func (t *fakeTx) Commit() error   { return t.db.record("COMMIT", nil) }
+func (t *fakeTx) Rollback() error { return t.db.record("ROLLBACK", nil) }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), s.db.record(s.query, args)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{v: s.db.version}, s.db.record(s.query, args)
+}
+
+type fakeRows struct {
+	v    int64
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.v
+	return nil
+}
+
+func (db *fakeDB) record(stmt string, args []driver.Value) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.fail != "" && strings.Contains(stmt, db.fail) {
+		return errors.New("database is locked")
+	}
+
+	db.stmts = append(db.stmts, stmt)
+	db.args = append(db.args, args)
+	return nil
+}
+
+func newSQLTestConfig(dsn string) *viper.Viper {
+	c := viper.New()
+	c.Set("output.sql.driver", "fakesql")
+	c.Set("output.sql.dsn", dsn)
+	c.Set("output.sql.batch_size", 2)
+	c.Set("output.sql.flush_interval", "1h")
+	return c
+}
+
+func Test_createSQLOutput(t *testing.T) {
+	c := newSQLTestConfig("test")
+
+	c.Set("output.format", "msgpack")
+	_, err := createSQLOutput(c)
+	assert.EqualError(t, err, "Output sql requires the json output format, `msgpack` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.sql.driver", "mysql")
+	_, err = createSQLOutput(c)
+	assert.EqualError(t, err, "output.sql.driver must be sqlite3 or postgres; Value: `mysql`")
+
+	// Builds with `-tags postgres` have the driver
+	c.Set("output.sql.driver", "postgres")
+	_, err = createSQLOutput(c)
+	if !sqlDriverBuiltIn("postgres") {
+		assert.EqualError(t, err, "Output sql driver `postgres` is not built in, build go-audit with `-tags postgres`")
+	}
+	c.Set("output.sql.driver", "fakesql")
+
+	c.Set("output.sql.dsn", "")
+	_, err = createSQLOutput(c)
+	assert.EqualError(t, err, "output.sql.dsn must be set")
+	c.Set("output.sql.dsn", "test")
+
+	c.Set("output.sql.batch_size", 0)
+	_, err = createSQLOutput(c)
+	assert.EqualError(t, err, "output.sql.batch_size must be greater than 0, 0 provided")
+	c.Set("output.sql.batch_size", 2)
+
+	c.Set("output.sql.flush_interval", "0s")
+	_, err = createSQLOutput(c)
+	assert.EqualError(t, err, "output.sql.flush_interval must be greater than 0, 0s provided")
+	c.Set("output.sql.flush_interval", "1h")
+
+	o, err := createSQLOutput(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "INSERT INTO go_audit_events (sequence, timestamp_ms, syscall, success, exit, pid, ppid, uid, auid, comm, exe, key, username, ausername, cwd, event) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)", o.(*sqlOutput).insertQ)
+}
+
+func TestSQLOutput_migrate(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	db := &fakeDB{version: 1}
+	fakeDBs["migrate"] = db
+
+	o, err := createSQLOutput(newSQLTestConfig("migrate"))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+	defer o.Close()
+
+	// Only the missing migration is applied
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS go_audit_schema (version INTEGER NOT NULL)",
+		"SELECT COALESCE(MAX(version), 0) FROM go_audit_schema",
+		"BEGIN",
+		"CREATE TABLE two",
+		"CREATE INDEX two",
+		"INSERT INTO go_audit_schema (version) VALUES ($1)",
+		"COMMIT",
+	}, db.stmts)
+	assert.Equal(t, []driver.Value{int64(2)}, db.args[5])
+	assert.Equal(t, "Migrated sql schema to version 2\n", lb.String())
+
+	// Failures roll back
+	db = &fakeDB{fail: "INDEX"}
+	fakeDBs["migrate_fail"] = db
+	o, err = createSQLOutput(newSQLTestConfig("migrate_fail"))
+	assert.Nil(t, err)
+	assert.EqualError(t, o.Open(), "Failed to migrate sql schema to version 2. Error: database is locked")
+	assert.Equal(t, "ROLLBACK", db.stmts[len(db.stmts)-1])
+
+	// Never run against a schema from a newer version
+	fakeDBs["migrate_new"] = &fakeDB{version: 3}
+	o, err = createSQLOutput(newSQLTestConfig("migrate_new"))
+	assert.Nil(t, err)
+	assert.EqualError(t, o.Open(), "sql schema version 3 is newer than this go-audit supports (2)")
+}
+
+func TestSQLOutput_insert(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	db := &fakeDB{version: 2}
+	fakeDBs["insert"] = db
+
+	o, err := createSQLOutput(newSQLTestConfig("insert"))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+
+	event := `{"sequence":10,"timestamp":"1.000","timestamp_ms":1000,"messages":[` +
+		`{"type":1300,"data":"arch=c000003e syscall=59 success=yes exit=0 ppid=1 pid=2 auid=1000 uid=0 comm=\"ls\" exe=\"/bin/ls\" key=\"exec\""},` +
+		`{"type":1307,"data":"IGN3ZD0iL3RtcCI=","encoding":"base64"}],"uid_map":{"0":"root","1000":"alice"}}` + "\n"
+
+	o.Write([]byte(event))
+	o.Write([]byte("not json"))
+	assert.Len(t, db.stmts, 2)
+
+	// The third write sends the first two in one transaction, the bad row is skipped
+	o.Write([]byte(`{"sequence":11,"timestamp_ms":2000,"messages":[{"type":1105,"data":"pid=1"}],"uid_map":{}}`))
+	assert.Equal(t, []string{"BEGIN", o.(*sqlOutput).insertQ, "COMMIT"}, db.stmts[2:])
+	assert.Equal(t, []driver.Value{
+		int64(10), int64(1000), "59", "yes", "0", "2", "1", "0", "1000", "ls", "/bin/ls", "exec", "root", "alice", "/tmp",
+		strings.TrimSpace(event),
+	}, db.args[3])
+	assert.Contains(t, elb.String(), "Skipping event that could not be flattened for the sql output.")
+
+	// Fields that are missing are NULL
+	assert.Nil(t, o.Close())
+	assert.Equal(t, []driver.Value{int64(11), int64(2000), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+		`{"sequence":11,"timestamp_ms":2000,"messages":[{"type":1105,"data":"pid=1"}],"uid_map":{}}`}, db.args[len(db.args)-2])
+
+	// Failed batches are rolled back and kept
+	db = &fakeDB{version: 2}
+	fakeDBs["insert_fail"] = db
+	o, err = createSQLOutput(newSQLTestConfig("insert_fail"))
+	assert.Nil(t, err)
+	assert.Nil(t, o.Open())
+	defer o.Close()
+
+	db.fail = "INSERT INTO go_audit_events"
+	assert.Nil(t, o.Flush())
+	o.Write([]byte(event))
+	assert.EqualError(t, o.Flush(), "Failed to insert into sql database. Error: database is locked")
+	assert.False(t, o.Healthy())
+	assert.Equal(t, "ROLLBACK", db.stmts[len(db.stmts)-1])
+
+	db.fail = ""
+	assert.Nil(t, o.Flush())
+	assert.True(t, o.Healthy())
+}
@@ -0,0 +1,263 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterOutput("tcp", createTCPOutput)
+}
+
+// tcpOutput streams newline delimited events to a tcp or tls listener, like logstash and fluent-bit tcp inputs
+// Writes only queue the event, a single goroutine sends them and reconnects with backoff whenever the connection breaks
+type tcpOutput struct {
+	address      string
+	tlsConfig    *tls.Config // nil for plain tcp
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	backoff      time.Duration
+	maxBackoff   time.Duration
+
+	queue   chan []byte
+	pending int64 // Queued events plus the one being sent, accessed atomically
+
+	mu   sync.Mutex
+	err  error // Last connect or write error, nil once an event was sent
+	stop chan struct{}
+	done chan struct{}
+}
+
+func createTCPOutput(config *viper.Viper) (Output, error) {
+	// Events are framed by newlines, so only json can be used
+	if f := outputFormat(config, "tcp"); f != "" && f != "json" {
+		return nil, fmt.Errorf("Output tcp requires the json output format, `%s` is configured", f)
+	}
+
+	address := config.GetString("output.tcp.address")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("output.tcp.address could not be parsed; Value: `%s`", address)
+	}
+
+	queueSize := config.GetInt("output.tcp.queue_size")
+	if queueSize < 1 {
+		return nil, fmt.Errorf("output.tcp.queue_size must be greater than 0, %v provided", queueSize)
+	}
+
+	backoff := config.GetDuration("output.tcp.reconnect.backoff")
+	if backoff <= 0 {
+		return nil, fmt.Errorf("output.tcp.reconnect.backoff must be greater than 0, %v provided", backoff)
+	}
+
+	o := &tcpOutput{
+		address:      address,
+		dialTimeout:  config.GetDuration("output.tcp.dial_timeout"),
+		writeTimeout: config.GetDuration("output.tcp.write_timeout"),
+		backoff:      backoff,
+		maxBackoff:   config.GetDuration("output.tcp.reconnect.max_backoff"),
+		queue:        make(chan []byte, queueSize),
+	}
+
+	if config.GetBool("output.tcp.tls.enabled") {
+		tlsConfig, err := createTLSConfig(config, "output.tcp.tls")
+		if err != nil {
+			return nil, err
+		}
+
+		o.tlsConfig = tlsConfig
+	}
+
+	return o, nil
+}
+
+// Open starts sending queued events, the connection itself is made by the sender
+func (o *tcpOutput) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop == nil {
+		o.stop = make(chan struct{})
+		o.done = make(chan struct{})
+		go o.run(o.stop, o.done)
+	}
+
+	return nil
+}
+
+// Write queues the event, it is refused if the queue is full so the writer can retry it
+func (o *tcpOutput) Write(p []byte) (int, error) {
+	// The writer may reuse p
+	line := append(bytes.TrimRight(append([]byte{}, p...), "\n"), '\n')
+
+	atomic.AddInt64(&o.pending, 1)
+	select {
+	case o.queue <- line:
+		return len(p), nil
+	default:
+		atomic.AddInt64(&o.pending, -1)
+		return 0, fmt.Errorf("The output.tcp queue is full, %d events are waiting", cap(o.queue))
+	}
+}
+
+// Flush waits up to write_timeout for the queue to be sent
+func (o *tcpOutput) Flush() error {
+	deadline := time.Now().Add(o.writeTimeout)
+	for {
+		n := atomic.LoadInt64(&o.pending)
+		if n == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Failed to flush the output.tcp queue, %d events are still waiting", n)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Close gives the queue a chance to drain, then stops the sender and drops the connection
+func (o *tcpOutput) Close() error {
+	err := o.Flush()
+
+	o.mu.Lock()
+	stop, done := o.stop, o.done
+	o.stop = nil
+	o.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s, they were lost", err)
+	}
+
+	return nil
+}
+
+// Healthy is true as long as the last event was sent and the queue has room
+func (o *tcpOutput) Healthy() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err == nil && len(o.queue) < cap(o.queue)
+}
+
+func (o *tcpOutput) setErr(err error) {
+	o.mu.Lock()
+	o.err = err
+	o.mu.Unlock()
+}
+
+func (o *tcpOutput) connect() (net.Conn, error) {
+	d := &net.Dialer{Timeout: o.dialTimeout}
+	if o.tlsConfig != nil {
+		return tls.DialWithDialer(d, "tcp", o.address, o.tlsConfig)
+	}
+
+	return d.Dial("tcp", o.address)
+}
+
+// Sends queued events one at a time, an event is only taken off once it was written
+func (o *tcpOutput) run(stop, done chan struct{}) {
+	defer close(done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	wait := o.backoff
+	for {
+		var line []byte
+		select {
+		case <-stop:
+			return
+		case line = <-o.queue:
+		}
+
+		for {
+			if conn != nil && peerClosed(conn) {
+				conn.Close()
+				conn = nil
+			}
+
+			if conn == nil {
+				c, err := o.connect()
+				if err != nil {
+					o.setErr(fmt.Errorf("Failed to connect to %s. Error: %s", o.address, err))
+					el.Printf("Failed to connect to %s, retrying in %s. Error: %s\n", o.address, wait, err)
+
+					select {
+					case <-stop:
+						return
+					case <-time.After(wait):
+					}
+
+					if wait *= 2; o.maxBackoff > 0 && wait > o.maxBackoff {
+						wait = o.maxBackoff
+					}
+					continue
+				}
+
+				conn, wait = c, o.backoff
+			}
+
+			if o.writeTimeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(o.writeTimeout))
+			}
+
+			// A partially written event is sent again in full on the next connection
+			if _, err := conn.Write(line); err != nil {
+				o.setErr(fmt.Errorf("Failed to write to %s. Error: %s", o.address, err))
+				el.Printf("Failed to write to %s, reconnecting. Error: %s\n", o.address, err)
+				conn.Close()
+				conn = nil
+				continue
+			}
+
+			break
+		}
+
+		o.setErr(nil)
+		atomic.AddInt64(&o.pending, -1)
+	}
+}
+
+// Listeners never send anything back, so anything other than "nothing to read yet" means the peer hung up
+// Without this the first event after the peer closed the connection would vanish into the socket buffer
+func peerClosed(conn net.Conn) bool {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	raw.Control(func(fd uintptr) {
+		var b [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = (err == nil && n == 0) || (err != nil && err != syscall.EAGAIN)
+	})
+
+	return closed
+}
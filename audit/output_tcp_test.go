@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createTCPOutput(t *testing.T) {
+	c := viper.New()
+	c.Set("output.tcp.address", "127.0.0.1:5170")
+	c.Set("output.tcp.queue_size", 10)
+	c.Set("output.tcp.reconnect.backoff", "1s")
+
+	c.Set("output.format", "msgpack")
+	_, err := createTCPOutput(c)
+	assert.EqualError(t, err, "Output tcp requires the json output format, `msgpack` is configured")
+	c.Set("output.format", "json")
+
+	c.Set("output.tcp.address", "127.0.0.1")
+	_, err = createTCPOutput(c)
+	assert.EqualError(t, err, "output.tcp.address could not be parsed; Value: `127.0.0.1`")
+	c.Set("output.tcp.address", "127.0.0.1:5170")
+
+	c.Set("output.tcp.queue_size", 0)
+	_, err = createTCPOutput(c)
+	assert.EqualError(t, err, "output.tcp.queue_size must be greater than 0, 0 provided")
+	c.Set("output.tcp.queue_size", 10)
+
+	c.Set("output.tcp.reconnect.backoff", "0s")
+	_, err = createTCPOutput(c)
+	assert.EqualError(t, err, "output.tcp.reconnect.backoff must be greater than 0, 0s provided")
+	c.Set("output.tcp.reconnect.backoff", "1s")
+
+	// tls settings are only looked at when it is enabled
+	c.Set("output.tcp.tls.ca_file", "/tmp/go-audit-does-not-exist")
+	o, err := createTCPOutput(c)
+	assert.Nil(t, err)
+	assert.Nil(t, o.(*tcpOutput).tlsConfig)
+
+	c.Set("output.tcp.tls.enabled", true)
+	_, err = createTCPOutput(c)
+	assert.EqualError(t, err, "Failed to read output.tcp.tls.ca_file. Error: open /tmp/go-audit-does-not-exist: no such file or directory")
+}
+
+func TestTCPOutput_reconnect(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	c := viper.New()
+	c.Set("output.tcp.address", ln.Addr().String())
+	c.Set("output.tcp.queue_size", 2)
+	c.Set("output.tcp.write_timeout", "5s")
+	c.Set("output.tcp.reconnect.backoff", "10ms")
+	o, err := createTCPOutput(c)
+	assert.Nil(t, err)
+
+	// Nothing is sent until the output is opened, a full queue refuses events
+	o.Write([]byte("{\"sequence\":1}\n"))
+	o.Write([]byte("{\"sequence\":2}"))
+	_, err = o.Write([]byte("{\"sequence\":3}\n"))
+	assert.EqualError(t, err, "The output.tcp queue is full, 2 events are waiting")
+	assert.False(t, o.Healthy())
+
+	assert.Nil(t, o.Open())
+
+	conn, err := ln.Accept()
+	assert.Nil(t, err)
+	r := bufio.NewReader(conn)
+	line, _ := r.ReadString('\n')
+	assert.Equal(t, "{\"sequence\":1}\n", line)
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "{\"sequence\":2}\n", line)
+	assert.Nil(t, o.Flush())
+	assert.True(t, o.Healthy())
+
+	// The next event goes out on a new connection once the listener hangs up
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	o.Write([]byte("{\"sequence\":3}\n"))
+
+	conn, err = ln.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	line, _ = bufio.NewReader(conn).ReadString('\n')
+	assert.Equal(t, "{\"sequence\":3}\n", line)
+	assert.Nil(t, o.Close())
+
+	// Events wait in the queue while the listener is gone
+	ln.Close()
+	assert.Nil(t, o.Open())
+	o.Write([]byte("{\"sequence\":4}\n"))
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, o.Healthy())
+
+	o.(*tcpOutput).writeTimeout = 10 * time.Millisecond
+	assert.EqualError(t, o.Close(), "Failed to flush the output.tcp queue, 1 events are still waiting, they were lost")
+	assert.Contains(t, elb.String(), "Failed to connect to "+ln.Addr().String()+", retrying in")
+}
+
+func TestTCPOutput_tls(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	ca, err := ioutil.TempFile("", "go-audit")
+	assert.Nil(t, err)
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	ca.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	c := viper.New()
+	c.Set("output.tcp.address", ln.Addr().String())
+	c.Set("output.tcp.queue_size", 10)
+	c.Set("output.tcp.write_timeout", "5s")
+	c.Set("output.tcp.reconnect.backoff", "10ms")
+	c.Set("output.tcp.tls.enabled", true)
+	c.Set("output.tcp.tls.ca_file", ca.Name())
+	o, err := createTCPOutput(c)
+	assert.Nil(t, err)
+
+	assert.Nil(t, o.Open())
+	defer o.Close()
+	o.Write([]byte("{\"sequence\":1}\n"))
+
+	conn, err := ln.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	assert.Equal(t, "{\"sequence\":1}\n", line)
+}
@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterOutput(t *testing.T) {
+	defer delete(outputs, "test")
+
+	RegisterOutput("test", func(config *viper.Viper) (Output, error) {
+		return NewWriterOutput(&bytes.Buffer{}), nil
+	})
+	assert.Contains(t, outputs, "test")
+
+	assert.Panics(t, func() {
+		RegisterOutput("test", nil)
+	}, "Registering the same name twice should panic")
+
+	// registered outputs can be enabled like the built in ones
+	c := viper.New()
+	c.Set("output.test.enabled", true)
+	c.Set("output.test.attempts", 2)
+	w, err := createOutput(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, w.(*OutputWriter).attempts)
+	assert.IsType(t, &bytes.Buffer{}, w.(*OutputWriter).w.(*WriterOutput).w)
+
+	// unknown outputs
+	ow, err := createNamedOutput(c, "nope")
+	assert.EqualError(t, err, "Unknown output `nope`")
+	assert.Nil(t, ow)
+}
+
+func TestWriterOutput(t *testing.T) {
+	// plain writers are not closed
+	b := &bytes.Buffer{}
+	o := NewWriterOutput(b)
+	assert.Nil(t, o.Open())
+	assert.True(t, o.Healthy())
+
+	n, err := o.Write([]byte("hi"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "hi", b.String())
+	assert.Nil(t, o.Flush())
+	assert.Nil(t, o.Close())
+
+	// failed writes mark the output unhealthy until a write succeeds
+	o = NewWriterOutput(&FailWriter{})
+	_, err = o.Write([]byte("hi"))
+	assert.EqualError(t, err, "derp")
+	assert.False(t, o.Healthy())
+
+	o.w = b
+	_, err = o.Write([]byte("hi"))
+	assert.Nil(t, err)
+	assert.True(t, o.Healthy())
+}
+
+func TestNewAuditWriter(t *testing.T) {
+	// io.Writers get wrapped
+	w := NewAuditWriter(&bytes.Buffer{}, 1)
+	assert.IsType(t, &WriterOutput{}, w.w)
+	assert.IsType(t, &JSONMarshaler{}, w.m)
+
+	// outputs are used as is
+	o := NewWriterOutput(&bytes.Buffer{})
+	w = NewAuditWriter(o, 1)
+	assert.Equal(t, o, w.w)
+	assert.True(t, w.Healthy())
+	assert.Nil(t, w.Flush())
+	assert.Nil(t, w.Close())
+}
+
+func Test_multiWriter(t *testing.T) {
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	m := &multiWriter{names: []string{"a", "b", "c"}, writers: []*OutputWriter{NewAuditWriter(a, 1), NewAuditWriter(&FailWriter{}, 1), NewAuditWriter(b, 1)}}
+	m.writers[2].m = &JSONMarshaler{Compat: true}
+
+	// A failing output doesn't keep the event from the others
+	err := m.Write(context.Background(), &AuditMessageGroup{Seq: 1, UidMap: map[string]string{}})
+	assert.EqualError(t, err, "Failed to write to output b. Error: derp")
+	assert.Contains(t, a.String(), `"schema_version":2,"sequence":1`)
+	assert.Contains(t, b.String(), `{"sequence":1`)
+	assert.False(t, m.Healthy())
+
+	m.writers[1].w = NewWriterOutput(&bytes.Buffer{})
+	assert.True(t, m.Healthy())
+	assert.Nil(t, m.Flush())
+	assert.Nil(t, m.Close())
+}
+
+func TestOutputWriter_filters(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	c.Set("output.file.filters", []interface{}{
+		map[interface{}]interface{}{"syscall": "connect"},
+	})
+	c.Set("output.stdout.filters", "nope")
+
+	_, err := createFormattedOutput(c, "stdout")
+	assert.EqualError(t, err, "Failed to parse output.stdout.filters. Error: Could not parse output.stdout.filters object")
+
+	filters, err := parseFilters(c, "output.file.filters")
+	assert.Nil(t, err)
+	assert.Empty(t, lb.String())
+
+	b := &bytes.Buffer{}
+	w := NewAuditWriter(b, 1)
+	w.filters = filters
+
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 1, SyscallName: "connect"}))
+	assert.Empty(t, b.String())
+
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 2, SyscallName: "execve"}))
+	assert.Contains(t, b.String(), `"sequence":2`)
+
+	// Regex filters keep their meaning, no syscall only matches events without one
+	w.filters = []AuditFilter{{messageType: 1112, regex: regexp.MustCompile("acct=\"bob\"")}}
+	b.Reset()
+
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 3, Msgs: []*AuditMessage{{Type: 1112, Data: `acct="bob"`}}}))
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 4, Syscall: "59", Msgs: []*AuditMessage{{Type: 1112, Data: `acct="bob"`}}}))
+	assert.NotContains(t, b.String(), `"sequence":3`)
+	assert.Contains(t, b.String(), `"sequence":4`)
+}
+
+func TestOutputWriter_filtersTamper(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewAuditWriter(b, 1)
+	w.filters = []AuditFilter{{syscallName: "connect"}}
+	w.keepOnly = true
+
+	// A keep only output with an unrelated filter still gets the tampering
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 1, Tamper: &AuditTamper{Change: "rules_removed"}}))
+	assert.Contains(t, b.String(), `"sequence":1`)
+
+	// Dropping it doesn't work either
+	b.Reset()
+	w.filters = []AuditFilter{{messageType: 1305, regex: regexp.MustCompile("op=remove_rule")}}
+	w.keepOnly = false
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 2, Tamper: &AuditTamper{Change: "rules_removed"},
+		Msgs: []*AuditMessage{{Type: 1305, Data: "op=remove_rule"}}}))
+	assert.Contains(t, b.String(), `"sequence":2`)
+}
+
+func TestOutputWriter_filtersInternal(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewAuditWriter(b, 1)
+	w.filters = []AuditFilter{{syscallName: "connect"}}
+	w.keepOnly = true
+
+	// Lost events, parse errors and takeovers go to every output
+	assert.Nil(t, w.Write(context.Background(), newInternalEvent(EVENT_KERNEL, "op=events_lost reason=kernel lost=1 total=1 res=0")))
+	assert.Contains(t, b.String(), "op=events_lost")
+
+	// Events from the kernel are still filtered
+	b.Reset()
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 3, SyscallName: "execve"}))
+	assert.Empty(t, b.String())
+}
+
+func Test_checkFailoverMembers(t *testing.T) {
+	c := viper.New()
+	c.Set("output.failover.outputs", []string{"syslog", "file"})
+	assert.Nil(t, checkFailoverMembers(c, []string{"file", "stdout"}))
+
+	c.Set("output.failover.enabled", true)
+	assert.Nil(t, checkFailoverMembers(c, []string{"failover", "stdout"}))
+	assert.EqualError(t, checkFailoverMembers(c, []string{"failover", "file"}), "Output `file` is in output.failover.outputs and can't be enabled on its own too")
+}
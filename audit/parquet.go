@@ -0,0 +1,332 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A minimal parquet writer for flat schemas of int64 and string columns
+// Each file is a single row group with one PLAIN encoded data page per column
+// See https://github.com/apache/parquet-format for the format and its thrift definitions
+
+const (
+	parquetInt64     = 2 // Type INT64
+	parquetByteArray = 6 // Type BYTE_ARRAY
+
+	parquetUTF8            = 0 // ConvertedType UTF8
+	parquetTimestampMillis = 9 // ConvertedType TIMESTAMP_MILLIS
+
+	parquetUncompressed = 0 // CompressionCodec UNCOMPRESSED
+	parquetGzip         = 2 // CompressionCodec GZIP
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	optional  bool
+}
+
+type parquetWriter struct {
+	columns []parquetColumn
+	codec   int32
+
+	values []bytes.Buffer // PLAIN encoded values, nulls are left out
+	levels [][]byte       // Definition levels of optional columns, 1 if the value is set
+	rows   int
+	size   int // Uncompressed size of the values
+}
+
+func newParquetWriter(columns []parquetColumn, codec int32) *parquetWriter {
+	return &parquetWriter{
+		columns: columns,
+		codec:   codec,
+		values:  make([]bytes.Buffer, len(columns)),
+		levels:  make([][]byte, len(columns)),
+	}
+}
+
+// Adds a row, values are int64, string or nil for each column in order
+func (w *parquetWriter) add(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("Parquet row has %d values, expected %d", len(row), len(w.columns))
+	}
+
+	// Check everything first so a bad row is not half added
+	for i, c := range w.columns {
+		switch row[i].(type) {
+		case nil:
+			if !c.optional {
+				return fmt.Errorf("Parquet column %s can not be null", c.name)
+			}
+		case int64:
+			if c.typ != parquetInt64 {
+				return fmt.Errorf("Parquet column %s does not take an int64", c.name)
+			}
+		case string:
+			if c.typ != parquetByteArray {
+				return fmt.Errorf("Parquet column %s does not take a string", c.name)
+			}
+		default:
+			return fmt.Errorf("Parquet column %s does not take a %T", c.name, row[i])
+		}
+	}
+
+	for i, c := range w.columns {
+		if c.optional {
+			level := byte(1)
+			if row[i] == nil {
+				level = 0
+			}
+			w.levels[i] = append(w.levels[i], level)
+		}
+
+		buf := &w.values[i]
+		before := buf.Len()
+		switch v := row[i].(type) {
+		case int64:
+			binary.Write(buf, binary.LittleEndian, v)
+		case string:
+			binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		}
+		w.size += buf.Len() - before
+	}
+
+	w.rows++
+	return nil
+}
+
+// Writes the complete file
+func (w *parquetWriter) writeTo(out io.Writer) error {
+	cw := &countingWriter{w: out}
+	cw.Write([]byte("PAR1"))
+
+	chunks := make([]*compactWriter, len(w.columns))
+	var totalSize int64
+	for i, c := range w.columns {
+		page := &bytes.Buffer{}
+		if c.optional {
+			levels := rleLevels(w.levels[i])
+			binary.Write(page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+		page.Write(w.values[i].Bytes())
+
+		compressed := page.Bytes()
+		if w.codec == parquetGzip {
+			gz := &bytes.Buffer{}
+			zw := gzip.NewWriter(gz)
+			zw.Write(page.Bytes())
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			compressed = gz.Bytes()
+		}
+
+		header := &compactWriter{}
+		header.structBegin()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(len(compressed)))
+		header.structField(5)
+		header.i32(1, int32(w.rows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE
+		header.i32(4, 3) // RLE
+		header.structEnd()
+		header.structEnd()
+
+		offset := cw.n
+		cw.Write(header.buf.Bytes())
+		cw.Write(compressed)
+		if cw.err != nil {
+			return cw.err
+		}
+
+		uncompressedSize := int64(header.buf.Len() + page.Len())
+		totalSize += uncompressedSize
+
+		// ColumnChunk
+		chunk := &compactWriter{}
+		chunk.structBegin()
+		chunk.i64(2, offset)
+		chunk.structField(3)
+		chunk.i32(1, c.typ)
+		chunk.listBegin(2, compactI32, 2)
+		chunk.listI32(0) // PLAIN
+		chunk.listI32(3) // RLE
+		chunk.listBegin(3, compactBinary, 1)
+		chunk.listBinary(c.name)
+		chunk.i32(4, w.codec)
+		chunk.i64(5, int64(w.rows))
+		chunk.i64(6, uncompressedSize)
+		chunk.i64(7, int64(header.buf.Len()+len(compressed)))
+		chunk.i64(9, offset)
+		chunk.structEnd()
+		chunk.structEnd()
+		chunks[i] = chunk
+	}
+
+	// FileMetaData
+	meta := &compactWriter{}
+	meta.structBegin()
+	meta.i32(1, 1)
+	meta.listBegin(2, compactStruct, len(w.columns)+1)
+	meta.structBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(w.columns)))
+	meta.structEnd()
+	for _, c := range w.columns {
+		repetition := int32(0) // REQUIRED
+		if c.optional {
+			repetition = 1 // OPTIONAL
+		}
+
+		meta.structBegin()
+		meta.i32(1, c.typ)
+		meta.i32(3, repetition)
+		meta.binary(4, c.name)
+		if c.converted >= 0 {
+			meta.i32(6, c.converted)
+		}
+		meta.structEnd()
+	}
+	meta.i64(3, int64(w.rows))
+	meta.listBegin(4, compactStruct, 1)
+	meta.structBegin()
+	meta.listBegin(1, compactStruct, len(chunks))
+	for _, chunk := range chunks {
+		meta.buf.Write(chunk.buf.Bytes())
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(w.rows))
+	meta.structEnd()
+	meta.binary(6, "go-audit")
+	meta.structEnd()
+
+	cw.Write(meta.buf.Bytes())
+	binary.Write(cw, binary.LittleEndian, uint32(meta.buf.Len()))
+	cw.Write([]byte("PAR1"))
+	return cw.err
+}
+
+// Encodes definition levels with a bit width of 1 as RLE runs
+func rleLevels(levels []byte) []byte {
+	buf := []byte{}
+	tmp := make([]byte, binary.MaxVarintLen64)
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+
+		n := binary.PutUvarint(tmp, uint64(j-i)<<1)
+		buf = append(buf, tmp[:n]...)
+		buf = append(buf, levels[i])
+		i = j
+	}
+
+	return buf
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error // The first error, later writes are skipped
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Thrift compact protocol types
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter writes the thrift compact protocol, just enough of it for parquet metadata
+type compactWriter struct {
+	buf  bytes.Buffer
+	last []int16 // Last field id of each open struct
+}
+
+func (t *compactWriter) uvarint(v uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	t.buf.Write(tmp[:binary.PutUvarint(tmp, v)])
+}
+
+func (t *compactWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.uvarint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	*last = id
+}
+
+func (t *compactWriter) structBegin() {
+	t.last = append(t.last, 0)
+}
+
+// Starts a struct field, end it with structEnd
+func (t *compactWriter) structField(id int16) {
+	t.field(id, compactStruct)
+	t.structBegin()
+}
+
+func (t *compactWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *compactWriter) i32(id int16, v int32) {
+	t.field(id, compactI32)
+	t.listI32(v)
+}
+
+func (t *compactWriter) i64(id int16, v int64) {
+	t.field(id, compactI64)
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *compactWriter) binary(id int16, v string) {
+	t.field(id, compactBinary)
+	t.listBinary(v)
+}
+
+// Starts a list field, the elements are written with the list* funcs or as structs
+func (t *compactWriter) listBegin(id int16, typ byte, n int) {
+	t.field(id, compactList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		t.buf.WriteByte(0xf0 | typ)
+		t.uvarint(uint64(n))
+	}
+}
+
+func (t *compactWriter) listI32(v int32) {
+	t.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *compactWriter) listBinary(v string) {
+	t.uvarint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
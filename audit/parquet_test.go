@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Reads thrift compact structs into maps of field id to value, enough to check the parquet metadata
+type compactReader struct {
+	b *bytes.Reader
+}
+
+func (r *compactReader) zigzag() int64 {
+	v, _ := binary.ReadUvarint(r.b)
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) value(typ byte) interface{} {
+	switch typ {
+	case compactI32, compactI64:
+		return r.zigzag()
+	case compactBinary:
+		n, _ := binary.ReadUvarint(r.b)
+		b := make([]byte, n)
+		r.b.Read(b)
+		return string(b)
+	case compactList:
+		h, _ := r.b.ReadByte()
+		n := uint64(h >> 4)
+		if n == 15 {
+			n, _ = binary.ReadUvarint(r.b)
+		}
+		list := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			list = append(list, r.value(h&0x0f))
+		}
+		return list
+	case compactStruct:
+		return r.readStruct()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *compactReader) readStruct() map[int64]interface{} {
+	s := map[int64]interface{}{}
+	var last int64
+	for {
+		h, _ := r.b.ReadByte()
+		if h == 0 {
+			return s
+		}
+
+		if h>>4 == 0 {
+			last = r.zigzag()
+		} else {
+			last += int64(h >> 4)
+		}
+		s[last] = r.value(h & 0x0f)
+	}
+}
+
+func Test_rleLevels(t *testing.T) {
+	assert.Equal(t, []byte{}, rleLevels(nil))
+	assert.Equal(t, []byte{6, 1, 2, 0, 4, 1}, rleLevels([]byte{1, 1, 1, 0, 1, 1}))
+
+	// Long runs need a multi byte varint
+	levels := make([]byte, 100)
+	assert.Equal(t, []byte{200, 1, 0}, rleLevels(levels))
+}
+
+func Test_compactWriter(t *testing.T) {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32(1, -1)
+	w.i64(3, 300)
+	w.binary(20, "far")
+	w.structField(21)
+	w.i32(1, 5)
+	w.structEnd()
+	w.listBegin(22, compactI32, 16)
+	for i := 0; i < 16; i++ {
+		w.listI32(int32(i))
+	}
+	w.structEnd()
+
+	assert.Equal(t, []byte{0x15, 0x01, 0x26, 0xd8, 0x04, 0x08, 0x28, 0x03, 'f', 'a', 'r', 0x1c, 0x15, 0x0a, 0x00, 0x19, 0xf5, 0x10}, w.buf.Bytes()[:18])
+
+	s := (&compactReader{bytes.NewReader(w.buf.Bytes())}).readStruct()
+	assert.Equal(t, int64(-1), s[1])
+	assert.Equal(t, int64(300), s[3])
+	assert.Equal(t, "far", s[20])
+	assert.Equal(t, map[int64]interface{}{1: int64(5)}, s[21])
+	assert.Len(t, s[22], 16)
+}
+
+func TestParquetWriter(t *testing.T) {
+	columns := []parquetColumn{
+		{name: "n", typ: parquetInt64, converted: -1},
+		{name: "s", typ: parquetByteArray, converted: parquetUTF8, optional: true},
+	}
+
+	w := newParquetWriter(columns, parquetGzip)
+	assert.EqualError(t, w.add([]interface{}{int64(1)}), "Parquet row has 1 values, expected 2")
+	assert.EqualError(t, w.add([]interface{}{nil, "a"}), "Parquet column n can not be null")
+	assert.EqualError(t, w.add([]interface{}{"1", "a"}), "Parquet column n does not take a string")
+	assert.EqualError(t, w.add([]interface{}{int64(1), int64(1)}), "Parquet column s does not take an int64")
+	assert.EqualError(t, w.add([]interface{}{int64(1), 1.5}), "Parquet column s does not take a float64")
+	assert.Equal(t, 0, w.rows)
+
+	assert.Nil(t, w.add([]interface{}{int64(1), "a"}))
+	assert.Nil(t, w.add([]interface{}{int64(2), nil}))
+	assert.Nil(t, w.add([]interface{}{int64(3), "bc"}))
+	assert.Equal(t, 3, w.rows)
+	assert.Equal(t, 8*3+4+1+4+2, w.size)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, w.writeTo(buf))
+	b := buf.Bytes()
+
+	assert.Equal(t, "PAR1", string(b[:4]))
+	assert.Equal(t, "PAR1", string(b[len(b)-4:]))
+	metaLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := (&compactReader{bytes.NewReader(b[len(b)-8-metaLen : len(b)-8])}).readStruct()
+
+	assert.Equal(t, int64(1), meta[1])
+	assert.Equal(t, int64(3), meta[3])
+	assert.Equal(t, "go-audit", meta[6])
+	assert.Equal(t, []interface{}{
+		map[int64]interface{}{4: "schema", 5: int64(2)},
+		map[int64]interface{}{1: int64(parquetInt64), 3: int64(0), 4: "n"},
+		map[int64]interface{}{1: int64(parquetByteArray), 3: int64(1), 4: "s", 6: int64(parquetUTF8)},
+	}, meta[2])
+
+	rowGroup := meta[4].([]interface{})[0].(map[int64]interface{})
+	assert.Equal(t, int64(3), rowGroup[3])
+	chunks := rowGroup[1].([]interface{})
+	assert.Len(t, chunks, 2)
+
+	// Read the page of the optional column back
+	md := chunks[1].(map[int64]interface{})[3].(map[int64]interface{})
+	assert.Equal(t, []interface{}{"s"}, md[3])
+	assert.Equal(t, int64(parquetGzip), md[4])
+	assert.Equal(t, int64(3), md[5])
+
+	chunk := b[md[9].(int64):]
+	page := bytes.NewReader(chunk)
+	header := (&compactReader{page}).readStruct()
+	assert.Equal(t, map[int64]interface{}{1: int64(3), 2: int64(0), 3: int64(3), 4: int64(3)}, header[5])
+
+	// The chunk size covers the page header and the compressed page
+	headerLen := int64(len(chunk) - page.Len())
+	assert.Equal(t, headerLen+header[3].(int64), md[7])
+	assert.Equal(t, headerLen+header[2].(int64), md[6])
+
+	compressed := make([]byte, header[3].(int64))
+	page.Read(compressed)
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(zr)
+	assert.Equal(t, int(header[2].(int64)), len(body))
+	assert.Equal(t, []byte{
+		6, 0, 0, 0, 2, 1, 2, 0, 2, 1, // Definition levels
+		1, 0, 0, 0, 'a', 2, 0, 0, 0, 'b', 'c', // Values, without the null
+	}, body)
+}
@@ -0,0 +1,506 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var uidMap = map[string]string{}
+
+// Uids that failed to resolve and when to try them again
+var uidMisses = map[string]time.Time{}
+
+// Same as uidMap and uidMisses for group ids
+var gidMap = map[string]string{}
+var gidMisses = map[string]time.Time{}
+
+// Uid and gid lookups answered from the cache, failed ones included, and those that had to look the id up, for metrics
+var idCacheHits, idCacheMisses uint64
+
+// Bounds each uid lookup and how long a failed lookup is remembered, see `uid_lookup` in the example config
+var uidLookupTimeout = time.Second * 2
+var uidNegativeTTL = time.Minute * 5
+
+// Name given to the unset id, (uid_t)-1, without looking it up. auid is unset for processes not started by a login
+const unsetId = "4294967295"
+
+var unsetIdName = "unset"
+
+// Time zone human readable timestamps are rendered in, see `output.timezone` in the example config
+var outputLocation = time.UTC
+
+// RFC3339 with milliseconds
+const humanTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+var headerEndChar = []byte{")"[0]}
+var headerSepChar = byte(':')
+var spaceChar = byte(' ')
+
+const (
+	HEADER_MIN_LENGTH = 7               // Minimum length of an audit header
+	HEADER_START_POS  = 6               // Position in the audit header that the data starts
+	COMPLETE_AFTER    = time.Second * 2 // Log a message after this time or EOE, the default for `events.complete_after`
+)
+
+type AuditMessage struct {
+	Type      uint16            `json:"type"`
+	Data      string            `json:"data"`
+	Encoding  string            `json:"encoding,omitempty"` // Set to base64 when data had to be encoded to survive json
+	Fields    map[string]string `json:"fields,omitempty"`   // The parsed data, only set when formats.json.parsed is on
+	Seq       int               `json:"-"`
+	AuditTime string            `json:"-"`
+	parseErr  error
+}
+
+// UidName is a uid and the username it resolved to
+type UidName struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type AuditMessageGroup struct {
+	Seq           int                    `json:"sequence"`
+	AuditTime     string                 `json:"timestamp"`
+	TimestampMs   int64                  `json:"timestamp_ms,omitempty"` // AuditTime as milliseconds since the epoch
+	CompleteAfter time.Time              `json:"-"`
+	Msgs          []*AuditMessage        `json:"messages"`
+	UidMap        map[string]string      `json:"uid_map"`
+	GidMap        map[string]string      `json:"gid_map,omitempty"`
+	Uids          map[string]*UidName    `json:"uids,omitempty"`         // Each uid field by name, like auid or euid, from the first record that has it
+	Syscall       string                 `json:"syscall,omitempty"`      // From the first SYSCALL record, empty for userspace events
+	SyscallName   string                 `json:"syscall_name,omitempty"` // Resolved with the arch of the same record, empty if either is unknown
+	SyscallArgs   map[string]string      `json:"syscall_args,omitempty"` // a0 to a3 decoded for common syscalls, like flags and mode of open
+	Syscalls      []string               `json:"syscalls,omitempty"`     // Every syscall in order, only set if the sequence had more than one SYSCALL record
+	Keys          []string               `json:"keys,omitempty"`         // The rule keys (-k) of the first SYSCALL record
+	Success       *bool                  `json:"success,omitempty"`      // success= of the first SYSCALL record
+	Exit          *int64                 `json:"exit,omitempty"`         // exit= of the first SYSCALL record
+	ExitErrno     string                 `json:"exit_errno,omitempty"`   // The errno name of a negative exit, like EACCES
+	SocketPath    string                 `json:"socket_path,omitempty"`  // From the first AF_UNIX SOCKADDR record
+	Sockaddr      *SocketAddress         `json:"sockaddr,omitempty"`     // From the first SOCKADDR record with a family we understand
+	Argv          []string               `json:"argv,omitempty"`         // Reassembled from the EXECVE records
+	Proctitle     string                 `json:"proctitle,omitempty"`    // Decoded from the PROCTITLE record
+	Paths         []*AuditPath           `json:"paths,omitempty"`        // The PATH records in item order
+	MAC           *MACDecision           `json:"mac,omitempty"`          // From the first AVC or APPARMOR record
+	Seccomp       *SeccompEvent          `json:"seccomp,omitempty"`      // From the first SECCOMP record
+	Capabilities  map[string][]string    `json:"capabilities,omitempty"` // The capability sets of CAPSET and BPRM_FCAPS records by field, like cap_pe
+	UserEvent     *UserEvent             `json:"user_event,omitempty"`   // From the first record in the 1100 range
+	Extra         map[string]interface{} `json:"extra,omitempty"`        // Free form data added by enrichers
+	Alert         *Alert                 `json:"alert,omitempty"`
+	Tamper        *AuditTamper           `json:"audit_tamper,omitempty"`
+	Latency       *EventLatency          `json:"latency_ms,omitempty"` // Only set when `latency.enabled` is on
+	Source        string                 `json:"source,omitempty"`     // go-audit for events it made up itself, see newInternalEvent
+	Dedup         *DedupSummary          `json:"dedup,omitempty"`      // Only set when `dedup.enabled` is on and identical events were folded into this one
+
+	// Set on groups holding a single message whose header could not be parsed, the data is the raw payload
+	ParseError       bool   `json:"parse_error,omitempty"`
+	ParseErrorReason string `json:"parse_error_reason,omitempty"`
+
+	// When the first record of the group was received
+	received time.Time
+}
+
+// Creates a new message group from the details parsed from the message
+func NewAuditMessageGroup(ctx context.Context, am *AuditMessage) *AuditMessageGroup {
+	//TODO: allocating 6 msgs per group is lame and we _should_ know ahead of time roughly how many we need
+	now := time.Now()
+	amg := &AuditMessageGroup{
+		Seq:           am.Seq,
+		AuditTime:     am.AuditTime,
+		CompleteAfter: now.Add(COMPLETE_AFTER),
+		received:      now,
+		UidMap:        make(map[string]string, 2), // Usually only 2 individual uids per execve
+		Msgs:          make([]*AuditMessage, 0, 6),
+	}
+
+	if t, ok := parseAuditTime(am.AuditTime); ok {
+		amg.TimestampMs = t.UnixNano() / int64(time.Millisecond)
+	}
+
+	amg.AddMessage(ctx, am)
+	return amg
+}
+
+// Creates an event for something go-audit noticed or did itself, it holds a single message shaped like a kernel record
+func newInternalEvent(msgType uint16, data string) *AuditMessageGroup {
+	now := time.Now()
+	ms := now.UnixNano() / int64(time.Millisecond)
+	return &AuditMessageGroup{
+		AuditTime:   fmt.Sprintf("%d.%03d", ms/1000, ms%1000),
+		TimestampMs: ms,
+		Msgs:        []*AuditMessage{{Type: msgType, Data: data}},
+		UidMap:      map[string]string{},
+		Source:      "go-audit",
+		received:    now,
+	}
+}
+
+// Parses the `seconds.milliseconds` timestamp from an audit header without going through a float
+func parseAuditTime(ts string) (time.Time, bool) {
+	sec, frac := ts, ""
+	if dot := strings.IndexByte(ts, '.'); dot >= 0 {
+		sec, frac = ts[:dot], ts[dot+1:]
+	}
+
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// Audit always uses 3 digits, pad or cut anything else to milliseconds
+	frac = (frac + "000")[:3]
+	ms, err := strconv.Atoi(frac)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(s, int64(ms)*int64(time.Millisecond)), true
+}
+
+// Finds the time zone for `output.timezone`, utc, local or a name from the time zone database
+func loadTimezone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "", "utc":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown output.timezone `%s`. Error: %s", name, err)
+	}
+
+	return loc, nil
+}
+
+// Creates a new go-audit message from a netlink message
+func NewAuditMessage(nlm *syscall.NetlinkMessage) *AuditMessage {
+	aTime, seq, err := parseAuditHeader(nlm)
+	return &AuditMessage{
+		Type:      nlm.Header.Type,
+		Data:      string(nlm.Data),
+		Seq:       seq,
+		AuditTime: aTime,
+		parseErr:  err,
+	}
+}
+
+// Gets the timestamp and audit sequence id from a netlink message, the header looks like `audit(1500000000.123:42): `
+// The data is left untouched if an error is returned, nothing in here can index out of range on malformed input
+func parseAuditHeader(msg *syscall.NetlinkMessage) (time string, seq int, err error) {
+	headerStop := bytes.Index(msg.Data, headerEndChar)
+	// If the position the header appears to stop is less than the minimum length of a header, bail out
+	if headerStop < HEADER_MIN_LENGTH {
+		return "", 0, errors.New("Header is too short")
+	}
+
+	header := string(msg.Data[:headerStop])
+	if !strings.HasPrefix(header, "audit(") {
+		return "", 0, errors.New("Header does not start with audit(")
+	}
+
+	sep := strings.IndexByte(header, headerSepChar)
+	if sep < HEADER_START_POS {
+		return "", 0, errors.New("Header is missing the sequence separator")
+	}
+
+	time = header[HEADER_START_POS:sep]
+	if time == "" || strings.Trim(time, "0123456789.") != "" {
+		return "", 0, errors.New("Header has an invalid timestamp")
+	}
+
+	seq, err = strconv.Atoi(header[sep+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not parse the sequence. Error: %s", err)
+	}
+
+	if seq < 0 {
+		return "", 0, errors.New("Header has a negative sequence")
+	}
+
+	// Remove the header and the `: ` after it from data, either may be missing on truncated messages
+	data := msg.Data[headerStop+1:]
+	data = bytes.TrimPrefix(data, []byte{headerSepChar})
+	data = bytes.TrimPrefix(data, []byte{spaceChar})
+	msg.Data = data
+
+	return time, seq, nil
+}
+
+// Add a new message to the current message group
+func (amg *AuditMessageGroup) AddMessage(ctx context.Context, am *AuditMessage) {
+	amg.Msgs = append(amg.Msgs, am)
+	//TODO: need to find more message types that won't contain uids, also make these constants
+	switch am.Type {
+	case 1306:
+		// Don't map uids here
+		saddr := parseFields(am.Data)["saddr"]
+		if amg.SocketPath == "" {
+			amg.SocketPath = parseSocketPath(saddr)
+		}
+
+		if amg.Sockaddr == nil {
+			amg.Sockaddr = parseSocketAddress(saddr)
+		}
+	case 1309, 1307:
+		// Don't map uids here
+	case 1321, 1322:
+		// Don't map uids here
+		amg.addCapabilities(am)
+	case 1327:
+		// Don't map uids here
+		amg.Proctitle = parseProctitle(am.Data)
+	case 1302:
+		amg.mapUids(ctx, am)
+		amg.mapGids(ctx, am)
+		amg.addPath(am)
+	case 1400, 1501, 1502, 1503, 1504, 1505, 1506:
+		amg.mapUids(ctx, am)
+		amg.mapGids(ctx, am)
+		if amg.MAC == nil {
+			amg.MAC = parseMACDecision(am)
+		}
+	case 1326:
+		amg.mapUids(ctx, am)
+		amg.mapGids(ctx, am)
+		if amg.Seccomp == nil {
+			amg.Seccomp = parseSeccomp(am.Data)
+		}
+	case 1300:
+		amg.findSyscall(am)
+		amg.mapUids(ctx, am)
+		amg.mapGids(ctx, am)
+	default:
+		amg.mapUids(ctx, am)
+		amg.mapGids(ctx, am)
+		if am.Type >= 1100 && am.Type < 1200 && amg.UserEvent == nil {
+			amg.UserEvent = parseUserEvent(ctx, am)
+		}
+	}
+}
+
+// Find all `uid=` occurrences in a message and adds the username to the UidMap object
+// Each field is also added to Uids by its name so auid and euid can be told apart
+func (amg *AuditMessageGroup) mapUids(ctx context.Context, am *AuditMessage) {
+	mapIds(ctx, am.Data, "uid=", amg.UidMap, getUsername, func(field, id, name string) {
+		if amg.Uids == nil {
+			amg.Uids = make(map[string]*UidName, 2)
+		}
+
+		if _, ok := amg.Uids[field]; !ok {
+			amg.Uids[field] = &UidName{ID: id, Name: name}
+		}
+	})
+}
+
+// Find all `gid=` occurrences in a message and adds the group name to the GidMap object
+func (amg *AuditMessageGroup) mapGids(ctx context.Context, am *AuditMessage) {
+	if !strings.Contains(am.Data, "gid=") {
+		return
+	}
+
+	if amg.GidMap == nil {
+		amg.GidMap = make(map[string]string, 2)
+	}
+
+	mapIds(ctx, am.Data, "gid=", amg.GidMap, getGroupname, nil)
+}
+
+// Finds every id following key in data and adds the resolved name to names
+// found is optional and called with the full field name, like auid for the key uid=, the id and its name
+func mapIds(ctx context.Context, data string, key string, names map[string]string, resolve func(context.Context, string) string, found func(field, id, name string)) {
+	start := 0
+	end := 0
+
+	for {
+		if start = strings.Index(data, key); start < 0 {
+			break
+		}
+
+		// Progress the start point beyon the = sign
+		start += len(key)
+		if end = strings.IndexByte(data[start:], spaceChar); end < 0 {
+			// There was no ending space, maybe the id is at the end of the line
+			end = len(data) - start
+
+			// If the end of the line is greater than 10 characters away (overflows a 32 bit uint) then it can't be an id
+			if end > 10 {
+				break
+			}
+		}
+
+		id := data[start : start+end]
+
+		// Don't bother re-adding if the existing group already has the mapping
+		name, ok := names[id]
+		if !ok {
+			name = resolve(ctx, id)
+			names[id] = name
+		}
+
+		if found != nil {
+			// The field name is the run of letters ending at the key
+			fieldStart := start - len(key)
+			for fieldStart > 0 && isFieldChar(data[fieldStart-1]) {
+				fieldStart--
+			}
+			found(data[fieldStart:start-1], id, name)
+		}
+
+		// Find the next id if we have space for one
+		next := start + end + 1
+		if next >= len(data) {
+			break
+		}
+
+		data = data[next:]
+	}
+}
+
+func isFieldChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c == '_'
+}
+
+func (amg *AuditMessageGroup) findSyscall(am *AuditMessage) {
+	data := am.Data
+	start := 0
+	end := 0
+
+	if start = strings.Index(data, "syscall="); start < 0 {
+		return
+	}
+
+	// Progress the start point beyond the = sign
+	start += 8
+	if end = strings.IndexByte(data[start:], spaceChar); end < 0 {
+		// There was no ending space, maybe the syscall id is at the end of the line
+		end = len(data) - start
+
+		// If the end of the line is greater than 5 characters away (overflows a 16 bit uint) then it can't be a syscall id
+		if end > 5 {
+			return
+		}
+	}
+
+	id := data[start : start+end]
+
+	// The kernel emits one SYSCALL record per event, more than one means records from different events were merged
+	// The first one keeps deciding filters and alerts, the rest are kept so nothing is lost
+	switch {
+	case amg.Syscall == "":
+		amg.Syscall = id
+		arch := findValue(data, "arch")
+		amg.SyscallName = syscallName(arch, id)
+		amg.SyscallArgs = decodeSyscallArgs(amg.SyscallName, arch, data)
+		amg.findSyscallResult(data)
+		amg.Keys = parseKeys(data)
+	case len(amg.Syscalls) == 0:
+		amg.Syscalls = []string{amg.Syscall, id}
+	default:
+		amg.Syscalls = append(amg.Syscalls, id)
+	}
+}
+
+// Gets a username for a user id
+// If the context is done before the lookup finishes UNKNOWN_USER is returned and nothing is cached
+func getUsername(ctx context.Context, uid string) string {
+	return lookupName(ctx, uid, uidMap, uidMisses, "UNKNOWN_USER", func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+
+		return u.Username, nil
+	})
+}
+
+// Gets a group name for a group id, the same way as getUsername
+func getGroupname(ctx context.Context, gid string) string {
+	return lookupName(ctx, gid, gidMap, gidMisses, "UNKNOWN_GROUP", func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+
+		return g.Name, nil
+	})
+}
+
+// Resolves an id through the names cache, failed lookups are remembered in misses for uidNegativeTTL
+func lookupName(ctx context.Context, id string, names map[string]string, misses map[string]time.Time, unknown string, lookup func(string) (string, error)) string {
+	if name, ok := names[id]; ok {
+		atomic.AddUint64(&idCacheHits, 1)
+		return name
+	}
+
+	if id == unsetId {
+		return unsetIdName
+	}
+
+	// Failed lookups are not retried until the ttl is up so a broken NSS backend doesn't slow down every event
+	if retry, ok := misses[id]; ok && time.Now().Before(retry) {
+		atomic.AddUint64(&idCacheHits, 1)
+		return unknown
+	}
+
+	lctx := ctx
+	if uidLookupTimeout > 0 {
+		var cancel context.CancelFunc
+		lctx, cancel = context.WithTimeout(ctx, uidLookupTimeout)
+		defer cancel()
+	}
+
+	atomic.AddUint64(&idCacheMisses, 1)
+	name, err := lookupWithContext(lctx, id, lookup)
+	if err == nil {
+		names[id] = name
+		delete(misses, id)
+		return name
+	}
+
+	// The caller gave up, that says nothing about the id
+	if ctx.Err() != nil {
+		return unknown
+	}
+
+	misses[id] = time.Now().Add(uidNegativeTTL)
+	return unknown
+}
+
+// Wraps a lookup so the caller can give up on a slow one
+// The lookup itself can't be interrupted and will finish in the background
+func lookupWithContext(ctx context.Context, id string, lookup func(string) (string, error)) (string, error) {
+	if ctx.Done() == nil {
+		return lookup(id)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+
+	c := make(chan result, 1)
+	go func() {
+		name, err := lookup(id)
+		c <- result{name, err}
+	}()
+
+	select {
+	case r := <-c:
+		return r.name, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,425 @@
+package audit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAuditConstants(t *testing.T) {
+	assert.Equal(t, 7, HEADER_MIN_LENGTH)
+	assert.Equal(t, 6, HEADER_START_POS)
+	assert.Equal(t, time.Second*2, COMPLETE_AFTER)
+	assert.Equal(t, []byte{")"[0]}, headerEndChar)
+}
+
+func TestNewAuditMessage(t *testing.T) {
+	msg := &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{
+			Len:   uint32(44),
+			Type:  uint16(1309),
+			Flags: uint16(0),
+			Seq:   uint32(0),
+			Pid:   uint32(0),
+		},
+		Data: []byte("audit(10000001:99): hi there"),
+	}
+
+	am := NewAuditMessage(msg)
+	assert.Equal(t, uint16(1309), am.Type)
+	assert.Equal(t, 99, am.Seq)
+	assert.Equal(t, "10000001", am.AuditTime)
+	assert.Equal(t, "hi there", am.Data)
+}
+
+func TestAuditMessageGroup_AddMessage(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMap["0"] = "hi"
+	uidMap["1"] = "nope"
+
+	amg := &AuditMessageGroup{
+		Seq:           1,
+		AuditTime:     "ok",
+		CompleteAfter: time.Now().Add(COMPLETE_AFTER),
+		UidMap:        make(map[string]string, 2),
+	}
+
+	m := &AuditMessage{
+		Data: "uid=0 things notuid=nopethisisnot",
+	}
+
+	amg.AddMessage(context.Background(), m)
+	assert.Equal(t, 1, len(amg.Msgs), "Expected 1 message")
+	assert.Equal(t, m, amg.Msgs[0], "First message was wrong")
+	assert.Equal(t, 1, len(amg.UidMap), "Incorrect uid mapping count")
+	assert.Equal(t, "hi", amg.UidMap["0"])
+
+	// Make sure we don't parse uids for message types that don't have them
+	m = &AuditMessage{
+		Type: uint16(1309),
+		Data: "uid=1",
+	}
+	amg.AddMessage(context.Background(), m)
+	assert.Equal(t, 2, len(amg.Msgs), "Expected 2 messages")
+	assert.Equal(t, m, amg.Msgs[1], "2nd message was wrong")
+	assert.Equal(t, 1, len(amg.UidMap), "Incorrect uid mapping count")
+
+	m = &AuditMessage{
+		Type: uint16(1307),
+		Data: "uid=1",
+	}
+	amg.AddMessage(context.Background(), m)
+	assert.Equal(t, 3, len(amg.Msgs), "Expected 2 messages")
+	assert.Equal(t, m, amg.Msgs[2], "3rd message was wrong")
+	assert.Equal(t, 1, len(amg.UidMap), "Incorrect uid mapping count")
+
+	amg.AddMessage(context.Background(), &AuditMessage{Type: uint16(1327), Data: "proctitle=6C73002D6C61"})
+	assert.Equal(t, "ls -la", amg.Proctitle)
+
+	// The first unix socket path is kept
+	assert.Equal(t, "", amg.SocketPath)
+	amg.AddMessage(context.Background(), &AuditMessage{Type: uint16(1306), Data: "saddr=020001BB0A0102030000000000000000"})
+	assert.Equal(t, "", amg.SocketPath)
+	amg.AddMessage(context.Background(), &AuditMessage{Type: uint16(1306), Data: "saddr=01002F7661722F72756E2F646F636B65722E736F636B000000"})
+	assert.Equal(t, "/var/run/docker.sock", amg.SocketPath)
+	amg.AddMessage(context.Background(), &AuditMessage{Type: uint16(1306), Data: "saddr=01002F746D702F736F636B"})
+	assert.Equal(t, "/var/run/docker.sock", amg.SocketPath)
+
+	// As is the first address of any family
+	assert.Equal(t, &SocketAddress{Family: "inet", Address: "10.1.2.3", Port: 443}, amg.Sockaddr)
+	assert.Equal(t, 1, len(amg.UidMap), "Incorrect uid mapping count")
+}
+
+func TestNewAuditMessageGroup(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	m := &AuditMessage{
+		Type:      uint16(1300),
+		Seq:       1019,
+		AuditTime: "9919",
+		Data:      "Stuff is here",
+	}
+
+	amg := NewAuditMessageGroup(context.Background(), m)
+	assert.Equal(t, 1019, amg.Seq)
+	assert.Equal(t, "9919", amg.AuditTime)
+	assert.Equal(t, int64(9919000), amg.TimestampMs)
+	assert.True(t, amg.CompleteAfter.After(time.Now()), "Complete after time should be greater than right now")
+	assert.Equal(t, 6, cap(amg.Msgs), "Msgs capacity should be 6")
+	assert.Equal(t, 1, len(amg.Msgs), "Msgs should only have 1 message")
+	assert.Equal(t, 0, len(amg.UidMap), "No uids in the original message")
+	assert.Equal(t, m, amg.Msgs[0], "First message should be the original")
+}
+
+func Test_getUsername(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMisses = make(map[string]time.Time, 0)
+	assert.Equal(t, "root", getUsername(context.Background(), "0"), "0 should be root you animal")
+	assert.Equal(t, "UNKNOWN_USER", getUsername(context.Background(), "-1"), "Expected UNKNOWN_USER")
+
+	val, ok := uidMap["0"]
+	if !ok {
+		t.Fatal("Expected the uid mapping to be cached")
+	}
+	assert.Equal(t, "root", val)
+
+	_, ok = uidMap["-1"]
+	assert.False(t, ok, "Failed lookups should not be cached as a username")
+	assert.WithinDuration(t, time.Now().Add(uidNegativeTTL), uidMisses["-1"], time.Second)
+}
+
+func Test_getGroupname(t *testing.T) {
+	uidMisses = make(map[string]time.Time, 0)
+	gidMap = make(map[string]string, 0)
+	gidMisses = make(map[string]time.Time, 0)
+	assert.Equal(t, "root", getGroupname(context.Background(), "0"))
+	assert.Equal(t, "UNKNOWN_GROUP", getGroupname(context.Background(), "-1"))
+
+	assert.Equal(t, map[string]string{"0": "root"}, gidMap)
+	assert.Contains(t, gidMisses, "-1")
+	assert.NotContains(t, uidMisses, "-1", "Groups should have their own cache")
+}
+
+func Test_getUsername_unset(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMisses = make(map[string]time.Time, 0)
+	gidMap = make(map[string]string, 0)
+
+	defer func(name string) { unsetIdName = name }(unsetIdName)
+	unsetIdName = "nobody-yet"
+
+	// Never looked up, so never a miss either
+	assert.Equal(t, "nobody-yet", getUsername(context.Background(), "4294967295"))
+	assert.Equal(t, "nobody-yet", getGroupname(context.Background(), "4294967295"))
+	assert.Empty(t, uidMisses)
+
+	// Including at the end of a record
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.mapUids(context.Background(), &AuditMessage{Data: "pid=1 uid=0 auid=4294967295"})
+	assert.Equal(t, &UidName{ID: "4294967295", Name: "nobody-yet"}, amg.Uids["auid"])
+}
+
+func Test_getUsername_negative(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMisses = make(map[string]time.Time, 0)
+
+	// Misses are not retried until the ttl is up, even if the uid would resolve now
+	uidMisses["0"] = time.Now().Add(time.Minute)
+	assert.Equal(t, "UNKNOWN_USER", getUsername(context.Background(), "0"))
+	assert.Empty(t, uidMap)
+
+	uidMisses["0"] = time.Now().Add(-time.Second)
+	assert.Equal(t, "root", getUsername(context.Background(), "0"))
+	assert.Equal(t, "root", uidMap["0"])
+	assert.NotContains(t, uidMisses, "0", "A successful lookup should clear the miss")
+}
+
+func Test_getUsername_timeout(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMisses = make(map[string]time.Time, 0)
+
+	defer func(d time.Duration) { uidLookupTimeout = d }(uidLookupTimeout)
+	uidLookupTimeout = time.Nanosecond
+
+	// Timed out lookups count as misses
+	assert.Equal(t, "UNKNOWN_USER", getUsername(context.Background(), "0"))
+	assert.Contains(t, uidMisses, "0")
+	assert.Empty(t, uidMap)
+}
+
+func Test_getUsername_context(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMisses = make(map[string]time.Time, 0)
+
+	// A done context gives up without caching so the next lookup can try again
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, "UNKNOWN_USER", getUsername(ctx, "0"))
+	assert.Empty(t, uidMap)
+	assert.Empty(t, uidMisses)
+
+	// Lookups that finish in time are cached
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(t, "root", getUsername(ctx, "0"))
+	assert.Equal(t, "root", uidMap["0"])
+}
+
+func TestAuditMessageGroup_mapUids(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+	uidMap["0"] = "hi"
+	uidMap["1"] = "there"
+	uidMap["2"] = "fun"
+	uidMap["3"] = "test"
+	uidMap["99999"] = "derp"
+
+	amg := &AuditMessageGroup{
+		Seq:           1,
+		AuditTime:     "ok",
+		CompleteAfter: time.Now().Add(COMPLETE_AFTER),
+		UidMap:        make(map[string]string, 2),
+	}
+
+	m := &AuditMessage{
+		Data: "uid=0 1uid=1 2uid=2 3uid=3 not here 4uid=99999",
+	}
+	amg.mapUids(context.Background(), m)
+
+	assert.Equal(t, 5, len(amg.UidMap), "Uid map is too big")
+	assert.Equal(t, "hi", amg.UidMap["0"])
+	assert.Equal(t, "there", amg.UidMap["1"])
+	assert.Equal(t, "fun", amg.UidMap["2"])
+	assert.Equal(t, "test", amg.UidMap["3"])
+	assert.Equal(t, "derp", amg.UidMap["99999"])
+}
+
+func TestAuditMessageGroup_mapUids_fields(t *testing.T) {
+	uidMap = map[string]string{"0": "root", "1000": "alice"}
+
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.mapUids(context.Background(), &AuditMessage{Data: "ppid=1 pid=2 auid=1000 uid=0 gid=0 euid=0 suid=0 fsuid=0 ses=1"})
+	amg.mapUids(context.Background(), &AuditMessage{Data: "item=0 name=\"/etc/shadow\" ouid=0 uid=1000"})
+
+	assert.Equal(t, map[string]*UidName{
+		"auid":  {ID: "1000", Name: "alice"},
+		"uid":   {ID: "0", Name: "root"},
+		"euid":  {ID: "0", Name: "root"},
+		"suid":  {ID: "0", Name: "root"},
+		"fsuid": {ID: "0", Name: "root"},
+		"ouid":  {ID: "0", Name: "root"},
+	}, amg.Uids, "The first record with a field should win")
+	assert.Equal(t, map[string]string{"0": "root", "1000": "alice"}, amg.UidMap)
+}
+
+func TestAuditMessageGroup_mapGids(t *testing.T) {
+	gidMap = map[string]string{"0": "root", "27": "sudo"}
+
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.mapGids(context.Background(), &AuditMessage{Data: "uid=0 auid=1000"})
+	assert.Nil(t, amg.GidMap, "No gids should leave the map out")
+
+	amg.mapGids(context.Background(), &AuditMessage{Data: "gid=0 egid=0 sgid=27 fsgid=27"})
+	assert.Equal(t, map[string]string{"0": "root", "27": "sudo"}, amg.GidMap)
+
+	// Through AddMessage
+	uidMap = map[string]string{"0": "root"}
+	amg = &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1300, Data: "syscall=59 uid=0 gid=27"})
+	assert.Equal(t, map[string]string{"0": "root"}, amg.UidMap)
+	assert.Equal(t, map[string]string{"27": "sudo"}, amg.GidMap)
+}
+
+func Benchmark_getUsername(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = getUsername(context.Background(), "0")
+	}
+}
+
+func Test_parseAuditHeader_errors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"hi", "Header is too short"},
+		{"auditx(10000001:1): hi", "Header does not start with audit("},
+		{"audit(10000001:x): hi", "Could not parse the sequence. Error: strconv.Atoi: parsing \"x\": invalid syntax"},
+		{"", "Header is too short"},
+		{")", "Header is too short"},
+		{"audit()", "Header is too short"},
+		{"audit(1)", "Header is missing the sequence separator"},
+		{"audit(100000001): hi", "Header is missing the sequence separator"},
+		{"audit(:1): hi", "Header has an invalid timestamp"},
+		{"audit(1x:1): hi", "Header has an invalid timestamp"},
+		{"audit(1:): hi", "Could not parse the sequence. Error: strconv.Atoi: parsing \"\": invalid syntax"},
+		{"audit(1:-1): hi", "Header has a negative sequence"},
+		{"audit(1:1:1): hi", "Could not parse the sequence. Error: strconv.Atoi: parsing \"1:1\": invalid syntax"},
+		{"\x00\xff\x01audit(1:1)", "Header does not start with audit("},
+		{"audit(1.2.3\xff:1)", "Header has an invalid timestamp"},
+	}
+
+	for _, test := range tests {
+		msg := &syscall.NetlinkMessage{Data: []byte(test.data)}
+		time, seq, err := parseAuditHeader(msg)
+		assert.EqualError(t, err, test.err)
+		assert.Equal(t, "", time)
+		assert.Equal(t, 0, seq)
+		assert.Equal(t, test.data, string(msg.Data), "The data should be left alone")
+	}
+}
+
+func Test_parseAuditHeader_truncated(t *testing.T) {
+	// Nothing after the header
+	for _, data := range []string{"audit(10000001.123:42)", "audit(10000001.123:42):", "audit(10000001.123:42): "} {
+		msg := &syscall.NetlinkMessage{Data: []byte(data)}
+		time, seq, err := parseAuditHeader(msg)
+		assert.Nil(t, err, data)
+		assert.Equal(t, "10000001.123", time)
+		assert.Equal(t, 42, seq)
+		assert.Equal(t, "", string(msg.Data))
+	}
+}
+
+func Test_parseAuditHeader_garbage(t *testing.T) {
+	// Random bytes with and without a plausible start should never panic
+	r := rand.New(rand.NewSource(1))
+	alphabet := []byte("audit():0123456789. \x00\xff")
+
+	for i := 0; i < 10000; i++ {
+		b := make([]byte, r.Intn(32))
+		for j := range b {
+			if r.Intn(4) == 0 {
+				b[j] = byte(r.Intn(256))
+			} else {
+				b[j] = alphabet[r.Intn(len(alphabet))]
+			}
+		}
+
+		if r.Intn(2) == 0 {
+			b = append([]byte("audit("), b...)
+		}
+
+		assert.NotPanics(t, func() {
+			NewAuditMessage(&syscall.NetlinkMessage{Data: b})
+		}, "%q", b)
+	}
+}
+
+func Test_parseAuditTime(t *testing.T) {
+	tests := []struct {
+		ts string
+		ms int64
+		ok bool
+	}{
+		{"10000001.123", 10000001123, true},
+		{"10000001.001", 10000001001, true},
+		{"10000001", 10000001000, true},
+		{"10000001.1", 10000001100, true},
+		{"10000001.123456", 10000001123, true},
+		{"1533589452.999", 1533589452999, true},
+		{"", 0, false},
+		{"ok", 0, false},
+		{".123", 0, false},
+		{"1.12x", 0, false},
+	}
+
+	for _, test := range tests {
+		ts, ok := parseAuditTime(test.ts)
+		assert.Equal(t, test.ok, ok, test.ts)
+		if ok {
+			assert.Equal(t, test.ms, ts.UnixNano()/int64(time.Millisecond), test.ts)
+		}
+	}
+}
+
+func Test_loadTimezone(t *testing.T) {
+	loc, err := loadTimezone("")
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, loc)
+
+	loc, err = loadTimezone("UTC")
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, loc)
+
+	loc, err = loadTimezone("local")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Local, loc)
+
+	_, err = loadTimezone("Not/AZone")
+	assert.EqualError(t, err, "Unknown output.timezone `Not/AZone`. Error: unknown time zone Not/AZone")
+}
+
+func TestAuditMessageGroup_findSyscall(t *testing.T) {
+	uidMap = make(map[string]string, 0)
+
+	// Userspace events have no SYSCALL record
+	amg := NewAuditMessageGroup(context.Background(), &AuditMessage{Type: 1100, Data: "pid=1 msg='op=PAM:authentication'"})
+	assert.Equal(t, "", amg.Syscall)
+	assert.Nil(t, amg.Syscalls)
+
+	amg = NewAuditMessageGroup(context.Background(), &AuditMessage{Type: 1300, Data: `arch=c000003e syscall=59 success=yes key="exec"`})
+	assert.Equal(t, "59", amg.Syscall)
+	assert.Equal(t, "execve", amg.SyscallName)
+	assert.Equal(t, []string{"exec"}, amg.Keys)
+	assert.Nil(t, amg.Syscalls)
+
+	// Duplicates keep the first syscall and list all of them
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1300, Data: `arch=c000003e syscall=2 success=yes key="open"`})
+	assert.Equal(t, "59", amg.Syscall)
+	assert.Equal(t, []string{"59", "2"}, amg.Syscalls)
+	assert.Equal(t, []string{"exec"}, amg.Keys)
+
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1300, Data: "arch=c000003e syscall=59"})
+	assert.Equal(t, "59", amg.Syscall)
+	assert.Equal(t, "execve", amg.SyscallName)
+	assert.Equal(t, []string{"59", "2", "59"}, amg.Syscalls)
+
+	// The arch decides the name
+	amg = NewAuditMessageGroup(context.Background(), &AuditMessage{Type: 1300, Data: "arch=c00000b7 syscall=221 success=yes"})
+	assert.Equal(t, "execve", amg.SyscallName)
+
+	amg = NewAuditMessageGroup(context.Background(), &AuditMessage{Type: 1300, Data: "syscall=59 success=yes"})
+	assert.Equal(t, "59", amg.Syscall)
+	assert.Equal(t, "", amg.SyscallName)
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"sort"
+	"strconv"
+)
+
+// AuditPath is a PATH record, the file or directory a syscall touched
+type AuditPath struct {
+	Item     int      `json:"item"`
+	Name     string   `json:"name,omitempty"`
+	Inode    string   `json:"inode,omitempty"`
+	Dev      string   `json:"dev,omitempty"`
+	Mode     string   `json:"mode,omitempty"` // Like ls -l, -rw-r--r--
+	Ouid     string   `json:"ouid,omitempty"`
+	Owner    string   `json:"owner,omitempty"` // ouid as a username
+	Ogid     string   `json:"ogid,omitempty"`
+	Nametype string   `json:"nametype,omitempty"`
+	CapFp    []string `json:"cap_fp,omitempty"` // The permitted and inheritable file capabilities, like CAP_NET_RAW
+	CapFi    []string `json:"cap_fi,omitempty"`
+}
+
+// Adds a PATH record to Paths, kept in item order. Run after mapUids so ouid has a username
+func (amg *AuditMessageGroup) addPath(am *AuditMessage) {
+	fields := parseFields(am.Data)
+	item, err := strconv.Atoi(fields["item"])
+	if err != nil {
+		return
+	}
+
+	p := &AuditPath{
+		Item:     item,
+		Name:     fields["name"],
+		Inode:    fields["inode"],
+		Dev:      fields["dev"],
+		Ouid:     fields["ouid"],
+		Ogid:     fields["ogid"],
+		Nametype: fields["nametype"],
+	}
+
+	if mode, err := strconv.ParseUint(fields["mode"], 8, 32); err == nil {
+		p.Mode = formatMode(uint32(mode))
+	}
+
+	// Nearly every file has no capabilities, empty sets are left out
+	if caps, _ := parseCapabilities(fields["cap_fp"]); len(caps) > 0 {
+		p.CapFp = caps
+	}
+
+	if caps, _ := parseCapabilities(fields["cap_fi"]); len(caps) > 0 {
+		p.CapFi = caps
+	}
+
+	if p.Ouid != "" {
+		p.Owner = amg.UidMap[p.Ouid]
+	}
+
+	// (null) is what the kernel logs when it had no name
+	if p.Name == "(null)" {
+		p.Name = ""
+	}
+
+	i := sort.Search(len(amg.Paths), func(i int) bool { return amg.Paths[i].Item > item })
+	amg.Paths = append(amg.Paths, nil)
+	copy(amg.Paths[i+1:], amg.Paths[i:])
+	amg.Paths[i] = p
+}
+
+// Formats a st_mode like ls -l does
+func formatMode(mode uint32) string {
+	b := []byte("?rwxrwxrwx")
+
+	switch mode & 0170000 {
+	case 0100000:
+		b[0] = '-'
+	case 0040000:
+		b[0] = 'd'
+	case 0120000:
+		b[0] = 'l'
+	case 0020000:
+		b[0] = 'c'
+	case 0060000:
+		b[0] = 'b'
+	case 0010000:
+		b[0] = 'p'
+	case 0140000:
+		b[0] = 's'
+	}
+
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+
+	// setuid, setgid and sticky replace the execute bits, upper case when the execute bit is not set
+	special := []struct {
+		bit uint32
+		pos int
+		c   byte
+	}{{04000, 3, 's'}, {02000, 6, 's'}, {01000, 9, 't'}}
+	for _, s := range special {
+		if mode&s.bit == 0 {
+			continue
+		}
+
+		if b[s.pos] == '-' {
+			b[s.pos] = s.c - 'a' + 'A'
+		} else {
+			b[s.pos] = s.c
+		}
+	}
+
+	return string(b)
+}
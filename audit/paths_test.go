@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMessageGroup_addPath(t *testing.T) {
+	uidMap = map[string]string{"0": "root", "1000": "alice"}
+	gidMap = map[string]string{"0": "root"}
+
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1302, Data: `item=1 name="/lib64/ld-linux-x86-64.so.2" inode=1836 dev=fd:00 mode=0100755 ouid=0 ogid=0 rdev=00:00 nametype=NORMAL cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0`})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1302, Data: `item=3 name="/usr/bin/ping" inode=9 dev=fd:00 mode=0100755 ouid=0 ogid=0 nametype=NORMAL cap_fp=2000 cap_fi=0 cap_fe=1 cap_fver=2`})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1302, Data: `item=0 name="/home/alice/run.sh" inode=42 dev=fd:00 mode=0104750 ouid=1000 ogid=0 rdev=00:00 nametype=NORMAL`})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1302, Data: `item=2 name=(null) inode=7 dev=fd:00 mode=040755 ouid=0 ogid=0 nametype=PARENT`})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1302, Data: `item=nope name="/x"`})
+
+	assert.Equal(t, []*AuditPath{
+		{Item: 0, Name: "/home/alice/run.sh", Inode: "42", Dev: "fd:00", Mode: "-rwsr-x---", Ouid: "1000", Owner: "alice", Ogid: "0", Nametype: "NORMAL"},
+		{Item: 1, Name: "/lib64/ld-linux-x86-64.so.2", Inode: "1836", Dev: "fd:00", Mode: "-rwxr-xr-x", Ouid: "0", Owner: "root", Ogid: "0", Nametype: "NORMAL"},
+		{Item: 2, Inode: "7", Dev: "fd:00", Mode: "drwxr-xr-x", Ouid: "0", Owner: "root", Ogid: "0", Nametype: "PARENT"},
+		{Item: 3, Name: "/usr/bin/ping", Inode: "9", Dev: "fd:00", Mode: "-rwxr-xr-x", Ouid: "0", Owner: "root", Ogid: "0", Nametype: "NORMAL", CapFp: []string{"CAP_NET_RAW"}},
+	}, amg.Paths)
+	assert.Equal(t, map[string]string{"0": "root", "1000": "alice"}, amg.UidMap)
+	assert.Equal(t, map[string]string{"0": "root"}, amg.GidMap)
+}
+
+func Test_formatMode(t *testing.T) {
+	assert.Equal(t, "-rw-r--r--", formatMode(0100644))
+	assert.Equal(t, "drwxrwxrwt", formatMode(041777))
+	assert.Equal(t, "drwxr-sr-x", formatMode(042755))
+	assert.Equal(t, "-rwSr--r--", formatMode(0104644))
+	assert.Equal(t, "lrwxrwxrwx", formatMode(0120777))
+	assert.Equal(t, "srw-rw----", formatMode(0140660))
+	assert.Equal(t, "crw-rw-rw-", formatMode(020666))
+	assert.Equal(t, "brw-rw----", formatMode(060660))
+	assert.Equal(t, "prw-------", formatMode(010600))
+	assert.Equal(t, "?---------", formatMode(0))
+}
@@ -0,0 +1,350 @@
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// Just enough of the protobuf wire format to encode message groups, the schema is examples/grpc/go-audit.proto
+// Field numbers here must match it, only add new ones there and never reuse a number
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+func init() {
+	RegisterMarshaler("protobuf", func(config *viper.Viper) (Marshaler, error) {
+		return &ProtobufMarshaler{}, nil
+	})
+}
+
+// ProtobufMarshaler encodes each group as a goaudit.v1.AuditMessageGroup, prefixed with its length as a varint
+// so a file or stream of them can be read back one at a time, like parseDelimitedFrom in the protobuf libraries
+type ProtobufMarshaler struct{}
+
+func (p *ProtobufMarshaler) Marshal(msg *AuditMessageGroup) ([]byte, error) {
+	b, err := encodeProtoGroup(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(binary.AppendUvarint(nil, uint64(len(b))), b...), nil
+}
+
+// protoBuffer builds an encoded message, fields left at their zero value are not written like proto3 does
+type protoBuffer struct {
+	b []byte
+}
+
+func (p *protoBuffer) tag(field int, wireType int) {
+	p.b = binary.AppendUvarint(p.b, uint64(field<<3|wireType))
+}
+
+func (p *protoBuffer) uint(field int, v uint64) {
+	if v != 0 {
+		p.tag(field, protoVarint)
+		p.b = binary.AppendUvarint(p.b, v)
+	}
+}
+
+// sint64, zigzag encoded so negative numbers stay small
+func (p *protoBuffer) sint(field int, v int64) {
+	if v != 0 {
+		p.tag(field, protoVarint)
+		p.b = binary.AppendUvarint(p.b, uint64(v<<1^v>>63))
+	}
+}
+
+func (p *protoBuffer) double(field int, v float64) {
+	if v != 0 {
+		p.tag(field, protoFixed64)
+		p.b = binary.LittleEndian.AppendUint64(p.b, math.Float64bits(v))
+	}
+}
+
+func (p *protoBuffer) bool(field int, v bool) {
+	if v {
+		p.uint(field, 1)
+	}
+}
+
+func (p *protoBuffer) bytes(field int, v []byte) {
+	if len(v) > 0 {
+		p.tag(field, protoBytes)
+		p.b = binary.AppendUvarint(p.b, uint64(len(v)))
+		p.b = append(p.b, v...)
+	}
+}
+
+func (p *protoBuffer) string(field int, v string) {
+	p.bytes(field, []byte(v))
+}
+
+func (p *protoBuffer) strings(field int, v []string) {
+	for _, s := range v {
+		// An empty string in a list still takes its place
+		p.tag(field, protoBytes)
+		p.b = binary.AppendUvarint(p.b, uint64(len(s)))
+		p.b = append(p.b, s...)
+	}
+}
+
+// Writes a nested message, it is written even if empty so optional messages can be told apart from missing ones
+func (p *protoBuffer) message(field int, encode func(m *protoBuffer)) {
+	m := &protoBuffer{}
+	encode(m)
+
+	p.tag(field, protoBytes)
+	p.b = binary.AppendUvarint(p.b, uint64(len(m.b)))
+	p.b = append(p.b, m.b...)
+}
+
+// map<string, string>, entries are sorted so the same group always encodes the same way
+func (p *protoBuffer) stringMap(field int, v map[string]string) {
+	for _, k := range sortedKeys(v) {
+		p.message(field, func(m *protoBuffer) {
+			m.string(1, k)
+			m.string(2, v[k])
+		})
+	}
+}
+
+func sortedKeys[V any](v map[string]V) []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Encodes a group as goaudit.v1.AuditMessageGroup, without the length prefix
+func encodeProtoGroup(msg *AuditMessageGroup) ([]byte, error) {
+	p := &protoBuffer{}
+	p.uint(1, SCHEMA_VERSION)
+	p.uint(2, uint64(msg.Seq))
+	p.string(3, msg.AuditTime)
+	p.sint(4, msg.TimestampMs)
+
+	for _, m := range msg.Msgs {
+		p.message(5, func(e *protoBuffer) {
+			e.uint(1, uint64(m.Type))
+			// bytes instead of string, data is not always valid utf8
+			e.string(2, m.Data)
+			e.stringMap(3, m.Fields)
+		})
+	}
+
+	p.stringMap(6, msg.UidMap)
+	p.stringMap(7, msg.GidMap)
+
+	for _, k := range sortedKeys(msg.Uids) {
+		u := msg.Uids[k]
+		p.message(8, func(e *protoBuffer) {
+			e.string(1, k)
+			e.message(2, func(n *protoBuffer) {
+				n.string(1, u.ID)
+				n.string(2, u.Name)
+			})
+		})
+	}
+
+	p.string(9, msg.Syscall)
+	p.string(10, msg.SyscallName)
+	p.stringMap(11, msg.SyscallArgs)
+	p.strings(12, msg.Syscalls)
+	p.strings(13, msg.Keys)
+
+	if msg.Success != nil {
+		// Wrapped so false can be told apart from a group without a SYSCALL record
+		p.message(14, func(e *protoBuffer) { e.bool(1, *msg.Success) })
+	}
+
+	if msg.Exit != nil {
+		p.message(15, func(e *protoBuffer) { e.sint(1, *msg.Exit) })
+	}
+
+	p.string(16, msg.ExitErrno)
+	p.string(17, msg.SocketPath)
+
+	if s := msg.Sockaddr; s != nil {
+		p.message(18, func(e *protoBuffer) {
+			e.string(1, s.Family)
+			e.string(2, s.Address)
+			e.uint(3, uint64(s.Port))
+			e.uint(4, uint64(s.Pid))
+			e.uint(5, uint64(s.Groups))
+			e.string(6, s.Protocol)
+			e.sint(7, int64(s.Ifindex))
+		})
+	}
+
+	p.strings(19, msg.Argv)
+	p.string(20, msg.Proctitle)
+
+	for _, path := range msg.Paths {
+		p.message(21, func(e *protoBuffer) {
+			e.uint(1, uint64(path.Item))
+			e.string(2, path.Name)
+			e.string(3, path.Inode)
+			e.string(4, path.Dev)
+			e.string(5, path.Mode)
+			e.string(6, path.Ouid)
+			e.string(7, path.Owner)
+			e.string(8, path.Ogid)
+			e.string(9, path.Nametype)
+			e.strings(10, path.CapFp)
+			e.strings(11, path.CapFi)
+		})
+	}
+
+	if m := msg.MAC; m != nil {
+		p.message(22, func(e *protoBuffer) {
+			e.string(1, m.System)
+			e.string(2, m.Result)
+			e.string(3, m.Operation)
+			e.strings(4, m.Permissions)
+			e.string(5, m.Denied)
+			e.string(6, m.Name)
+			e.string(7, m.Profile)
+			e.string(8, m.Scontext)
+			e.string(9, m.Tcontext)
+			e.string(10, m.Tclass)
+			e.bool(11, m.Permissive)
+		})
+	}
+
+	if s := msg.Seccomp; s != nil {
+		p.message(23, func(e *protoBuffer) {
+			e.string(1, s.Syscall)
+			e.string(2, s.SyscallName)
+			e.string(3, s.Signal)
+			e.string(4, s.Action)
+			e.sint(5, int64(s.Errno))
+		})
+	}
+
+	for _, k := range sortedKeys(msg.Capabilities) {
+		p.message(24, func(e *protoBuffer) {
+			e.string(1, k)
+			e.message(2, func(l *protoBuffer) { l.strings(1, msg.Capabilities[k]) })
+		})
+	}
+
+	if u := msg.UserEvent; u != nil {
+		p.message(25, func(e *protoBuffer) {
+			e.string(1, u.Type)
+			e.string(2, u.Op)
+			e.string(3, u.Acct)
+			e.string(4, u.ID)
+			e.string(5, u.IDName)
+			e.string(6, u.Cmd)
+			e.string(7, u.Exe)
+			e.string(8, u.Hostname)
+			e.string(9, u.Addr)
+			e.string(10, u.Terminal)
+			e.string(11, u.Result)
+		})
+	}
+
+	// Enrichers can add anything, it is kept as json
+	if len(msg.Extra) > 0 {
+		b, err := json.Marshal(msg.Extra)
+		if err != nil {
+			return nil, err
+		}
+		p.bytes(26, b)
+	}
+
+	if a := msg.Alert; a != nil {
+		p.message(27, func(e *protoBuffer) {
+			e.string(1, a.Rule)
+			e.string(2, a.Severity)
+			e.stringMap(3, a.Tags)
+		})
+	}
+
+	if t := msg.Tamper; t != nil {
+		p.message(28, func(e *protoBuffer) {
+			e.string(1, t.Change)
+			e.string(2, t.Value)
+			e.string(3, t.Old)
+			e.string(4, t.Result)
+			e.stringMap(5, t.Actor)
+		})
+	}
+
+	if l := msg.Latency; l != nil {
+		p.message(29, func(e *protoBuffer) {
+			e.double(1, l.Receive)
+			e.double(2, l.Assemble)
+			e.double(3, l.Process)
+		})
+	}
+
+	p.string(30, msg.Source)
+	p.bool(31, msg.ParseError)
+	p.string(32, msg.ParseErrorReason)
+
+	if d := msg.Dedup; d != nil {
+		p.message(33, func(e *protoBuffer) {
+			e.uint(1, uint64(d.Count))
+			e.string(2, d.Last)
+		})
+	}
+
+	return p.b, nil
+}
+
+// Reads a varint field out of an encoded message, 0 if it is not there
+func protoUint(b []byte, field int) uint64 {
+	var v uint64
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return v
+		}
+		b = b[n:]
+
+		switch tag & 7 {
+		case protoVarint:
+			x, n := binary.Uvarint(b)
+			if n <= 0 {
+				return v
+			}
+			if int(tag>>3) == field {
+				v = x
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return v
+			}
+			b = b[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return v
+			}
+			b = b[n+int(size):]
+		case protoFixed32:
+			if len(b) < 4 {
+				return v
+			}
+			b = b[4:]
+		default:
+			return v
+		}
+	}
+
+	return v
+}
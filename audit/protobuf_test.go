@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A decoded field, v is the value of varint and fixed64 fields, b the contents of bytes fields
+type testProtoField struct {
+	field int
+	v     uint64
+	b     []byte
+}
+
+func decodeTestProto(t *testing.T, b []byte) []testProtoField {
+	var fields []testProtoField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		assert.True(t, n > 0)
+		b = b[n:]
+
+		f := testProtoField{field: int(tag >> 3)}
+		switch tag & 7 {
+		case protoVarint:
+			f.v, n = binary.Uvarint(b)
+			assert.True(t, n > 0)
+			b = b[n:]
+		case protoFixed64:
+			f.v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			assert.True(t, n > 0)
+			f.b = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatalf("Unexpected wire type %d", tag&7)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// The fields with a number, in order
+func testProtoFields(fields []testProtoField, field int) []testProtoField {
+	var out []testProtoField
+	for _, f := range fields {
+		if f.field == field {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func TestProtobufMarshaler_Marshal(t *testing.T) {
+	success := false
+	exit := int64(-13)
+	msg := &AuditMessageGroup{
+		Seq:         12,
+		AuditTime:   "1500000000.123",
+		TimestampMs: 1500000000123,
+		Msgs: []*AuditMessage{
+			{Type: 1300, Data: "arch=c000003e syscall=2"},
+			{Type: 1307, Data: "cwd=\"/\xff\""},
+		},
+		UidMap:       map[string]string{"0": "root", "1000": "alice"},
+		Uids:         map[string]*UidName{"auid": {ID: "1000", Name: "alice"}},
+		Syscall:      "2",
+		Keys:         []string{"passwd", ""},
+		Success:      &success,
+		Exit:         &exit,
+		Sockaddr:     &SocketAddress{Family: "inet", Address: "10.0.0.1", Port: 443},
+		Paths:        []*AuditPath{{Item: 0, Name: "/etc/passwd"}},
+		Capabilities: map[string][]string{"cap_pe": {"CAP_CHOWN", "CAP_KILL"}},
+		Extra:        map[string]interface{}{"host": "web1"},
+		Latency:      &EventLatency{Receive: 1.5},
+		Dedup:        &DedupSummary{Count: 3, Last: "1500000000.456"},
+	}
+
+	b, err := (&ProtobufMarshaler{}).Marshal(msg)
+	assert.Nil(t, err)
+
+	// Length prefixed
+	size, n := binary.Uvarint(b)
+	assert.Equal(t, uint64(len(b)-n), size)
+
+	fields := decodeTestProto(t, b[n:])
+	assert.Equal(t, uint64(SCHEMA_VERSION), testProtoFields(fields, 1)[0].v)
+	assert.Equal(t, uint64(12), testProtoFields(fields, 2)[0].v)
+	assert.Equal(t, "1500000000.123", string(testProtoFields(fields, 3)[0].b))
+	assert.Equal(t, uint64(1500000000123*2), testProtoFields(fields, 4)[0].v)
+
+	msgs := testProtoFields(fields, 5)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, []testProtoField{{field: 1, v: 1307}, {field: 2, b: []byte("cwd=\"/\xff\"")}}, decodeTestProto(t, msgs[1].b))
+
+	// Map entries are sorted by key
+	uidMap := testProtoFields(fields, 6)
+	assert.Len(t, uidMap, 2)
+	assert.Equal(t, []testProtoField{{field: 1, b: []byte("0")}, {field: 2, b: []byte("root")}}, decodeTestProto(t, uidMap[0].b))
+
+	uids := decodeTestProto(t, testProtoFields(fields, 8)[0].b)
+	assert.Equal(t, "auid", string(uids[0].b))
+	assert.Equal(t, []testProtoField{{field: 1, b: []byte("1000")}, {field: 2, b: []byte("alice")}}, decodeTestProto(t, uids[1].b))
+
+	assert.Equal(t, "2", string(testProtoFields(fields, 9)[0].b))
+	assert.Empty(t, testProtoFields(fields, 10))
+
+	// Empty strings keep their place in lists
+	keys := testProtoFields(fields, 13)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "", string(keys[1].b))
+
+	// false is still set, it is wrapped
+	assert.Equal(t, 0, len(testProtoFields(fields, 14)[0].b))
+	assert.Equal(t, []testProtoField{{field: 1, v: 25}}, decodeTestProto(t, testProtoFields(fields, 15)[0].b))
+
+	assert.Equal(t, []testProtoField{
+		{field: 1, b: []byte("inet")},
+		{field: 2, b: []byte("10.0.0.1")},
+		{field: 3, v: 443},
+	}, decodeTestProto(t, testProtoFields(fields, 18)[0].b))
+
+	assert.Equal(t, []testProtoField{{field: 2, b: []byte("/etc/passwd")}}, decodeTestProto(t, testProtoFields(fields, 21)[0].b))
+
+	caps := decodeTestProto(t, testProtoFields(fields, 24)[0].b)
+	assert.Equal(t, "cap_pe", string(caps[0].b))
+	assert.Equal(t, []testProtoField{{field: 1, b: []byte("CAP_CHOWN")}, {field: 1, b: []byte("CAP_KILL")}}, decodeTestProto(t, caps[1].b))
+
+	assert.Equal(t, `{"host":"web1"}`, string(testProtoFields(fields, 26)[0].b))
+	assert.Equal(t, []testProtoField{{field: 1, v: math.Float64bits(1.5)}}, decodeTestProto(t, testProtoFields(fields, 29)[0].b))
+	assert.Equal(t, []testProtoField{{field: 1, v: 3}, {field: 2, b: []byte("1500000000.456")}}, decodeTestProto(t, testProtoFields(fields, 33)[0].b))
+
+	// Nothing else was set
+	assert.Empty(t, testProtoFields(fields, 22))
+	assert.Empty(t, testProtoFields(fields, 27))
+	assert.Empty(t, testProtoFields(fields, 31))
+}
+
+func Test_protoUint(t *testing.T) {
+	p := &protoBuffer{}
+	p.string(2, "skipped")
+	p.double(3, 1)
+	p.uint(1, 300)
+
+	assert.Equal(t, uint64(300), protoUint(p.b, 1))
+	assert.Equal(t, uint64(0), protoUint(p.b, 4))
+	assert.Equal(t, uint64(0), protoUint([]byte{0xff}, 1))
+}
@@ -0,0 +1,293 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Default and maximum number of events returned by a single query
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 10000
+)
+
+// recentEvents keeps the last events in memory so they can be queried locally, see `query_api` in the example config
+// Events are stored as json along with the few fields that can be queried on
+type recentEvents struct {
+	maxEvents int
+	maxBytes  int
+
+	mu     sync.Mutex
+	events []*recentEvent // Ring buffer, next is the oldest once it is full
+	next   int
+	count  int
+	bytes  int
+}
+
+type recentEvent struct {
+	time  time.Time
+	users map[string]bool // uid and auid, both as numbers and names
+	exe   string
+	json  []byte
+}
+
+func newRecentEvents(maxEvents, maxBytes int) *recentEvents {
+	return &recentEvents{
+		maxEvents: maxEvents,
+		maxBytes:  maxBytes,
+		events:    make([]*recentEvent, maxEvents),
+	}
+}
+
+// queryServer serves recent events over http on a unix socket, peers are checked against uids like the control socket
+type queryServer struct {
+	path string
+	uids map[uint32]bool
+	http *http.Server
+}
+
+// Creates the query api, returns nil if it is not enabled
+func createQueryAPI(config *viper.Viper) (*recentEvents, *queryServer, error) {
+	if !config.GetBool("query_api.enabled") {
+		return nil, nil, nil
+	}
+
+	// Events contain everything audit sees, a tcp port would let any local user read them
+	if config.IsSet("query_api.address") {
+		return nil, nil, errors.New("query_api.address is no longer supported, use query_api.socket")
+	}
+
+	path := config.GetString("query_api.socket")
+	if path == "" {
+		return nil, nil, errors.New("query_api.socket must be set")
+	}
+
+	uids, err := parseAllowedUids(config, "query_api.allowed_uids")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	maxEvents := config.GetInt("query_api.max_events")
+	if maxEvents < 1 {
+		return nil, nil, fmt.Errorf("query_api.max_events must be greater than 0, %v provided", maxEvents)
+	}
+
+	r := newRecentEvents(maxEvents, config.GetInt("query_api.max_bytes"))
+	mux := http.NewServeMux()
+	mux.Handle("/events", r)
+
+	return r, &queryServer{path: path, uids: uidSet(uids), http: &http.Server{Handler: mux}}, nil
+}
+
+// Opens the socket and serves until it fails
+func (s *queryServer) ListenAndServe() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	return s.http.Serve(ln)
+}
+
+func (s *queryServer) listen() (net.Listener, error) {
+	ln, err := listenOwnerUnix(s.path, "query api")
+	if err != nil {
+		return nil, err
+	}
+
+	return &peerListener{UnixListener: ln, uids: s.uids}, nil
+}
+
+// peerListener only hands out connections from allowed uids
+type peerListener struct {
+	*net.UnixListener
+	uids map[uint32]bool
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUid(conn)
+		if err == nil && l.uids[uid] {
+			return conn, nil
+		}
+
+		el.Printf("Refused query api connection from uid %d. Error: %v\n", uid, err)
+		conn.Close()
+	}
+}
+
+// Adds an event, meant to be used as a Client subscriber
+func (r *recentEvents) add(msg *AuditMessageGroup) {
+	// Same shape as the json output
+	b, err := (&JSONMarshaler{}).Marshal(msg)
+	if err != nil {
+		el.Printf("Failed to keep sequence %d for the query api. Error: %s\n", msg.Seq, err)
+		return
+	}
+	b = bytes.TrimSuffix(b, []byte{'\n'})
+
+	e := &recentEvent{json: b, users: map[string]bool{}}
+	if t, ok := parseAuditTime(msg.AuditTime); ok {
+		e.time = t
+	} else {
+		e.time = time.Now()
+	}
+
+	if data, ok := msg.firstMessage(1300); ok {
+		fields := parseFields(data)
+		e.exe = fields["exe"]
+		for _, k := range []string{"uid", "auid"} {
+			if uid, ok := fields[k]; ok {
+				e.users[uid] = true
+				if name, ok := msg.UidMap[uid]; ok {
+					e.users[name] = true
+				}
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count == r.maxEvents {
+		r.drop()
+	}
+
+	// Make room if a byte limit is set, a single event bigger than the limit is still kept
+	for r.maxBytes > 0 && r.count > 0 && r.bytes+len(b) > r.maxBytes {
+		r.drop()
+	}
+
+	r.events[(r.next+r.count)%r.maxEvents] = e
+	r.count++
+	r.bytes += len(b)
+}
+
+// Drops the oldest event
+func (r *recentEvents) drop() {
+	r.bytes -= len(r.events[r.next].json)
+	r.events[r.next] = nil
+	r.next = (r.next + 1) % r.maxEvents
+	r.count--
+}
+
+// recentQuery filters events, every condition that is set must match
+type recentQuery struct {
+	since time.Time
+	until time.Time
+	user  string
+	exe   string // A glob, see path.Match
+	limit int
+}
+
+func (q *recentQuery) matches(e *recentEvent) bool {
+	if !q.since.IsZero() && e.time.Before(q.since) {
+		return false
+	}
+
+	if !q.until.IsZero() && e.time.After(q.until) {
+		return false
+	}
+
+	if q.user != "" && !e.users[q.user] {
+		return false
+	}
+
+	if q.exe != "" {
+		if ok, _ := path.Match(q.exe, e.exe); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Finds the newest events matching the query, returned oldest first
+func (r *recentEvents) query(q *recentQuery) [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found := [][]byte{}
+	for i := r.count - 1; i >= 0 && len(found) < q.limit; i-- {
+		e := r.events[(r.next+i)%r.maxEvents]
+		if q.matches(e) {
+			found = append(found, e.json)
+		}
+	}
+
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+
+	return found
+}
+
+// Parses since and until, either RFC3339 or seconds since the epoch
+func parseQueryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(f*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
+
+func parseRecentQuery(req *http.Request) (*recentQuery, error) {
+	v := req.URL.Query()
+	q := &recentQuery{user: v.Get("user"), exe: v.Get("exe"), limit: defaultQueryLimit}
+
+	var err error
+	if q.since, err = parseQueryTime(v.Get("since")); err != nil {
+		return nil, fmt.Errorf("`since` could not be parsed; Value: `%s`", v.Get("since"))
+	}
+
+	if q.until, err = parseQueryTime(v.Get("until")); err != nil {
+		return nil, fmt.Errorf("`until` could not be parsed; Value: `%s`", v.Get("until"))
+	}
+
+	if _, err := path.Match(q.exe, ""); err != nil {
+		return nil, fmt.Errorf("`exe` could not be parsed; Value: `%s`; Error: %s", q.exe, err)
+	}
+
+	if l := v.Get("limit"); l != "" {
+		if q.limit, err = strconv.Atoi(l); err != nil || q.limit < 1 || q.limit > maxQueryLimit {
+			return nil, fmt.Errorf("`limit` must be a number between 1 and %d; Value: `%s`", maxQueryLimit, l)
+		}
+	}
+
+	return q, nil
+}
+
+// ServeHTTP answers GET /events?since=&until=&user=&exe=&limit= with a json array of events
+func (r *recentEvents) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseRecentQuery(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(append([]byte{'['}, bytes.Join(r.query(q), []byte{','})...), ']', '\n'))
+}
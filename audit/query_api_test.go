@@ -0,0 +1,209 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createQueryAPI(t *testing.T) {
+	c := viper.New()
+	r, s, err := createQueryAPI(c)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	assert.Nil(t, s)
+
+	c.Set("query_api.enabled", true)
+	c.Set("query_api.address", "127.0.0.1:8649")
+	_, _, err = createQueryAPI(c)
+	assert.EqualError(t, err, "query_api.address is no longer supported, use query_api.socket")
+
+	c = viper.New()
+	c.Set("query_api.enabled", true)
+	_, _, err = createQueryAPI(c)
+	assert.EqualError(t, err, "query_api.socket must be set")
+
+	c.Set("query_api.socket", "/tmp/go-audit.query.sock")
+	c.Set("query_api.allowed_uids", []interface{}{"nope"})
+	_, _, err = createQueryAPI(c)
+	assert.EqualError(t, err, "query_api.allowed_uids could not be parsed; Value: `nope`")
+
+	c.Set("query_api.allowed_uids", []interface{}{0, 1000})
+	_, _, err = createQueryAPI(c)
+	assert.EqualError(t, err, "query_api.max_events must be greater than 0, 0 provided")
+
+	c.Set("query_api.max_events", 10)
+	c.Set("query_api.max_bytes", 100)
+	r, s, err = createQueryAPI(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, r.maxEvents)
+	assert.Equal(t, 100, r.maxBytes)
+	assert.Equal(t, "/tmp/go-audit.query.sock", s.path)
+	assert.Equal(t, map[uint32]bool{0: true, 1000: true}, s.uids)
+}
+
+func TestQueryServer(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := viper.New()
+	c.Set("query_api.enabled", true)
+	c.Set("query_api.socket", filepath.Join(dir, "query.sock"))
+	c.Set("query_api.allowed_uids", []int{os.Getuid()})
+	c.Set("query_api.max_events", 10)
+	r, s, err := createQueryAPI(c)
+	assert.Nil(t, err)
+	r.add(queryEvent(1, "100.000", "0", "/bin/sh"))
+
+	ln, err := s.listen()
+	assert.Nil(t, err)
+	served := make(chan error)
+	go func() { served <- s.http.Serve(ln) }()
+
+	info, err := os.Stat(s.path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	get := func(path string) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}}
+		return client.Get("http://localhost/events")
+	}
+
+	res, err := get(s.path)
+	if assert.Nil(t, err) {
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Contains(t, string(b), `"sequence":1,`)
+	}
+
+	assert.Nil(t, s.http.Close())
+	assert.Equal(t, http.ErrServerClosed, <-served)
+
+	// Peers that aren't allowed are refused, by a server of their own so the uids are set before it serves
+	refusing := &queryServer{path: filepath.Join(dir, "refusing.sock"), uids: uidSet([]int{}), http: &http.Server{Handler: r}}
+	ln, err = refusing.listen()
+	assert.Nil(t, err)
+	go func() { served <- refusing.http.Serve(ln) }()
+
+	_, err = get(refusing.path)
+	assert.NotNil(t, err)
+	assert.Nil(t, refusing.http.Close())
+	<-served
+	assert.Contains(t, elb.String(), fmt.Sprintf("Refused query api connection from uid %d", os.Getuid()))
+}
+
+func queryEvent(seq int, ts, uid, exe string) *AuditMessageGroup {
+	return &AuditMessageGroup{
+		Seq:       seq,
+		AuditTime: ts,
+		Msgs:      []*AuditMessage{{Type: 1300, Data: `uid=` + uid + ` auid=1000 exe="` + exe + `"`}},
+		UidMap:    map[string]string{uid: "user" + uid, "1000": "alice"},
+	}
+}
+
+func TestRecentEvents(t *testing.T) {
+	r := newRecentEvents(3, 0)
+	r.add(queryEvent(1, "100.000", "0", "/bin/sh"))
+	r.add(queryEvent(2, "200.000", "33", "/usr/bin/curl"))
+	r.add(queryEvent(3, "300.000", "33", "/bin/bash"))
+	r.add(queryEvent(4, "400.000", "0", "/bin/sh"))
+
+	seqs := func(q *recentQuery) []string {
+		if q.limit == 0 {
+			q.limit = defaultQueryLimit
+		}
+
+		s := []string{}
+		for _, b := range r.query(q) {
+			s = append(s, string(b[len(`{"schema_version":2,"sequence":`)]))
+		}
+		return s
+	}
+
+	// The oldest was dropped
+	assert.Equal(t, []string{"2", "3", "4"}, seqs(&recentQuery{}))
+	assert.Equal(t, []string{"3", "4"}, seqs(&recentQuery{limit: 2}), "The newest should be returned")
+	assert.Equal(t, []string{"2", "3"}, seqs(&recentQuery{user: "33"}))
+	assert.Equal(t, []string{"4"}, seqs(&recentQuery{user: "user0"}))
+	assert.Equal(t, []string{"2", "3", "4"}, seqs(&recentQuery{user: "alice"}))
+	assert.Equal(t, []string{"3", "4"}, seqs(&recentQuery{exe: "/bin/*"}))
+	assert.Equal(t, []string{"3"}, seqs(&recentQuery{since: time.Unix(250, 0), until: time.Unix(300, 0)}))
+	assert.Empty(t, seqs(&recentQuery{user: "nobody"}))
+
+	// Byte limit drops the oldest until the new event fits
+	r = newRecentEvents(1, 0)
+	r.add(queryEvent(1, "100.000", "0", "/bin/sh"))
+	size := r.bytes
+
+	r = newRecentEvents(10, size*2)
+	r.add(queryEvent(1, "100.000", "0", "/bin/sh"))
+	r.add(queryEvent(2, "200.000", "0", "/bin/sh"))
+	r.add(queryEvent(3, "300.000", "0", "/bin/sh"))
+	assert.Equal(t, []string{"2", "3"}, seqs(&recentQuery{}))
+	assert.Equal(t, size*2, r.bytes)
+}
+
+func TestRecentEvents_ServeHTTP(t *testing.T) {
+	r := newRecentEvents(5, 0)
+	r.add(&AuditMessageGroup{Seq: 1, AuditTime: "100.000", Msgs: []*AuditMessage{{Type: 1300, Data: `uid=0 exe="/bin/sh"`}}})
+	r.add(&AuditMessageGroup{Seq: 2, AuditTime: "200.000", Msgs: []*AuditMessage{{Type: 1300, Data: `uid=0 exe="/bin/ls"`}}})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	get := func(query string) (int, string) {
+		resp, err := http.Get(ts.URL + "/events?" + query)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := get("since=1970-01-01T00:02:00Z")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[{\"schema_version\":2,\"sequence\":2,\"timestamp\":\"200.000\",\"messages\":[{\"type\":1300,\"data\":\"uid=0 exe=\\\"/bin/ls\\\"\"}],\"uid_map\":null}]\n", body)
+
+	code, body = get("exe=/nope")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body)
+
+	code, body = get("until=150")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"sequence":1`)
+	assert.NotContains(t, body, `"sequence":2`)
+
+	tests := map[string]string{
+		"since=yesterday": "`since` could not be parsed; Value: `yesterday`\n",
+		"until=x":         "`until` could not be parsed; Value: `x`\n",
+		"exe=[":           "`exe` could not be parsed; Value: `[`; Error: syntax error in pattern\n",
+		"limit=0":         "`limit` must be a number between 1 and 10000; Value: `0`\n",
+	}
+	for query, err := range tests {
+		code, body = get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Equal(t, err, body, query)
+	}
+
+	resp, err := http.Post(ts.URL+"/events", "text/plain", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
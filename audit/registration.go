@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Reads `audit_daemon` from the config, returns how often to check the registration and whether to take it back
+func createRegistration(config *viper.Viper) (time.Duration, bool, error) {
+	interval := config.GetDuration("audit_daemon.interval")
+	if interval < 0 {
+		return 0, false, fmt.Errorf("audit_daemon.interval must be 0 or greater, %v provided", interval)
+	}
+
+	switch action := config.GetString("audit_daemon.on_takeover"); action {
+	case "reclaim":
+		return interval, true, nil
+	case "report":
+		return interval, false, nil
+	default:
+		return 0, false, fmt.Errorf("`audit_daemon.on_takeover` could not be parsed; Value: `%s`", action)
+	}
+}
+
+// Polls the pid the kernel sends audit records to and hands it to the receive loop whenever it changes, after it is
+// no longer pid and once it is pid again
+// Another process registering as the audit daemon, like auditd starting, silently cuts go-audit off otherwise
+func watchRegistration(ctx context.Context, interval time.Duration, pid uint32, takeover chan<- uint32) {
+	n, err := dialNetlink()
+	if err != nil {
+		el.Printf("Failed to watch the audit daemon registration. Error: %s\n", err)
+		return
+	}
+	defer n.Close()
+
+	if err := n.SetReceiveTimeout(rulesTimeout); err != nil {
+		el.Printf("Failed to watch the audit daemon registration. Error: %s\n", err)
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	// Only changes are reported, a takeover once and not on every poll
+	reported := pid
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		s, err := getAuditStatus(n)
+		if err != nil {
+			el.Printf("Failed to get the audit status. Error: %s\n", err)
+			continue
+		}
+
+		if s.Pid == reported {
+			continue
+		}
+
+		reported = s.Pid
+		select {
+		case takeover <- s.Pid:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Tells the kernel to stop sending audit records to go-audit, only the registered audit daemon may
+// It uses its own socket so the records still queued on the daemon socket are not read past while waiting for the ack
+func deregister() error {
+	n, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	if err := n.SetReceiveTimeout(rulesTimeout); err != nil {
+		return err
+	}
+
+	packet := &NetlinkPacket{
+		Type:  AUDIT_SET,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Pid:   uint32(syscall.Getpid()),
+	}
+
+	_, err = n.Request(packet, &AuditStatusPayload{Mask: AUDIT_STATUS_PID}, syscall.NLMSG_ERROR)
+	return err
+}
+
+// Reports that pid took the audit daemon registration, 0 means nobody is registered
+func (a *AuditMarshaller) registrationLost(ctx context.Context, pid uint32, reclaimed bool) {
+	a.stats.Takeovers++
+
+	action := "report"
+	if reclaimed {
+		action = "reclaim"
+		el.Printf("Pid %d took over as the audit daemon, registered go-audit again\n", pid)
+	} else {
+		el.Printf("Pid %d took over as the audit daemon, go-audit is no longer receiving events\n", pid)
+	}
+
+	a.emit(ctx, newInternalEvent(EVENT_KERNEL, fmt.Sprintf("op=daemon_takeover pid=%d action=%s res=0", pid, action)))
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createRegistration(t *testing.T) {
+	c := viper.New()
+	c.Set("audit_daemon.interval", "5s")
+	c.Set("audit_daemon.on_takeover", "reclaim")
+	interval, reclaim, err := createRegistration(c)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second*5, interval)
+	assert.True(t, reclaim)
+
+	c.Set("audit_daemon.on_takeover", "report")
+	_, reclaim, err = createRegistration(c)
+	assert.Nil(t, err)
+	assert.False(t, reclaim)
+
+	c.Set("audit_daemon.on_takeover", "ignore")
+	_, _, err = createRegistration(c)
+	assert.EqualError(t, err, "`audit_daemon.on_takeover` could not be parsed; Value: `ignore`")
+
+	c.Set("audit_daemon.on_takeover", "report")
+	c.Set("audit_daemon.interval", "-1s")
+	_, _, err = createRegistration(c)
+	assert.EqualError(t, err, "audit_daemon.interval must be 0 or greater, -1s provided")
+}
+
+func TestAuditMarshaller_registrationLost(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{}, nil)
+
+	m.registrationLost(context.Background(), 1234, false)
+	assert.Equal(t, uint64(1), m.stats.Takeovers)
+	assert.Equal(t, "Pid 1234 took over as the audit daemon, go-audit is no longer receiving events\n", elb.String())
+	assert.Contains(t, w.String(), `{"type":2000,"data":"op=daemon_takeover pid=1234 action=report res=0"}`)
+
+	elb.Reset()
+	w.Reset()
+	m.registrationLost(context.Background(), 0, true)
+	assert.Equal(t, uint64(2), m.stats.Takeovers)
+	assert.Equal(t, "Pid 0 took over as the audit daemon, registered go-audit again\n", elb.String())
+	assert.Contains(t, w.String(), `"data":"op=daemon_takeover pid=0 action=reclaim res=0"`)
+}
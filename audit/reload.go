@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Re-reads the config file and applies its filters, outputs and audit rules to the running client, on SIGHUP
+// Everything is parsed and the outputs are opened before anything changes, a config with a mistake in it leaves the
+// running one in place. The rules go in before the swap, if the kernel refuses one the new outputs are closed again and
+// the running filters and outputs are kept, along with whichever rules made it in. e is nil when the rules are left to
+// the audit daemon
+func reloadConfig(ctx context.Context, file string, c *Client, e executor) error {
+	config, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+
+	remote, err := createRemoteConfig(config)
+	if err != nil {
+		return err
+	}
+
+	if remote != nil {
+		if err := remote.apply(ctx, config); err != nil {
+			el.Printf("Using the local config only. Error: %s\n", err)
+		}
+	}
+
+	filters, err := CreateFilters(config)
+	if err != nil {
+		return err
+	}
+
+	keepOnly, err := parseFilterMode(config, "filter_mode", filters)
+	if err != nil {
+		return err
+	}
+
+	if e != nil {
+		if err := checkAuditRules(config); err != nil {
+			return err
+		}
+	}
+
+	m, err := createOutputWriters(config)
+	if err != nil {
+		return err
+	}
+
+	if e != nil {
+		if err := setRules(config, e); err != nil {
+			m.Close()
+			return err
+		}
+	}
+
+	err = c.Reload(ctx, ReloadOptions{
+		Filters:  filters,
+		KeepOnly: keepOnly,
+		Writer:   m.writer(),
+		Start:    func() error { return m.startSpools(config) },
+	})
+
+	// The client never got the new outputs
+	if err != nil && err == ctx.Err() {
+		m.Close()
+		return err
+	}
+
+	if err != nil {
+		el.Printf("Failed to start the output spool. Error: %s\n", err)
+	}
+
+	l.Printf("Reloaded filters, outputs and rules from %s, other changes need a restart\n", file)
+	return nil
+}
+
+// Parses every audit rule the way netlinkExec would, without applying them
+func checkAuditRules(config *viper.Viper) error {
+	rules, err := createAuditRules(config)
+	if err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		return errors.New("No audit rules found")
+	}
+
+	for i, r := range rules {
+		if r.rule == "" {
+			continue
+		}
+
+		if _, err := parseAuditctl(strings.Fields(r.rule)); err != nil {
+			return fmt.Errorf("Failed to parse rule %s. Error: %s", r.name(i), err)
+		}
+	}
+
+	return nil
+}
+
+// Swaps in the filters and writer of a reload, see Client.Reload
+func (a *AuditMarshaller) reload(opts ReloadOptions) error {
+	a.filterList = opts.Filters
+	a.keepOnly = opts.KeepOnly
+	a.buildFilters()
+
+	// Closing flushes whatever the previous outputs buffered, their spools are left for the new ones to pick up
+	if a.writer != nil && a.writer != opts.Writer {
+		if err := a.writer.Close(); err != nil {
+			el.Printf("Error closing the previous output: %+v\n", err)
+		}
+	}
+
+	a.writer = opts.Writer
+	if opts.Start != nil {
+		return opts.Start()
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingWriter keeps every group written to it
+type recordingWriter struct {
+	msgs   []*AuditMessageGroup
+	closed bool
+}
+
+func (r *recordingWriter) Write(ctx context.Context, msg *AuditMessageGroup) error {
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func (r *recordingWriter) Flush() error  { return nil }
+func (r *recordingWriter) Healthy() bool { return !r.closed }
+
+func (r *recordingWriter) Close() error {
+	r.closed = true
+	return nil
+}
+
+func Test_checkAuditRules(t *testing.T) {
+	c := viper.New()
+	assert.EqualError(t, checkAuditRules(c), "No audit rules found")
+
+	c.Set("rules", []string{"-a exit,always -S execve -k exec", ""})
+	assert.Nil(t, checkAuditRules(c))
+
+	c.Set("rules", []string{"-a exit,always -S execve", "-a exit,sometimes -S connect"})
+	assert.EqualError(t, checkAuditRules(c), "Failed to parse rule #2. Error: Rule list and action could not be parsed; Value: `exit,sometimes`")
+}
+
+func TestAuditMarshaller_reload(t *testing.T) {
+	old, w := &recordingWriter{}, &recordingWriter{}
+	m := NewAuditMarshaller(old, 1300, 1399, false, false, 0, []AuditFilter{{syscallName: "execve"}}, nil)
+
+	started := false
+	err := m.reload(ReloadOptions{
+		Filters:  []AuditFilter{{syscallName: "connect"}},
+		KeepOnly: true,
+		Writer:   w,
+		Start: func() error {
+			assert.True(t, old.closed, "The previous writer should be closed before the new one starts")
+			started = true
+			return nil
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, started)
+	assert.True(t, m.keepOnly)
+	assert.True(t, m.dropMessage(&AuditMessageGroup{SyscallName: "execve"}))
+	assert.False(t, m.dropMessage(&AuditMessageGroup{SyscallName: "connect"}))
+
+	m.emit(context.Background(), newInternalEvent(EVENT_KERNEL, "op=test res=0"))
+	assert.Empty(t, old.msgs)
+	assert.Len(t, w.msgs, 1)
+
+	// Reloading the same writer leaves it open
+	assert.EqualError(t, m.reload(ReloadOptions{Writer: w, Start: func() error { return errors.New("derp") }}), "derp")
+	assert.False(t, w.closed)
+}
+
+func TestClient_Reload(t *testing.T) {
+	_, _ = hookLogger()
+	defer resetLogger()
+
+	old, w := &recordingWriter{}, &recordingWriter{}
+	// Unbuffered, a message is consumed before the loop looks at the context again
+	f := &fakeReceiver{msgs: make(chan *syscall.NetlinkMessage)}
+	c := NewClient(ClientOptions{Writer: old})
+	c.nl = f
+
+	// Nothing picks it up without Run
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, c.Reload(ctx, ReloadOptions{Writer: w}))
+	cancel()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	// Partially assembled groups survive the reload
+	f.msgs <- newNlMsg(1300, "audit(10000001:1): hi")
+	assert.Nil(t, c.Reload(context.Background(), ReloadOptions{Writer: w}))
+	f.msgs <- newNlMsg(1307, "audit(10000001:1): cwd=\"/\"")
+	f.msgs <- new1320("1")
+	cancel()
+	<-done
+
+	assert.True(t, old.closed)
+	assert.Empty(t, old.msgs)
+	if assert.Len(t, w.msgs, 1) {
+		assert.Len(t, w.msgs[0].Msgs, 2)
+	}
+	assert.Equal(t, w, c.Writer())
+}
+
+func Test_reloadConfig(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	u, err := user.Current()
+	assert.Nil(t, err)
+	g, err := user.LookupGroupId(u.Gid)
+	assert.Nil(t, err)
+
+	out := path.Join(t.TempDir(), "go-audit.log")
+	file := createTempFile(t, "reload.test.yaml", fmt.Sprintf(`
+rules:
+  - -a exit,always -S connect -k net
+filter_mode: keep
+filters:
+  - syscall: connect
+output:
+  file:
+    enabled: true
+    attempts: 1
+    path: %s
+    mode: 0600
+    user: %s
+    group: %s
+`, out, u.Username, g.Name))
+	defer os.Remove(file)
+
+	old := &recordingWriter{}
+	// Unbuffered, a message is consumed before the loop looks at the context again
+	f := &fakeReceiver{msgs: make(chan *syscall.NetlinkMessage)}
+	c := NewClient(ClientOptions{Writer: old})
+	c.nl = f
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	// Rules the kernel refuses leave the running filters and outputs alone
+	assert.EqualError(t, reloadConfig(context.Background(), file, c, func(name string, args ...string) error {
+		if args[0] == "-a" {
+			return errors.New("derp")
+		}
+		return nil
+	}), "Failed to add rule #1. Error: derp")
+	assert.Equal(t, old, c.Writer())
+	assert.False(t, old.closed)
+
+	var ran []string
+	assert.Nil(t, reloadConfig(context.Background(), file, c, func(name string, args ...string) error {
+		ran = append(ran, strings.Join(args, " "))
+		return nil
+	}))
+
+	f.msgs <- newNlMsg(1300, "audit(10000001:1): arch=c000003e syscall=42")
+	f.msgs <- new1320("1")
+	f.msgs <- newNlMsg(1300, "audit(10000001:2): arch=c000003e syscall=59")
+	f.msgs <- new1320("2")
+	cancel()
+	<-done
+	assert.Nil(t, c.Writer().Close())
+
+	assert.Equal(t, []string{"-D", "-a exit,always -S connect -k net"}, ran)
+	assert.True(t, old.closed)
+
+	b, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"sequence":1,`)
+	assert.NotContains(t, string(b), `"sequence":2,`, "Only connect should be kept")
+	assert.Contains(t, lb.String(), "Reloaded filters, outputs and rules from "+file)
+
+	// A broken config leaves everything alone
+	assert.Nil(t, os.WriteFile(file, []byte("rules:\n  - -a exit,sometimes -S connect\n"), 0600))
+	assert.EqualError(t, reloadConfig(context.Background(), file, c, func(string, ...string) error {
+		t.Fatal("Rules should not be applied")
+		return nil
+	}), "Failed to parse rule #1. Error: Rule list and action could not be parsed; Value: `exit,sometimes`")
+	assert.Empty(t, elb.String())
+}
@@ -0,0 +1,271 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Remote configs are small, anything bigger than this is refused
+const maxRemoteConfigSize = 10 * 1024 * 1024
+
+// remoteConfig fetches config from a url and only applies it if it carries a valid ed25519 signature
+// See `remote_config` in the example config
+type remoteConfig struct {
+	url      string
+	sigURL   string
+	key      ed25519.PublicKey
+	only     []string
+	interval time.Duration
+	client   *http.Client
+
+	applied [sha256.Size]byte
+	version int // remote_config.version of the applied config, older versions are refused
+}
+
+// Creates the remote config fetcher, returns nil if `remote_config.url` is not set
+func createRemoteConfig(config *viper.Viper) (*remoteConfig, error) {
+	url := config.GetString("remote_config.url")
+	if url == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("remote_config.url must be an https url")
+	}
+
+	sigURL := config.GetString("remote_config.signature_url")
+	if sigURL == "" {
+		sigURL = url + ".sig"
+	}
+
+	if !strings.HasPrefix(sigURL, "https://") {
+		return nil, errors.New("remote_config.signature_url must be an https url")
+	}
+
+	// The public key file holds the base64 encoded 32 byte key
+	keyFile := config.GetString("remote_config.public_key")
+	if keyFile == "" {
+		return nil, errors.New("remote_config.public_key must be set")
+	}
+
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read remote_config.public_key. Error: %s", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("remote_config.public_key must contain a base64 encoded %d byte ed25519 public key", ed25519.PublicKeySize)
+	}
+
+	tlsConfig, err := createTLSConfig(config, "remote_config")
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteConfig{
+		url:      url,
+		sigURL:   sigURL,
+		key:      ed25519.PublicKey(key),
+		only:     config.GetStringSlice("remote_config.only"),
+		interval: config.GetDuration("remote_config.interval"),
+		client: &http.Client{
+			Timeout:   config.GetDuration("remote_config.timeout"),
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Fetches the config and its signature, nothing is returned unless the signature is valid
+func (r *remoteConfig) fetch(ctx context.Context) ([]byte, error) {
+	body, err := r.get(ctx, r.url)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := r.get(ctx, r.sigURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accept raw or base64 encoded signatures
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+			return nil, fmt.Errorf("Remote config signature could not be decoded. Error: %s", err)
+		}
+	}
+
+	if !ed25519.Verify(r.key, body, sig) {
+		return nil, errors.New("Remote config signature is not valid")
+	}
+
+	return body, nil
+}
+
+func (r *remoteConfig) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %s. Error: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s. Status: %s", url, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxRemoteConfigSize))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %s. Error: %s", url, err)
+	}
+
+	return b, nil
+}
+
+// Parses a fetched config into the keys that should be applied and its version
+// remote_config itself can't be changed remotely, and if `only` is set just those sections are kept
+// The signed remote_config.version and remote_config.expires keep an old signed config from being served again
+func (r *remoteConfig) parse(body []byte) (map[string]interface{}, int, error) {
+	remote := viper.New()
+	remote.SetConfigType("yaml")
+	if err := remote.ReadConfig(bytes.NewReader(body)); err != nil {
+		return nil, 0, fmt.Errorf("Failed to parse remote config. Error: %s", err)
+	}
+
+	version, err := cast.ToIntE(remote.Get("remote_config.version"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("remote_config.version in the remote config could not be parsed. Error: %s", err)
+	}
+
+	if version < r.version {
+		return nil, 0, fmt.Errorf("Remote config version %d is older than the applied version %d", version, r.version)
+	}
+
+	// yaml turns timestamps into times already
+	if e := remote.Get("remote_config.expires"); e != nil {
+		expires, err := cast.ToTimeE(e)
+		if err != nil {
+			return nil, 0, fmt.Errorf("remote_config.expires in the remote config could not be parsed. Error: %s", err)
+		}
+
+		if time.Now().After(expires) {
+			return nil, 0, fmt.Errorf("Remote config expired at %s", expires.Format(time.RFC3339))
+		}
+	}
+
+	values := map[string]interface{}{}
+	for _, k := range remote.AllKeys() {
+		if k == "remote_config" || strings.HasPrefix(k, "remote_config.") || !r.wanted(k) {
+			continue
+		}
+
+		values[k] = remote.Get(k)
+	}
+
+	return values, version, nil
+}
+
+func (r *remoteConfig) wanted(key string) bool {
+	if len(r.only) == 0 {
+		return true
+	}
+
+	for _, o := range r.only {
+		if key == o || strings.HasPrefix(key, o+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fetches and applies the remote config on top of the local one, meant to be called once at startup
+func (r *remoteConfig) apply(ctx context.Context, config *viper.Viper) error {
+	body, err := r.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	values, version, err := r.parse(body)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range values {
+		config.Set(k, v)
+	}
+
+	r.applied = sha256.Sum256(body)
+	r.version = version
+	l.Printf("Applied %d settings from remote config %s\n", len(values), r.url)
+	return nil
+}
+
+// Checks for a new remote config every interval until the context is done
+// Audit rules are re-applied right away, any other change needs a restart and is only logged
+func (r *remoteConfig) watch(ctx context.Context, e executor) {
+	if r.interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if err := r.refresh(ctx, e); err != nil {
+			el.Println(err)
+		}
+	}
+}
+
+func (r *remoteConfig) refresh(ctx context.Context, e executor) error {
+	body, err := r.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(body)
+	if sum == r.applied {
+		return nil
+	}
+
+	values, version, err := r.parse(body)
+	if err != nil {
+		return err
+	}
+
+	r.applied = sum
+	r.version = version
+	if rules, ok := values["rules"]; ok {
+		rc := viper.New()
+		rc.Set("rules", rules)
+		if err := setRules(rc, e); err != nil {
+			return fmt.Errorf("Failed to apply rules from remote config. Error: %s", err)
+		}
+	}
+
+	l.Printf("Remote config %s changed, restart go-audit to apply changes other than rules\n", r.url)
+	return nil
+}
@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createRemoteConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	keyFile := filepath.Join(dir, "key")
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0600))
+	badKeyFile := filepath.Join(dir, "bad")
+	assert.Nil(t, ioutil.WriteFile(badKeyFile, []byte("nope"), 0600))
+
+	c := viper.New()
+	r, err := createRemoteConfig(c)
+	assert.Nil(t, err)
+	assert.Nil(t, r, "No url should disable remote config")
+
+	tests := []struct {
+		set map[string]interface{}
+		err string
+	}{
+		{map[string]interface{}{"url": "http://example.com/c.yaml"}, "remote_config.url must be an https url"},
+		{map[string]interface{}{"signature_url": "http://example.com/c.sig"}, "remote_config.signature_url must be an https url"},
+		{map[string]interface{}{}, "remote_config.public_key must be set"},
+		{map[string]interface{}{"public_key": filepath.Join(dir, "nope")}, "Failed to read remote_config.public_key. Error: open " + filepath.Join(dir, "nope") + ": no such file or directory"},
+		{map[string]interface{}{"public_key": badKeyFile}, "remote_config.public_key must contain a base64 encoded 32 byte ed25519 public key"},
+		{map[string]interface{}{"public_key": keyFile, "ca_file": badKeyFile}, "remote_config.ca_file did not contain any certificates"},
+	}
+
+	for _, test := range tests {
+		c := viper.New()
+		c.Set("remote_config.url", "https://example.com/c.yaml")
+		for k, v := range test.set {
+			c.Set("remote_config."+k, v)
+		}
+
+		_, err := createRemoteConfig(c)
+		assert.EqualError(t, err, test.err)
+	}
+
+	c.Set("remote_config.url", "https://example.com/c.yaml")
+	c.Set("remote_config.public_key", keyFile)
+	c.Set("remote_config.only", []string{"rules", "filters"})
+	r, err = createRemoteConfig(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/c.yaml.sig", r.sigURL)
+	assert.Equal(t, ed25519.PublicKey(pub), r.key)
+	assert.Equal(t, []string{"rules", "filters"}, r.only)
+}
+
+func TestRemoteConfig(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	body := []byte("rules:\n  - -a exit,always -S execve\noutput:\n  file:\n    path: /tmp/remote.log\nremote_config:\n  url: https://evil\n")
+	sig := ed25519.Sign(priv, body)
+	encoded := false
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/c.yaml":
+			w.Write(body)
+		case "/c.yaml.sig":
+			if encoded {
+				w.Write([]byte(base64.StdEncoding.EncodeToString(sig) + "\n"))
+			} else {
+				w.Write(sig)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	r := &remoteConfig{url: ts.URL + "/c.yaml", sigURL: ts.URL + "/c.yaml.sig", key: pub, client: ts.Client()}
+
+	// Everything but remote_config is applied
+	c := viper.New()
+	c.Set("remote_config.url", "https://good")
+	assert.Nil(t, r.apply(context.Background(), c))
+	assert.Equal(t, []string{"-a exit,always -S execve"}, c.GetStringSlice("rules"))
+	assert.Equal(t, "/tmp/remote.log", c.GetString("output.file.path"))
+	assert.Equal(t, "https://good", c.GetString("remote_config.url"))
+	assert.Equal(t, "Applied 2 settings from remote config "+r.url+"\n", lb.String())
+
+	// Base64 signatures work too
+	encoded = true
+	_, err = r.fetch(context.Background())
+	assert.Nil(t, err)
+
+	// Limited to some sections
+	r.only = []string{"rules"}
+	values, _, err := r.parse(body)
+	assert.Nil(t, err)
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"rules"}, keys)
+
+	// Unchanged configs are skipped on refresh, changed rules are applied
+	calls := 0
+	e := func(s string, a ...string) error {
+		calls++
+		return nil
+	}
+	assert.Nil(t, r.refresh(context.Background(), e))
+	assert.Equal(t, 0, calls)
+
+	body = []byte("rules:\n  - -a exit,always -S connect\n")
+	sig = ed25519.Sign(priv, body)
+	lb.Reset()
+	assert.Nil(t, r.refresh(context.Background(), e))
+	assert.Equal(t, 2, calls, "Flush and one rule")
+	assert.Contains(t, lb.String(), "Remote config "+r.url+" changed, restart go-audit to apply changes other than rules\n")
+
+	// Bad signatures are never applied
+	body = []byte("rules:\n  - -D\n")
+	c = viper.New()
+	assert.EqualError(t, r.apply(context.Background(), c), "Remote config signature is not valid")
+	assert.False(t, c.IsSet("rules"))
+	assert.EqualError(t, r.refresh(context.Background(), e), "Remote config signature is not valid")
+	assert.Equal(t, 2, calls)
+
+	encoded = false
+	sig = []byte("short")
+	_, err = r.fetch(context.Background())
+	assert.True(t, strings.HasPrefix(err.Error(), "Remote config signature could not be decoded. Error: "))
+
+	// Fetch errors
+	r.sigURL = ts.URL + "/nope"
+	_, err = r.fetch(context.Background())
+	assert.EqualError(t, err, "Failed to fetch "+ts.URL+"/nope. Status: 404 Not Found")
+
+	r.client = &http.Client{}
+	_, err = r.fetch(context.Background())
+	assert.Contains(t, err.Error(), "Failed to fetch "+r.url+". Error: ")
+
+	// Unparsable configs
+	_, _, err = r.parse([]byte("- nope: ["))
+	assert.Contains(t, err.Error(), "Failed to parse remote config. Error: ")
+	assert.Empty(t, elb.String())
+}
+
+func TestRemoteConfig_replay(t *testing.T) {
+	hookLogger()
+	defer resetLogger()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	var body []byte
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sig") {
+			w.Write(ed25519.Sign(priv, body))
+			return
+		}
+		w.Write(body)
+	}))
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	r := &remoteConfig{url: ts.URL + "/c.yaml", sigURL: ts.URL + "/c.yaml.sig", key: pub, client: ts.Client()}
+	calls := 0
+	e := func(s string, a ...string) error {
+		calls++
+		return nil
+	}
+
+	body = []byte("rules:\n  - -a exit,always -S execve\nremote_config:\n  version: 2\n")
+	assert.Nil(t, r.apply(context.Background(), viper.New()))
+	assert.Equal(t, 2, r.version)
+
+	// An older signed config is refused
+	body = []byte("rules:\n  - -D\nremote_config:\n  version: 1\n")
+	assert.EqualError(t, r.refresh(context.Background(), e), "Remote config version 1 is older than the applied version 2")
+	assert.Equal(t, 0, calls)
+
+	// So is one without a version, once a version was applied
+	body = []byte("rules:\n  - -D\n")
+	assert.EqualError(t, r.refresh(context.Background(), e), "Remote config version 0 is older than the applied version 2")
+
+	// And expired ones
+	body = []byte("rules:\n  - -D\nremote_config:\n  version: 3\n  expires: 2001-01-01T00:00:00Z\n")
+	assert.EqualError(t, r.refresh(context.Background(), e), "Remote config expired at 2001-01-01T00:00:00Z")
+
+	body = []byte("rules:\n  - -D\nremote_config:\n  version: 3\n  expires: nope\n")
+	assert.Contains(t, r.refresh(context.Background(), e).Error(), "remote_config.expires in the remote config could not be parsed. Error: ")
+
+	body = []byte("rules:\n  - -D\nremote_config:\n  version: nope\n")
+	assert.Contains(t, r.refresh(context.Background(), e).Error(), "remote_config.version in the remote config could not be parsed. Error: ")
+	assert.Equal(t, 0, calls)
+
+	// Newer ones are applied
+	body = []byte("rules:\n  - -a exit,always -S connect\nremote_config:\n  version: 3\n  expires: " + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + "\n")
+	assert.Nil(t, r.refresh(context.Background(), e))
+	assert.Equal(t, 2, calls, "Flush and one rule")
+	assert.Equal(t, 3, r.version)
+}
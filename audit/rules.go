@@ -0,0 +1,680 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// See http://lxr.free-electrons.com/source/include/uapi/linux/audit.h
+const (
+	AUDIT_GET        = 1000
+	AUDIT_SET        = 1001
+	AUDIT_ADD_RULE   = 1011
+	AUDIT_DEL_RULE   = 1012
+	AUDIT_LIST_RULES = 1013
+
+	AUDIT_STATUS_ENABLED           = 0x1
+	AUDIT_STATUS_FAILURE           = 0x2
+	AUDIT_STATUS_PID               = 0x4
+	AUDIT_STATUS_RATE_LIMIT        = 0x8
+	AUDIT_STATUS_BACKLOG_LIMIT     = 0x10
+	AUDIT_STATUS_BACKLOG_WAIT_TIME = 0x20
+
+	AUDIT_FILTER_PREPEND = 0x10
+	AUDIT_MAX_FIELDS     = 64
+	AUDIT_BITMASK_SIZE   = 64
+	AUDIT_MAX_KEY_LEN    = 256
+)
+
+// How long to wait for the kernel to answer a rule request
+const rulesTimeout = time.Second * 2
+
+// Rule lists by their auditctl name
+var auditRuleLists = map[string]uint32{"user": 0, "task": 1, "exit": 4, "exclude": 5, "filesystem": 6}
+
+// Rule actions by their auditctl name
+var auditRuleActions = map[string]uint32{"never": 0, "possible": 1, "always": 2}
+
+// Field comparison operators, longest first so != is not taken for =
+var auditRuleOperators = []struct {
+	op    string
+	value uint32
+}{
+	{"!=", 0x30000000}, {">=", 0x60000000}, {"<=", 0x50000000}, {"&=", 0x48000000},
+	{"=", 0x40000000}, {">", 0x20000000}, {"<", 0x10000000}, {"&", 0x08000000},
+}
+
+const auditOpEqual = 0x40000000
+
+// How the value of a rule field is parsed
+const (
+	auditValueNumber = iota
+	auditValueString
+	auditValueUid
+	auditValueGid
+	auditValueArch
+	auditValuePerm
+	auditValueExit
+	auditValueFiletype
+)
+
+// Rule fields by their auditctl name, see AUDIT_PID and friends
+var auditRuleFields = map[string]struct {
+	id   uint32
+	kind int
+}{
+	"pid": {0, auditValueNumber}, "uid": {1, auditValueUid}, "euid": {2, auditValueUid}, "suid": {3, auditValueUid},
+	"fsuid": {4, auditValueUid}, "gid": {5, auditValueGid}, "egid": {6, auditValueGid}, "sgid": {7, auditValueGid},
+	"fsgid": {8, auditValueGid}, "auid": {9, auditValueUid}, "loginuid": {9, auditValueUid},
+	"pers": {10, auditValueNumber}, "arch": {11, auditValueArch}, "msgtype": {12, auditValueNumber},
+	"subj_user": {13, auditValueString}, "subj_role": {14, auditValueString}, "subj_type": {15, auditValueString},
+	"subj_sen": {16, auditValueString}, "subj_clr": {17, auditValueString}, "ppid": {18, auditValueNumber},
+	"obj_user": {19, auditValueString}, "obj_role": {20, auditValueString}, "obj_type": {21, auditValueString},
+	"obj_lev_low": {22, auditValueString}, "obj_lev_high": {23, auditValueString},
+	"loginuid_set": {24, auditValueNumber}, "sessionid": {25, auditValueNumber}, "fstype": {26, auditValueNumber},
+	"devmajor": {100, auditValueNumber}, "devminor": {101, auditValueNumber}, "inode": {102, auditValueNumber},
+	"exit": {103, auditValueExit}, "success": {104, auditValueNumber}, "path": {105, auditValueString},
+	"perm": {106, auditValuePerm}, "dir": {107, auditValueString}, "filetype": {108, auditValueFiletype},
+	"obj_uid": {109, auditValueUid}, "obj_gid": {110, auditValueGid}, "exe": {112, auditValueString},
+	"saddr_fam": {113, auditValueNumber}, "a0": {200, auditValueNumber}, "a1": {201, auditValueNumber},
+	"a2": {202, auditValueNumber}, "a3": {203, auditValueNumber}, "key": {210, auditValueString},
+}
+
+const (
+	auditFieldWatch = 105
+	auditFieldPerm  = 106
+	auditFieldDir   = 107
+	auditFieldKey   = 210
+)
+
+// Audit arch values by the names auditctl takes for -F arch=
+var auditArchs = map[string]uint32{
+	"x86_64": 0xc000003e, "i386": 0x40000003, "i686": 0x40000003, "aarch64": 0xc00000b7, "arm": 0x40000028,
+}
+
+// What b64 and b32 mean on the machine we run on
+var nativeArchs = map[string]map[string]uint32{
+	"amd64": {"b64": 0xc000003e, "b32": 0x40000003},
+	"386":   {"b32": 0x40000003},
+	"arm64": {"b64": 0xc00000b7, "b32": 0x40000028},
+	"arm":   {"b32": 0x40000028},
+}
+
+// File types for -F filetype=
+var auditFiletypes = map[string]uint32{
+	"file": 0100000, "dir": 0040000, "socket": 0140000, "link": 0120000, "character": 0020000, "block": 0060000,
+	"fifo": 0010000,
+}
+
+// auditRuleData is struct audit_rule_data, the strings of string fields are stored one after the other in Buf
+type auditRuleData struct {
+	Flags      uint32
+	Action     uint32
+	FieldCount uint32
+	Mask       [AUDIT_BITMASK_SIZE]uint32
+	Fields     [AUDIT_MAX_FIELDS]uint32
+	Values     [AUDIT_MAX_FIELDS]uint32
+	FieldFlags [AUDIT_MAX_FIELDS]uint32
+	Buf        []byte
+}
+
+func (r *auditRuleData) addField(field, op, value uint32) error {
+	if r.FieldCount >= AUDIT_MAX_FIELDS {
+		return fmt.Errorf("Rules can't have more than %d fields", AUDIT_MAX_FIELDS)
+	}
+
+	r.Fields[r.FieldCount] = field
+	r.FieldFlags[r.FieldCount] = op
+	r.Values[r.FieldCount] = value
+	r.FieldCount++
+	return nil
+}
+
+// String fields have the length of the string as their value
+func (r *auditRuleData) addStringField(field, op uint32, value string) error {
+	if err := r.addField(field, op, uint32(len(value))); err != nil {
+		return err
+	}
+
+	r.Buf = append(r.Buf, value...)
+	return nil
+}
+
+// Encodes the rule the way the kernel expects it in AUDIT_ADD_RULE and AUDIT_DEL_RULE
+func (r *auditRuleData) bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, Endianness, []uint32{r.Flags, r.Action, r.FieldCount})
+	binary.Write(buf, Endianness, r.Mask)
+	binary.Write(buf, Endianness, r.Fields)
+	binary.Write(buf, Endianness, r.Values)
+	binary.Write(buf, Endianness, r.FieldFlags)
+	binary.Write(buf, Endianness, uint32(len(r.Buf)))
+	buf.Write(r.Buf)
+	return buf.Bytes()
+}
+
+// auditctlCommand is an auditctl command line translated into what has to be sent to the kernel
+type auditctlCommand struct {
+	flush   bool                // -D
+	status  *AuditStatusPayload // -e, -b, -f, -r and --backlog_wait_time
+	msgType uint16              // AUDIT_ADD_RULE or AUDIT_DEL_RULE for rule
+	rule    *auditRuleData
+}
+
+// Translates the arguments of an auditctl command line, the options that change the kernel are understood
+// Listing options like -l or -s and field comparisons (-C) are not
+func parseAuditctl(args []string) (*auditctlCommand, error) {
+	c := &auditctlCommand{}
+	var watch, perms, arch string
+	var syscalls, keys []string
+	var fields [][3]string
+
+	next := func(i int) (string, error) {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("auditctl option `%s` needs a value", args[i])
+		}
+
+		return args[i+1], nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		if opt == "-D" {
+			c.flush = true
+			continue
+		}
+
+		value, err := next(i)
+		if err != nil {
+			return nil, err
+		}
+		i++
+
+		switch opt {
+		case "-a", "-A", "-d":
+			if c.rule != nil {
+				return nil, errors.New("Only one of -a, -A, -d, -w or -W can be used at a time")
+			}
+
+			if c.rule, err = parseRuleListAction(value); err != nil {
+				return nil, err
+			}
+
+			c.msgType = AUDIT_ADD_RULE
+			switch opt {
+			case "-A":
+				c.rule.Flags |= AUDIT_FILTER_PREPEND
+			case "-d":
+				c.msgType = AUDIT_DEL_RULE
+			}
+		case "-w", "-W":
+			if c.rule != nil {
+				return nil, errors.New("Only one of -a, -A, -d, -w or -W can be used at a time")
+			}
+
+			// Watches are exit rules on every syscall
+			c.rule = &auditRuleData{Flags: auditRuleLists["exit"], Action: auditRuleActions["always"]}
+			c.msgType = AUDIT_ADD_RULE
+			if opt == "-W" {
+				c.msgType = AUDIT_DEL_RULE
+			}
+			watch = value
+		case "-p":
+			perms = value
+		case "-S":
+			syscalls = append(syscalls, strings.Split(value, ",")...)
+		case "-F":
+			name, op, v, err := splitRuleField(value)
+			if err != nil {
+				return nil, err
+			}
+
+			switch name {
+			case "key":
+				keys = append(keys, v)
+			case "arch":
+				arch = v
+				fields = append(fields, [3]string{name, op, v})
+			default:
+				fields = append(fields, [3]string{name, op, v})
+			}
+		case "-k":
+			keys = append(keys, value)
+		case "-e", "-b", "-f", "-r", "--backlog_wait_time":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("auditctl option `%s` could not be parsed; Value: `%s`", opt, value)
+			}
+
+			if c.status == nil {
+				c.status = &AuditStatusPayload{}
+			}
+
+			switch opt {
+			case "-e":
+				c.status.Mask |= AUDIT_STATUS_ENABLED
+				c.status.Enabled = uint32(n)
+			case "-b":
+				c.status.Mask |= AUDIT_STATUS_BACKLOG_LIMIT
+				c.status.BacklogLimit = uint32(n)
+			case "-f":
+				c.status.Mask |= AUDIT_STATUS_FAILURE
+				c.status.Failure = uint32(n)
+			case "-r":
+				c.status.Mask |= AUDIT_STATUS_RATE_LIMIT
+				c.status.RateLimit = uint32(n)
+			case "--backlog_wait_time":
+				c.status.Mask |= AUDIT_STATUS_BACKLOG_WAIT_TIME
+				c.status.BacklogWaitTime = uint32(n)
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported auditctl option `%s`", opt)
+		}
+	}
+
+	if c.rule == nil {
+		if len(syscalls) > 0 || len(fields) > 0 || len(keys) > 0 || perms != "" {
+			return nil, errors.New("Rule options need one of -a, -A, -d, -w or -W")
+		}
+
+		if !c.flush && c.status == nil {
+			return nil, errors.New("Nothing to do")
+		}
+
+		return c, nil
+	}
+
+	if err := c.buildRule(watch, perms, arch, syscalls, fields, keys); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Parses the list,action (or action,list) of -a
+func parseRuleListAction(v string) (*auditRuleData, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Rule list and action could not be parsed; Value: `%s`", v)
+	}
+
+	list, ok := auditRuleLists[parts[0]]
+	action, aok := auditRuleActions[parts[1]]
+	if !ok || !aok {
+		list, ok = auditRuleLists[parts[1]]
+		action, aok = auditRuleActions[parts[0]]
+	}
+
+	if !ok || !aok {
+		return nil, fmt.Errorf("Rule list and action could not be parsed; Value: `%s`", v)
+	}
+
+	return &auditRuleData{Flags: list, Action: action}, nil
+}
+
+// Splits a -F name=value into its parts
+func splitRuleField(v string) (name, op, value string, err error) {
+	if i := strings.IndexAny(v, "!=<>&"); i > 0 {
+		for _, o := range auditRuleOperators {
+			if strings.HasPrefix(v[i:], o.op) {
+				return v[:i], o.op, v[i+len(o.op):], nil
+			}
+		}
+	}
+
+	return "", "", "", fmt.Errorf("Rule field could not be parsed; Value: `%s`", v)
+}
+
+func (c *auditctlCommand) buildRule(watch, perms, arch string, syscalls []string, fields [][3]string, keys []string) error {
+	r := c.rule
+	if watch != "" {
+		if len(syscalls) > 0 {
+			return errors.New("Watches can't have syscalls")
+		}
+
+		field := uint32(auditFieldWatch)
+		if fi, err := os.Stat(watch); err == nil && fi.IsDir() {
+			field = auditFieldDir
+		}
+
+		if err := r.addStringField(field, auditOpEqual, strings.TrimSuffix(watch, "/")); err != nil {
+			return err
+		}
+
+		// Watches are on every kind of access unless told otherwise
+		if perms == "" {
+			perms = "rwxa"
+		}
+	}
+
+	if perms != "" {
+		p, err := parsePerms(perms)
+		if err != nil {
+			return err
+		}
+
+		if err := r.addField(auditFieldPerm, auditOpEqual, p); err != nil {
+			return err
+		}
+	}
+
+	archValue, err := parseRuleArch(arch)
+	if err != nil {
+		return err
+	}
+
+	if len(syscalls) == 0 {
+		for i := range r.Mask {
+			r.Mask[i] = ^uint32(0)
+		}
+	}
+
+	for _, s := range syscalls {
+		if err := r.addSyscall(archValue, s); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		if err := r.addRuleField(f[0], f[1], f[2], archValue); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) > 0 {
+		key := strings.Join(keys, auditKeySeparator)
+		if len(key) > AUDIT_MAX_KEY_LEN {
+			return fmt.Errorf("Rule keys can't be longer than %d characters", AUDIT_MAX_KEY_LEN)
+		}
+
+		if err := r.addStringField(auditFieldKey, auditOpEqual, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resolves -F arch=, b64 and b32 depend on the machine. The native arch is used if there is none
+func parseRuleArch(arch string) (uint32, error) {
+	switch {
+	case arch == "":
+		if a, ok := nativeArchs[runtime.GOARCH]["b64"]; ok {
+			return a, nil
+		}
+		return nativeArchs[runtime.GOARCH]["b32"], nil
+	case nativeArchs[runtime.GOARCH][arch] != 0:
+		return nativeArchs[runtime.GOARCH][arch], nil
+	case auditArchs[arch] != 0:
+		return auditArchs[arch], nil
+	}
+
+	if a, err := strconv.ParseUint(arch, 0, 32); err == nil {
+		return uint32(a), nil
+	}
+
+	return 0, fmt.Errorf("Rule arch could not be parsed; Value: `%s`", arch)
+}
+
+// Adds a syscall by name or number to the mask, all sets every bit
+func (r *auditRuleData) addSyscall(arch uint32, s string) error {
+	if s == "all" {
+		for i := range r.Mask {
+			r.Mask[i] = ^uint32(0)
+		}
+		return nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		n = -1
+		for nr, name := range syscallTables[fmt.Sprintf("%08x", arch)] {
+			if name == s {
+				n = nr
+				break
+			}
+		}
+	}
+
+	if n < 0 || n >= AUDIT_BITMASK_SIZE*32 {
+		return fmt.Errorf("Unknown syscall `%s` for arch %08x", s, arch)
+	}
+
+	r.Mask[n/32] |= 1 << uint(n%32)
+	return nil
+}
+
+func (r *auditRuleData) addRuleField(name, op, value string, arch uint32) error {
+	f, ok := auditRuleFields[name]
+	if !ok {
+		return fmt.Errorf("Unknown rule field `%s`", name)
+	}
+
+	var opValue uint32
+	for _, o := range auditRuleOperators {
+		if o.op == op {
+			opValue = o.value
+		}
+	}
+
+	if f.kind == auditValueString {
+		return r.addStringField(f.id, opValue, value)
+	}
+
+	v, err := parseRuleFieldValue(f.kind, value, arch)
+	if err != nil {
+		return fmt.Errorf("Rule field `%s` could not be parsed; Value: `%s`", name, value)
+	}
+
+	return r.addField(f.id, opValue, v)
+}
+
+func parseRuleFieldValue(kind int, value string, arch uint32) (uint32, error) {
+	switch kind {
+	case auditValueArch:
+		return arch, nil
+	case auditValuePerm:
+		return parsePerms(value)
+	case auditValueFiletype:
+		if t, ok := auditFiletypes[value]; ok {
+			return t, nil
+		}
+		return 0, errors.New("unknown file type")
+	case auditValueUid, auditValueGid:
+		if value == "unset" {
+			return 4294967295, nil
+		}
+
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			if kind == auditValueUid {
+				u, err := user.Lookup(value)
+				if err != nil {
+					return 0, err
+				}
+				value = u.Uid
+			} else {
+				g, err := user.LookupGroup(value)
+				if err != nil {
+					return 0, err
+				}
+				value = g.Gid
+			}
+		}
+	case auditValueExit:
+		name := strings.TrimPrefix(value, "-")
+		for n, e := range errnoNames {
+			if e == name {
+				return uint32(-int32(n)), nil
+			}
+		}
+	}
+
+	// Negative numbers like -1 for unset ids are sent as their two's complement
+	if n, err := strconv.ParseInt(value, 0, 64); err == nil && n >= -2147483648 && n <= 4294967295 {
+		return uint32(n), nil
+	}
+
+	return 0, errors.New("not a number")
+}
+
+// Parses -p, any of r, w, x and a
+func parsePerms(perms string) (uint32, error) {
+	var p uint32
+	for _, c := range perms {
+		switch c {
+		case 'x':
+			p |= 1
+		case 'w':
+			p |= 2
+		case 'r':
+			p |= 4
+		case 'a':
+			p |= 8
+		default:
+			return 0, fmt.Errorf("Rule permissions could not be parsed; Value: `%s`", perms)
+		}
+	}
+
+	return p, nil
+}
+
+// Sends the command to the kernel
+func (c *auditctlCommand) apply(n *NetlinkClient) error {
+	if c.flush {
+		if err := flushAuditRules(n); err != nil {
+			return err
+		}
+	}
+
+	if c.rule != nil {
+		if err := sendAuditRule(n, c.msgType, c.rule.bytes()); err != nil {
+			return err
+		}
+	}
+
+	if c.status != nil {
+		packet := &NetlinkPacket{
+			Type:  AUDIT_SET,
+			Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+			Pid:   uint32(syscall.Getpid()),
+		}
+
+		if _, err := n.Request(packet, c.status, syscall.NLMSG_ERROR); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sends an encoded rule with AUDIT_ADD_RULE or AUDIT_DEL_RULE
+func sendAuditRule(n *NetlinkClient, msgType uint16, rule []byte) error {
+	packet := &NetlinkPacket{
+		Type:  msgType,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Pid:   uint32(syscall.Getpid()),
+	}
+
+	if _, err := n.Request(packet, rule, syscall.NLMSG_ERROR); err != nil {
+		if err == syscall.EEXIST {
+			return errors.New("Rule exists")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Lists the rules in the kernel, each one is the encoded audit_rule_data
+func listAuditRules(n *NetlinkClient) ([][]byte, error) {
+	packet := &NetlinkPacket{
+		Type:  AUDIT_LIST_RULES,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Pid:   uint32(syscall.Getpid()),
+	}
+
+	if err := n.Send(packet, []byte{}); err != nil {
+		return nil, err
+	}
+
+	rules := [][]byte{}
+	for {
+		msg, err := n.Receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Header.Seq != packet.Seq {
+			continue
+		}
+
+		switch msg.Header.Type {
+		case syscall.NLMSG_DONE:
+			return rules, nil
+		case syscall.NLMSG_ERROR:
+			if len(msg.Data) >= 4 {
+				if code := int32(Endianness.Uint32(msg.Data[0:4])); code != 0 {
+					return nil, syscall.Errno(-code)
+				}
+			}
+		case AUDIT_LIST_RULES:
+			// The receive buffer is reused
+			rules = append(rules, append([]byte{}, msg.Data...))
+		}
+	}
+}
+
+// Deletes every rule in the kernel, like auditctl -D
+func flushAuditRules(n *NetlinkClient) error {
+	rules, err := listAuditRules(n)
+	if err != nil {
+		return fmt.Errorf("Failed to list audit rules. Error: %s", err)
+	}
+
+	for _, r := range rules {
+		if err := sendAuditRule(n, AUDIT_DEL_RULE, r); err != nil {
+			return fmt.Errorf("Failed to delete audit rule. Error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// An executor that applies auditctl commands over netlink so auditctl does not have to be installed
+// Anything else is run as usual
+func netlinkExec(name string, args ...string) error {
+	if name != "auditctl" {
+		return lExec(name, args...)
+	}
+
+	c, err := parseAuditctl(args)
+	if err != nil {
+		return err
+	}
+
+	n, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	if err := n.SetReceiveTimeout(rulesTimeout); err != nil {
+		return fmt.Errorf("Failed to set the netlink receive timeout. Error: %s", err)
+	}
+
+	if err := c.apply(n); err != nil {
+		return err
+	}
+
+	appliedRules.record(c)
+	return nil
+}
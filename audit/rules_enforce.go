@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ruleSet is the rules go-audit applied over netlink, what the enforcer puts back when they go missing
+type ruleSet struct {
+	mu    sync.Mutex
+	rules [][]byte
+}
+
+var appliedRules = &ruleSet{}
+
+// Keeps the set in line with a command that was applied
+func (s *ruleSet) record(c *auditctlCommand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.flush {
+		s.rules = nil
+	}
+
+	if c.rule == nil {
+		return
+	}
+
+	rule := c.rule.bytes()
+	switch c.msgType {
+	case AUDIT_ADD_RULE:
+		s.rules = append(s.rules, rule)
+	case AUDIT_DEL_RULE:
+		for i, r := range s.rules {
+			if sameAuditRule(r, rule) {
+				s.rules = append(s.rules[:i], s.rules[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (s *ruleSet) list() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]byte{}, s.rules...)
+}
+
+// Compares two encoded rules, a rule added with -A is the same rule as one added with -a
+func sameAuditRule(a, b []byte) bool {
+	if len(a) != len(b) || len(a) < 4 {
+		return false
+	}
+
+	flags := func(r []byte) uint32 { return Endianness.Uint32(r[0:4]) &^ AUDIT_FILTER_PREPEND }
+	return flags(a) == flags(b) && bytes.Equal(a[4:], b[4:])
+}
+
+// rulesEnforcer periodically lists the kernel rules and adds back any applied rule that was removed or changed
+// See `rules_enforce` in the example config
+type rulesEnforcer struct {
+	interval time.Duration
+	rules    *ruleSet
+}
+
+// Creates the enforcer, returns nil if it is not enabled
+func createRulesEnforcer(config *viper.Viper) (*rulesEnforcer, error) {
+	if !config.GetBool("rules_enforce.enabled") {
+		return nil, nil
+	}
+
+	interval := config.GetDuration("rules_enforce.interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("rules_enforce.interval must be greater than 0, %v provided", interval)
+	}
+
+	return &rulesEnforcer{interval: interval, rules: appliedRules}, nil
+}
+
+// Checks the rules every interval until the context is done, emit receives an event for every correction
+func (e *rulesEnforcer) run(ctx context.Context, emit func(*AuditMessageGroup)) {
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		n, err := dialNetlink()
+		if err != nil {
+			el.Printf("Failed to check the audit rules. Error: %s\n", err)
+			continue
+		}
+
+		if err := n.SetReceiveTimeout(rulesTimeout); err != nil {
+			el.Printf("Failed to check the audit rules. Error: %s\n", err)
+		} else if msg := e.enforce(
+			func() ([][]byte, error) { return listAuditRules(n) },
+			func(rule []byte) error { return sendAuditRule(n, AUDIT_ADD_RULE, rule) },
+		); msg != nil {
+			emit(msg)
+		}
+
+		n.Close()
+	}
+}
+
+// Adds back every rule missing from the kernel, returns an event if anything was missing
+func (e *rulesEnforcer) enforce(list func() ([][]byte, error), add func([]byte) error) *AuditMessageGroup {
+	current, err := list()
+	if err != nil {
+		el.Printf("Failed to list the audit rules. Error: %s\n", err)
+		return nil
+	}
+
+	missing, restored := 0, 0
+	for _, r := range e.rules.list() {
+		found := false
+		for _, c := range current {
+			if sameAuditRule(r, c) {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		missing++
+		if err := add(r); err != nil {
+			el.Printf("Failed to restore an audit rule. Error: %s\n", err)
+			continue
+		}
+		restored++
+	}
+
+	if missing == 0 {
+		return nil
+	}
+
+	el.Printf("Restored %d of %d audit rules that were removed or changed\n", restored, missing)
+
+	res := 1
+	if restored < missing {
+		res = 0
+	}
+
+	return newInternalEvent(1305, fmt.Sprintf("op=restore_rules missing=%d restored=%d res=%d", missing, restored, res))
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParseAuditctl(t *testing.T, args string) *auditctlCommand {
+	c, err := parseAuditctl(strings.Fields(args))
+	assert.Nil(t, err)
+	return c
+}
+
+func Test_ruleSet_record(t *testing.T) {
+	s := &ruleSet{}
+	s.record(mustParseAuditctl(t, "-D"))
+	s.record(mustParseAuditctl(t, "-a exit,always -F arch=x86_64 -S execve"))
+	s.record(mustParseAuditctl(t, "-A exit,always -F arch=x86_64 -S open"))
+	s.record(mustParseAuditctl(t, "-w /etc/shadow -p wa"))
+	s.record(mustParseAuditctl(t, "-e 1"))
+	assert.Len(t, s.list(), 3)
+
+	// Deleting matches rules added with -A too
+	s.record(mustParseAuditctl(t, "-d exit,always -F arch=x86_64 -S open"))
+	s.record(mustParseAuditctl(t, "-W /etc/shadow -p wa"))
+	assert.Equal(t, [][]byte{mustParseAuditctl(t, "-a exit,always -F arch=x86_64 -S execve").rule.bytes()}, s.list())
+
+	s.record(mustParseAuditctl(t, "-D"))
+	assert.Empty(t, s.list())
+}
+
+func Test_rulesEnforcer_enforce(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	execve := mustParseAuditctl(t, "-a exit,always -F arch=x86_64 -S execve -k exec").rule.bytes()
+	shadow := mustParseAuditctl(t, "-w /etc/shadow -p wa -k shadow").rule.bytes()
+	changed := mustParseAuditctl(t, "-w /etc/shadow -p r -k shadow").rule.bytes()
+
+	e := &rulesEnforcer{rules: &ruleSet{rules: [][]byte{execve, shadow}}}
+	added := [][]byte{}
+	add := func(r []byte) error {
+		added = append(added, r)
+		return nil
+	}
+
+	// Nothing is missing
+	msg := e.enforce(func() ([][]byte, error) { return [][]byte{shadow, execve}, nil }, add)
+	assert.Nil(t, msg)
+	assert.Empty(t, added)
+
+	// Removed and changed rules are added back
+	msg = e.enforce(func() ([][]byte, error) { return [][]byte{changed}, nil }, add)
+	assert.Equal(t, [][]byte{execve, shadow}, added)
+	assert.Equal(t, "go-audit", msg.Source)
+	assert.Equal(t, []*AuditMessage{{Type: 1305, Data: "op=restore_rules missing=2 restored=2 res=1"}}, msg.Msgs)
+	assert.Equal(t, "Restored 2 of 2 audit rules that were removed or changed\n", elb.String())
+
+	// Rules that can't be added back are logged
+	elb.Reset()
+	msg = e.enforce(func() ([][]byte, error) { return nil, nil }, func(r []byte) error { return errors.New("locked") })
+	assert.Equal(t, "op=restore_rules missing=2 restored=0 res=0", msg.Msgs[0].Data)
+	assert.Contains(t, elb.String(), "Failed to restore an audit rule. Error: locked\n")
+
+	elb.Reset()
+	msg = e.enforce(func() ([][]byte, error) { return nil, errors.New("derp") }, add)
+	assert.Nil(t, msg)
+	assert.Equal(t, "Failed to list the audit rules. Error: derp\n", elb.String())
+}
+
+func Test_createRulesEnforcer(t *testing.T) {
+	c := viper.New()
+	e, err := createRulesEnforcer(c)
+	assert.Nil(t, err)
+	assert.Nil(t, e)
+
+	c.Set("rules_enforce.enabled", true)
+	c.Set("rules_enforce.interval", "0s")
+	_, err = createRulesEnforcer(c)
+	assert.EqualError(t, err, "rules_enforce.interval must be greater than 0, 0s provided")
+
+	c.Set("rules_enforce.interval", "30s")
+	e, err = createRulesEnforcer(c)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second*30, e.interval)
+	assert.Equal(t, appliedRules, e.rules)
+}
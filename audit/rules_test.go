@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseAuditctl_syscallRule(t *testing.T) {
+	c, err := parseAuditctl(strings.Fields("-a always,exit -F arch=x86_64 -S execve,59 -S open -F auid>=1000 -F auid!=unset -k exec -k user"))
+	assert.Nil(t, err)
+	assert.False(t, c.flush)
+	assert.Nil(t, c.status)
+	assert.Equal(t, uint16(AUDIT_ADD_RULE), c.msgType)
+
+	r := c.rule
+	assert.Equal(t, uint32(4), r.Flags)
+	assert.Equal(t, uint32(2), r.Action)
+	assert.Equal(t, uint32(1<<2), r.Mask[0], "open is syscall 2")
+	assert.Equal(t, uint32(1<<(59-32)), r.Mask[1], "execve is syscall 59")
+	assert.Equal(t, uint32(4), r.FieldCount)
+	assert.Equal(t, []uint32{11, 9, 9, 210}, r.Fields[:4])
+	assert.Equal(t, []uint32{0x40000000, 0x60000000, 0x30000000, 0x40000000}, r.FieldFlags[:4])
+	assert.Equal(t, []uint32{0xc000003e, 1000, 4294967295, 9}, r.Values[:4])
+	assert.Equal(t, "exec\x01user", string(r.Buf))
+
+	// The list and action can be either way around, -A prepends
+	c, err = parseAuditctl(strings.Fields("-A exit,never -F arch=i386 -S 11 -F exit=-EACCES -F a1&0x40"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4|AUDIT_FILTER_PREPEND), c.rule.Flags)
+	assert.Equal(t, uint32(0), c.rule.Action)
+	assert.Equal(t, uint32(1<<11), c.rule.Mask[0])
+	assert.Equal(t, []uint32{0x40000003, 0xfffffff3, 0x40}, c.rule.Values[:3])
+	assert.Equal(t, uint32(0x08000000), c.rule.FieldFlags[2])
+
+	// No syscalls means all of them
+	c, err = parseAuditctl(strings.Fields("-d exclude,always -F msgtype=1305"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(AUDIT_DEL_RULE), c.msgType)
+	assert.Equal(t, uint32(5), c.rule.Flags)
+	assert.Equal(t, ^uint32(0), c.rule.Mask[63])
+
+	// Strings go in the buffer in order
+	c, err = parseAuditctl(strings.Fields("-a exit,always -F arch=x86_64 -S all -F exe=/bin/sh -F key=shell"))
+	assert.Nil(t, err)
+	assert.Equal(t, ^uint32(0), c.rule.Mask[0])
+	assert.Equal(t, []uint32{7, 5}, c.rule.Values[1:3])
+	assert.Equal(t, "/bin/shshell", string(c.rule.Buf))
+}
+
+func Test_parseAuditctl_watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := parseAuditctl(strings.Fields("-w /etc/shadow -p wa -k shadow"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(AUDIT_ADD_RULE), c.msgType)
+	assert.Equal(t, uint32(4), c.rule.Flags)
+	assert.Equal(t, uint32(2), c.rule.Action)
+	assert.Equal(t, ^uint32(0), c.rule.Mask[0])
+	assert.Equal(t, []uint32{105, 106, 210}, c.rule.Fields[:3])
+	assert.Equal(t, []uint32{11, 2 | 8, 6}, c.rule.Values[:3])
+	assert.Equal(t, "/etc/shadowshadow", string(c.rule.Buf))
+
+	// Directories are dir watches, all permissions by default
+	c, err = parseAuditctl([]string{"-W", dir + "/"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(AUDIT_DEL_RULE), c.msgType)
+	assert.Equal(t, []uint32{107, 106}, c.rule.Fields[:2])
+	assert.Equal(t, []uint32{uint32(len(dir)), 15}, c.rule.Values[:2])
+	assert.Equal(t, dir, string(c.rule.Buf))
+}
+
+func Test_parseAuditctl_status(t *testing.T) {
+	c, err := parseAuditctl([]string{"-D"})
+	assert.Nil(t, err)
+	assert.True(t, c.flush)
+	assert.Nil(t, c.rule)
+
+	c, err = parseAuditctl(strings.Fields("-e 2"))
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditStatusPayload{Mask: AUDIT_STATUS_ENABLED, Enabled: 2}, c.status)
+
+	c, err = parseAuditctl(strings.Fields("-b 8192 -f 1 -r 100 --backlog_wait_time 60000"))
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditStatusPayload{
+		Mask:            AUDIT_STATUS_BACKLOG_LIMIT | AUDIT_STATUS_FAILURE | AUDIT_STATUS_RATE_LIMIT | AUDIT_STATUS_BACKLOG_WAIT_TIME,
+		BacklogLimit:    8192,
+		Failure:         1,
+		RateLimit:       100,
+		BacklogWaitTime: 60000,
+	}, c.status)
+}
+
+func Test_parseAuditctl_errors(t *testing.T) {
+	tests := map[string]string{
+		"":                                      "Nothing to do",
+		"-l":                                    "auditctl option `-l` needs a value",
+		"-s x":                                  "Unsupported auditctl option `-s`",
+		"-a exit":                               "Rule list and action could not be parsed; Value: `exit`",
+		"-a exit,sometimes":                     "Rule list and action could not be parsed; Value: `exit,sometimes`",
+		"-a exit,always -w /tmp":                "Only one of -a, -A, -d, -w or -W can be used at a time",
+		"-S execve":                             "Rule options need one of -a, -A, -d, -w or -W",
+		"-a exit,always -F arch=x86_64 -S nope": "Unknown syscall `nope` for arch c000003e",
+		"-a exit,always -F arch=sparc":          "Rule arch could not be parsed; Value: `sparc`",
+		"-a exit,always -F nope=1":              "Unknown rule field `nope`",
+		"-a exit,always -F pid":                 "Rule field could not be parsed; Value: `pid`",
+		"-a exit,always -F pid=x":               "Rule field `pid` could not be parsed; Value: `x`",
+		"-a exit,always -F filetype=x":          "Rule field `filetype` could not be parsed; Value: `x`",
+		"-w /etc/shadow -p rwz":                 "Rule permissions could not be parsed; Value: `rwz`",
+		"-w /etc/shadow -S open":                "Watches can't have syscalls",
+		"-e on":                                 "auditctl option `-e` could not be parsed; Value: `on`",
+		"-a exit,always -k " + strings.Repeat("k", 257): "Rule keys can't be longer than 256 characters",
+	}
+
+	for args, msg := range tests {
+		_, err := parseAuditctl(strings.Fields(args))
+		assert.EqualError(t, err, msg, args)
+	}
+}
+
+func Test_auditRuleData_bytes(t *testing.T) {
+	c, err := parseAuditctl(strings.Fields("-w /etc/shadow -p wa -k shadow"))
+	assert.Nil(t, err)
+
+	b := c.rule.bytes()
+	assert.Len(t, b, 4*(3+64*4+1)+len("/etc/shadowshadow"))
+	assert.Equal(t, uint32(4), binary.LittleEndian.Uint32(b[0:4]))
+	assert.Equal(t, uint32(3), binary.LittleEndian.Uint32(b[8:12]))
+	assert.Equal(t, uint32(17), binary.LittleEndian.Uint32(b[len(b)-21:len(b)-17]))
+	assert.Equal(t, "/etc/shadowshadow", string(b[len(b)-17:]))
+}
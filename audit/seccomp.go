@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Signal names by number, the same on x86 and arm
+var signalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT", 7: "SIGBUS", 8: "SIGFPE",
+	9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2", 13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM",
+	16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT", 19: "SIGSTOP", 20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU",
+	23: "SIGURG", 24: "SIGXCPU", 25: "SIGXFSZ", 26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO",
+	30: "SIGPWR", 31: "SIGSYS",
+}
+
+// SECCOMP_RET_* actions by the upper 16 bits of code, see linux/seccomp.h
+var seccompActions = map[uint32]string{
+	0x0000: "kill_thread",
+	0x8000: "kill_process",
+	0x0003: "trap",
+	0x0005: "errno",
+	0x7fc0: "user_notif",
+	0x7ff0: "trace",
+	0x7ffc: "log",
+	0x7fff: "allow",
+}
+
+// SeccompEvent is a syscall a seccomp filter acted on, from a SECCOMP record
+type SeccompEvent struct {
+	Syscall     string `json:"syscall"`
+	SyscallName string `json:"syscall_name,omitempty"`
+	Signal      string `json:"signal,omitempty"` // Like SIGSYS, the number if it has no name
+	Action      string `json:"action,omitempty"` // Like kill_process or errno
+	Errno       int    `json:"errno,omitempty"`  // Only for the errno action
+}
+
+// Parses a SECCOMP record, nil if it has no syscall
+func parseSeccomp(data string) *SeccompEvent {
+	fields := parseFields(data)
+	if fields["syscall"] == "" {
+		return nil
+	}
+
+	s := &SeccompEvent{
+		Syscall:     fields["syscall"],
+		SyscallName: syscallName(fields["arch"], fields["syscall"]),
+	}
+
+	if sig, err := strconv.Atoi(fields["sig"]); err == nil && sig > 0 {
+		if name, ok := signalNames[sig]; ok {
+			s.Signal = name
+		} else {
+			s.Signal = fields["sig"]
+		}
+	}
+
+	if code, err := strconv.ParseUint(strings.TrimPrefix(fields["code"], "0x"), 16, 32); err == nil {
+		s.Action = seccompActions[uint32(code)>>16]
+		if s.Action == "errno" {
+			s.Errno = int(code & 0xffff)
+		}
+	}
+
+	return s
+}
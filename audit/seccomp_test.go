@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSeccomp(t *testing.T) {
+	assert.Equal(t,
+		&SeccompEvent{Syscall: "59", SyscallName: "execve", Signal: "SIGSYS", Action: "kill_process"},
+		parseSeccomp(`auid=1000 uid=1000 gid=1000 ses=2 pid=4242 comm="sh" exe="/bin/sh" sig=31 arch=c000003e syscall=59 compat=0 ip=0x7f code=0x80000000`),
+	)
+
+	assert.Equal(t,
+		&SeccompEvent{Syscall: "41", SyscallName: "socket", Action: "errno", Errno: 1},
+		parseSeccomp(`pid=1 comm="curl" sig=0 arch=c000003e syscall=41 compat=0 ip=0x7f code=0x50001`),
+	)
+
+	assert.Equal(t,
+		&SeccompEvent{Syscall: "999", Signal: "64", Action: "log"},
+		parseSeccomp(`sig=64 arch=c000003e syscall=999 code=0x7ffc0000`),
+	)
+
+	assert.Equal(t, &SeccompEvent{Syscall: "1"}, parseSeccomp(`syscall=1 code=nope`))
+	assert.Nil(t, parseSeccomp(`pid=1`))
+}
+
+func TestAuditMessageGroup_AddMessage_seccomp(t *testing.T) {
+	uidMap = map[string]string{"0": "root"}
+
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1326, Data: `uid=0 sig=31 arch=c000003e syscall=59 code=0x0`})
+	assert.Equal(t, &SeccompEvent{Syscall: "59", SyscallName: "execve", Signal: "SIGSYS", Action: "kill_thread"}, amg.Seccomp)
+	assert.Equal(t, "", amg.Syscall, "Only SYSCALL records set the syscall of the event")
+	assert.Equal(t, map[string]string{"0": "root"}, amg.UidMap)
+}
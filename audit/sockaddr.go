@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Ethernet protocols of AF_PACKET sockets, see linux/if_ether.h
+var etherProtocols = map[uint16]string{
+	0x0003: "ETH_P_ALL", 0x0800: "ETH_P_IP", 0x0806: "ETH_P_ARP", 0x8100: "ETH_P_8021Q", 0x86dd: "ETH_P_IPV6",
+	0x888e: "ETH_P_PAE", 0x88cc: "ETH_P_LLDP",
+}
+
+// SocketAddress is the decoded saddr of a SOCKADDR record, the address a connect, bind or sendto was made with
+type SocketAddress struct {
+	Family  string `json:"family"`            // inet, inet6, unix, netlink or packet
+	Address string `json:"address,omitempty"` // The ip address, or the path of a unix socket
+	Port    int    `json:"port,omitempty"`
+
+	// netlink, the port id (0 is the kernel) and multicast groups bitmask
+	Pid    uint32 `json:"pid,omitempty"`
+	Groups uint32 `json:"groups,omitempty"`
+
+	// packet, the ethernet protocol like ETH_P_ALL and the interface index, 0 is any interface
+	Protocol string `json:"protocol,omitempty"`
+	Ifindex  int32  `json:"ifindex,omitempty"`
+}
+
+// Decodes the hex encoded saddr field of a SOCKADDR message, nil for families that aren't understood
+func parseSocketAddress(saddr string) *SocketAddress {
+	b, err := hex.DecodeString(saddr)
+	if err != nil || len(b) < 2 {
+		return nil
+	}
+
+	switch Endianness.Uint16(b[0:2]) {
+	case 1: // AF_UNIX
+		if path := parseSocketPath(saddr); path != "" {
+			return &SocketAddress{Family: "unix", Address: path}
+		}
+	case 2: // AF_INET
+		if ip, port := parseSockaddr(saddr); ip != nil {
+			return &SocketAddress{Family: "inet", Address: ip.String(), Port: port}
+		}
+	case 10: // AF_INET6
+		if ip, port := parseSockaddr(saddr); ip != nil {
+			return &SocketAddress{Family: "inet6", Address: ip.String(), Port: port}
+		}
+	case 16: // AF_NETLINK: family(2) pad(2) pid(4) groups(4)
+		if len(b) >= 12 {
+			return &SocketAddress{Family: "netlink", Pid: Endianness.Uint32(b[4:8]), Groups: Endianness.Uint32(b[8:12])}
+		}
+	case 17: // AF_PACKET: family(2) protocol(2) ifindex(4) hatype(2) pkttype(1) halen(1) addr(8)
+		if len(b) >= 8 {
+			return &SocketAddress{
+				Family:   "packet",
+				Protocol: etherProtocol(binary.BigEndian.Uint16(b[2:4])),
+				Ifindex:  int32(Endianness.Uint32(b[4:8])),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Names an ethernet protocol, unknown ones are formatted like 0x88b5
+// 0 is the protocol of a socket that isn't receiving anything yet, it is left empty
+func etherProtocol(proto uint16) string {
+	if proto == 0 {
+		return ""
+	}
+
+	if name, ok := etherProtocols[proto]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%04x", proto)
+}
@@ -0,0 +1,33 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSocketAddress(t *testing.T) {
+	assert.Equal(t, &SocketAddress{Family: "inet", Address: "10.1.2.3", Port: 443}, parseSocketAddress("020001BB0A0102030000000000000000"))
+	assert.Equal(
+		t,
+		&SocketAddress{Family: "inet6", Address: "2001:db8::1", Port: 8080},
+		parseSocketAddress("0A001F900000000020010DB800000000000000000000000100000000"),
+	)
+	assert.Equal(t, &SocketAddress{Family: "unix", Address: "/tmp/sock"}, parseSocketAddress("01002F746D702F736F636B"))
+
+	// pid and groups are in host byte order
+	assert.Equal(t, &SocketAddress{Family: "netlink"}, parseSocketAddress("100000000000000000000000"))
+	assert.Equal(t, &SocketAddress{Family: "netlink", Pid: 1234, Groups: 1}, parseSocketAddress("10000000D204000001000000"))
+
+	// The protocol is in network byte order, ifindex in host byte order
+	assert.Equal(t, &SocketAddress{Family: "packet", Protocol: "ETH_P_ALL", Ifindex: 2}, parseSocketAddress("1100000302000000000000000000000000000000"))
+	assert.Equal(t, &SocketAddress{Family: "packet", Protocol: "0x88b5"}, parseSocketAddress("110088B500000000"))
+
+	// Too short for its family
+	assert.Nil(t, parseSocketAddress("10000000"))
+	assert.Nil(t, parseSocketAddress("11000003"))
+	assert.Nil(t, parseSocketAddress("020001BB"))
+	assert.Nil(t, parseSocketAddress("0100"))
+	assert.Nil(t, parseSocketAddress("nothex"))
+	assert.Nil(t, parseSocketAddress(""))
+}
@@ -0,0 +1,501 @@
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Events are appended to segment files of about this size, a segment is removed once every event in it was sent
+const spoolSegmentSize = 16 * 1024 * 1024
+
+// Sidecar file in the spool dir holding where reading the first segment left off, see spoolOffset
+const spoolOffsetFile = "read_offset"
+
+// outputSpool keeps events on disk while an output is failing and sends them in order once it recovers
+// See `output.spool` in the example config. The read offset is saved after every flushed drain, events sent after
+// that when go-audit stopped are sent again
+type outputSpool struct {
+	name     string
+	dir      string
+	maxSize  int64
+	interval time.Duration
+
+	mu       sync.Mutex
+	segments []string // Oldest first, the last one is appended to
+	w        *os.File // Open for appending to the last segment, nil until the next event is spooled
+	offset   int64    // Read position in the first segment
+	stats    spoolStats
+	full     bool // Set while events are being dropped, so it is only logged once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Saved read position, only used if segment is still the first one
+type spoolOffset struct {
+	Segment string `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Spool counters for the control socket
+type spoolStats struct {
+	Events  int64  `json:"events"`  // Events waiting on disk
+	Bytes   int64  `json:"bytes"`   // Size of the waiting events on disk
+	Dropped uint64 `json:"dropped"` // Events dropped because the spool was full
+}
+
+// Creates the spool for an output in `output.spool.dir`/<name>, returns nil if the dir is not set
+// Events left over from a previous run are counted and sent once the spool is started
+func createSpool(config *viper.Viper, name string) (*outputSpool, error) {
+	dir := config.GetString("output.spool.dir")
+	if dir == "" {
+		return nil, nil
+	}
+
+	maxSize := int64(config.GetInt("output.spool.max_size"))
+	if maxSize < 1 {
+		return nil, fmt.Errorf("output.spool.max_size must be greater than 0, %v provided", maxSize)
+	}
+
+	interval := config.GetDuration("output.spool.interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("output.spool.interval must be greater than 0, %v provided", interval)
+	}
+
+	s := &outputSpool{name: name, dir: filepath.Join(dir, name), maxSize: maxSize, interval: interval}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create output.spool.dir. Error: %s", err)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("Failed to read the spool for output %s. Error: %s", name, err)
+	}
+
+	if s.stats.Events > 0 {
+		l.Printf("Output %s has %d spooled events from a previous run\n", name, s.stats.Events)
+	}
+
+	return s, nil
+}
+
+// Finds existing segments, picks up the saved read offset and counts the events left in them
+func (s *outputSpool) load() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.spool"))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+	s.segments = files
+
+	if len(files) > 0 {
+		if s.offset, err = s.loadOffset(files[0]); err != nil {
+			return err
+		}
+	}
+
+	for i, f := range files {
+		from := int64(0)
+		if i == 0 {
+			from = s.offset
+		}
+
+		events, size, err := countSpooled(f, from)
+		if err != nil {
+			return err
+		}
+
+		s.stats.Events += events
+		s.stats.Bytes += size
+	}
+
+	return nil
+}
+
+// Reads the saved read offset, 0 if there is none or it was saved for a segment that has since been removed
+func (s *outputSpool) loadOffset(first string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, spoolOffsetFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	saved := spoolOffset{}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		el.Printf("Ignoring the read offset of the spool for output %s, it could not be parsed. Error: %s\n", s.name, err)
+		return 0, nil
+	}
+
+	if saved.Segment != filepath.Base(first) {
+		return 0, nil
+	}
+
+	info, err := os.Stat(first)
+	if err != nil {
+		return 0, err
+	}
+
+	if saved.Offset < 0 || saved.Offset > info.Size() {
+		el.Printf("Ignoring the read offset of the spool for output %s, %d is outside of %s\n", s.name, saved.Offset, first)
+		return 0, nil
+	}
+
+	return saved.Offset, nil
+}
+
+// Writes the read offset to a temporary file and moves it into place so a crash never leaves a partial file
+// Only called once the events before the offset were flushed, otherwise they could be lost on a restart
+func (s *outputSpool) saveOffset() error {
+	s.mu.Lock()
+	saved := spoolOffset{Offset: s.offset}
+	if len(s.segments) > 0 {
+		saved.Segment = filepath.Base(s.segments[0])
+	}
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, spoolOffsetFile)
+	if saved.Segment == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove the spool read offset for output %s. Error: %s", s.name, err)
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, spoolOffsetFile)
+	if err != nil {
+		return fmt.Errorf("Failed to save the spool read offset for output %s. Error: %s", s.name, err)
+	}
+
+	_, err = tmp.Write(append(b, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Failed to save the spool read offset for output %s. Error: %s", s.name, err)
+	}
+
+	return nil
+}
+
+// Counts the complete events in a segment from offset on and their size, a partial event at the end is left for the reader to skip
+func countSpooled(path string, offset int64) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var events int64
+	start := offset
+	var size [4]byte
+	for {
+		if _, err := f.ReadAt(size[:], offset); err != nil {
+			break
+		}
+
+		next := offset + 4 + int64(binary.BigEndian.Uint32(size[:]))
+		if next > info.Size() {
+			break
+		}
+
+		events++
+		offset = next
+	}
+
+	return events, offset - start, nil
+}
+
+// Write sends the event straight to the output unless older events are still waiting, then it is spooled too
+// A failed write is spooled right away instead of being retried. If the spool is full the event is dropped
+func (s *outputSpool) write(b []byte, send func([]byte) error) error {
+	s.mu.Lock()
+	waiting := s.stats.Events > 0
+	s.mu.Unlock()
+
+	if !waiting {
+		err := send(b)
+		if err == nil {
+			return nil
+		}
+
+		el.Printf("Output %s failed, spooling events until it recovers. Error: %s\n", s.name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(b)
+}
+
+// Appends an event to the last segment, must be called with mu held
+func (s *outputSpool) add(b []byte) error {
+	if s.stats.Bytes+4+int64(len(b)) > s.maxSize {
+		s.stats.Dropped++
+		if !s.full {
+			s.full = true
+			el.Printf("The spool for output %s is full, events are being dropped\n", s.name)
+		}
+		return nil
+	}
+
+	if s.w != nil {
+		if info, err := s.w.Stat(); err != nil || info.Size() >= spoolSegmentSize {
+			s.w.Close()
+			s.w = nil
+		}
+	}
+
+	if s.w == nil {
+		if err := s.nextSegment(); err != nil {
+			return fmt.Errorf("Failed to create spool segment. Error: %s", err)
+		}
+	}
+
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+
+	if _, err := s.w.Write(buf); err != nil {
+		// Whatever made it into the file is skipped as a partial event when it is read back
+		s.w.Close()
+		s.w = nil
+		return fmt.Errorf("Failed to write spool segment. Error: %s", err)
+	}
+
+	s.stats.Events++
+	s.stats.Bytes += int64(len(buf))
+	return nil
+}
+
+// Starts a new segment, named so they sort in the order they were created
+func (s *outputSpool) nextSegment() error {
+	n := 1
+	if len(s.segments) > 0 {
+		last := strings.TrimSuffix(filepath.Base(s.segments[len(s.segments)-1]), ".spool")
+		if i, err := strconv.Atoi(last); err == nil {
+			n = i + 1
+		}
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.spool", n))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.w = f
+	s.segments = append(s.segments, path)
+	return nil
+}
+
+// Returns the oldest spooled event without taking it off, nil if there are none
+func (s *outputSpool) peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 0 {
+		b, err := s.readAt(s.segments[0], s.offset)
+		if err != io.EOF {
+			return b, err
+		}
+
+		// The segment is used up, a partial event left by a failed write or crash goes with it
+		if len(s.segments) == 1 && s.w != nil {
+			if info, err := s.w.Stat(); err == nil && info.Size() > s.offset {
+				el.Printf("Skipping a partial event in the spool for output %s\n", s.name)
+			}
+			s.w.Close()
+			s.w = nil
+		}
+
+		s.removeFirst()
+	}
+
+	return nil, nil
+}
+
+// Reads the event at offset, io.EOF if there is no complete event there
+func (s *outputSpool) readAt(path string, offset int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var size [4]byte
+	if _, err := f.ReadAt(size[:], offset); err != nil {
+		return nil, io.EOF
+	}
+
+	b := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := f.ReadAt(b, offset+4); err != nil {
+		return nil, io.EOF
+	}
+
+	return b, nil
+}
+
+// Takes the event returned by peek off the spool
+func (s *outputSpool) pop(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset += 4 + int64(len(b))
+	s.stats.Events--
+	s.stats.Bytes -= 4 + int64(len(b))
+	s.full = false
+}
+
+// Removes the first segment, must be called with mu held
+func (s *outputSpool) removeFirst() {
+	if err := os.Remove(s.segments[0]); err != nil && !os.IsNotExist(err) {
+		el.Printf("Failed to remove spool segment %s. Error: %s\n", s.segments[0], err)
+	}
+
+	// A saved offset is for this segment, names are used again once the spool is empty so it must not outlive it
+	if err := os.Remove(filepath.Join(s.dir, spoolOffsetFile)); err != nil && !os.IsNotExist(err) {
+		el.Printf("Failed to remove the spool read offset for output %s. Error: %s\n", s.name, err)
+	}
+
+	s.segments = s.segments[1:]
+	s.offset = 0
+}
+
+// Sends spooled events in order until the spool is empty or a write fails, returns how many were sent
+// Only the drain loop calls this, while events are waiting nothing else writes to the output
+func (s *outputSpool) drain(send func([]byte) error) (int, error) {
+	sent := 0
+	for {
+		b, err := s.peek()
+		if err != nil || b == nil {
+			return sent, err
+		}
+
+		if err := send(b); err != nil {
+			return sent, err
+		}
+
+		s.pop(b)
+		sent++
+	}
+}
+
+// Starts trying to drain the spool every interval
+func (s *outputSpool) start(send func([]byte) error, flush func() error) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+
+			if s.Stats().Events == 0 {
+				continue
+			}
+
+			sent, err := s.drain(send)
+			if sent > 0 {
+				if ferr := flush(); ferr != nil {
+					if err == nil {
+						err = ferr
+					}
+				} else if serr := s.saveOffset(); serr != nil {
+					el.Println(serr)
+				}
+			}
+
+			if err != nil {
+				el.Printf("Sent %d spooled events to output %s, %d are left. Error: %s\n", sent, s.name, s.Stats().Events, err)
+			} else if sent > 0 {
+				l.Printf("Sent %d spooled events to output %s, it has recovered\n", sent, s.name)
+			}
+		}
+	}()
+}
+
+// Stats returns a copy of the spool counters
+func (s *outputSpool) Stats() spoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Stops draining, spooled events stay on disk for the next run
+func (s *outputSpool) close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w != nil {
+		err := s.w.Close()
+		s.w = nil
+		return err
+	}
+
+	return nil
+}
+
+// Collects the spool counters of every output that has one, by output name
+func writerSpoolStats(w AuditWriter) map[string]spoolStats {
+	var writers []*OutputWriter
+	switch t := w.(type) {
+	case *OutputWriter:
+		writers = []*OutputWriter{t}
+	case *multiWriter:
+		writers = t.writers
+	}
+
+	stats := map[string]spoolStats{}
+	for _, ow := range writers {
+		if ow.spool != nil {
+			stats[ow.spool.name] = ow.spool.Stats()
+		}
+	}
+
+	if len(stats) == 0 {
+		return nil
+	}
+
+	return stats
+}
@@ -0,0 +1,280 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := viper.New()
+	s, err := createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Nil(t, s, "No dir should disable the spool")
+
+	c.Set("output.spool.dir", dir)
+	_, err = createSpool(c, "file")
+	assert.EqualError(t, err, "output.spool.max_size must be greater than 0, 0 provided")
+
+	c.Set("output.spool.max_size", 1024)
+	_, err = createSpool(c, "file")
+	assert.EqualError(t, err, "output.spool.interval must be greater than 0, 0s provided")
+
+	c.Set("output.spool.interval", "1s")
+	s, err = createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "file"), s.dir)
+	assert.Equal(t, int64(1024), s.maxSize)
+
+	info, err := os.Stat(s.dir)
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir())
+
+	// Can't be used with the dead letter file
+	c.Set("output.stdout.enabled", true)
+	c.Set("output.stdout.attempts", 1)
+	c.Set("output.dead_letter.path", filepath.Join(dir, "dl"))
+	c.Set("output.dead_letter.max_size", 1024)
+	_, err = createOutput(c)
+	assert.EqualError(t, err, "output.spool and output.dead_letter can't be used together")
+}
+
+func TestAuditWriter_spool(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := viper.New()
+	c.Set("output.spool.dir", dir)
+	c.Set("output.spool.max_size", 1024)
+	c.Set("output.spool.interval", "1s")
+
+	fw := &flakyWriter{ok: 1}
+	o := NewWriterOutput(fw)
+	w := NewAuditWriter(o, 3)
+	w.spool, err = createSpool(c, "file")
+	assert.Nil(t, err)
+
+	event := func(seq int) string {
+		return "{\"schema_version\":2,\"sequence\":" + string(rune('0'+seq)) + ",\"timestamp\":\"1\",\"messages\":null,\"uid_map\":null}\n"
+	}
+	write := func(seq int) {
+		assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: seq, AuditTime: "1"}))
+	}
+
+	// A failed write is spooled right away, later events wait behind it even if the output works again
+	write(1)
+	write(2)
+	fw.ok = 10
+	write(3)
+	assert.Equal(t, event(1), fw.b.String())
+	assert.Contains(t, elb.String(), "Output file failed, spooling events until it recovers. Error: derp\n")
+	assert.Equal(t, spoolStats{Events: 2, Bytes: int64(2 * (4 + len(event(2))))}, w.spool.Stats())
+	assert.Equal(t, map[string]spoolStats{"file": w.spool.Stats()}, writerSpoolStats(w))
+
+	// Draining stops at the first failure
+	fw.ok = 1
+	sent, err := w.spool.drain(w.writeOnce)
+	assert.EqualError(t, err, "derp")
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, event(1)+event(2), fw.b.String())
+
+	// Spooled events survive a restart, the rest of a partly sent segment is picked up again
+	assert.Nil(t, w.Close())
+	w.spool, err = createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), w.spool.Stats().Events)
+	assert.Contains(t, lb.String(), "Output file has 2 spooled events from a previous run\n")
+
+	fw.ok = 10
+	sent, err = w.spool.drain(w.writeOnce)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, event(1)+event(2)+event(2)+event(3), fw.b.String())
+	assert.Equal(t, spoolStats{}, w.spool.Stats())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "file", "*.spool"))
+	assert.Empty(t, segments)
+
+	// Nothing is waiting, so events go straight to the output
+	write(4)
+	assert.Equal(t, event(4), fw.b.String()[len(fw.b.String())-len(event(4)):])
+
+	// Events that don't fit are dropped
+	fw.ok = 0
+	w.spool.maxSize = int64(4 + len(event(5)))
+	write(5)
+	write(6)
+	assert.Equal(t, spoolStats{Events: 1, Bytes: w.spool.maxSize, Dropped: 1}, w.spool.Stats())
+	assert.Contains(t, elb.String(), "The spool for output file is full, events are being dropped\n")
+
+	// A partial event at the end of a segment is skipped
+	w.spool.mu.Lock()
+	w.spool.w.Write([]byte{0, 0, 1})
+	w.spool.mu.Unlock()
+
+	fw.ok = 10
+	fw.b.Reset()
+	sent, err = w.spool.drain(w.writeOnce)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, event(5), fw.b.String())
+	assert.Equal(t, spoolStats{Dropped: 1}, w.spool.Stats())
+	assert.Contains(t, elb.String(), "Skipping a partial event in the spool for output file\n")
+}
+
+func TestOutputSpool_start(t *testing.T) {
+	lb, _ := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &outputSpool{name: "file", dir: dir, maxSize: 1024, interval: 10 * time.Millisecond}
+	s.add([]byte("1\n"))
+	s.add([]byte("2\n"))
+
+	fw := &flakyWriter{ok: 10}
+	w := NewAuditWriter(fw, 1)
+	flushed := make(chan bool, 1)
+	s.start(w.writeOnce, func() error {
+		flushed <- true
+		return nil
+	})
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("The spool was not drained")
+	}
+
+	assert.Nil(t, s.close())
+	assert.Equal(t, "1\n2\n", fw.b.String())
+	assert.Contains(t, lb.String(), "Sent 2 spooled events to output file, it has recovered\n")
+
+	// Nothing is left to read from
+	_, err = os.Stat(filepath.Join(dir, spoolOffsetFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOutputSpool_offset(t *testing.T) {
+	_, elb := hookLogger()
+	defer resetLogger()
+
+	dir, err := ioutil.TempDir("", "go-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := viper.New()
+	c.Set("output.spool.dir", dir)
+	c.Set("output.spool.max_size", 1024)
+	c.Set("output.spool.interval", "1s")
+
+	s, err := createSpool(c, "file")
+	assert.Nil(t, err)
+	s.add([]byte("1\n"))
+	s.add([]byte("2\n"))
+	s.add([]byte("3\n"))
+
+	fw := &flakyWriter{ok: 2}
+	w := NewAuditWriter(NewWriterOutput(fw), 1)
+	sent, err := s.drain(w.writeOnce)
+	assert.Equal(t, 2, sent)
+	assert.NotNil(t, err)
+	assert.Nil(t, s.saveOffset())
+	assert.Nil(t, s.close())
+
+	// Events before the saved offset are not sent again after a restart
+	s, err = createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, spoolStats{Events: 1, Bytes: 4 + 2}, s.Stats())
+
+	fw = &flakyWriter{ok: 10}
+	w = NewAuditWriter(NewWriterOutput(fw), 1)
+	sent, err = s.drain(w.writeOnce)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, "3\n", fw.b.String())
+	assert.Nil(t, s.close())
+
+	// Removing the segment removes its offset, the next segment gets the same name
+	path := filepath.Join(dir, "file", spoolOffsetFile)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// An offset saved for a segment that is gone or past its end is ignored
+	s = &outputSpool{name: "file", dir: filepath.Join(dir, "file"), maxSize: 1024}
+	s.add([]byte("4\n"))
+	s.close()
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"segment":"00000000000000000099.spool","offset":1}`), 0600))
+	s, err = createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), s.Stats().Events)
+	assert.Equal(t, int64(0), s.offset)
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "file", "*.spool"))
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"segment":"`+filepath.Base(segments[0])+`","offset":100}`), 0600))
+	s, err = createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), s.Stats().Events)
+	assert.Contains(t, elb.String(), "Ignoring the read offset of the spool for output file, 100 is outside of ")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`nope`), 0600))
+	s, err = createSpool(c, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), s.Stats().Events)
+	assert.Contains(t, elb.String(), "Ignoring the read offset of the spool for output file, it could not be parsed. Error: ")
+}
+
+func Test_shutdownWriter(t *testing.T) {
+	lb, elb := hookLogger()
+	defer resetLogger()
+
+	c := viper.New()
+	c.Set("output.spool.dir", t.TempDir())
+	c.Set("output.spool.max_size", 1024)
+	c.Set("output.spool.interval", "1h")
+
+	fw := &flakyWriter{}
+	spooled := func() *OutputWriter {
+		w := NewAuditWriter(NewWriterOutput(fw), 1)
+		spool, err := createSpool(c, "file")
+		assert.Nil(t, err)
+		w.spool = spool
+		w.spool.start(w.writeOnce, w.Flush)
+		return w
+	}
+
+	// Out of time, everything stays on disk
+	w := spooled()
+	fw.ok = 0
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 1, AuditTime: "1"}))
+	assert.Nil(t, w.Write(context.Background(), &AuditMessageGroup{Seq: 2, AuditTime: "1"}))
+	fw.ok = 10
+	assert.Nil(t, shutdownWriter(w, time.Now().Add(-time.Second)))
+	assert.Empty(t, fw.b.String())
+	assert.Contains(t, elb.String(), "Sent 0 spooled events to output file before exiting, 2 are left for the next run. Error: Ran out of time\n")
+
+	// The next run sends them before exiting, without waiting for the interval
+	w = spooled()
+	assert.Nil(t, shutdownWriter(w, time.Now().Add(time.Second)))
+	assert.Contains(t, fw.b.String(), `"sequence":1,`)
+	assert.Contains(t, fw.b.String(), `"sequence":2,`)
+	assert.Equal(t, spoolStats{}, w.spool.Stats())
+	assert.Contains(t, lb.String(), "Sent 2 spooled events to output file before exiting\n")
+}
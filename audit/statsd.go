@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Keeps a datagram under the usual ethernet mtu
+const statsdPacketSize = 1432
+
+// Characters that mean something in the statsd line protocol
+var statsdEscaper = strings.NewReplacer("|", "_", ",", "_", "#", "_", ":", "_", "@", "_", "\n", "_", " ", "_")
+
+// StatsdClient pushes the metrics served on /metrics to statsd every interval, see `statsd` in the example config
+// Counters are sent as the increase since the previous push and left out if there was none, gauges are always sent
+// It is only used on the receive loop
+type StatsdClient struct {
+	address   string
+	interval  time.Duration
+	prefix    string
+	dogstatsd bool     // Labels become tags instead of part of the name
+	tags      []string // Like role:audit, dogstatsd only
+	conn      net.Conn
+	last      map[string]float64 // Counter values of the previous push, by name and label value
+	failing   bool               // Only the first of a run of failed pushes is logged
+}
+
+// CreateStatsd reads `statsd` from the config, nil if it is disabled
+func CreateStatsd(config *viper.Viper) (*StatsdClient, error) {
+	if !config.GetBool("statsd.enabled") {
+		return nil, nil
+	}
+
+	s := &StatsdClient{
+		address:   config.GetString("statsd.address"),
+		interval:  config.GetDuration("statsd.interval"),
+		prefix:    config.GetString("statsd.prefix"),
+		dogstatsd: config.GetBool("statsd.dogstatsd"),
+		last:      map[string]float64{},
+	}
+
+	if _, _, err := net.SplitHostPort(s.address); err != nil {
+		return nil, fmt.Errorf("statsd.address could not be parsed. Error: %s", err)
+	}
+
+	if s.interval <= 0 {
+		return nil, fmt.Errorf("statsd.interval must be greater than 0, %v provided", s.interval)
+	}
+
+	tags := config.GetStringMapString("statsd.tags")
+	if len(tags) > 0 && !s.dogstatsd {
+		return nil, fmt.Errorf("statsd.tags need statsd.dogstatsd, plain statsd has no tags")
+	}
+
+	// The host tag defaults to the hostname, setting it empty leaves it out
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
+	if _, ok := tags["host"]; !ok && s.dogstatsd && hostname != "" {
+		tags["host"] = hostname
+	}
+
+	for k, v := range tags {
+		if v != "" {
+			s.tags = append(s.tags, statsdEscaper.Replace(k)+":"+statsdEscaper.Replace(v))
+		}
+	}
+	sort.Strings(s.tags)
+
+	return s, nil
+}
+
+// Resolves the address, nothing is sent until the first push
+func (s *StatsdClient) open() error {
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return fmt.Errorf("Failed to open the statsd socket. Error: %s", err)
+	}
+
+	s.conn = conn
+	return nil
+}
+
+func (s *StatsdClient) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
+
+// Sends one round of metrics, in as few datagrams as fit
+func (s *StatsdClient) push(families []metricFamily) {
+	var err error
+	var packet bytes.Buffer
+	for _, line := range s.lines(families) {
+		if packet.Len() > 0 && packet.Len()+len(line) >= statsdPacketSize {
+			if _, werr := s.conn.Write(packet.Bytes()); werr != nil && err == nil {
+				err = werr
+			}
+			packet.Reset()
+		}
+
+		packet.WriteString(line)
+		packet.WriteByte('\n')
+	}
+
+	if packet.Len() > 0 {
+		if _, werr := s.conn.Write(packet.Bytes()); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	if err != nil && !s.failing {
+		el.Printf("Failed to push metrics to statsd at %s. Error: %s\n", s.address, err)
+	}
+	s.failing = err != nil
+}
+
+// Renders metrics as statsd lines, like go_audit.groups_flushed:3|c|#reason:eoe,role:audit for dogstatsd
+// A counter that went down, like those of outputs replaced by a reload, starts over
+func (s *StatsdClient) lines(families []metricFamily) []string {
+	var lines []string
+	for _, f := range families {
+		name := strings.TrimSuffix(f.name, "_total")
+		if s.prefix != "" {
+			name = s.prefix + "." + name
+		}
+
+		for _, sample := range f.samples {
+			line := statsdEscaper.Replace(name)
+			tags := s.tags
+			if sample.label != "" && s.dogstatsd {
+				tags = append([]string{statsdEscaper.Replace(sample.label) + ":" + statsdEscaper.Replace(sample.value)}, tags...)
+			} else if sample.label != "" {
+				line += "." + statsdEscaper.Replace(sample.value)
+			}
+
+			v, kind := sample.v, "g"
+			if f.kind == "counter" {
+				key := f.name + "|" + sample.value
+				if last := s.last[key]; v >= last {
+					v -= last
+				}
+				s.last[key] = sample.v
+				kind = "c"
+
+				// statsd takes a missing counter as 0
+				if v == 0 {
+					continue
+				}
+			}
+
+			line += ":" + strconv.FormatFloat(v, 'f', -1, 64) + "|" + kind
+			if len(tags) > 0 {
+				line += "|#" + strings.Join(tags, ",")
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
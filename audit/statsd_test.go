@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateStatsd(t *testing.T) {
+	c := viper.New()
+	s, err := CreateStatsd(c)
+	assert.Nil(t, err)
+	assert.Nil(t, s, "Disabled statsd should not be created")
+
+	c.Set("statsd.enabled", true)
+	c.Set("statsd.address", "nope")
+	_, err = CreateStatsd(c)
+	assert.EqualError(t, err, "statsd.address could not be parsed. Error: address nope: missing port in address")
+
+	c.Set("statsd.address", "127.0.0.1:8125")
+	_, err = CreateStatsd(c)
+	assert.EqualError(t, err, "statsd.interval must be greater than 0, 0s provided")
+
+	c.Set("statsd.interval", "10s")
+	c.Set("statsd.tags", map[string]interface{}{"role": "audit"})
+	_, err = CreateStatsd(c)
+	assert.EqualError(t, err, "statsd.tags need statsd.dogstatsd, plain statsd has no tags")
+
+	c.Set("statsd.dogstatsd", true)
+	s, err = CreateStatsd(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, s.interval)
+	if hostname != "" {
+		assert.Equal(t, []string{"host:" + statsdEscaper.Replace(hostname), "role:audit"}, s.tags)
+	}
+
+	c.Set("statsd.tags", map[string]interface{}{"role": "a|b", "host": ""})
+	s, err = CreateStatsd(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"role:a_b"}, s.tags, "An empty host should drop the tag")
+}
+
+func Test_statsdClient_lines(t *testing.T) {
+	families := func(received, eoe float64) []metricFamily {
+		return []metricFamily{
+			{name: "records_received_total", kind: "counter", samples: []metricSample{{v: received}}},
+			{name: "groups_flushed_total", kind: "counter", samples: []metricSample{{"reason", "eoe", eoe}, {"reason", "timeout", 0}}},
+			{name: "output_healthy", kind: "gauge", samples: []metricSample{{"output", "a:b", 1}}},
+		}
+	}
+
+	s := &StatsdClient{prefix: "go_audit", last: map[string]float64{}}
+	assert.Equal(t, []string{
+		"go_audit.records_received:5|c",
+		"go_audit.groups_flushed.eoe:2|c",
+		"go_audit.output_healthy.a_b:1|g",
+	}, s.lines(families(5, 2)))
+
+	// Only the increase is sent, counters that went down start over
+	assert.Equal(t, []string{
+		"go_audit.records_received:3|c",
+		"go_audit.output_healthy.a_b:1|g",
+	}, s.lines(families(8, 2)))
+	assert.Equal(t, []string{
+		"go_audit.records_received:1|c",
+		"go_audit.groups_flushed.eoe:4|c",
+		"go_audit.output_healthy.a_b:1|g",
+	}, s.lines(families(1, 6)))
+
+	s = &StatsdClient{dogstatsd: true, tags: []string{"role:audit"}, last: map[string]float64{}}
+	assert.Equal(t, []string{
+		"records_received:5|c|#role:audit",
+		"groups_flushed:2|c|#reason:eoe,role:audit",
+		"output_healthy:1|g|#output:a_b,role:audit",
+	}, s.lines(families(5, 2)))
+}
+
+func Test_statsdClient_push(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer ln.Close()
+
+	s := &StatsdClient{address: ln.LocalAddr().String(), prefix: "go_audit", last: map[string]float64{}}
+	assert.Nil(t, s.open())
+	defer s.Close()
+
+	// Enough lines to need a second datagram
+	var samples []metricSample
+	for i := 0; i < 100; i++ {
+		samples = append(samples, metricSample{"output", strings.Repeat("x", i+1), 1})
+	}
+	s.push([]metricFamily{{name: "output_healthy", kind: "gauge", samples: samples}})
+
+	var got []string
+	buf := make([]byte, 65536)
+	ln.SetReadDeadline(time.Now().Add(time.Second))
+	for len(got) < 100 {
+		n, _, err := ln.ReadFrom(buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.True(t, n <= statsdPacketSize, "Datagrams should fit the mtu")
+		got = append(got, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+
+	assert.Len(t, got, 100)
+	assert.Equal(t, "go_audit.output_healthy.x:1|g", got[0])
+	assert.Equal(t, "go_audit.output_healthy.xx:1|g", got[1])
+}
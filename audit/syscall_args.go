@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// open flags as x86 and most other archs define them, from the low bit up
+var openFlags = []struct {
+	bit  uint64
+	name string
+}{
+	{0100, "O_CREAT"}, {0200, "O_EXCL"}, {0400, "O_NOCTTY"}, {01000, "O_TRUNC"}, {02000, "O_APPEND"},
+	{04000, "O_NONBLOCK"}, {010000, "O_DSYNC"}, {020000, "O_ASYNC"}, {040000, "O_DIRECT"}, {0100000, "O_LARGEFILE"},
+	{0200000, "O_DIRECTORY"}, {0400000, "O_NOFOLLOW"}, {01000000, "O_NOATIME"}, {02000000, "O_CLOEXEC"},
+	{04000000, "O_SYNC"}, {010000000, "O_PATH"}, {020000000, "O_TMPFILE"},
+}
+
+// arm and aarch64 swap these around
+var armOpenFlags = map[uint64]string{
+	040000: "O_DIRECTORY", 0100000: "O_NOFOLLOW", 0200000: "O_DIRECT", 0400000: "O_LARGEFILE",
+}
+
+// Which register holds what for the syscalls worth decoding, a0 to a3
+var syscallArgs = map[string][4]string{
+	"open":      {"", "flags", "mode", ""},
+	"openat":    {"dirfd", "", "flags", "mode"},
+	"creat":     {"", "mode", "", ""},
+	"mkdir":     {"", "mode", "", ""},
+	"mkdirat":   {"dirfd", "", "mode", ""},
+	"chmod":     {"", "mode", "", ""},
+	"fchmod":    {"fd", "mode", "", ""},
+	"fchmodat":  {"dirfd", "", "mode", ""},
+	"connect":   {"fd", "", "", ""},
+	"bind":      {"fd", "", "", ""},
+	"accept":    {"fd", "", "", ""},
+	"accept4":   {"fd", "", "", ""},
+	"execveat":  {"dirfd", "", "", ""},
+	"setuid":    {"uid", "", "", ""},
+	"setgid":    {"gid", "", "", ""},
+	"setfsuid":  {"uid", "", "", ""},
+	"setfsgid":  {"gid", "", "", ""},
+	"setreuid":  {"ruid", "euid", "", ""},
+	"setregid":  {"rgid", "egid", "", ""},
+	"setresuid": {"ruid", "euid", "suid", ""},
+	"setresgid": {"rgid", "egid", "sgid", ""},
+	"kill":      {"pid", "signal", "", ""},
+	"tkill":     {"tid", "signal", "", ""},
+	"tgkill":    {"tgid", "tid", "signal", ""},
+}
+
+// Decodes the a0-a3 registers of a SYSCALL record for the syscalls in syscallArgs, nil for anything else
+// The registers are logged as hex, int arguments only use the lower 32 bits
+func decodeSyscallArgs(name, arch, data string) map[string]string {
+	roles, ok := syscallArgs[name]
+	if !ok {
+		return nil
+	}
+
+	args := map[string]string{}
+	for i, role := range roles {
+		if role == "" {
+			continue
+		}
+
+		v, err := strconv.ParseUint(findValue(data, "a"+strconv.Itoa(i)), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		switch role {
+		case "flags":
+			args[role] = formatOpenFlags(v, arch)
+		case "mode":
+			args[role] = fmt.Sprintf("%04o", v&07777)
+		case "dirfd", "fd":
+			if int32(v) == -100 {
+				args[role] = "AT_FDCWD"
+			} else {
+				args[role] = strconv.Itoa(int(int32(v)))
+			}
+		case "signal":
+			if sig, ok := signalNames[int(int32(v))]; ok {
+				args[role] = sig
+			} else {
+				args[role] = strconv.Itoa(int(int32(v)))
+			}
+		case "pid", "tid", "tgid":
+			args[role] = strconv.Itoa(int(int32(v)))
+		default:
+			// ids, -1 leaves the id unchanged
+			args[role] = strconv.FormatUint(uint64(uint32(v)), 10)
+		}
+	}
+
+	return args
+}
+
+// Formats open flags like O_WRONLY|O_CREAT|O_TRUNC, bits without a name are added in hex
+func formatOpenFlags(v uint64, arch string) string {
+	names := []string{[]string{"O_RDONLY", "O_WRONLY", "O_RDWR", "O_ACCMODE"}[v&3]}
+	left := v &^ 3
+
+	arm := arch == "40000028" || arch == "c00000b7"
+	for _, f := range openFlags {
+		if left&f.bit == 0 {
+			continue
+		}
+
+		name := f.name
+		if armName, ok := armOpenFlags[f.bit]; ok && arm {
+			name = armName
+		}
+
+		names = append(names, name)
+		left &^= f.bit
+	}
+
+	if left != 0 {
+		names = append(names, fmt.Sprintf("0x%x", left))
+	}
+
+	return strings.Join(names, "|")
+}
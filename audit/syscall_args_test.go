@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_decodeSyscallArgs(t *testing.T) {
+	assert.Equal(t,
+		map[string]string{"dirfd": "AT_FDCWD", "flags": "O_WRONLY|O_CREAT|O_TRUNC|O_CLOEXEC", "mode": "0644"},
+		decodeSyscallArgs("openat", "c000003e", "arch=c000003e syscall=257 success=yes exit=3 a0=ffffff9c a1=7ffd a2=80241 a3=1a4 items=2"),
+	)
+
+	assert.Equal(t,
+		map[string]string{"flags": "O_RDONLY", "mode": "0000"},
+		decodeSyscallArgs("open", "c000003e", "a0=7ffd a1=0 a2=0 a3=0"),
+	)
+
+	assert.Equal(t,
+		map[string]string{"pid": "-1", "signal": "SIGKILL"},
+		decodeSyscallArgs("kill", "c000003e", "a0=ffffffff a1=9 a2=0 a3=0"),
+	)
+
+	assert.Equal(t, map[string]string{"uid": "0"}, decodeSyscallArgs("setuid", "c000003e", "a0=0 a1=1 a2=2 a3=3"))
+	assert.Equal(t,
+		map[string]string{"ruid": "4294967295", "euid": "0", "suid": "4294967295"},
+		decodeSyscallArgs("setresuid", "c000003e", "a0=ffffffff a1=0 a2=ffffffff a3=0"),
+	)
+	assert.Equal(t, map[string]string{"fd": "3"}, decodeSyscallArgs("connect", "c000003e", "a0=3 a1=7ffd a2=10 a3=0"))
+
+	// Registers that are missing or garbage are left out
+	assert.Equal(t, map[string]string{"fd": "3"}, decodeSyscallArgs("fchmod", "c000003e", "a0=3 a1=nope"))
+
+	assert.Nil(t, decodeSyscallArgs("read", "c000003e", "a0=3 a1=7ffd a2=10 a3=0"))
+	assert.Nil(t, decodeSyscallArgs("", "", "a0=3"))
+}
+
+func Test_formatOpenFlags(t *testing.T) {
+	assert.Equal(t, "O_RDWR|O_CREAT|O_EXCL", formatOpenFlags(0302, "c000003e"))
+	assert.Equal(t, "O_RDONLY|O_NONBLOCK|O_DIRECTORY|O_CLOEXEC", formatOpenFlags(02204000, "c000003e"))
+	assert.Equal(t, "O_RDONLY|O_NONBLOCK|O_DIRECTORY|O_CLOEXEC", formatOpenFlags(02044000, "c00000b7"))
+	assert.Equal(t, "O_ACCMODE|0x40000000", formatOpenFlags(0x40000003, "c000003e"))
+}
+
+func TestAuditMessageGroup_findSyscall_args(t *testing.T) {
+	uidMap = map[string]string{"0": "root"}
+
+	amg := NewAuditMessageGroup(context.Background(), &AuditMessage{Type: 1300, Data: "arch=c000003e syscall=2 success=no exit=-13 a0=7ffd a1=441 a2=1b6 a3=0 uid=0"})
+	assert.Equal(t, map[string]string{"flags": "O_WRONLY|O_CREAT|O_APPEND", "mode": "0666"}, amg.SyscallArgs)
+}
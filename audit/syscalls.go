@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Syscall tables by the audit arch value of SYSCALL records, see AUDIT_ARCH_* in linux/audit.h
+var syscallTables = map[string]map[int]string{
+	"c000003e": syscallsX86_64,
+	"40000003": syscallsI386,
+	"c00000b7": syscallsAarch64,
+	"40000028": syscallsARM,
+}
+
+// Resolves a syscall number to its name for the arch, empty if either is unknown
+func syscallName(arch, id string) string {
+	table, ok := syscallTables[strings.ToLower(arch)]
+	if !ok {
+		return ""
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return ""
+	}
+
+	return table[n]
+}
+
+// Errors that only exist inside the kernel but still show up as the exit of a syscall
+var kernelErrnoNames = map[int]string{
+	512: "ERESTARTSYS", 513: "ERESTARTNOINTR", 514: "ERESTARTNOHAND", 515: "ENOIOCTLCMD", 516: "ERESTART_RESTARTBLOCK",
+	517: "EPROBE_DEFER", 518: "EOPENSTALE", 519: "ENOPARAM", 521: "EBADHANDLE", 524: "ENOTSUPP", 529: "EIOCBQUEUED",
+}
+
+// Names a negative syscall exit value, like EACCES for -13. Empty for anything else
+func errnoName(exit int64) string {
+	if exit >= 0 || exit < -4095 {
+		return ""
+	}
+
+	if name, ok := errnoNames[int(-exit)]; ok {
+		return name
+	}
+
+	return kernelErrnoNames[int(-exit)]
+}
+
+// Sets Success, Exit and ExitErrno from a SYSCALL record
+func (amg *AuditMessageGroup) findSyscallResult(data string) {
+	switch findValue(data, "success") {
+	case "yes":
+		success := true
+		amg.Success = &success
+	case "no":
+		success := false
+		amg.Success = &success
+	}
+
+	if exit, err := strconv.ParseInt(findValue(data, "exit"), 10, 64); err == nil {
+		amg.Exit = &exit
+		amg.ExitErrno = errnoName(exit)
+	}
+}
+
+// Finds the value of a key=value field without parsing all of data, meant for plain values like arch or exit
+func findValue(data, key string) string {
+	for i := 0; ; {
+		start := strings.Index(data[i:], key+"=")
+		if start < 0 {
+			return ""
+		}
+		start += i
+
+		// Must be the whole key, a search for exit must not find a_exit
+		if start > 0 && data[start-1] != spaceChar {
+			i = start + len(key)
+			continue
+		}
+
+		value := data[start+len(key)+1:]
+		if end := strings.IndexByte(value, spaceChar); end >= 0 {
+			value = value[:end]
+		}
+
+		return value
+	}
+}
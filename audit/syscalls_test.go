@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_syscallName(t *testing.T) {
+	assert.Equal(t, "execve", syscallName("c000003e", "59"))
+	assert.Equal(t, "execve", syscallName("C000003E", "59"))
+	assert.Equal(t, "execve", syscallName("40000003", "11"))
+	assert.Equal(t, "execve", syscallName("c00000b7", "221"))
+	assert.Equal(t, "execve", syscallName("40000028", "11"))
+	assert.Equal(t, "connect", syscallName("40000028", "283"))
+	assert.Equal(t, "openat", syscallName("c000003e", "257"))
+
+	// unknown archs and numbers
+	assert.Equal(t, "", syscallName("c0000015", "59"))
+	assert.Equal(t, "", syscallName("", "59"))
+	assert.Equal(t, "", syscallName("c000003e", "9999"))
+	assert.Equal(t, "", syscallName("c000003e", "nope"))
+}
+
+func Test_findValue(t *testing.T) {
+	assert.Equal(t, "c000003e", findValue("arch=c000003e syscall=59", "arch"))
+	assert.Equal(t, "c000003e", findValue("syscall=59 arch=c000003e", "arch"))
+	assert.Equal(t, "-13", findValue("a_exit=1 exit=-13 success=no", "exit"))
+	assert.Equal(t, "", findValue("a_exit=1", "exit"))
+	assert.Equal(t, "", findValue("syscall=59", "arch"))
+}
+
+func Test_errnoName(t *testing.T) {
+	assert.Equal(t, "EACCES", errnoName(-13))
+	assert.Equal(t, "ENOENT", errnoName(-2))
+	assert.Equal(t, "ERESTARTSYS", errnoName(-512))
+	assert.Equal(t, "", errnoName(-4000))
+	assert.Equal(t, "", errnoName(0))
+	assert.Equal(t, "", errnoName(3))
+	assert.Equal(t, "", errnoName(-140737488355328), "Addresses from mmap are not errors")
+}
+
+func TestAuditMessageGroup_findSyscallResult(t *testing.T) {
+	amg := &AuditMessageGroup{}
+	amg.findSyscallResult("arch=c000003e syscall=2 success=no exit=-13 a0=7ffd")
+	assert.Equal(t, false, *amg.Success)
+	assert.Equal(t, int64(-13), *amg.Exit)
+	assert.Equal(t, "EACCES", amg.ExitErrno)
+
+	amg = &AuditMessageGroup{}
+	amg.findSyscallResult("arch=c000003e syscall=59 success=yes exit=0")
+	assert.Equal(t, true, *amg.Success)
+	assert.Equal(t, int64(0), *amg.Exit)
+	assert.Equal(t, "", amg.ExitErrno)
+
+	amg = &AuditMessageGroup{}
+	amg.findSyscallResult("arch=c000003e syscall=59")
+	assert.Nil(t, amg.Success)
+	assert.Nil(t, amg.Exit)
+}
@@ -0,0 +1,236 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RFC5424 caps the header fields at these lengths
+const (
+	rfc5424MaxHostname = 255
+	rfc5424MaxAppName  = 48
+	rfc5424MaxMsgID    = 32
+	rfc5424MaxSDID     = 32
+)
+
+// rfc5424Writer writes events as RFC5424 syslog messages, the sequence, syscall and rule keys of the event are
+// added as a structured data element so relays can route on them without parsing the message
+// https://tools.ietf.org/html/rfc5424
+type rfc5424Writer struct {
+	network  string
+	address  string
+	priority int
+	hostname string
+	appName  string
+	procID   string
+	msgID    string
+	sdID     string
+	framed   bool // Octet counting framing for stream sockets, RFC6587
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// The parts of a json event that go into the header and structured data
+type rfc5424Event struct {
+	Seq         int      `json:"sequence"`
+	TimestampMs int64    `json:"timestamp_ms"`
+	Syscall     string   `json:"syscall"`
+	SyscallName string   `json:"syscall_name"`
+	Keys        []string `json:"keys"`
+}
+
+func createRFC5424Writer(config *viper.Viper) (*rfc5424Writer, error) {
+	// Structured data comes from the json form of the event
+	if f := outputFormat(config, "syslog"); f != "" && f != "json" {
+		return nil, fmt.Errorf("output.syslog.rfc5424 requires the json output format, `%s` is configured", f)
+	}
+
+	priority := config.GetInt("output.syslog.priority")
+	if priority < 0 || priority > 191 {
+		return nil, fmt.Errorf("output.syslog.priority must be between 0 and 191, %v provided", priority)
+	}
+
+	w := &rfc5424Writer{
+		network:  config.GetString("output.syslog.network"),
+		address:  config.GetString("output.syslog.address"),
+		priority: priority,
+		hostname: config.GetString("output.syslog.rfc5424.hostname"),
+		appName:  config.GetString("output.syslog.rfc5424.app_name"),
+		procID:   strconv.Itoa(os.Getpid()),
+		msgID:    config.GetString("output.syslog.rfc5424.msgid"),
+		sdID:     config.GetString("output.syslog.rfc5424.sd_id"),
+	}
+
+	// Like log/syslog, no network means the local syslog daemon
+	if w.network == "" {
+		w.network, w.address = "unixgram", "/dev/log"
+	}
+	w.framed = strings.HasPrefix(w.network, "tcp")
+
+	if w.hostname == "" {
+		// The NILVALUE if the hostname can't be used
+		w.hostname = "-"
+		if h, err := os.Hostname(); err == nil && rfc5424Name(h, rfc5424MaxHostname) {
+			w.hostname = h
+		}
+	} else if !rfc5424Name(w.hostname, rfc5424MaxHostname) {
+		return nil, fmt.Errorf("output.syslog.rfc5424.hostname must be up to %d printable ascii characters; Value: `%s`", rfc5424MaxHostname, w.hostname)
+	}
+
+	if w.appName == "" {
+		w.appName = config.GetString("output.syslog.tag")
+	}
+
+	if !rfc5424Name(w.appName, rfc5424MaxAppName) {
+		return nil, fmt.Errorf("output.syslog.rfc5424.app_name must be up to %d printable ascii characters; Value: `%s`", rfc5424MaxAppName, w.appName)
+	}
+
+	if !rfc5424Name(w.msgID, rfc5424MaxMsgID) {
+		return nil, fmt.Errorf("output.syslog.rfc5424.msgid must be up to %d printable ascii characters; Value: `%s`", rfc5424MaxMsgID, w.msgID)
+	}
+
+	if !rfc5424Name(w.sdID, rfc5424MaxSDID) || strings.ContainsAny(w.sdID, `="]`) {
+		return nil, fmt.Errorf("output.syslog.rfc5424.sd_id must be up to %d printable ascii characters without =, \" or ]; Value: `%s`", rfc5424MaxSDID, w.sdID)
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write sends the event as one message, reconnecting once if the connection broke like log/syslog does
+func (w *rfc5424Writer) Write(p []byte) (int, error) {
+	msg := w.format(p, time.Now())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn != nil {
+		if _, err := w.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+	}
+
+	if err := w.connect(); err != nil {
+		return 0, err
+	}
+
+	if _, err := w.conn.Write(msg); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *rfc5424Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// (Re)connects, must be called with mu held or before the writer is shared
+func (w *rfc5424Writer) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	conn, err := net.Dial(w.network, w.address)
+	if err != nil {
+		return fmt.Errorf("Failed to open syslog writer. Error: %v", err)
+	}
+
+	w.conn = conn
+	return nil
+}
+
+// Builds the message for an event, now is used as the timestamp if the event has none
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID sequence="" syscall="" key=""] MSG
+func (w *rfc5424Writer) format(p []byte, now time.Time) []byte {
+	event := bytes.TrimRight(p, "\n")
+
+	e := &rfc5424Event{}
+	sd := "-"
+	if err := json.Unmarshal(event, e); err == nil {
+		sd = w.structuredData(e)
+	}
+
+	ts := now
+	if e.TimestampMs > 0 {
+		ts = time.Unix(0, e.TimestampMs*int64(time.Millisecond))
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "<%d>1 %s %s %s %s %s %s ",
+		w.priority, ts.UTC().Format("2006-01-02T15:04:05.000Z07:00"), w.hostname, w.appName, w.procID, w.msgID, sd)
+	b.Write(event)
+
+	if !w.framed {
+		return b.Bytes()
+	}
+
+	return append([]byte(strconv.Itoa(b.Len())+" "), b.Bytes()...)
+}
+
+// One element with the sequence, the syscall and every rule key. Params the event does not have are left out
+func (w *rfc5424Writer) structuredData(e *rfc5424Event) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "[%s sequence=\"%d\"", w.sdID, e.Seq)
+
+	if e.Syscall != "" {
+		fmt.Fprintf(b, " syscall=\"%s\"", rfc5424Escape(e.Syscall))
+	}
+
+	if e.SyscallName != "" {
+		fmt.Fprintf(b, " syscall_name=\"%s\"", rfc5424Escape(e.SyscallName))
+	}
+
+	// A param name can repeat, relays see every key
+	for _, k := range e.Keys {
+		fmt.Fprintf(b, " key=\"%s\"", rfc5424Escape(k))
+	}
+
+	b.WriteByte(']')
+	return b.String()
+}
+
+var rfc5424Escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Escapes a PARAM-VALUE, only ", \ and ] have to be
+func rfc5424Escape(v string) string {
+	return rfc5424Escaper.Replace(v)
+}
+
+// Header fields are 1 to max printable ascii characters, no spaces as those separate the fields
+func rfc5424Name(v string, max int) bool {
+	if v == "" || len(v) > max {
+		return false
+	}
+
+	for i := 0; i < len(v); i++ {
+		if v[i] < 33 || v[i] > 126 {
+			return false
+		}
+	}
+
+	return true
+}
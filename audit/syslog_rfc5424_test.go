@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createRFC5424Writer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	newConfig := func() *viper.Viper {
+		c := viper.New()
+		c.Set("output.syslog.network", "tcp")
+		c.Set("output.syslog.address", l.Addr().String())
+		c.Set("output.syslog.priority", 132)
+		c.Set("output.syslog.tag", "go-audit")
+		c.Set("output.syslog.rfc5424.msgid", "audit")
+		c.Set("output.syslog.rfc5424.sd_id", "go-audit@32473")
+		return c
+	}
+
+	// format error
+	c := newConfig()
+	c.Set("output.syslog.format", "protobuf")
+	w, err := createRFC5424Writer(c)
+	assert.EqualError(t, err, "output.syslog.rfc5424 requires the json output format, `protobuf` is configured")
+	assert.Nil(t, w)
+
+	// priority error
+	c = newConfig()
+	c.Set("output.syslog.priority", 192)
+	w, err = createRFC5424Writer(c)
+	assert.EqualError(t, err, "output.syslog.priority must be between 0 and 191, 192 provided")
+	assert.Nil(t, w)
+
+	// hostname error
+	c = newConfig()
+	c.Set("output.syslog.rfc5424.hostname", "a host")
+	w, err = createRFC5424Writer(c)
+	assert.EqualError(t, err, "output.syslog.rfc5424.hostname must be up to 255 printable ascii characters; Value: `a host`")
+	assert.Nil(t, w)
+
+	// app_name error
+	c = newConfig()
+	c.Set("output.syslog.rfc5424.app_name", strings.Repeat("a", 49))
+	w, err = createRFC5424Writer(c)
+	assert.EqualError(t, err, "output.syslog.rfc5424.app_name must be up to 48 printable ascii characters; Value: `"+strings.Repeat("a", 49)+"`")
+	assert.Nil(t, w)
+
+	// msgid error
+	c = newConfig()
+	c.Set("output.syslog.rfc5424.msgid", "")
+	w, err = createRFC5424Writer(c)
+	assert.EqualError(t, err, "output.syslog.rfc5424.msgid must be up to 32 printable ascii characters; Value: ``")
+	assert.Nil(t, w)
+
+	// sd_id error
+	c = newConfig()
+	c.Set("output.syslog.rfc5424.sd_id", "go=audit")
+	w, err = createRFC5424Writer(c)
+	assert.EqualError(t, err, "output.syslog.rfc5424.sd_id must be up to 32 printable ascii characters without =, \" or ]; Value: `go=audit`")
+	assert.Nil(t, w)
+
+	// dial error
+	c = newConfig()
+	c.Set("output.syslog.network", "unixgram")
+	c.Set("output.syslog.address", "/do/not/exist/please")
+	w, err = createRFC5424Writer(c)
+	assert.EqualError(t, err, "Failed to open syslog writer. Error: dial unixgram /do/not/exist/please: connect: no such file or directory")
+	assert.Nil(t, w)
+
+	// All good, app_name falls back to the tag and the hostname to the machine's
+	hostname, _ := os.Hostname()
+	w, err = createRFC5424Writer(newConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, "go-audit", w.appName)
+	assert.Equal(t, hostname, w.hostname)
+	assert.True(t, w.framed)
+	assert.Nil(t, w.Close())
+
+	// The syslog output uses it once enabled
+	c = newConfig()
+	c.Set("output.syslog.rfc5424.enabled", true)
+	o, err := createSyslogOutput(c)
+	assert.Nil(t, err)
+	assert.IsType(t, &rfc5424Writer{}, o.(*WriterOutput).w)
+	assert.Nil(t, o.Close())
+}
+
+func Test_rfc5424Writer_format(t *testing.T) {
+	w := &rfc5424Writer{
+		priority: 132,
+		hostname: "host",
+		appName:  "go-audit",
+		procID:   "1233",
+		msgID:    "audit",
+		sdID:     "go-audit@32473",
+	}
+
+	event := `{"sequence":10,"timestamp":"1500000000.123","timestamp_ms":1500000000123,"syscall":"59","syscall_name":"execve","keys":["exec","a\"b]c\\"]}`
+	assert.Equal(
+		t,
+		`<132>1 2017-07-14T02:40:00.123Z host go-audit 1233 audit [go-audit@32473 sequence="10" syscall="59" syscall_name="execve" key="exec" key="a\"b\]c\\"] `+event,
+		string(w.format([]byte(event+"\n"), time.Now())),
+	)
+
+	// Params the event does not have are left out, the time of writing is used without a timestamp_ms
+	now := time.Unix(1600000000, 0)
+	assert.Equal(
+		t,
+		`<132>1 2020-09-13T12:26:40.000Z host go-audit 1233 audit [go-audit@32473 sequence="11"] {"sequence":11}`,
+		string(w.format([]byte(`{"sequence":11}`), now)),
+	)
+
+	// Not json, no structured data
+	assert.Equal(t, `<132>1 2020-09-13T12:26:40.000Z host go-audit 1233 audit - nope`, string(w.format([]byte("nope\n"), now)))
+
+	// Stream sockets get the length in front
+	w.framed = true
+	msg := `<132>1 2020-09-13T12:26:40.000Z host go-audit 1233 audit [go-audit@32473 sequence="11"] {"sequence":11}`
+	assert.Equal(t, fmt.Sprintf("%d %s", len(msg), msg), string(w.format([]byte(`{"sequence":11}`), now)))
+}
+
+func Test_rfc5424Writer_Write(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var n int
+					if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+						return
+					}
+
+					b := make([]byte, n)
+					if _, err := io.ReadFull(r, b); err != nil {
+						return
+					}
+					lines <- string(b)
+				}
+			}(conn)
+		}
+	}()
+
+	c := viper.New()
+	c.Set("output.syslog.network", "tcp")
+	c.Set("output.syslog.address", l.Addr().String())
+	c.Set("output.syslog.priority", 129)
+	c.Set("output.syslog.tag", "go-audit")
+	c.Set("output.syslog.rfc5424.hostname", "host")
+	c.Set("output.syslog.rfc5424.app_name", "auditor")
+	c.Set("output.syslog.rfc5424.msgid", "audit")
+	c.Set("output.syslog.rfc5424.sd_id", "go-audit@32473")
+	w, err := createRFC5424Writer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	event := []byte(`{"sequence":1,"timestamp_ms":1500000000123,"keys":["exec"]}` + "\n")
+	n, err := w.Write(event)
+	assert.Nil(t, err)
+	assert.Equal(t, len(event), n)
+
+	pid := os.Getpid()
+	assert.Equal(t, fmt.Sprintf(`<129>1 2017-07-14T02:40:00.123Z host auditor %d audit [go-audit@32473 sequence="1" key="exec"] {"sequence":1,"timestamp_ms":1500000000123,"keys":["exec"]}`, pid), <-lines)
+
+	// A broken connection is replaced
+	w.conn.Close()
+	_, err = w.Write([]byte(`{"sequence":2,"timestamp_ms":1500000000123}`))
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf(`<129>1 2017-07-14T02:40:00.123Z host auditor %d audit [go-audit@32473 sequence="2"] {"sequence":2,"timestamp_ms":1500000000123}`, pid), <-lines)
+}
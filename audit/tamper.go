@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SYSCALL fields copied into the actor of an AuditTamper
+var tamperActorFields = []string{"pid", "ppid", "uid", "auid", "euid", "ses", "tty", "comm", "exe", "subj"}
+
+// AuditTamper describes a change to the audit subsystem made by something other than go-audit
+type AuditTamper struct {
+	// One of rules_removed, audit_disabled, backlog_changed or audit_pid_changed
+	Change string `json:"change"`
+	Value  string `json:"value,omitempty"`
+	Old    string `json:"old,omitempty"`
+	Result string `json:"result,omitempty"`
+
+	// Who made the change, from the SYSCALL message if there was one
+	Actor map[string]string `json:"actor"`
+}
+
+// Looks for a CONFIG_CHANGE message that removes rules, disables auditing, changes the backlog or
+// hands the audit socket to another process. nil is returned for anything else
+func detectTamper(msg *AuditMessageGroup) *AuditTamper {
+	data, ok := msg.firstMessage(1305)
+	if !ok {
+		return nil
+	}
+
+	cf := parseFields(data)
+	t := &AuditTamper{Result: cf["res"]}
+
+	switch {
+	case strings.Replace(cf["op"], " ", "_", -1) == "remove_rule":
+		t.Change = "rules_removed"
+		t.Value = cf["key"]
+	case cf["audit_enabled"] == "0":
+		t.Change = "audit_disabled"
+		t.Value, t.Old = cf["audit_enabled"], cf["old"]
+	case cf["audit_backlog_limit"] != "":
+		t.Change = "backlog_changed"
+		t.Value, t.Old = cf["audit_backlog_limit"], cf["old"]
+	case cf["audit_backlog_wait_time"] != "":
+		t.Change = "backlog_changed"
+		t.Value, t.Old = cf["audit_backlog_wait_time"], cf["old"]
+	case cf["audit_pid"] != "":
+		t.Change = "audit_pid_changed"
+		t.Value, t.Old = cf["audit_pid"], cf["old"]
+	default:
+		return nil
+	}
+
+	// Older kernels don't attach a SYSCALL message, the CONFIG_CHANGE message has auid and ses at least
+	sf := cf
+	if data, ok := msg.firstMessage(1300); ok {
+		sf = parseFields(data)
+	}
+
+	t.Actor = map[string]string{}
+	for _, k := range tamperActorFields {
+		if v, ok := sf[k]; ok {
+			t.Actor[k] = v
+		} else if v, ok := cf[k]; ok {
+			t.Actor[k] = v
+		}
+	}
+
+	// Changes made by go-audit, like applying the rules on startup, are expected
+	self := strconv.Itoa(os.Getpid())
+	if t.Actor["pid"] == self || t.Actor["ppid"] == self {
+		return nil
+	}
+
+	// Handing the audit socket back to go-audit is not a problem either
+	if t.Change == "audit_pid_changed" && t.Value == self {
+		return nil
+	}
+
+	return t
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_detectTamper(t *testing.T) {
+	group := func(msgs ...*AuditMessage) *AuditMessageGroup {
+		return &AuditMessageGroup{Msgs: msgs}
+	}
+	syscall := &AuditMessage{Type: 1300, Data: `arch=c000003e syscall=44 success=yes ppid=1 pid=1234 auid=1000 uid=0 euid=0 tty=pts0 ses=3 comm="auditctl" exe="/sbin/auditctl" key=(null)`}
+	actor := map[string]string{"pid": "1234", "ppid": "1", "uid": "0", "auid": "1000", "euid": "0", "ses": "3", "tty": "pts0", "comm": "auditctl", "exe": "/sbin/auditctl"}
+
+	// not a CONFIG_CHANGE
+	assert.Nil(t, detectTamper(group(syscall)))
+
+	// rules removed
+	assert.Equal(
+		t,
+		&AuditTamper{Change: "rules_removed", Value: "(null)", Result: "1", Actor: actor},
+		detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `auid=1000 ses=3 op=remove_rule key=(null) list=4 res=1`})),
+	)
+
+	// older kernels without a SYSCALL
+	assert.Equal(
+		t,
+		&AuditTamper{Change: "rules_removed", Value: "(null)", Result: "1", Actor: map[string]string{"auid": "1000", "ses": "3"}},
+		detectTamper(group(&AuditMessage{Type: 1305, Data: `auid=1000 ses=3 op="remove rule" key=(null) list=4 res=1`})),
+	)
+
+	// audit disabled
+	assert.Equal(
+		t,
+		&AuditTamper{Change: "audit_disabled", Value: "0", Old: "1", Result: "1", Actor: actor},
+		detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `op=set audit_enabled=0 old=1 auid=1000 ses=3 res=1`})),
+	)
+
+	// enabling is fine
+	assert.Nil(t, detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `op=set audit_enabled=1 old=1 auid=1000 ses=3 res=1`})))
+
+	// backlog
+	assert.Equal(
+		t,
+		&AuditTamper{Change: "backlog_changed", Value: "1", Old: "8192", Result: "0", Actor: actor},
+		detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `op=set audit_backlog_limit=1 old=8192 auid=1000 ses=3 res=0`})),
+	)
+
+	// someone else took the audit socket
+	assert.Equal(
+		t,
+		&AuditTamper{Change: "audit_pid_changed", Value: "1234", Old: "99", Result: "1", Actor: actor},
+		detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `op=set audit_pid=1234 old=99 auid=1000 ses=3 res=1`})),
+	)
+
+	// unrelated changes
+	assert.Nil(t, detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `auid=1000 ses=3 op=add_rule key=(null) list=4 res=1`})))
+
+	// changes made by go-audit and its children are ignored
+	self := strconv.Itoa(os.Getpid())
+	own := &AuditMessage{Type: 1300, Data: `syscall=44 ppid=1 pid=` + self}
+	assert.Nil(t, detectTamper(group(own, &AuditMessage{Type: 1305, Data: `op=set audit_pid=` + self + ` old=0 res=1`})))
+	child := &AuditMessage{Type: 1300, Data: `syscall=44 ppid=` + self + ` pid=1`}
+	assert.Nil(t, detectTamper(group(child, &AuditMessage{Type: 1305, Data: `op=remove_rule key=(null) list=4 res=1`})))
+
+	// audit_pid handed back to go-audit by someone else
+	assert.Nil(t, detectTamper(group(syscall, &AuditMessage{Type: 1305, Data: `op=set audit_pid=` + self + ` old=1234 res=1`})))
+}
+
+func TestAuditMarshaller_tamper(t *testing.T) {
+	w := &bytes.Buffer{}
+	m := NewAuditMarshaller(NewAuditWriter(w, 1), uint16(1300), uint16(1399), false, false, 0, []AuditFilter{
+		{messageType: 1305, regex: regexp.MustCompile("."), syscall: "44"},
+	}, nil)
+	m.alerter = &Alerter{}
+
+	m.Consume(context.Background(), newNlMsg(1300, "audit(10000001:1): arch=c000003e syscall=44 ppid=1 pid=2 auid=1000 uid=0 exe=\"/sbin/auditctl\""))
+	m.Consume(context.Background(), newNlMsg(1305, "audit(10000001:1): op=set audit_enabled=0 old=1 auid=1000 ses=3 res=1"))
+	m.Consume(context.Background(), new1320("1"))
+
+	assert.Contains(t, w.String(), `"alert":{"rule":"audit_tamper","severity":"critical"},"audit_tamper":{"change":"audit_disabled","value":"0","old":"1","result":"1","actor":{"auid":"1000","exe":"/sbin/auditctl","pid":"2","ppid":"1","ses":"3","uid":"0"}}}`)
+}
@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Just enough of the thrift binary protocol to speak to osquery, see osquery.go
+// Values are read into plain go types: bool, int32, int64, string, []interface{} for lists,
+// map[string]interface{} for maps (keys are always strings in osquery) and map[int16]interface{} for structs
+
+// Thrift binary protocol types
+const (
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftMap    = 13
+	thriftList   = 15
+
+	thriftCall      = 1
+	thriftReply     = 2
+	thriftException = 3
+
+	thriftVersion1 = 0x80010000
+)
+
+// Nothing in osquery comes close to this, it stops a bad length from allocating everything
+const thriftMaxLength = 64 * 1024 * 1024
+
+// thriftConn reads and writes thrift messages, errors are sticky and returned by flush and readMessageBegin
+type thriftConn struct {
+	r   *bufio.Reader
+	w   *bufio.Writer
+	err error
+}
+
+func newThriftConn(rw io.ReadWriter) *thriftConn {
+	return &thriftConn{r: bufio.NewReader(rw), w: bufio.NewWriter(rw)}
+}
+
+func (t *thriftConn) write(v interface{}) {
+	if t.err == nil {
+		t.err = binary.Write(t.w, binary.BigEndian, v)
+	}
+}
+
+func (t *thriftConn) writeMessageBegin(name string, typ int32, seq int32) {
+	t.write(uint32(thriftVersion1) | uint32(typ))
+	t.writeString(name)
+	t.write(seq)
+}
+
+func (t *thriftConn) writeFieldBegin(typ byte, id int16) {
+	t.write(typ)
+	t.write(id)
+}
+
+func (t *thriftConn) writeFieldStop() {
+	t.write(byte(thriftStop))
+}
+
+func (t *thriftConn) writeString(v string) {
+	t.write(int32(len(v)))
+	if t.err == nil {
+		_, t.err = t.w.WriteString(v)
+	}
+}
+
+func (t *thriftConn) writeMapBegin(keyType, valueType byte, n int) {
+	t.write(keyType)
+	t.write(valueType)
+	t.write(int32(n))
+}
+
+func (t *thriftConn) writeListBegin(elemType byte, n int) {
+	t.write(elemType)
+	t.write(int32(n))
+}
+
+// Writes a map<string, string>
+func (t *thriftConn) writeStringMap(m map[string]string) {
+	t.writeMapBegin(thriftString, thriftString, len(m))
+	for k, v := range m {
+		t.writeString(k)
+		t.writeString(v)
+	}
+}
+
+// Writes a list<map<string, string>>, the ExtensionPluginResponse of osquery
+func (t *thriftConn) writeRows(rows []map[string]string) {
+	t.writeListBegin(thriftMap, len(rows))
+	for _, row := range rows {
+		t.writeStringMap(row)
+	}
+}
+
+func (t *thriftConn) flush() error {
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+
+	return t.err
+}
+
+func (t *thriftConn) read(v interface{}) {
+	if t.err == nil {
+		t.err = binary.Read(t.r, binary.BigEndian, v)
+	}
+}
+
+// Reads a message header, only strict (versioned) messages are supported
+func (t *thriftConn) readMessageBegin() (name string, typ int32, seq int32, err error) {
+	var version uint32
+	t.read(&version)
+	if t.err == nil && version&0xffff0000 != thriftVersion1 {
+		t.err = fmt.Errorf("Unsupported thrift message version %x", version)
+	}
+
+	name = t.readString()
+	t.read(&seq)
+	return name, int32(version & 0xff), seq, t.err
+}
+
+func (t *thriftConn) readString() string {
+	var n int32
+	t.read(&n)
+	if t.err != nil {
+		return ""
+	}
+
+	if n < 0 || n > thriftMaxLength {
+		t.err = fmt.Errorf("Thrift string length %d is out of range", n)
+		return ""
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(t.r, b); err != nil {
+		t.err = err
+	}
+
+	return string(b)
+}
+
+func (t *thriftConn) readLength() int {
+	var n int32
+	t.read(&n)
+	if t.err == nil && (n < 0 || n > thriftMaxLength) {
+		t.err = fmt.Errorf("Thrift container length %d is out of range", n)
+	}
+
+	if t.err != nil {
+		return 0
+	}
+
+	return int(n)
+}
+
+// Reads a struct into a map of field id to value
+func (t *thriftConn) readStruct() map[int16]interface{} {
+	s := map[int16]interface{}{}
+	for t.err == nil {
+		var typ byte
+		t.read(&typ)
+		if typ == thriftStop {
+			break
+		}
+
+		var id int16
+		t.read(&id)
+		s[id] = t.readValue(typ)
+	}
+
+	return s
+}
+
+func (t *thriftConn) readValue(typ byte) interface{} {
+	switch typ {
+	case thriftBool:
+		var v byte
+		t.read(&v)
+		return v != 0
+	case thriftI32:
+		var v int32
+		t.read(&v)
+		return v
+	case thriftI64:
+		var v int64
+		t.read(&v)
+		return v
+	case thriftString:
+		return t.readString()
+	case thriftStruct:
+		return t.readStruct()
+	case thriftMap:
+		var keyType, valueType byte
+		t.read(&keyType)
+		t.read(&valueType)
+		n := t.readLength()
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n && t.err == nil; i++ {
+			m[fmt.Sprint(t.readValue(keyType))] = t.readValue(valueType)
+		}
+		return m
+	case thriftList:
+		var elemType byte
+		t.read(&elemType)
+		n := t.readLength()
+		l := make([]interface{}, 0, n)
+		for i := 0; i < n && t.err == nil; i++ {
+			l = append(l, t.readValue(elemType))
+		}
+		return l
+	}
+
+	if t.err == nil {
+		t.err = fmt.Errorf("Unsupported thrift type %d", typ)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThriftConn(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tc := newThriftConn(buf)
+
+	tc.writeMessageBegin("call", thriftCall, 5)
+	tc.writeFieldBegin(thriftString, 1)
+	tc.writeString("table")
+	tc.writeFieldBegin(thriftI32, 2)
+	tc.write(int32(-3))
+	tc.writeFieldBegin(thriftI64, 3)
+	tc.write(int64(1) << 40)
+	tc.writeFieldBegin(thriftMap, 4)
+	tc.writeStringMap(map[string]string{"action": "generate"})
+	tc.writeFieldBegin(thriftList, 5)
+	tc.writeRows([]map[string]string{{"a": "1"}, {"b": "2"}})
+	tc.writeFieldBegin(thriftBool, 6)
+	tc.write(byte(1))
+	tc.writeFieldStop()
+	assert.Nil(t, tc.flush())
+
+	assert.Equal(t, []byte{0x80, 0x01, 0x00, 0x01, 0, 0, 0, 4, 'c', 'a', 'l', 'l', 0, 0, 0, 5, thriftString, 0, 1}, buf.Bytes()[:19])
+
+	tc = newThriftConn(bytes.NewBuffer(buf.Bytes()))
+	name, typ, seq, err := tc.readMessageBegin()
+	assert.Nil(t, err)
+	assert.Equal(t, "call", name)
+	assert.Equal(t, int32(thriftCall), typ)
+	assert.Equal(t, int32(5), seq)
+
+	assert.Equal(t, map[int16]interface{}{
+		1: "table",
+		2: int32(-3),
+		3: int64(1) << 40,
+		4: map[string]interface{}{"action": "generate"},
+		5: []interface{}{map[string]interface{}{"a": "1"}, map[string]interface{}{"b": "2"}},
+		6: true,
+	}, tc.readStruct())
+	assert.Nil(t, tc.err)
+}
+
+func TestThriftConn_errors(t *testing.T) {
+	// Unversioned messages
+	tc := newThriftConn(bytes.NewBuffer([]byte{0, 0, 0, 4, 'c', 'a', 'l', 'l', 1, 0, 0, 0, 1}))
+	_, _, _, err := tc.readMessageBegin()
+	assert.EqualError(t, err, "Unsupported thrift message version 4")
+
+	// Lengths that are out of range
+	tc = newThriftConn(bytes.NewBuffer([]byte{thriftString, 0, 1, 0xff, 0xff, 0xff, 0xff}))
+	tc.readStruct()
+	assert.EqualError(t, tc.err, "Thrift string length -1 is out of range")
+
+	tc = newThriftConn(bytes.NewBuffer([]byte{thriftList, 0, 1, thriftString, 0x7f, 0xff, 0xff, 0xff}))
+	tc.readStruct()
+	assert.EqualError(t, tc.err, "Thrift container length 2147483647 is out of range")
+
+	tc = newThriftConn(bytes.NewBuffer([]byte{4, 0, 1}))
+	tc.readStruct()
+	assert.EqualError(t, tc.err, "Unsupported thrift type 4")
+
+	// Errors stick
+	tc = newThriftConn(bytes.NewBuffer([]byte{thriftString, 0}))
+	assert.Equal(t, map[int16]interface{}{0: ""}, tc.readStruct())
+	assert.EqualError(t, tc.err, "unexpected EOF")
+}
@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+)
+
+// Builds a client tls config from `<prefix>.ca_file`, `<prefix>.cert_file`, `<prefix>.key_file` and `<prefix>.insecure_skip_verify`
+// Without a ca_file the system roots are used, the cert and key are for servers that want a client certificate
+func createTLSConfig(config *viper.Viper, prefix string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.GetBool(prefix + ".insecure_skip_verify")}
+
+	if caFile := config.GetString(prefix + ".ca_file"); caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s.ca_file. Error: %s", prefix, err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New(prefix + ".ca_file did not contain any certificates")
+		}
+	}
+
+	certFile, keyFile := config.GetString(prefix+".cert_file"), config.GetString(prefix+".key_file")
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("%s.cert_file and %s.key_file must be set together", prefix, prefix)
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load %s.cert_file. Error: %s", prefix, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+)
+
+// Names of the userspace record types, see linux/audit.h
+var userRecordTypes = map[uint16]string{
+	1100: "USER_AUTH", 1101: "USER_ACCT", 1102: "USER_MGMT", 1103: "CRED_ACQ", 1104: "CRED_DISP",
+	1105: "USER_START", 1106: "USER_END", 1107: "USER_AVC", 1108: "USER_CHAUTHTOK", 1109: "USER_ERR",
+	1110: "CRED_REFR", 1111: "USYS_CONFIG", 1112: "USER_LOGIN", 1113: "USER_LOGOUT", 1114: "ADD_USER",
+	1115: "DEL_USER", 1116: "ADD_GROUP", 1117: "DEL_GROUP", 1118: "DAC_CHECK", 1119: "CHGRP_ID",
+	1120: "TEST", 1121: "TRUSTED_APP", 1122: "USER_SELINUX_ERR", 1123: "USER_CMD", 1124: "USER_TTY",
+	1125: "CHUSER_ID", 1126: "GRP_AUTH", 1127: "SYSTEM_BOOT", 1128: "SYSTEM_SHUTDOWN", 1129: "SYSTEM_RUNLEVEL",
+	1130: "SERVICE_START", 1131: "SERVICE_STOP", 1132: "GRP_MGMT", 1133: "GRP_CHAUTHTOK", 1134: "MAC_CHECK",
+	1135: "ACCT_LOCK", 1136: "ACCT_UNLOCK", 1137: "USER_DEVICE", 1138: "SOFTWARE_UPDATE",
+}
+
+// UserEvent is a userspace record, like a PAM login or a sudo command, with the nested msg='...' fields unwrapped
+type UserEvent struct {
+	Type     string `json:"type"` // Like USER_LOGIN, the number for types without a name
+	Op       string `json:"op,omitempty"`
+	Acct     string `json:"acct,omitempty"`     // The account acted on, hex encoded names are decoded
+	ID       string `json:"id,omitempty"`       // Some records name the account by uid instead
+	IDName   string `json:"id_name,omitempty"`  // id or a numeric acct as a username
+	Cmd      string `json:"cmd,omitempty"`      // The command of USER_CMD, decoded
+	Exe      string `json:"exe,omitempty"`      // The program that logged the record
+	Hostname string `json:"hostname,omitempty"` // Remote host, ? when there is none
+	Addr     string `json:"addr,omitempty"`
+	Terminal string `json:"terminal,omitempty"`
+	Result   string `json:"result,omitempty"` // success or failed
+}
+
+// Parses a userspace record in the 1100 range
+func parseUserEvent(ctx context.Context, am *AuditMessage) *UserEvent {
+	fields := parseFields(am.Data)
+
+	u := &UserEvent{
+		Type:     userRecordTypes[am.Type],
+		Op:       fields["op"],
+		Acct:     fields["acct"],
+		ID:       fields["id"],
+		Cmd:      fields["cmd"],
+		Exe:      fields["exe"],
+		Hostname: fields["hostname"],
+		Addr:     fields["addr"],
+		Terminal: fields["terminal"],
+		Result:   fields["res"],
+	}
+
+	if u.Type == "" {
+		u.Type = strconv.Itoa(int(am.Type))
+	}
+
+	id := u.ID
+	if id == "" {
+		id = u.Acct
+	}
+
+	if _, err := strconv.ParseUint(id, 10, 32); err == nil {
+		u.IDName = getUsername(ctx, id)
+	}
+
+	return u
+}
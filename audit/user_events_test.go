@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseUserEvent(t *testing.T) {
+	uidMap = map[string]string{"0": "root", "1000": "alice"}
+	uidMisses = make(map[string]time.Time, 0)
+
+	assert.Equal(t, &UserEvent{
+		Type:     "USER_CMD",
+		Cmd:      "/usr/bin/systemctl restart nginx",
+		Exe:      "/usr/bin/sudo",
+		Terminal: "pts/0",
+		Result:   "success",
+	}, parseUserEvent(context.Background(), &AuditMessage{
+		Type: 1123,
+		Data: `pid=1 uid=1000 auid=1000 ses=1 msg='cwd="/home/alice" cmd=2F7573722F62696E2F73797374656D63746C2072657374617274206E67696E78 exe="/usr/bin/sudo" terminal=pts/0 res=success'`,
+	}))
+
+	assert.Equal(t, &UserEvent{
+		Type:     "USER_AUTH",
+		Op:       "PAM:authentication",
+		Acct:     "bob smith",
+		Exe:      "/usr/sbin/sshd",
+		Hostname: "10.0.0.1",
+		Addr:     "10.0.0.1",
+		Terminal: "ssh",
+		Result:   "failed",
+	}, parseUserEvent(context.Background(), &AuditMessage{
+		Type: 1100,
+		Data: `pid=1 uid=0 auid=4294967295 ses=4294967295 msg='op=PAM:authentication grantors=? acct=626F6220736D697468 exe="/usr/sbin/sshd" hostname=10.0.0.1 addr=10.0.0.1 terminal=ssh res=failed'`,
+	}))
+
+	assert.Equal(t, &UserEvent{
+		Type:     "USER_LOGIN",
+		Op:       "login",
+		ID:       "1000",
+		IDName:   "alice",
+		Exe:      "/usr/sbin/sshd",
+		Hostname: "?",
+		Addr:     "10.0.0.1",
+		Terminal: "/dev/pts/0",
+		Result:   "success",
+	}, parseUserEvent(context.Background(), &AuditMessage{
+		Type: 1112,
+		Data: `pid=1 uid=0 auid=1000 ses=3 msg='op=login id=1000 exe="/usr/sbin/sshd" hostname=? addr=10.0.0.1 terminal=/dev/pts/0 res=success'`,
+	}))
+
+	assert.Equal(t, &UserEvent{Type: "1199", Acct: "0", IDName: "root"}, parseUserEvent(context.Background(), &AuditMessage{Type: 1199, Data: `msg='acct="0"'`}))
+}
+
+func TestAuditMessageGroup_AddMessage_userEvent(t *testing.T) {
+	uidMap = map[string]string{"0": "root"}
+
+	amg := &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1105, Data: `pid=1 uid=0 msg='op=PAM:session_open acct="root" res=success'`})
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1106, Data: `pid=1 uid=0 msg='op=PAM:session_close acct="root" res=success'`})
+	assert.Equal(t, "USER_START", amg.UserEvent.Type, "The first record should win")
+	assert.Equal(t, map[string]string{"0": "root"}, amg.UidMap)
+
+	amg = &AuditMessageGroup{UidMap: make(map[string]string, 2)}
+	amg.AddMessage(context.Background(), &AuditMessage{Type: 1305, Data: `op=add_rule key="x" uid=0 res=1`})
+	assert.Nil(t, amg.UserEvent)
+}
@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// AuditWriter takes complete message groups to wherever they should end up
+// An OutputWriter writes to a single Output, a multiWriter fans out to several
+type AuditWriter interface {
+	// Write is called with every message group that made it through the pipeline
+	Write(ctx context.Context, msg *AuditMessageGroup) error
+
+	// Flush pushes out anything buffered along the way
+	Flush() error
+
+	// Close flushes and releases whatever the writer holds
+	Close() error
+
+	// Healthy reports if the writer believes it can currently accept writes
+	Healthy() bool
+}
+
+// OutputWriter marshals message groups and writes them to an Output, retrying failed writes
+type OutputWriter struct {
+	m        Marshaler
+	w        Output
+	attempts int
+
+	// Optional, events that exhaust their attempts are kept here instead of failing the write
+	deadLetter *deadLetter
+
+	// Optional, events are kept here while the output is failing and sent once it recovers
+	spool *outputSpool
+
+	// Optional, events matching any of these are not written to this output, see output.<name>.filters
+	// With keepOnly only the events matching one of them are written
+	filters  []AuditFilter
+	keepOnly bool
+
+	// The output name for metrics, and the events it took and the write attempts that failed
+	// Counted atomically, the spool writes from its own goroutine
+	name    string
+	written uint64
+	failed  uint64
+}
+
+// NewAuditWriter creates a writer using the default json format, plain io.Writers are wrapped in a WriterOutput
+func NewAuditWriter(w io.Writer, attempts int) *OutputWriter {
+	o, ok := w.(Output)
+	if !ok {
+		o = NewWriterOutput(w)
+	}
+
+	return &OutputWriter{
+		m:        &JSONMarshaler{},
+		w:        o,
+		attempts: attempts,
+	}
+}
+
+// Write marshals and writes the message group, giving up on retries if the context is done
+// If every attempt fails and there is a dead letter file the event is stored there instead
+// Tampering and go-audit's own events skip the output's filters, like they skip the global ones
+func (a *OutputWriter) Write(ctx context.Context, msg *AuditMessageGroup) (err error) {
+	if len(a.filters) > 0 && msg.Tamper == nil && msg.Source != "go-audit" && a.matchesFilter(msg) != a.keepOnly {
+		return nil
+	}
+
+	b, err := a.m.Marshal(msg)
+	if err != nil {
+		// Retrying won't help if the message can't be marshaled
+		return err
+	}
+
+	if a.spool != nil {
+		return a.spool.write(b, a.writeOnce)
+	}
+
+	if err = a.writeRaw(ctx, b); err == nil || a.deadLetter == nil {
+		return err
+	}
+
+	if dlErr := a.deadLetter.add(b, err); dlErr != nil {
+		return fmt.Errorf("%s, the dead letter file could not be written either. Error: %s", err, dlErr)
+	}
+
+	el.Printf("Wrote sequence %d to the dead letter file. Error: %s\n", msg.Seq, err)
+	return nil
+}
+
+// Reports if any of the output's filters matches the group
+func (a *OutputWriter) matchesFilter(msg *AuditMessageGroup) bool {
+	for _, f := range a.filters {
+		if f.matches(msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes already marshaled data, retrying up to attempts times
+func (a *OutputWriter) writeRaw(ctx context.Context, b []byte) (err error) {
+	for i := 0; i < a.attempts; i++ {
+		_, err = a.w.Write(b)
+		if err == nil {
+			atomic.AddUint64(&a.written, 1)
+			break
+		}
+		atomic.AddUint64(&a.failed, 1)
+
+		if i != a.attempts-1 {
+			el.Println("Failed to write message, retrying in 1 second. Error:", err)
+			select {
+			case <-time.After(time.Second * 1):
+			case <-ctx.Done():
+				return err
+			}
+		}
+	}
+
+	return err
+}
+
+// Writes already marshaled data without retrying, for the spool
+func (a *OutputWriter) writeOnce(b []byte) error {
+	if _, err := a.w.Write(b); err != nil {
+		atomic.AddUint64(&a.failed, 1)
+		return err
+	}
+
+	atomic.AddUint64(&a.written, 1)
+	return nil
+}
+
+// Flush flushes any buffered data in the output
+func (a *OutputWriter) Flush() error {
+	return a.w.Flush()
+}
+
+// Close flushes and closes the output, spooled events are left for the next run
+func (a *OutputWriter) Close() error {
+	if a.spool != nil {
+		if err := a.spool.close(); err != nil {
+			el.Printf("Error closing the spool for output %s: %+v\n", a.spool.name, err)
+		}
+	}
+
+	return a.w.Close()
+}
+
+// Stops the spool and sends what it holds until a write fails or deadline passes, the rest is left for the next run
+func (a *OutputWriter) drainSpool(deadline time.Time) {
+	if a.spool == nil {
+		return
+	}
+
+	if err := a.spool.close(); err != nil {
+		el.Printf("Error closing the spool for output %s: %+v\n", a.spool.name, err)
+	}
+
+	if a.spool.Stats().Events == 0 {
+		return
+	}
+
+	sent, err := a.spool.drain(func(b []byte) error {
+		if time.Now().After(deadline) {
+			return errors.New("Ran out of time")
+		}
+		return a.writeOnce(b)
+	})
+
+	if sent > 0 {
+		if ferr := a.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+
+	if err != nil {
+		el.Printf("Sent %d spooled events to output %s before exiting, %d are left for the next run. Error: %s\n", sent, a.spool.name, a.spool.Stats().Events, err)
+	} else if sent > 0 {
+		l.Printf("Sent %d spooled events to output %s before exiting\n", sent, a.spool.name)
+	}
+}
+
+// Healthy reports the health of the output
+func (a *OutputWriter) Healthy() bool {
+	return a.w.Healthy()
+}
+
+// multiWriter writes every message group to each of its writers, see createOutput
+type multiWriter struct {
+	names   []string
+	writers []*OutputWriter
+}
+
+// Write hands the group to every writer in turn, a failing writer does not keep the group from the rest
+func (m *multiWriter) Write(ctx context.Context, msg *AuditMessageGroup) error {
+	var err error
+	for i, w := range m.writers {
+		if werr := w.Write(ctx, msg); werr != nil && err == nil {
+			err = fmt.Errorf("Failed to write to output %s. Error: %s", m.names[i], werr)
+		}
+	}
+
+	return err
+}
+
+func (m *multiWriter) Flush() error {
+	var err error
+	for i, w := range m.writers {
+		if ferr := w.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("Failed to flush output %s. Error: %s", m.names[i], ferr)
+		}
+	}
+
+	return err
+}
+
+func (m *multiWriter) Close() error {
+	var err error
+	for i, w := range m.writers {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Failed to close output %s. Error: %s", m.names[i], cerr)
+		}
+	}
+
+	return err
+}
+
+// Healthy is only true while every output is
+func (m *multiWriter) Healthy() bool {
+	for _, w := range m.writers {
+		if !w.Healthy() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Closes w like Close does, after giving every spool until deadline to send what it holds. Used on shutdown
+func shutdownWriter(w AuditWriter, deadline time.Time) error {
+	for _, o := range outputWriters(w) {
+		o.drainSpool(deadline)
+	}
+
+	return w.Close()
+}
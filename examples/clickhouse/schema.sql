@@ -0,0 +1,22 @@
+-- Table for the go-audit clickhouse output, see output.clickhouse in go-audit.yaml.example
+-- Event fields without a column are skipped, add or drop columns as needed
+CREATE DATABASE IF NOT EXISTS go_audit;
+
+CREATE TABLE IF NOT EXISTS go_audit.events
+(
+    sequence       UInt64,
+    timestamp      String,
+    timestamp_ms   Int64,
+    time           DateTime64(3) MATERIALIZED fromUnixTimestamp64Milli(timestamp_ms),
+    syscall        LowCardinality(String),
+    messages       Array(Tuple(type UInt16, data String, encoding String)),
+    uid_map        Map(String, String),
+    extra          String, -- Enricher output as json
+    alert          String, -- Set when an alert rule matched, as json
+    audit_tamper   String, -- Set when the audit subsystem was changed, as json
+    parse_error    UInt8
+)
+ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (time, sequence)
+TTL toDate(time) + INTERVAL 90 DAY;
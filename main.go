@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/slackhq/go-audit/audit"
+)
+
+func main() {
+	audit.Main()
+}